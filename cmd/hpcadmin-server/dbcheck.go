@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// dbCheckTimeout is how long -test-db waits for the database to answer
+const dbCheckTimeout = 5 * time.Second
+
+// checkDB connects to the database of the request and pings it, failing
+// if that takes longer than timeout. It's tried once, without the retries
+// the server makes at startup, so a wrong host or password fails fast. The
+// error says which database couldn't be reached.
+func checkDB(dbr data.DBRequest, timeout time.Duration) error {
+	slog.Debug("checking database connection", "host", dbr.Host, "port", dbr.Port, "dbname", dbr.DBName, "package", "main", "method", "checkDB")
+	dbr.ConnectAttempts = 1
+	errs := make(chan error, 1)
+	go func() {
+		dbConn, err := data.NewDBConn(dbr)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer dbConn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		errs <- dbConn.PingContext(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errs:
+	case <-time.After(timeout):
+		err = fmt.Errorf("no answer after %v", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reach database %s on %s as %s: %v", dbr.DBName, dbAddr(dbr), dbr.User, err)
+	}
+	return nil
+}
+
+// dbAddr is the host and port of the request, for messages
+func dbAddr(dbr data.DBRequest) string {
+	if dbr.Port == 0 {
+		return dbr.Host
+	}
+	return net.JoinHostPort(dbr.Host, strconv.Itoa(dbr.Port))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestCheckDBReachable(t *testing.T) {
+	host, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_HOST")
+	if !found {
+		t.Skip("HPCADMIN_TEST_DATABASE_HOST not set")
+	}
+	port, err := strconv.Atoi(os.Getenv("HPCADMIN_TEST_DATABASE_PORT"))
+	if err != nil {
+		t.Fatal("HPCADMIN_TEST_DATABASE_PORT not an integer")
+	}
+	dbr := data.DBRequest{
+		Host:       host,
+		Port:       port,
+		User:       os.Getenv("HPCADMIN_TEST_DATABASE_USERNAME"),
+		Password:   os.Getenv("HPCADMIN_TEST_DATABASE_PASSWORD"),
+		DBName:     os.Getenv("HPCADMIN_TEST_DATABASE_NAME"),
+		DisableSSL: true,
+	}
+	if err := checkDB(dbr, dbCheckTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckDBUnreachable(t *testing.T) {
+	// a port nothing listens on refuses the connection
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	// and one that accepts connections but never answers times out
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		port    int
+		message string
+	}{
+		{"Refused", refusedPort, "refused"},
+		{"Silent", silent.Addr().(*net.TCPAddr).Port, "no answer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbr := data.DBRequest{Host: "127.0.0.1", Port: tt.port, User: "hpcadmin", DBName: "hpcadmin", DisableSSL: true}
+			start := time.Now()
+			err := checkDB(dbr, 200*time.Millisecond)
+			if err == nil {
+				t.Fatal("expected an error checking an unreachable database")
+			}
+			if !strings.Contains(err.Error(), tt.message) || !strings.Contains(err.Error(), dbAddr(dbr)) {
+				t.Errorf("expected an error about %s and the address got %v", tt.message, err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("expected the check to fail fast, took %v", elapsed)
+			}
+		})
+	}
+}
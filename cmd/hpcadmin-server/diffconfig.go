@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// Exit statuses of -diff-config, the same as diff(1)
+const (
+	diffConfigSame    = 0
+	diffConfigDiffers = 1
+	diffConfigFailed  = 2
+)
+
+// runDiffConfig loads the two config files in args, and writes each field
+// that differs between them to w as "field: a -> b", secrets redacted. Both
+// are loaded on top of the defaults, without the environment overrides, so
+// only the files themselves are compared. It returns the exit status.
+func runDiffConfig(w io.Writer, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(w, "Usage: hpcadmin-server -diff-config a.yaml b.yaml")
+		return diffConfigFailed
+	}
+	var cfgs [2]*config.ServerConfig
+	for i, path := range args {
+		// LoadFile falls back to the defaults without a file, which isn't a comparison
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(w, "Error loading configuration from %s: %v\n", path, err)
+			return diffConfigFailed
+		}
+		cfg, err := config.LoadFile(path)
+		if err != nil {
+			fmt.Fprintf(w, "Error loading configuration from %s: %v\n", path, err)
+			return diffConfigFailed
+		}
+		cfgs[i] = cfg
+	}
+	diffs, err := config.Diff(cfgs[0], cfgs[1])
+	if err != nil {
+		fmt.Fprintf(w, "Error comparing configurations: %v\n", err)
+		return diffConfigFailed
+	}
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s: %s -> %s\n", d.Field, d.A, d.B)
+	}
+	if len(diffs) > 0 {
+		return diffConfigDiffers
+	}
+	return diffConfigSame
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDiffConfig(t *testing.T) {
+	base, err := os.ReadFile("../../test/data/testconfig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name string, extra string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, append(base, []byte("\n"+extra)...), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	staging := write("staging.yaml", "log_level: debug\n")
+	prod := write("prod.yaml", "log_level: warn\nwebhooks:\n  secret: prodsecret\n")
+
+	var out strings.Builder
+	if status := runDiffConfig(&out, []string{staging, prod}); status != diffConfigDiffers {
+		t.Errorf("expected status %v got %v", diffConfigDiffers, status)
+	}
+	want := "log_level: \"debug\" -> \"warn\"\nwebhooks.secret: \"\" -> <redacted>\n"
+	if out.String() != want {
+		t.Errorf("expected %q got %q", want, out.String())
+	}
+
+	out.Reset()
+	if status := runDiffConfig(&out, []string{staging, staging}); status != diffConfigSame || out.Len() != 0 {
+		t.Errorf("expected no differences got %v %q", status, out.String())
+	}
+	if status := runDiffConfig(&out, []string{staging}); status != diffConfigFailed {
+		t.Errorf("expected a single file to fail got %v", status)
+	}
+	if status := runDiffConfig(&out, []string{staging, filepath.Join(dir, "missing.yaml")}); status != diffConfigFailed {
+		t.Errorf("expected a missing file to fail got %v", status)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -14,24 +17,69 @@ import (
 	"github.com/go-chi/render"
 
 	"github.com/lcrownover/hpcadmin-server/internal/api"
+	"github.com/lcrownover/hpcadmin-server/internal/auditsink"
 	"github.com/lcrownover/hpcadmin-server/internal/auth"
 	"github.com/lcrownover/hpcadmin-server/internal/config"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/errorlog"
+	"github.com/lcrownover/hpcadmin-server/internal/flags"
+	"github.com/lcrownover/hpcadmin-server/internal/hostcheck"
+	"github.com/lcrownover/hpcadmin-server/internal/httpclient"
+	"github.com/lcrownover/hpcadmin-server/internal/jobs"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/maintenance"
+	"github.com/lcrownover/hpcadmin-server/internal/metrics"
+	"github.com/lcrownover/hpcadmin-server/internal/notify"
+	"github.com/lcrownover/hpcadmin-server/internal/ratelimit"
+	"github.com/lcrownover/hpcadmin-server/internal/slurm"
 	"github.com/lcrownover/hpcadmin-server/internal/util"
-
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/lcrownover/hpcadmin-server/internal/webhook"
 )
 
 var docs = flag.String("docs", "", "Generate router documentation")
+var openapi = flag.String("openapi", "", "Write an OpenAPI 3 spec of the routes to this path")
 var configPath = flag.String("config", "", "Path to hpcadmin-server configuration file")
 var debug = flag.Bool("debug", false, "Enable debug mode")
+var migrateDB = flag.Bool("migrate", false, "Apply pending database migrations before serving")
+var testDB = flag.Bool("test-db", false, "Check the configured database can be reached, then exit without serving")
+var checkOauth = flag.Bool("check-oauth", false, "Check the configured oauth tenant exists before serving")
+var diffConfig = flag.Bool("diff-config", false, "Print the fields that differ between the two config files given as arguments, then exit")
+
+const (
+	// defaultMembershipSweepInterval applies when membership_sweep_interval isn't set
+	defaultMembershipSweepInterval = 5 * time.Minute
+	// defaultMaxConcurrentJobs applies when max_concurrent_jobs isn't set
+	defaultMaxConcurrentJobs = 2
+	// defaultAuditRetentionInterval applies when audit_retention_interval isn't set
+	defaultAuditRetentionInterval = 24 * time.Hour
+	// defaultAuditSinkInterval applies when audit_sinks.interval isn't set
+	defaultAuditSinkInterval = time.Second
+	// defaultStatsDInterval applies when metrics.statsd.interval isn't set
+	defaultStatsDInterval = 10 * time.Second
+	// defaultRecentErrors applies when recent_errors isn't set
+	defaultRecentErrors = 100
+	// defaultMaxRequestBodyBytes applies when max_request_body_bytes isn't set
+	defaultMaxRequestBodyBytes = 1 << 20
+	// defaultDBQueryTimeout applies when db_query_timeout_seconds isn't set
+	defaultDBQueryTimeout = 10 * time.Second
+	// defaultMigrationsPath applies when migrations_path isn't set
+	defaultMigrationsPath = "/etc/hpcadmin-server/migrations"
+)
+
+// healthCheckPaths are served regardless of the Host header
+// so load balancers and scrapers can reach the server by address
+var healthCheckPaths = []string{"/", "/healthz", "/readyz", "/metrics"}
 
 func main() {
 	var err error
 
 	flag.Parse()
-	util.ConfigureLogging(*debug)
+	// logs at info until the configured log_level is known
+	util.ConfigureLogging(*debug, "")
+
+	if *diffConfig {
+		os.Exit(runDiffConfig(os.Stdout, flag.Args()))
+	}
 
 	slog.Debug("loading configuration from file", "package", "main", "method", "main")
 	cfg, err := config.LoadFile(*configPath)
@@ -41,7 +89,11 @@ func main() {
 	}
 
 	slog.Debug("searching environment variables for overrides", "package", "main", "method", "main")
-	cfg = config.LoadEnvironment(cfg)
+	cfg, err = config.LoadEnvironment(cfg)
+	if err != nil {
+		fmt.Printf("Error loading configuration from environment: %v\n", err)
+		os.Exit(1)
+	}
 
 	slog.Debug("validating Configuration", "package", "main", "method", "main")
 	err = config.Validate(cfg)
@@ -49,16 +101,41 @@ func main() {
 		fmt.Printf("Error validating configuration: %v\n", err)
 		os.Exit(1)
 	}
+	util.ConfigureLogging(*debug, cfg.LogLevel)
 
 	slog.Debug("starting hpcadmin-server", "package", "main", "method", "main")
 
+	dbQueryTimeout := time.Duration(cfg.DBQueryTimeoutSeconds) * time.Second
+	if dbQueryTimeout == 0 {
+		dbQueryTimeout = defaultDBQueryTimeout
+	}
 	dbRequest := data.DBRequest{
-		Host:       cfg.DB.Host,
-		Port:       cfg.DB.Port,
-		User:       cfg.DB.User,
-		Password:   cfg.DB.Password,
-		DBName:     cfg.DB.DBName,
-		DisableSSL: true,
+		Host:            cfg.DB.Host,
+		Port:            cfg.DB.Port,
+		User:            cfg.DB.User,
+		Password:        cfg.DB.Password,
+		DBName:          cfg.DB.DBName,
+		Driver:          cfg.DB.Driver,
+		DisableSSL:      true,
+		SSLMode:         cfg.DB.SSLMode,
+		SSLRootCert:     cfg.DB.SSLRootCert,
+		SSLCert:         cfg.DB.SSLCert,
+		SSLKey:          cfg.DB.SSLKey,
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(cfg.DB.ConnMaxLifetimeSeconds) * time.Second,
+		QueryTimeout:    dbQueryTimeout,
+		LogQueries:      cfg.LogQueries,
+		ConnectAttempts: cfg.DB.ConnectAttempts,
+		ConnectBackoff:  cfg.DB.ConnectBackoff,
+	}
+	if *testDB {
+		if err = checkDB(dbRequest, dbCheckTimeout); err != nil {
+			fmt.Printf("Error testing database connection: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Connected to database %s on %s as %s\n", dbRequest.DBName, dbAddr(dbRequest), dbRequest.User)
+		os.Exit(0)
 	}
 	dbConn, err := data.NewDBConn(dbRequest)
 	if err != nil {
@@ -66,48 +143,229 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *migrateDB {
+		migrationsPath := cfg.MigrationsPath
+		if migrationsPath == "" {
+			migrationsPath = defaultMigrationsPath
+		}
+		// migrations can run longer than a query is allowed to, so they
+		// get their own connection without the query timeout
+		migrateRequest := dbRequest
+		migrateRequest.QueryTimeout = 0
+		migrateConn, err := data.NewDBConn(migrateRequest)
+		if err != nil {
+			fmt.Printf("Error connecting to database: %v\n", err)
+			os.Exit(1)
+		}
+		err = data.RunMigrations(migrateConn, migrationsPath)
+		migrateConn.Close()
+		if err != nil {
+			fmt.Printf("Error migrating database: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.DBWarmupConnections > 0 {
+		err = data.WarmupDBConn(dbConn, dbRequest, cfg.DBWarmupConnections)
+		if err != nil {
+			fmt.Printf("Error warming up database connections: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.DefaultPirg != "" {
+		slog.Debug("validating default pirg", "package", "main", "method", "main", "pirg", cfg.DefaultPirg)
+		err = data.ValidateDefaultPirg(dbConn, cfg.DefaultPirg)
+		if err != nil {
+			fmt.Printf("Error validating configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.OrphanedPirgOwner != "" {
+		slog.Debug("validating orphaned pirg owner", "package", "main", "method", "main", "username", cfg.OrphanedPirgOwner)
+		err = data.ValidateOrphanedPirgOwner(dbConn, cfg.OrphanedPirgOwner)
+		if err != nil {
+			fmt.Printf("Error validating configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	httpClient, err := httpclient.New(cfg.HTTPClient)
+	if err != nil {
+		fmt.Printf("Error validating configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if *checkOauth {
+		slog.Debug("verifying oauth tenant", "package", "main", "method", "main", "tenant", cfg.Oauth.TenantID)
+		verifyTimeout := cfg.Oauth.VerifyTimeout
+		if verifyTimeout == 0 {
+			verifyTimeout = config.DefaultOauthVerifyTimeout
+		}
+		verifyCtx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+		err = config.VerifyOauth(verifyCtx, cfg, httpClient)
+		cancel()
+		if err != nil {
+			fmt.Printf("Error validating configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	sweepInterval := cfg.MembershipSweepInterval
+	if sweepInterval == 0 {
+		sweepInterval = defaultMembershipSweepInterval
+	}
+	maxConcurrentJobs := cfg.MaxConcurrentJobs
+	if maxConcurrentJobs == 0 {
+		maxConcurrentJobs = defaultMaxConcurrentJobs
+	}
+	jobRegistry := jobs.NewRegistry(maxConcurrentJobs)
+	jobRegistry.Start(context.Background(), "membership expiry sweep", sweepInterval, func(context.Context) error {
+		_, err := data.SweepExpiredPirgMembers(dbConn)
+		return err
+	})
+
 	listenAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 
 	authCache := auth.NewAuthCache()
-	mw := auth.NewMiddleware(dbConn)
+	mw := auth.NewMiddleware(dbConn, cfg, httpClient)
+	go mw.RefreshJWKS(context.Background())
 
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, keys.DBConnKey, dbConn)
+	ctx = context.WithValue(ctx, keys.StoreKey, data.NewPostgresStore(dbConn))
 	ctx = context.WithValue(ctx, keys.ListenAddrKey, listenAddr)
 	ctx = context.WithValue(ctx, keys.AuthCacheKey, authCache)
 	ctx = context.WithValue(ctx, keys.ConfigKey, cfg)
+	ctx = context.WithValue(ctx, keys.HTTPClientKey, httpClient)
+	ctx = context.WithValue(ctx, keys.FlagsKey, flags.NewEvaluator(cfg.FeatureFlags))
+	notice := maintenance.NewNotice()
+	recentErrors := cfg.RecentErrors
+	if recentErrors == 0 {
+		recentErrors = defaultRecentErrors
+	}
+	errorLog := errorlog.NewBuffer(recentErrors)
+	ctx = context.WithValue(ctx, keys.ErrorLogKey, errorLog)
+	ctx = context.WithValue(ctx, keys.MaintenanceKey, notice)
+	ctx = context.WithValue(ctx, keys.NotifierKey, notify.New(cfg.Notifications))
+	ctx = context.WithValue(ctx, keys.JobsKey, jobRegistry)
+	ctx = context.WithValue(ctx, keys.SlurmKey, slurm.New(cfg.Slurm, httpClient))
+	webhooks := webhook.New(cfg.Webhooks, httpClient)
+	if webhooks != nil {
+		go webhooks.Run(context.Background())
+	}
+	ctx = context.WithValue(ctx, keys.WebhooksKey, webhooks)
+
+	if cfg.AuditRetentionDays > 0 {
+		retentionInterval := cfg.AuditRetentionInterval
+		if retentionInterval == 0 {
+			retentionInterval = defaultAuditRetentionInterval
+		}
+		jobRegistry.Start(context.Background(), "audit retention", retentionInterval, api.AuditRetentionJob(ctx))
+	}
+
+	auditSinks, err := auditsink.New(cfg.AuditSinks, httpClient)
+	if err != nil {
+		fmt.Printf("Error configuring audit sinks: %v\n", err)
+		os.Exit(1)
+	}
+	if len(auditSinks) > 0 {
+		sinkJob, err := api.AuditSinkJob(ctx, auditSinks)
+		if err != nil {
+			fmt.Printf("Error configuring audit sinks: %v\n", err)
+			os.Exit(1)
+		}
+		sinkInterval := cfg.AuditSinks.Interval
+		if sinkInterval == 0 {
+			sinkInterval = defaultAuditSinkInterval
+		}
+		jobRegistry.Start(context.Background(), "audit sink forwarding", sinkInterval, sinkJob)
+	}
+
+	serverMetrics, err := metrics.New(dbConn, cfg.Metrics)
+	if err != nil {
+		fmt.Printf("Error configuring metrics: %v\n", err)
+		os.Exit(1)
+	}
+	if serverMetrics.StatsD() {
+		statsdInterval := cfg.Metrics.StatsD.Interval
+		if statsdInterval == 0 {
+			statsdInterval = defaultStatsDInterval
+		}
+		jobRegistry.Start(context.Background(), "statsd database stats", statsdInterval, serverMetrics.SendDBStats)
+	}
+
+	maxRequestBodyBytes := cfg.MaxRequestBodyBytes
+	if maxRequestBodyBytes == 0 {
+		maxRequestBodyBytes = defaultMaxRequestBodyBytes
+	}
+	// off while requests_per_minute is 0, a reload can turn it on
+	rateLimiter := ratelimit.NewMemoryLimiter(cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.Burst)
 
 	r := chi.NewRouter()
-	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
+	r.Use(requestIdMiddleware(cfg.RequestIdFormat))
+	r.Use(errorLog.Middleware)
+	r.Use(api.InternalErrorDetail(cfg.ExposeInternalErrors))
+	r.Use(requestLogMiddleware)
 	r.Use(middleware.Recoverer)
+	r.Use(serverMetrics.Middleware)
+	r.Use(hostcheck.Middleware(cfg.AllowedHosts, healthCheckPaths))
+	// after hostcheck, so requests are only redirected to allowed hosts
+	r.Use(api.EnforceHTTPS(cfg.TLS, cfg.Port, healthCheckPaths))
+	r.Use(notice.Middleware)
+	r.Use(trailingSlashMiddleware(cfg.TrailingSlash))
 	r.Use(middleware.URLFormat)
+	r.Use(api.Charset(cfg.IncludeCharset))
 	r.Use(render.SetContentType(render.ContentTypeJSON))
+	r.NotFound(api.NotFound)
 
-	// public routes for logging in and simple homepage
+	// public routes for logging in, health checks and simple homepage
 	r.Group(func(r chi.Router) {
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Get("/healthz", api.Healthz)
+		r.Get("/readyz", api.Readyz(ctx))
+		if serverMetrics.Prometheus() {
+			r.Method(http.MethodGet, "/metrics", serverMetrics.Handler())
+		}
+		r.Get("/api/v1/time", api.GetTime)
 		// r.Mount("/login", api.LoginRouter(ctx)) // TODO(lcrown)
 		r.Mount("/oauth", auth.OauthRouter(ctx))
 	})
 
 	// private routes for authenticated users
 	r.Group(func(r chi.Router) {
+		// before auth, so preflight requests are answered without credentials
+		r.Use(api.CORS(cfg.CORS))
+		r.Use(api.MaxRequestBody(maxRequestBodyBytes))
+		r.Use(mw.ClientCertLoader)
 		r.Use(mw.APIKeyLoader)
 		r.Use(mw.OauthLoader)
+		r.Use(mw.GrantLoader)
 		r.Use(mw.RoleVerifier)
+		r.Use(api.ReadAudit(dbConn, cfg.ReadAuditRoutes))
 		r.Route("/api/v1", func(r chi.Router) {
-			r.Mount("/users", api.UsersRouter(ctx))
-			r.Mount("/pirgs", api.PirgsRouter(ctx))
+			r.Use(api.RateLimit(rateLimiter))
+			r.Get("/ratelimit", api.GetRateLimit)
+			sanitize := api.SanitizeStrings(cfg.InputSanitization)
+			r.With(sanitize).Mount("/users", api.UsersRouter(ctx))
+			r.With(sanitize).Mount("/pirgs", api.PirgsRouter(ctx))
+			r.Mount("/memberships", api.MembershipsRouter(ctx))
+			r.Mount("/partitions", api.PartitionsRouter(ctx))
+			r.Mount("/me", api.MeRouter(ctx))
+			r.Mount("/capabilities", api.CapabilitiesRouter(ctx))
+			r.Mount("/changelog", api.ChangelogRouter(ctx))
 		})
 	})
 
 	// admin routes for authenticated admins
 	r.Group(func(r chi.Router) {
+		r.Use(mw.ClientCertLoader)
 		r.Use(mw.APIKeyLoader)
 		r.Use(mw.OauthLoader)
+		r.Use(mw.GrantLoader)
 		r.Use(mw.RoleVerifier)
 		r.Use(mw.AdminOnly)
+		r.Use(api.ReadAudit(dbConn, cfg.ReadAuditRoutes))
 		r.Mount("/admin", api.AdminRouter(ctx))
 	})
 
@@ -116,11 +374,60 @@ func main() {
 		return
 	}
 
+	if *openapi != "" {
+		if err := api.GenerateOpenAPI(r, *openapi); err != nil {
+			fmt.Printf("Error generating OpenAPI spec: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	docgen.PrintRoutes(r)
 
+	srv := newServer(cfg, listenAddr, r)
+	listen := srv.ListenAndServe
+	if cfg.TLS.CertFile != "" {
+		srv.TLSConfig, err = auth.NewServerTLSConfig(cfg.TLS)
+		if err != nil {
+			fmt.Printf("Error configuring TLS: %v\n", err)
+			os.Exit(1)
+		}
+		listen = func() error { return srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile) }
+	}
 	fmt.Println("Listening on " + listenAddr)
-	err = http.ListenAndServe(listenAddr, r)
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// SIGHUP reloads the log level and rate limits without a restart
+	newLiveConfig(*configPath, *debug, cfg, rateLimiter).reloadOnSIGHUP(signalCtx)
+	shutdownTimeout := durationOrDefault(cfg.Timeouts.ShutdownTimeout, defaultShutdownTimeout)
+
+	// the plain http port serves the same routes, EnforceHTTPS redirects
+	// everything but health checks
+	httpErrs := make(chan error, 1)
+	if cfg.TLS.HTTPPort != 0 {
+		httpAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.TLS.HTTPPort)
+		httpSrv := newServer(cfg, httpAddr, r)
+		fmt.Println("Listening on " + httpAddr)
+		go func() {
+			err := runServer(signalCtx, httpSrv, shutdownTimeout, httpSrv.ListenAndServe)
+			// stop the https server too if this one fails
+			stop()
+			httpErrs <- err
+		}()
+	} else {
+		httpErrs <- nil
+	}
+
+	err = runServer(signalCtx, srv, shutdownTimeout, listen)
+	stop()
+	if httpErr := <-httpErrs; err == nil {
+		err = httpErr
+	}
+	// after the requests, which can submit jobs, have drained
+	drainJobs(jobRegistry, durationOrDefault(cfg.Timeouts.JobsShutdownTimeout, defaultJobsShutdownTimeout))
+	dbConn.Close()
 	if err != nil {
-		fmt.Printf("Error starting server: %v\n", err)
+		fmt.Printf("Error running server: %v\n", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/ratelimit"
+	"github.com/lcrownover/hpcadmin-server/internal/util"
+)
+
+// liveConfig is the configuration the server is running with. Reloads swap
+// it whole, so readers always see one consistent config, and apply the
+// config.ReloadableFields to the logger and the rate limiter.
+type liveConfig struct {
+	configPath string
+	debug      bool
+	limiter    *ratelimit.MemoryLimiter
+	current    atomic.Pointer[config.ServerConfig]
+}
+
+func newLiveConfig(configPath string, debug bool, cfg *config.ServerConfig, limiter *ratelimit.MemoryLimiter) *liveConfig {
+	l := &liveConfig{configPath: configPath, debug: debug, limiter: limiter}
+	l.current.Store(cfg)
+	return l
+}
+
+// Config returns the configuration last loaded
+func (l *liveConfig) Config() *config.ServerConfig {
+	return l.current.Load()
+}
+
+// reload loads the configuration again, see config.Reload. If it's invalid
+// the running one is kept. Fields other than config.ReloadableFields are
+// stored but only take effect after a restart, which is logged.
+func (l *liveConfig) reload() error {
+	slog.Debug("reloading configuration", "path", l.configPath, "package", "main", "method", "reload")
+	cfg, err := config.Reload(l.configPath)
+	if err != nil {
+		return err
+	}
+	old := l.current.Swap(cfg)
+	util.SetLogLevel(l.debug, cfg.LogLevel)
+	l.limiter.SetRate(cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.Burst)
+	if changed := config.RestartRequired(old, cfg); len(changed) > 0 {
+		slog.Warn("configuration changes need a restart to take effect", "fields", changed, "package", "main", "method", "reload")
+	}
+	slog.Info("reloaded configuration", "log_level", cfg.LogLevel, "requests_per_minute", cfg.RateLimit.RequestsPerMinute, "burst", cfg.RateLimit.Burst, "package", "main", "method", "reload")
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration each time the process gets SIGHUP,
+// until ctx is done
+func (l *liveConfig) reloadOnSIGHUP(ctx context.Context) {
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hups)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hups:
+				if err := l.reload(); err != nil {
+					slog.Error("failed to reload configuration, keeping the running one", "error", err, "package", "main", "method", "reloadOnSIGHUP")
+				}
+			}
+		}
+	}()
+}
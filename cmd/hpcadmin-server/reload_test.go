@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/ratelimit"
+	"github.com/lcrownover/hpcadmin-server/internal/util"
+)
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	base, err := os.ReadFile("../../test/data/testconfig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(extra string) {
+		if err := os.WriteFile(configPath, append(base, []byte("\n"+extra)...), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("log_level: info\n")
+	cfg, err := config.Reload(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer slog.SetDefault(slog.Default())
+	util.ConfigureLogging(false, cfg.LogLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	limiter := ratelimit.NewMemoryLimiter(0, 0)
+	live := newLiveConfig(configPath, false, cfg, limiter)
+	live.reloadOnSIGHUP(ctx)
+
+	writeConfig("log_level: warn\nrate_limit:\n  requests_per_minute: 60\n  burst: 1\n")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for live.Config() == cfg && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if live.Config() == cfg {
+		t.Fatal("expected the configuration to be reloaded on SIGHUP")
+	}
+	if slog.Default().Enabled(ctx, slog.LevelInfo) || !slog.Default().Enabled(ctx, slog.LevelWarn) {
+		t.Error("expected the log level to change to warn")
+	}
+	if live.Config().LogLevel != config.LogLevelWarn {
+		t.Errorf("expected the live log level warn got %v", live.Config().LogLevel)
+	}
+	limiter.Allow(ctx, "client")
+	if ok, _, _ := limiter.Allow(ctx, "client"); ok {
+		t.Error("expected the new rate limit to apply")
+	}
+
+	// an invalid configuration keeps the running one
+	reloaded := live.Config()
+	writeConfig("log_level: loud\n")
+	if err := live.reload(); err == nil {
+		t.Error("expected an error reloading an invalid configuration")
+	}
+	if live.Config() != reloaded || slog.Default().Enabled(ctx, slog.LevelInfo) {
+		t.Error("expected the running configuration to be kept")
+	}
+}
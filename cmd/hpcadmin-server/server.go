@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/jobs"
+)
+
+// server timeouts used when they aren't configured. They're generous enough
+// for large list pages and audit exports, but don't let a client hold a
+// connection open indefinitely.
+const (
+	defaultReadTimeout       = time.Minute
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 5 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 15 * time.Second
+	// background jobs can be in the middle of a long transaction, such
+	// as an ldap sync, so they get longer than requests
+	defaultJobsShutdownTimeout = 30 * time.Second
+)
+
+// newServer builds the http server for the configured limits.
+// Requests with headers over MaxHeaderBytes get a 431 from net/http
+// before they reach the handler, and connections that are too slow
+// to send their headers are closed.
+func newServer(cfg *config.ServerConfig, addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       durationOrDefault(cfg.Timeouts.ReadTimeout, defaultReadTimeout),
+		ReadHeaderTimeout: durationOrDefault(cfg.Timeouts.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      durationOrDefault(cfg.Timeouts.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       durationOrDefault(cfg.Timeouts.IdleTimeout, defaultIdleTimeout),
+	}
+	if cfg.MaxHeaderBytes > 0 {
+		srv.MaxHeaderBytes = cfg.MaxHeaderBytes
+	}
+	return srv
+}
+
+// runServer calls listen, which serves srv, until it fails or ctx is done.
+// Then the server stops accepting connections and in-flight requests get up
+// to timeout to finish before their connections are closed.
+func runServer(ctx context.Context, srv *http.Server, timeout time.Duration, listen func() error) error {
+	errs := make(chan error, 1)
+	go func() { errs <- listen() }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("shutting down, draining in-flight requests", "timeout", timeout, "package", "main", "method", "runServer")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("failed to drain in-flight requests: %v", err)
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// drainJobs shuts the registry down, waiting up to timeout for the jobs
+// running to finish, so they aren't cut off mid-transaction when the
+// database is closed. Jobs still running at the timeout are abandoned.
+func drainJobs(registry *jobs.Registry, timeout time.Duration) {
+	slog.Info("shutting down, draining background jobs", "timeout", timeout, "package", "main", "method", "drainJobs")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if abandoned := registry.Shutdown(ctx); len(abandoned) > 0 {
+		slog.Warn("abandoning background jobs still running at shutdown", "jobs", abandoned, "timeout", timeout, "package", "main", "method", "drainJobs")
+	}
+}
+
+func durationOrDefault(d time.Duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// requestIdMiddleware returns the middleware that sets the request id for the
+// configured format. Either way an id sent in X-Request-Id is kept, the id is
+// read back with middleware.GetReqID, and it's sent back in X-Request-Id so
+// clients can quote it.
+func requestIdMiddleware(format string) func(http.Handler) http.Handler {
+	if format != config.RequestIdFormatUUID {
+		return func(next http.Handler) http.Handler {
+			return middleware.RequestID(echoRequestId(next))
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		next = echoRequestId(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(middleware.RequestIDHeader)
+			if id == "" {
+				id = newUUID()
+			}
+			ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func echoRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestLogMiddleware logs each request once it's served, as a structured
+// record with its request id. Server errors are logged at error, everything
+// else at info.
+func requestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			slog.Log(r.Context(), level, "served request",
+				"http_method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"duration", time.Since(start),
+				"bytes", ww.BytesWritten(),
+				"request_id", middleware.GetReqID(r.Context()),
+				"remote_addr", r.RemoteAddr,
+				"package", "main",
+				"method", "requestLogMiddleware",
+			)
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// trailingSlashMiddleware handles requests for paths ending in a slash the
+// configured way. Redirects keep the query, and use 308 for methods other
+// than GET and HEAD so clients resend the body.
+func trailingSlashMiddleware(mode string) func(http.Handler) http.Handler {
+	switch mode {
+	case config.TrailingSlashStrip:
+		return middleware.StripSlashes
+	case config.TrailingSlashRedirect:
+		return redirectSlashes
+	default:
+		return func(next http.Handler) http.Handler { return next }
+	}
+}
+
+func redirectSlashes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if len(path) <= 1 || !strings.HasSuffix(path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// collapse leading slashes so the location can't be read as
+		// another host, like //example.com
+		target := "/" + strings.Trim(path, "/")
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target, status)
+	})
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
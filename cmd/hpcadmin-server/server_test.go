@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestNewServerMaxHeaderBytes(t *testing.T) {
+	cfg := &config.ServerConfig{MaxHeaderBytes: 1024}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(cfg, ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	get := func(cookie string) int {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Cookie", cookie)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := get("small=1"); status != http.StatusOK {
+		t.Errorf("expected status %v got %v", http.StatusOK, status)
+	}
+	// net/http allows some slack over the limit, so go well past it
+	if status := get("huge=" + strings.Repeat("a", 16*1024)); status != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected status %v got %v", http.StatusRequestHeaderFieldsTooLarge, status)
+	}
+}
+
+func TestNewServerReadHeaderTimeout(t *testing.T) {
+	cfg := &config.ServerConfig{Timeouts: config.TimeoutConfig{ReadHeaderTimeout: 100 * time.Millisecond}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(cfg, ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// start a request and never finish the headers
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	buf := make([]byte, 1024)
+	for {
+		if _, err = conn.Read(buf); err != nil {
+			break
+		}
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("expected the server to close the connection before the client gave up")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the connection to close after the read header timeout, took %v", elapsed)
+	}
+}
+
+func TestNewServerDefaultTimeouts(t *testing.T) {
+	srv := newServer(&config.ServerConfig{}, "", nil)
+	if srv.ReadHeaderTimeout != defaultReadHeaderTimeout || srv.ReadTimeout != defaultReadTimeout || srv.WriteTimeout != defaultWriteTimeout || srv.IdleTimeout != defaultIdleTimeout {
+		t.Errorf("expected the default timeouts got %+v", srv)
+	}
+}
+
+func TestRequestIdMiddleware(t *testing.T) {
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	requestId := func(format string, header string) string {
+		t.Helper()
+		var id string
+		handler := requestIdMiddleware(format)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id = middleware.GetReqID(r.Context())
+		}))
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set(middleware.RequestIDHeader, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if sent := w.Header().Get(middleware.RequestIDHeader); sent != id {
+			t.Errorf("expected the request id %q in the response header got %q", id, sent)
+		}
+		return id
+	}
+
+	a, b := requestId(config.RequestIdFormatUUID, ""), requestId(config.RequestIdFormatUUID, "")
+	if !uuidV4.MatchString(a) || !uuidV4.MatchString(b) {
+		t.Errorf("expected UUIDv4 request ids got %q and %q", a, b)
+	}
+	if a == b {
+		t.Errorf("expected unique request ids got %q twice", a)
+	}
+	if id := requestId(config.RequestIdFormatUUID, "from-client"); id != "from-client" {
+		t.Errorf("expected the client's request id got %q", id)
+	}
+	for _, format := range []string{"", config.RequestIdFormatChi} {
+		if id := requestId(format, ""); id == "" || uuidV4.MatchString(id) {
+			t.Errorf("expected a chi request id for format %q got %q", format, id)
+		}
+	}
+}
+
+func TestRequestLogMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	handler := requestIdMiddleware(config.RequestIdFormatUUID)(requestLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("hello"))
+	})))
+	for _, path := range []string{"/users", "/fail"} {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set(middleware.RequestIDHeader, "test-id"+path)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 request logs got %d: %s", len(records), buf.String())
+	}
+	ok, failed := records[0], records[1]
+	if ok["level"] != "INFO" || ok["http_method"] != "POST" || ok["path"] != "/users" || ok["status"] != float64(200) || ok["bytes"] != float64(5) || ok["request_id"] != "test-id/users" {
+		t.Errorf("unexpected request log %v", ok)
+	}
+	if _, ok := ok["duration"]; !ok {
+		t.Errorf("expected the request log to have a duration")
+	}
+	if failed["level"] != "ERROR" || failed["status"] != float64(500) || failed["request_id"] != "test-id/fail" {
+		t.Errorf("unexpected request log %v", failed)
+	}
+}
+
+func TestRunServerDrainsRequests(t *testing.T) {
+	newTestServer := func(handler http.HandlerFunc) (*http.Server, net.Listener) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newServer(&config.ServerConfig{}, ln.Addr().String(), handler), ln
+	}
+	// started is closed once a request is in flight, and release lets it finish
+	run := func(timeout time.Duration, release <-chan struct{}) (*http.Response, error, error) {
+		t.Helper()
+		started := make(chan struct{})
+		srv, ln := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.Write([]byte("done"))
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		runErr := make(chan error, 1)
+		go func() { runErr <- runServer(ctx, srv, timeout, func() error { return srv.Serve(ln) }) }()
+
+		type result struct {
+			resp *http.Response
+			err  error
+		}
+		results := make(chan result, 1)
+		go func() {
+			resp, err := http.Get("http://" + ln.Addr().String() + "/")
+			results <- result{resp, err}
+		}()
+		<-started
+		cancel()
+		res := <-results
+		return res.resp, res.err, <-runErr
+	}
+
+	t.Run("InFlightRequestFinishes", func(t *testing.T) {
+		release := make(chan struct{})
+		time.AfterFunc(50*time.Millisecond, func() { close(release) })
+		resp, err, runErr := run(5*time.Second, release)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected the in-flight request to finish got %v", resp.StatusCode)
+		}
+		if runErr != nil {
+			t.Errorf("expected a clean shutdown got %v", runErr)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		_, _, runErr := run(10*time.Millisecond, release)
+		if runErr == nil || !strings.Contains(runErr.Error(), "drain") {
+			t.Errorf("expected the shutdown to time out got %v", runErr)
+		}
+	})
+}
+
+func TestTrailingSlashMiddleware(t *testing.T) {
+	newRouter := func(mode string) http.Handler {
+		users := chi.NewRouter()
+		users.Get("/", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("list")) })
+		users.Get("/by-uid/{uid}", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("uid " + chi.URLParam(r, "uid"))) })
+		users.Post("/by-uid/{uid}", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("post")) })
+		r := chi.NewRouter()
+		r.Use(trailingSlashMiddleware(mode))
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("home")) })
+		r.Route("/api/v1", func(r chi.Router) {
+			r.Mount("/users", users)
+		})
+		return r
+	}
+	tests := []struct {
+		mode       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantTarget string
+	}{
+		{config.TrailingSlashStrict, "GET", "/api/v1/users/by-uid/5", http.StatusOK, "uid 5", ""},
+		{config.TrailingSlashStrict, "GET", "/api/v1/users/by-uid/5/", http.StatusNotFound, "", ""},
+		{"", "GET", "/api/v1/users/by-uid/5/", http.StatusNotFound, "", ""},
+		{config.TrailingSlashStrip, "GET", "/api/v1/users", http.StatusOK, "list", ""},
+		{config.TrailingSlashStrip, "GET", "/api/v1/users/", http.StatusOK, "list", ""},
+		{config.TrailingSlashStrip, "GET", "/api/v1/users/by-uid/5", http.StatusOK, "uid 5", ""},
+		{config.TrailingSlashStrip, "GET", "/api/v1/users/by-uid/5/", http.StatusOK, "uid 5", ""},
+		{config.TrailingSlashStrip, "GET", "/", http.StatusOK, "home", ""},
+		{config.TrailingSlashRedirect, "GET", "/api/v1/users/by-uid/5", http.StatusOK, "uid 5", ""},
+		{config.TrailingSlashRedirect, "GET", "/api/v1/users/by-uid/5/?x=1", http.StatusMovedPermanently, "", "/api/v1/users/by-uid/5?x=1"},
+		{config.TrailingSlashRedirect, "POST", "/api/v1/users/by-uid/5/", http.StatusPermanentRedirect, "", "/api/v1/users/by-uid/5"},
+		{config.TrailingSlashRedirect, "GET", "//example.com/", http.StatusMovedPermanently, "", "/example.com"},
+		{config.TrailingSlashRedirect, "GET", "/", http.StatusOK, "home", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newRouter(tt.mode).ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v got %v", tt.wantStatus, w.Code)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("expected body %q got %q", tt.wantBody, w.Body.String())
+			}
+			if location := w.Header().Get("Location"); location != tt.wantTarget {
+				t.Errorf("expected location %q got %q", tt.wantTarget, location)
+			}
+		})
+	}
+}
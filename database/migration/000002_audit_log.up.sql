@@ -0,0 +1,10 @@
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    details JSONB
+);
+CREATE INDEX audit_log_occurred_at_idx ON audit_log (occurred_at);
@@ -0,0 +1,12 @@
+CREATE TABLE user_attributes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, key),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TRIGGER update_user_attributes_modtime BEFORE UPDATE ON user_attributes FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX user_attributes_key_value_idx ON user_attributes (key, value);
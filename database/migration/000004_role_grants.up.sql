@@ -0,0 +1,13 @@
+CREATE TABLE role_grants (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    role TEXT NOT NULL,
+    granted_by TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TRIGGER update_role_grants_modtime BEFORE UPDATE ON role_grants FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX role_grants_user_id_expires_at_idx ON role_grants (user_id, expires_at);
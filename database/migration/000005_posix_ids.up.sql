@@ -0,0 +1,11 @@
+-- ids are never deleted so a uid or gid is not reused after its
+-- user or pirg is removed, which would hand over their files
+CREATE TABLE posix_ids (
+    id SERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('uid', 'gid')),
+    value INT NOT NULL,
+    resource_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (kind, value),
+    UNIQUE (kind, resource_id)
+);
@@ -0,0 +1,4 @@
+DROP VIEW IF EXISTS active_pirgs_admins;
+DROP VIEW IF EXISTS active_pirgs_users;
+DROP INDEX IF EXISTS pirgs_users_expires_at_idx;
+ALTER TABLE pirgs_users DROP COLUMN IF EXISTS expires_at;
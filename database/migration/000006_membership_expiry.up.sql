@@ -0,0 +1,15 @@
+ALTER TABLE pirgs_users ADD COLUMN expires_at TIMESTAMP;
+CREATE INDEX pirgs_users_expires_at_idx ON pirgs_users (expires_at) WHERE expires_at IS NOT NULL;
+
+-- membership is read through these views so it ends the moment it expires,
+-- before the sweep deletes the rows. expires_at is stored in UTC.
+CREATE VIEW active_pirgs_users AS
+    SELECT * FROM pirgs_users
+    WHERE expires_at IS NULL OR expires_at > (NOW() AT TIME ZONE 'UTC');
+
+CREATE VIEW active_pirgs_admins AS
+    SELECT pa.* FROM pirgs_admins pa
+    WHERE EXISTS (
+        SELECT 1 FROM active_pirgs_users pu
+        WHERE pu.pirg_id = pa.pirg_id AND pu.user_id = pa.user_id
+    );
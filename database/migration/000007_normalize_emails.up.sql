@@ -0,0 +1,4 @@
+-- emails are stored trimmed and lowercased, and the index enforces it for
+-- rows written outside the api. Fails if existing emails only differ by case.
+UPDATE users SET email = lower(btrim(email)) WHERE email <> lower(btrim(email));
+CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
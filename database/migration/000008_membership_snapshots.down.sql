@@ -0,0 +1 @@
+DROP TABLE IF EXISTS pirg_membership_snapshots;
@@ -0,0 +1,11 @@
+-- restore points for pirg membership, taken before bulk changes
+CREATE TABLE pirg_membership_snapshots (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    admin_ids INT[] NOT NULL,
+    user_ids INT[] NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE
+);
+CREATE INDEX pirg_membership_snapshots_pirg_id_idx ON pirg_membership_snapshots (pirg_id);
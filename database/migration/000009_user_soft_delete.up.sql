@@ -0,0 +1,4 @@
+-- users are soft deleted so the pirg membership and audit history that
+-- references them stays intact. Deleted users are left out of listings.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- last_login_at is stamped by the login integration on every login, so stale
+-- accounts can be found. It isn't indexed, an index on it would have to be
+-- updated on every login too, and finding stale accounts is rare.
+ALTER TABLE users ADD COLUMN last_login_at TIMESTAMP;
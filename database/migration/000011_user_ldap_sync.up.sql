@@ -0,0 +1,3 @@
+-- ldap_synced marks users the ldap sync created or matched, only they are
+-- deleted when they're no longer in the directory
+ALTER TABLE users ADD COLUMN ldap_synced BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS clear_users_email_verified ON users;
+DROP FUNCTION IF EXISTS clear_email_verified();
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
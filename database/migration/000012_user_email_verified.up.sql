@@ -0,0 +1,14 @@
+-- set once the user has shown they own their email. The trigger clears it
+-- whenever the email changes, however the row is written.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+CREATE OR REPLACE FUNCTION clear_email_verified()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF lower(NEW.email) <> lower(OLD.email) THEN
+        NEW.email_verified_at = NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+CREATE TRIGGER clear_users_email_verified BEFORE UPDATE OF email ON users FOR EACH ROW EXECUTE PROCEDURE clear_email_verified();
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS bump_pirgs_admins_pirg_version ON pirgs_admins;
+DROP TRIGGER IF EXISTS bump_pirgs_users_pirg_version ON pirgs_users;
+DROP FUNCTION IF EXISTS bump_pirg_version();
+DROP TRIGGER IF EXISTS bump_pirgs_version ON pirgs;
+DROP TRIGGER IF EXISTS bump_users_version ON users;
+DROP FUNCTION IF EXISTS bump_version();
+ALTER TABLE pirgs DROP COLUMN IF EXISTS version;
+ALTER TABLE users DROP COLUMN IF EXISTS version;
@@ -0,0 +1,33 @@
+-- version counts the updates of each user and pirg, so an update can require
+-- the version it was based on and be refused if another got there first. The
+-- triggers bump it however the row is written, unless nothing changed. A
+-- user's version only changes with their fields, not logins or deletes, and
+-- a pirg's changes with its membership too.
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE pirgs ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION bump_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW IS DISTINCT FROM OLD THEN
+        NEW.version = OLD.version + 1;
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+CREATE TRIGGER bump_users_version BEFORE UPDATE OF username, email, firstname, lastname ON users FOR EACH ROW EXECUTE PROCEDURE bump_version();
+CREATE TRIGGER bump_pirgs_version BEFORE UPDATE OF name, owner_id ON pirgs FOR EACH ROW EXECUTE PROCEDURE bump_version();
+
+CREATE OR REPLACE FUNCTION bump_pirg_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE pirgs SET version = version + 1 WHERE id = OLD.pirg_id;
+        RETURN OLD;
+    END IF;
+    UPDATE pirgs SET version = version + 1 WHERE id = NEW.pirg_id;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+CREATE TRIGGER bump_pirgs_users_pirg_version AFTER INSERT OR UPDATE OR DELETE ON pirgs_users FOR EACH ROW EXECUTE PROCEDURE bump_pirg_version();
+CREATE TRIGGER bump_pirgs_admins_pirg_version AFTER INSERT OR UPDATE OR DELETE ON pirgs_admins FOR EACH ROW EXECUTE PROCEDURE bump_pirg_version();
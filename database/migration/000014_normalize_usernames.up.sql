@@ -0,0 +1,5 @@
+-- usernames are case insensitive, they're stored trimmed and lowercased, and
+-- the index enforces it for rows written outside the api. Fails if existing
+-- usernames only differ by case.
+UPDATE users SET username = lower(btrim(username)) WHERE username <> lower(btrim(username));
+CREATE UNIQUE INDEX users_username_lower_key ON users (lower(username));
@@ -0,0 +1,3 @@
+CREATE OR REPLACE VIEW active_pirgs_users AS
+    SELECT * FROM pirgs_users
+    WHERE expires_at IS NULL OR expires_at > (NOW() AT TIME ZONE 'UTC');
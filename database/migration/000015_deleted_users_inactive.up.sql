@@ -0,0 +1,7 @@
+-- deleted users keep their membership rows, for the history and so a
+-- restore brings their memberships back, but they're no longer members or
+-- admins of anything while they're deleted
+CREATE OR REPLACE VIEW active_pirgs_users AS
+    SELECT pu.* FROM pirgs_users pu
+    WHERE (pu.expires_at IS NULL OR pu.expires_at > (NOW() AT TIME ZONE 'UTC'))
+        AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id = pu.user_id AND u.deleted_at IS NOT NULL);
@@ -11,14 +11,52 @@ import (
 // A completely separate router for administrator routes
 func AdminRouter(ctx context.Context) chi.Router {
 	r := chi.NewRouter()
+	auditHandler := newAuditHandler(ctx)
+	grantHandler := newGrantHandler(ctx)
+	posixIdHandler := newPosixIdHandler(ctx)
+	provisioningHandler := newProvisioningHandler(ctx)
+	maintenanceHandler := newMaintenanceHandler(ctx)
+	errorLogHandler := newErrorLogHandler(ctx)
+	fairshareHandler := newFairshareHandler(ctx)
+	notificationHandler := newNotificationHandler(ctx)
+	jobsHandler := newJobsHandler(ctx)
+	ldapSyncHandler := newLDAPSyncHandler(ctx)
+	pirgHandler := newPirgHandler(ctx)
+	reportHandler := newReportHandler(ctx)
+	userHandler := newUserHandler(ctx)
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("admin: index"))
 	})
 	r.Get("/accounts", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("admin: list accounts.."))
 	})
+	r.Get("/users/pending", userHandler.GetPendingUsers)
 	r.Get("/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "admin: view user id %v", chi.URLParam(r, "userId"))
 	})
+	r.Get("/audit", auditHandler.GetAuditLog)
+	r.Get("/audit/export", auditHandler.ExportAudit)
+	r.Get("/audit/by-actor/{actor}", auditHandler.GetAuditEventsByActor)
+	r.Get("/export/provisioning", provisioningHandler.ExportProvisioning)
+	r.Get("/export/fairshare", fairshareHandler.ExportFairshare)
+	r.Post("/users/{userId}/grant", grantHandler.CreateGrant)
+	r.Get("/next-uid", posixIdHandler.GetNextUid)
+	r.Get("/next-gid", posixIdHandler.GetNextGid)
+	r.Get("/allocations/utilization", posixIdHandler.GetAllocationUtilization)
+	r.Post("/config/validate", ValidateConfig)
+	r.Post("/templates/validate", provisioningHandler.ValidateScriptTemplate)
+	r.Put("/maintenance/banner", maintenanceHandler.SetMaintenanceBanner)
+	r.Delete("/maintenance/banner", maintenanceHandler.ClearMaintenanceBanner)
+	r.Post("/maintenance/recompute-derived", userHandler.RecomputeDerived)
+	r.Post("/maintenance/backfill-ids", posixIdHandler.BackfillPosixIds)
+	r.Get("/errors/recent", errorLogHandler.GetRecentErrors)
+	r.Post("/notifications/test", notificationHandler.SendTestNotification)
+	r.Get("/jobs", jobsHandler.GetJobs)
+	r.Post("/sync/ldap", ldapSyncHandler.SyncLDAP)
+	r.Get("/sync/ldap/{syncId}", ldapSyncHandler.GetLDAPSyncJob)
+	r.Post("/pirgs/reconcile-all", pirgHandler.ReconcileAllPirgMembers)
+	r.Post("/pirgs/transfer-all", pirgHandler.TransferAllPirgs)
+	r.Get("/reports/users", reportHandler.GetUserReport)
+	r.Mount("/debug", DebugRouter(ctx))
 	return r
 }
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/auditsink"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// AuditExportEvent is the line format of the audit export.
+// Fields are only ever added to this struct, never renamed or removed,
+// so SIEM parsers built against it keep working.
+type AuditExportEvent struct {
+	Id           int             `json:"id"`
+	Timestamp    string          `json:"timestamp"`
+	Actor        string          `json:"actor"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceId   string          `json:"resource_id"`
+	Details      json.RawMessage `json:"details"`
+}
+
+func newAuditExportEvent(e *data.AuditEvent) *AuditExportEvent {
+	details := e.Details
+	if len(details) == 0 {
+		details = json.RawMessage("null")
+	}
+	return &AuditExportEvent{
+		Id:           e.Id,
+		Timestamp:    e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		Actor:        e.Actor,
+		Action:       e.Action,
+		ResourceType: e.ResourceType,
+		ResourceId:   e.ResourceId,
+		Details:      details,
+	}
+}
+
+// actorFromContext returns the identity auth attached to the request,
+// for recording who made a change
+func actorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(keys.ActorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return "unknown"
+}
+
+// recordPirgMembershipChanges records membership changes after the fact.
+// The change itself already succeeded, so failures are only logged.
+func recordPirgMembershipChanges(ctx context.Context, store data.Store, pirgId int, before []int, after []int) {
+	err := store.RecordPirgMembershipChanges(actorFromContext(ctx), pirgId, before, after)
+	if err != nil {
+		slog.Error("failed to record pirg membership changes", "package", "api", "method", "recordPirgMembershipChanges", "pirg_id", pirgId, "error", err)
+	}
+}
+
+// recordPirgAdminChange records a user gaining or losing admin rights on a pirg.
+// The change itself already succeeded, so failures are only logged.
+func recordPirgAdminChange(ctx context.Context, store data.Store, pirgId int, action string, userId int) {
+	recordAudit(ctx, store, action, "pirg", strconv.Itoa(pirgId), map[string]int{"user_id": userId})
+}
+
+// recordChange records a user or pirg being created, updated, deleted or restored.
+// The change itself already succeeded, so failures are only logged.
+func recordChange(ctx context.Context, store data.Store, resourceType string, resourceId int, action string) {
+	recordAudit(ctx, store, action, resourceType, strconv.Itoa(resourceId), nil)
+}
+
+// recordAudit records the request's actor taking action on a resource.
+// It's called once the action already succeeded, so failing to record it
+// doesn't fail the request, it's only logged.
+func recordAudit(ctx context.Context, store data.Store, action string, resourceType string, resourceId string, details any) {
+	err := store.RecordAudit(actorFromContext(ctx), action, resourceType, resourceId, details)
+	if err != nil {
+		slog.Warn("failed to record audit event", "package", "api", "method", "recordAudit", "action", action, "resource_type", resourceType, "resource_id", resourceId, "error", err)
+	}
+}
+
+// auditExportFlushEvery is how many events are written between flushes
+// so clients see output as it's produced
+const auditExportFlushEvery = 100
+
+type AuditHandler struct {
+	dbConn *sql.DB
+	pages  pageLimits
+}
+
+func newAuditHandler(ctx context.Context) *AuditHandler {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	return &AuditHandler{dbConn: dbConn, pages: newPageLimits(cfg.Pagination, cfg.ListEnvelope)}
+}
+
+// ExportAudit streams the audit events between the `from` and `to` query params
+// as newline-delimited JSON. `from` is inclusive and `to` is exclusive, and both
+// accept RFC3339 timestamps or YYYY-MM-DD dates. `to` defaults to now.
+func (h *AuditHandler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("exporting audit events", "package", "api", "method", "ExportAudit")
+	format := r.URL.Query().Get("format")
+	if format != "" && !slices.Contains(auditExportFormats, format) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported export format: %s", format)))
+		return
+	}
+	from, to, err := parseAuditRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), time.Now())
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	count := 0
+	err = data.StreamAuditEvents(h.dbConn, from, to, func(e *data.AuditEvent) error {
+		if err := enc.Encode(newAuditExportEvent(e)); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%auditExportFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// the status is already sent, so all we can do is stop and log it
+		slog.Error("failed to export audit events", "package", "api", "method", "ExportAudit", "error", err)
+		return
+	}
+	slog.Debug("exported audit events", "count", count, "package", "api", "method", "ExportAudit")
+}
+
+// GetAuditLog returns a page of the audit log, oldest first, in the export
+// format. Following next_cursor reads the events recorded after the page.
+func (h *AuditHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting audit log", "package", "api", "method", "GetAuditLog")
+	limit, afterId, err := h.pages.parseCursor(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	events, err := data.GetAuditEventsAfter(h.dbConn, afterId, limit+1)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := &CursorPageResponse{Limit: limit}
+	if len(events) > limit {
+		events = events[:limit]
+		resp.NextCursor = encodeCursor(events[limit-1].Id)
+	}
+	items := make([]*AuditExportEvent, 0, len(events))
+	for _, e := range events {
+		items = append(items, newAuditExportEvent(e))
+	}
+	resp.Items = items
+	h.pages.renderCursor(w, r, resp)
+}
+
+// GetAuditEventsByActor returns a page of everything the actor in the URL did,
+// oldest first, in the export format. The optional `from` and `to` query
+// params limit it to a range the same way as the export.
+func (h *AuditHandler) GetAuditEventsByActor(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting audit events by actor", "package", "api", "method", "GetAuditEventsByActor")
+	actor := chi.URLParam(r, "actor")
+	fromParam := r.URL.Query().Get("from")
+	if fromParam == "" {
+		fromParam = time.Time{}.Format(time.RFC3339)
+	}
+	from, to, err := parseAuditRange(fromParam, r.URL.Query().Get("to"), time.Now())
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	limit, offset, err := h.pages.parse(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	events, total, err := data.GetAuditEventsByActor(h.dbConn, actor, from, to, limit, offset)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	items := make([]*AuditExportEvent, 0, len(events))
+	for _, e := range events {
+		items = append(items, newAuditExportEvent(e))
+	}
+	resp := &PageResponse{
+		Items:  items,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	h.pages.render(w, r, resp)
+}
+
+// AuditRetentionJob returns a job for jobs.Registry.Start that deletes audit events older
+// than audit_retention_days. If audit_archive_dir is set the events are first
+// written there in the export format, one file per run.
+func AuditRetentionJob(ctx context.Context) func(context.Context) error {
+	h := newAuditHandler(ctx)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	return func(context.Context) error {
+		before := time.Now().AddDate(0, 0, -cfg.AuditRetentionDays)
+		count, err := h.purgeAudit(before, cfg.AuditArchiveDir)
+		if err != nil {
+			return err
+		}
+		slog.Info("purged audit events", "count", count, "before", before, "package", "api", "method", "AuditRetentionJob")
+		return nil
+	}
+}
+
+// auditSinkBatchSize is the most events AuditSinkJob reads per run,
+// so a sink that was down catches up over a few runs
+const auditSinkBatchSize = 500
+
+// auditForwarder is where each sink is in the audit log
+type auditForwarder struct {
+	dbConn  *sql.DB
+	sinks   []auditsink.Sink
+	lastIds []int
+}
+
+// AuditSinkJob returns a job for jobs.Registry.Start that forwards the audit
+// events recorded since its last run to each sink, in the export format. The
+// database has already recorded them, so a sink that fails only fails the job:
+// each sink keeps its own place and gets the events it missed on a later run,
+// without the other sinks seeing them twice. Events recorded before the server
+// started aren't forwarded.
+func AuditSinkJob(ctx context.Context, sinks []auditsink.Sink) (func(context.Context) error, error) {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	lastId, err := data.GetLastAuditEventId(dbConn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the last audit event: %v", err)
+	}
+	f := &auditForwarder{dbConn: dbConn, sinks: sinks, lastIds: make([]int, len(sinks))}
+	for i := range f.lastIds {
+		f.lastIds[i] = lastId
+	}
+	return f.forward, nil
+}
+
+func (f *auditForwarder) forward(ctx context.Context) error {
+	if len(f.sinks) == 0 {
+		return nil
+	}
+	events, err := data.GetAuditEventsAfter(f.dbConn, slices.Min(f.lastIds), auditSinkBatchSize)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, sink := range f.sinks {
+		count := 0
+		for _, e := range events {
+			if e.Id <= f.lastIds[i] {
+				continue
+			}
+			line, err := json.Marshal(newAuditExportEvent(e))
+			if err == nil {
+				err = sink.Write(ctx, line)
+			}
+			if err != nil {
+				slog.Error("failed to forward audit event", "sink", sink.Name(), "id", e.Id, "package", "api", "method", "AuditSinkJob", "error", err)
+				errs = append(errs, fmt.Errorf("%s: %v", sink.Name(), err))
+				break
+			}
+			f.lastIds[i] = e.Id
+			count++
+		}
+		if count > 0 {
+			slog.Debug("forwarded audit events", "sink", sink.Name(), "count", count, "package", "api", "method", "AuditSinkJob")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purgeAudit deletes the audit events before the cutoff. Unless archiveDir is
+// empty they're first written to a new file there, and only the events that
+// made it to disk are deleted.
+func (h *AuditHandler) purgeAudit(before time.Time, archiveDir string) (int, error) {
+	if archiveDir == "" {
+		return data.PurgeAuditEvents(h.dbConn, before, 0)
+	}
+	name := fmt.Sprintf("audit-%s-%d.jsonl", before.UTC().Format(time.DateOnly), time.Now().Unix())
+	path := filepath.Join(archiveDir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create audit archive: %v", err)
+	}
+	enc := json.NewEncoder(f)
+	lastId := 0
+	err = data.StreamAuditEvents(h.dbConn, time.Time{}, before, func(e *data.AuditEvent) error {
+		lastId = max(lastId, e.Id)
+		return enc.Encode(newAuditExportEvent(e))
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || lastId == 0 {
+		os.Remove(path)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive audit events: %v", err)
+	}
+	if lastId == 0 {
+		return 0, nil
+	}
+	return data.PurgeAuditEvents(h.dbConn, before, lastId)
+}
+
+// parseAuditRange parses the from and to query params.
+// from is required, to defaults to now.
+func parseAuditRange(fromParam string, toParam string, now time.Time) (time.Time, time.Time, error) {
+	if fromParam == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("missing required query param: from")
+	}
+	from, err := parseAuditTime(fromParam)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %v", err)
+	}
+	to := now
+	if toParam != "" {
+		to, err = parseAuditTime(toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %v", err)
+		}
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+func parseAuditTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC3339 timestamp or YYYY-MM-DD date: %s", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,497 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/auditsink"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestParseAuditRange(t *testing.T) {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	from, to, err := parseAuditRange("2024-03-01", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected from: %v", from)
+	}
+	if !to.Equal(now) {
+		t.Errorf("expected to to default to now, got %v", to)
+	}
+	_, to, err = parseAuditRange("2024-03-01", "2024-03-02T06:00:00Z", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !to.Equal(time.Date(2024, 3, 2, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected to: %v", to)
+	}
+	for _, tc := range [][2]string{
+		{"", ""},
+		{"yesterday", ""},
+		{"2024-03-05", "2024-03-01"},
+	} {
+		if _, _, err := parseAuditRange(tc[0], tc[1], now); err == nil {
+			t.Errorf("expected error for from=%q to=%q", tc[0], tc[1])
+		}
+	}
+}
+
+func TestAPIExportAudit(t *testing.T) {
+	th := NewTestDataHandler()
+	inRange := time.Date(2002, 6, 1, 12, 0, 0, 0, time.UTC)
+	outOfRange := time.Date(2002, 6, 3, 12, 0, 0, 0, time.UTC)
+	var wantId int
+	for _, ts := range []time.Time{inRange, outOfRange} {
+		e, err := data.CreateAuditEvent(th.DB, &data.AuditEventRequest{
+			Actor:        "testapiexportaudit",
+			Action:       "delete",
+			ResourceType: "pirg",
+			ResourceId:   "42",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = th.DB.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id = $2", ts, e.Id); err != nil {
+			t.Fatal(err)
+		}
+		if ts.Equal(inRange) {
+			wantId = e.Id
+		}
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost:3333/admin/audit/export?format=jsonl&from=2002-06-01&to=2002-06-02", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "testkey1")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected content type application/x-ndjson got %v", ct)
+	}
+
+	// every line must be a standalone json object with the export schema
+	var events []map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var event map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid jsonl line %q: %v", scanner.Text(), err)
+		}
+		for _, field := range []string{"id", "timestamp", "actor", "action", "resource_type", "resource_id", "details"} {
+			if _, ok := event[field]; !ok {
+				t.Fatalf("missing field %v in line %q", field, scanner.Text())
+			}
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event in range got %v", len(events))
+	}
+	if int(events[0]["id"].(float64)) != wantId {
+		t.Errorf("expected event id %v got %v", wantId, events[0]["id"])
+	}
+}
+
+func TestAPIGetAuditEventsByActor(t *testing.T) {
+	th := NewTestDataHandler()
+	actors := []string{"testapiauditbyactora", "testapiauditbyactorb"}
+	if _, err := th.DB.Exec("DELETE FROM audit_log WHERE actor = $1 OR actor = $2", actors[0], actors[1]); err != nil {
+		t.Fatal(err)
+	}
+	inRange := time.Date(2003, 6, 1, 12, 0, 0, 0, time.UTC)
+	outOfRange := time.Date(2003, 6, 3, 12, 0, 0, 0, time.UTC)
+	var wantIds []int
+	for _, actor := range actors {
+		for _, ts := range []time.Time{inRange, inRange.Add(time.Hour), outOfRange} {
+			e, err := data.CreateAuditEvent(th.DB, &data.AuditEventRequest{
+				Actor:        actor,
+				Action:       "update",
+				ResourceType: "user",
+				ResourceId:   "42",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = th.DB.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id = $2", ts, e.Id); err != nil {
+				t.Fatal(err)
+			}
+			if actor == actors[0] && !ts.Equal(outOfRange) {
+				wantIds = append(wantIds, e.Id)
+			}
+		}
+	}
+
+	get := func(query string) map[string]any {
+		req, err := http.NewRequest("GET", "http://localhost:3333/admin/audit/by-actor/"+actors[0]+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+		var page map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	// only the requested actor's events are returned, and to filters out the last
+	page := get("?from=2003-06-01&to=2003-06-02&envelope=true")
+	if int(page["total"].(float64)) != len(wantIds) {
+		t.Fatalf("expected %v events got %v", len(wantIds), page["total"])
+	}
+	for i, item := range page["items"].([]any) {
+		event := item.(map[string]any)
+		if event["actor"] != actors[0] {
+			t.Errorf("expected only events by %v got %v", actors[0], event["actor"])
+		}
+		if int(event["id"].(float64)) != wantIds[i] {
+			t.Errorf("expected event %v got %v", wantIds[i], event["id"])
+		}
+	}
+
+	// pages follow limit and offset, and from is optional
+	page = get("?to=2003-06-02&limit=1&offset=1&envelope=true")
+	items := page["items"].([]any)
+	if len(items) != 1 || int(items[0].(map[string]any)["id"].(float64)) != wantIds[1] {
+		t.Errorf("expected only event %v got %v", wantIds[1], items)
+	}
+}
+
+func TestPurgeAuditArchives(t *testing.T) {
+	th := NewTestDataHandler()
+	h := &AuditHandler{dbConn: th.DB}
+	cutoff := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	e, err := data.CreateAuditEvent(th.DB, &data.AuditEventRequest{
+		Actor:        "testpurgeauditarchives",
+		Action:       "delete",
+		ResourceType: "pirg",
+		ResourceId:   "42",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = th.DB.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id = $2", cutoff.AddDate(-1, 0, 0), e.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	count, err := h.purgeAudit(cutoff, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count < 1 {
+		t.Fatalf("expected at least 1 purged event got %v", count)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 archive file got %v %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var archived AuditExportEvent
+		if err := json.Unmarshal(scanner.Bytes(), &archived); err != nil {
+			t.Fatalf("archive line isn't an export event: %s", scanner.Bytes())
+		}
+		found = found || archived.Id == e.Id
+	}
+	if !found {
+		t.Fatalf("expected event %v in the archive", e.Id)
+	}
+
+	// with nothing left to purge no archive file is left behind
+	if _, err = h.purgeAudit(cutoff, dir); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ = filepath.Glob(filepath.Join(dir, "audit-*.jsonl")); len(files) != 1 {
+		t.Fatalf("expected the empty archive to be removed got %v", files)
+	}
+}
+
+func TestAuditSinkJob(t *testing.T) {
+	th := NewTestDataHandler()
+	var received []AuditExportEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e AuditExportEvent
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Errorf("webhook body isn't an export event: %s", b)
+		}
+		received = append(received, e)
+	}))
+	defer srv.Close()
+
+	webhook := auditsink.NewHTTPSink(config.HTTPSinkConfig{URL: srv.URL}, srv.Client())
+	flaky := &auditsink.Recorder{Err: errors.New("sink is down")}
+	ctx := context.WithValue(context.Background(), keys.DBConnKey, th.DB)
+	job, err := AuditSinkJob(ctx, []auditsink.Sink{webhook, flaky})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := data.CreateAuditEvent(th.DB, &data.AuditEventRequest{
+		Actor:        "testauditsinkjob",
+		Action:       data.AuditActionMemberAdded,
+		ResourceType: "pirg",
+		ResourceId:   "42",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sink failing fails the job but not the other sinks
+	if err := job(context.Background()); err == nil {
+		t.Fatal("expected the failing sink to fail the job")
+	}
+
+	// the event is in the database and reached the webhook
+	var stored int
+	if err := th.DB.QueryRow("SELECT COUNT(*) FROM audit_log WHERE id = $1", e.Id).Scan(&stored); err != nil || stored != 1 {
+		t.Fatalf("expected event %v in the database got %v %v", e.Id, stored, err)
+	}
+	if len(received) != 1 || received[0].Id != e.Id || received[0].Action != data.AuditActionMemberAdded {
+		t.Fatalf("expected the webhook to receive event %v got %v", e.Id, received)
+	}
+
+	// once the failing sink is back it gets the event it missed,
+	// and the webhook doesn't get it twice
+	flaky.Err = nil
+	if err := job(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(flaky.Events()) != 1 {
+		t.Fatalf("expected the recovered sink to get 1 event got %v", len(flaky.Events()))
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected the webhook to get the event once got %v", len(received))
+	}
+}
+
+func TestAPIGetChangelog(t *testing.T) {
+	th := NewTestDataHandler()
+	actor := "testapigetchangelog"
+	if _, err := th.DB.Exec("DELETE FROM audit_log WHERE actor = $1", actor); err != nil {
+		t.Fatal(err)
+	}
+	// changes to each kind of resource, recorded out of time order
+	since := time.Date(2005, 4, 1, 0, 0, 0, 0, time.UTC)
+	changes := []struct {
+		at           time.Time
+		action       string
+		resourceType string
+	}{
+		{since.Add(3 * time.Hour), data.AuditActionMemberAdded, "pirg"},
+		{since.Add(time.Hour), data.AuditActionCreated, "user"},
+		{since.Add(2 * time.Hour), data.AuditActionCreated, "pirg"},
+	}
+	ids := map[time.Time]int{}
+	for _, c := range changes {
+		e, err := data.CreateAuditEvent(th.DB, &data.AuditEventRequest{
+			Actor:        actor,
+			Action:       c.action,
+			ResourceType: c.resourceType,
+			ResourceId:   "1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = th.DB.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id = $2", c.at, e.Id); err != nil {
+			t.Fatal(err)
+		}
+		ids[c.at] = e.Id
+	}
+	want := []int{ids[since.Add(time.Hour)], ids[since.Add(2*time.Hour)], ids[since.Add(3*time.Hour)]}
+
+	get := func(query string) map[string]any {
+		req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/changelog"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+		var page map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	// pages of two follow the cursor, and the changes come back in time order
+	var got []int
+	page := get("?since=2005-04-01T00:00:00Z&limit=2&envelope=true")
+	for _, item := range page["items"].([]any) {
+		got = append(got, int(item.(map[string]any)["id"].(float64)))
+	}
+	cursor, _ := page["next_cursor"].(string)
+	if cursor == "" {
+		t.Fatal("expected a next cursor")
+	}
+	page = get("?limit=2&envelope=true&cursor=" + cursor)
+	for _, item := range page["items"].([]any) {
+		got = append(got, int(item.(map[string]any)["id"].(float64)))
+	}
+	if len(got) < len(want) {
+		t.Fatalf("expected at least %v changes got %v", len(want), got)
+	}
+	for i, id := range want {
+		if got[i] != id {
+			t.Errorf("expected change %v at %v got %v", id, i, got[i])
+		}
+	}
+}
+
+func TestGetChangelogRejects(t *testing.T) {
+	h := &AuditHandler{}
+	tests := []struct {
+		name   string
+		role   string
+		query  string
+		status int
+	}{
+		{"NotAdmin", "user", "", http.StatusForbidden},
+		{"InvalidSince", "admin", "?since=yesterday", http.StatusBadRequest},
+		{"InvalidCursor", "admin", "?cursor=nope", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/changelog"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), keys.RoleKey, tt.role))
+			w := httptest.NewRecorder()
+			h.GetChangelog(w, req)
+			if w.Code != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestChangelogCursor(t *testing.T) {
+	at := time.Date(2005, 4, 1, 12, 30, 0, 123456000, time.UTC)
+	gotTime, gotId, err := decodeChangelogCursor(encodeChangelogCursor(at, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotTime.Equal(at) || gotId != 42 {
+		t.Errorf("expected %v and 42 got %v and %v", at, gotTime, gotId)
+	}
+}
+
+func TestAPIGetAuditLog(t *testing.T) {
+	th := NewTestDataHandler()
+	last, err := data.GetLastAuditEventId(th.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := data.CreateUser(th.DB, &data.UserRequest{Username: "testapigetauditlog", Email: "testapigetauditlog@localhost", FirstName: "Test", LastName: "Audit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a mutating request records who made it
+	body := `{"filter": {"username": "testapigetauditlog"}, "attributes": {"sponsor": "testapigetauditlog"}}`
+	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/users/attributes/bulk", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", "testkey1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	// page through everything recorded since, two events at a time
+	var events []AuditExportEvent
+	cursor := encodeCursor(last)
+	for pages := 0; cursor != "" && pages < 100; pages++ {
+		req, err := http.NewRequest("GET", "http://localhost:3333/admin/audit?limit=2&envelope=true&cursor="+cursor, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page struct {
+			Items      []AuditExportEvent `json:"items"`
+			NextCursor string             `json:"next_cursor"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+		if len(page.Items) > 2 {
+			t.Fatalf("expected at most 2 events per page got %v", len(page.Items))
+		}
+		events = append(events, page.Items...)
+		cursor = page.NextCursor
+	}
+	found := false
+	for i, e := range events {
+		if i > 0 && e.Id <= events[i-1].Id {
+			t.Errorf("expected events oldest first got %v after %v", e.Id, events[i-1].Id)
+		}
+		if e.Action == data.AuditActionAttributesSet && strings.Contains(string(e.Details), user.Username) {
+			found = true
+			if e.Actor == "" || e.Actor == "unknown" {
+				t.Errorf("expected the event to record the actor got %q", e.Actor)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the bulk attribute change in the audit log got %+v", events)
+	}
+}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+)
+
+// MaxRequestBody limits request bodies to limit bytes. Reading past it fails
+// with an *http.MaxBytesError, which ErrBind turns into a 413, so an
+// oversized body is never read into memory.
+func MaxRequestBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
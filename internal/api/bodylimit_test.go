@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxRequestBody(t *testing.T) {
+	const limit = 64
+	h := &UserHandler{}
+	handler := MaxRequestBody(limit)(http.HandlerFunc(h.CreateUser))
+
+	body := `{"username": "testbodylimit", "email": "` + strings.Repeat("a", limit) + `@example.com"}`
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	var resp ErrResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected a JSON error body got %s: %v", w.Body.String(), err)
+	}
+	if !strings.Contains(resp.Message, "64 bytes") {
+		t.Errorf("expected the limit in the error got %q", resp.Message)
+	}
+
+	// bodies that aren't over the limit are still bound, and fail validation
+	req = httptest.NewRequest("POST", "/users", strings.NewReader(`{"username": "testbodylimit"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusUnprocessableEntity)
+	}
+}
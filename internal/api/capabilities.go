@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/flags"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/maintenance"
+)
+
+// auditExportFormats are the formats /admin/audit/export can produce
+var auditExportFormats = []string{"jsonl"}
+
+// CapabilitiesResponse describes the optional features this instance has
+// turned on, so clients can adapt to it. It's derived from the config and
+// never includes secrets.
+type CapabilitiesResponse struct {
+	APIVersion    string                 `json:"api_version"`
+	Auth          AuthCapabilities       `json:"auth"`
+	Pagination    PaginationCapabilities `json:"pagination"`
+	Modules       ModuleCapabilities     `json:"modules"`
+	ExportFormats []string               `json:"export_formats"`
+	// FeatureFlags are the flags that are on for the caller
+	FeatureFlags []string `json:"feature_flags"`
+	// Maintenance is the current maintenance banner, or null
+	Maintenance *maintenance.Banner `json:"maintenance"`
+}
+
+func (c *CapabilitiesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type AuthCapabilities struct {
+	APIKey             bool `json:"api_key"`
+	Oauth              bool `json:"oauth"`
+	ClientCert         bool `json:"client_cert"`
+	RequireClientCert  bool `json:"require_client_cert"`
+	IdentityResolution bool `json:"identity_resolution"`
+	TLS                bool `json:"tls"`
+}
+
+type PaginationCapabilities struct {
+	Style        string `json:"style"`
+	DefaultLimit int    `json:"default_limit"`
+	MaxLimit     int    `json:"max_limit"`
+	Envelope     bool   `json:"envelope"`
+}
+
+type ModuleCapabilities struct {
+	DefaultPirg      bool `json:"default_pirg"`
+	UidAllocation    bool `json:"uid_allocation"`
+	GidAllocation    bool `json:"gid_allocation"`
+	MembershipExpiry bool `json:"membership_expiry"`
+	AuditRetention   bool `json:"audit_retention"`
+	Partitions       bool `json:"partitions"`
+}
+
+type CapabilitiesHandler struct {
+	cfg    *config.ServerConfig
+	flags  *flags.Evaluator
+	pages  pageLimits
+	notice *maintenance.Notice
+}
+
+func CapabilitiesRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newCapabilitiesHandler(ctx)
+	r.Get("/", h.GetCapabilities)
+	return r
+}
+
+func newCapabilitiesHandler(ctx context.Context) *CapabilitiesHandler {
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	evaluator := ctx.Value(keys.FlagsKey).(*flags.Evaluator)
+	notice := ctx.Value(keys.MaintenanceKey).(*maintenance.Notice)
+	return &CapabilitiesHandler{
+		cfg:    cfg,
+		flags:  evaluator,
+		pages:  newPageLimits(cfg.Pagination, cfg.ListEnvelope),
+		notice: notice,
+	}
+}
+
+// GetCapabilities returns the features this instance supports
+func (h *CapabilitiesHandler) GetCapabilities(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting capabilities", "package", "api", "method", "GetCapabilities")
+	cfg := h.cfg
+	// the page sizes can depend on the caller's role
+	pages := h.pages.forRequest(r)
+	resp := &CapabilitiesResponse{
+		APIVersion: "v1",
+		Auth: AuthCapabilities{
+			APIKey:             true,
+			Oauth:              cfg.Oauth.TenantID != "" && cfg.Oauth.ClientID != "",
+			ClientCert:         cfg.TLS.ClientCAFile != "",
+			RequireClientCert:  cfg.TLS.RequireClientCert,
+			IdentityResolution: cfg.Identity.UserinfoURL != "",
+			TLS:                cfg.TLS.CertFile != "",
+		},
+		Pagination: PaginationCapabilities{
+			Style:        "offset",
+			DefaultLimit: pages.defaultLimit,
+			MaxLimit:     pages.maxLimit,
+			Envelope:     pages.envelope,
+		},
+		Modules: ModuleCapabilities{
+			DefaultPirg:      cfg.DefaultPirg != "",
+			UidAllocation:    cfg.PosixIds.UidMin > 0,
+			GidAllocation:    cfg.PosixIds.GidMin > 0,
+			MembershipExpiry: true,
+			AuditRetention:   cfg.AuditRetentionDays > 0,
+			Partitions:       len(cfg.Partitions) > 0,
+		},
+		ExportFormats: auditExportFormats,
+		FeatureFlags:  h.flags.EnabledFlags(r.Context()),
+		Maintenance:   h.notice.Get(),
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
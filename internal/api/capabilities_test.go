@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/flags"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/maintenance"
+)
+
+func getTestCapabilities(t *testing.T, cfg *config.ServerConfig, role string) (CapabilitiesResponse, string) {
+	t.Helper()
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, cfg)
+	ctx = context.WithValue(ctx, keys.FlagsKey, flags.NewEvaluator(cfg.FeatureFlags))
+	ctx = context.WithValue(ctx, keys.MaintenanceKey, maintenance.NewNotice())
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), keys.RoleKey, role))
+	w := httptest.NewRecorder()
+	CapabilitiesRouter(ctx).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	var resp CapabilitiesResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestGetCapabilities(t *testing.T) {
+	disabled := false
+	cfg := &config.ServerConfig{
+		Oauth: config.OauthConfig{TenantID: "tenant", ClientID: "client", ClientSecret: "supersecretvalue"},
+		DB:    config.DatabaseConfig{Password: "supersecretvalue"},
+		TLS: config.TLSConfig{
+			CertFile:     "/etc/hpcadmin/cert.pem",
+			KeyFile:      "/etc/hpcadmin/key.pem",
+			ClientCAFile: "/etc/hpcadmin/ca.pem",
+		},
+		Pagination:         config.PaginationConfig{DefaultLimit: 20, MaxLimit: 100},
+		ListEnvelope:       &disabled,
+		PosixIds:           config.PosixIdConfig{UidMin: 50000, UidMax: 59999},
+		AuditRetentionDays: 30,
+		FeatureFlags: map[string]config.FeatureFlagConfig{
+			"admins-only": {Roles: []string{"admin"}},
+			"everyone":    {Enabled: true},
+		},
+	}
+
+	caps, body := getTestCapabilities(t, cfg, "admin")
+	if !caps.Auth.Oauth || !caps.Auth.ClientCert || !caps.Auth.TLS || caps.Auth.RequireClientCert || caps.Auth.IdentityResolution {
+		t.Errorf("unexpected auth capabilities %+v", caps.Auth)
+	}
+	if !caps.Modules.UidAllocation || caps.Modules.GidAllocation || !caps.Modules.AuditRetention || caps.Modules.DefaultPirg {
+		t.Errorf("unexpected module capabilities %+v", caps.Modules)
+	}
+	if caps.Pagination.DefaultLimit != 20 || caps.Pagination.MaxLimit != 100 || caps.Pagination.Envelope {
+		t.Errorf("unexpected pagination capabilities %+v", caps.Pagination)
+	}
+	if strings.Join(caps.FeatureFlags, ",") != "admins-only,everyone" {
+		t.Errorf("expected both flags for an admin got %v", caps.FeatureFlags)
+	}
+	if strings.Contains(body, "supersecretvalue") || strings.Contains(body, "/etc/hpcadmin") {
+		t.Errorf("expected no secrets or paths in capabilities got %s", body)
+	}
+
+	// flags are reported for the caller
+	caps, _ = getTestCapabilities(t, cfg, "user")
+	if strings.Join(caps.FeatureFlags, ",") != "everyone" {
+		t.Errorf("expected only the everyone flag for a user got %v", caps.FeatureFlags)
+	}
+
+	// page sizes are reported for the caller's role
+	cfg.Pagination.Roles = map[string]config.RolePaginationConfig{"sync-service": {DefaultLimit: 1000, MaxLimit: 5000}}
+	service, _ := getTestCapabilities(t, cfg, "sync-service")
+	interactive, _ := getTestCapabilities(t, cfg, "user")
+	if service.Pagination.DefaultLimit != 1000 || service.Pagination.MaxLimit != 5000 {
+		t.Errorf("expected the sync-service page sizes got %+v", service.Pagination)
+	}
+	if interactive.Pagination.DefaultLimit != 20 || interactive.Pagination.MaxLimit != 100 {
+		t.Errorf("expected the global page sizes for a user got %+v", interactive.Pagination)
+	}
+
+	// and an instance with nothing optional configured reports that
+	caps, _ = getTestCapabilities(t, &config.ServerConfig{}, "user")
+	if caps.Auth.Oauth || caps.Auth.TLS || caps.Modules.UidAllocation || caps.Modules.AuditRetention || !caps.Pagination.Envelope {
+		t.Errorf("expected defaults for an empty config got %+v", caps)
+	}
+	if caps.FeatureFlags == nil || len(caps.FeatureFlags) != 0 {
+		t.Errorf("expected an empty flag list got %v", caps.FeatureFlags)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// textMediaTypes are the non text/* media types that carry text and get a
+// charset. Types with a +json or +xml suffix are text too.
+var textMediaTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/yaml":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+}
+
+// Charset adds "; charset=utf-8" to the Content-Type of every text response
+// that doesn't already name a charset, for clients that won't assume one.
+// Binary responses are left alone. If include is false it does nothing.
+func Charset(include bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !include {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&charsetWriter{ResponseWriter: w}, r)
+		})
+	}
+}
+
+// charsetWriter sets the charset just before the headers are sent,
+// since handlers set the Content-Type after the middleware runs
+type charsetWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *charsetWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		addCharset(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *charsetWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming through the wrapper
+func (w *charsetWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *charsetWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func addCharset(h http.Header) {
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil || params["charset"] != "" {
+		return
+	}
+	if strings.HasPrefix(mediaType, "text/") || textMediaTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		h.Set("Content-Type", ct+"; charset=utf-8")
+	}
+}
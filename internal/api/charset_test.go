@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/render"
+)
+
+func TestCharset(t *testing.T) {
+	handler := func(contentType string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if contentType == "" {
+				render.JSON(w, r, map[string]string{"status": "ok"})
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte("body"))
+		})
+	}
+	tests := []struct {
+		name        string
+		include     bool
+		contentType string
+		want        string
+	}{
+		{name: "JSON", include: true, want: "application/json; charset=utf-8"},
+		{name: "JSONDisabled", include: false, want: "application/json"},
+		{name: "NDJSON", include: true, contentType: "application/x-ndjson", want: "application/x-ndjson; charset=utf-8"},
+		{name: "CSV", include: true, contentType: "text/csv", want: "text/csv; charset=utf-8"},
+		{name: "Script", include: true, contentType: "text/x-shellscript", want: "text/x-shellscript; charset=utf-8"},
+		{name: "ExistingCharset", include: true, contentType: "text/plain; charset=iso-8859-1", want: "text/plain; charset=iso-8859-1"},
+		{name: "Binary", include: true, contentType: "application/octet-stream", want: "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Charset(tt.include)(handler(tt.contentType)).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if got := w.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("expected %q got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCharsetFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	Charset(true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.(http.Flusher).Flush()
+	})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.Flushed {
+		t.Error("expected the flush to reach the underlying writer")
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson; charset=utf-8" {
+		t.Errorf("expected the charset before the flush got %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// maxConfigValidateBytes caps the size of a config submitted for validation
+const maxConfigValidateBytes = 1 << 20
+
+type ConfigValidationResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+func (c *ConfigValidationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// ValidateConfig checks the YAML or JSON config in the request body and reports
+// every problem found. Settings it leaves out take their defaults, environment
+// overrides aren't applied, and nothing about the running config changes. The body holds secrets, so it's never logged.
+func ValidateConfig(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("validating submitted config", "package", "api", "method", "ValidateConfig")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigValidateBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		render.Render(w, r, ErrRequestTooLarge(tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("failed to read config: %v", err)))
+		return
+	}
+	if len(body) == 0 {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("missing config in request body")))
+		return
+	}
+	resp := &ConfigValidationResponse{Errors: []string{}}
+	cfg, err := config.Parse(body, true)
+	if err != nil {
+		resp.Errors = append(resp.Errors, err.Error())
+	} else {
+		for _, err := range config.ValidationErrors(cfg) {
+			resp.Errors = append(resp.Errors, err.Error())
+		}
+	}
+	resp.Valid = len(resp.Errors) == 0
+	slog.Debug("validated submitted config", "valid", resp.Valid, "error_count", len(resp.Errors), "package", "api", "method", "ValidateConfig")
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
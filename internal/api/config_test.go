@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const validTestConfig = `
+host: localhost
+port: 3333
+database:
+  host: localhost
+  port: 5432
+  user: hpcadmin
+  password: hunter2
+  dbname: hpcadmin
+oauth:
+  tenant_id: mock
+  client_id: mock
+  client_secret: mock
+`
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantValid  bool
+		wantErrors []string
+	}{
+		{"Valid", validTestConfig, http.StatusOK, true, nil},
+		{"ValidJSON", `{"host": "localhost", "port": 3333, "database": {"host": "db", "port": 5432, "user": "u", "password": "p", "dbname": "d"}, "oauth": {"tenant_id": "t", "client_id": "c", "client_secret": "s"}}`, http.StatusOK, true, nil},
+		{"AggregatesErrors", "host: localhost\nport: 0\nmax_header_bytes: -1\n", http.StatusOK, false, []string{"missing port", "missing database user", "max_header_bytes must not be negative"}},
+		{"UnknownField", validTestConfig + "not_a_setting: true\n", http.StatusOK, false, []string{"not_a_setting"}},
+		{"Unparseable", "port: [", http.StatusOK, false, []string{"failed to load configuration"}},
+		{"Empty", "", http.StatusBadRequest, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/config/validate", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			ValidateConfig(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp ConfigValidationResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Valid != tt.wantValid {
+				t.Fatalf("expected valid %v got %v with errors %v", tt.wantValid, resp.Valid, resp.Errors)
+			}
+			all := strings.Join(resp.Errors, "\n")
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(all, want) {
+					t.Errorf("expected an error containing %q got %v", want, resp.Errors)
+				}
+			}
+			if strings.Contains(all, "hunter2") {
+				t.Errorf("expected the password not to be echoed back, got %v", resp.Errors)
+			}
+		})
+	}
+}
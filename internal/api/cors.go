@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// defaultCORSMethods are the methods allowed if allowed_methods isn't set
+var defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// corsHeaders are the request headers browsers may send, the ones auth and
+// request ids are read from
+const corsHeaders = "Authorization, Content-Type, X-Api-Key, X-Request-Id"
+
+// corsMaxAge is how long, in seconds, browsers can cache a preflight response
+const corsMaxAge = "600"
+
+// CORS adds the Access-Control-Allow-* headers for the configured origins, and
+// answers preflight OPTIONS requests itself so they don't need to authenticate.
+// Requests from other origins get no CORS headers, so the browser blocks
+// them. It does nothing if no origins are allowed.
+func CORS(cfg config.CORSConfig) func(http.Handler) http.Handler {
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	allowMethods := strings.Join(methods, ", ")
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	return func(next http.Handler) http.Handler {
+		if len(cfg.AllowedOrigins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+			allowed := origin != "" && (anyOrigin || slices.Contains(cfg.AllowedOrigins, origin))
+			if !preflight {
+				if allowed {
+					setCORSOrigin(w, cfg, anyOrigin, origin)
+					w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id")
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allowed && slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+				setCORSOrigin(w, cfg, anyOrigin, origin)
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// setCORSOrigin allows the origin. Credentials are never allowed with *,
+// config.Validate refuses that.
+func setCORSOrigin(w http.ResponseWriter, cfg config.CORSConfig, anyOrigin bool, origin string) {
+	if anyOrigin && !cfg.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if cfg.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
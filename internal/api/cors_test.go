@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestCORS(t *testing.T) {
+	// stands in for auth, preflight requests must not reach it
+	authed := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Api-Key") == "" {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	newRouter := func(cfg config.CORSConfig) http.Handler {
+		r := chi.NewRouter()
+		r.Group(func(r chi.Router) {
+			r.Use(CORS(cfg))
+			r.Use(authed)
+			r.Route("/api/v1", func(r chi.Router) {
+				r.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+			})
+		})
+		return r
+	}
+	do := func(h http.Handler, method string, origin string, requestMethod string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestMethod)
+		} else {
+			req.Header.Set("X-Api-Key", "key")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		h := newRouter(config.CORSConfig{})
+		w := do(h, "GET", "https://admin.example.com", "")
+		if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "" {
+			t.Errorf("expected no CORS headers got %v", w.Header())
+		}
+		if w := do(h, "OPTIONS", "https://admin.example.com", "GET"); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected preflight to be left to the router got %v", w.Code)
+		}
+	})
+
+	h := newRouter(config.CORSConfig{AllowedOrigins: []string{"https://admin.example.com"}, AllowCredentials: true})
+	t.Run("Preflight", func(t *testing.T) {
+		w := do(h, "OPTIONS", "https://admin.example.com", "DELETE")
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusNoContent)
+		}
+		want := map[string]string{
+			"Access-Control-Allow-Origin":      "https://admin.example.com",
+			"Access-Control-Allow-Methods":     "GET, POST, PUT, PATCH, DELETE",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Headers":     corsHeaders,
+		}
+		for k, v := range want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("expected %s %q got %q", k, v, got)
+			}
+		}
+	})
+	t.Run("PreflightOtherOrigin", func(t *testing.T) {
+		w := do(h, "OPTIONS", "https://evil.example.com", "GET")
+		if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("expected preflight from another origin to not be allowed got %v %v", w.Code, w.Header())
+		}
+	})
+	t.Run("PreflightOtherMethod", func(t *testing.T) {
+		h := newRouter(config.CORSConfig{AllowedOrigins: []string{"https://admin.example.com"}, AllowedMethods: []string{"GET"}})
+		if w := do(h, "OPTIONS", "https://admin.example.com", "DELETE"); w.Header().Get("Access-Control-Allow-Methods") != "" {
+			t.Errorf("expected DELETE to not be allowed got %v", w.Header())
+		}
+	})
+	t.Run("Request", func(t *testing.T) {
+		w := do(h, "GET", "https://admin.example.com", "")
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://admin.example.com" {
+			t.Errorf("expected the origin to be allowed got %v %v", w.Code, w.Header())
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("expected Vary: Origin got %q", w.Header().Get("Vary"))
+		}
+		if w := do(h, "GET", "https://evil.example.com", ""); w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("expected another origin to not be allowed got %v", w.Header())
+		}
+	})
+	t.Run("AnyOrigin", func(t *testing.T) {
+		h := newRouter(config.CORSConfig{AllowedOrigins: []string{"*"}})
+		if w := do(h, "GET", "https://other.example.com", ""); w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("expected any origin to be allowed got %v", w.Header())
+		}
+	})
+}
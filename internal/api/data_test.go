@@ -45,6 +45,8 @@ func NewTestDataHandler() *testDataHandler {
 		Password:   password,
 		DBName:     dbname,
 		DisableSSL: true,
+		// the test database should already be up, so fail fast
+		ConnectAttempts: 1,
 	}
 	db, err := data.NewDBConn(dbr)
 	if err != nil {
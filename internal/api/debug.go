@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// processStart is when the server started, for the uptime of /debug/vars
+var processStart = time.Now()
+
+// DebugRouter serves the pprof profiles at /pprof and runtime stats at /vars
+// if debug.pprof_enabled is set, otherwise everything under it is a 404.
+// It's mounted in the AdminRouter, so only admins can reach it.
+func DebugRouter(ctx context.Context) chi.Router {
+	r := chi.NewRouter()
+	r.NotFound(NotFound)
+	cfg, _ := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	if cfg == nil || !cfg.Debug.PprofEnabled {
+		return r
+	}
+
+	// pprof.Index links to the profiles relative to itself, and only looks
+	// up the profile named in the path under /debug/pprof/, so the named
+	// profiles get their own handlers
+	r.Get("/pprof", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.RequestURI+"/", http.StatusMovedPermanently)
+	})
+	r.HandleFunc("/pprof/", pprof.Index)
+	r.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/pprof/profile", pprof.Profile)
+	r.HandleFunc("/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/pprof/trace", pprof.Trace)
+	r.Get("/pprof/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(chi.URLParam(r, "profile")).ServeHTTP(w, r)
+	})
+	r.Get("/vars", GetDebugVars)
+	return r
+}
+
+// DebugVarsResponse is a snapshot of the server's runtime
+type DebugVarsResponse struct {
+	Goroutines    int              `json:"goroutines"`
+	StartedAt     time.Time        `json:"started_at"`
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	GoVersion     string           `json:"go_version"`
+	Memory        DebugMemoryStats `json:"memory"`
+}
+
+// DebugMemoryStats are the heap stats of runtime.MemStats, in bytes
+type DebugMemoryStats struct {
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapSys      uint64 `json:"heap_sys"`
+	HeapIdle     uint64 `json:"heap_idle"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapReleased uint64 `json:"heap_released"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+}
+
+func (d *DebugVarsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// GetDebugVars returns the goroutine count, heap stats and uptime of the server
+func GetDebugVars(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	render.Render(w, r, &DebugVarsResponse{
+		Goroutines:    runtime.NumGoroutine(),
+		StartedAt:     processStart.UTC(),
+		UptimeSeconds: int64(time.Since(processStart).Seconds()),
+		GoVersion:     runtime.Version(),
+		Memory: DebugMemoryStats{
+			HeapAlloc:    m.HeapAlloc,
+			HeapSys:      m.HeapSys,
+			HeapIdle:     m.HeapIdle,
+			HeapInuse:    m.HeapInuse,
+			HeapReleased: m.HeapReleased,
+			HeapObjects:  m.HeapObjects,
+			TotalAlloc:   m.TotalAlloc,
+			Sys:          m.Sys,
+			NumGC:        m.NumGC,
+			PauseTotalNs: m.PauseTotalNs,
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestDebugRouter(t *testing.T) {
+	paths := []string{"/pprof/", "/pprof/cmdline", "/pprof/goroutine", "/vars"}
+	for _, enabled := range []bool{false, true} {
+		cfg := &config.ServerConfig{Debug: config.DebugConfig{PprofEnabled: enabled}}
+		debug := DebugRouter(context.WithValue(context.Background(), keys.ConfigKey, cfg))
+		for _, path := range paths {
+			w := httptest.NewRecorder()
+			debug.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			want := http.StatusNotFound
+			if enabled {
+				want = http.StatusOK
+			}
+			if w.Code != want {
+				t.Errorf("pprof_enabled %v: GET %s returned %d, want %d", enabled, path, w.Code, want)
+			}
+		}
+	}
+
+	cfg := &config.ServerConfig{Debug: config.DebugConfig{PprofEnabled: true}}
+	debug := DebugRouter(context.WithValue(context.Background(), keys.ConfigKey, cfg))
+	w := httptest.NewRecorder()
+	debug.ServeHTTP(w, httptest.NewRequest("GET", "/vars", nil))
+	var vars DebugVarsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Goroutines < 1 || vars.Memory.HeapAlloc == 0 || vars.StartedAt.IsZero() {
+		t.Errorf("expected runtime stats got %+v", vars)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// DryRunChange is one kind of change a dry run found. Ids are the ids of the
+// resources the action applies to, and Names the usernames of users it would
+// create, since they don't have an id yet.
+type DryRunChange struct {
+	ResourceType string   `json:"resource_type"`
+	Action       string   `json:"action"`
+	Ids          []int    `json:"ids,omitempty"`
+	Names        []string `json:"names,omitempty"`
+}
+
+// DryRunResponse is returned instead of the usual response by the operations
+// that don't have one of their own to flag a dry run with
+type DryRunResponse struct {
+	Changes []*DryRunChange `json:"changes"`
+	DryRun  bool            `json:"dry_run"`
+}
+
+func (d *DryRunResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// dryRunRequested is whether the request asks for a dry run with
+// ?dry_run=true. The data functions roll back their transaction for one, so
+// the handlers only have to skip auditing and notifying about the changes.
+func dryRunRequested(r *http.Request) bool {
+	return r.URL.Query().Get("dry_run") == "true"
+}
+
+// userDryRunChange is the change of action to users, by id, or by username
+// for users that would be created
+func userDryRunChange(action string, users []*data.User) *DryRunChange {
+	c := &DryRunChange{ResourceType: "user", Action: action}
+	for _, u := range users {
+		if action == data.AuditActionCreated {
+			c.Names = append(c.Names, u.Username)
+			continue
+		}
+		c.Ids = append(c.Ids, u.Id)
+	}
+	return c
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestDryRunRequested(t *testing.T) {
+	for target, want := range map[string]bool{
+		"/users/1":                                   false,
+		"/users/1?dry_run=true":                      true,
+		"/users/1?dry_run=false":                     false,
+		"/users/1?apply=true":                        false,
+		"/users/1?include_deleted=true&dry_run=true": true,
+	} {
+		if got := dryRunRequested(httptest.NewRequest("DELETE", target, nil)); got != want {
+			t.Errorf("%s: expected %v got %v", target, want, got)
+		}
+	}
+}
+
+func TestUserDryRunChange(t *testing.T) {
+	users := []*data.User{{Id: 1, Username: "a"}, {Id: 2, Username: "b"}}
+	created := userDryRunChange(data.AuditActionCreated, users)
+	if created.Ids != nil || !slices.Equal(created.Names, []string{"a", "b"}) {
+		t.Errorf("expected created users by username got %+v", created)
+	}
+	deleted := userDryRunChange(data.AuditActionDeleted, users)
+	if deleted.Names != nil || !slices.Equal(deleted.Ids, []int{1, 2}) || deleted.ResourceType != "user" {
+		t.Errorf("expected deleted users by id got %+v", deleted)
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/errorlog"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type RecentErrorsResponse struct {
+	Errors []errorlog.Entry `json:"errors"`
+}
+
+func (e *RecentErrorsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type ErrorLogHandler struct {
+	errorLog *errorlog.Buffer
+}
+
+func newErrorLogHandler(ctx context.Context) *ErrorLogHandler {
+	errorLog := ctx.Value(keys.ErrorLogKey).(*errorlog.Buffer)
+	return &ErrorLogHandler{errorLog: errorLog}
+}
+
+// GetRecentErrors returns the most recent 5xx responses, newest first
+func (h *ErrorLogHandler) GetRecentErrors(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting recent errors", "package", "api", "method", "GetRecentErrors")
+	resp := &RecentErrorsResponse{Errors: h.errorLog.Recent()}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
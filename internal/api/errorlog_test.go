@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/errorlog"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestGetRecentErrors(t *testing.T) {
+	errorLog := errorlog.NewBuffer(2)
+	h := newErrorLogHandler(context.WithValue(context.Background(), keys.ErrorLogKey, errorLog))
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(errorLog.Middleware)
+	r.Get("/users/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, ErrInternal(errors.New("connection refused")))
+	})
+	r.Get("/admin/errors/recent", h.GetRecentErrors)
+
+	getRecent := func() RecentErrorsResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/admin/errors/recent", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+		}
+		var resp RecentErrorsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := getRecent(); resp.Errors == nil || len(resp.Errors) != 0 {
+		t.Fatalf("expected an empty list got %+v", resp.Errors)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users/1", nil))
+	var errResp ErrResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+		t.Fatal(err)
+	}
+	resp := getRecent()
+	if len(resp.Errors) != 1 {
+		t.Fatalf("expected the 500 to be recorded got %+v", resp.Errors)
+	}
+	e := resp.Errors[0]
+	if e.Route != "/users/{userID}" || e.Status != http.StatusInternalServerError || e.RequestId != errResp.RequestId || e.Error != "connection refused" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	// the oldest entries roll off
+	for i := 0; i < 2; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/2", nil))
+	}
+	resp = getRecent()
+	if len(resp.Errors) != 2 || resp.Errors[0].RequestId == errResp.RequestId || resp.Errors[1].RequestId == errResp.RequestId {
+		t.Errorf("expected the first error to roll off got %+v", resp.Errors)
+	}
+}
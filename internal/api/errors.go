@@ -1,58 +1,221 @@
 package api
 
 import (
-	"github.com/go-chi/render"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/errorlog"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
 )
 
 //--
 // Error response payloads & renderers
 //--
 
-// ErrResponse renderer type for handling all sorts of errors.
-//
-// In the best case scenario, the excellent github.com/pkg/errors package
-// helps reveal information on the error, setting it on Err, and in the Render()
-// method, using it to set the application-specific error code in AppCode.
+// ErrResponse is the body of every error response, so clients can handle
+// them all the same way. Status is always the response's status code, and
+// Code is a stable, machine readable name for the kind of error. Message
+// describes this particular error and can change between releases.
 type ErrResponse struct {
-	Err            error `json:"-"` // low-level runtime error
-	HTTPStatusCode int   `json:"-"` // http response status code
+	Err error `json:"-"` // low-level runtime error
+
+	HTTPStatusCode int    `json:"status"`               // http response status code
+	Code           string `json:"error"`                // stable error code, such as not_found
+	Message        string `json:"message"`              // what went wrong, for people
+	RequestId      string `json:"request_id,omitempty"` // correlates a 500 with the server log
 
-	StatusText string `json:"status"`          // user-level status message
-	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
-	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+	ServerTime *time.Time `json:"server_time,omitempty"` // the server's clock, for expired tokens
+
+	Fields []data.FieldError `json:"fields,omitempty"` // what's wrong with each invalid field
 }
 
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	render.Status(r, e.HTTPStatusCode)
+	if e.HTTPStatusCode == http.StatusInternalServerError {
+		e.RequestId = middleware.GetReqID(r.Context())
+		slog.Error("internal server error", "request_id", e.RequestId, "path", r.URL.Path, "error", e.Err, "package", "api", "method", "ErrResponse.Render")
+		errorlog.SetError(r.Context(), e.Err)
+		if expose, _ := r.Context().Value(keys.ExposeErrorsKey).(bool); !expose {
+			e.Message = fmt.Sprintf("an internal error occurred, see request id %s in the server log", e.RequestId)
+			if e.RequestId == "" {
+				e.Message = "an internal error occurred"
+			}
+		}
+	}
 	return nil
 }
 
+// InternalErrorDetail sets whether 500 responses include the underlying error.
+// Otherwise they only carry the request id, and the error is only logged.
+func InternalErrorDetail(expose bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), keys.ExposeErrorsKey, expose)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func ErrInvalidRequest(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
 		HTTPStatusCode: 400,
-		StatusText:     "Invalid request.",
-		ErrorText:      err.Error(),
+		Code:           "invalid_request",
+		Message:        err.Error(),
+	}
+}
+
+// ErrBind is the error for a request body that couldn't be bound: a 413 if
+// it was over the size limit, a 422 if fields failed validation, otherwise a 400
+func ErrBind(err error) render.Renderer {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrRequestTooLarge(tooLarge.Limit)
 	}
+	var invalid *data.ValidationError
+	if errors.As(err, &invalid) {
+		return ErrValidation(invalid)
+	}
+	return ErrInvalidRequest(err)
 }
 
-func ErrInternalServer(err error) render.Renderer {
+func ErrValidation(err *data.ValidationError) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 422,
+		Code:           "validation_failed",
+		Message:        err.Error(),
+		Fields:         err.Fields,
+	}
+}
+
+func ErrRequestTooLarge(limit int64) render.Renderer {
+	return &ErrResponse{
+		HTTPStatusCode: 413,
+		Code:           "request_too_large",
+		Message:        fmt.Sprintf("request body must not exceed %d bytes", limit),
+	}
+}
+
+// ErrInternal is the error for a failure that isn't the client's fault, a
+// 504 if it was a database query timing out, otherwise a 500
+func ErrInternal(err error) render.Renderer {
+	if errors.Is(err, data.ErrQueryTimeout) {
+		return ErrGatewayTimeout(err)
+	}
 	return &ErrResponse{
 		Err:            err,
 		HTTPStatusCode: 500,
-		StatusText:     "Internal Server Error.",
-		ErrorText:      err.Error(),
+		Code:           "internal_error",
+		Message:        err.Error(),
+	}
+}
+
+func ErrTooManyRequests(retryAfter int) render.Renderer {
+	return &ErrResponse{
+		HTTPStatusCode: 429,
+		Code:           "rate_limited",
+		Message:        fmt.Sprintf("too many requests, retry after %d seconds", retryAfter),
+	}
+}
+
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 409,
+		Code:           "conflict",
+		Message:        err.Error(),
+	}
+}
+
+// VersionConflictResponse is the 409 of an update based on a version of the
+// record that's no longer current. Current is the record as it is now, so
+// the client can reapply their change to it and retry with its version.
+type VersionConflictResponse struct {
+	*ErrResponse
+	Current render.Renderer `json:"current"`
+}
+
+// Render does nothing itself, render renders the ErrResponse and Current
+// as the fields of it that are Renderers
+func (e *VersionConflictResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func ErrVersionConflict(current render.Renderer) render.Renderer {
+	return &VersionConflictResponse{
+		ErrResponse: &ErrResponse{
+			Err:            data.ErrVersionConflict,
+			HTTPStatusCode: 409,
+			Code:           "version_conflict",
+			Message:        data.ErrVersionConflict.Error(),
+		},
+		Current: current,
+	}
+}
+
+func ErrBadGateway(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 502,
+		Code:           "bad_gateway",
+		Message:        err.Error(),
 	}
 }
 
+func ErrGatewayTimeout(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 504,
+		Code:           "gateway_timeout",
+		Message:        err.Error(),
+	}
+}
+
+// ErrLookup is the error for looking something up that failed, a 504 if
+// the query timed out, otherwise a 404
+func ErrLookup(err error) render.Renderer {
+	if errors.Is(err, data.ErrQueryTimeout) {
+		return ErrGatewayTimeout(err)
+	}
+	return ErrNotFound
+}
+
 func ErrRender(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
 		HTTPStatusCode: 422,
-		StatusText:     "Error rendering response.",
-		ErrorText:      err.Error(),
+		Code:           "render_failed",
+		Message:        err.Error(),
 	}
 }
 
-var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
+// ErrTokenExpired is the 401 for an expired token. It includes the server's
+// time so a client can tell whether its clock is skewed.
+func ErrTokenExpired(now time.Time) render.Renderer {
+	now = now.UTC()
+	return &ErrResponse{
+		HTTPStatusCode: 401,
+		Code:           "token_expired",
+		Message:        "token is expired",
+		ServerTime:     &now,
+	}
+}
+
+// NotFound replaces chi's plain text 404 for routes that don't exist
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, ErrNotFound)
+}
+
+var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, Code: "not_found", Message: "resource not found"}
+
+var ErrForbidden = &ErrResponse{HTTPStatusCode: 403, Code: "forbidden", Message: "forbidden"}
+
+var ErrUnauthorized = &ErrResponse{HTTPStatusCode: 401, Code: "unauthorized", Message: "missing or invalid credentials"}
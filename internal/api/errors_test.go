@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestInternalErrorDetail(t *testing.T) {
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, ErrInternal(errors.New("pq: password authentication failed for user hpcadmin")))
+	})
+	tests := []struct {
+		name   string
+		expose bool
+	}{
+		{"Hidden", false},
+		{"Exposed", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware.RequestID(InternalErrorDetail(tt.expose)(failing))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusInternalServerError)
+			}
+			var resp ErrResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.RequestId == "" {
+				t.Errorf("expected a request id got %s", w.Body.String())
+			}
+			leaked := strings.Contains(resp.Message, "pq: password authentication failed")
+			if tt.expose && !leaked {
+				t.Errorf("expected the underlying error got %q", resp.Message)
+			}
+			if !tt.expose && (leaked || !strings.Contains(resp.Message, resp.RequestId)) {
+				t.Errorf("expected a generic message with the request id got %q", resp.Message)
+			}
+		})
+	}
+
+	// other errors are always descriptive
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	render.Render(w, r, ErrInvalidRequest(errors.New("missing required name")))
+	if !strings.Contains(w.Body.String(), "missing required name") {
+		t.Errorf("expected the bad request error got %s", w.Body.String())
+	}
+}
+
+func TestErrResponseShape(t *testing.T) {
+	r := chi.NewRouter()
+	r.NotFound(NotFound)
+	r.Get("/invalid", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("limit must be a positive integer: x")))
+	})
+	r.Get("/missing", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, ErrNotFound)
+	})
+	r.Get("/failing", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, ErrInternal(errors.New("connection refused")))
+	})
+	timedOut := fmt.Errorf("%w: pq: canceling statement due to user request", data.ErrQueryTimeout)
+	r.Get("/timeout", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, ErrInternal(timedOut))
+	})
+	r.Get("/lookup-timeout", func(w http.ResponseWriter, r *http.Request) {
+		render.Render(w, r, ErrLookup(timedOut))
+	})
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/invalid", http.StatusBadRequest, "invalid_request"},
+		{"/missing", http.StatusNotFound, "not_found"},
+		{"/no-such-route", http.StatusNotFound, "not_found"},
+		{"/failing", http.StatusInternalServerError, "internal_error"},
+		{"/timeout", http.StatusGatewayTimeout, "gateway_timeout"},
+		{"/lookup-timeout", http.StatusGatewayTimeout, "gateway_timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, tt.status)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected a JSON error body got %q: %v", w.Body.String(), err)
+			}
+			if body["status"] != float64(tt.status) {
+				t.Errorf("expected status %v in the body got %v", tt.status, body["status"])
+			}
+			if body["error"] != tt.code {
+				t.Errorf("expected error %v got %v", tt.code, body["error"])
+			}
+			if message, _ := body["message"].(string); message == "" {
+				t.Errorf("expected a message got %v", body["message"])
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+// renderWithETag writes v as JSON with an ETag of its encoding, or just 304
+// Not Modified if the request's If-None-Match already has it. Any change to
+// the resource changes its encoding, so no version has to be kept to know
+// when it was modified.
+func renderWithETag(w http.ResponseWriter, r *http.Request, v render.Renderer) {
+	if err := v.Render(w, r); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	writeWithETag(w, r, body)
+}
+
+// writeWithETag writes the JSON body with a weak ETag of its hash, or just
+// 304 Not Modified if the request's If-None-Match already has it
+func writeWithETag(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// etagMatches reports whether the If-None-Match header has the etag, or is
+// *. The comparison is weak, as RFC 9110 has it, so W/ prefixes are ignored.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
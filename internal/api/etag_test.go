@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestGetUserETag(t *testing.T) {
+	h := &UserHandler{}
+	user := &data.User{Id: 1, Username: "testetag", Email: "testetag@localhost"}
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		r = r.WithContext(context.WithValue(r.Context(), keys.UserKey, user))
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		h.GetUser(w, r)
+		return w
+	}
+
+	w := get("")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.Len() == 0 {
+		t.Fatalf("expected a 200 with an ETag got %v %q %q", w.Code, etag, w.Body.String())
+	}
+	for _, ifNoneMatch := range []string{etag, `"other", ` + etag, etag[2:], "*"} {
+		w = get(ifNoneMatch)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %v: expected an empty 304 got %v %q", ifNoneMatch, w.Code, w.Body.String())
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %v: expected the 304 to carry the ETag got %q", ifNoneMatch, w.Header().Get("ETag"))
+		}
+	}
+
+	// changing the user changes the ETag, so the old one is stale
+	user.Email = "testetagchanged@localhost"
+	w = get(etag)
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Fatalf("expected a stale ETag to get a 200 got %v", w.Code)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Errorf("expected the ETag to change with the user got %v", etag)
+	}
+}
+
+func TestGetPirgETag(t *testing.T) {
+	h := &PirgHandler{}
+	pirg := &data.Pirg{Id: 1, Name: "testetag", OwnerId: 1, AdminIds: []int{1}, UserIds: []int{1}}
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/pirgs/1", nil)
+		r = r.WithContext(context.WithValue(r.Context(), keys.PirgKey, pirg))
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		h.GetPirg(w, r)
+		return w
+	}
+
+	w := get(`W/"stale"`)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected a 200 with an ETag got %v %q", w.Code, etag)
+	}
+	if w = get(etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected an empty 304 got %v %q", w.Code, w.Body.String())
+	}
+	// adding a member changes the ETag
+	pirg.UserIds = append(pirg.UserIds, 2)
+	if w = get(etag); w.Code != http.StatusOK {
+		t.Errorf("expected a stale ETag to get a 200 got %v", w.Code)
+	}
+}
+
+// TestGetUserMatchesByUsername checks a user gets the same body and ETag by
+// id as by username
+func TestGetUserMatchesByUsername(t *testing.T) {
+	store := data.NewMemoryStore()
+	user, err := store.CreateUser(&data.UserRequest{Username: "testetag", Email: "testetag@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, &config.ServerConfig{})
+	ctx = context.WithValue(ctx, keys.StoreKey, store)
+	router := UsersRouter(ctx)
+	get := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200 got %v %s", target, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	byId := get(fmt.Sprintf("/%d", user.Id))
+	byUsername := get("/by-username/TestEtag")
+	if byId.Body.String() != byUsername.Body.String() || byId.Header().Get("ETag") != byUsername.Header().Get("ETag") {
+		t.Errorf("expected the same body and ETag got %q %q and %q %q",
+			byId.Header().Get("ETag"), byId.Body.String(), byUsername.Header().Get("ETag"), byUsername.Body.String())
+	}
+	if !strings.Contains(byId.Body.String(), `"created_at"`) {
+		t.Errorf("expected created_at in the user got %s", byId.Body.String())
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// defaultFairshareTotal is what the shares sum to if fairshare total isn't set
+const defaultFairshareTotal = 10000
+
+// defaultFairshareAllocation is the allocation of pirgs fairshare allocations
+// doesn't list, if default_allocation isn't set, so they share equally
+const defaultFairshareAllocation = 1
+
+// fairshareExportFormats are the formats ExportFairshare accepts, the first is the default
+var fairshareExportFormats = []string{"sacctmgr", "json"}
+
+// FairshareExportResponse is every pirg's fair-share weight, ordered by pirg
+// name. The shares sum to Total unless no pirg has an allocation.
+type FairshareExportResponse struct {
+	Total int                      `json:"total"`
+	Pirgs []*PirgFairshareResponse `json:"pirgs"`
+}
+
+func (f *FairshareExportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type PirgFairshareResponse struct {
+	PirgId     int    `json:"pirg_id"`
+	Name       string `json:"name"`
+	Allocation int    `json:"allocation"`
+	Share      int    `json:"share"`
+}
+
+type FairshareHandler struct {
+	dbConn *sql.DB
+	cfg    config.FairshareConfig
+}
+
+func newFairshareHandler(ctx context.Context) *FairshareHandler {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	return &FairshareHandler{dbConn: dbConn, cfg: cfg.Fairshare}
+}
+
+// ExportFairshare returns each pirg's Slurm fair-share weight, its share of
+// the configured total in proportion to its allocation. By default it's a
+// script of sacctmgr commands setting them on the pirgs' accounts, and with
+// ?format=json the weights and allocations they came from.
+func (h *FairshareHandler) ExportFairshare(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("exporting fairshare", "package", "api", "method", "ExportFairshare")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = fairshareExportFormats[0]
+	}
+	if !slices.Contains(fairshareExportFormats, format) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported export format: %s", format)))
+		return
+	}
+	pirgs, err := data.GetAllPirgs(h.dbConn)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := h.newFairshareExportResponse(pirgs)
+
+	if format == "json" {
+		if err := render.Render(w, r, resp); err != nil {
+			render.Render(w, r, ErrRender(err))
+		}
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#!/bin/sh\n# slurm fair-share weights totalling %d, generated by hpcadmin-server\nset -eu\n\n", resp.Total)
+	for _, p := range resp.Pirgs {
+		fmt.Fprintf(&buf, "sacctmgr --immediate modify account where name=%s set fairshare=%d\n", shellQuote(p.Name), p.Share)
+	}
+	w.Header().Set("Content-Type", "text/x-shellscript")
+	w.Header().Set("Content-Disposition", `attachment; filename="fairshare.sh"`)
+	w.Write(buf.Bytes())
+}
+
+func (h *FairshareHandler) newFairshareExportResponse(pirgs []*data.Pirg) *FairshareExportResponse {
+	total := h.cfg.Total
+	if total == 0 {
+		total = defaultFairshareTotal
+	}
+	defaultAllocation := defaultFairshareAllocation
+	if h.cfg.DefaultAllocation != nil {
+		defaultAllocation = *h.cfg.DefaultAllocation
+	}
+	slices.SortFunc(pirgs, func(a, b *data.Pirg) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	allocations := make([]int, len(pirgs))
+	for i, p := range pirgs {
+		allocations[i] = defaultAllocation
+		if a, ok := h.cfg.Allocations[p.Name]; ok {
+			allocations[i] = a
+		}
+	}
+	shares := fairshareShares(allocations, total)
+	resp := &FairshareExportResponse{Total: total, Pirgs: []*PirgFairshareResponse{}}
+	for i, p := range pirgs {
+		resp.Pirgs = append(resp.Pirgs, &PirgFairshareResponse{
+			PirgId:     p.Id,
+			Name:       p.Name,
+			Allocation: allocations[i],
+			Share:      shares[i],
+		})
+	}
+	return resp
+}
+
+// fairshareShares splits total into whole shares in proportion to the
+// allocations, summing to total. What's left after rounding down goes to the
+// largest remainders, and ties to the earlier allocation. If nothing is
+// allocated every share is 0.
+func fairshareShares(allocations []int, total int) []int {
+	shares := make([]int, len(allocations))
+	var sum int64
+	for _, a := range allocations {
+		sum += int64(a)
+	}
+	if sum == 0 {
+		return shares
+	}
+	remainders := make([]int64, len(allocations))
+	left := total
+	for i, a := range allocations {
+		n := int64(a) * int64(total)
+		shares[i] = int(n / sum)
+		remainders[i] = n % sum
+		left -= shares[i]
+	}
+	order := make([]int, len(allocations))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(remainders[b], remainders[a])
+	})
+	for _, i := range order[:left] {
+		shares[i]++
+	}
+	return shares
+}
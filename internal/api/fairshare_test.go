@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestFairshareShares(t *testing.T) {
+	tests := []struct {
+		name        string
+		allocations []int
+		total       int
+		want        []int
+	}{
+		{"Proportional", []int{4, 2, 2}, 100, []int{50, 25, 25}},
+		{"Equal", []int{1, 1, 1, 1}, 10000, []int{2500, 2500, 2500, 2500}},
+		// 3333.33 each, the leftover goes to the first
+		{"Rounded", []int{1, 1, 1}, 10000, []int{3334, 3333, 3333}},
+		// 14.28, 28.57 and 57.14, the leftover goes to the largest remainder
+		{"LargestRemainder", []int{1, 2, 4}, 100, []int{14, 29, 57}},
+		{"ZeroAllocation", []int{3, 0, 1}, 100, []int{75, 0, 25}},
+		{"NothingAllocated", []int{0, 0}, 100, []int{0, 0}},
+		{"NoPirgs", []int{}, 100, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fairshareShares(tt.allocations, tt.total)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v got %v", tt.want, got)
+			}
+		})
+	}
+
+	// the shares always sum to the total
+	allocations := []int{}
+	for i := 1; i <= 37; i++ {
+		allocations = append(allocations, i*i%11)
+	}
+	sum := 0
+	for _, s := range fairshareShares(allocations, 9973) {
+		sum += s
+	}
+	if sum != 9973 {
+		t.Errorf("expected the shares to sum to 9973 got %v", sum)
+	}
+}
+
+func TestNewFairshareExportResponse(t *testing.T) {
+	noShare := 0
+	pirgs := []*data.Pirg{{Id: 1, Name: "testpirgc"}, {Id: 2, Name: "testpirga"}, {Id: 3, Name: "testpirgb"}}
+
+	h := &FairshareHandler{cfg: config.FairshareConfig{Allocations: map[string]int{"testpirga": 3, "testmissing": 5}}}
+	resp := h.newFairshareExportResponse(slices.Clone(pirgs))
+	want := []*PirgFairshareResponse{
+		{PirgId: 2, Name: "testpirga", Allocation: 3, Share: 6000},
+		{PirgId: 3, Name: "testpirgb", Allocation: 1, Share: 2000},
+		{PirgId: 1, Name: "testpirgc", Allocation: 1, Share: 2000},
+	}
+	if resp.Total != defaultFairshareTotal || len(resp.Pirgs) != len(want) {
+		t.Fatalf("expected %v pirgs sharing %v got %+v", len(want), defaultFairshareTotal, resp)
+	}
+	for i, p := range resp.Pirgs {
+		if *p != *want[i] {
+			t.Errorf("expected %+v got %+v", want[i], p)
+		}
+	}
+
+	// unlisted pirgs get nothing without a default allocation
+	h = &FairshareHandler{cfg: config.FairshareConfig{Total: 100, DefaultAllocation: &noShare, Allocations: map[string]int{"testpirgb": 1}}}
+	resp = h.newFairshareExportResponse(slices.Clone(pirgs))
+	for _, p := range resp.Pirgs {
+		if want := map[string]int{"testpirgb": 100}[p.Name]; p.Share != want {
+			t.Errorf("expected %v to get %v got %v", p.Name, want, p.Share)
+		}
+	}
+}
+
+func TestExportFairshare(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testfairshareowner")
+	for _, name := range []string{"testfairsharea", "testfairshareb"} {
+		_, err := data.CreatePirg(th.DB, &data.PirgRequest{Name: name, OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &FairshareHandler{dbConn: th.DB, cfg: config.FairshareConfig{Allocations: map[string]int{"testfairsharea": 1000000}}}
+
+	w := httptest.NewRecorder()
+	h.ExportFairshare(w, httptest.NewRequest("GET", "/export/fairshare", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/x-shellscript" {
+		t.Errorf("expected a shell script got %v", ct)
+	}
+	script := w.Body.String()
+	for _, line := range []string{
+		"sacctmgr --immediate modify account where name='testfairsharea' set fairshare=",
+		"sacctmgr --immediate modify account where name='testfairshareb' set fairshare=",
+	} {
+		if !strings.Contains(script, line) {
+			t.Errorf("expected %q in the script got\n%s", line, script)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ExportFairshare(w, httptest.NewRequest("GET", "/export/fairshare?format=xml", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
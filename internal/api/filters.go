@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+)
+
+// queryValues returns the values of a list filter param that can match any of
+// several values. They can be repeated, ?status=a&status=b, or comma separated,
+// ?status=a,b, or both. Empty values are dropped and duplicates kept once,
+// in the order given. Only params whose values can't contain commas should
+// be read this way.
+func queryValues(q url.Values, param string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for _, v := range q[param] {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" || seen[part] {
+				continue
+			}
+			seen[part] = true
+			values = append(values, part)
+		}
+	}
+	return values
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestQueryValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"Single", "status=active", []string{"active"}},
+		{"Repeated", "status=active&status=pending", []string{"active", "pending"}},
+		{"CommaSeparated", "status=active,pending", []string{"active", "pending"}},
+		{"Mixed", "status=active,pending&status=closed", []string{"active", "pending", "closed"}},
+		{"DropsEmptyAndDuplicates", "status=active,,pending&status=active, ", []string{"active", "pending"}},
+		{"Missing", "other=active", nil},
+		{"Empty", "status=", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := queryValues(q, "status"); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// grantableRoles are the roles a time-limited grant can confer
+var grantableRoles = []string{"admin", "user"}
+
+type RoleGrantRequest struct {
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Reason    string    `json:"reason"`
+}
+
+func (g *RoleGrantRequest) Bind(r *http.Request) error {
+	if !slices.Contains(grantableRoles, g.Role) {
+		return fmt.Errorf("role must be one of %v: %q", grantableRoles, g.Role)
+	}
+	if g.ExpiresAt.IsZero() {
+		return fmt.Errorf("missing required expires_at")
+	}
+	if !g.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+type RoleGrantResponse struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	Role      string    `json:"role"`
+	GrantedBy string    `json:"granted_by"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (g *RoleGrantResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newRoleGrantResponse(g *data.RoleGrant) *RoleGrantResponse {
+	return &RoleGrantResponse{
+		Id:        g.Id,
+		UserId:    g.UserId,
+		Role:      g.Role,
+		GrantedBy: g.GrantedBy,
+		Reason:    g.Reason,
+		ExpiresAt: g.ExpiresAt,
+		CreatedAt: g.CreatedAt,
+	}
+}
+
+type GrantHandler struct {
+	dbConn *sql.DB
+}
+
+func newGrantHandler(ctx context.Context) *GrantHandler {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	return &GrantHandler{dbConn: dbConn}
+}
+
+// CreateGrant gives the user in the URL a role until expires_at
+func (h *GrantHandler) CreateGrant(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("creating role grant", "package", "api", "method", "CreateGrant")
+	userId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	grantReq := &RoleGrantRequest{}
+	if err := render.Bind(r, grantReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	if _, err := data.GetUserById(h.dbConn, userId); err != nil {
+		render.Render(w, r, ErrLookup(err))
+		return
+	}
+	actor := actorFromContext(r.Context())
+	grant, err := data.CreateRoleGrant(h.dbConn, &data.RoleGrantRequest{
+		UserId:    userId,
+		Role:      grantReq.Role,
+		GrantedBy: actor,
+		Reason:    grantReq.Reason,
+		ExpiresAt: grantReq.ExpiresAt,
+	})
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	details, _ := json.Marshal(map[string]any{
+		"grant_id":   grant.Id,
+		"role":       grant.Role,
+		"expires_at": grant.ExpiresAt,
+		"reason":     grant.Reason,
+	})
+	_, err = data.CreateAuditEvent(h.dbConn, &data.AuditEventRequest{
+		Actor:        actor,
+		Action:       "role_granted",
+		ResourceType: "user",
+		ResourceId:   strconv.Itoa(userId),
+		Details:      details,
+	})
+	if err != nil {
+		slog.Error("failed to audit role grant", "package", "api", "method", "CreateGrant", "grant_id", grant.Id, "error", err)
+	}
+
+	resp := newRoleGrantResponse(grant)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
+}
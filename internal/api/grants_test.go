@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPICreateGrant(t *testing.T) {
+	th := NewTestDataHandler()
+	user := newTestPirgOwner(t, th, "testapicreategrant")
+	userKey := "testapicreategrantkey"
+	_, err := th.DB.Exec("INSERT INTO api_keys (key, role, user_id) VALUES ($1, 'user', $2)", userKey, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{}
+	adminStatus := func() int {
+		req, err := http.NewRequest("GET", "http://localhost:3333/admin/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", userKey)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := adminStatus(); status != http.StatusForbidden {
+		t.Fatalf("expected status %v before the grant got %v", http.StatusForbidden, status)
+	}
+
+	body, err := json.Marshal(RoleGrantRequest{
+		Role:      "admin",
+		ExpiresAt: time.Now().Add(time.Hour),
+		Reason:    "incident response",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://localhost:3333/admin/users/%d/grant", user.Id)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", "testkey1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusCreated)
+	}
+	var grant RoleGrantResponse
+	if err = json.NewDecoder(resp.Body).Decode(&grant); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := adminStatus(); status != http.StatusOK {
+		t.Fatalf("expected status %v while the grant is active got %v", http.StatusOK, status)
+	}
+
+	// expire the grant, access reverts
+	_, err = th.DB.Exec("UPDATE role_grants SET expires_at = $1 WHERE id = $2", time.Now().Add(-time.Minute).UTC(), grant.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := adminStatus(); status != http.StatusForbidden {
+		t.Fatalf("expected status %v after the grant expired got %v", http.StatusForbidden, status)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// readyzPingTimeout bounds the database ping of a readiness check
+const readyzPingTimeout = 2 * time.Second
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+func (h *HealthResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Healthz is the liveness check, it succeeds whenever the server is serving
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, &HealthResponse{Status: "ok"})
+}
+
+// Readyz returns the readiness check, which fails with a 503 while the
+// database can't be reached
+func Readyz(ctx context.Context) http.HandlerFunc {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	return func(w http.ResponseWriter, r *http.Request) {
+		pingCtx, cancel := context.WithTimeout(r.Context(), readyzPingTimeout)
+		defer cancel()
+		if err := dbConn.PingContext(pingCtx); err != nil {
+			slog.Warn("readiness check failed", "package", "api", "method", "Readyz", "error", err)
+			render.Status(r, http.StatusServiceUnavailable)
+			render.Render(w, r, &HealthResponse{Status: "db unavailable"})
+			return
+		}
+		render.Render(w, r, &HealthResponse{Status: "ok"})
+	}
+}
+
+// TimeResponse is the server's clock, for clients to check theirs against
+// before token expiry checks fail on skew
+type TimeResponse struct {
+	Time time.Time `json:"time"`
+	Unix int64     `json:"unix"`
+}
+
+func (t *TimeResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// GetTime returns the server's current time in UTC. It doesn't need auth so
+// it can be checked before a request fails with an expired token.
+func GetTime(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	render.Render(w, r, &TimeResponse{Time: now, Unix: now.Unix()})
+}
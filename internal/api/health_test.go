@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	Healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %v", w.Code)
+	}
+	var resp HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Status != "ok" {
+		t.Fatalf("expected status ok got %+v %v", resp, err)
+	}
+}
+
+func TestReadyzUnavailableDB(t *testing.T) {
+	db, err := sql.Open("postgres", "postgresql://nobody@127.0.0.1:1/nothing?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	ctx := context.WithValue(context.Background(), keys.DBConnKey, db)
+
+	w := httptest.NewRecorder()
+	Readyz(ctx)(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 got %v", w.Code)
+	}
+	var resp HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Status != "db unavailable" {
+		t.Fatalf("expected status db unavailable got %+v %v", resp, err)
+	}
+}
+
+func TestGetTime(t *testing.T) {
+	before := time.Now()
+	w := httptest.NewRecorder()
+	GetTime(w, httptest.NewRequest("GET", "/api/v1/time", nil))
+	after := time.Now()
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	var resp TimeResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	// the response only has second precision in unix
+	if resp.Time.Before(before) || resp.Time.After(after) || resp.Unix != resp.Time.Unix() {
+		t.Errorf("expected a time between %v and %v got %+v", before, after, resp)
+	}
+	if resp.Time.Location() != time.UTC {
+		t.Errorf("expected the time in UTC got %v", resp.Time)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// defaultHSTSMaxAge is a year, in seconds
+const defaultHSTSMaxAge = 365 * 24 * 60 * 60
+
+// EnforceHTTPS redirects cleartext requests to https on httpsPort with a 308,
+// so the method and body are kept, and sets Strict-Transport-Security on
+// responses served over TLS. Requests for the exempt paths, such as health
+// checks, are served over either. It does nothing unless enforce_https is set.
+func EnforceHTTPS(cfg config.TLSConfig, httpsPort int, exempt []string) func(http.Handler) http.Handler {
+	maxAge := int(cfg.HSTSMaxAge.Seconds())
+	if maxAge == 0 {
+		maxAge = defaultHSTSMaxAge
+	}
+	hsts := fmt.Sprintf("max-age=%d", maxAge)
+	return func(next http.Handler) http.Handler {
+		if !cfg.EnforceHTTPS {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", hsts)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if slices.Contains(exempt, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.Redirect(w, r, httpsURL(r, httpsPort), http.StatusPermanentRedirect)
+		})
+	}
+}
+
+// httpsURL is the request's url on https, on port unless it's the default
+func httpsURL(r *http.Request, port int) string {
+	host := strings.Trim(r.Host, "[]")
+	if name, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = name
+	}
+	if port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
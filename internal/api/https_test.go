@@ -0,0 +1,57 @@
+package api
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestEnforceHTTPS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	enforced := config.TLSConfig{EnforceHTTPS: true}
+	tests := []struct {
+		name     string
+		cfg      config.TLSConfig
+		port     int
+		method   string
+		target   string
+		host     string
+		tls      bool
+		want     int
+		location string
+		hsts     string
+	}{
+		{name: "Redirect", cfg: enforced, port: 3333, method: "GET", target: "/api/v1/users?limit=5", host: "hpcadmin.example.com:8080", want: http.StatusPermanentRedirect, location: "https://hpcadmin.example.com:3333/api/v1/users?limit=5"},
+		{name: "RedirectDefaultPort", cfg: enforced, port: 443, method: "GET", target: "/api/v1/users", host: "hpcadmin.example.com", want: http.StatusPermanentRedirect, location: "https://hpcadmin.example.com/api/v1/users"},
+		{name: "RedirectIPv6", cfg: enforced, port: 443, method: "GET", target: "/api/v1/users", host: "[::1]:8080", want: http.StatusPermanentRedirect, location: "https://[::1]/api/v1/users"},
+		{name: "RedirectKeepsMethod", cfg: enforced, port: 3333, method: "POST", target: "/api/v1/users", host: "localhost", want: http.StatusPermanentRedirect, location: "https://localhost:3333/api/v1/users"},
+		{name: "HealthCheck", cfg: enforced, port: 3333, method: "GET", target: "/healthz", host: "localhost:8080", want: http.StatusOK},
+		{name: "HSTS", cfg: enforced, port: 3333, method: "GET", target: "/api/v1/users", host: "localhost:3333", tls: true, want: http.StatusOK, hsts: "max-age=31536000"},
+		{name: "HSTSMaxAge", cfg: config.TLSConfig{EnforceHTTPS: true, HSTSMaxAge: time.Hour}, port: 3333, method: "GET", target: "/healthz", host: "localhost:3333", tls: true, want: http.StatusOK, hsts: "max-age=3600"},
+		{name: "Disabled", cfg: config.TLSConfig{}, port: 3333, method: "GET", target: "/api/v1/users", host: "localhost:8080", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.Host = tt.host
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			EnforceHTTPS(tt.cfg, tt.port, []string{"/", "/healthz"})(ok).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("expected status %v got %v", tt.want, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("expected location %q got %q", tt.location, got)
+			}
+			if got := w.Header().Get("Strict-Transport-Security"); got != tt.hsts {
+				t.Errorf("expected Strict-Transport-Security %q got %q", tt.hsts, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/jobs"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type JobResponse struct {
+	Name       string     `json:"name"`
+	Interval   string     `json:"interval"`
+	Queued     bool       `json:"queued"`
+	Running    bool       `json:"running"`
+	LastRun    *time.Time `json:"last_run"`
+	LastStatus string     `json:"last_status,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	NextRun    time.Time  `json:"next_run"`
+}
+
+type JobsResponse struct {
+	Jobs []*JobResponse `json:"jobs"`
+}
+
+func (j *JobsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newJobsResponse(statuses []jobs.Status) *JobsResponse {
+	resp := &JobsResponse{Jobs: []*JobResponse{}}
+	for _, s := range statuses {
+		resp.Jobs = append(resp.Jobs, &JobResponse{
+			Name:       s.Name,
+			Interval:   s.Interval.String(),
+			Queued:     s.Queued,
+			Running:    s.Running,
+			LastRun:    s.LastRun,
+			LastStatus: s.LastStatus,
+			LastError:  s.LastError,
+			NextRun:    s.NextRun,
+		})
+	}
+	return resp
+}
+
+type JobsHandler struct {
+	registry *jobs.Registry
+}
+
+func newJobsHandler(ctx context.Context) *JobsHandler {
+	registry := ctx.Value(keys.JobsKey).(*jobs.Registry)
+	return &JobsHandler{registry: registry}
+}
+
+// GetJobs returns the background jobs running on this instance, with the
+// outcome of their last run and when the next one is due
+func (h *JobsHandler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting background jobs", "package", "api", "method", "GetJobs")
+	if err := render.Render(w, r, newJobsResponse(h.registry.Jobs())); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/jobs"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestGetJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := jobs.NewRegistry(0)
+	registry.Start(ctx, "membership expiry sweep", 5*time.Minute, func(context.Context) error { return nil })
+	h := newJobsHandler(context.WithValue(context.Background(), keys.JobsKey, registry))
+
+	w := httptest.NewRecorder()
+	h.GetJobs(w, httptest.NewRequest("GET", "/admin/jobs", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	var resp JobsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Jobs) != 1 {
+		t.Fatalf("expected 1 job got %+v", resp.Jobs)
+	}
+	job := resp.Jobs[0]
+	if job.Name != "membership expiry sweep" || job.Interval != "5m0s" || job.LastRun != nil || job.Running {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if time.Until(job.NextRun) <= 4*time.Minute {
+		t.Errorf("expected the next run in about 5m got %v", job.NextRun)
+	}
+}
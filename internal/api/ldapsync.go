@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/jobs"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/ldap"
+	"github.com/lcrownover/hpcadmin-server/internal/webhook"
+)
+
+// userDirectory reads the users to sync. It's an *ldap.Directory outside of tests.
+type userDirectory interface {
+	Users(ctx context.Context) ([]ldap.User, error)
+}
+
+// LDAPSyncResponse counts what a sync changed. Invalid has the usernames of
+// directory users that were skipped for failing validation, and KeptOwners
+// the users missing from the directory who weren't deleted because they
+// still own pirgs. A dry run lists the users each change would apply to in
+// Changes.
+type LDAPSyncResponse struct {
+	Created    int             `json:"created"`
+	Updated    int             `json:"updated"`
+	Restored   int             `json:"restored"`
+	Removed    int             `json:"removed"`
+	Unchanged  int             `json:"unchanged"`
+	Invalid    []string        `json:"invalid"`
+	KeptOwners []string        `json:"kept_owners"`
+	Changes    []*DryRunChange `json:"changes,omitempty"`
+	DryRun     bool            `json:"dry_run"`
+}
+
+func (l *LDAPSyncResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Statuses of an LDAP sync job
+const (
+	ldapSyncQueued    = "queued"
+	ldapSyncRunning   = "running"
+	ldapSyncSucceeded = jobs.StatusSucceeded
+	ldapSyncFailed    = jobs.StatusFailed
+)
+
+// errLDAPSyncInProgress is returned starting a sync while another is queued or running
+var errLDAPSyncInProgress = errors.New("an ldap sync is already in progress")
+
+// LDAPSyncJobResponse is the state of a sync started with POST /admin/sync/ldap.
+// The counts are its progress so far, and while it's running they're of a
+// transaction that isn't committed yet. Result is set once it succeeded,
+// and Error once it failed.
+type LDAPSyncJobResponse struct {
+	Id         int               `json:"id"`
+	Status     string            `json:"status"`
+	DryRun     bool              `json:"dry_run"`
+	Scanned    int64             `json:"scanned"`
+	Created    int64             `json:"created"`
+	Updated    int64             `json:"updated"`
+	Restored   int64             `json:"restored"`
+	Removed    int64             `json:"removed"`
+	QueuedAt   time.Time         `json:"queued_at"`
+	StartedAt  *time.Time        `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at"`
+	Error      string            `json:"error,omitempty"`
+	Result     *LDAPSyncResponse `json:"result,omitempty"`
+}
+
+func (l *LDAPSyncJobResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// ldapSyncJob is a sync started on this instance. progress is counted up by
+// data.SyncUsers, the rest is guarded by the ldapSyncJobs' mutex.
+type ldapSyncJob struct {
+	id         int
+	dryRun     bool
+	progress   data.UserSyncProgress
+	status     string
+	queuedAt   time.Time
+	startedAt  *time.Time
+	finishedAt *time.Time
+	err        string
+	result     *LDAPSyncResponse
+}
+
+// ldapSyncJobs keeps the syncs started on this instance until it restarts,
+// and makes sure only one is queued or running at a time
+type ldapSyncJobs struct {
+	mu     sync.Mutex
+	jobs   map[int]*ldapSyncJob
+	lastId int
+	// current is the last job started
+	current *ldapSyncJob
+}
+
+func newLDAPSyncJobs() *ldapSyncJobs {
+	return &ldapSyncJobs{jobs: map[int]*ldapSyncJob{}}
+}
+
+// start queues a new job, or returns errLDAPSyncInProgress with the job
+// that's already queued or running
+func (s *ldapSyncJobs) start(dryRun bool) (*ldapSyncJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.current; c != nil && (c.status == ldapSyncQueued || c.status == ldapSyncRunning) {
+		return c, errLDAPSyncInProgress
+	}
+	s.lastId++
+	job := &ldapSyncJob{id: s.lastId, dryRun: dryRun, status: ldapSyncQueued, queuedAt: time.Now().UTC()}
+	s.jobs[job.id] = job
+	s.current = job
+	return job, nil
+}
+
+func (s *ldapSyncJobs) running(job *ldapSyncJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	job.status = ldapSyncRunning
+	job.startedAt = &now
+}
+
+func (s *ldapSyncJobs) finish(job *ldapSyncJob, result *LDAPSyncResponse, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	job.finishedAt = &now
+	if err != nil {
+		job.status = ldapSyncFailed
+		job.err = err.Error()
+		return
+	}
+	job.status = ldapSyncSucceeded
+	job.result = result
+}
+
+// get returns the job with id, or nil if there's none
+func (s *ldapSyncJobs) get(id int) *ldapSyncJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// response returns the job's state so far
+func (s *ldapSyncJobs) response(job *ldapSyncJob) *LDAPSyncJobResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &LDAPSyncJobResponse{
+		Id:         job.id,
+		Status:     job.status,
+		DryRun:     job.dryRun,
+		Scanned:    job.progress.Scanned.Load(),
+		Created:    job.progress.Created.Load(),
+		Updated:    job.progress.Updated.Load(),
+		Restored:   job.progress.Restored.Load(),
+		Removed:    job.progress.Removed.Load(),
+		QueuedAt:   job.queuedAt,
+		StartedAt:  job.startedAt,
+		FinishedAt: job.finishedAt,
+		Error:      job.err,
+		Result:     job.result,
+	}
+}
+
+type LDAPSyncHandler struct {
+	dbConn             *sql.DB
+	store              data.Store
+	directory          userDirectory
+	stripEmailPlusTags bool
+	webhooks           *webhook.Dispatcher
+	registry           *jobs.Registry
+	syncs              *ldapSyncJobs
+	uidProvisioner     *uidProvisioner
+}
+
+func newLDAPSyncHandler(ctx context.Context) *LDAPSyncHandler {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	webhooks, _ := ctx.Value(keys.WebhooksKey).(*webhook.Dispatcher)
+	registry := ctx.Value(keys.JobsKey).(*jobs.Registry)
+	return &LDAPSyncHandler{
+		dbConn:             dbConn,
+		store:              storeFromContext(ctx),
+		directory:          ldap.NewDirectory(cfg.LDAP),
+		stripEmailPlusTags: cfg.StripEmailPlusTags,
+		webhooks:           webhooks,
+		registry:           registry,
+		syncs:              newLDAPSyncJobs(),
+		uidProvisioner:     newUidProvisioner(ctx),
+	}
+}
+
+// SyncLDAP starts a job that reconciles the users table against the
+// configured directory, see sync, and answers 202 Accepted with it. The job
+// runs in the background jobs' pool and GetLDAPSyncJob reports its progress.
+// Only one sync is queued or running at a time, starting another meanwhile
+// answers 409. With ?dry_run=true the job lists the changes and makes none.
+func (h *LDAPSyncHandler) SyncLDAP(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("starting ldap sync", "package", "api", "method", "SyncLDAP")
+	job, err := h.syncs.start(dryRunRequested(r))
+	if errors.Is(err, errLDAPSyncInProgress) {
+		render.Render(w, r, ErrConflict(fmt.Errorf("%v: job %d", err, job.id)))
+		return
+	}
+	// the job outlives the request, but keeps its actor for the audit events
+	ctx := context.WithoutCancel(r.Context())
+	h.registry.Submit(ctx, "ldap sync", func(ctx context.Context) error {
+		h.syncs.running(job)
+		result, err := h.sync(ctx, job.dryRun, &job.progress)
+		h.syncs.finish(job, result, err)
+		return err
+	})
+	slog.Info("started ldap sync", "job_id", job.id, "dry_run", job.dryRun, "actor", actorFromContext(r.Context()), "package", "api", "method", "SyncLDAP")
+	render.Status(r, http.StatusAccepted)
+	if err := render.Render(w, r, h.syncs.response(job)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetLDAPSyncJob returns the sync job with the id in the URL, see SyncLDAP
+func (h *LDAPSyncHandler) GetLDAPSyncJob(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting ldap sync job", "package", "api", "method", "GetLDAPSyncJob")
+	id, err := strconv.Atoi(chi.URLParam(r, "syncId"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid sync job id: %s", chi.URLParam(r, "syncId"))))
+		return
+	}
+	job := h.syncs.get(id)
+	if job == nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := render.Render(w, r, h.syncs.response(job)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// sync reconciles the users table against the configured directory: new
+// users are created, changed ones updated, and synced users who are no longer
+// in the directory are deleted. See data.SyncUsers. Each change is audited.
+// With dryRun the changes are listed and none are made.
+func (h *LDAPSyncHandler) sync(ctx context.Context, dryRun bool, progress *data.UserSyncProgress) (*LDAPSyncResponse, error) {
+	slog.Debug("syncing users from ldap", "package", "api", "method", "sync")
+	entries, err := h.directory.Users(ctx)
+	if err != nil {
+		if !errors.Is(err, ldap.ErrNotConfigured) {
+			slog.Warn("failed to read users from ldap", "error", err, "package", "api", "method", "sync")
+		}
+		return nil, fmt.Errorf("failed to read users from ldap: %w", err)
+	}
+	users := []*data.UserRequest{}
+	for _, e := range entries {
+		users = append(users, &data.UserRequest{
+			Username:  e.Username,
+			Email:     data.NormalizeEmail(e.Email, h.stripEmailPlusTags),
+			FirstName: e.FirstName,
+			LastName:  e.LastName,
+		})
+	}
+	result, err := data.SyncUsers(h.dbConn, users, dryRun, progress)
+	if err != nil {
+		return nil, err
+	}
+	changes := []*DryRunChange{}
+	for _, c := range []struct {
+		action    string
+		eventType string
+		users     []*data.User
+	}{
+		{data.AuditActionCreated, webhook.EventUserCreated, result.Created},
+		{data.AuditActionUpdated, webhook.EventUserUpdated, result.Updated},
+		{data.AuditActionRestored, webhook.EventUserRestored, result.Restored},
+		{data.AuditActionDeleted, webhook.EventUserDeleted, result.Removed},
+	} {
+		if dryRun {
+			if len(c.users) > 0 {
+				changes = append(changes, userDryRunChange(c.action, c.users))
+			}
+			continue
+		}
+		for _, u := range c.users {
+			recordChange(ctx, h.store, "user", u.Id, c.action)
+			h.webhooks.Enqueue(c.eventType, newUserResponse(u))
+			if c.action == data.AuditActionCreated {
+				h.uidProvisioner.provision(u)
+			}
+		}
+	}
+	slog.Info("synced users from ldap", "dry_run", dryRun, "created", len(result.Created), "updated", len(result.Updated), "restored", len(result.Restored),
+		"removed", len(result.Removed), "invalid", len(result.Invalid), "kept_owners", len(result.KeptOwners), "package", "api", "method", "sync")
+	resp := &LDAPSyncResponse{
+		Created:    len(result.Created),
+		Updated:    len(result.Updated),
+		Restored:   len(result.Restored),
+		Removed:    len(result.Removed),
+		Unchanged:  result.Unchanged,
+		Invalid:    result.Invalid,
+		KeptOwners: result.KeptOwners,
+		DryRun:     dryRun,
+	}
+	if dryRun {
+		resp.Changes = changes
+	}
+	return resp, nil
+}
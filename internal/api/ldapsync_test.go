@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/jobs"
+	"github.com/lcrownover/hpcadmin-server/internal/ldap"
+)
+
+type stubDirectory struct {
+	users []ldap.User
+	err   error
+}
+
+func (d *stubDirectory) Users(ctx context.Context) ([]ldap.User, error) {
+	return d.users, d.err
+}
+
+// blockingDirectory returns its users once release is closed
+type blockingDirectory struct {
+	stubDirectory
+	release chan struct{}
+}
+
+func (d *blockingDirectory) Users(ctx context.Context) ([]ldap.User, error) {
+	<-d.release
+	return d.stubDirectory.Users(ctx)
+}
+
+func newTestLDAPSyncHandler(db *sql.DB, directory userDirectory) (*LDAPSyncHandler, http.Handler) {
+	h := &LDAPSyncHandler{
+		dbConn:    db,
+		store:     data.NewPostgresStore(db),
+		directory: directory,
+		registry:  jobs.NewRegistry(0),
+		syncs:     newLDAPSyncJobs(),
+	}
+	r := chi.NewRouter()
+	r.Post("/sync/ldap", h.SyncLDAP)
+	r.Get("/sync/ldap/{syncId}", h.GetLDAPSyncJob)
+	return h, r
+}
+
+// startLDAPSync posts a sync to router and returns the response's status and job
+func startLDAPSync(t *testing.T, router http.Handler) (int, *LDAPSyncJobResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/sync/ldap", nil))
+	job := &LDAPSyncJobResponse{}
+	if w.Code == http.StatusAccepted {
+		if err := json.Unmarshal(w.Body.Bytes(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, job
+}
+
+// waitForLDAPSync polls the job with id until it's finished
+func waitForLDAPSync(t *testing.T, router http.Handler, id int) *LDAPSyncJobResponse {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/sync/ldap/%d", id), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected the job got %v %s", w.Code, w.Body.String())
+		}
+		job := &LDAPSyncJobResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), job); err != nil {
+			t.Fatal(err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("expected the sync job to finish got %+v", job)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestSyncLDAPErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		directory userDirectory
+		message   string
+	}{
+		{name: "NotConfigured", directory: ldap.NewDirectory(config.LDAPConfig{}), message: "not configured"},
+		{name: "DirectoryError", directory: &stubDirectory{err: errors.New("invalid credentials")}, message: "invalid credentials"},
+		{name: "EmptyDirectory", directory: &stubDirectory{users: []ldap.User{}}, message: "no users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, router := newTestLDAPSyncHandler(nil, tt.directory)
+			status, job := startLDAPSync(t, router)
+			if status != http.StatusAccepted {
+				t.Fatalf("expected the sync to be accepted got %v", status)
+			}
+			job = waitForLDAPSync(t, router, job.Id)
+			if job.Status != ldapSyncFailed || !strings.Contains(job.Error, tt.message) || job.Result != nil {
+				t.Errorf("expected a failed job with an error containing %q got %+v", tt.message, job)
+			}
+		})
+	}
+}
+
+func TestSyncLDAPInProgress(t *testing.T) {
+	directory := &blockingDirectory{stubDirectory: stubDirectory{users: []ldap.User{}}, release: make(chan struct{})}
+	_, router := newTestLDAPSyncHandler(nil, directory)
+	status, first := startLDAPSync(t, router)
+	if status != http.StatusAccepted || first.Status == "" {
+		t.Fatalf("expected the first sync to be accepted got %v %+v", status, first)
+	}
+	if status, _ := startLDAPSync(t, router); status != http.StatusConflict {
+		t.Errorf("expected a second sync to be refused while the first runs got %v", status)
+	}
+	close(directory.release)
+	waitForLDAPSync(t, router, first.Id)
+	status, second := startLDAPSync(t, router)
+	if status != http.StatusAccepted || second.Id == first.Id {
+		t.Errorf("expected a new sync once the first finished got %v %+v", status, second)
+	}
+	waitForLDAPSync(t, router, second.Id)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/sync/ldap/999", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing job got %v", w.Code)
+	}
+}
+
+func TestSyncLDAPJob(t *testing.T) {
+	th := NewTestDataHandler()
+	directory := &stubDirectory{users: []ldap.User{
+		{Username: "testapildapsynca", Email: "testapildapsynca@localhost", FirstName: "Test", LastName: "SyncA"},
+		{Username: "testapildapsyncb", Email: "testapildapsyncb@localhost", FirstName: "Test", LastName: "SyncB"},
+	}}
+	_, router := newTestLDAPSyncHandler(th.DB, directory)
+	status, job := startLDAPSync(t, router)
+	if status != http.StatusAccepted {
+		t.Fatalf("expected the sync to be accepted got %v", status)
+	}
+	job = waitForLDAPSync(t, router, job.Id)
+	if job.Status != ldapSyncSucceeded || job.Scanned != 2 || job.Result == nil {
+		t.Fatalf("expected the sync to succeed after scanning both users got %+v", job)
+	}
+	if job.Created+job.Updated+job.Restored != 2-int64(job.Result.Unchanged) || job.StartedAt == nil {
+		t.Errorf("expected the progress to match the result got %+v %+v", job, job.Result)
+	}
+	if _, err := data.GetUserByUsername(th.DB, "testapildapsynca"); err != nil {
+		t.Errorf("expected the sync to create testapildapsynca got %v", err)
+	}
+}
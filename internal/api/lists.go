@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// defaultStreamListThreshold is how many items a list response can have
+// before it's streamed, if stream_list_threshold isn't set
+const defaultStreamListThreshold = 1000
+
+// streamFlushEvery is how many items are written between flushes of a streamed list
+const streamFlushEvery = 100
+
+// listRenderer writes unpaginated list responses. Lists of up to threshold
+// items are encoded in memory so they can carry an ETag and be answered
+// with 304 Not Modified. Longer lists are streamed item by item instead,
+// so the whole response is never held in memory.
+type listRenderer struct {
+	threshold int
+}
+
+func newListRenderer(threshold int) listRenderer {
+	if threshold == 0 {
+		threshold = defaultStreamListThreshold
+	}
+	return listRenderer{threshold: threshold}
+}
+
+// render writes the items as a JSON array, the same as render.RenderList
+func (l listRenderer) render(w http.ResponseWriter, r *http.Request, items []render.Renderer) {
+	for _, item := range items {
+		if err := item.Render(w, r); err != nil {
+			render.Render(w, r, ErrRender(err))
+			return
+		}
+	}
+	if len(items) > l.threshold {
+		l.stream(w, items)
+		return
+	}
+	body, err := json.Marshal(items)
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+	writeWithETag(w, r, body)
+}
+
+func (l listRenderer) stream(w http.ResponseWriter, items []render.Renderer) {
+	slog.Debug("streaming list response", "count", len(items), "package", "api", "method", "stream")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	buf.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(item); err != nil {
+			// the status is already sent, so all we can do is stop and log it
+			slog.Error("failed to stream list response", "package", "api", "method", "stream", "error", err)
+			return
+		}
+		if (i+1)%streamFlushEvery == 0 {
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return
+			}
+			buf.Reset()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	buf.WriteByte(']')
+	w.Write(buf.Bytes())
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
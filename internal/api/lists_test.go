@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/render"
+)
+
+func newTestListItems(n int) []render.Renderer {
+	items := []render.Renderer{}
+	for i := 1; i <= n; i++ {
+		items = append(items, &UserResponse{Id: i, Username: fmt.Sprintf("user%d", i)})
+	}
+	return items
+}
+
+func checkListBody(t *testing.T, w *httptest.ResponseRecorder, n int) {
+	t.Helper()
+	var users []UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("expected a JSON array got %q: %v", w.Body.String(), err)
+	}
+	if len(users) != n {
+		t.Fatalf("expected %v users got %v", n, len(users))
+	}
+	for i, u := range users {
+		if u.Id != i+1 {
+			t.Errorf("expected user %v at %v got %v", i+1, i, u.Id)
+		}
+	}
+}
+
+func TestListRenderer(t *testing.T) {
+	l := newListRenderer(150)
+
+	t.Run("SmallListIsBuffered", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		l.render(w, httptest.NewRequest("GET", "/users", nil), newTestListItems(150))
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("expected an ETag")
+		}
+		if w.Flushed {
+			t.Error("expected the response to be written in one piece")
+		}
+		checkListBody(t, w, 150)
+
+		r := httptest.NewRequest("GET", "/users", nil)
+		r.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		l.render(w, r, newTestListItems(150))
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("expected an empty 304 got %v %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("LargeListIsStreamed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		l.render(w, httptest.NewRequest("GET", "/users", nil), newTestListItems(251))
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+		}
+		if etag := w.Header().Get("ETag"); etag != "" {
+			t.Errorf("expected no ETag got %v", etag)
+		}
+		if !w.Flushed {
+			t.Error("expected the response to be flushed as it's written")
+		}
+		checkListBody(t, w, 251)
+	})
+
+	t.Run("EmptyList", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newListRenderer(0).render(w, httptest.NewRequest("GET", "/users", nil), newTestListItems(0))
+		if w.Body.String() != "[]" {
+			t.Errorf("expected an empty array got %q", w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/maintenance"
+)
+
+type MaintenanceBannerRequest struct {
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+func (m *MaintenanceBannerRequest) Bind(r *http.Request) error {
+	b := maintenance.Banner{Message: m.Message, Severity: m.Severity}
+	return b.Validate()
+}
+
+type MaintenanceBannerResponse struct {
+	*maintenance.Banner
+}
+
+func (m *MaintenanceBannerResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type MaintenanceHandler struct {
+	notice *maintenance.Notice
+}
+
+func newMaintenanceHandler(ctx context.Context) *MaintenanceHandler {
+	notice := ctx.Value(keys.MaintenanceKey).(*maintenance.Notice)
+	return &MaintenanceHandler{notice: notice}
+}
+
+// SetMaintenanceBanner sets the banner sent in the X-Maintenance-Notice header
+// of every response, replacing any banner already set
+func (h *MaintenanceHandler) SetMaintenanceBanner(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("setting maintenance banner", "package", "api", "method", "SetMaintenanceBanner")
+	req := &MaintenanceBannerRequest{}
+	if err := render.Bind(r, req); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	h.notice.Set(maintenance.Banner{Message: req.Message, Severity: req.Severity, SetAt: time.Now().UTC()})
+	slog.Info("maintenance banner set", "severity", req.Severity, "actor", actorFromContext(r.Context()), "package", "api", "method", "SetMaintenanceBanner")
+	// this response was already given its headers without the new banner
+	w.Header().Set(maintenance.HeaderName, req.Severity+": "+req.Message)
+	if err := render.Render(w, r, &MaintenanceBannerResponse{Banner: h.notice.Get()}); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// ClearMaintenanceBanner removes the banner
+func (h *MaintenanceHandler) ClearMaintenanceBanner(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("clearing maintenance banner", "package", "api", "method", "ClearMaintenanceBanner")
+	h.notice.Clear()
+	slog.Info("maintenance banner cleared", "actor", actorFromContext(r.Context()), "package", "api", "method", "ClearMaintenanceBanner")
+	w.Header().Del(maintenance.HeaderName)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/flags"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/maintenance"
+)
+
+func TestMaintenanceBanner(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	notice := maintenance.NewNotice()
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, cfg)
+	ctx = context.WithValue(ctx, keys.FlagsKey, flags.NewEvaluator(cfg.FeatureFlags))
+	ctx = context.WithValue(ctx, keys.MaintenanceKey, notice)
+	h := newMaintenanceHandler(ctx)
+	r := chi.NewRouter()
+	r.Use(notice.Middleware)
+	r.Put("/admin/maintenance/banner", h.SetMaintenanceBanner)
+	r.Delete("/admin/maintenance/banner", h.ClearMaintenanceBanner)
+	r.Mount("/api/v1/capabilities", CapabilitiesRouter(ctx))
+
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/api/v1/capabilities", ""); w.Header().Get(maintenance.HeaderName) != "" || !strings.Contains(w.Body.String(), `"maintenance":null`) {
+		t.Fatalf("expected no banner before one is set got header %q body %s", w.Header().Get(maintenance.HeaderName), w.Body.String())
+	}
+
+	w := do("PUT", "/admin/maintenance/banner", `{"message": "database upgrade at 18:00", "severity": "critical"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	expected := "critical: database upgrade at 18:00"
+	if h := w.Header().Get(maintenance.HeaderName); h != expected {
+		t.Errorf("expected the set response to carry the banner got %q", h)
+	}
+	w = do("GET", "/api/v1/capabilities", "")
+	if h := w.Header().Get(maintenance.HeaderName); h != expected {
+		t.Errorf("expected header %q got %q", expected, h)
+	}
+	if !strings.Contains(w.Body.String(), `"message":"database upgrade at 18:00"`) {
+		t.Errorf("expected the banner in capabilities got %s", w.Body.String())
+	}
+
+	if w := do("PUT", "/admin/maintenance/banner", `{"message": "upgrade", "severity": "urgent"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+
+	w = do("DELETE", "/admin/maintenance/banner", "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusNoContent)
+	}
+	if h := w.Header().Get(maintenance.HeaderName); h != "" {
+		t.Errorf("expected the clear response to drop the banner got %q", h)
+	}
+	if w := do("GET", "/api/v1/capabilities", ""); w.Header().Get(maintenance.HeaderName) != "" {
+		t.Errorf("expected no header after clearing got %q", w.Header().Get(maintenance.HeaderName))
+	}
+}
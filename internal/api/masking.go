@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// defaultMaskedUserFields are masked if masked_user_fields isn't set
+var defaultMaskedUserFields = []string{"email"}
+
+// fieldMask blanks the configured fields of user responses, see
+// config.MaskableUserFields, for callers who aren't admins, unless the user
+// is the caller themselves
+type fieldMask struct {
+	fields []string
+}
+
+// newFieldMask returns the mask of fields, or the default fields if they're nil.
+// An empty list masks nothing.
+func newFieldMask(fields []string) fieldMask {
+	if fields == nil {
+		fields = defaultMaskedUserFields
+	}
+	return fieldMask{fields: fields}
+}
+
+// hides reports whether u should be masked for the caller of the request
+func (m fieldMask) hides(r *http.Request, u *UserResponse) bool {
+	if len(m.fields) == 0 {
+		return false
+	}
+	ctx := r.Context()
+	if role, _ := ctx.Value(keys.RoleKey).(string); role == "admin" {
+		return false
+	}
+	if userId, ok := ctx.Value(keys.AuthUserIdKey).(int); ok && userId != 0 && userId == u.Id {
+		return false
+	}
+	if username, ok := ctx.Value(keys.AuthUsernameKey).(string); ok && username != "" && username == u.Username {
+		return false
+	}
+	return true
+}
+
+// user returns u as the caller of the request should see it. Masked users
+// are copies, so u can still be handed to webhooks unmasked.
+func (m fieldMask) user(r *http.Request, u *UserResponse) *UserResponse {
+	if !m.hides(r, u) {
+		return u
+	}
+	masked := *u
+	for _, field := range m.fields {
+		switch field {
+		case "email":
+			masked.Email = ""
+		case "firstname":
+			masked.FirstName = ""
+		case "lastname":
+			masked.LastName = ""
+		case "last_login_at":
+			masked.LastLoginAt = nil
+		}
+	}
+	return &masked
+}
+
+// users masks each UserResponse of list, see user
+func (m fieldMask) users(r *http.Request, list []render.Renderer) []render.Renderer {
+	masked := make([]render.Renderer, 0, len(list))
+	for _, item := range list {
+		if u, ok := item.(*UserResponse); ok {
+			item = m.user(r, u)
+		}
+		masked = append(masked, item)
+	}
+	return masked
+}
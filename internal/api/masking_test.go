@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestMaskedUserFields(t *testing.T) {
+	store := data.NewMemoryStore()
+	self, err := store.CreateUser(&data.UserRequest{Username: "testmaskself", Email: "testmaskself@localhost", FirstName: "Test", LastName: "Self"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.CreateUser(&data.UserRequest{Username: "testmaskother", Email: "testmaskother@localhost", FirstName: "Test", LastName: "Other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, &config.ServerConfig{})
+	ctx = context.WithValue(ctx, keys.StoreKey, store)
+	users := UsersRouter(ctx)
+
+	tests := []struct {
+		name      string
+		role      string
+		user      *data.User
+		wantEmail string
+	}{
+		{"AdminSeesEmail", "admin", other, other.Email},
+		{"UserSeesOwnEmail", "user", self, self.Email},
+		{"UserSeesOtherMasked", "user", other, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/%d", tt.user.Id), nil)
+			reqCtx := context.WithValue(req.Context(), keys.RoleKey, tt.role)
+			reqCtx = context.WithValue(reqCtx, keys.AuthUsernameKey, self.Username)
+			w := httptest.NewRecorder()
+			users.ServeHTTP(w, req.WithContext(reqCtx))
+			if w.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			var u UserResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+				t.Fatal(err)
+			}
+			if u.Email != tt.wantEmail {
+				t.Errorf("expected email %q got %q", tt.wantEmail, u.Email)
+			}
+			if u.Username != tt.user.Username {
+				t.Errorf("expected username %v got %v", tt.user.Username, u.Username)
+			}
+		})
+	}
+
+	// the list masks each user but the caller's own
+	req := httptest.NewRequest("GET", "/", nil)
+	reqCtx := context.WithValue(req.Context(), keys.RoleKey, "user")
+	reqCtx = context.WithValue(reqCtx, keys.AuthUserIdKey, self.Id)
+	w := httptest.NewRecorder()
+	users.ServeHTTP(w, req.WithContext(reqCtx))
+	var list []UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Email != self.Email || list[1].Email != "" {
+		t.Errorf("expected only the caller's email in the list got %+v", list)
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type MembershipFilterRequest struct {
+	PirgIds        []int             `json:"pirg_ids"`
+	UserAttributes map[string]string `json:"user_attributes"`
+	ExpiresBefore  *time.Time        `json:"expires_before"`
+}
+
+// MembershipDeactivateBulkRequest removes every membership matching Filter
+type MembershipDeactivateBulkRequest struct {
+	Filter MembershipFilterRequest `json:"filter"`
+}
+
+func (m *MembershipDeactivateBulkRequest) Bind(r *http.Request) error {
+	if m.filter().IsEmpty() {
+		return data.ErrEmptyMembershipFilter
+	}
+	for k := range m.Filter.UserAttributes {
+		if k == "" {
+			return errors.New("user attribute keys must not be empty")
+		}
+	}
+	return nil
+}
+
+func (m *MembershipDeactivateBulkRequest) filter() data.MembershipFilter {
+	return data.MembershipFilter{
+		PirgIds:        m.Filter.PirgIds,
+		UserAttributes: m.Filter.UserAttributes,
+		ExpiresBefore:  m.Filter.ExpiresBefore,
+	}
+}
+
+type MembershipDeactivateBulkResponse struct {
+	Deactivated int `json:"deactivated"`
+}
+
+func (m *MembershipDeactivateBulkResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type MembershipHandler struct {
+	dbConn *sql.DB
+}
+
+// MembershipsRouter serves changes to pirg memberships across pirgs
+func MembershipsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newMembershipHandler(ctx)
+	r.Post("/bulk-deactivate", h.DeactivateMembershipsBulk)
+	return r
+}
+
+func newMembershipHandler(ctx context.Context) *MembershipHandler {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	return &MembershipHandler{dbConn: dbConn}
+}
+
+// DeactivateMembershipsBulk removes every pirg membership matching the filter in
+// the request body, such as those of the users a grant that ended sponsored, and
+// returns how many were removed. Pirg owners are never removed. Only admins can
+// make bulk changes.
+func (h *MembershipHandler) DeactivateMembershipsBulk(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("deactivating memberships in bulk", "package", "api", "method", "DeactivateMembershipsBulk")
+	if role, _ := r.Context().Value(keys.RoleKey).(string); role != "admin" {
+		render.Render(w, r, ErrForbidden)
+		return
+	}
+	req := &MembershipDeactivateBulkRequest{}
+	if err := render.Bind(r, req); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	actor := actorFromContext(r.Context())
+	count, err := data.DeactivatePirgMembersBulk(h.dbConn, req.filter(), actor)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	slog.Info("deactivated memberships in bulk", "deactivated", count, "actor", actor, "package", "api", "method", "DeactivateMembershipsBulk")
+	if err := render.Render(w, r, &MembershipDeactivateBulkResponse{Deactivated: count}); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestDeactivateMembershipsBulkRejects(t *testing.T) {
+	h := &MembershipHandler{}
+	tests := []struct {
+		name   string
+		role   string
+		body   string
+		status int
+	}{
+		{"NotAdmin", "user", `{"filter": {"pirg_ids": [1]}}`, http.StatusForbidden},
+		{"NoFilter", "admin", `{}`, http.StatusBadRequest},
+		{"EmptyFilter", "admin", `{"filter": {"pirg_ids": [], "user_attributes": {}}}`, http.StatusBadRequest},
+		{"EmptyAttributeKey", "admin", `{"filter": {"user_attributes": {"": "GrantX"}}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/memberships/bulk-deactivate", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), keys.RoleKey, tt.role))
+			w := httptest.NewRecorder()
+			h.DeactivateMembershipsBulk(w, req)
+			if w.Code != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/notify"
+)
+
+type TestNotificationRequest struct {
+	Recipient string `json:"recipient"`
+}
+
+func (n *TestNotificationRequest) Bind(r *http.Request) error {
+	if n.Recipient == "" {
+		return fmt.Errorf("missing required recipient")
+	}
+	addr, err := mail.ParseAddress(n.Recipient)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %v", err)
+	}
+	n.Recipient = addr.Address
+	return nil
+}
+
+type TestNotificationResponse struct {
+	Recipient string `json:"recipient"`
+	Sent      bool   `json:"sent"`
+}
+
+func (n *TestNotificationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type NotificationHandler struct {
+	notifier notify.Notifier
+}
+
+func newNotificationHandler(ctx context.Context) *NotificationHandler {
+	notifier := ctx.Value(keys.NotifierKey).(notify.Notifier)
+	return &NotificationHandler{notifier: notifier}
+}
+
+// SendTestNotification sends a test message to the recipient through the
+// configured notifier, to check delivery works. Nothing is stored, and a
+// failed delivery returns the error from the mail server.
+func (h *NotificationHandler) SendTestNotification(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("sending test notification", "package", "api", "method", "SendTestNotification")
+	req := &TestNotificationRequest{}
+	if err := render.Bind(r, req); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	err := h.notifier.Send(r.Context(), notify.Message{
+		To:      []string{req.Recipient},
+		Subject: "hpcadmin-server test notification",
+		Body:    fmt.Sprintf("This is a test notification requested by %s.\n", actorFromContext(r.Context())),
+	})
+	if errors.Is(err, notify.ErrNotConfigured) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err != nil {
+		slog.Warn("test notification failed", "error", err, "package", "api", "method", "SendTestNotification")
+		render.Render(w, r, ErrBadGateway(fmt.Errorf("failed to deliver test notification: %v", err)))
+		return
+	}
+	if err := render.Render(w, r, &TestNotificationResponse{Recipient: req.Recipient, Sent: true}); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
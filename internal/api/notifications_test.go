@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/notify"
+)
+
+func TestSendTestNotification(t *testing.T) {
+	do := func(notifier notify.Notifier, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		ctx := context.WithValue(context.Background(), keys.NotifierKey, notifier)
+		h := newNotificationHandler(ctx)
+		req := httptest.NewRequest("POST", "/admin/notifications/test", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.SendTestNotification(w, req)
+		return w
+	}
+
+	t.Run("Sent", func(t *testing.T) {
+		notifier := &notify.Recorder{}
+		w := do(notifier, `{"recipient": "Admin <admin@example.com>"}`)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"sent":true`) {
+			t.Fatalf("expected the notification to be sent got %v %s", w.Code, w.Body.String())
+		}
+		sent := notifier.Sent()
+		if len(sent) != 1 || len(sent[0].To) != 1 || sent[0].To[0] != "admin@example.com" {
+			t.Errorf("expected one message to admin@example.com got %+v", sent)
+		}
+	})
+
+	t.Run("DeliveryError", func(t *testing.T) {
+		notifier := &notify.Recorder{Err: errors.New("550 mailbox unavailable")}
+		w := do(notifier, `{"recipient": "admin@example.com"}`)
+		if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "550 mailbox unavailable") {
+			t.Errorf("expected the delivery error got %v %s", w.Code, w.Body.String())
+		}
+		if len(notifier.Sent()) != 1 {
+			t.Errorf("expected the send to be attempted got %+v", notifier.Sent())
+		}
+	})
+
+	t.Run("InvalidRecipient", func(t *testing.T) {
+		notifier := &notify.Recorder{}
+		for _, body := range []string{`{}`, `{"recipient": "not an address"}`} {
+			if w := do(notifier, body); w.Code != http.StatusBadRequest {
+				t.Errorf("expected %s to be rejected got %v", body, w.Code)
+			}
+		}
+		if len(notifier.Sent()) != 0 {
+			t.Errorf("expected nothing to be sent got %+v", notifier.Sent())
+		}
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		w := do(notify.New(config.NotificationConfig{}), `{"recipient": "admin@example.com"}`)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "not configured") {
+			t.Errorf("expected not configured got %v %s", w.Code, w.Body.String())
+		}
+	})
+}
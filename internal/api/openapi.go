@@ -0,0 +1,304 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the document follows
+const openAPIVersion = "3.0.3"
+
+// OpenAPIDocument is an OpenAPI 3.0 document. Only the parts the generated
+// spec uses are here.
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]map[string]any `json:"schemas"`
+	SecuritySchemes map[string]map[string]any `json:"securitySchemes"`
+}
+
+type OpenAPIOperation struct {
+	OperationId string                      `json:"operationId"`
+	Parameters  []map[string]any            `json:"parameters,omitempty"`
+	RequestBody map[string]any              `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	// Security is only set, to an empty list, on the public routes
+	Security *[]map[string][]string `json:"security,omitempty"`
+}
+
+type OpenAPIResponse struct {
+	Description string         `json:"description"`
+	Content     map[string]any `json:"content,omitempty"`
+}
+
+// openAPISchemas are the resource types the spec has schemas for, by schema
+// name. Their properties are read from the json tags, and required lists
+// the properties a request has to set.
+var openAPISchemas = []struct {
+	name     string
+	value    any
+	required []string
+}{
+	{"User", UserResponse{}, nil},
+	{"UserRequest", UserRequest{}, []string{"username", "email", "firstname", "lastname"}},
+	{"UserPatchRequest", UserRequest{}, nil},
+	{"Pirg", PirgResponse{}, nil},
+	{"PirgRequest", PirgRequest{}, []string{"name", "owner_id", "admin_ids", "user_ids"}},
+	{"Error", ErrResponse{}, []string{"status", "error", "message"}},
+}
+
+// openAPIOperationSchemas hand-registers the bodies of the routes that use
+// the schemas, since they can't be read from the router. Lists return one
+// object instead when a filter looks a single one up, so they're either.
+var openAPIOperationSchemas = map[string]struct {
+	request  string
+	response string
+	list     bool
+	status   int
+}{
+	"GET /api/v1/users":                        {response: "User", list: true},
+	"POST /api/v1/users":                       {request: "UserRequest", response: "User", status: http.StatusCreated},
+	"GET /api/v1/users/{userID}":               {response: "User"},
+	"PUT /api/v1/users/{userID}":               {request: "UserRequest", response: "User"},
+	"PATCH /api/v1/users/{userID}":             {request: "UserPatchRequest", response: "User"},
+	"DELETE /api/v1/users/{userID}":            {status: http.StatusNoContent},
+	"POST /api/v1/users/{userID}/restore":      {response: "User"},
+	"POST /api/v1/users/{userID}/login":        {status: http.StatusNoContent},
+	"POST /api/v1/users/{userID}/verify-email": {status: http.StatusNoContent},
+	"GET /api/v1/users/by-uid/{uid}":           {response: "User"},
+	"GET /api/v1/me":                           {response: "User"},
+	"GET /api/v1/pirgs":                        {response: "Pirg", list: true},
+	"POST /api/v1/pirgs":                       {request: "PirgRequest", response: "Pirg", status: http.StatusCreated},
+	"PUT /api/v1/pirgs/by-name/{pirgName}":     {request: "PirgRequest", response: "Pirg"},
+	"GET /api/v1/pirgs/{pirgID}":               {response: "Pirg"},
+	"PUT /api/v1/pirgs/{pirgID}":               {request: "PirgRequest", response: "Pirg"},
+	"DELETE /api/v1/pirgs/{pirgID}":            {status: http.StatusNoContent},
+}
+
+// openAPIPublicPaths don't need a token, every other route does
+var openAPIPublicPaths = []string{"/", "/healthz", "/readyz", "/metrics", "/api/v1/time", "/oauth"}
+
+// chiParamRegexp matches a path param and the regexp it may be restricted to
+var chiParamRegexp = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// integerParamRegexp matches the names of path params that are ids
+var integerParamRegexp = regexp.MustCompile(`(ID|Id|^uid)$`)
+
+// handlerNameRegexp matches the method or function name at the end of a
+// handler's symbol, without the -fm suffix of method values
+var handlerNameRegexp = regexp.MustCompile(`\.([A-Za-z_][A-Za-z0-9_]*)(-fm)?$`)
+
+// GenerateOpenAPI writes an OpenAPI 3.0 JSON document of the router's routes
+// to path. Every route is in it, but only the User and Pirg routes registered
+// in openAPIOperationSchemas have request and response schemas.
+func GenerateOpenAPI(r chi.Router, path string) error {
+	doc, err := NewOpenAPIDocument(r)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
+
+// NewOpenAPIDocument walks the router and builds its OpenAPI document
+func NewOpenAPIDocument(r chi.Router) (*OpenAPIDocument, error) {
+	doc := &OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    OpenAPIInfo{Title: "HPCAdmin REST API", Version: "v1"},
+		Paths:   map[string]map[string]*OpenAPIOperation{},
+		Components: OpenAPIComponents{
+			Schemas: map[string]map[string]any{},
+			SecuritySchemes: map[string]map[string]any{
+				"bearerAuth": {"type": "http", "scheme": "bearer"},
+			},
+		},
+		Security: []map[string][]string{{"bearerAuth": {}}},
+	}
+	for _, s := range openAPISchemas {
+		schema := openAPISchema(reflect.TypeOf(s.value))
+		if len(s.required) > 0 {
+			schema["required"] = s.required
+		}
+		doc.Components.Schemas[s.name] = schema
+	}
+
+	operationIds := map[string]bool{}
+	err := chi.Walk(r, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		path := openAPIPath(route)
+		op := &OpenAPIOperation{
+			OperationId: openAPIOperationId(handler, method, path, operationIds),
+			Parameters:  openAPIParameters(path),
+			Responses: map[string]*OpenAPIResponse{
+				"default": {Description: "Error", Content: openAPIContent(openAPIRef("Error"))},
+			},
+		}
+		if openAPIPublic(path) {
+			op.Security = &[]map[string][]string{}
+		}
+		status := http.StatusOK
+		var body map[string]any
+		if s, ok := openAPIOperationSchemas[method+" "+path]; ok {
+			if s.status != 0 {
+				status = s.status
+			}
+			if s.request != "" {
+				op.RequestBody = map[string]any{"required": true, "content": openAPIContent(openAPIRef(s.request))}
+			}
+			if s.response != "" {
+				body = openAPIRef(s.response)
+				if s.list {
+					body = map[string]any{"oneOf": []any{
+						map[string]any{"type": "array", "items": openAPIRef(s.response)},
+						openAPIRef(s.response),
+					}}
+				}
+			}
+		}
+		resp := &OpenAPIResponse{Description: http.StatusText(status)}
+		if body != nil {
+			resp.Content = openAPIContent(body)
+		}
+		op.Responses[fmt.Sprint(status)] = resp
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*OpenAPIOperation{}
+		}
+		doc.Paths[path][strings.ToLower(method)] = op
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk routes: %v", err)
+	}
+	return doc, nil
+}
+
+// openAPIPath turns a chi route into an OpenAPI path. The trailing slash of
+// the routes of a subrouter is dropped, and so are param regexps.
+func openAPIPath(route string) string {
+	path := strings.TrimSuffix(route, "/*")
+	path = chiParamRegexp.ReplaceAllString(path, "{$1}")
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func openAPIParameters(path string) []map[string]any {
+	params := []map[string]any{}
+	for _, m := range chiParamRegexp.FindAllStringSubmatch(path, -1) {
+		schema := map[string]any{"type": "string"}
+		if integerParamRegexp.MatchString(m[1]) {
+			schema = map[string]any{"type": "integer"}
+		}
+		params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": schema})
+	}
+	return params
+}
+
+// openAPIOperationId is the name of the handler, or if it doesn't have a
+// unique one, the method and path
+func openAPIOperationId(handler http.Handler, method string, path string, seen map[string]bool) string {
+	id := ""
+	if f, ok := handler.(http.HandlerFunc); ok {
+		if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+			if m := handlerNameRegexp.FindStringSubmatch(fn.Name()); m != nil && !strings.HasPrefix(m[1], "func") {
+				id = m[1]
+			}
+		}
+	}
+	if id == "" || seen[id] {
+		id = strings.ToLower(method) + strings.Map(func(r rune) rune {
+			if r == '/' || r == '{' || r == '}' || r == '-' {
+				return '_'
+			}
+			return r
+		}, path)
+	}
+	seen[id] = true
+	return id
+}
+
+func openAPIPublic(path string) bool {
+	for _, p := range openAPIPublicPaths {
+		if path == p || (p != "/" && strings.HasPrefix(path, p+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func openAPIRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func openAPIContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// openAPISchema describes a type from its json encoding
+func openAPISchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": openAPISchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": openAPISchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = openAPISchema(f.Type)
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestGenerateOpenAPI(t *testing.T) {
+	h := &UserHandler{}
+	users := chi.NewRouter()
+	users.Get("/", h.GetAllUsers)
+	users.Post("/", h.CreateUser)
+	users.Route("/{userID}", func(r chi.Router) {
+		r.Get("/", h.GetUser)
+		r.Patch("/", h.PatchUser)
+	})
+	r := chi.NewRouter()
+	r.Get("/healthz", Healthz)
+	r.Get("/admin/users/{userId:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Mount("/users", users)
+	})
+
+	path := filepath.Join(t.TempDir(), "openapi.json")
+	if err := GenerateOpenAPI(r, path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc OpenAPIDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != openAPIVersion {
+		t.Errorf("expected openapi version %s, got %s", openAPIVersion, doc.OpenAPI)
+	}
+	for _, want := range []string{"/healthz", "/admin/users/{userId}", "/api/v1/users", "/api/v1/users/{userID}"} {
+		if doc.Paths[want] == nil {
+			t.Errorf("expected path %s, got %v", want, doc.Paths)
+		}
+	}
+
+	create := doc.Paths["/api/v1/users"]["post"]
+	if create == nil {
+		t.Fatalf("expected POST /api/v1/users")
+	}
+	if create.OperationId != "CreateUser" {
+		t.Errorf("expected operationId CreateUser, got %s", create.OperationId)
+	}
+	if create.Responses["201"] == nil || create.RequestBody == nil {
+		t.Errorf("expected a request body and a 201 response, got %+v", create)
+	}
+	get := doc.Paths["/api/v1/users/{userID}"]["get"]
+	if len(get.Parameters) != 1 || get.Parameters[0]["name"] != "userID" || get.Parameters[0]["in"] != "path" {
+		t.Errorf("expected the userID path param, got %v", get.Parameters)
+	}
+	if doc.Paths["/healthz"]["get"].Security == nil {
+		t.Errorf("expected /healthz to not need a token")
+	}
+	if get.Security != nil {
+		t.Errorf("expected /api/v1/users/{userID} to use the default security")
+	}
+
+	user := doc.Components.Schemas["User"]
+	properties, _ := user["properties"].(map[string]any)
+	for _, want := range []string{"id", "username", "email", "firstname", "lastname", "created_at", "modified_at"} {
+		if properties[want] == nil {
+			t.Errorf("expected User schema to have property %s", want)
+		}
+	}
+	pirgRequest := doc.Components.Schemas["PirgRequest"]
+	properties, _ = pirgRequest["properties"].(map[string]any)
+	if userIds, _ := properties["user_ids"].(map[string]any); userIds["type"] != "array" {
+		t.Errorf("expected PirgRequest user_ids to be an array, got %v", properties["user_ids"])
+	}
+}
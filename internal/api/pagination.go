@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// totalCountHeader carries the total when a page is returned without the envelope
+const totalCountHeader = "X-Total-Count"
+
+// nextCursorHeader carries the next cursor when a cursor page is returned without the envelope
+const nextCursorHeader = "X-Next-Cursor"
+
+// PageResponse wraps a page of a list endpoint with the information
+// needed to request the next one
+type PageResponse struct {
+	Items  any `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+func (p *PageResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// CursorPageResponse wraps a page of a list endpoint paged by cursor.
+// NextCursor is empty on the last page.
+type CursorPageResponse struct {
+	Items      any    `json:"items"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor"`
+}
+
+func (p *CursorPageResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// encodeCursor returns the opaque cursor for resuming a list after id
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	id, err := strconv.Atoi(string(b))
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	return id, nil
+}
+
+// pageLimits holds the configured page sizes for a handler,
+// and whether pages are wrapped in the PageResponse envelope by default
+type pageLimits struct {
+	defaultLimit int
+	maxLimit     int
+	envelope     bool
+	// roles replace the page sizes for requests with the role
+	roles map[string]pageLimits
+}
+
+func newPageLimits(cfg config.PaginationConfig, envelope *bool) pageLimits {
+	p := pageLimits{defaultLimit: cfg.DefaultLimit, maxLimit: cfg.MaxLimit, envelope: true}
+	if envelope != nil {
+		p.envelope = *envelope
+	}
+	if p.maxLimit == 0 {
+		p.maxLimit = maxPageLimit
+	}
+	if p.defaultLimit == 0 {
+		p.defaultLimit = min(defaultPageLimit, p.maxLimit)
+	}
+	if len(cfg.Roles) > 0 {
+		p.roles = map[string]pageLimits{}
+	}
+	for role, rc := range cfg.Roles {
+		rp := pageLimits{defaultLimit: rc.DefaultLimit, maxLimit: rc.MaxLimit, envelope: p.envelope}
+		if rp.maxLimit == 0 {
+			rp.maxLimit = p.maxLimit
+		}
+		if rp.defaultLimit == 0 {
+			rp.defaultLimit = min(p.defaultLimit, rp.maxLimit)
+		}
+		p.roles[role] = rp
+	}
+	return p
+}
+
+// forRequest returns the page sizes for the role the request was authenticated
+// with, or the global ones if the role has no override
+func (p pageLimits) forRequest(r *http.Request) pageLimits {
+	role, _ := r.Context().Value(keys.RoleKey).(string)
+	if rp, ok := p.roles[role]; ok {
+		return rp
+	}
+	return p
+}
+
+// parse reads the limit and offset query params.
+// limit defaults to the configured default for the request's role
+// and is capped at the configured max.
+func (p pageLimits) parse(r *http.Request) (int, int, error) {
+	p = p.forRequest(r)
+	limit := p.defaultLimit
+	offset := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer: %s", v)
+		}
+		limit = min(l, p.maxLimit)
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer: %s", v)
+		}
+		offset = o
+	}
+	if _, err := p.wantEnvelope(r); err != nil {
+		return 0, 0, err
+	}
+	return limit, offset, nil
+}
+
+// wantsCursor reports whether the request asks for a cursor page,
+// which it does by passing either a limit or a cursor
+func wantsCursor(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Has("limit") || q.Has("cursor")
+}
+
+// parseCursor reads the limit and cursor query params. limit is handled the
+// same as parse, and the returned id is the one the cursor resumes after,
+// 0 for the first page.
+func (p pageLimits) parseCursor(r *http.Request) (int, int, error) {
+	limit, err := p.parseLimit(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	afterId := 0
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		id, err := decodeCursor(v)
+		if err != nil {
+			return 0, 0, err
+		}
+		afterId = id
+	}
+	return limit, afterId, nil
+}
+
+// parseLimit reads the limit query param alone, for cursor pages whose
+// cursor isn't an id. It's handled the same as parse.
+func (p pageLimits) parseLimit(r *http.Request) (int, error) {
+	p = p.forRequest(r)
+	limit := p.defaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			return 0, fmt.Errorf("limit must be a positive integer: %s", v)
+		}
+		limit = min(l, p.maxLimit)
+	}
+	if _, err := p.wantEnvelope(r); err != nil {
+		return 0, err
+	}
+	return limit, nil
+}
+
+// renderCursor writes the page either as the CursorPageResponse envelope,
+// or as the bare items with the next cursor in the X-Next-Cursor header
+func (p pageLimits) renderCursor(w http.ResponseWriter, r *http.Request, resp *CursorPageResponse) {
+	envelope, err := p.wantEnvelope(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !envelope {
+		if resp.NextCursor != "" {
+			w.Header().Set(nextCursorHeader, resp.NextCursor)
+		}
+		render.JSON(w, r, resp.Items)
+		return
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// wantEnvelope reports whether the page should be enveloped,
+// using the envelope query param if present
+func (p pageLimits) wantEnvelope(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("envelope")
+	if v == "" {
+		return p.envelope, nil
+	}
+	envelope, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("envelope must be true or false: %s", v)
+	}
+	return envelope, nil
+}
+
+// render writes the page either as the PageResponse envelope,
+// or as the bare items with the total in the X-Total-Count header
+func (p pageLimits) render(w http.ResponseWriter, r *http.Request, resp *PageResponse) {
+	envelope, err := p.wantEnvelope(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !envelope {
+		w.Header().Set(totalCountHeader, strconv.Itoa(resp.Total))
+		render.JSON(w, r, resp.Items)
+		return
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestPageLimitsParseCursor(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantLimit   int
+		wantAfterId int
+		wantErr     bool
+	}{
+		{"FirstPage", "?limit=10", 10, 0, false},
+		{"DefaultLimit", "?cursor=" + encodeCursor(42), defaultPageLimit, 42, false},
+		{"CappedAtMax", "?limit=100000&cursor=" + encodeCursor(7), maxPageLimit, 7, false},
+		{"BadCursor", "?cursor=!!!", 0, 0, true},
+		{"NotAnId", "?cursor=" + base64.RawURLEncoding.EncodeToString([]byte("abc")), 0, 0, true},
+		{"ZeroLimit", "?limit=0", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/"+tt.query, nil)
+			limit, afterId, err := newPageLimits(config.PaginationConfig{}, nil).parseCursor(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if limit != tt.wantLimit || afterId != tt.wantAfterId {
+				t.Errorf("expected limit %v after %v got %v %v", tt.wantLimit, tt.wantAfterId, limit, afterId)
+			}
+		})
+	}
+}
+
+func TestPageLimitsParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.PaginationConfig
+		query      string
+		wantLimit  int
+		wantOffset int
+		wantErr    bool
+	}{
+		{"Defaults", config.PaginationConfig{}, "", defaultPageLimit, 0, false},
+		{"Explicit", config.PaginationConfig{}, "?limit=10&offset=20", 10, 20, false},
+		{"CappedAtMax", config.PaginationConfig{}, "?limit=100000", maxPageLimit, 0, false},
+		{"ConfiguredDefault", config.PaginationConfig{DefaultLimit: 25}, "", 25, 0, false},
+		{"ConfiguredMax", config.PaginationConfig{MaxLimit: 20}, "?limit=30", 20, 0, false},
+		{"DefaultWithinMax", config.PaginationConfig{MaxLimit: 20}, "", 20, 0, false},
+		{"ZeroLimit", config.PaginationConfig{}, "?limit=0", 0, 0, true},
+		{"BadLimit", config.PaginationConfig{}, "?limit=abc", 0, 0, true},
+		{"NegativeOffset", config.PaginationConfig{}, "?offset=-1", 0, 0, true},
+		{"BadEnvelope", config.PaginationConfig{}, "?envelope=maybe", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/"+tt.query, nil)
+			limit, offset, err := newPageLimits(tt.cfg, nil).parse(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if limit != tt.wantLimit || offset != tt.wantOffset {
+				t.Errorf("expected limit %v offset %v got %v %v", tt.wantLimit, tt.wantOffset, limit, offset)
+			}
+		})
+	}
+}
+
+func TestPageLimitsParseRoles(t *testing.T) {
+	cfg := config.PaginationConfig{
+		DefaultLimit: 20,
+		MaxLimit:     100,
+		Roles: map[string]config.RolePaginationConfig{
+			"sync-service": {DefaultLimit: 1000, MaxLimit: 5000},
+			"ui":           {MaxLimit: 10},
+		},
+	}
+	pages := newPageLimits(cfg, nil)
+	tests := []struct {
+		role      string
+		query     string
+		wantLimit int
+	}{
+		{"sync-service", "", 1000},
+		{"sync-service", "?limit=4000", 4000},
+		{"sync-service", "?limit=10000", 5000},
+		// the role inherits the global default, capped at its max
+		{"ui", "", 10},
+		{"ui", "?limit=50", 10},
+		// roles without an override use the global limits
+		{"user", "", 20},
+		{"user", "?limit=4000", 100},
+		{"", "", 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role+tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/"+tt.query, nil)
+			if tt.role != "" {
+				r = r.WithContext(context.WithValue(r.Context(), keys.RoleKey, tt.role))
+			}
+			limit, _, err := pages.parse(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limit != tt.wantLimit {
+				t.Errorf("expected limit %v got %v", tt.wantLimit, limit)
+			}
+		})
+	}
+}
+
+func TestPageLimitsRender(t *testing.T) {
+	enabled, disabled := true, false
+	page := &PageResponse{Items: []string{"a", "b"}, Total: 5, Limit: 2, Offset: 0}
+	tests := []struct {
+		name         string
+		envelope     *bool
+		query        string
+		wantEnvelope bool
+	}{
+		{"DefaultEnvelope", nil, "", true},
+		{"ConfiguredEnvelope", &enabled, "", true},
+		{"ConfiguredBare", &disabled, "", false},
+		{"QueryBare", nil, "?envelope=false", false},
+		{"QueryEnvelope", &disabled, "?envelope=true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/"+tt.query, nil)
+			w := httptest.NewRecorder()
+			newPageLimits(config.PaginationConfig{}, tt.envelope).render(w, r, page)
+			if tt.wantEnvelope {
+				var got struct {
+					Items []string `json:"items"`
+					Total int      `json:"total"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatalf("expected an envelope got %s", w.Body.String())
+				}
+				if len(got.Items) != 2 || got.Total != 5 {
+					t.Errorf("unexpected envelope %+v", got)
+				}
+				return
+			}
+			var got []string
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("expected a bare array got %s", w.Body.String())
+			}
+			if strings.Join(got, ",") != "a,b" {
+				t.Errorf("unexpected items %v", got)
+			}
+			if total := w.Header().Get(totalCountHeader); total != "5" {
+				t.Errorf("expected %v header 5 got %q", totalCountHeader, total)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type PartitionResponse struct {
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description" yaml:"description"`
+	MaxNodes    int               `json:"max_nodes" yaml:"max_nodes"`
+	MaxCPUs     int               `json:"max_cpus" yaml:"max_cpus"`
+	MaxWallTime string            `json:"max_walltime" yaml:"max_walltime"`
+	Metadata    map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+func (p *PartitionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPartitionResponse(p config.PartitionConfig) *PartitionResponse {
+	metadata := p.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return &PartitionResponse{
+		Name:        p.Name,
+		Description: p.Description,
+		MaxNodes:    p.MaxNodes,
+		MaxCPUs:     p.MaxCPUs,
+		MaxWallTime: p.MaxWallTime,
+		Metadata:    metadata,
+	}
+}
+
+// newPartitionResponseList converts the configured partitions into a list of render.Renderer objects
+func newPartitionResponseList(partitions []config.PartitionConfig) []render.Renderer {
+	list := []render.Renderer{}
+	for _, p := range partitions {
+		list = append(list, newPartitionResponse(p))
+	}
+	return list
+}
+
+type PartitionHandler struct {
+	partitions []config.PartitionConfig
+}
+
+func PartitionsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newPartitionHandler(ctx)
+	r.Get("/", h.GetAllPartitions)
+	return r
+}
+
+func newPartitionHandler(ctx context.Context) *PartitionHandler {
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	return &PartitionHandler{partitions: cfg.Partitions}
+}
+
+// GetAllPartitions returns the partitions from the server configuration
+func (h *PartitionHandler) GetAllPartitions(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting all partitions", "package", "api", "method", "GetAllPartitions")
+	resp := newPartitionResponseList(h.partitions)
+	if err := render.RenderList(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestAPIGetAllPartitions(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Partitions: []config.PartitionConfig{
+			{Name: "compute", MaxNodes: 10, MaxWallTime: "1-00:00:00"},
+			{Name: "gpu", MaxCPUs: 64, Metadata: map[string]string{"gres": "gpu:a100"}},
+		},
+	}
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, cfg)
+	srv := httptest.NewServer(PartitionsRouter(ctx))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	var partitions []PartitionResponse
+	err = json.NewDecoder(resp.Body).Decode(&partitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partitions) != len(cfg.Partitions) {
+		t.Fatalf("expected %v partitions got %v", len(cfg.Partitions), len(partitions))
+	}
+	for i, p := range cfg.Partitions {
+		if partitions[i].Name != p.Name {
+			t.Errorf("expected partition %v got %v", p.Name, partitions[i].Name)
+		}
+	}
+	if partitions[0].MaxNodes != 10 || partitions[0].MaxWallTime != "1-00:00:00" {
+		t.Errorf("expected compute limits to be returned, got %+v", partitions[0])
+	}
+	if partitions[1].Metadata["gres"] != "gpu:a100" {
+		t.Errorf("expected gpu metadata to be returned, got %+v", partitions[1].Metadata)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/notify"
+)
+
+// pirgNotifier notifies the recipients of a pirg when its members change, see
+// config.PirgNotificationConfig. It's nil while pirg notifications are
+// disabled, and does nothing then.
+type pirgNotifier struct {
+	notifier notify.Notifier
+	store    data.Store
+	cfg      config.PirgNotificationConfig
+	// sends are the notifications still being sent, for tests to wait on
+	sends sync.WaitGroup
+}
+
+func newPirgNotifier(ctx context.Context) *pirgNotifier {
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	notifier, _ := ctx.Value(keys.NotifierKey).(notify.Notifier)
+	if !cfg.Notifications.Pirgs.Enabled || notifier == nil {
+		return nil
+	}
+	return &pirgNotifier{notifier: notifier, store: storeFromContext(ctx), cfg: cfg.Notifications.Pirgs}
+}
+
+// membershipChanged notifies the recipients of the pirg of the users added
+// and removed between before and after. It's sent in the background so it
+// doesn't hold up the response, and since the change already succeeded,
+// failures are only logged.
+func (n *pirgNotifier) membershipChanged(ctx context.Context, pirgId int, before []int, after []int) {
+	if n == nil {
+		return
+	}
+	var added, removed []int
+	for _, userId := range after {
+		if !slices.Contains(before, userId) {
+			added = append(added, userId)
+		}
+	}
+	for _, userId := range before {
+		if !slices.Contains(after, userId) {
+			removed = append(removed, userId)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	actor := actorFromContext(ctx)
+	n.sends.Add(1)
+	go func() {
+		defer n.sends.Done()
+		if err := n.send(context.WithoutCancel(ctx), actor, pirgId, added, removed); err != nil {
+			slog.Error("failed to notify pirg membership changes", "pirg_id", pirgId, "error", err, "package", "api", "method", "membershipChanged")
+		}
+	}()
+}
+
+func (n *pirgNotifier) send(ctx context.Context, actor string, pirgId int, added []int, removed []int) error {
+	pirg, err := n.store.GetPirgById(pirgId)
+	if err != nil {
+		return fmt.Errorf("failed to look up pirg: %v", err)
+	}
+	recipients := n.recipients(pirg)
+	if len(recipients) == 0 {
+		return nil
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "The members of pirg %s were changed by %s.\n", pirg.Name, actor)
+	if len(added) > 0 {
+		fmt.Fprintf(&body, "\nAdded: %s\n", n.usernames(added))
+	}
+	if len(removed) > 0 {
+		fmt.Fprintf(&body, "\nRemoved: %s\n", n.usernames(removed))
+	}
+	slog.Debug("notifying pirg membership changes", "pirg", pirg.Name, "recipients", recipients, "package", "api", "method", "send")
+	return n.notifier.Send(ctx, notify.Message{
+		To:      recipients,
+		Subject: fmt.Sprintf("hpcadmin-server: members of pirg %s changed", pirg.Name),
+		Body:    body.String(),
+	})
+}
+
+// recipients is the owner of the pirg, unless SkipOwner is set, and the
+// extra recipients configured for it, each once
+func (n *pirgNotifier) recipients(pirg *data.Pirg) []string {
+	var recipients []string
+	if !n.cfg.SkipOwner {
+		owner, err := n.store.GetUserById(pirg.OwnerId)
+		if err != nil {
+			slog.Warn("failed to look up pirg owner to notify", "pirg", pirg.Name, "owner_id", pirg.OwnerId, "error", err, "package", "api", "method", "recipients")
+		} else if owner.Email != "" {
+			recipients = append(recipients, owner.Email)
+		}
+	}
+	for _, recipient := range n.cfg.Recipients[pirg.Name] {
+		if !slices.Contains(recipients, recipient) {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// usernames lists the users by username, or by id if they can't be looked up
+func (n *pirgNotifier) usernames(userIds []int) string {
+	names := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		if u, err := n.store.GetUserByIdIncludingDeleted(userId); err == nil {
+			names = append(names, u.Username)
+		} else {
+			names = append(names, fmt.Sprintf("user %d", userId))
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/notify"
+)
+
+func TestPirgMembershipNotifications(t *testing.T) {
+	store := data.NewMemoryStore()
+	newUser := func(username string) *data.User {
+		u, err := store.CreateUser(&data.UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Notify"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	ownerA, ownerB, member := newUser("testnotifyownera"), newUser("testnotifyownerb"), newUser("testnotifymember")
+	cfg := &config.ServerConfig{Notifications: config.NotificationConfig{Pirgs: config.PirgNotificationConfig{
+		Enabled: true,
+		Recipients: map[string][]string{
+			"testnotifya": {"managera@localhost"},
+			"testnotifyb": {"managerb@localhost"},
+		},
+	}}}
+	recorder := &notify.Recorder{}
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, cfg)
+	ctx = context.WithValue(ctx, keys.StoreKey, store)
+	ctx = context.WithValue(ctx, keys.NotifierKey, notify.Notifier(recorder))
+	createPirg := func(h *PirgHandler, name string, ownerId int, memberId int) {
+		t.Helper()
+		body := fmt.Sprintf(`{"name": %q, "owner_id": %d, "admin_ids": [%d], "user_ids": [%d, %d]}`, name, ownerId, ownerId, ownerId, memberId)
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.CreatePirg(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		h.pirgNotifier.sends.Wait()
+	}
+
+	h := newPirgHandler(ctx)
+	createPirg(h, "testnotifya", ownerA.Id, member.Id)
+	sent := recorder.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected one notification got %v", sent)
+	}
+	if want := []string{ownerA.Email, "managera@localhost"}; !slices.Equal(sent[0].To, want) {
+		t.Errorf("expected the notification to go to %v got %v", want, sent[0].To)
+	}
+	if !strings.Contains(sent[0].Subject, "testnotifya") || !strings.Contains(sent[0].Body, "Added: testnotifyownera, testnotifymember") {
+		t.Errorf("expected the notification to name the pirg and the added member got %+v", sent[0])
+	}
+
+	// skip_owner only notifies the configured recipients
+	cfg.Notifications.Pirgs.SkipOwner = true
+	createPirg(newPirgHandler(ctx), "testnotifyb", ownerB.Id, member.Id)
+	sent = recorder.Sent()
+	if len(sent) != 2 || !slices.Equal(sent[1].To, []string{"managerb@localhost"}) {
+		t.Errorf("expected only managerb to be notified got %v", sent[1:])
+	}
+
+	// and nothing is sent while pirg notifications are disabled
+	cfg.Notifications.Pirgs.Enabled = false
+	if h := newPirgHandler(ctx); h.pirgNotifier != nil {
+		t.Error("expected no pirg notifier while disabled")
+	}
+}
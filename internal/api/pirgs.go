@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -12,8 +13,11 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/slurm"
+	"github.com/lcrownover/hpcadmin-server/internal/webhook"
 )
 
 type PirgResponse struct {
@@ -24,6 +28,10 @@ type PirgResponse struct {
 	UserIds    []int     `json:"user_ids"`
 	CreatedAt  time.Time `json:"created_at"`
 	ModifiedAt time.Time `json:"modified_at"`
+	// Version is sent back with updates that shouldn't overwrite others', see expectedVersion
+	Version int `json:"version"`
+	// SlurmError is set when the change was made but couldn't be provisioned in Slurm
+	SlurmError string `json:"slurm_error,omitempty"`
 }
 
 func (u *PirgResponse) Bind(r *http.Request) error {
@@ -43,6 +51,7 @@ func newPirgResponse(u *data.Pirg) *PirgResponse {
 		UserIds:    u.UserIds,
 		CreatedAt:  u.CreatedAt,
 		ModifiedAt: u.ModifiedAt,
+		Version:    u.Version,
 	}
 }
 
@@ -90,6 +99,17 @@ func (u *PirgRequest) Bind(r *http.Request) error {
 	return nil
 }
 
+// PirgUpdateRequest is the PirgRequest of an update, with the version of
+// the Pirg it's based on, see expectedVersion
+type PirgUpdateRequest struct {
+	PirgRequest
+	Version int `json:"version"`
+}
+
+func (u *PirgUpdateRequest) Bind(r *http.Request) error {
+	return u.PirgRequest.Bind(r)
+}
+
 func newPirgRequest(u *data.Pirg) *PirgRequest {
 	return &PirgRequest{
 		Name:     u.Name,
@@ -99,33 +119,431 @@ func newPirgRequest(u *data.Pirg) *PirgRequest {
 	}
 }
 
+type PirgSummaryResponse struct {
+	Id            int                   `json:"id"`
+	Name          string                `json:"name"`
+	OwnerId       int                   `json:"owner_id"`
+	MemberCount   int                   `json:"member_count"`
+	AdminCount    int                   `json:"admin_count"`
+	RecentMembers []*PirgMemberResponse `json:"recent_members"`
+	LastActivity  time.Time             `json:"last_activity"`
+}
+
+func (u *PirgSummaryResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type PirgMemberResponse struct {
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newPirgSummaryResponse(s *data.PirgSummary) *PirgSummaryResponse {
+	recent := []*PirgMemberResponse{}
+	for _, c := range s.RecentMembers {
+		recent = append(recent, &PirgMemberResponse{
+			UserId:    c.UserId,
+			Username:  c.Username,
+			Action:    c.Action,
+			Timestamp: c.Timestamp,
+		})
+	}
+	return &PirgSummaryResponse{
+		Id:            s.PirgId,
+		Name:          s.Name,
+		OwnerId:       s.OwnerId,
+		MemberCount:   s.MemberCount,
+		AdminCount:    s.AdminCount,
+		RecentMembers: recent,
+		LastActivity:  s.LastActivity,
+	}
+}
+
+// maxIncludedPirgMembers caps the members returned across all pirgs by
+// GET /pirgs?include=members
+const maxIncludedPirgMembers = 10000
+
+// PirgWithMembersResponse is a PirgResponse with its members embedded. If
+// members_truncated is set the cap was hit and members is incomplete,
+// member_count is the full count and the rest can be paged through
+// /pirgs/{pirgID}/members.
+type PirgWithMembersResponse struct {
+	*PirgResponse
+	Members          []*PirgMemberListResponse `json:"members"`
+	MemberCount      int                       `json:"member_count"`
+	MembersTruncated bool                      `json:"members_truncated"`
+}
+
+func (u *PirgWithMembersResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgWithMembersResponse(p *data.PirgWithMembers) *PirgWithMembersResponse {
+	members := []*PirgMemberListResponse{}
+	for _, m := range p.Members {
+		members = append(members, newPirgMemberResponse(m))
+	}
+	return &PirgWithMembersResponse{
+		PirgResponse:     newPirgResponse(p.Pirg),
+		Members:          members,
+		MemberCount:      p.MemberCount,
+		MembersTruncated: p.MembersTruncated,
+	}
+}
+
+// PirgUpsertResponse is a PirgResponse that also reports whether
+// the upsert created the pirg or updated an existing one
+type PirgUpsertResponse struct {
+	*PirgResponse
+	Created bool `json:"created"`
+}
+
+func (u *PirgUpsertResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type PirgMembershipEventResponse struct {
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newPirgMembershipEventResponseList(events []*data.PirgMembershipEvent) []*PirgMembershipEventResponse {
+	list := []*PirgMembershipEventResponse{}
+	for _, e := range events {
+		list = append(list, &PirgMembershipEventResponse{
+			Actor:     e.Actor,
+			Action:    e.Action,
+			UserId:    e.UserId,
+			Username:  e.Username,
+			Timestamp: e.OccurredAt,
+		})
+	}
+	return list
+}
+
+type PirgMemberListResponse struct {
+	UserId    int        `json:"user_id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	FirstName string     `json:"firstname"`
+	LastName  string     `json:"lastname"`
+	IsAdmin   bool       `json:"is_admin"`
+	JoinedAt  time.Time  `json:"joined_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// SlurmError is set when the member was added but couldn't be provisioned in Slurm
+	SlurmError string `json:"slurm_error,omitempty"`
+	// User is the member's whole user record, only set with ?expand=user
+	User *UserResponse `json:"user,omitempty"`
+}
+
+func (m *PirgMemberListResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgMemberResponse(m *data.PirgMember) *PirgMemberListResponse {
+	return &PirgMemberListResponse{
+		UserId:    m.UserId,
+		Username:  m.Username,
+		Email:     m.Email,
+		FirstName: m.FirstName,
+		LastName:  m.LastName,
+		IsAdmin:   m.IsAdmin,
+		JoinedAt:  m.JoinedAt,
+		ExpiresAt: m.ExpiresAt,
+	}
+}
+
+func newPirgMemberListResponse(members []*data.PirgMember) []*PirgMemberListResponse {
+	list := []*PirgMemberListResponse{}
+	for _, m := range members {
+		list = append(list, newPirgMemberResponse(m))
+	}
+	return list
+}
+
+// PirgMemberAsOfResponse is a member a pirg had at a past time. The user's
+// fields are their current ones, and joined_at is when they were last added
+// before then. Whether they were an admin isn't kept in the history.
+type PirgMemberAsOfResponse struct {
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"firstname"`
+	LastName  string    `json:"lastname"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// PirgMemberRequest adds a user to a pirg. With expires_at set the membership
+// is removed automatically once it passes.
+type PirgMemberRequest struct {
+	UserId    int        `json:"user_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (p *PirgMemberRequest) Bind(r *http.Request) error {
+	if p.UserId == 0 {
+		return fmt.Errorf("missing required user_id")
+	}
+	if p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+type PirgReconcileRequest struct {
+	Usernames []string `json:"usernames"`
+	DryRun    bool     `json:"dry_run"`
+}
+
+func (p *PirgReconcileRequest) Bind(r *http.Request) error {
+	if p.Usernames == nil {
+		return fmt.Errorf("missing required usernames")
+	}
+	return nil
+}
+
+// PirgReconcileAllRequest maps pirg names to the usernames that should be their members
+type PirgReconcileAllRequest map[string][]string
+
+func (p *PirgReconcileAllRequest) Bind(r *http.Request) error {
+	if len(*p) == 0 {
+		return fmt.Errorf("missing required pirgs")
+	}
+	for name, usernames := range *p {
+		if usernames == nil {
+			return fmt.Errorf("missing required usernames for pirg %s", name)
+		}
+	}
+	return nil
+}
+
+// PirgTransferAllRequest moves every pirg owned by FromUser to ToUser, by username
+type PirgTransferAllRequest struct {
+	FromUser string `json:"from_user"`
+	ToUser   string `json:"to_user"`
+}
+
+func (p *PirgTransferAllRequest) Bind(r *http.Request) error {
+	if p.FromUser == "" || p.ToUser == "" {
+		return fmt.Errorf("missing required from_user and to_user")
+	}
+	return nil
+}
+
+type PirgTransferAllResponse struct {
+	Transferred int   `json:"transferred"`
+	PirgIds     []int `json:"pirg_ids"`
+	DryRun      bool  `json:"dry_run"`
+}
+
+func (p *PirgTransferAllResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// PirgMemberRefResponse identifies a member in responses that list
+// many of them, such as a reconcile or comparison
+type PirgMemberRefResponse struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func newPirgMemberRefResponseList(members []*data.PirgMember) []*PirgMemberRefResponse {
+	list := []*PirgMemberRefResponse{}
+	for _, m := range members {
+		list = append(list, &PirgMemberRefResponse{UserId: m.UserId, Username: m.Username})
+	}
+	return list
+}
+
+type PirgReconcileResponse struct {
+	Added   []*PirgMemberRefResponse `json:"added"`
+	Removed []*PirgMemberRefResponse `json:"removed"`
+	DryRun  bool                     `json:"dry_run"`
+}
+
+func (p *PirgReconcileResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgReconcileResponse(res *data.PirgReconcileResult) *PirgReconcileResponse {
+	return &PirgReconcileResponse{
+		Added:   newPirgMemberRefResponseList(res.Added),
+		Removed: newPirgMemberRefResponseList(res.Removed),
+		DryRun:  res.DryRun,
+	}
+}
+
+type PirgReconcileAllResponse struct {
+	Pirgs  map[string]*PirgReconcileResponse `json:"pirgs"`
+	DryRun bool                              `json:"dry_run"`
+}
+
+func (p *PirgReconcileAllResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type PirgCompareResponse struct {
+	A     int                      `json:"a"`
+	B     int                      `json:"b"`
+	OnlyA []*PirgMemberRefResponse `json:"only_a"`
+	OnlyB []*PirgMemberRefResponse `json:"only_b"`
+	Both  []*PirgMemberRefResponse `json:"both"`
+}
+
+func (p *PirgCompareResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgCompareResponse(c *data.PirgComparison) *PirgCompareResponse {
+	return &PirgCompareResponse{
+		A:     c.A,
+		B:     c.B,
+		OnlyA: newPirgMemberRefResponseList(c.OnlyA),
+		OnlyB: newPirgMemberRefResponseList(c.OnlyB),
+		Both:  newPirgMemberRefResponseList(c.Both),
+	}
+}
+
+type PirgMembershipSnapshotResponse struct {
+	Id        int       `json:"id"`
+	PirgId    int       `json:"pirg_id"`
+	AdminIds  []int     `json:"admin_ids"`
+	UserIds   []int     `json:"user_ids"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (p *PirgMembershipSnapshotResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgMembershipSnapshotResponse(s *data.PirgMembershipSnapshot) *PirgMembershipSnapshotResponse {
+	return &PirgMembershipSnapshotResponse{
+		Id:        s.Id,
+		PirgId:    s.PirgId,
+		AdminIds:  s.AdminIds,
+		UserIds:   s.UserIds,
+		CreatedBy: s.CreatedBy,
+		CreatedAt: s.CreatedAt,
+	}
+}
+
+// PirgSnapshotRestoreResponse lists the users the restore added and removed.
+// MissingUserIds were in the snapshot but have been deleted since.
+type PirgSnapshotRestoreResponse struct {
+	SnapshotId     int   `json:"snapshot_id"`
+	AddedUserIds   []int `json:"added_user_ids"`
+	RemovedUserIds []int `json:"removed_user_ids"`
+	MissingUserIds []int `json:"missing_user_ids"`
+}
+
+func (p *PirgSnapshotRestoreResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgSnapshotRestoreResponse(res *data.PirgSnapshotRestore) *PirgSnapshotRestoreResponse {
+	resp := &PirgSnapshotRestoreResponse{
+		SnapshotId:     res.Snapshot.Id,
+		AddedUserIds:   []int{},
+		RemovedUserIds: []int{},
+		MissingUserIds: res.MissingUserIds,
+	}
+	for _, id := range res.AfterUserIds {
+		if !slices.Contains(res.BeforeUserIds, id) {
+			resp.AddedUserIds = append(resp.AddedUserIds, id)
+		}
+	}
+	for _, id := range res.BeforeUserIds {
+		if !slices.Contains(res.AfterUserIds, id) {
+			resp.RemovedUserIds = append(resp.RemovedUserIds, id)
+		}
+	}
+	slices.Sort(resp.AddedUserIds)
+	slices.Sort(resp.RemovedUserIds)
+	return resp
+}
+
 type PirgStub struct {
 	Id       int
 	Pirgname string
 }
 
+// pirgSummaryRecentLimit is the number of recent membership changes included in a summary
+const pirgSummaryRecentLimit = 10
+
+// pirgMembersExpandUser is the ?expand of GET /pirgs/{pirgID}/members adding each member's user record
+const pirgMembersExpandUser = "user"
+
 type PirgHandler struct {
 	dbConn *sql.DB
+	store  data.Store
+	pages  pageLimits
+	lists  listRenderer
+	// slurm is nil while Slurm provisioning is disabled
+	slurm        slurm.SlurmProvisioner
+	webhooks     *webhook.Dispatcher
+	pirgNotifier *pirgNotifier
+	mask         fieldMask
+	// reconcileBatchSize is the most membership changes a reconcile applies per transaction
+	reconcileBatchSize int
 }
 
 func PirgsRouter(ctx context.Context) http.Handler {
 	r := chi.NewRouter()
 	h := newPirgHandler(ctx)
+	provisioningHandler := newProvisioningHandler(ctx)
 	r.Get("/", h.GetAllPirgs)
 	r.Post("/", h.CreatePirg)
+	r.Put("/by-name/{pirgName}", h.UpsertPirgByName)
+	r.Get("/compare", h.ComparePirgs)
 	r.Route("/{pirgID}", func(r chi.Router) {
 		r.Use(h.PirgCtx)
 		r.Get("/", h.GetPirg)
-		r.Put("/", h.UpdatePirg)
 		r.Delete("/", h.DeletePirg)
+		r.Get("/summary", h.GetPirgSummary)
+		r.Get("/membership-history", h.GetPirgMembershipHistory)
+		r.Get("/members", h.GetPirgMembers)
+		// everything that changes who's a member or an admin
+		r.Group(func(r chi.Router) {
+			r.Use(h.PirgAdminOnly)
+			r.Put("/", h.UpdatePirg)
+			r.Post("/members", h.AddPirgMember)
+			r.Delete("/members/{userID}", h.RemovePirgMember)
+			r.Put("/members/{userID}/admin", h.SetPirgAdmin)
+			r.Delete("/members/{userID}/admin", h.RemovePirgAdmin)
+			r.Post("/reconcile-members", h.ReconcilePirgMembers)
+			r.Post("/membership-snapshot", h.CreatePirgMembershipSnapshot)
+			r.Post("/membership-restore/{snapshotID}", h.RestorePirgMembershipSnapshot)
+		})
+		r.Get("/provision-script", provisioningHandler.GetPirgProvisionScript)
+		r.Get("/validate", provisioningHandler.ValidatePirg)
 		// r.Mount("/admins", PirgAdminsRouter(ctx))
 	})
 	return r
 }
 
 func newPirgHandler(ctx context.Context) *PirgHandler {
-	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
-	return &PirgHandler{dbConn: dbConn}
+	dbConn, _ := ctx.Value(keys.DBConnKey).(*sql.DB)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	provisioner, _ := ctx.Value(keys.SlurmKey).(slurm.SlurmProvisioner)
+	webhooks, _ := ctx.Value(keys.WebhooksKey).(*webhook.Dispatcher)
+	return &PirgHandler{
+		dbConn:       dbConn,
+		store:        storeFromContext(ctx),
+		pages:        newPageLimits(cfg.Pagination, cfg.ListEnvelope),
+		lists:        newListRenderer(cfg.StreamListThreshold),
+		slurm:        provisioner,
+		webhooks:     webhooks,
+		pirgNotifier: newPirgNotifier(ctx),
+		mask:         newFieldMask(cfg.MaskedUserFields),
+
+		reconcileBatchSize: cfg.ReconcileBatchSize,
+	}
 }
 
 // GetAllPirgs returns all existing Pirgs
@@ -134,9 +552,9 @@ func (h *PirgHandler) GetAllPirgs(w http.ResponseWriter, r *http.Request) {
 	// name passed as query param, get specific pirg
 	if searchName != "" {
 		slog.Debug("getting pirg by name", "package", "api", "method", "GetAllPirgs")
-		pirg, err := data.GetPirgByName(h.dbConn, searchName)
+		pirg, err := h.store.GetPirgByName(searchName)
 		if err != nil {
-			render.Render(w, r, ErrNotFound)
+			render.Render(w, r, ErrLookup(err))
 			return
 		}
 		resp := newPirgResponse(pirg)
@@ -144,47 +562,126 @@ func (h *PirgHandler) GetAllPirgs(w http.ResponseWriter, r *http.Request) {
 			render.Render(w, r, ErrRender(err))
 			return
 		}
+	} else if include := r.URL.Query().Get("include"); include != "" {
+		if include != "members" {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unknown include, only members is supported: %s", include)))
+			return
+		}
+		slog.Debug("getting all pirgs with members", "package", "api", "method", "GetAllPirgs")
+		pirgs, err := data.GetAllPirgsWithMembers(h.dbConn, maxIncludedPirgMembers)
+		if err != nil {
+			render.Render(w, r, ErrInternal(err))
+			return
+		}
+		list := []render.Renderer{}
+		for _, pirg := range pirgs {
+			list = append(list, newPirgWithMembersResponse(pirg))
+		}
+		h.lists.render(w, r, list)
 	} else {
 		// no name passed as query param, get all pirgs
 		slog.Debug("getting all pirgs", "package", "api", "method", "GetAllPirgs")
 		var pirgs []*data.Pirg
 
-		pirgs, err := data.GetAllPirgs(h.dbConn)
+		pirgs, err := h.store.GetAllPirgs()
 		if err != nil {
-			render.Render(w, r, ErrInternalServer(err))
+			render.Render(w, r, ErrInternal(err))
 			return
 		}
 
-		resp := newPirgResponseList(pirgs)
-		if err := render.RenderList(w, r, resp); err != nil {
-			render.Render(w, r, ErrRender(err))
-			return
-		}
+		h.lists.render(w, r, newPirgResponseList(pirgs))
 	}
 }
 
-// CreatePirg creates a new Pirg
+// CreatePirg creates a new Pirg, and its Slurm account with its members
 func (h *PirgHandler) CreatePirg(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("creating new pirg", "package", "api", "method", "CreatePirg")
 	pirg := &PirgRequest{}
 	if err := render.Bind(r, pirg); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+		render.Render(w, r, ErrBind(err))
 		return
 	}
 
 	dataPirg := data.PirgRequest(*pirg)
 
-	newPirg, err := data.CreatePirg(h.dbConn, &dataPirg)
+	newPirg, err := h.store.CreatePirg(&dataPirg)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	recordChange(r.Context(), h.store, "pirg", newPirg.Id, data.AuditActionCreated)
+	recordPirgMembershipChanges(r.Context(), h.store, newPirg.Id, nil, newPirg.UserIds)
+	h.pirgNotifier.membershipChanged(r.Context(), newPirg.Id, nil, newPirg.UserIds)
 
 	resp := newPirgResponse(newPirg)
+	resp.SlurmError = h.provisionSlurm(r.Context(), newPirg, true, nil, newPirg.UserIds)
+	h.webhooks.Enqueue(webhook.EventPirgCreated, newPirgResponse(newPirg))
 	render.Status(r, http.StatusCreated)
 	render.Render(w, r, resp)
 }
 
+// UpsertPirgByName creates the Pirg named in the URL, or updates it if it already exists.
+// Responds 201 if the Pirg was created and 200 if it was updated.
+func (h *PirgHandler) UpsertPirgByName(w http.ResponseWriter, r *http.Request) {
+	pirgName := chi.URLParam(r, "pirgName")
+	slog.Debug("upserting pirg by name", "name", pirgName, "package", "api", "method", "UpsertPirgByName")
+	// name can be omitted from the body, but must match the url if present
+	pirgReq := &PirgRequest{Name: pirgName}
+	if err := render.Bind(r, pirgReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	if pirgReq.Name != pirgName {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("pirg name in body does not match url: %s", pirgReq.Name)))
+		return
+	}
+	var existingUserIds []int
+	existing, err := h.store.GetPirgByName(pirgName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if existing != nil {
+		// updating an existing pirg changes its members, like PirgAdminOnly
+		isAdmin, err := h.isPirgAdmin(r.Context(), existing.Id)
+		if err != nil {
+			render.Render(w, r, ErrInternal(err))
+			return
+		}
+		if !isAdmin {
+			render.Render(w, r, ErrForbidden)
+			return
+		}
+		existingUserIds = existing.UserIds
+	}
+	dataPirgRequest := data.PirgRequest(*pirgReq)
+	pirg, created, err := h.store.UpsertPirg(&dataPirgRequest)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	action := data.AuditActionUpdated
+	if created {
+		action = data.AuditActionCreated
+	}
+	recordChange(r.Context(), h.store, "pirg", pirg.Id, action)
+	recordPirgMembershipChanges(r.Context(), h.store, pirg.Id, existingUserIds, pirg.UserIds)
+	h.pirgNotifier.membershipChanged(r.Context(), pirg.Id, existingUserIds, pirg.UserIds)
+	resp := &PirgUpsertResponse{PirgResponse: newPirgResponse(pirg), Created: created}
+	resp.SlurmError = h.provisionSlurm(r.Context(), pirg, created, existingUserIds, pirg.UserIds)
+	if created {
+		h.webhooks.Enqueue(webhook.EventPirgCreated, newPirgResponse(pirg))
+	} else {
+		h.webhooks.Enqueue(webhook.EventPirgUpdated, newPirgResponse(pirg))
+	}
+	if created {
+		render.Status(r, http.StatusCreated)
+	} else {
+		render.Status(r, http.StatusOK)
+	}
+	render.Render(w, r, resp)
+}
+
 // PirgCtx middleware is used to load a Pirg object from /Pirgs/{Pirgname} requests
 // and then attach it to the request context. In case of failure the request is aborted
 // and a 404 error response is sent to the client.
@@ -200,9 +697,9 @@ func (h *PirgHandler) PirgCtx(next http.Handler) http.Handler {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
-		pirg, err = data.GetPirgById(h.dbConn, pirgId)
+		pirg, err = h.store.GetPirgById(pirgId)
 		if err != nil {
-			render.Render(w, r, ErrNotFound)
+			render.Render(w, r, ErrLookup(err))
 			return
 		}
 
@@ -211,50 +708,549 @@ func (h *PirgHandler) PirgCtx(next http.Handler) http.Handler {
 	})
 }
 
-// GetPirg returns the Pirg by the ID in the URL
+// PirgAdminOnly middleware restricts changing the members of the Pirg in the
+// request context to global admins and the pirg's own admins
+func (h *PirgHandler) PirgAdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+		isAdmin, err := h.isPirgAdmin(r.Context(), pirg.Id)
+		if err != nil {
+			render.Render(w, r, ErrInternal(err))
+			return
+		}
+		if !isAdmin {
+			render.Render(w, r, ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isPirgAdmin reports whether the caller is a global admin or an admin of
+// the pirg
+func (h *PirgHandler) isPirgAdmin(ctx context.Context, pirgId int) (bool, error) {
+	if role, _ := ctx.Value(keys.RoleKey).(string); role == "admin" {
+		return true, nil
+	}
+	userId, err := requestUserId(ctx, h.store)
+	if err != nil || userId == 0 {
+		return false, err
+	}
+	return h.store.IsPirgAdmin(pirgId, userId)
+}
+
+// GetPirg returns the Pirg by the ID in the URL, or 304 Not Modified if the
+// If-None-Match has its ETag
 func (h *PirgHandler) GetPirg(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("getting pirg", "package", "api", "method", "GetPirg")
 	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
-	resp := newPirgResponse(pirg)
-	if err := render.Render(w, r, resp); err != nil {
-		render.Render(w, r, ErrRender(err))
-	}
+	renderWithETag(w, r, newPirgResponse(pirg))
 }
 
-// UpdatePirg updates a Pirg
+// UpdatePirg updates a Pirg. With a version in the If-Match header or the
+// body, it's a 409 with the current Pirg if it's been updated since.
 func (h *PirgHandler) UpdatePirg(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("updating pirg", "package", "api", "method", "UpdatePirg")
 	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
-	pirgReq := newPirgRequest(pirg)
+	pirgReq := &PirgUpdateRequest{PirgRequest: *newPirgRequest(pirg)}
 	if err := render.Bind(r, pirgReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	version, err := expectedVersion(r, pirgReq.Version)
+	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
-	dataPirgRequest := data.PirgRequest(*pirgReq)
-	fmt.Printf("dataPirgRequest: %+v\n", dataPirgRequest)
-	updatedPirg, err := data.UpdatePirg(h.dbConn, pirg.Id, &dataPirgRequest)
+	dataPirgRequest := data.PirgRequest(pirgReq.PirgRequest)
+	updatedPirg, err := h.store.UpdatePirg(pirg.Id, &dataPirgRequest, version)
+	if errors.Is(err, data.ErrVersionConflict) {
+		current, err := h.store.GetPirgById(pirg.Id)
+		if err != nil {
+			render.Render(w, r, ErrLookup(err))
+			return
+		}
+		render.Render(w, r, ErrVersionConflict(newPirgResponse(current)))
+		return
+	}
 	if err != nil {
-		render.Render(w, r, ErrInternalServer(err))
+		render.Render(w, r, ErrInternal(err))
 		return
 	}
+	recordChange(r.Context(), h.store, "pirg", pirg.Id, data.AuditActionUpdated)
+	recordPirgMembershipChanges(r.Context(), h.store, pirg.Id, pirg.UserIds, updatedPirg.UserIds)
+	h.pirgNotifier.membershipChanged(r.Context(), pirg.Id, pirg.UserIds, updatedPirg.UserIds)
 
 	resp := newPirgResponse(updatedPirg)
+	resp.SlurmError = h.provisionSlurm(r.Context(), updatedPirg, false, pirg.UserIds, updatedPirg.UserIds)
+	h.webhooks.Enqueue(webhook.EventPirgUpdated, newPirgResponse(updatedPirg))
 	render.Status(r, http.StatusOK)
 	render.Render(w, r, resp)
 }
 
-// DeletePirg deletes a Pirg
+// DeletePirg deletes a Pirg and its Slurm account. It answers 204, or 200
+// with a SlurmErrorResponse if the account couldn't be deleted.
 func (h *PirgHandler) DeletePirg(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("deleting pirg", "package", "api", "method", "DeletePirg")
 	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
-	err := data.DeletePirg(h.dbConn, pirg.Id)
+	err := h.store.DeletePirg(pirg.Id)
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		render.Render(w, r, ErrLookup(err))
+		return
+	}
+	recordChange(r.Context(), h.store, "pirg", pirg.Id, data.AuditActionDeleted)
+	h.webhooks.Enqueue(webhook.EventPirgDeleted, newPirgResponse(pirg))
+	if slurmErr := h.deprovisionSlurm(r.Context(), pirg); slurmErr != "" {
+		render.JSON(w, r, &SlurmErrorResponse{SlurmError: slurmErr})
 		return
 	}
 	render.Status(r, http.StatusNoContent)
 }
 
+// GetPirgSummary returns member counts, recent membership changes, and
+// the last activity for the Pirg in the request context
+func (h *PirgHandler) GetPirgSummary(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting pirg summary", "package", "api", "method", "GetPirgSummary")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	summary, err := data.GetPirgSummary(h.dbConn, pirg.Id, pirgSummaryRecentLimit)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := newPirgSummaryResponse(summary)
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetPirgMembershipHistory returns a page of the users added to and removed from
+// the Pirg in the request context, oldest first
+func (h *PirgHandler) GetPirgMembershipHistory(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting pirg membership history", "package", "api", "method", "GetPirgMembershipHistory")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	limit, offset, err := h.pages.parse(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	events, total, err := data.GetPirgMembershipHistory(h.dbConn, pirg.Id, limit, offset)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := &PageResponse{
+		Items:  newPirgMembershipEventResponseList(events),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	h.pages.render(w, r, resp)
+}
+
+// GetPirgMembers returns a page of the members of the Pirg in the request
+// context. With the `as_of` query param, an RFC3339 timestamp or a YYYY-MM-DD
+// date at midnight UTC, it's the members the Pirg had then instead. With
+// ?expand=user each member also has their whole user record, read in the
+// same query, masked like the user responses.
+func (h *PirgHandler) GetPirgMembers(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting pirg members", "package", "api", "method", "GetPirgMembers")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	limit, offset, err := h.pages.parse(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	expand := r.URL.Query().Get("expand")
+	if expand != "" && expand != pirgMembersExpandUser {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("expand must be %s: %s", pirgMembersExpandUser, expand)))
+		return
+	}
+	if v := r.URL.Query().Get("as_of"); v != "" {
+		if expand != "" {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("expand isn't supported with as_of")))
+			return
+		}
+		asOf, err := parseAuditTime(v)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid as_of: %v", err)))
+			return
+		}
+		h.getPirgMembersAsOf(w, r, pirg, asOf, limit, offset)
+		return
+	}
+	getMembers := data.GetPirgMembers
+	if expand == pirgMembersExpandUser {
+		getMembers = data.GetPirgMembersWithUsers
+	}
+	members, total, err := getMembers(h.dbConn, pirg.Id, limit, offset)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	items := newPirgMemberListResponse(members)
+	for i, m := range members {
+		if m.User != nil {
+			items[i].User = h.mask.user(r, newUserRecordResponse(m.User))
+		}
+	}
+	resp := &PageResponse{
+		Items:  items,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	h.pages.render(w, r, resp)
+}
+
+// getPirgMembersAsOf returns a page of the members the pirg had at asOf,
+// replayed from its membership history
+func (h *PirgHandler) getPirgMembersAsOf(w http.ResponseWriter, r *http.Request, pirg *data.Pirg, asOf time.Time, limit int, offset int) {
+	if asOf.After(time.Now()) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("as_of must not be in the future: %s", asOf.Format(time.RFC3339))))
+		return
+	}
+	members, total, err := data.GetPirgMembersAsOf(h.dbConn, pirg.Id, asOf, limit, offset)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	items := []*PirgMemberAsOfResponse{}
+	for _, m := range members {
+		items = append(items, &PirgMemberAsOfResponse{
+			UserId:    m.UserId,
+			Username:  m.Username,
+			Email:     m.Email,
+			FirstName: m.FirstName,
+			LastName:  m.LastName,
+			JoinedAt:  m.JoinedAt,
+		})
+	}
+	resp := &PageResponse{
+		Items:  items,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	h.pages.render(w, r, resp)
+}
+
+// AddPirgMember adds a user to the Pirg in the request context, optionally until
+// expires_at, and associates new members with its Slurm account. Adding an
+// existing member replaces their expiry.
+func (h *PirgHandler) AddPirgMember(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("adding pirg member", "package", "api", "method", "AddPirgMember")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	memberReq := &PirgMemberRequest{}
+	if err := render.Bind(r, memberReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	if memberReq.UserId == pirg.OwnerId && memberReq.ExpiresAt != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("the pirg owner's membership can't expire")))
+		return
+	}
+	if _, err := h.store.GetUserById(memberReq.UserId); err != nil {
+		render.Render(w, r, ErrLookup(err))
+		return
+	}
+	member, created, err := h.store.AddPirgMember(pirg.Id, memberReq.UserId, memberReq.ExpiresAt)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	status := http.StatusOK
+	resp := newPirgMemberResponse(member)
+	if created {
+		recordPirgMembershipChanges(r.Context(), h.store, pirg.Id, nil, []int{member.UserId})
+		h.pirgNotifier.membershipChanged(r.Context(), pirg.Id, nil, []int{member.UserId})
+		resp.SlurmError = h.provisionSlurm(r.Context(), pirg, false, nil, []int{member.UserId})
+		status = http.StatusCreated
+	}
+	render.Status(r, status)
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// RemovePirgMember removes the user in the url from the members of the Pirg
+// in the request context, and from its admins, and removes their association
+// with its Slurm account. The owner can't be removed. It answers 204, or 200
+// with a SlurmErrorResponse if the association couldn't be removed.
+func (h *PirgHandler) RemovePirgMember(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("removing pirg member", "package", "api", "method", "RemovePirgMember")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	removed, err := h.store.RemovePirgMember(pirg.Id, userId)
+	if errors.Is(err, data.ErrRemovePirgOwner) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if errors.Is(err, data.ErrRemoveLastPirgAdmin) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if !removed {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	recordPirgMembershipChanges(r.Context(), h.store, pirg.Id, []int{userId}, nil)
+	h.pirgNotifier.membershipChanged(r.Context(), pirg.Id, []int{userId}, nil)
+	if slurmErr := h.provisionSlurm(r.Context(), pirg, false, []int{userId}, nil); slurmErr != "" {
+		render.JSON(w, r, &SlurmErrorResponse{SlurmError: slurmErr})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetPirgAdmin makes the member in the url an admin of the Pirg in the request
+// context. It returns 201 if they weren't one already and 200 if they were.
+func (h *PirgHandler) SetPirgAdmin(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("setting pirg admin", "package", "api", "method", "SetPirgAdmin")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	created, err := h.store.SetPirgAdmin(pirg.Id, userId)
+	if errors.Is(err, data.ErrNotPirgMember) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	status := http.StatusOK
+	if created {
+		recordPirgAdminChange(r.Context(), h.store, pirg.Id, data.AuditActionAdminAdded, userId)
+		status = http.StatusCreated
+	}
+	w.WriteHeader(status)
+}
+
+// RemovePirgAdmin takes the admin rights of the user in the url on the Pirg in
+// the request context, they stay a member. The last admin can't be removed.
+func (h *PirgHandler) RemovePirgAdmin(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("removing pirg admin", "package", "api", "method", "RemovePirgAdmin")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	removed, err := h.store.RemovePirgAdmin(pirg.Id, userId)
+	if errors.Is(err, data.ErrRemoveLastPirgAdmin) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if !removed {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	recordPirgAdminChange(r.Context(), h.store, pirg.Id, data.AuditActionAdminRemoved, userId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReconcilePirgMembers sets the members of the Pirg in the request context to
+// exactly the usernames provided and returns who was added and removed.
+// With dry_run set in the body or query, the diff is returned but not applied.
+func (h *PirgHandler) ReconcilePirgMembers(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("reconciling pirg members", "package", "api", "method", "ReconcilePirgMembers")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	reconcileReq := &PirgReconcileRequest{}
+	if err := render.Bind(r, reconcileReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	dryRun := reconcileReq.DryRun || r.URL.Query().Get("dry_run") == "true"
+	result, err := data.ReconcilePirgMembers(h.dbConn, pirg.Id, reconcileReq.Usernames, dryRun, h.reconcileBatchSize)
+	if err != nil {
+		// batches applied before the error are still recorded
+		if result != nil {
+			h.recordReconcile(r.Context(), result)
+		}
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !result.DryRun {
+		h.recordReconcile(r.Context(), result)
+	}
+	resp := newPirgReconcileResponse(result)
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// ReconcileAllPirgMembers reconciles the members of every pirg in the body, a
+// map of pirg name to usernames, and returns the diff of each. Nothing is
+// applied unless ?apply=true, and then every pirg is applied in one transaction,
+// or in batches of reconcile_batch_size changes if it's set.
+func (h *PirgHandler) ReconcileAllPirgMembers(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("reconciling members of all pirgs", "package", "api", "method", "ReconcileAllPirgMembers")
+	reconcileReq := PirgReconcileAllRequest{}
+	if err := render.Bind(r, &reconcileReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	dryRun := r.URL.Query().Get("apply") != "true"
+	results, err := data.ReconcileAllPirgMembers(h.dbConn, reconcileReq, dryRun, h.reconcileBatchSize)
+	if err != nil {
+		// pirgs reconciled before the error are still recorded
+		for _, result := range results {
+			h.recordReconcile(r.Context(), result)
+		}
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	resp := &PirgReconcileAllResponse{Pirgs: map[string]*PirgReconcileResponse{}, DryRun: dryRun}
+	for name, result := range results {
+		if !dryRun {
+			h.recordReconcile(r.Context(), result)
+		}
+		resp.Pirgs[name] = newPirgReconcileResponse(result)
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// TransferAllPirgs makes to_user the owner of every pirg from_user owns, for
+// when a PI hands over their groups, and adds them as a member of each.
+// Everything is transferred in one transaction. With ?dry_run=true the pirgs
+// that would be transferred are returned, and none are.
+func (h *PirgHandler) TransferAllPirgs(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("transferring all pirgs", "package", "api", "method", "TransferAllPirgs")
+	transferReq := &PirgTransferAllRequest{}
+	if err := render.Bind(r, transferReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	from, err := data.GetUserByUsername(h.dbConn, transferReq.FromUser)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("user does not exist with username: %s", transferReq.FromUser)))
+		return
+	}
+	to, err := data.GetUserByUsername(h.dbConn, transferReq.ToUser)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("user does not exist with username: %s", transferReq.ToUser)))
+		return
+	}
+	if from.Id == to.Id {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("from_user and to_user must be different users")))
+		return
+	}
+	dryRun := dryRunRequested(r)
+	pirgIds, err := data.TransferAllPirgs(h.dbConn, from.Id, to.Id, actorFromContext(r.Context()), dryRun)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if !dryRun {
+		slog.Info("transferred pirgs", "from_user", from.Username, "to_user", to.Username, "pirg_ids", pirgIds, "package", "api", "method", "TransferAllPirgs")
+	}
+	resp := &PirgTransferAllResponse{Transferred: len(pirgIds), PirgIds: pirgIds, DryRun: dryRun}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// recordReconcile records the membership changes of an applied reconcile
+func (h *PirgHandler) recordReconcile(ctx context.Context, result *data.PirgReconcileResult) {
+	var addedIds, removedIds []int
+	for _, m := range result.Added {
+		addedIds = append(addedIds, m.UserId)
+	}
+	for _, m := range result.Removed {
+		removedIds = append(removedIds, m.UserId)
+	}
+	recordPirgMembershipChanges(ctx, h.store, result.PirgId, removedIds, addedIds)
+	h.pirgNotifier.membershipChanged(ctx, result.PirgId, removedIds, addedIds)
+}
+
+// CreatePirgMembershipSnapshot stores the current admins and users of the Pirg
+// in the request context, as a restore point before bulk membership changes
+func (h *PirgHandler) CreatePirgMembershipSnapshot(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("creating pirg membership snapshot", "package", "api", "method", "CreatePirgMembershipSnapshot")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	snapshot, err := data.CreatePirgMembershipSnapshot(h.dbConn, pirg.Id, actorFromContext(r.Context()))
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	recordChange(r.Context(), h.store, "pirg_membership_snapshot", snapshot.Id, data.AuditActionCreated)
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, newPirgMembershipSnapshotResponse(snapshot)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// RestorePirgMembershipSnapshot adds and removes members of the Pirg in the
+// request context until its membership matches the snapshot again
+func (h *PirgHandler) RestorePirgMembershipSnapshot(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("restoring pirg membership snapshot", "package", "api", "method", "RestorePirgMembershipSnapshot")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	snapshotId, err := strconv.Atoi(chi.URLParam(r, "snapshotID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	result, err := data.RestorePirgMembershipSnapshot(h.dbConn, pirg.Id, snapshotId)
+	if err == sql.ErrNoRows {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	recordPirgMembershipChanges(r.Context(), h.store, pirg.Id, result.BeforeUserIds, result.AfterUserIds)
+	h.pirgNotifier.membershipChanged(r.Context(), pirg.Id, result.BeforeUserIds, result.AfterUserIds)
+	if err := render.Render(w, r, newPirgSnapshotRestoreResponse(result)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// ComparePirgs returns the members only in pirg a, only in pirg b,
+// and in both, for the pirg ids in the a and b query params
+func (h *PirgHandler) ComparePirgs(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("comparing pirgs", "package", "api", "method", "ComparePirgs")
+	ids := map[string]int{}
+	for _, param := range []string{"a", "b"} {
+		v := r.URL.Query().Get(param)
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("query param %s must be a pirg id: %q", param, v)))
+			return
+		}
+		if _, err = data.GetPirgById(h.dbConn, id); err != nil {
+			render.Render(w, r, ErrLookup(err))
+			return
+		}
+		ids[param] = id
+	}
+	comparison, err := data.ComparePirgMembers(h.dbConn, ids["a"], ids["b"])
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if err := render.Render(w, r, newPirgCompareResponse(comparison)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
 // Utilities
 func IsAlphaNumeric(s string) bool {
 	for _, r := range s {
@@ -2,26 +2,46 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
 )
 
+// newTestPirgOwner creates a user directly in the database to act as a pirg owner
+func newTestPirgOwner(t *testing.T, th *testDataHandler, username string) *data.User {
+	owner, err := data.CreateUser(th.DB, &data.UserRequest{
+		Username:  username,
+		Email:     username + "@localhost",
+		FirstName: "TestAPI",
+		LastName:  "PirgOwner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return owner
+}
+
 func TestAPICreatePirg(t *testing.T) {
 	th := NewTestDataHandler()
-	// TODO(lcrown): create a user in the beginning of all these, then fix all the tests
+	owner := newTestPirgOwner(t, th, "testapicreatepirgowner")
 
 	// first we need to create a pirg, then get it back
-	ur := PirgRequest{
-		Name:  "testapicreatepirg",
-		Email:     "testapicreatepirg@localhost",
-		FirstName: "TestAPI",
-		LastName:  "CreatePirg",
+	pr := PirgRequest{
+		Name:     "testapicreatepirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
 	}
-	pirgReq, err := json.Marshal(ur)
+	pirgReq, err := json.Marshal(pr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,35 +67,38 @@ func TestAPICreatePirg(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	u, err := data.GetPirgById(th.DB, pirgResponse.Id)
+	p, err := data.GetPirgById(th.DB, pirgResponse.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u.Pirgname != ur.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur.Pirgname, u.Pirgname)
+	if p.Name != pr.Name {
+		t.Errorf("expected name %v got %v", pr.Name, p.Name)
 	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
+	if p.OwnerId != pr.OwnerId {
+		t.Errorf("expected owner_id %v got %v", pr.OwnerId, p.OwnerId)
 	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
+	if len(p.UserIds) != 1 || p.UserIds[0] != owner.Id {
+		t.Errorf("expected user_ids %v got %v", pr.UserIds, p.UserIds)
 	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
+	if len(p.AdminIds) != 1 || p.AdminIds[0] != owner.Id {
+		t.Errorf("expected admin_ids %v got %v", pr.AdminIds, p.AdminIds)
 	}
 }
 
 // TestGetAllPirgs tests the GET /api/v1/pirgs endpoint
 // it creates a pirg, then gets all pirgs and checks that the created pirg is in the list
 func TestAPIGetAllPirgs(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapigetallpirgsowner")
+
 	// first we need to create a pirg, then get it back
-	ur := PirgRequest{
-		Pirgname:  "testapigetallpirgs",
-		Email:     "testapigetallpirgs@localhost",
-		FirstName: "TestAPI",
-		LastName:  "GetAllPirgs",
+	pr := PirgRequest{
+		Name:     "testapigetallpirgs",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
 	}
-	pirgReq, err := json.Marshal(ur)
+	pirgReq, err := json.Marshal(pr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,26 +150,96 @@ func TestAPIGetAllPirgs(t *testing.T) {
 	// check if the pirg we created is in the list
 	found := false
 	for _, pirg := range pirgsResponse {
-		if pirg.Pirgname == ur.Pirgname {
+		if pirg.Name == pr.Name {
 			found = true
 		}
 	}
 	if !found {
-		t.Errorf("expected to find pirg %v in the list of pirgs", ur.Pirgname)
+		t.Errorf("expected to find pirg %v in the list of pirgs", pr.Name)
+	}
+}
+
+func TestAPIGetAllPirgsIncludeMembers(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapiincludemembersowner")
+	member, err := data.CreateUser(th.DB, &data.UserRequest{
+		Username:  "testapiincludemembers",
+		Email:     "testapiincludemembers@localhost",
+		FirstName: "Test",
+		LastName:  "Member",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapiincludemembers",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/pirgs?include=members", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "testkey1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+	var pirgs []PirgWithMembersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pirgs); err != nil {
+		t.Fatal(err)
+	}
+	var found *PirgWithMembersResponse
+	for i := range pirgs {
+		if pirgs[i].Id == pirg.Id {
+			found = &pirgs[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("expected to find pirg %v in the list of pirgs", pirg.Name)
+	}
+	if found.MemberCount != 2 || len(found.Members) != 2 || found.MembersTruncated {
+		t.Fatalf("expected 2 members got %v of %v", len(found.Members), found.MemberCount)
+	}
+	for _, m := range found.Members {
+		if m.IsAdmin != (m.UserId == owner.Id) {
+			t.Errorf("unexpected is_admin %v for user %v", m.IsAdmin, m.Username)
+		}
+	}
+}
+
+func TestGetAllPirgsRejectsUnknownInclude(t *testing.T) {
+	h := &PirgHandler{}
+	req := httptest.NewRequest("GET", "/pirgs?include=admins", nil)
+	w := httptest.NewRecorder()
+	h.GetAllPirgs(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
 	}
 }
 
 func TestAPIUpdatePirg(t *testing.T) {
 	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapiupdatepirgowner")
+	member := newTestPirgOwner(t, th, "testapiupdatepirgmember")
 
 	// first we need to create a pirg, then get it back
-	ur := PirgRequest{
-		Pirgname:  "testapiupdatepirg",
-		Email:     "testapiupdatepirg@localhost",
-		FirstName: "TestAPI",
-		LastName:  "UpdatePirg",
+	pr := PirgRequest{
+		Name:     "testapiupdatepirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
 	}
-	pirgReq, err := json.Marshal(ur)
+	pirgReq, err := json.Marshal(pr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -172,31 +265,22 @@ func TestAPIUpdatePirg(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	u, err := data.GetPirgById(th.DB, pirgResponse.Id)
+	p, err := data.GetPirgById(th.DB, pirgResponse.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u.Pirgname != ur.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur.Pirgname, u.Pirgname)
-	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
-	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
-	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
+	if p.Name != pr.Name {
+		t.Errorf("expected name %v got %v", pr.Name, p.Name)
 	}
 
 	// now update the pirg
-	ur2 := PirgRequest{
-		Pirgname:  "testapiupdatepirg",
-		Email:     "testapiupdatepirg2@localhost",
-		FirstName: "TestAPI2",
-		LastName:  "UpdatePirg2",
+	pr2 := PirgRequest{
+		Name:     "testapiupdatepirg2",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
 	}
-	pirgReq2, err := json.Marshal(ur2)
+	pirgReq2, err := json.Marshal(pr2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -222,35 +306,703 @@ func TestAPIUpdatePirg(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	u, err = data.GetPirgById(th.DB, pirgResponse.Id)
+	p, err = data.GetPirgById(th.DB, pirgResponse.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != pr2.Name {
+		t.Errorf("expected name %v got %v", pr2.Name, p.Name)
+	}
+	if len(p.UserIds) != len(pr2.UserIds) {
+		t.Errorf("expected user_ids %v got %v", pr2.UserIds, p.UserIds)
+	}
+}
+
+func TestAPIUpsertPirgByName(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapiupsertpirgowner")
+	member := newTestPirgOwner(t, th, "testapiupsertpirgmember")
+	upsertURL := "http://localhost:3333/api/v1/pirgs/by-name/testapiupsertpirg"
+
+	upsert := func(pr PirgRequest) (*http.Response, PirgUpsertResponse) {
+		pirgReq, err := json.Marshal(pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("PUT", upsertURL, bytes.NewBuffer(pirgReq))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "testkey1")
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var upsertResponse PirgUpsertResponse
+		if err = json.NewDecoder(resp.Body).Decode(&upsertResponse); err != nil {
+			t.Fatal(err)
+		}
+		return resp, upsertResponse
+	}
+
+	// create path, name is taken from the url
+	resp, created := upsert(PirgRequest{
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusCreated)
+	}
+	if !created.Created {
+		t.Fatal("expected created to be true")
+	}
+	if created.Name != "testapiupsertpirg" {
+		t.Errorf("expected name %v got %v", "testapiupsertpirg", created.Name)
+	}
+
+	// update path
+	resp, updated := upsert(PirgRequest{
+		Name:     "testapiupsertpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	if updated.Created {
+		t.Fatal("expected created to be false")
+	}
+	if updated.Id != created.Id {
+		t.Errorf("expected id %v got %v", created.Id, updated.Id)
+	}
+	if len(updated.UserIds) != 2 {
+		t.Errorf("expected 2 user_ids got %v", updated.UserIds)
+	}
+}
+
+func TestAPIGetPirgMembershipHistory(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapihistoryowner")
+	member := newTestPirgOwner(t, th, "testapihistorymember")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapihistory",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirgURL := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d", pirg.Id)
+	client := &http.Client{}
+
+	// add the member, then remove them
+	for _, userIds := range [][]int{{owner.Id, member.Id}, {owner.Id}} {
+		body, err := json.Marshal(PirgRequest{
+			Name:     pirg.Name,
+			OwnerId:  owner.Id,
+			AdminIds: []int{owner.Id},
+			UserIds:  userIds,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("PUT", pirgURL, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	req, err := http.NewRequest("GET", pirgURL+"/membership-history", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "testkey1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	var page struct {
+		Items []PirgMembershipEventResponse `json:"items"`
+		Total int                           `json:"total"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 2 {
+		t.Fatalf("expected 2 history entries got total %v items %+v", page.Total, page.Items)
+	}
+	if page.Items[0].Action != data.AuditActionMemberAdded || page.Items[1].Action != data.AuditActionMemberRemoved {
+		t.Errorf("expected add then remove got %v then %v", page.Items[0].Action, page.Items[1].Action)
+	}
+	for _, item := range page.Items {
+		if item.UserId != member.Id {
+			t.Errorf("expected target user %v got %v", member.Id, item.UserId)
+		}
+		if item.Actor == "" || item.Actor == "unknown" {
+			t.Errorf("expected the api key user as actor, got %q", item.Actor)
+		}
+	}
+}
+
+func TestGetPirgMembersRejectsInvalidAsOf(t *testing.T) {
+	h := &PirgHandler{}
+	future := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+	for _, asOf := range []string{"yesterday", "2001-13-01", future} {
+		req := httptest.NewRequest("GET", "/pirgs/1/members?as_of="+asOf, nil)
+		req = req.WithContext(context.WithValue(req.Context(), keys.PirgKey, &data.Pirg{Id: 1}))
+		w := httptest.NewRecorder()
+		h.GetPirgMembers(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("as_of=%v: handler returned wrong status code: got %v want %v", asOf, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetPirgMembersRejectsInvalidExpand(t *testing.T) {
+	h := &PirgHandler{}
+	for _, query := range []string{"expand=pirg", "expand=user&as_of=2001-01-01"} {
+		req := httptest.NewRequest("GET", "/pirgs/1/members?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), keys.PirgKey, &data.Pirg{Id: 1}))
+		w := httptest.NewRecorder()
+		h.GetPirgMembers(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPIGetPirgMembersExpandUser(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapimembersexpandowner")
+	member := newTestPirgOwner(t, th, "testapimembersexpandmember")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapimembersexpand",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getMembers := func(query string) []PirgMemberListResponse {
+		t.Helper()
+		url := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/members%s", pirg.Id, query)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, http.StatusOK)
+		}
+		var page struct {
+			Items []PirgMemberListResponse `json:"items"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Items
+	}
+
+	for _, m := range getMembers("") {
+		if m.User != nil {
+			t.Errorf("expected no user record without expand got %+v", m.User)
+		}
+	}
+	members := getMembers("?expand=user")
+	if len(members) != 2 {
+		t.Fatalf("expected 2 members got %+v", members)
+	}
+	for _, m := range members {
+		if m.User == nil || m.User.Id != m.UserId || m.User.Username != m.Username || m.User.CreatedAt.IsZero() {
+			t.Fatalf("expected the member's user record got %+v", m.User)
+		}
+		if wantAdmin := m.UserId == owner.Id; m.IsAdmin != wantAdmin {
+			t.Errorf("expected %v is_admin %v got %v", m.Username, wantAdmin, m.IsAdmin)
+		}
+	}
+}
+
+func TestAPIGetPirgMembersAsOf(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapimembersasofowner")
+	member := newTestPirgOwner(t, th, "testapimembersasofmember")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapimembersasof",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u.Pirgname != ur2.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur2.Pirgname, u.Pirgname)
+	// the owner and member joined on the 1st, and the member left on the 2nd
+	err = data.RecordPirgMembershipChanges(th.DB, "user:1", pirg.Id, []int{}, []int{owner.Id, member.Id})
+	if err != nil {
+		t.Fatal(err)
 	}
-	if u.Email != ur2.Email {
-		t.Errorf("expected email %v got %v", ur2.Email, u.Email)
+	_, err = th.DB.Exec("UPDATE audit_log SET occurred_at = '2001-01-01' WHERE resource_type = 'pirg' AND resource_id = $1", fmt.Sprint(pirg.Id))
+	if err != nil {
+		t.Fatal(err)
 	}
-	if u.FirstName != ur2.FirstName {
-		t.Errorf("expected firstname %v got %v", ur2.FirstName, u.FirstName)
+	err = data.RecordPirgMembershipChanges(th.DB, "user:1", pirg.Id, []int{owner.Id, member.Id}, []int{owner.Id})
+	if err != nil {
+		t.Fatal(err)
 	}
-	if u.LastName != ur2.LastName {
-		t.Errorf("expected lastname %v got %v", ur2.LastName, u.LastName)
+	_, err = th.DB.Exec("UPDATE audit_log SET occurred_at = '2001-01-02' WHERE resource_type = 'pirg' AND resource_id = $1 AND action = $2",
+		fmt.Sprint(pirg.Id), data.AuditActionMemberRemoved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		asOf string
+		want []string
+	}{
+		{"2000-12-31", []string{}},
+		{"2001-01-01T12:00:00Z", []string{owner.Username, member.Username}},
+		{"2001-01-03", []string{owner.Username}},
+	}
+	for _, tt := range tests {
+		url := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/members?as_of=%s", pirg.Id, tt.asOf)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, http.StatusOK)
+		}
+		var page struct {
+			Items []PirgMemberAsOfResponse `json:"items"`
+			Total int                      `json:"total"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, m := range page.Items {
+			got = append(got, m.Username)
+		}
+		slices.Sort(got)
+		slices.Sort(tt.want)
+		if page.Total != len(tt.want) || !slices.Equal(got, tt.want) {
+			t.Fatalf("as of %v expected %v got %v (total %v)", tt.asOf, tt.want, got, page.Total)
+		}
+	}
+}
+
+func TestAPIGetPirgMembersEnvelope(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapienvelopeowner")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapienvelope",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	membersURL := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/members", pirg.Id)
+	get := func(url string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, http.StatusOK)
+		}
+		return resp
+	}
+
+	resp := get(membersURL)
+	defer resp.Body.Close()
+	var page struct {
+		Items []PirgMemberListResponse `json:"items"`
+		Total int                      `json:"total"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].UserId != owner.Id {
+		t.Fatalf("expected an envelope with the owner got %+v", page)
+	}
+
+	bareResp := get(membersURL + "?envelope=false")
+	defer bareResp.Body.Close()
+	var bare []PirgMemberListResponse
+	if err = json.NewDecoder(bareResp.Body).Decode(&bare); err != nil {
+		t.Fatal(err)
+	}
+	if len(bare) != 1 || bare[0].UserId != owner.Id {
+		t.Fatalf("expected a bare array with the owner got %+v", bare)
+	}
+	if total := bareResp.Header.Get("X-Total-Count"); total != "1" {
+		t.Errorf("expected X-Total-Count 1 got %q", total)
+	}
+}
+
+func TestAPIAddPirgMemberWithExpiry(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapiaddmemberowner")
+	guest := newTestPirgOwner(t, th, "testapiaddmemberguest")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapiaddmember",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	body, err := json.Marshal(PirgMemberRequest{UserId: guest.Id, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/members", pirg.Id)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", "testkey1")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusCreated)
+	}
+	var member PirgMemberListResponse
+	if err = json.NewDecoder(resp.Body).Decode(&member); err != nil {
+		t.Fatal(err)
+	}
+	if member.UserId != guest.Id || member.ExpiresAt == nil || !member.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected guest expiring at %v got %+v", expiresAt, member)
+	}
+
+	// the owner's membership can't be made temporary
+	body, err = json.Marshal(PirgMemberRequest{UserId: owner.Id, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", "testkey1")
+	ownerResp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerResp.Body.Close()
+	if ownerResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			ownerResp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestAPIRemovePirgMember(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapiremovememberowner")
+	member := newTestPirgOwner(t, th, "testapiremovemember")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapiremovemember",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method string, path string, body []byte, wantStatus int) {
+		t.Helper()
+		req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/members%s", pirg.Id, path), bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("%s %s returned wrong status code: got %v want %v", method, path, resp.StatusCode, wantStatus)
+		}
+	}
+
+	do("DELETE", fmt.Sprintf("/%d", member.Id), nil, http.StatusNoContent)
+	got, err := data.GetPirgById(th.DB, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.UserIds) != 1 {
+		t.Fatalf("expected only the owner left got %v", got.UserIds)
+	}
+	// they're no longer a member, and the owner can't be removed
+	do("DELETE", fmt.Sprintf("/%d", member.Id), nil, http.StatusNotFound)
+	do("DELETE", fmt.Sprintf("/%d", owner.Id), nil, http.StatusBadRequest)
+
+	// adding back is created, adding again is idempotent, and unknown users aren't found
+	do("POST", "", []byte(fmt.Sprintf(`{"user_id": %d}`, member.Id)), http.StatusCreated)
+	do("POST", "", []byte(fmt.Sprintf(`{"user_id": %d}`, member.Id)), http.StatusOK)
+	do("POST", "", []byte(`{"user_id": 999999999}`), http.StatusNotFound)
+}
+
+func TestAPIPirgAdmins(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapipirgadminsowner")
+	member := newTestPirgOwner(t, th, "testapipirgadminsmember")
+	guest := newTestPirgOwner(t, th, "testapipirgadminsguest")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapipirgadmins",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, memberKey := "testapipirgadminsownerkey", "testapipirgadminsmemberkey"
+	for key, userId := range map[string]int{ownerKey: owner.Id, memberKey: member.Id} {
+		_, err = th.DB.Exec("INSERT INTO api_keys (key, role, user_id) VALUES ($1, 'user', $2)", key, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	do := func(key string, method string, path string, body []byte, wantStatus int) {
+		t.Helper()
+		req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/members%s", pirg.Id, path), bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("%s %s returned wrong status code: got %v want %v", method, path, resp.StatusCode, wantStatus)
+		}
+	}
+	addGuest := []byte(fmt.Sprintf(`{"user_id": %d}`, guest.Id))
+	memberAdmin := fmt.Sprintf("/%d/admin", member.Id)
+	ownerAdmin := fmt.Sprintf("/%d/admin", owner.Id)
+
+	// ordinary members can't change membership, pirg admins can
+	do(memberKey, "POST", "", addGuest, http.StatusForbidden)
+	do(memberKey, "PUT", memberAdmin, nil, http.StatusForbidden)
+	do(ownerKey, "POST", "", addGuest, http.StatusCreated)
+	do(ownerKey, "DELETE", fmt.Sprintf("/%d", guest.Id), nil, http.StatusNoContent)
+
+	// only members can be admins
+	do(ownerKey, "PUT", fmt.Sprintf("/%d/admin", guest.Id), nil, http.StatusNotFound)
+	do(ownerKey, "PUT", memberAdmin, nil, http.StatusCreated)
+	do(ownerKey, "PUT", memberAdmin, nil, http.StatusOK)
+	do(memberKey, "POST", "", addGuest, http.StatusCreated)
+
+	// the last admin can't be removed, global admins can still manage the pirg
+	do(memberKey, "DELETE", ownerAdmin, nil, http.StatusNoContent)
+	do(memberKey, "DELETE", ownerAdmin, nil, http.StatusNotFound)
+	do(memberKey, "DELETE", memberAdmin, nil, http.StatusConflict)
+	do(ownerKey, "PUT", ownerAdmin, nil, http.StatusForbidden)
+	do("testkey1", "DELETE", fmt.Sprintf("/%d", member.Id), nil, http.StatusConflict)
+	do("testkey1", "PUT", ownerAdmin, nil, http.StatusCreated)
+	do("testkey1", "DELETE", memberAdmin, nil, http.StatusNoContent)
+}
+
+// Every route that changes membership is only for pirg admins and global admins
+func TestAPIPirgMembershipRoutesAdminOnly(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapimembershiproutesowner")
+	member := newTestPirgOwner(t, th, "testapimembershiproutesmember")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapimembershiproutes",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := data.CreatePirgMembershipSnapshot(th.DB, pirg.Id, owner.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memberKey := "testapimembershiproutesmemberkey"
+	_, err = th.DB.Exec("INSERT INTO api_keys (key, role, user_id) VALUES ($1, 'user', $2)", memberKey, member.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allMembers := []byte(fmt.Sprintf(`{"usernames": [%q, %q]}`, owner.Username, member.Username))
+	update := []byte(fmt.Sprintf(`{"name": %q, "owner_id": %d, "admin_ids": [%d, %d], "user_ids": [%d, %d]}`,
+		pirg.Name, owner.Id, owner.Id, member.Id, owner.Id, member.Id))
+	tests := []struct {
+		method string
+		path   string
+		body   []byte
+	}{
+		{"PUT", fmt.Sprintf("/%d", pirg.Id), update},
+		{"PUT", "/by-name/" + pirg.Name, update},
+		{"POST", fmt.Sprintf("/%d/reconcile-members", pirg.Id), allMembers},
+		{"POST", fmt.Sprintf("/%d/membership-snapshot", pirg.Id), nil},
+		{"POST", fmt.Sprintf("/%d/membership-restore/%d", pirg.Id, snapshot.Id), nil},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "http://localhost:3333/api/v1/pirgs"+tt.path, bytes.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", memberKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s returned wrong status code: got %v want %v", tt.method, tt.path, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+	got, err := data.GetPirgById(th.DB, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.AdminIds, []int{owner.Id}) {
+		t.Errorf("expected the member not to have made themselves an admin got %v", got.AdminIds)
+	}
+}
+
+func TestAPIComparePirgs(t *testing.T) {
+	th := NewTestDataHandler()
+	shared := newTestPirgOwner(t, th, "testapicompareshared")
+	other := newTestPirgOwner(t, th, "testapicompareother")
+	a, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapicomparea",
+		OwnerId:  shared.Id,
+		AdminIds: []int{shared.Id},
+		UserIds:  []int{shared.Id, other.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapicompareb",
+		OwnerId:  shared.Id,
+		AdminIds: []int{shared.Id},
+		UserIds:  []int{shared.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare := func(query string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/pirgs/compare"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := compare(fmt.Sprintf("?a=%d&b=%d", a.Id, b.Id))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	var comparison PirgCompareResponse
+	if err = json.NewDecoder(resp.Body).Decode(&comparison); err != nil {
+		t.Fatal(err)
+	}
+	if len(comparison.OnlyA) != 1 || comparison.OnlyA[0].UserId != other.Id {
+		t.Errorf("expected %v only in a got %+v", other.Username, comparison.OnlyA)
+	}
+	if len(comparison.OnlyB) != 0 {
+		t.Errorf("expected nobody only in b got %+v", comparison.OnlyB)
+	}
+	if len(comparison.Both) != 1 || comparison.Both[0].UserId != shared.Id {
+		t.Errorf("expected %v in both got %+v", shared.Username, comparison.Both)
+	}
+
+	badResp := compare(fmt.Sprintf("?a=%d", a.Id))
+	badResp.Body.Close()
+	if badResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			badResp.StatusCode, http.StatusBadRequest)
 	}
 }
 
 func TestAPIDeletePirg(t *testing.T) {
 	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapideletepirgowner")
 
 	// first we need to create a pirg, then delete it
-	ur := PirgRequest{
-		Pirgname:  "testapideletepirg",
-		Email:     "testapideletepirg@localhost",
-		FirstName: "TestAPI",
-		LastName:  "deletePirg",
+	pr := PirgRequest{
+		Name:     "testapideletepirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
 	}
-	pirgReq, err := json.Marshal(ur)
+	pirgReq, err := json.Marshal(pr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -276,26 +1028,17 @@ func TestAPIDeletePirg(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	u, err := data.GetPirgById(th.DB, pirgResponse.Id)
+	p, err := data.GetPirgById(th.DB, pirgResponse.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u.Pirgname != ur.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur.Pirgname, u.Pirgname)
-	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
-	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
-	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
+	if p.Name != pr.Name {
+		t.Errorf("expected name %v got %v", pr.Name, p.Name)
 	}
 
 	// now delete the pirg
 	deleteURL := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d", pirgResponse.Id)
-	req, err = http.NewRequest("DELETE", deleteURL, bytes.NewBuffer([]byte(pirgReq)))
+	req, err = http.NewRequest("DELETE", deleteURL, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -338,7 +1081,7 @@ func TestAPIDeletePirg(t *testing.T) {
 	// make sure the pirg is not in the list
 	found := false
 	for _, pirg := range pirgsResponse {
-		if pirg.Pirgname == ur.Pirgname {
+		if pirg.Name == pr.Name {
 			found = true
 		}
 	}
@@ -346,3 +1089,245 @@ func TestAPIDeletePirg(t *testing.T) {
 		t.Error("found pirg that should have been deleted")
 	}
 }
+
+func TestAPIGetPirgSummary(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapipirgsummaryowner")
+	member := newTestPirgOwner(t, th, "testapipirgsummarymember")
+
+	p, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapipirgsummary",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaryURL := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/summary", p.Id)
+	req, err := http.NewRequest("GET", summaryURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", "testkey1")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	var summary PirgSummaryResponse
+	err = json.NewDecoder(resp.Body).Decode(&summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.MemberCount != 2 {
+		t.Errorf("expected member_count 2 got %v", summary.MemberCount)
+	}
+	if summary.AdminCount != 1 {
+		t.Errorf("expected admin_count 1 got %v", summary.AdminCount)
+	}
+	if len(summary.RecentMembers) != 2 {
+		t.Errorf("expected 2 recent members got %v", len(summary.RecentMembers))
+	}
+	if summary.LastActivity.Before(p.CreatedAt) {
+		t.Errorf("expected last_activity %v to be after created_at %v", summary.LastActivity, p.CreatedAt)
+	}
+}
+
+func TestAPIPirgMembershipSnapshotRestore(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapisnapshotowner")
+	member := newTestPirgOwner(t, th, "testapisnapshotmember")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapisnapshot",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(path string, wantStatus int, v any) {
+		t.Helper()
+		req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d/%s", pirg.Id, path), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, wantStatus)
+		}
+		if v != nil {
+			if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var snapshot PirgMembershipSnapshotResponse
+	post("membership-snapshot", http.StatusCreated, &snapshot)
+	if len(snapshot.UserIds) != 2 {
+		t.Fatalf("expected 2 users in the snapshot got %+v", snapshot)
+	}
+	// remove the member, then restore them
+	_, err = data.UpdatePirg(th.DB, pirg.Id, &data.PirgRequest{Name: pirg.Name, OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restore PirgSnapshotRestoreResponse
+	post(fmt.Sprintf("membership-restore/%d", snapshot.Id), http.StatusOK, &restore)
+	if len(restore.AddedUserIds) != 1 || restore.AddedUserIds[0] != member.Id || len(restore.RemovedUserIds) != 0 {
+		t.Fatalf("expected member %v to be added back got %+v", member.Id, restore)
+	}
+	restored, err := data.GetPirgById(th.DB, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.UserIds) != 2 {
+		t.Fatalf("expected the snapshot membership got %v", restored.UserIds)
+	}
+	post("membership-restore/999999", http.StatusNotFound, nil)
+}
+
+func TestAPIReconcileAllPirgMembers(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapireconcileallowner")
+	member := newTestPirgOwner(t, th, "testapireconcileallmember")
+	var pirgs []*data.Pirg
+	for _, name := range []string{"testapireconcileallfirst", "testapireconcileallsecond"} {
+		p, err := data.CreatePirg(th.DB, &data.PirgRequest{Name: name, OwnerId: owner.Id, UserIds: []int{owner.Id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pirgs = append(pirgs, p)
+	}
+	body, err := json.Marshal(map[string][]string{
+		pirgs[0].Name: {owner.Username, member.Username},
+		pirgs[1].Name: {owner.Username, member.Username},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcile := func(query string) *PirgReconcileAllResponse {
+		t.Helper()
+		req, err := http.NewRequest("POST", "http://localhost:3333/admin/pirgs/reconcile-all"+query, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+		var result PirgReconcileAllResponse
+		if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return &result
+	}
+	memberCount := func(id int) int {
+		t.Helper()
+		p, err := data.GetPirgById(th.DB, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(p.UserIds)
+	}
+
+	// without apply the diff is only previewed
+	preview := reconcile("")
+	if !preview.DryRun || len(preview.Pirgs) != 2 {
+		t.Fatalf("expected a dry run over 2 pirgs got %+v", preview)
+	}
+	for _, p := range pirgs {
+		diff := preview.Pirgs[p.Name]
+		if diff == nil || len(diff.Added) != 1 || diff.Added[0].UserId != member.Id || len(diff.Removed) != 0 {
+			t.Fatalf("unexpected diff for %v: %+v", p.Name, diff)
+		}
+		if memberCount(p.Id) != 1 {
+			t.Fatalf("expected the preview to leave %v unchanged", p.Name)
+		}
+	}
+
+	applied := reconcile("?apply=true")
+	if applied.DryRun {
+		t.Fatal("expected the reconcile to be applied")
+	}
+	for _, p := range pirgs {
+		if memberCount(p.Id) != 2 {
+			t.Fatalf("expected %v to have 2 members", p.Name)
+		}
+	}
+}
+
+func TestAPITransferAllPirgs(t *testing.T) {
+	th := NewTestDataHandler()
+	from := newTestPirgOwner(t, th, "testapitransferallfrom")
+	to := newTestPirgOwner(t, th, "testapitransferallto")
+	var pirgs []*data.Pirg
+	for _, name := range []string{"testapitransferallfirst", "testapitransferallsecond"} {
+		p, err := data.CreatePirg(th.DB, &data.PirgRequest{Name: name, OwnerId: from.Id, AdminIds: []int{from.Id}, UserIds: []int{from.Id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pirgs = append(pirgs, p)
+	}
+	transfer := func(body string, wantStatus int) *PirgTransferAllResponse {
+		t.Helper()
+		req, err := http.NewRequest("POST", "http://localhost:3333/admin/pirgs/transfer-all", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, wantStatus)
+		}
+		var result PirgTransferAllResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return &result
+	}
+
+	transfer(`{"from_user": "testapitransferallfrom"}`, http.StatusBadRequest)
+	transfer(`{"from_user": "testapitransferallfrom", "to_user": "testapitransferallnobody"}`, http.StatusBadRequest)
+	result := transfer(`{"from_user": "testapitransferallfrom", "to_user": "testapitransferallto"}`, http.StatusOK)
+	if result.Transferred != 2 || len(result.PirgIds) != 2 {
+		t.Fatalf("expected 2 pirgs transferred got %+v", result)
+	}
+	for _, p := range pirgs {
+		got, err := data.GetPirgById(th.DB, p.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.OwnerId != to.Id || !slices.Contains(got.UserIds, to.Id) {
+			t.Errorf("expected %v to be owned by and have member %v got owner %v members %v", p.Name, to.Id, got.OwnerId, got.UserIds)
+		}
+	}
+	if result = transfer(`{"from_user": "testapitransferallfrom", "to_user": "testapitransferallto"}`, http.StatusOK); result.Transferred != 0 {
+		t.Errorf("expected nothing left to transfer got %+v", result)
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// NextPosixIdResponse previews the next id the allocator would assign.
+// It's advisory, since a concurrent allocation can take the id first.
+type NextPosixIdResponse struct {
+	Kind     string `json:"kind"`
+	Next     int    `json:"next"`
+	Advisory bool   `json:"advisory"`
+}
+
+func (n *NextPosixIdResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// PosixIdUtilizationResponse reports how much of a range is used.
+// Only kind and configured are set for a range that isn't configured.
+// next is omitted once the range is exhausted.
+type PosixIdUtilizationResponse struct {
+	Kind       string `json:"kind"`
+	Configured bool   `json:"configured"`
+	Min        int    `json:"min,omitempty"`
+	Max        int    `json:"max,omitempty"`
+	Size       int    `json:"size"`
+	Allocated  int    `json:"allocated"`
+	Free       int    `json:"free"`
+	Remaining  int    `json:"remaining"`
+	Next       int    `json:"next,omitempty"`
+}
+
+type AllocationUtilizationResponse struct {
+	Ranges []*PosixIdUtilizationResponse `json:"ranges"`
+}
+
+func (a *AllocationUtilizationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// PosixIdBackfillResponse counts the uids and gids a backfill allocated,
+// or on a dry run would have
+type PosixIdBackfillResponse struct {
+	Uids   int  `json:"uids"`
+	Gids   int  `json:"gids"`
+	DryRun bool `json:"dry_run"`
+}
+
+func (p *PosixIdBackfillResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type PosixIdHandler struct {
+	dbConn *sql.DB
+	uids   data.PosixIdRange
+	gids   data.PosixIdRange
+}
+
+func newPosixIdHandler(ctx context.Context) *PosixIdHandler {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	return &PosixIdHandler{
+		dbConn: dbConn,
+		uids:   data.PosixIdRange{Min: cfg.PosixIds.UidMin, Max: cfg.PosixIds.UidMax},
+		gids:   data.PosixIdRange{Min: cfg.PosixIds.GidMin, Max: cfg.PosixIds.GidMax},
+	}
+}
+
+// GetNextUid returns the next uid without allocating it
+func (h *PosixIdHandler) GetNextUid(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting next uid", "package", "api", "method", "GetNextUid")
+	h.renderNext(w, r, data.PosixIdKindUid, h.uids)
+}
+
+// GetNextGid returns the next gid without allocating it
+func (h *PosixIdHandler) GetNextGid(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting next gid", "package", "api", "method", "GetNextGid")
+	h.renderNext(w, r, data.PosixIdKindGid, h.gids)
+}
+
+func (h *PosixIdHandler) renderNext(w http.ResponseWriter, r *http.Request, kind string, rng data.PosixIdRange) {
+	if !rng.Configured() {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("%s allocation is not configured", kind)))
+		return
+	}
+	next, err := data.NextPosixId(h.dbConn, kind, rng)
+	if errors.Is(err, data.ErrPosixIdRangeExhausted) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := &NextPosixIdResponse{Kind: kind, Next: next, Advisory: true}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetAllocationUtilization returns the size, allocated, and free counts of the uid
+// and gid ranges, and the next id of each. remaining is how many ids the allocator
+// can still hand out, which is less than free if ids were allocated out of order.
+func (h *PosixIdHandler) GetAllocationUtilization(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting allocation utilization", "package", "api", "method", "GetAllocationUtilization")
+	resp := &AllocationUtilizationResponse{Ranges: []*PosixIdUtilizationResponse{}}
+	ranges := []struct {
+		kind string
+		rng  data.PosixIdRange
+	}{
+		{data.PosixIdKindUid, h.uids},
+		{data.PosixIdKindGid, h.gids},
+	}
+	for _, rr := range ranges {
+		if !rr.rng.Configured() {
+			resp.Ranges = append(resp.Ranges, &PosixIdUtilizationResponse{Kind: rr.kind})
+			continue
+		}
+		u, err := data.GetPosixIdUtilization(h.dbConn, rr.kind, rr.rng)
+		if err != nil {
+			render.Render(w, r, ErrInternal(err))
+			return
+		}
+		resp.Ranges = append(resp.Ranges, &PosixIdUtilizationResponse{
+			Kind:       u.Kind,
+			Configured: true,
+			Min:        u.Range.Min,
+			Max:        u.Range.Max,
+			Size:       u.Size,
+			Allocated:  u.Allocated,
+			Free:       u.Free,
+			Remaining:  u.Remaining,
+			Next:       u.Next,
+		})
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// BackfillPosixIds allocates uids to the users and gids to the pirgs that
+// were created before allocation was configured, see data.BackfillPosixIds.
+// A range without room for every missing id is a 409 and nothing is
+// allocated. With ?dry_run=true the counts are returned and nothing is.
+func (h *PosixIdHandler) BackfillPosixIds(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("backfilling posix ids", "package", "api", "method", "BackfillPosixIds")
+	if !h.uids.Configured() && !h.gids.Configured() {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("uid and gid allocation are not configured")))
+		return
+	}
+	res, err := data.BackfillPosixIds(h.dbConn, h.uids, h.gids, dryRunRequested(r))
+	if errors.Is(err, data.ErrPosixIdRangeExhausted) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if !res.DryRun {
+		slog.Info("backfilled posix ids", "uids", res.Uids, "gids", res.Gids, "actor", actorFromContext(r.Context()), "package", "api", "method", "BackfillPosixIds")
+	}
+	resp := &PosixIdBackfillResponse{Uids: res.Uids, Gids: res.Gids, DryRun: res.DryRun}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
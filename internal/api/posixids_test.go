@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestGetNextPosixIdMatchesAllocation(t *testing.T) {
+	th := NewTestDataHandler()
+	h := &PosixIdHandler{
+		dbConn: th.DB,
+		uids:   data.PosixIdRange{Min: 80000, Max: 80099},
+		gids:   data.PosixIdRange{Min: 81000, Max: 81099},
+	}
+	tests := []struct {
+		kind    string
+		handler http.HandlerFunc
+		rng     data.PosixIdRange
+	}{
+		{data.PosixIdKindUid, h.GetNextUid, h.uids},
+		{data.PosixIdKindGid, h.GetNextGid, h.gids},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest("GET", "/next-"+tt.kind, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+			}
+			var preview NextPosixIdResponse
+			if err := json.NewDecoder(w.Body).Decode(&preview); err != nil {
+				t.Fatal(err)
+			}
+			if !preview.Advisory {
+				t.Error("expected the preview to be marked advisory")
+			}
+			allocated, err := data.AllocatePosixId(th.DB, tt.kind, 1, tt.rng)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allocated != preview.Next {
+				t.Errorf("expected allocated %v %v to match preview %v", tt.kind, allocated, preview.Next)
+			}
+		})
+	}
+}
+
+func TestGetNextPosixIdNotConfigured(t *testing.T) {
+	h := &PosixIdHandler{}
+	w := httptest.NewRecorder()
+	h.GetNextUid(w, httptest.NewRequest("GET", "/next-uid", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetAllocationUtilization(t *testing.T) {
+	th := NewTestDataHandler()
+	h := &PosixIdHandler{
+		dbConn: th.DB,
+		uids:   data.PosixIdRange{Min: 86000, Max: 86099},
+	}
+	for _, resourceId := range []int{1, 2, 3} {
+		if _, err := data.AllocatePosixId(th.DB, data.PosixIdKindUid, resourceId, h.uids); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.GetAllocationUtilization(w, httptest.NewRequest("GET", "/allocations/utilization", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	var resp AllocationUtilizationResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Ranges) != 2 {
+		t.Fatalf("expected the uid and gid ranges got %+v", resp.Ranges)
+	}
+	uids, gids := resp.Ranges[0], resp.Ranges[1]
+	if uids.Kind != data.PosixIdKindUid || !uids.Configured || uids.Size != 100 || uids.Allocated != 3 || uids.Free != 97 || uids.Remaining != 97 || uids.Next != 86003 {
+		t.Errorf("unexpected uid utilization %+v", uids)
+	}
+	if gids.Kind != data.PosixIdKindGid || gids.Configured {
+		t.Errorf("expected the gid range to be reported unconfigured got %+v", gids)
+	}
+}
+
+func TestBackfillPosixIdsNotConfigured(t *testing.T) {
+	h := &PosixIdHandler{}
+	w := httptest.NewRecorder()
+	h.BackfillPosixIds(w, httptest.NewRequest("POST", "/maintenance/backfill-ids", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
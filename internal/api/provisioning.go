@@ -0,0 +1,394 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultProvisionBasePath is where pirg directories are created if base_path isn't set
+const defaultProvisionBasePath = "/projects"
+
+// defaultProvisionScript creates the pirg directory owned by the owner and the
+// pirg gid, and a directory inside it for each member
+const defaultProvisionScript = `#!/bin/sh
+# provisions pirg {{.Name}}, generated by hpcadmin-server
+set -eu
+
+dir={{quote .Path}}
+mkdir -p "$dir"
+chown {{.OwnerUid}}:{{.Gid}} "$dir"
+chmod 2770 "$dir"
+{{- if .Quota}}
+# quota: {{.Quota}}
+{{- end}}
+{{range .Members}}
+mkdir -p "$dir"/{{quote .Username}}
+chown {{.Uid}}:{{$.Gid}} "$dir"/{{quote .Username}}
+chmod 2770 "$dir"/{{quote .Username}}
+{{- end}}
+`
+
+// provisionScriptFuncs are available to provisioning script templates.
+// quote single quotes a string for the shell.
+var provisionScriptFuncs = template.FuncMap{
+	"quote": shellQuote,
+}
+
+// shellQuote single quotes s for the shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ProvisionScript is what provisioning script templates are rendered with.
+// Path is the pirg's directory and Members are ordered by username.
+type ProvisionScript struct {
+	Name     string
+	Path     string
+	Gid      int
+	OwnerUid int
+	Quota    string
+	Members  []ProvisionScriptMember
+}
+
+type ProvisionScriptMember struct {
+	Username string
+	Uid      int
+}
+
+// ProvisioningExportResponse is every pirg's provisioning attributes, for
+// building a cluster. Pirgs are ordered by name, members by username, and
+// partitions by name, so the same state always exports the same document.
+type ProvisioningExportResponse struct {
+	Partitions []*PartitionResponse        `json:"partitions" yaml:"partitions"`
+	Pirgs      []*PirgProvisioningResponse `json:"pirgs" yaml:"pirgs"`
+}
+
+type PirgProvisioningResponse struct {
+	Id      int                          `json:"id" yaml:"id"`
+	Name    string                       `json:"name" yaml:"name"`
+	Path    string                       `json:"path" yaml:"path"`
+	Gid     int                          `json:"gid" yaml:"gid"`
+	Quota   string                       `json:"quota" yaml:"quota"`
+	OwnerId int                          `json:"owner_id" yaml:"owner_id"`
+	Members []*ProvisionedMemberResponse `json:"members" yaml:"members"`
+}
+
+type ProvisionedMemberResponse struct {
+	UserId   int    `json:"user_id" yaml:"user_id"`
+	Username string `json:"username" yaml:"username"`
+	Uid      int    `json:"uid" yaml:"uid"`
+}
+
+func (h *ProvisioningHandler) newPirgProvisioningResponse(p *data.PirgProvisioning) *PirgProvisioningResponse {
+	resp := &PirgProvisioningResponse{
+		Id:      p.PirgId,
+		Name:    p.Name,
+		Path:    h.pirgPath(p.Name),
+		Gid:     p.Gid,
+		Quota:   h.cfg.Quota,
+		OwnerId: p.OwnerId,
+		Members: []*ProvisionedMemberResponse{},
+	}
+	for _, m := range p.Members {
+		resp.Members = append(resp.Members, &ProvisionedMemberResponse{UserId: m.UserId, Username: m.Username, Uid: m.Uid})
+	}
+	return resp
+}
+
+// Pirg invariant violations ValidatePirg reports
+const (
+	PirgViolationNoMembers      = "no_members"
+	PirgViolationOwnerNotMember = "owner_not_member"
+	PirgViolationOwnerNotAdmin  = "owner_not_admin"
+	PirgViolationAdminNotMember = "admin_not_member"
+	PirgViolationInvalidName    = "invalid_name"
+	PirgViolationNoQuota        = "no_quota"
+	PirgViolationNoPartitions   = "no_partitions"
+)
+
+// PirgValidationResponse lists what's wrong with a pirg before it's exported
+// to the scheduler, Violations is empty if nothing is
+type PirgValidationResponse struct {
+	PirgId     int                      `json:"pirg_id"`
+	Violations []*PirgViolationResponse `json:"violations"`
+}
+
+func (p *PirgValidationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type PirgViolationResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// maxTemplateValidateBytes caps the size of a template submitted for validation
+const maxTemplateValidateBytes = 1 << 20
+
+// sampleProvisioning is the pirg submitted templates are rendered with
+var sampleProvisioning = &data.PirgProvisioning{
+	PirgId:  1,
+	Name:    "samplepirg",
+	OwnerId: 1,
+	Gid:     50001,
+	Members: []*data.ProvisionedMember{
+		{UserId: 2, Username: "asmith", Uid: 40002},
+		{UserId: 1, Username: "jdoe", Uid: 40001},
+	},
+}
+
+// TemplateValidationResponse reports whether a template parsed and rendered,
+// Output is what it rendered for the sample pirg
+type TemplateValidationResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+	Output string   `json:"output,omitempty"`
+}
+
+func (t *TemplateValidationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// provisioningExportFormats are the formats ExportProvisioning accepts, the first is the default
+var provisioningExportFormats = []string{"json", "yaml"}
+
+type ProvisioningHandler struct {
+	dbConn     *sql.DB
+	uids       data.PosixIdRange
+	gids       data.PosixIdRange
+	cfg        config.ProvisioningConfig
+	partitions []config.PartitionConfig
+}
+
+func newProvisioningHandler(ctx context.Context) *ProvisioningHandler {
+	dbConn, _ := ctx.Value(keys.DBConnKey).(*sql.DB)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	return &ProvisioningHandler{
+		dbConn:     dbConn,
+		uids:       data.PosixIdRange{Min: cfg.PosixIds.UidMin, Max: cfg.PosixIds.UidMax},
+		gids:       data.PosixIdRange{Min: cfg.PosixIds.GidMin, Max: cfg.PosixIds.GidMax},
+		cfg:        cfg.Provisioning,
+		partitions: cfg.Partitions,
+	}
+}
+
+// GetPirgProvisionScript returns a shell script that creates the directories of
+// the Pirg in the request context, owned by its gid and its members' uids.
+// Ids that haven't been allocated yet are allocated.
+func (h *ProvisioningHandler) GetPirgProvisionScript(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting pirg provision script", "package", "api", "method", "GetPirgProvisionScript")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	p, err := data.GetPirgProvisioning(h.dbConn, pirg.Id, h.uids, h.gids)
+	if err != nil {
+		renderProvisioningError(w, r, err)
+		return
+	}
+	tmpl, err := h.scriptTemplate()
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	// render to a buffer so a template error can still be reported
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, h.newProvisionScript(p)); err != nil {
+		render.Render(w, r, ErrInternal(fmt.Errorf("failed to render provision script: %v", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-shellscript")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="provision-%s.sh"`, p.Name))
+	w.Write(buf.Bytes())
+}
+
+func (h *ProvisioningHandler) newProvisionScript(p *data.PirgProvisioning) *ProvisionScript {
+	s := &ProvisionScript{
+		Name:    p.Name,
+		Path:    h.pirgPath(p.Name),
+		Gid:     p.Gid,
+		Quota:   h.cfg.Quota,
+		Members: []ProvisionScriptMember{},
+	}
+	for _, m := range p.Members {
+		if m.UserId == p.OwnerId {
+			s.OwnerUid = m.Uid
+		}
+		s.Members = append(s.Members, ProvisionScriptMember{Username: m.Username, Uid: m.Uid})
+	}
+	return s
+}
+
+// pirgPath is the directory the pirg is provisioned in
+func (h *ProvisioningHandler) pirgPath(name string) string {
+	basePath := h.cfg.BasePath
+	if basePath == "" {
+		basePath = defaultProvisionBasePath
+	}
+	return path.Join(basePath, name)
+}
+
+// ValidatePirg checks the Pirg in the request context is ready to be exported:
+// it has members including its owner, its owner and admins are members, and a
+// quota and partitions are configured to export it with.
+func (h *ProvisioningHandler) ValidatePirg(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("validating pirg", "package", "api", "method", "ValidatePirg")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	resp := &PirgValidationResponse{PirgId: pirg.Id, Violations: h.pirgViolations(pirg)}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+func (h *ProvisioningHandler) pirgViolations(pirg *data.Pirg) []*PirgViolationResponse {
+	violations := []*PirgViolationResponse{}
+	add := func(code string, format string, a ...any) {
+		violations = append(violations, &PirgViolationResponse{Code: code, Message: fmt.Sprintf(format, a...)})
+	}
+	if len(pirg.UserIds) == 0 {
+		add(PirgViolationNoMembers, "pirg has no members")
+	}
+	if !slices.Contains(pirg.UserIds, pirg.OwnerId) {
+		add(PirgViolationOwnerNotMember, "owner %d is not a member", pirg.OwnerId)
+	}
+	if !slices.Contains(pirg.AdminIds, pirg.OwnerId) {
+		add(PirgViolationOwnerNotAdmin, "owner %d is not an admin", pirg.OwnerId)
+	}
+	for _, adminId := range pirg.AdminIds {
+		if !slices.Contains(pirg.UserIds, adminId) {
+			add(PirgViolationAdminNotMember, "admin %d is not a member", adminId)
+		}
+	}
+	if err := ValidatePirgName(pirg.Name); err != nil {
+		add(PirgViolationInvalidName, "invalid name: %v", err)
+	}
+	if h.cfg.Quota == "" {
+		add(PirgViolationNoQuota, "provisioning quota is not configured")
+	}
+	if len(h.partitions) == 0 {
+		add(PirgViolationNoPartitions, "no partitions are configured")
+	}
+	return violations
+}
+
+// ExportProvisioning returns the provisioning attributes of every pirg and the
+// configured partitions as JSON, or as YAML with ?format=yaml. Ids that haven't
+// been allocated yet are allocated.
+func (h *ProvisioningHandler) ExportProvisioning(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("exporting provisioning", "package", "api", "method", "ExportProvisioning")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = provisioningExportFormats[0]
+	}
+	if !slices.Contains(provisioningExportFormats, format) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported export format: %s", format)))
+		return
+	}
+	pirgs, err := data.GetAllPirgProvisioning(h.dbConn, h.uids, h.gids)
+	if err != nil {
+		renderProvisioningError(w, r, err)
+		return
+	}
+	resp := &ProvisioningExportResponse{
+		Partitions: []*PartitionResponse{},
+		Pirgs:      []*PirgProvisioningResponse{},
+	}
+	for _, p := range h.partitions {
+		resp.Partitions = append(resp.Partitions, newPartitionResponse(p))
+	}
+	slices.SortFunc(resp.Partitions, func(a, b *PartitionResponse) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, p := range pirgs {
+		resp.Pirgs = append(resp.Pirgs, h.newPirgProvisioningResponse(p))
+	}
+
+	if format == "json" {
+		render.JSON(w, r, resp)
+		return
+	}
+	out, err := yaml.Marshal(resp)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
+
+// ValidateScriptTemplate parses the provisioning script template in the request
+// body and renders it for a sample pirg, so a template can be checked before
+// it's deployed as provisioning.script_template. Parse and render errors are
+// reported in the response rather than as an error status.
+func (h *ProvisioningHandler) ValidateScriptTemplate(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("validating submitted script template", "package", "api", "method", "ValidateScriptTemplate")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTemplateValidateBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		render.Render(w, r, ErrRequestTooLarge(tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("failed to read template: %v", err)))
+		return
+	}
+	if len(body) == 0 {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("missing template in request body")))
+		return
+	}
+	resp := &TemplateValidationResponse{Errors: []string{}}
+	tmpl, err := template.New("provision").Funcs(provisionScriptFuncs).Option("missingkey=error").Parse(string(body))
+	if err != nil {
+		resp.Errors = append(resp.Errors, fmt.Sprintf("failed to parse template: %v", err))
+	} else {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, h.newProvisionScript(sampleProvisioning)); err != nil {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("failed to render template: %v", err))
+		} else {
+			resp.Output = buf.String()
+		}
+	}
+	resp.Valid = len(resp.Errors) == 0
+	slog.Debug("validated submitted script template", "valid", resp.Valid, "package", "api", "method", "ValidateScriptTemplate")
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// scriptTemplate parses the configured script template, or the built in one.
+// The file is read on every request so it can be changed without a restart.
+func (h *ProvisioningHandler) scriptTemplate() (*template.Template, error) {
+	if h.cfg.ScriptTemplate == "" {
+		return template.New("provision").Funcs(provisionScriptFuncs).Parse(defaultProvisionScript)
+	}
+	tmpl, err := template.New(filepath.Base(h.cfg.ScriptTemplate)).Funcs(provisionScriptFuncs).ParseFiles(h.cfg.ScriptTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provision script template: %v", err)
+	}
+	return tmpl, nil
+}
+
+func renderProvisioningError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrPosixIdRangeNotConfigured):
+		render.Render(w, r, ErrInvalidRequest(err))
+	case errors.Is(err, data.ErrPosixIdRangeExhausted):
+		render.Render(w, r, ErrConflict(err))
+	default:
+		render.Render(w, r, ErrInternal(err))
+	}
+}
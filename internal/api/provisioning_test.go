@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGetPirgProvisionScript(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testprovisionscriptowner")
+	member := newTestPirgOwner(t, th, "testprovisionscriptmember")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testprovisionscript",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ProvisioningHandler{
+		dbConn: th.DB,
+		uids:   data.PosixIdRange{Min: 82000, Max: 82099},
+		gids:   data.PosixIdRange{Min: 83000, Max: 83099},
+		cfg:    config.ProvisioningConfig{BasePath: "/gpfs/projects", Quota: "2T"},
+	}
+	getScript := func(t *testing.T) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/provision-script", nil)
+		req = req.WithContext(context.WithValue(req.Context(), keys.PirgKey, pirg))
+		h.GetPirgProvisionScript(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	t.Run("DefaultTemplate", func(t *testing.T) {
+		script := getScript(t)
+		gid, err := data.AllocatePosixId(th.DB, data.PosixIdKindGid, pirg.Id, h.gids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ownerUid, err := data.AllocatePosixId(th.DB, data.PosixIdKindUid, owner.Id, h.uids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		memberUid, err := data.AllocatePosixId(th.DB, data.PosixIdKindUid, member.Id, h.uids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			"dir='/gpfs/projects/testprovisionscript'",
+			fmt.Sprintf(`chown %d:%d "$dir"`+"\n", ownerUid, gid),
+			fmt.Sprintf(`chown %d:%d "$dir"/'testprovisionscriptmember'`, memberUid, gid),
+			fmt.Sprintf(`chown %d:%d "$dir"/'testprovisionscriptowner'`, ownerUid, gid),
+			"# quota: 2T",
+		}
+		for _, e := range expected {
+			if !strings.Contains(script, e) {
+				t.Errorf("expected script to contain %q got:\n%s", e, script)
+			}
+		}
+	})
+	t.Run("ConfiguredTemplate", func(t *testing.T) {
+		tmplFile := filepath.Join(t.TempDir(), "provision.sh.tmpl")
+		tmpl := "{{.Path}} {{.Gid}} {{.Quota}}{{range .Members}} {{.Username}}={{.Uid}}{{end}}"
+		if err := os.WriteFile(tmplFile, []byte(tmpl), 0600); err != nil {
+			t.Fatal(err)
+		}
+		h.cfg.ScriptTemplate = tmplFile
+		defer func() { h.cfg.ScriptTemplate = "" }()
+		script := getScript(t)
+		if !strings.HasPrefix(script, "/gpfs/projects/testprovisionscript 830") || !strings.Contains(script, " 2T testprovisionscriptmember=820") {
+			t.Errorf("unexpected script from configured template: %s", script)
+		}
+	})
+}
+
+func TestGetPirgProvisionScriptNotConfigured(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testprovisionunconfiguredowner")
+	pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testprovisionunconfigured",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ProvisioningHandler{dbConn: th.DB}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/provision-script", nil)
+	req = req.WithContext(context.WithValue(req.Context(), keys.PirgKey, pirg))
+	h.GetPirgProvisionScript(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExportProvisioning(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testexportprovisioningowner")
+	member := newTestPirgOwner(t, th, "testexportprovisioningmember")
+	seeded := map[string]*data.Pirg{}
+	for _, name := range []string{"testexportprovisioningb", "testexportprovisioninga"} {
+		pirg, err := data.CreatePirg(th.DB, &data.PirgRequest{
+			Name:     name,
+			OwnerId:  owner.Id,
+			AdminIds: []int{owner.Id},
+			UserIds:  []int{owner.Id, member.Id},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeded[name] = pirg
+	}
+	h := &ProvisioningHandler{
+		dbConn: th.DB,
+		uids:   data.PosixIdRange{Min: 84000, Max: 84999},
+		gids:   data.PosixIdRange{Min: 85000, Max: 85999},
+		cfg:    config.ProvisioningConfig{Quota: "500G"},
+		partitions: []config.PartitionConfig{
+			{Name: "gpu", MaxNodes: 2},
+			{Name: "compute", MaxNodes: 10},
+		},
+	}
+	tests := []struct {
+		format string
+		decode func([]byte, any) error
+	}{
+		{"", json.Unmarshal},
+		{"yaml", yaml.Unmarshal},
+	}
+	for _, tt := range tests {
+		t.Run("Format"+tt.format, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ExportProvisioning(w, httptest.NewRequest("GET", "/export/provisioning?format="+tt.format, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			var export ProvisioningExportResponse
+			if err := tt.decode(w.Body.Bytes(), &export); err != nil {
+				t.Fatal(err)
+			}
+			if len(export.Partitions) != 2 || export.Partitions[0].Name != "compute" || export.Partitions[1].MaxNodes != 2 {
+				t.Errorf("expected both partitions ordered by name got %+v", export.Partitions)
+			}
+			var names []string
+			found := 0
+			for _, p := range export.Pirgs {
+				names = append(names, p.Name)
+				pirg, ok := seeded[p.Name]
+				if !ok {
+					continue
+				}
+				found++
+				if p.Id != pirg.Id || p.Gid < h.gids.Min || p.Quota != "500G" || p.Path != "/projects/"+p.Name {
+					t.Errorf("unexpected provisioning for %v: %+v", p.Name, p)
+				}
+				if len(p.Members) != 2 || p.Members[0].Username != member.Username || p.Members[0].Uid < h.uids.Min {
+					t.Errorf("expected both members with uids for %v got %+v", p.Name, p.Members)
+				}
+			}
+			if found != len(seeded) {
+				t.Errorf("expected every seeded pirg in the export got %v", names)
+			}
+			if !slices.IsSorted(names) {
+				t.Errorf("expected pirgs ordered by name got %v", names)
+			}
+		})
+	}
+	t.Run("UnsupportedFormat", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ExportProvisioning(w, httptest.NewRequest("GET", "/export/provisioning?format=xml", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestValidatePirg(t *testing.T) {
+	h := &ProvisioningHandler{
+		cfg:        config.ProvisioningConfig{Quota: "2T"},
+		partitions: []config.PartitionConfig{{Name: "compute"}},
+	}
+	validate := func(t *testing.T, h *ProvisioningHandler, pirg *data.Pirg) []string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/validate", nil)
+		req = req.WithContext(context.WithValue(req.Context(), keys.PirgKey, pirg))
+		h.ValidatePirg(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+		}
+		var resp PirgValidationResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.PirgId != pirg.Id || resp.Violations == nil {
+			t.Errorf("unexpected validation response: %s", w.Body.String())
+		}
+		codes := []string{}
+		for _, v := range resp.Violations {
+			codes = append(codes, v.Code)
+		}
+		return codes
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		pirg := &data.Pirg{Id: 1, Name: "testvalidpirg", OwnerId: 10, AdminIds: []int{10}, UserIds: []int{10, 11}}
+		if codes := validate(t, h, pirg); len(codes) != 0 {
+			t.Errorf("expected no violations got %v", codes)
+		}
+	})
+	t.Run("Violations", func(t *testing.T) {
+		unconfigured := &ProvisioningHandler{}
+		pirg := &data.Pirg{Id: 2, Name: "testinvalidpirg", OwnerId: 10, AdminIds: []int{12}, UserIds: []int{}}
+		codes := validate(t, unconfigured, pirg)
+		expected := []string{
+			PirgViolationNoMembers,
+			PirgViolationOwnerNotMember,
+			PirgViolationOwnerNotAdmin,
+			PirgViolationAdminNotMember,
+			PirgViolationNoQuota,
+			PirgViolationNoPartitions,
+		}
+		if !slices.Equal(codes, expected) {
+			t.Errorf("expected violations %v got %v", expected, codes)
+		}
+	})
+}
+
+func TestValidateScriptTemplate(t *testing.T) {
+	h := &ProvisioningHandler{cfg: config.ProvisioningConfig{BasePath: "/gpfs/projects", Quota: "2T"}}
+	validate := func(t *testing.T, tmpl string) TemplateValidationResponse {
+		w := httptest.NewRecorder()
+		h.ValidateScriptTemplate(w, httptest.NewRequest("POST", "/templates/validate", strings.NewReader(tmpl)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var resp TemplateValidationResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		resp := validate(t, "{{quote .Path}} {{.Quota}}{{range .Members}} {{.Username}}={{.Uid}}{{end}}")
+		if !resp.Valid || len(resp.Errors) != 0 {
+			t.Fatalf("expected valid template got errors %v", resp.Errors)
+		}
+		if resp.Output != "'/gpfs/projects/samplepirg' 2T asmith=40002 jdoe=40001" {
+			t.Errorf("unexpected output for sample pirg: %q", resp.Output)
+		}
+	})
+	t.Run("DefaultTemplate", func(t *testing.T) {
+		if resp := validate(t, defaultProvisionScript); !resp.Valid {
+			t.Errorf("expected the built in template to be valid got errors %v", resp.Errors)
+		}
+	})
+	t.Run("SyntaxError", func(t *testing.T) {
+		resp := validate(t, "{{range .Members}}{{.Username}}")
+		if resp.Valid || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0], "failed to parse") {
+			t.Errorf("expected a parse error got %+v", resp)
+		}
+	})
+	t.Run("RenderError", func(t *testing.T) {
+		resp := validate(t, "{{.Owner.Email}}")
+		if resp.Valid || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0], "failed to render") {
+			t.Errorf("expected a render error got %+v", resp)
+		}
+	})
+	t.Run("Empty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ValidateScriptTemplate(w, httptest.NewRequest("POST", "/templates/validate", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/ratelimit"
+)
+
+// RateLimit answers 429 Too Many Requests, with a Retry-After, for clients
+// that have used up their requests. Clients are the authenticated actor, or
+// the remote address if there's none, so it has to come after the auth
+// middleware. If the limiter fails the request is served. A nil limiter
+// doesn't limit.
+//
+// While there's a limit, responses have X-RateLimit-Limit, the most requests
+// the client can make at once, X-RateLimit-Remaining, how many they have
+// left, and X-RateLimit-Reset, the seconds until they have them all back.
+func RateLimit(limiter ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(r)
+			ok, wait, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				slog.Error("failed to check rate limit", "key", key, "error", err, "package", "api", "method", "RateLimit")
+				next.ServeHTTP(w, r)
+				return
+			}
+			status, err := limiter.Status(r.Context(), key)
+			if err != nil {
+				slog.Error("failed to get rate limit status", "key", key, "error", err, "package", "api", "method", "RateLimit")
+			} else if status.Limit > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+				w.Header().Set("X-RateLimit-Reset", strconv.Itoa(resetSeconds(status.Reset)))
+				r = r.WithContext(context.WithValue(r.Context(), keys.RateLimitKey, status))
+			}
+			if !ok {
+				slog.Debug("rate limiting request", "key", key, "retry_after", wait, "package", "api", "method", "RateLimit")
+				seconds := retryAfterSeconds(wait)
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				render.Render(w, r, ErrTooManyRequests(seconds))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitResponse is the caller's rate limit as of their request, see
+// RateLimit. Enabled is false if there's no limit.
+type RateLimitResponse struct {
+	Enabled   bool `json:"enabled"`
+	Limit     int  `json:"limit"`
+	Remaining int  `json:"remaining"`
+	// Reset is the seconds until the caller has all their requests back
+	Reset int `json:"reset"`
+}
+
+func (rl *RateLimitResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// GetRateLimit returns the caller's rate limit, so clients can throttle
+// themselves. It has to come after RateLimit, and counts against the limit
+// like any other request.
+func GetRateLimit(w http.ResponseWriter, r *http.Request) {
+	resp := &RateLimitResponse{}
+	if status, ok := r.Context().Value(keys.RateLimitKey).(ratelimit.Status); ok {
+		resp = &RateLimitResponse{
+			Enabled:   true,
+			Limit:     status.Limit,
+			Remaining: status.Remaining,
+			Reset:     resetSeconds(status.Reset),
+		}
+	}
+	render.Render(w, r, resp)
+}
+
+// rateLimitKey is the client a request counts against
+func rateLimitKey(r *http.Request) string {
+	if actor, ok := r.Context().Value(keys.ActorKey).(string); ok && actor != "" {
+		return actor
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// resetSeconds rounds reset up to whole seconds
+func resetSeconds(reset time.Duration) int {
+	return int(math.Ceil(reset.Seconds()))
+}
+
+// retryAfterSeconds rounds wait up to whole seconds, at least 1
+func retryAfterSeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
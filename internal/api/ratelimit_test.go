@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	const burst = 5
+	handler := RateLimit(ratelimit.NewMemoryLimiter(1, burst))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(actor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/users", nil)
+		if actor != "" {
+			req = req.WithContext(context.WithValue(req.Context(), keys.ActorKey, actor))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < burst; i++ {
+		if w := get("apikey:1"); w.Code != http.StatusOK {
+			t.Fatalf("expected request %d to be served got %v", i+1, w.Code)
+		}
+	}
+	w := get("apikey:1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected request %d to be limited got %v", burst+1, w.Code)
+	}
+	if seconds, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || seconds < 1 {
+		t.Errorf("expected a Retry-After in seconds got %q", w.Header().Get("Retry-After"))
+	}
+
+	// other actors, and unauthenticated requests by address, have their own limit
+	if w := get("apikey:2"); w.Code != http.StatusOK {
+		t.Errorf("expected another actor to be served got %v", w.Code)
+	}
+	if w := get(""); w.Code != http.StatusOK {
+		t.Errorf("expected an unauthenticated request to be served got %v", w.Code)
+	}
+
+	// a nil limiter doesn't limit
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for i := 0; i <= burst; i++ {
+		w := httptest.NewRecorder()
+		RateLimit(nil)(next).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/users", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected a nil limiter to serve every request got %v", w.Code)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	const burst = 3
+	handler := RateLimit(ratelimit.NewMemoryLimiter(1, burst))(http.HandlerFunc(GetRateLimit))
+	get := func() (*httptest.ResponseRecorder, RateLimitResponse) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/ratelimit", nil)
+		req = req.WithContext(context.WithValue(req.Context(), keys.ActorKey, "apikey:1"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var resp RateLimitResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, resp
+	}
+
+	// each request takes one of the burst, and the endpoint agrees with the headers
+	for i := 1; i <= burst; i++ {
+		w, resp := get()
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected request %d to be served got %v", i, w.Code)
+		}
+		want := strconv.Itoa(burst - i)
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != want {
+			t.Errorf("expected X-RateLimit-Remaining %s after request %d got %q", want, i, got)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != strconv.Itoa(burst) {
+			t.Errorf("expected X-RateLimit-Limit %d got %q", burst, got)
+		}
+		if reset, err := strconv.Atoi(w.Header().Get("X-RateLimit-Reset")); err != nil || reset < 1 {
+			t.Errorf("expected an X-RateLimit-Reset in seconds got %q", w.Header().Get("X-RateLimit-Reset"))
+		}
+		if !resp.Enabled || resp.Limit != burst || resp.Remaining != burst-i {
+			t.Errorf("expected %d of %d remaining after request %d got %+v", burst-i, burst, i, resp)
+		}
+	}
+	w, _ := get()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("expected a 429 with none remaining got %v %q", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	// without a limit there are no headers, and the endpoint says so
+	unlimited := RateLimit(ratelimit.NewMemoryLimiter(0, 0))(http.HandlerFunc(GetRateLimit))
+	w = httptest.NewRecorder()
+	unlimited.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/ratelimit", nil))
+	var resp RateLimitResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "" || resp.Enabled {
+		t.Errorf("expected no rate limit got header %q and %+v", w.Header().Get("X-RateLimit-Limit"), resp)
+	}
+}
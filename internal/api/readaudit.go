@@ -0,0 +1,70 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// ReadAudit records a read audit event for every successful GET of one of the
+// routes, matched by chi route pattern such as /api/v1/users/{userID}. It has
+// to run after auth so the actor is known. With no routes it does nothing.
+//
+// The event's resource is the request path, and its details hold the route
+// and its url params. Recording happens after the response is written, and
+// failures are only logged.
+func ReadAudit(db *sql.DB, routes []string) func(http.Handler) http.Handler {
+	audited := map[string]bool{}
+	for _, route := range routes {
+		audited[route] = true
+	}
+	return func(next http.Handler) http.Handler {
+		if len(audited) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			// the pattern is only complete once the request has been routed
+			rctx := chi.RouteContext(r.Context())
+			if rctx == nil || !audited[rctx.RoutePattern()] {
+				return
+			}
+			if status := ww.Status(); status != 0 && (status < 200 || status > 299) {
+				return
+			}
+			recordRead(r, db, rctx)
+		})
+	}
+}
+
+func recordRead(r *http.Request, db *sql.DB, rctx *chi.Context) {
+	params := map[string]string{}
+	for i, key := range rctx.URLParams.Keys {
+		if key != "*" {
+			params[key] = rctx.URLParams.Values[i]
+		}
+	}
+	details, err := json.Marshal(map[string]any{"route": rctx.RoutePattern(), "params": params})
+	if err == nil {
+		_, err = data.CreateAuditEvent(db, &data.AuditEventRequest{
+			Actor:        actorFromContext(r.Context()),
+			Action:       data.AuditActionRead,
+			ResourceType: "route",
+			ResourceId:   r.URL.Path,
+			Details:      details,
+		})
+	}
+	if err != nil {
+		slog.Error("failed to record read audit event", "path", r.URL.Path, "package", "api", "method", "ReadAudit", "error", err)
+	}
+}
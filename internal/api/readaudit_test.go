@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestReadAudit(t *testing.T) {
+	th := NewTestDataHandler()
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keys.ActorKey, "testreadauditor")))
+		})
+	})
+	r.Use(ReadAudit(th.DB, []string{"/api/v1/users/{userID}"}))
+	r.Route("/api/v1/users", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Get("/{userID}", func(w http.ResponseWriter, r *http.Request) {})
+		r.Delete("/{userID}", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	newEvents := func(t *testing.T, method string, path string) []*data.AuditEvent {
+		lastId, err := data.GetLastAuditEventId(th.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+		}
+		events, err := data.GetAuditEventsAfter(th.DB, lastId, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+
+	t.Run("Audited", func(t *testing.T) {
+		events := newEvents(t, "GET", "/api/v1/users/42")
+		if len(events) != 1 {
+			t.Fatalf("expected 1 audit event got %v", len(events))
+		}
+		e := events[0]
+		if e.Actor != "testreadauditor" || e.Action != data.AuditActionRead || e.ResourceId != "/api/v1/users/42" {
+			t.Errorf("unexpected audit event %+v", e)
+		}
+		var details struct {
+			Route  string            `json:"route"`
+			Params map[string]string `json:"params"`
+		}
+		if err := json.Unmarshal(e.Details, &details); err != nil {
+			t.Fatal(err)
+		}
+		if details.Route != "/api/v1/users/{userID}" || details.Params["userID"] != "42" {
+			t.Errorf("unexpected audit details %s", e.Details)
+		}
+	})
+
+	t.Run("NotAudited", func(t *testing.T) {
+		if events := newEvents(t, "GET", "/api/v1/users"); len(events) != 0 {
+			t.Errorf("expected no audit events got %v", len(events))
+		}
+	})
+
+	t.Run("NotGet", func(t *testing.T) {
+		if events := newEvents(t, "DELETE", "/api/v1/users/42"); len(events) != 0 {
+			t.Errorf("expected no audit events got %v", len(events))
+		}
+	})
+}
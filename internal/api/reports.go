@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// userReportFormats are the formats GetUserReport accepts, the first is the default
+var userReportFormats = []string{"json", "csv"}
+
+// userReportColumns is the header of the csv user report
+var userReportColumns = []string{"user_id", "username", "uid", "pirgs"}
+
+// UserReportRowResponse is a user's line in the json user report.
+// Uid is null until the user is allocated one.
+type UserReportRowResponse struct {
+	UserId   int      `json:"user_id"`
+	Username string   `json:"username"`
+	Uid      *int     `json:"uid"`
+	Pirgs    []string `json:"pirgs"`
+}
+
+func newUserReportRowResponse(row *data.UserReportRow) *UserReportRowResponse {
+	resp := &UserReportRowResponse{UserId: row.UserId, Username: row.Username, Pirgs: row.Pirgs}
+	if row.Uid != 0 {
+		resp.Uid = &row.Uid
+	}
+	if resp.Pirgs == nil {
+		resp.Pirgs = []string{}
+	}
+	return resp
+}
+
+type ReportHandler struct {
+	dbConn *sql.DB
+}
+
+func newReportHandler(ctx context.Context) *ReportHandler {
+	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	return &ReportHandler{dbConn: dbConn}
+}
+
+// GetUserReport streams a line per user with their uid and pirgs, ordered by
+// username, as a JSON array or with ?format=csv as CSV with a header row.
+// Pirgs are separated by semicolons in the CSV.
+func (h *ReportHandler) GetUserReport(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting user report", "package", "api", "method", "GetUserReport")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = userReportFormats[0]
+	}
+	if !slices.Contains(userReportFormats, format) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("unsupported report format: %s", format)))
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	count := 0
+	var err error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		w.WriteHeader(http.StatusOK)
+		cw := csv.NewWriter(w)
+		cw.Write(userReportColumns)
+		err = data.StreamUserReport(h.dbConn, func(row *data.UserReportRow) error {
+			uid := ""
+			if row.Uid != 0 {
+				uid = strconv.Itoa(row.Uid)
+			}
+			if err := cw.Write([]string{strconv.Itoa(row.UserId), row.Username, uid, strings.Join(row.Pirgs, ";")}); err != nil {
+				return err
+			}
+			count++
+			if count%streamFlushEvery == 0 {
+				cw.Flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return nil
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		w.Write([]byte("["))
+		err = data.StreamUserReport(h.dbConn, func(row *data.UserReportRow) error {
+			if count > 0 {
+				w.Write([]byte(","))
+			}
+			if err := enc.Encode(newUserReportRowResponse(row)); err != nil {
+				return err
+			}
+			count++
+			if flusher != nil && count%streamFlushEvery == 0 {
+				flusher.Flush()
+			}
+			return nil
+		})
+		w.Write([]byte("]"))
+	}
+	if err != nil {
+		// the status is already sent, so all we can do is stop and log it
+		slog.Error("failed to write user report", "package", "api", "method", "GetUserReport", "error", err)
+		return
+	}
+	slog.Debug("wrote user report", "count", count, "package", "api", "method", "GetUserReport")
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestGetUserReport(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testuserreportowner")
+	other := newTestPirgOwner(t, th, "testuserreportother")
+	_, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testuserreport",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := data.AllocatePosixId(th.DB, data.PosixIdKindUid, owner.Id, data.PosixIdRange{Min: 84000, Max: 84099})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ReportHandler{dbConn: th.DB}
+	getReport := func(t *testing.T, format string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.GetUserReport(w, httptest.NewRequest("GET", "/reports/users?format="+format, nil))
+		return w
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		w := getReport(t, "json")
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var rows []map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Fatalf("invalid json report: %v: %s", err, w.Body.String())
+		}
+		found := map[string]map[string]any{}
+		for _, row := range rows {
+			for _, column := range []string{"user_id", "username", "uid", "pirgs"} {
+				if _, ok := row[column]; !ok {
+					t.Fatalf("expected column %v in row %v", column, row)
+				}
+			}
+			found[row["username"].(string)] = row
+		}
+		if row := found["testuserreportowner"]; row == nil || row["uid"] != float64(uid) || fmt.Sprint(row["pirgs"]) != "[testuserreport]" {
+			t.Errorf("expected owner with uid %v in testuserreport got %v", uid, row)
+		}
+		if row := found["testuserreportother"]; row == nil || row["uid"] != nil || fmt.Sprint(row["pirgs"]) != "[]" {
+			t.Errorf("expected other without a uid or pirgs got %v", row)
+		}
+	})
+	t.Run("CSV", func(t *testing.T) {
+		w := getReport(t, "csv")
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+			t.Errorf("expected content type text/csv got %v", ct)
+		}
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) == 0 || !slices.Equal(records[0], []string{"user_id", "username", "uid", "pirgs"}) {
+			t.Fatalf("expected header row got %v", records)
+		}
+		expected := [][]string{
+			{fmt.Sprint(other.Id), "testuserreportother", "", ""},
+			{fmt.Sprint(owner.Id), "testuserreportowner", fmt.Sprint(uid), "testuserreport"},
+		}
+		for _, e := range expected {
+			if !slices.ContainsFunc(records[1:], func(r []string) bool { return slices.Equal(r, e) }) {
+				t.Errorf("expected row %v in report", e)
+			}
+		}
+		var usernames []string
+		for _, r := range records[1:] {
+			usernames = append(usernames, r[1])
+		}
+		if !slices.IsSorted(usernames) {
+			t.Errorf("expected rows ordered by username got %v", usernames)
+		}
+	})
+	t.Run("UnsupportedFormat", func(t *testing.T) {
+		if w := getReport(t, "xlsx"); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %v got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+}
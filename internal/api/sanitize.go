@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// SanitizeStrings checks every string in a JSON request body, object keys
+// included, for control characters and null bytes before a handler binds it.
+// The reject mode, the default, answers 422 listing each field that has one,
+// and the strip mode removes them. Bodies that aren't JSON are left for the
+// handler to refuse.
+func SanitizeStrings(mode string) func(http.Handler) http.Handler {
+	strip := mode == config.InputSanitizationStrip
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Method == http.MethodGet || r.Method == http.MethodDelete {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				render.Render(w, r, ErrBind(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			var v any
+			if err := dec.Decode(&v); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fields := []data.FieldError{}
+			v = sanitizeJSON(v, "", strip, &fields)
+			if len(fields) > 0 {
+				slices.SortFunc(fields, func(a, b data.FieldError) int { return strings.Compare(a.Field, b.Field) })
+				render.Render(w, r, ErrValidation(&data.ValidationError{Fields: fields}))
+				return
+			}
+			if strip {
+				if body, err = json.Marshal(v); err != nil {
+					render.Render(w, r, ErrInternal(err))
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sanitizeJSON returns v with control characters stripped from its strings,
+// or without strip, adds a field error for each string at path that has them
+func sanitizeJSON(v any, path string, strip bool, fields *[]data.FieldError) any {
+	switch v := v.(type) {
+	case string:
+		return sanitizeString(v, path, strip, fields)
+	case []any:
+		for i, item := range v {
+			v[i] = sanitizeJSON(item, fmt.Sprintf("%s[%d]", path, i), strip, fields)
+		}
+		return v
+	case map[string]any:
+		sanitized := make(map[string]any, len(v))
+		for k, item := range v {
+			key := sanitizeString(k, joinFieldPath(path, k), strip, fields)
+			sanitized[key] = sanitizeJSON(item, joinFieldPath(path, key), strip, fields)
+		}
+		return sanitized
+	}
+	return v
+}
+
+func sanitizeString(s string, path string, strip bool, fields *[]data.FieldError) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+	if strip {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	*fields = append(*fields, data.FieldError{Field: path, Message: "must not contain control characters or null bytes"})
+	return s
+}
+
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
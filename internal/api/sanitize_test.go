@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestSanitizeStrings(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		body   string
+		want   int
+		fields []string
+		// the body the handler reads, when it's reached
+		bound string
+	}{
+		{name: "Clean", mode: config.InputSanitizationReject, body: `{"username": "someone", "firstname": "Zoë"}`, want: http.StatusOK, bound: `{"username": "someone", "firstname": "Zoë"}`},
+		{name: "RejectNullByte", mode: config.InputSanitizationReject, body: `{"username": "some\u0000one"}`, want: http.StatusUnprocessableEntity, fields: []string{"username"}},
+		{name: "RejectControlCharacters", mode: "", body: `{"lastname": "one\u001b[31m", "firstname": "Some\nOne"}`, want: http.StatusUnprocessableEntity, fields: []string{"firstname", "lastname"}},
+		{name: "RejectNested", mode: config.InputSanitizationReject, body: `[{"username": "a"}, {"username": "b", "attributes": {"sponsor\u0007": "x"}}]`, want: http.StatusUnprocessableEntity, fields: []string{"[1].attributes.sponsor\a"}},
+		{name: "StripNullByte", mode: config.InputSanitizationStrip, body: `{"username": "some\u0000one", "owner_id": 12345678901}`, want: http.StatusOK, bound: `{"owner_id":12345678901,"username":"someone"}`},
+		{name: "StripControlCharacters", mode: config.InputSanitizationStrip, body: `[{"firstname": "\u007fSome\tOne\u0085"}]`, want: http.StatusOK, bound: `[{"firstname":"SomeOne"}]`},
+		{name: "NotJSON", mode: config.InputSanitizationReject, body: "some\x00one", want: http.StatusOK, bound: "some\x00one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bound string
+			h := SanitizeStrings(tt.mode)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				bound = string(b)
+			}))
+			req := httptest.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("expected status %v got %v: %s", tt.want, w.Code, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				var resp ErrResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				var fields []string
+				for _, f := range resp.Fields {
+					fields = append(fields, f.Field)
+				}
+				if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+					t.Errorf("expected fields %v got %v", tt.fields, fields)
+				}
+				return
+			}
+			if bound != tt.bound {
+				t.Errorf("expected the handler to read %q got %q", tt.bound, bound)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// SlurmErrorResponse is the body of a pirg delete or member removal that
+// succeeded but couldn't be provisioned in Slurm. They otherwise have none.
+type SlurmErrorResponse struct {
+	SlurmError string `json:"slurm_error"`
+}
+
+// provisionSlurm creates the pirg's Slurm account if created is set, and
+// associates the members in after but not before with it and removes the
+// ones in before but not after. It does nothing while Slurm provisioning is
+// disabled. The pirg change already succeeded, so a failure doesn't fail the
+// request, it's logged and returned to be put in the response.
+func (h *PirgHandler) provisionSlurm(ctx context.Context, pirg *data.Pirg, created bool, before []int, after []int) string {
+	if h.slurm == nil {
+		return ""
+	}
+	errs := []error{}
+	if created {
+		errs = append(errs, h.slurm.CreateAccount(ctx, pirg.Name))
+	}
+	for _, id := range after {
+		if !slices.Contains(before, id) {
+			errs = append(errs, h.provisionSlurmUser(ctx, pirg, id, h.slurm.AddUser))
+		}
+	}
+	for _, id := range before {
+		if !slices.Contains(after, id) {
+			errs = append(errs, h.provisionSlurmUser(ctx, pirg, id, h.slurm.RemoveUser))
+		}
+	}
+	return slurmError(pirg, errors.Join(errs...))
+}
+
+func (h *PirgHandler) provisionSlurmUser(ctx context.Context, pirg *data.Pirg, userId int, fn func(context.Context, string, string) error) error {
+	user, err := h.store.GetUserByIdIncludingDeleted(userId)
+	if err != nil {
+		return fmt.Errorf("failed to look up user %d: %v", userId, err)
+	}
+	return fn(ctx, pirg.Name, user.Username)
+}
+
+// deprovisionSlurm deletes the pirg's Slurm account, which removes its users'
+// associations with it. Like provisionSlurm, a failure is only logged and
+// returned for the response.
+func (h *PirgHandler) deprovisionSlurm(ctx context.Context, pirg *data.Pirg) string {
+	if h.slurm == nil {
+		return ""
+	}
+	return slurmError(pirg, h.slurm.DeleteAccount(ctx, pirg.Name))
+}
+
+func slurmError(pirg *data.Pirg, err error) string {
+	if err == nil {
+		return ""
+	}
+	slog.Error("failed to provision pirg in slurm", "pirg_id", pirg.Id, "pirg", pirg.Name, "error", err, "package", "api", "method", "provisionSlurm")
+	return err.Error()
+}
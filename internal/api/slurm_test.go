@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/slurm"
+)
+
+func TestProvisionSlurm(t *testing.T) {
+	ctx := context.Background()
+	pirg := &data.Pirg{Id: 1, Name: "testslurm"}
+
+	if msg := (&PirgHandler{}).provisionSlurm(ctx, pirg, true, nil, nil); msg != "" {
+		t.Errorf("expected nothing to be provisioned while disabled got %q", msg)
+	}
+
+	rec := &slurm.Recorder{}
+	h := &PirgHandler{slurm: rec}
+	if msg := h.provisionSlurm(ctx, pirg, true, nil, nil); msg != "" {
+		t.Fatalf("unexpected slurm error %q", msg)
+	}
+	if msg := h.deprovisionSlurm(ctx, pirg); msg != "" {
+		t.Fatalf("unexpected slurm error %q", msg)
+	}
+	calls := rec.Calls()
+	if len(calls) != 2 || calls[0] != (slurm.Call{Method: "CreateAccount", Account: "testslurm"}) || calls[1] != (slurm.Call{Method: "DeleteAccount", Account: "testslurm"}) {
+		t.Errorf("expected the account to be created and deleted got %+v", calls)
+	}
+
+	// unchanged members aren't touched
+	if msg := h.provisionSlurm(ctx, pirg, false, []int{1, 2}, []int{2, 1}); msg != "" || len(rec.Calls()) != 2 {
+		t.Errorf("expected no changes got %q %+v", msg, rec.Calls())
+	}
+
+	rec.Err = errors.New("sacctmgr add account failed")
+	if msg := h.provisionSlurm(ctx, pirg, true, nil, nil); msg != "sacctmgr add account failed" {
+		t.Errorf("expected the slurm error got %q", msg)
+	}
+	if msg := h.deprovisionSlurm(ctx, pirg); msg != "sacctmgr add account failed" {
+		t.Errorf("expected the slurm error got %q", msg)
+	}
+}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// storeFromContext returns the data.Store in ctx, or if there isn't one, a
+// PostgresStore on the connection in ctx. Handler tests set a MemoryStore
+// and leave the connection out.
+func storeFromContext(ctx context.Context) data.Store {
+	if store, ok := ctx.Value(keys.StoreKey).(data.Store); ok {
+		return store
+	}
+	return data.NewPostgresStore(ctx.Value(keys.DBConnKey).(*sql.DB))
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// TestMemoryStoreHandlers runs the user and pirg routers against a
+// data.MemoryStore, without a database
+func TestMemoryStoreHandlers(t *testing.T) {
+	store := data.NewMemoryStore()
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, &config.ServerConfig{})
+	ctx = context.WithValue(ctx, keys.StoreKey, store)
+	users := UsersRouter(ctx)
+	pirgs := PirgsRouter(ctx)
+	// doAs makes the request as a caller with role, who is the user with
+	// userId if it isn't 0
+	doAs := func(role string, userId int, router http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		reqCtx := req.Context()
+		if role != "" {
+			reqCtx = context.WithValue(reqCtx, keys.RoleKey, role)
+		}
+		if userId != 0 {
+			reqCtx = context.WithValue(reqCtx, keys.AuthUserIdKey, userId)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req.WithContext(reqCtx))
+		return w
+	}
+	do := func(router http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		return doAs("", 0, router, method, target, body)
+	}
+
+	w := do(users, "POST", "/", `{"username": "testmemstore", "email": "testmemstore@localhost", "firstname": "Test", "lastname": "Store"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var user UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(users, "POST", "/", `{"username": "testmemstore", "email": "other@localhost", "firstname": "Test", "lastname": "Store"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a taken username to be refused got %v", w.Code)
+	}
+	if w := do(users, "GET", fmt.Sprintf("/%d", user.Id), ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "testmemstore") {
+		t.Errorf("expected to get the user got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "GET", "/?username=testmemstore", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "testmemstore") {
+		t.Errorf("expected to find the user by username got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "GET", "/", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "testmemstore") {
+		t.Errorf("expected the user to be listed got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "GET", "/999", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing user got %v", w.Code)
+	}
+
+	w = do(pirgs, "POST", "/", fmt.Sprintf(`{"name": "testmemstore", "owner_id": %d, "admin_ids": [%[1]d], "user_ids": [%[1]d]}`, user.Id))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var pirg PirgResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &pirg); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(pirgs, "GET", fmt.Sprintf("/%d", pirg.Id), ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "testmemstore") {
+		t.Errorf("expected to get the pirg got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(pirgs, "GET", "/?name=testmemstore", ""); w.Code != http.StatusOK {
+		t.Errorf("expected to find the pirg by name got %v %s", w.Code, w.Body.String())
+	}
+
+	w = do(users, "POST", "/", `{"username": "testmemmember", "email": "testmemmember@localhost", "firstname": "Test", "lastname": "Member"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var member UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &member); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(users, "PUT", fmt.Sprintf("/%d", member.Id), `{"firstname": "Updated", "version": 1}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Updated") {
+		t.Errorf("expected the user to be updated got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "PUT", fmt.Sprintf("/%d", member.Id), `{"firstname": "Stale", "version": 1}`); w.Code != http.StatusConflict {
+		t.Errorf("expected a stale version to conflict got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "PATCH", fmt.Sprintf("/%d", member.Id), `{"lastname": "Patched"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Patched") {
+		t.Errorf("expected the user to be patched got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "PATCH", fmt.Sprintf("/%d", member.Id), `{"email": "testmemstore@localhost"}`); w.Code == http.StatusOK {
+		t.Errorf("expected a taken email to be refused got %v", w.Code)
+	}
+
+	memberPath := fmt.Sprintf("/%d/members", pirg.Id)
+	adminPath := fmt.Sprintf("%s/%d/admin", memberPath, member.Id)
+	if w := doAs("user", member.Id, pirgs, "POST", memberPath, fmt.Sprintf(`{"user_id": %d}`, member.Id)); w.Code != http.StatusForbidden {
+		t.Errorf("expected a non member to be forbidden from adding members got %v", w.Code)
+	}
+	if w := doAs("admin", 0, pirgs, "POST", memberPath, fmt.Sprintf(`{"user_id": %d}`, member.Id)); w.Code != http.StatusCreated {
+		t.Errorf("expected the member to be added got %v %s", w.Code, w.Body.String())
+	}
+	if w := doAs("user", member.Id, pirgs, "PUT", adminPath, ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected a plain member to be forbidden from adding admins got %v", w.Code)
+	}
+	if w := doAs("user", user.Id, pirgs, "PUT", adminPath, ""); w.Code != http.StatusCreated {
+		t.Errorf("expected a pirg admin to add an admin got %v %s", w.Code, w.Body.String())
+	}
+	if w := doAs("user", member.Id, pirgs, "DELETE", adminPath, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected the new pirg admin to remove their admin got %v %s", w.Code, w.Body.String())
+	}
+	if w := doAs("user", user.Id, pirgs, "DELETE", fmt.Sprintf("%s/%d/admin", memberPath, user.Id), ""); w.Code != http.StatusConflict {
+		t.Errorf("expected removing the last pirg admin to conflict got %v %s", w.Code, w.Body.String())
+	}
+	if w := doAs("user", user.Id, pirgs, "DELETE", fmt.Sprintf("%s/%d", memberPath, member.Id), ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected the member to be removed got %v %s", w.Code, w.Body.String())
+	}
+	if got, _ := store.GetPirgById(pirg.Id); len(got.UserIds) != 1 || len(got.AdminIds) != 1 {
+		t.Errorf("expected only the owner left in the pirg got %+v", got)
+	}
+
+	upsert := fmt.Sprintf(`{"owner_id": %d, "admin_ids": [%[1]d], "user_ids": [%[1]d, %d]}`, user.Id, member.Id)
+	if w := doAs("user", member.Id, pirgs, "PUT", "/by-name/testmemstore", upsert); w.Code != http.StatusForbidden {
+		t.Errorf("expected a non admin to be forbidden from upserting an existing pirg got %v", w.Code)
+	}
+	if w := doAs("user", user.Id, pirgs, "PUT", "/by-name/testmemstore", upsert); w.Code != http.StatusOK {
+		t.Errorf("expected a pirg admin to upsert their pirg got %v %s", w.Code, w.Body.String())
+	}
+	if got, _ := store.GetPirgById(pirg.Id); len(got.UserIds) != 2 {
+		t.Errorf("expected the upsert to add the member got %+v", got)
+	}
+	if w := doAs("user", member.Id, pirgs, "PUT", "/by-name/testmemstorenew", fmt.Sprintf(`{"owner_id": %d, "admin_ids": [%[1]d], "user_ids": [%[1]d]}`, user.Id)); w.Code != http.StatusCreated {
+		t.Errorf("expected upserting a new pirg to create it got %v %s", w.Code, w.Body.String())
+	}
+	if w := doAs("user", user.Id, pirgs, "DELETE", fmt.Sprintf("%s/%d", memberPath, member.Id), ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected the member to be removed got %v %s", w.Code, w.Body.String())
+	}
+
+	if w := do(users, "DELETE", fmt.Sprintf("/%d", user.Id), ""); w.Code != http.StatusConflict {
+		t.Errorf("expected deleting a pirg owner to conflict got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "DELETE", fmt.Sprintf("/%d", member.Id), ""); w.Code != http.StatusOK {
+		t.Errorf("expected the user to be deleted got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "GET", fmt.Sprintf("/%d", member.Id), ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected a deleted user to be 404 got %v", w.Code)
+	}
+	if w := doAs("user", user.Id, users, "POST", fmt.Sprintf("/%d/restore", member.Id), ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected restoring to be 404 for a non admin got %v", w.Code)
+	}
+	if w := doAs("admin", 0, users, "POST", fmt.Sprintf("/%d/restore", member.Id), ""); w.Code != http.StatusOK {
+		t.Errorf("expected the user to be restored got %v %s", w.Code, w.Body.String())
+	}
+	if w := do(users, "GET", fmt.Sprintf("/%d", member.Id), ""); w.Code != http.StatusOK {
+		t.Errorf("expected a restored user to be found got %v", w.Code)
+	}
+
+	actions := []string{}
+	for _, e := range store.AuditEvents() {
+		actions = append(actions, e.ResourceType+" "+e.Action)
+	}
+	if want := "user created,pirg created,pirg member_added"; !strings.HasPrefix(strings.Join(actions, ","), want) || !slices.Contains(actions, "user restored") {
+		t.Errorf("expected audit events %v got %v", want, actions)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/webhook"
+)
+
+// UserProvisionEvent is the data of the user.provision webhook event, what's
+// needed to create a new user's home directory
+type UserProvisionEvent struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+	Uid      int    `json:"uid"`
+	Gid      int    `json:"gid"`
+}
+
+// uidProvisioner allocates new users their uid as they're created, see
+// config.PosixIdConfig. It's nil while allocate_uid_on_create is off, and
+// does nothing then.
+type uidProvisioner struct {
+	dbConn     *sql.DB
+	uids       data.PosixIdRange
+	defaultGid int
+	webhooks   *webhook.Dispatcher
+}
+
+func newUidProvisioner(ctx context.Context) *uidProvisioner {
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	if !cfg.PosixIds.AllocateUidOnCreate {
+		return nil
+	}
+	dbConn, _ := ctx.Value(keys.DBConnKey).(*sql.DB)
+	webhooks, _ := ctx.Value(keys.WebhooksKey).(*webhook.Dispatcher)
+	return &uidProvisioner{
+		dbConn:     dbConn,
+		uids:       data.PosixIdRange{Min: cfg.PosixIds.UidMin, Max: cfg.PosixIds.UidMax},
+		defaultGid: cfg.PosixIds.DefaultGid,
+		webhooks:   webhooks,
+	}
+}
+
+// provision allocates the uid of the user just created and sends the
+// user.provision event with it, once per user since it's only called on
+// creation. The user already exists, so a failure is only logged, and the
+// uid is allocated when a pirg of theirs is provisioned instead.
+func (p *uidProvisioner) provision(u *data.User) {
+	if p == nil {
+		return
+	}
+	uid, err := data.AllocatePosixId(p.dbConn, data.PosixIdKindUid, u.Id, p.uids)
+	if err != nil {
+		slog.Error("failed to allocate uid of new user", "user_id", u.Id, "username", u.Username, "error", err, "package", "api", "method", "provision")
+		return
+	}
+	slog.Debug("allocated uid of new user", "user_id", u.Id, "uid", uid, "package", "api", "method", "provision")
+	p.webhooks.Enqueue(webhook.EventUserProvision, &UserProvisionEvent{
+		UserId:   u.Id,
+		Username: u.Username,
+		Uid:      uid,
+		Gid:      p.defaultGid,
+	})
+}
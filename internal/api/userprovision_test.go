@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/webhook"
+)
+
+func TestAPICreateUserProvisionEvent(t *testing.T) {
+	th := NewTestDataHandler()
+	var mu sync.Mutex
+	var events []json.RawMessage
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&e)
+		switch e.Type {
+		case webhook.EventUserProvision:
+			mu.Lock()
+			events = append(events, e.Data)
+			mu.Unlock()
+		case "test.done":
+			close(done)
+		}
+	}))
+	defer srv.Close()
+	webhooks := webhook.New(config.WebhookConfig{URLs: []string{srv.URL}, Secret: "secret"}, srv.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhooks.Run(ctx)
+
+	h := &UserHandler{
+		dbConn:         th.DB,
+		store:          data.NewPostgresStore(th.DB),
+		webhooks:       webhooks,
+		uidProvisioner: &uidProvisioner{dbConn: th.DB, uids: data.PosixIdRange{Min: 86000, Max: 86999}, defaultGid: 100, webhooks: webhooks},
+	}
+	body := `{"username": "testapiprovisionevent", "email": "testapiprovisionevent@localhost", "firstname": "Test", "lastname": "Provision"}`
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.CreateUser(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	// events are delivered in order, so everything before this one has been
+	webhooks.Enqueue("test.done", nil)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook events")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("expected exactly one user.provision event got %d", len(events))
+	}
+	var event UserProvisionEvent
+	if err := json.Unmarshal(events[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Username != "testapiprovisionevent" || event.Gid != 100 || event.Uid < 86000 || event.Uid > 86999 {
+		t.Errorf("expected the new user with a uid in range and gid 100 got %+v", event)
+	}
+	u, err := data.GetUserByUid(th.DB, event.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Id != event.UserId {
+		t.Errorf("expected uid %d to be allocated to user %d got user %d", event.Uid, event.UserId, u.Id)
+	}
+}
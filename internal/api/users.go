@@ -3,16 +3,23 @@ package api
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/webhook"
 )
 
 type UserResponse struct {
@@ -23,6 +30,12 @@ type UserResponse struct {
 	LastName   string    `json:"lastname"`
 	CreatedAt  time.Time `json:"created_at"`
 	ModifiedAt time.Time `json:"modified_at"`
+	// DeletedAt is only set on deleted users, which are only returned with ?include_deleted=true
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// LastLoginAt is null for users who've never logged in
+	LastLoginAt *time.Time `json:"last_login_at"`
+	// Version is sent back with updates that shouldn't overwrite others', see expectedVersion
+	Version int `json:"version"`
 }
 
 func (u *UserResponse) Bind(r *http.Request) error {
@@ -35,14 +48,25 @@ func (u *UserResponse) Render(w http.ResponseWriter, r *http.Request) error {
 
 func newUserResponse(u *data.User) *UserResponse {
 	return &UserResponse{
-		Id:        u.Id,
-		Username:  u.Username,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Email:     u.Email,
+		Id:          u.Id,
+		Username:    u.Username,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Email:       u.Email,
+		DeletedAt:   u.DeletedAt,
+		LastLoginAt: u.LastLoginAt,
+		Version:     u.Version,
 	}
 }
 
+// newUserRecordResponse is newUserResponse with created_at and modified_at
+// set too, for the responses carrying the whole user record
+func newUserRecordResponse(u *data.User) *UserResponse {
+	resp := newUserResponse(u)
+	resp.CreatedAt, resp.ModifiedAt = u.CreatedAt, u.ModifiedAt
+	return resp
+}
+
 // newUserResponseList converts a list of UserResponse objects into a list of render.Renderer objects
 func newUserResponseList(users []*data.User) []render.Renderer {
 	list := []render.Renderer{}
@@ -60,11 +84,8 @@ type UserRequest struct {
 }
 
 func (u *UserRequest) Bind(r *http.Request) error {
-	if u.Username == "" || u.Email == "" || u.FirstName == "" || u.LastName == "" {
-		return fmt.Errorf("missing required User fields: %+v", u)
-	}
-	// add in more checks like alphanumeric, length, etc.
-	return nil
+	u.Username = data.NormalizeUsername(u.Username)
+	return data.ValidateUser((*data.UserRequest)(u))
 }
 
 func newUserRequest(u *data.User) *UserRequest {
@@ -76,8 +97,242 @@ func newUserRequest(u *data.User) *UserRequest {
 	}
 }
 
+// UserUpdateRequest is the UserRequest of an update, with the version of
+// the User it's based on, see expectedVersion
+type UserUpdateRequest struct {
+	UserRequest
+	Version int `json:"version"`
+}
+
+func (u *UserUpdateRequest) Bind(r *http.Request) error {
+	return u.UserRequest.Bind(r)
+}
+
+// UserPatchRequest is the fields of a User to change, by their json name.
+// id can't be changed and is ignored, and version is the version of the User
+// the patch is based on, see expectedVersion.
+type UserPatchRequest map[string]any
+
+func (u *UserPatchRequest) Bind(r *http.Request) error {
+	delete(*u, "id")
+	if username, ok := (*u)["username"].(string); ok {
+		(*u)["username"] = data.NormalizeUsername(username)
+	}
+	fields := maps.Clone(*u)
+	if v, ok := fields["version"]; ok {
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return &data.ValidationError{Fields: []data.FieldError{{Field: "version", Message: "must be an integer"}}}
+		}
+		delete(fields, "version")
+	}
+	if unknown := data.UnknownUserFields(fields); len(unknown) > 0 {
+		return fmt.Errorf("unknown User fields: %s", strings.Join(unknown, ", "))
+	}
+	return data.ValidateUserFields(fields)
+}
+
+// version removes the version from the patch and returns it, or 0 if it
+// has none
+func (u UserPatchRequest) version() int {
+	v, _ := u["version"].(float64)
+	delete(u, "version")
+	return int(v)
+}
+
+type UserFilterRequest struct {
+	Username   string            `json:"username"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// UserAttributesBulkRequest sets Attributes on every user matching Filter
+type UserAttributesBulkRequest struct {
+	Filter     UserFilterRequest `json:"filter"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func (u *UserAttributesBulkRequest) Bind(r *http.Request) error {
+	if u.Filter.Username == "" && len(u.Filter.Attributes) == 0 {
+		return data.ErrEmptyUserFilter
+	}
+	if len(u.Attributes) == 0 {
+		return fmt.Errorf("missing required attributes")
+	}
+	for k := range u.Attributes {
+		if k == "" {
+			return fmt.Errorf("attribute keys must not be empty")
+		}
+	}
+	return nil
+}
+
+type UserAttributesBulkResponse struct {
+	Affected int   `json:"affected"`
+	UserIds  []int `json:"user_ids"`
+	DryRun   bool  `json:"dry_run"`
+}
+
+func (u *UserAttributesBulkResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// maxBulkUsers is the most users POST /users/bulk creates at once
+const maxBulkUsers = 1000
+
+// UserBulkRequest is a batch of users to create together
+type UserBulkRequest []*UserRequest
+
+// Bind only checks the size of the batch. The users are validated when
+// they're created, so every invalid one can be reported at once.
+func (u *UserBulkRequest) Bind(r *http.Request) error {
+	if len(*u) == 0 {
+		return fmt.Errorf("missing users to create")
+	}
+	if len(*u) > maxBulkUsers {
+		return fmt.Errorf("at most %d users can be created at once: %d", maxBulkUsers, len(*u))
+	}
+	for i, user := range *u {
+		if user == nil {
+			return fmt.Errorf("user %d is null", i)
+		}
+	}
+	return nil
+}
+
+// UserBulkResult is what happened to one user of a batch that wasn't created.
+// Status, Error and Message are the same as the error response creating
+// just that user would have had. Users that were fine have Status 424,
+// they weren't created because others in the batch failed.
+type UserBulkResult struct {
+	Index   int               `json:"index"`
+	Status  int               `json:"status"`
+	Error   string            `json:"error"`
+	Message string            `json:"message"`
+	Fields  []data.FieldError `json:"fields,omitempty"`
+}
+
+// UserBulkResponse has a result for every user of a batch that failed
+type UserBulkResponse struct {
+	Results []*UserBulkResult `json:"results"`
+}
+
+func (u *UserBulkResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newUserBulkResponse(count int, bulkErr *data.BulkUserError) *UserBulkResponse {
+	resp := &UserBulkResponse{Results: make([]*UserBulkResult, 0, count)}
+	for i := 0; i < count; i++ {
+		result := &UserBulkResult{
+			Index:   i,
+			Status:  http.StatusFailedDependency,
+			Error:   "not_created",
+			Message: "not created because other users in the batch failed",
+		}
+		if err := bulkErr.Errors[i]; err != nil {
+			e := ErrInvalidRequest(err).(*ErrResponse)
+			var invalid *data.ValidationError
+			if errors.As(err, &invalid) {
+				e = ErrValidation(invalid).(*ErrResponse)
+			} else if errors.Is(err, data.ErrUserExists) {
+				e = ErrConflict(err).(*ErrResponse)
+			}
+			result.Status = e.HTTPStatusCode
+			result.Error = e.Code
+			result.Message = e.Message
+			result.Fields = e.Fields
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp
+}
+
+type PirgRefResponse struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func newPirgRefResponseList(refs []*data.PirgRef) []*PirgRefResponse {
+	list := []*PirgRefResponse{}
+	for _, ref := range refs {
+		list = append(list, &PirgRefResponse{Id: ref.Id, Name: ref.Name})
+	}
+	return list
+}
+
+type UserDeleteImpactResponse struct {
+	UserId                    int                `json:"user_id"`
+	OwnedPirgs                []*PirgRefResponse `json:"owned_pirgs"`
+	AdminPirgs                []*PirgRefResponse `json:"admin_pirgs"`
+	MemberPirgs               []*PirgRefResponse `json:"member_pirgs"`
+	APIKeyCount               int                `json:"api_key_count"`
+	RequiresOwnershipTransfer bool               `json:"requires_ownership_transfer"`
+}
+
+func (u *UserDeleteImpactResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newUserDeleteImpactResponse(i *data.UserDeleteImpact) *UserDeleteImpactResponse {
+	return &UserDeleteImpactResponse{
+		UserId:                    i.UserId,
+		OwnedPirgs:                newPirgRefResponseList(i.OwnedPirgs),
+		AdminPirgs:                newPirgRefResponseList(i.AdminPirgs),
+		MemberPirgs:               newPirgRefResponseList(i.MemberPirgs),
+		APIKeyCount:               i.APIKeyCount,
+		RequiresOwnershipTransfer: i.RequiresOwnershipTransfer,
+	}
+}
+
+// RecomputeDerivedResponse lists the users whose stored values don't match
+// the current normalization. Changed is how many were, or on a dry run would
+// be, updated. Conflicts would collide with another user and aren't changed.
+type RecomputeDerivedResponse struct {
+	Changed   int                        `json:"changed"`
+	Emails    []*UserEmailChangeResponse `json:"emails"`
+	Conflicts []*UserEmailChangeResponse `json:"conflicts"`
+	DryRun    bool                       `json:"dry_run"`
+}
+
+type UserEmailChangeResponse struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+func (u *RecomputeDerivedResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newUserEmailChangeResponseList(changes []*data.UserEmailChange) []*UserEmailChangeResponse {
+	list := []*UserEmailChangeResponse{}
+	for _, c := range changes {
+		list = append(list, &UserEmailChangeResponse{UserId: c.UserId, Username: c.Username, From: c.From, To: c.To})
+	}
+	return list
+}
+
+func newRecomputeDerivedResponse(res *data.UserEmailNormalizeResult) *RecomputeDerivedResponse {
+	return &RecomputeDerivedResponse{
+		Changed:   len(res.Changed),
+		Emails:    newUserEmailChangeResponseList(res.Changed),
+		Conflicts: newUserEmailChangeResponseList(res.Conflicts),
+		DryRun:    res.DryRun,
+	}
+}
+
 type UserHandler struct {
-	dbConn *sql.DB
+	dbConn             *sql.DB
+	store              data.Store
+	defaultPirg        string
+	stripEmailPlusTags bool
+	orphanedPirgOwner  string
+	pages              pageLimits
+	lists              listRenderer
+	mask               fieldMask
+	webhooks           *webhook.Dispatcher
+	pirgNotifier       *pirgNotifier
+	uidProvisioner     *uidProvisioner
 }
 
 func UsersRouter(ctx context.Context) http.Handler {
@@ -85,54 +340,230 @@ func UsersRouter(ctx context.Context) http.Handler {
 	h := newUserHandler(ctx)
 	r.Get("/", h.GetAllUsers)
 	r.Post("/", h.CreateUser)
+	r.Post("/bulk", h.CreateUsersBulk)
+	r.Post("/attributes/bulk", h.SetUserAttributesBulk)
+	r.Get("/by-uid/{uid}", h.GetUserByUid)
+	r.Get("/by-username/{username}", h.GetUserByUsername)
 	r.Route("/{userID}", func(r chi.Router) {
-		r.Use(h.UserCtx)
-		r.Get("/", h.GetUser)
-		r.Put("/", h.UpdateUser)
-		r.Delete("/", h.DeleteUser)
+		r.With(h.DeletedUserCtx).Post("/restore", h.RestoreUser)
+		r.Group(func(r chi.Router) {
+			r.Use(h.UserCtx)
+			r.Get("/", h.GetUser)
+			r.Put("/", h.UpdateUser)
+			r.Patch("/", h.PatchUser)
+			r.Delete("/", h.DeleteUser)
+			r.Post("/login", h.TouchUserLogin)
+			r.Post("/verify-email", h.VerifyUserEmail)
+			r.Get("/delete-impact", h.GetUserDeleteImpact)
+			r.Get("/owned-pirgs", h.GetUserOwnedPirgs)
+		})
 	})
 	return r
 }
 
+// MeRouter serves the User record of whoever is making the request
+func MeRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newUserHandler(ctx)
+	r.Get("/", h.GetMe)
+	return r
+}
+
 func newUserHandler(ctx context.Context) *UserHandler {
-	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
-	return &UserHandler{dbConn: dbConn}
+	dbConn, _ := ctx.Value(keys.DBConnKey).(*sql.DB)
+	cfg := ctx.Value(keys.ConfigKey).(*config.ServerConfig)
+	webhooks, _ := ctx.Value(keys.WebhooksKey).(*webhook.Dispatcher)
+	return &UserHandler{
+		dbConn:             dbConn,
+		store:              storeFromContext(ctx),
+		defaultPirg:        cfg.DefaultPirg,
+		stripEmailPlusTags: cfg.StripEmailPlusTags,
+		orphanedPirgOwner:  cfg.OrphanedPirgOwner,
+		pages:              newPageLimits(cfg.Pagination, cfg.ListEnvelope),
+		lists:              newListRenderer(cfg.StreamListThreshold),
+		mask:               newFieldMask(cfg.MaskedUserFields),
+		webhooks:           webhooks,
+		pirgNotifier:       newPirgNotifier(ctx),
+		uidProvisioner:     newUidProvisioner(ctx),
+	}
 }
 
-// GetAllUsers returns all existing users
+// userAttributeParamPrefix marks query params that filter on user attributes,
+// e.g. ?attribute.department=physics
+const userAttributeParamPrefix = "attribute."
+
+// includeDeleted reports whether deleted users should be included in the
+// response, which only admins can ask for with ?include_deleted=true
+func includeDeleted(r *http.Request) bool {
+	role, _ := r.Context().Value(keys.RoleKey).(string)
+	return role == "admin" && r.URL.Query().Get("include_deleted") == "true"
+}
+
+// GetAllUsers returns all existing users. The username and attribute.* filters
+// can match any of several values, repeated or comma separated, see queryValues.
+// Deleted users are left out unless an admin asks for them, see includeDeleted.
+// Asking for CSV exports every user instead, see exportUsersCSV.
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	searchUsername := r.URL.Query().Get("username")
+	csv, err := wantsUserCSV(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if csv {
+		h.exportUsersCSV(w, r)
+		return
+	}
+	searchUsernames := queryValues(r.URL.Query(), "username")
+	// email query parameter looks up a specific user by their normalized email
+	if searchEmail := r.URL.Query().Get("email"); searchEmail != "" {
+		slog.Debug("getting user by email", "package", "api", "method", "GetAllUsers")
+		user, err := h.store.GetUserByEmail(data.NormalizeEmail(searchEmail, h.stripEmailPlusTags))
+		if err != nil {
+			render.Render(w, r, ErrLookup(err))
+			return
+		}
+		if err := render.Render(w, r, h.mask.user(r, newUserResponse(user))); err != nil {
+			render.Render(w, r, ErrRender(err))
+		}
+		return
+	}
+	attributes := map[string][]string{}
+	for param := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(param, userAttributeParamPrefix); ok && key != "" {
+			if values := queryValues(r.URL.Query(), param); len(values) > 0 {
+				attributes[key] = values
+			}
+		}
+	}
+	// searching, filtering by pirg membership or last login, or sorting
+	// returns a page, combined with the username and attribute filters
+	if q := r.URL.Query(); q.Has("q") || q.Has("has_pirg") || q.Has("inactive_since") || q.Has("sort") || q.Has("order") {
+		h.searchUsers(w, r, data.UserFilter{Usernames: searchUsernames, AttributesIn: attributes, IncludeDeleted: includeDeleted(r)})
+		return
+	}
+	// attribute filters and several usernames always return a list,
+	// combined with any other filters
+	if len(attributes) > 0 || len(searchUsernames) > 1 {
+		slog.Debug("finding users by filter", "package", "api", "method", "GetAllUsers")
+		users, err := data.FindUsers(h.dbConn, data.UserFilter{Usernames: searchUsernames, AttributesIn: attributes, IncludeDeleted: includeDeleted(r)})
+		if err != nil {
+			render.Render(w, r, ErrInternal(err))
+			return
+		}
+		h.lists.render(w, r, h.mask.users(r, newUserResponseList(users)))
+		return
+	}
 	// username query parameter exists, so we are looking for a specific user
 	// TODO(lcrown): why are both arms of this if statement running???
-	if searchUsername != "" {
+	if len(searchUsernames) == 1 {
 		slog.Debug("getting user by username", "package", "api", "method", "GetAllUsers")
-		user, err := data.GetUserByUsername(h.dbConn, searchUsername)
+		user, err := h.store.GetUserByUsername(searchUsernames[0])
 		if err != nil {
-			render.Render(w, r, ErrNotFound)
+			render.Render(w, r, ErrLookup(err))
 			return
 		}
-		resp := newUserResponse(user)
+		resp := h.mask.user(r, newUserResponse(user))
 		if err := render.Render(w, r, resp); err != nil {
 			render.Render(w, r, ErrRender(err))
 			return
 		}
+	} else if wantsCursor(r) {
+		slog.Debug("getting page of users", "package", "api", "method", "GetAllUsers")
+		h.getUsersPage(w, r)
 	} else {
 		// username query parameter doesn't exist, so we are looking for all users
 		slog.Debug("getting all users", "package", "api", "method", "GetAllUsers")
 		var users []*data.User
 
-		users, err := data.GetAllUsers(h.dbConn)
+		users, err := h.store.GetAllUsers(includeDeleted(r))
 		if err != nil {
-			render.Render(w, r, ErrInternalServer(err))
+			render.Render(w, r, ErrInternal(err))
 			return
 		}
 
-		resp := newUserResponseList(users)
-		if err := render.RenderList(w, r, resp); err != nil {
-			render.Render(w, r, ErrRender(err))
+		h.lists.render(w, r, h.mask.users(r, newUserResponseList(users)))
+	}
+}
+
+// searchUsers returns a page of the users matching filter and the q,
+// has_pirg and inactive_since params, sorted by the sort param, username by default, in the
+// order of the order param, asc by default
+func (h *UserHandler) searchUsers(w http.ResponseWriter, r *http.Request, filter data.UserFilter) {
+	slog.Debug("searching users", "package", "api", "method", "searchUsers")
+	q := r.URL.Query()
+	filter.Query = strings.TrimSpace(q.Get("q"))
+	if v := q.Get("has_pirg"); v != "" {
+		hasPirg, err := strconv.ParseBool(v)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("has_pirg must be true or false: %s", v)))
+			return
+		}
+		filter.HasPirg = &hasPirg
+	}
+	if v := q.Get("inactive_since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("inactive_since must be an RFC3339 timestamp: %s", v)))
 			return
 		}
+		filter.InactiveSince = &since
+	}
+	sortBy := "username"
+	if v := q.Get("sort"); v != "" {
+		if !slices.Contains(data.UserSortFields, v) {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("sort must be one of %s: %s", strings.Join(data.UserSortFields, ", "), v)))
+			return
+		}
+		sortBy = v
+	}
+	desc := false
+	switch v := q.Get("order"); v {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("order must be asc or desc: %s", v)))
+		return
+	}
+	limit, offset, err := h.pages.parse(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	users, total, err := data.FindUsersPage(h.dbConn, filter, sortBy, desc, limit, offset)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := &PageResponse{
+		Items:  h.mask.users(r, newUserResponseList(users)),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	h.pages.render(w, r, resp)
+}
+
+// getUsersPage returns the page of users after the cursor, ordered by id.
+// One more user than the limit is read to know whether there's a next page.
+func (h *UserHandler) getUsersPage(w http.ResponseWriter, r *http.Request) {
+	limit, afterId, err := h.pages.parseCursor(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	users, err := data.GetUsersAfter(h.dbConn, afterId, limit+1, includeDeleted(r))
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := &CursorPageResponse{Limit: limit}
+	if len(users) > limit {
+		users = users[:limit]
+		resp.NextCursor = encodeCursor(users[limit-1].Id)
 	}
+	resp.Items = h.mask.users(r, newUserResponseList(users))
+	h.pages.renderCursor(w, r, resp)
 }
 
 // CreateUser creates a new user
@@ -140,27 +571,156 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("creating new user", "package", "api", "method", "CreateUser")
 	userReq := &UserRequest{}
 	if err := render.Bind(r, userReq); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+		render.Render(w, r, ErrBind(err))
 		return
 	}
+	userReq.Email = data.NormalizeEmail(userReq.Email, h.stripEmailPlusTags)
 
 	dataUser := data.UserRequest(*userReq)
 
-	newUser, err := data.CreateUser(h.dbConn, &dataUser)
+	var newUser *data.User
+	var err error
+	if h.defaultPirg != "" {
+		newUser, err = h.store.CreateUserInPirg(&dataUser, h.defaultPirg)
+	} else {
+		newUser, err = h.store.CreateUser(&dataUser)
+	}
+	if errors.Is(err, data.ErrDeletedUserExists) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	if h.defaultPirg != "" {
+		if pirg, err := h.store.GetPirgByName(h.defaultPirg); err == nil {
+			recordPirgMembershipChanges(r.Context(), h.store, pirg.Id, nil, []int{newUser.Id})
+			h.pirgNotifier.membershipChanged(r.Context(), pirg.Id, nil, []int{newUser.Id})
+		}
+	}
+
+	recordChange(r.Context(), h.store, "user", newUser.Id, data.AuditActionCreated)
 
 	resp := newUserResponse(newUser)
+	h.webhooks.Enqueue(webhook.EventUserCreated, resp)
+	h.uidProvisioner.provision(newUser)
 	render.Status(r, http.StatusCreated)
-	render.Render(w, r, resp)
+	render.Render(w, r, h.mask.user(r, resp))
+}
+
+// CreateUsersBulk creates every user in the request body in one transaction,
+// up to maxBulkUsers of them. If all of them can be created they're returned
+// with a 201. Otherwise none are, and a 207 has the result of each user,
+// saying which ones failed and why. With ?dry_run=true nothing is created,
+// and the usernames that would be are returned.
+func (h *UserHandler) CreateUsersBulk(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("creating users in bulk", "package", "api", "method", "CreateUsersBulk")
+	req := UserBulkRequest{}
+	if err := render.Bind(r, &req); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	users := make([]*data.UserRequest, 0, len(req))
+	for _, userReq := range req {
+		userReq.Username = data.NormalizeUsername(userReq.Username)
+		userReq.Email = data.NormalizeEmail(userReq.Email, h.stripEmailPlusTags)
+		users = append(users, (*data.UserRequest)(userReq))
+	}
+	dryRun := dryRunRequested(r)
+	newUsers, err := data.CreateUsers(h.dbConn, users, h.defaultPirg, dryRun)
+	var bulkErr *data.BulkUserError
+	if errors.As(err, &bulkErr) {
+		render.Status(r, http.StatusMultiStatus)
+		render.Render(w, r, newUserBulkResponse(len(users), bulkErr))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if dryRun {
+		resp := &DryRunResponse{Changes: []*DryRunChange{userDryRunChange(data.AuditActionCreated, newUsers)}, DryRun: true}
+		if err := render.Render(w, r, resp); err != nil {
+			render.Render(w, r, ErrRender(err))
+		}
+		return
+	}
+	newUserIds := make([]int, 0, len(newUsers))
+	for _, newUser := range newUsers {
+		recordChange(r.Context(), h.store, "user", newUser.Id, data.AuditActionCreated)
+		h.webhooks.Enqueue(webhook.EventUserCreated, newUserResponse(newUser))
+		h.uidProvisioner.provision(newUser)
+		newUserIds = append(newUserIds, newUser.Id)
+	}
+	if h.defaultPirg != "" {
+		if pirg, err := data.GetPirgByName(h.dbConn, h.defaultPirg); err == nil {
+			recordPirgMembershipChanges(r.Context(), h.store, pirg.Id, nil, newUserIds)
+			h.pirgNotifier.membershipChanged(r.Context(), pirg.Id, nil, newUserIds)
+		}
+	}
+	slog.Info("created users in bulk", "created", len(newUsers), "actor", actorFromContext(r.Context()), "package", "api", "method", "CreateUsersBulk")
+	render.Status(r, http.StatusCreated)
+	if err := render.RenderList(w, r, h.mask.users(r, newUserResponseList(newUsers))); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// SetUserAttributesBulk sets attributes on every user matching the filter in
+// one transaction, and returns the users matched. The filter takes the same
+// conditions as the GET /users query params and can't be empty. With
+// ?dry_run=true the users are returned but their attributes aren't set.
+func (h *UserHandler) SetUserAttributesBulk(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("setting user attributes in bulk", "package", "api", "method", "SetUserAttributesBulk")
+	req := &UserAttributesBulkRequest{}
+	if err := render.Bind(r, req); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	filter := data.UserFilter{Username: req.Filter.Username, Attributes: req.Filter.Attributes}
+	dryRun := dryRunRequested(r)
+	userIds, err := data.SetUserAttributesBulk(h.dbConn, filter, req.Attributes, dryRun)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if !dryRun {
+		slog.Info("set user attributes in bulk", "affected", len(userIds), "actor", actorFromContext(r.Context()), "package", "api", "method", "SetUserAttributesBulk")
+		recordAudit(r.Context(), h.store, data.AuditActionAttributesSet, "user", "", map[string]any{
+			"filter":     req.Filter,
+			"attributes": req.Attributes,
+			"affected":   len(userIds),
+		})
+	}
+	resp := &UserAttributesBulkResponse{Affected: len(userIds), UserIds: userIds, DryRun: dryRun}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
 }
 
 // UserCtx middleware is used to load a User object from /users/{username} requests
 // and then attach it to the request context. In case of failure the request is aborted
-// and a 404 error response is sent to the client.
+// and a 404 error response is sent to the client. Deleted users are only loaded
+// if an admin asks for them, see includeDeleted.
 func (h *UserHandler) UserCtx(next http.Handler) http.Handler {
+	return h.userCtx(next, includeDeleted)
+}
+
+// DeletedUserCtx is UserCtx, but loads deleted users. Like includeDeleted
+// it's only for admins, everyone else gets 404 so they can't tell which
+// users are deleted.
+func (h *UserHandler) DeletedUserCtx(next http.Handler) http.Handler {
+	withDeleted := h.userCtx(next, func(*http.Request) bool { return true })
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := r.Context().Value(keys.RoleKey).(string); role != "admin" {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		withDeleted.ServeHTTP(w, r)
+	})
+}
+
+func (h *UserHandler) userCtx(next http.Handler, withDeleted func(*http.Request) bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var user *data.User
 		var err error
@@ -172,9 +732,13 @@ func (h *UserHandler) UserCtx(next http.Handler) http.Handler {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
-		user, err = data.GetUserById(h.dbConn, userId)
+		if withDeleted(r) {
+			user, err = h.store.GetUserByIdIncludingDeleted(userId)
+		} else {
+			user, err = h.store.GetUserById(userId)
+		}
 		if err != nil {
-			render.Render(w, r, ErrNotFound)
+			render.Render(w, r, ErrLookup(err))
 			return
 		}
 
@@ -183,17 +747,54 @@ func (h *UserHandler) UserCtx(next http.Handler) http.Handler {
 	})
 }
 
-// GetUser returns the user in the request context
+// GetUserByUid returns the user the posix uid in the url is allocated to
+func (h *UserHandler) GetUserByUid(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting user by uid", "package", "api", "method", "GetUserByUid")
+	uid, err := strconv.Atoi(chi.URLParam(r, "uid"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	user, err := data.GetUserByUid(h.dbConn, uid)
+	if err == sql.ErrNoRows {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if err := render.Render(w, r, h.mask.user(r, newUserResponse(user))); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetUserByUsername returns the user with the username in the URL, in any
+// case, or 404 if there's none
+func (h *UserHandler) GetUserByUsername(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting user by username", "package", "api", "method", "GetUserByUsername")
+	user, err := h.store.GetUserByUsername(chi.URLParam(r, "username"))
+	if err == sql.ErrNoRows {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	renderWithETag(w, r, h.mask.user(r, newUserRecordResponse(user)))
+}
+
+// GetUser returns the user in the request context, or 304 Not Modified if
+// the If-None-Match has their ETag
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("getting user", "package", "api", "method", "GetUser")
 	user := r.Context().Value(keys.UserKey).(*data.User)
-	resp := newUserResponse(user)
-	if err := render.Render(w, r, resp); err != nil {
-		render.Render(w, r, ErrRender(err))
-	}
+	renderWithETag(w, r, h.mask.user(r, newUserRecordResponse(user)))
 }
 
-// UpdateUser updates a user
+// UpdateUser updates a user. With a version in the If-Match header or the
+// body, it's a 409 with the current user if they've been updated since.
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("updating user", "package", "api", "method", "UpdateUser")
 	// existing user comes from the request context because
@@ -203,36 +804,346 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	// so that it contains all the fields of the existing user
 	// then bind the request body to it so that the new values
 	// from the request body are updated in the UserRequest object
-	userReq := newUserRequest(user)
+	userReq := &UserUpdateRequest{UserRequest: *newUserRequest(user)}
 	if err := render.Bind(r, userReq); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	version, err := expectedVersion(r, userReq.Version)
+	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
-	dataUserRequest := data.UserRequest(*userReq)
-	err := data.UpdateUser(h.dbConn, user.Id, &dataUserRequest)
+	userReq.Email = data.NormalizeEmail(userReq.Email, h.stripEmailPlusTags)
+	dataUserRequest := data.UserRequest(userReq.UserRequest)
+	err = h.store.UpdateUser(user.Id, &dataUserRequest, version)
+	if errors.Is(err, data.ErrVersionConflict) {
+		h.renderUserVersionConflict(w, r, user.Id)
+		return
+	}
 	if err != nil {
-		render.Render(w, r, ErrInternalServer(err))
+		render.Render(w, r, ErrInternal(err))
 		return
 	}
-	updatedUser, err := data.GetUserById(h.dbConn, user.Id)
+	recordChange(r.Context(), h.store, "user", user.Id, data.AuditActionUpdated)
+	updatedUser, err := h.store.GetUserById(user.Id)
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		render.Render(w, r, ErrLookup(err))
 		return
 	}
+	h.webhooks.Enqueue(webhook.EventUserUpdated, newUserResponse(updatedUser))
 
-	resp := newUserResponse(updatedUser)
+	resp := h.mask.user(r, newUserResponse(updatedUser))
 	render.Status(r, http.StatusOK)
 	render.Render(w, r, resp)
 }
 
-// DeleteUser deletes a user
+// PatchUser changes only the fields of the User in the request context that
+// are in the body, so clients don't have to send the whole User. A version
+// is checked like UpdateUser does.
+func (h *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("patching user", "package", "api", "method", "PatchUser")
+	user := r.Context().Value(keys.UserKey).(*data.User)
+	patch := UserPatchRequest{}
+	if err := render.Bind(r, &patch); err != nil {
+		render.Render(w, r, ErrBind(err))
+		return
+	}
+	version, err := expectedVersion(r, patch.version())
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if email, ok := patch["email"].(string); ok {
+		patch["email"] = data.NormalizeEmail(email, h.stripEmailPlusTags)
+	}
+	err = h.store.UpdateUserFields(user.Id, patch, version)
+	if errors.Is(err, data.ErrVersionConflict) {
+		h.renderUserVersionConflict(w, r, user.Id)
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	recordChange(r.Context(), h.store, "user", user.Id, data.AuditActionUpdated)
+	updatedUser, err := h.store.GetUserById(user.Id)
+	if err != nil {
+		render.Render(w, r, ErrLookup(err))
+		return
+	}
+	h.webhooks.Enqueue(webhook.EventUserUpdated, newUserResponse(updatedUser))
+	if err := render.Render(w, r, h.mask.user(r, newUserResponse(updatedUser))); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// renderUserVersionConflict answers an update of the user based on an old
+// version with the 409 carrying the user as they are now
+func (h *UserHandler) renderUserVersionConflict(w http.ResponseWriter, r *http.Request, userId int) {
+	current, err := h.store.GetUserById(userId)
+	if err != nil {
+		render.Render(w, r, ErrLookup(err))
+		return
+	}
+	render.Render(w, r, ErrVersionConflict(h.mask.user(r, newUserResponse(current))))
+}
+
+// DeleteUser soft deletes a user, see data.DeleteUser. Pirgs they own are
+// transferred to the orphaned_pirg_owner if it's set, otherwise the delete is
+// refused. Deleting a deleted user is a 404, or does nothing for an admin
+// with ?include_deleted=true. With ?dry_run=true the user isn't deleted, and
+// a 200 lists what would change.
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("deleting user", "package", "api", "method", "DeleteUser")
 	user := r.Context().Value(keys.UserKey).(*data.User)
-	err := data.DeleteUser(h.dbConn, user.Id)
+	dryRun := dryRunRequested(r)
+	if user.DeletedAt != nil {
+		if dryRun {
+			render.Render(w, r, &DryRunResponse{Changes: []*DryRunChange{}, DryRun: true})
+			return
+		}
+		render.Status(r, http.StatusNoContent)
+		return
+	}
+	if h.orphanedPirgOwner != "" {
+		h.deleteUserReassigningPirgs(w, r, user, dryRun)
+		return
+	}
+	err := h.store.DeleteUser(user.Id, dryRun)
+	if errors.Is(err, data.ErrUserOwnsPirgs) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		render.Render(w, r, ErrLookup(err))
 		return
 	}
+	if dryRun {
+		render.Render(w, r, newUserDeleteDryRunResponse(user, nil))
+		return
+	}
+	recordChange(r.Context(), h.store, "user", user.Id, data.AuditActionDeleted)
+	h.webhooks.Enqueue(webhook.EventUserDeleted, newUserResponse(user))
 	render.Status(r, http.StatusNoContent)
 }
+
+// newUserDeleteDryRunResponse is what deleting the user would change,
+// transferring pirgIds to the orphaned_pirg_owner first
+func newUserDeleteDryRunResponse(user *data.User, pirgIds []int) *DryRunResponse {
+	resp := &DryRunResponse{Changes: []*DryRunChange{}, DryRun: true}
+	if len(pirgIds) > 0 {
+		resp.Changes = append(resp.Changes, &DryRunChange{ResourceType: "pirg", Action: data.AuditActionOwnerChanged, Ids: pirgIds})
+	}
+	resp.Changes = append(resp.Changes, userDryRunChange(data.AuditActionDeleted, []*data.User{user}))
+	return resp
+}
+
+func (h *UserHandler) deleteUserReassigningPirgs(w http.ResponseWriter, r *http.Request, user *data.User, dryRun bool) {
+	newOwner, err := data.GetUserByUsername(h.dbConn, h.orphanedPirgOwner)
+	if err != nil {
+		render.Render(w, r, ErrInternal(fmt.Errorf("failed to look up orphaned pirg owner %s: %v", h.orphanedPirgOwner, err)))
+		return
+	}
+	if newOwner.Id == user.Id {
+		render.Render(w, r, ErrConflict(fmt.Errorf("user %s is the orphaned_pirg_owner and can't be deleted", user.Username)))
+		return
+	}
+	pirgIds, err := data.DeleteUserReassigningPirgs(h.dbConn, user.Id, newOwner.Id, actorFromContext(r.Context()), dryRun)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if dryRun {
+		render.Render(w, r, newUserDeleteDryRunResponse(user, pirgIds))
+		return
+	}
+	if len(pirgIds) > 0 {
+		slog.Info("reassigned pirgs of deleted user", "user_id", user.Id, "new_owner", newOwner.Username, "pirg_ids", pirgIds, "package", "api", "method", "DeleteUser")
+	}
+	recordChange(r.Context(), h.store, "user", user.Id, data.AuditActionDeleted)
+	h.webhooks.Enqueue(webhook.EventUserDeleted, newUserResponse(user))
+	render.Status(r, http.StatusNoContent)
+}
+
+// TouchUserLogin records that the User in the request context just logged in.
+// The login integration calls it on every login, so it isn't audited.
+func (h *UserHandler) TouchUserLogin(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("touching user login", "package", "api", "method", "TouchUserLogin")
+	user := r.Context().Value(keys.UserKey).(*data.User)
+	if err := h.store.TouchUserLogin(user.Id); err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyUserEmail records that the User in the request context verified
+// their email, once the onboarding flow has confirmed it
+func (h *UserHandler) VerifyUserEmail(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("verifying user email", "package", "api", "method", "VerifyUserEmail")
+	user := r.Context().Value(keys.UserKey).(*data.User)
+	if err := h.store.VerifyUserEmail(user.Id); err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	recordChange(r.Context(), h.store, "user", user.Id, data.AuditActionUpdated)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPendingUsers returns a page of the users pending for the reason
+// parameter, one of data.PendingUserReasons: those who haven't verified
+// their email, haven't been allocated a uid, or aren't in any pirg
+func (h *UserHandler) GetPendingUsers(w http.ResponseWriter, r *http.Request) {
+	reason := r.URL.Query().Get("reason")
+	slog.Debug("getting pending users", "reason", reason, "package", "api", "method", "GetPendingUsers")
+	if !slices.Contains(data.PendingUserReasons, reason) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("reason must be one of %s: %s", strings.Join(data.PendingUserReasons, ", "), reason)))
+		return
+	}
+	limit, offset, err := h.pages.parse(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	users, total, err := data.GetPendingUsers(h.dbConn, reason, limit, offset)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := &PageResponse{
+		Items:  h.mask.users(r, newUserResponseList(users)),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	h.pages.render(w, r, resp)
+}
+
+// RestoreUser undeletes the User in the request context and returns them.
+// Restoring a user who isn't deleted just returns them.
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("restoring user", "package", "api", "method", "RestoreUser")
+	user := r.Context().Value(keys.UserKey).(*data.User)
+	if err := h.store.RestoreUser(user.Id); err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	restored := user.DeletedAt != nil
+	user.DeletedAt = nil
+	resp := newUserResponse(user)
+	if restored {
+		slog.Info("restored deleted user", "user_id", user.Id, "actor", actorFromContext(r.Context()), "package", "api", "method", "RestoreUser")
+		recordChange(r.Context(), h.store, "user", user.Id, data.AuditActionRestored)
+		h.webhooks.Enqueue(webhook.EventUserRestored, resp)
+	}
+	if err := render.Render(w, r, h.mask.user(r, resp)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetUserDeleteImpact returns the pirgs and api keys that depend on the User
+// in the request context, so an admin can see what deleting them would affect
+func (h *UserHandler) GetUserDeleteImpact(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting user delete impact", "package", "api", "method", "GetUserDeleteImpact")
+	user := r.Context().Value(keys.UserKey).(*data.User)
+	impact, err := data.GetUserDeleteImpact(h.dbConn, user.Id)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := newUserDeleteImpactResponse(impact)
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetUserOwnedPirgs returns a page of the pirgs owned by the User in the
+// request context. Pirgs they're only a member or admin of aren't included.
+func (h *UserHandler) GetUserOwnedPirgs(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting user owned pirgs", "package", "api", "method", "GetUserOwnedPirgs")
+	user := r.Context().Value(keys.UserKey).(*data.User)
+	limit, offset, err := h.pages.parse(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	pirgs, total, err := data.GetPirgsByOwner(h.dbConn, user.Id, limit, offset)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	resp := &PageResponse{
+		Items:  newPirgResponseList(pirgs),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	h.pages.render(w, r, resp)
+}
+
+// requestUserId returns the id of the user making the request, or 0 if the
+// credentials don't belong to a known user
+func requestUserId(ctx context.Context, store data.Store) (int, error) {
+	if userId, ok := ctx.Value(keys.AuthUserIdKey).(int); ok && userId != 0 {
+		return userId, nil
+	}
+	if username, ok := ctx.Value(keys.AuthUsernameKey).(string); ok && username != "" {
+		user, err := store.GetUserByUsername(username)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return user.Id, nil
+	}
+	return 0, nil
+}
+
+// GetMe returns the User making the request. Oauth callers are matched by the
+// username from their token, and api key callers by the key's user.
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("getting requesting user", "package", "api", "method", "GetMe")
+	var user *data.User
+	var err error
+	if username, ok := r.Context().Value(keys.AuthUsernameKey).(string); ok && username != "" {
+		user, err = h.store.GetUserByUsername(username)
+	} else if userId, ok := r.Context().Value(keys.AuthUserIdKey).(int); ok && userId != 0 {
+		user, err = h.store.GetUserById(userId)
+	} else {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrLookup(err))
+		return
+	}
+	resp := newUserResponse(user)
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// RecomputeDerived re-applies the current normalization to every user, for
+// after strip_email_plus_tags changes. Emails are the only normalized field.
+// Nothing is changed unless ?apply=true is set, and a user whose email would
+// collide with another's is reported as a conflict instead of changed.
+func (h *UserHandler) RecomputeDerived(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("recomputing derived user fields", "package", "api", "method", "RecomputeDerived")
+	dryRun := r.URL.Query().Get("apply") != "true"
+	res, err := data.NormalizeUserEmails(h.dbConn, h.stripEmailPlusTags, dryRun)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if !dryRun {
+		slog.Info("recomputed derived user fields", "changed", len(res.Changed), "conflicts", len(res.Conflicts), "actor", actorFromContext(r.Context()), "package", "api", "method", "RecomputeDerived")
+		for _, c := range res.Changed {
+			recordChange(r.Context(), h.store, "user", c.UserId, data.AuditActionUpdated)
+		}
+	}
+	if err := render.Render(w, r, newRecomputeDerivedResponse(res)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
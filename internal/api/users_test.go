@@ -2,12 +2,20 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/lcrownover/hpcadmin-server/internal/config"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
 )
 
 func TestAPICreateUser(t *testing.T) {
@@ -239,6 +247,157 @@ func TestAPIUpdateUser(t *testing.T) {
 	}
 }
 
+func TestAPIPatchUser(t *testing.T) {
+	th := NewTestDataHandler()
+	user, err := data.CreateUser(th.DB, &data.UserRequest{
+		Username:  "testapipatchuser",
+		Email:     "testapipatchuser@localhost",
+		FirstName: "TestAPI",
+		LastName:  "PatchUser",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := func(body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("http://localhost:3333/api/v1/users/%d", user.Id), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := patch(fmt.Sprintf(`{"id": %d, "lastname": "Patched", "email": "TestAPIPatchUser2@Localhost"}`, user.Id+1))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+	var userResponse UserResponse
+	if err := json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
+		t.Fatal(err)
+	}
+	if userResponse.Id != user.Id || userResponse.LastName != "Patched" || userResponse.Email != "testapipatchuser2@localhost" {
+		t.Errorf("expected patched lastname and normalized email got %+v", userResponse)
+	}
+	got, err := data.GetUserById(th.DB, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != user.Username || got.FirstName != user.FirstName {
+		t.Errorf("expected untouched fields to keep their values got %+v", got)
+	}
+
+	// unknown fields are listed, and bad values rejected, without changing anything
+	resp = patch(`{"lastname": "Nope", "shell": "/bin/zsh", "admin": true}`)
+	defer resp.Body.Close()
+	var errResp ErrResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(errResp.Message, "admin, shell") {
+		t.Errorf("expected 400 listing admin, shell got %v %q", resp.StatusCode, errResp.Message)
+	}
+	resp = patch(`{"firstname": ""}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %v for an empty firstname got %v", http.StatusUnprocessableEntity, resp.StatusCode)
+	}
+	if got, err = data.GetUserById(th.DB, user.Id); err != nil || got.LastName != "Patched" || got.FirstName != user.FirstName {
+		t.Errorf("expected rejected patches to change nothing got %+v %v", got, err)
+	}
+}
+
+func TestAPIUpdateUserVersionConflict(t *testing.T) {
+	th := NewTestDataHandler()
+	user, err := data.CreateUser(th.DB, &data.UserRequest{
+		Username:  "testapiuserversion",
+		Email:     "testapiuserversion@localhost",
+		FirstName: "TestAPI",
+		LastName:  "UserVersion",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(method string, ifMatch string, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:3333/api/v1/users/%d", user.Id), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "testkey1")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// two clients read the user at the same version, the first to update wins
+	first := update("PUT", fmt.Sprintf(`"%d"`, user.Version), `{"username": "testapiuserversion", "email": "testapiuserversion@localhost", "firstname": "First", "lastname": "UserVersion"}`)
+	defer first.Body.Close()
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", first.StatusCode, http.StatusOK)
+	}
+	var updated UserResponse
+	if err := json.NewDecoder(first.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != user.Version+1 {
+		t.Errorf("expected version %d after the update got %d", user.Version+1, updated.Version)
+	}
+
+	second := update("PUT", "", fmt.Sprintf(`{"username": "testapiuserversion", "email": "testapiuserversion@localhost", "firstname": "Second", "lastname": "UserVersion", "version": %d}`, user.Version))
+	defer second.Body.Close()
+	if second.StatusCode != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", second.StatusCode, http.StatusConflict)
+	}
+	var conflict struct {
+		Code    string       `json:"error"`
+		Current UserResponse `json:"current"`
+	}
+	if err := json.NewDecoder(second.Body).Decode(&conflict); err != nil {
+		t.Fatal(err)
+	}
+	if conflict.Code != "version_conflict" || conflict.Current.FirstName != "First" || conflict.Current.Version != updated.Version {
+		t.Errorf("expected a version_conflict with the first update as current got %+v", conflict)
+	}
+	got, err := data.GetUserById(th.DB, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FirstName != "First" {
+		t.Errorf("expected the rejected update to change nothing got firstname %v", got.FirstName)
+	}
+
+	// retrying with the current version goes through, and so do patches
+	// that don't send one
+	patched := update("PATCH", fmt.Sprintf(`W/"%d"`, conflict.Current.Version), `{"firstname": "Second"}`)
+	defer patched.Body.Close()
+	if patched.StatusCode != http.StatusOK {
+		t.Errorf("expected status %v patching the current version got %v", http.StatusOK, patched.StatusCode)
+	}
+	unchecked := update("PATCH", "", `{"lastname": "Unchecked"}`)
+	defer unchecked.Body.Close()
+	if unchecked.StatusCode != http.StatusOK {
+		t.Errorf("expected status %v patching without a version got %v", http.StatusOK, unchecked.StatusCode)
+	}
+	stale := update("PATCH", "", fmt.Sprintf(`{"lastname": "Stale", "version": %d}`, updated.Version))
+	defer stale.Body.Close()
+	if stale.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %v patching a stale version got %v", http.StatusConflict, stale.StatusCode)
+	}
+}
+
 func TestAPIDeleteUser(t *testing.T) {
 	th := NewTestDataHandler()
 
@@ -344,4 +503,860 @@ func TestAPIDeleteUser(t *testing.T) {
 	if found {
 		t.Error("found user that should have been deleted")
 	}
+
+	do := func(method string, url string, want int) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s returned wrong status code: got %v want %v", method, url, resp.StatusCode, want)
+		}
+		return resp
+	}
+	// the user is soft deleted, so they're gone unless asked for
+	do("GET", deleteURL, http.StatusNotFound)
+	do("DELETE", deleteURL, http.StatusNotFound)
+	do("DELETE", deleteURL+"?include_deleted=true", http.StatusOK)
+	var deleted UserResponse
+	if err := json.NewDecoder(do("GET", deleteURL+"?include_deleted=true", http.StatusOK).Body).Decode(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.DeletedAt == nil {
+		t.Errorf("expected deleted_at to be set on the deleted user")
+	}
+	usersResponse = nil
+	if err := json.NewDecoder(do("GET", "http://localhost:3333/api/v1/users?include_deleted=true", http.StatusOK).Body).Decode(&usersResponse); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(usersResponse, func(u UserResponse) bool { return u.Id == userResponse.Id }) {
+		t.Errorf("expected the deleted user to be listed with include_deleted")
+	}
+
+	var restored UserResponse
+	if err := json.NewDecoder(do("POST", deleteURL+"/restore", http.StatusOK).Body).Decode(&restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Id != userResponse.Id || restored.DeletedAt != nil {
+		t.Errorf("expected the restored user got %+v", restored)
+	}
+	do("GET", deleteURL, http.StatusOK)
+}
+
+func TestAPIDeletedUserAPIKey(t *testing.T) {
+	th := NewTestDataHandler()
+	user, err := data.CreateUser(th.DB, &data.UserRequest{
+		Username:  "testapideleteduserkey",
+		Email:     "testapideleteduserkey@localhost",
+		FirstName: "TestAPI",
+		LastName:  "DeletedUserKey",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an admin key, so a deleted user can't keep their role
+	userKey := "testapideleteduserkey"
+	_, err = th.DB.Exec("INSERT INTO api_keys (key, role, user_id) VALUES ($1, 'admin', $2)", userKey, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method string, url string, key string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the first request caches the key
+	if code := do("GET", "http://localhost:3333/api/v1/me", userKey); code != http.StatusOK {
+		t.Fatalf("expected the key to work got %v", code)
+	}
+	if code := do("DELETE", fmt.Sprintf("http://localhost:3333/api/v1/users/%d", user.Id), "testkey1"); code != http.StatusOK {
+		t.Fatalf("expected the user to be deleted got %v", code)
+	}
+	if code := do("GET", "http://localhost:3333/api/v1/me", userKey); code != http.StatusUnauthorized {
+		t.Errorf("expected the deleted user's cached key to be refused got %v want %v", code, http.StatusUnauthorized)
+	}
+}
+
+func TestAPIGetUserDeleteImpact(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapideleteimpactowner")
+	member := newTestPirgOwner(t, th, "testapideleteimpactmember")
+	_, err := data.CreatePirg(th.DB, &data.PirgRequest{
+		Name:     "testapideleteimpact",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getImpact := func(userId int) UserDeleteImpactResponse {
+		url := fmt.Sprintf("http://localhost:3333/api/v1/users/%d/delete-impact", userId)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, http.StatusOK)
+		}
+		var impact UserDeleteImpactResponse
+		if err = json.NewDecoder(resp.Body).Decode(&impact); err != nil {
+			t.Fatal(err)
+		}
+		return impact
+	}
+
+	impact := getImpact(owner.Id)
+	if !impact.RequiresOwnershipTransfer {
+		t.Error("expected owner to require ownership transfer")
+	}
+	if len(impact.OwnedPirgs) != 1 {
+		t.Errorf("expected 1 owned pirg got %+v", impact.OwnedPirgs)
+	}
+
+	impact = getImpact(member.Id)
+	if impact.RequiresOwnershipTransfer {
+		t.Error("expected plain member not to require ownership transfer")
+	}
+	if len(impact.OwnedPirgs) != 0 || len(impact.MemberPirgs) != 1 {
+		t.Errorf("expected 0 owned and 1 member pirg got %+v and %+v", impact.OwnedPirgs, impact.MemberPirgs)
+	}
+}
+
+func TestAPIGetUserOwnedPirgs(t *testing.T) {
+	th := NewTestDataHandler()
+	owner := newTestPirgOwner(t, th, "testapiownedpirgsowner")
+	member := newTestPirgOwner(t, th, "testapiownedpirgsmember")
+	for _, name := range []string{"testapiownedpirgsa", "testapiownedpirgsb"} {
+		_, err := data.CreatePirg(th.DB, &data.PirgRequest{
+			Name:     name,
+			OwnerId:  owner.Id,
+			AdminIds: []int{owner.Id, member.Id},
+			UserIds:  []int{owner.Id, member.Id},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getOwned := func(userId int) ([]PirgResponse, int) {
+		url := fmt.Sprintf("http://localhost:3333/api/v1/users/%d/owned-pirgs", userId)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, http.StatusOK)
+		}
+		var page struct {
+			Items []PirgResponse `json:"items"`
+			Total int            `json:"total"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Items, page.Total
+	}
+
+	pirgs, total := getOwned(owner.Id)
+	if total != 2 || len(pirgs) != 2 {
+		t.Fatalf("expected 2 owned pirgs got total %v pirgs %+v", total, pirgs)
+	}
+	for _, p := range pirgs {
+		if p.OwnerId != owner.Id {
+			t.Errorf("expected owner %v got %v", owner.Id, p.OwnerId)
+		}
+	}
+
+	// an admin member of both pirgs still owns none
+	pirgs, total = getOwned(member.Id)
+	if total != 0 || pirgs == nil || len(pirgs) != 0 {
+		t.Fatalf("expected an empty array got total %v pirgs %+v", total, pirgs)
+	}
+}
+
+func TestAPIGetUsersByAttribute(t *testing.T) {
+	th := NewTestDataHandler()
+	user := newTestPirgOwner(t, th, "testapiusersbyattribute")
+	if err := data.SetUserAttribute(th.DB, user.Id, "testapidept", "physics"); err != nil {
+		t.Fatal(err)
+	}
+
+	getUsers := func(query string) []UserResponse {
+		req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/users?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				resp.StatusCode, http.StatusOK)
+		}
+		var users []UserResponse
+		if err = json.NewDecoder(resp.Body).Decode(&users); err != nil {
+			t.Fatal(err)
+		}
+		return users
+	}
+
+	users := getUsers("attribute.testapidept=physics")
+	if len(users) != 1 || users[0].Id != user.Id {
+		t.Errorf("expected only user %v got %+v", user.Id, users)
+	}
+	users = getUsers("attribute.testapidept=nomatch")
+	if len(users) != 0 {
+		t.Errorf("expected no users got %+v", users)
+	}
+
+	other := newTestPirgOwner(t, th, "testapiusersbyattribute2")
+	if err := data.SetUserAttribute(th.DB, other.Id, "testapidept", "chemistry"); err != nil {
+		t.Fatal(err)
+	}
+	// repeated and comma separated values are the same IN filter
+	for _, query := range []string{
+		"attribute.testapidept=physics&attribute.testapidept=chemistry",
+		"attribute.testapidept=physics,chemistry",
+		"username=testapiusersbyattribute&username=testapiusersbyattribute2",
+		"username=testapiusersbyattribute,testapiusersbyattribute2",
+	} {
+		users = getUsers(query)
+		if len(users) != 2 || users[0].Id != user.Id || users[1].Id != other.Id {
+			t.Errorf("%s: expected users %v and %v got %+v", query, user.Id, other.Id, users)
+		}
+	}
+}
+
+func TestAPIGetUserByEmail(t *testing.T) {
+	th := NewTestDataHandler()
+	user := newTestPirgOwner(t, th, "testapigetuserbyemail")
+
+	req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/users?email="+url.QueryEscape(" TestAPIGetUserByEmail@Localhost"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "testkey1")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	var userResponse UserResponse
+	if err = json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
+		t.Fatal(err)
+	}
+	if userResponse.Id != user.Id {
+		t.Errorf("expected user %v got %v", user.Id, userResponse.Id)
+	}
+}
+
+func TestAPIGetMe(t *testing.T) {
+	th := NewTestDataHandler()
+	entry, err := data.GetAPIKeyEntry(th.DB, "testkey1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "testkey1")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			resp.StatusCode, http.StatusOK)
+	}
+	var me UserResponse
+	if err = json.NewDecoder(resp.Body).Decode(&me); err != nil {
+		t.Fatal(err)
+	}
+	if me.Id != entry.UserId {
+		t.Errorf("expected the api key's user %v got %v", entry.UserId, me.Id)
+	}
+}
+
+func TestSetUserAttributesBulkRejectsEmptyFilter(t *testing.T) {
+	h := &UserHandler{}
+	for _, body := range []string{
+		`{"attributes": {"sponsor": "GrantX"}}`,
+		`{"filter": {"attributes": {}}, "attributes": {"sponsor": "GrantX"}}`,
+		`{"filter": {"username": "someone"}}`,
+		`{"filter": {"username": "someone"}, "attributes": {"": "GrantX"}}`,
+	} {
+		req := httptest.NewRequest("POST", "/users/attributes/bulk", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.SetUserAttributesBulk(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected got %v", body, w.Code)
+		}
+	}
+}
+
+func TestAPISearchUsers(t *testing.T) {
+	th := NewTestDataHandler()
+	for _, username := range []string{"testapisearchb", "testapisearcha", "testapiothername"} {
+		_, err := data.CreateUser(th.DB, &data.UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Search"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/users?q=APISEARCH&has_pirg=false&sort=username&order=desc&envelope=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "testkey1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+	var page struct {
+		Items []UserResponse `json:"items"`
+		Total int            `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 2 || page.Items[0].Username != "testapisearchb" || page.Items[1].Username != "testapisearcha" {
+		t.Errorf("expected testapisearchb and testapisearcha got %+v", page)
+	}
+}
+
+func TestAPITouchUserLogin(t *testing.T) {
+	th := NewTestDataHandler()
+	user, err := data.CreateUser(th.DB, &data.UserRequest{Username: "testapitouchlogin", Email: "testapitouchlogin@localhost", FirstName: "Test", LastName: "Login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method string, url string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	// they've never logged in, so they're inactive since any time
+	since := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	inactiveURL := "http://localhost:3333/api/v1/users?q=testapitouchlogin&envelope=true&inactive_since=" + since
+	inactive := func() int {
+		t.Helper()
+		resp := do("GET", inactiveURL)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+		var page struct {
+			Total int `json:"total"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Total
+	}
+	if total := inactive(); total != 1 {
+		t.Fatalf("expected the user to be inactive got total %v", total)
+	}
+
+	resp := do("POST", fmt.Sprintf("http://localhost:3333/api/v1/users/%d/login", user.Id))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusNoContent)
+	}
+	if total := inactive(); total != 0 {
+		t.Fatalf("expected the user to be active after logging in got total %v", total)
+	}
+	resp = do("GET", fmt.Sprintf("http://localhost:3333/api/v1/users/%d", user.Id))
+	defer resp.Body.Close()
+	var got UserResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.LastLoginAt == nil {
+		t.Errorf("expected last_login_at to be set got %+v", got)
+	}
+}
+
+func TestSearchUsersRejectsInvalidParams(t *testing.T) {
+	h := &UserHandler{}
+	for _, query := range []string{
+		"sort=email",
+		"q=someone&sort=id%3BDROP%20TABLE%20users",
+		"order=up",
+		"has_pirg=maybe",
+		"inactive_since=2001-01-01",
+	} {
+		req := httptest.NewRequest("GET", "/users?"+query, nil)
+		w := httptest.NewRecorder()
+		h.GetAllUsers(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected got %v", query, w.Code)
+		}
+	}
+}
+
+func TestAPICreateUsersBulk(t *testing.T) {
+	th := NewTestDataHandler()
+	post := func(users []UserRequest) *http.Response {
+		body, err := json.Marshal(users)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/users/bulk", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	users := []UserRequest{
+		{Username: "testapibulka", Email: "testapibulka@localhost", FirstName: "Test", LastName: "BulkA"},
+		{Username: "testapibulkb", Email: "testapibulkb@localhost", FirstName: "Test", LastName: "BulkB"},
+	}
+	resp := post(users)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+	var created []UserResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != len(users) {
+		t.Fatalf("expected %v users got %v", len(users), len(created))
+	}
+	for i, u := range created {
+		if u.Username != users[i].Username {
+			t.Errorf("expected username %v got %v", users[i].Username, u.Username)
+		}
+	}
+
+	// one bad user and nothing is created, every user gets a result
+	batch := []UserRequest{
+		{Username: "testapibulkc", Email: "testapibulkc@localhost", FirstName: "Test", LastName: "BulkC"},
+		{Username: "Test Bulk", Email: "testapibulkd@localhost", FirstName: "Test", LastName: "BulkD"},
+		{Username: "testapibulka", Email: "testapibulke@localhost", FirstName: "Test", LastName: "BulkE"},
+	}
+	resp = post(batch)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusMultiStatus)
+	}
+	var results UserBulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{http.StatusFailedDependency, http.StatusUnprocessableEntity, http.StatusConflict}
+	if len(results.Results) != len(want) {
+		t.Fatalf("expected %v results got %v", len(want), len(results.Results))
+	}
+	for i, result := range results.Results {
+		if result.Index != i || result.Status != want[i] {
+			t.Errorf("expected user %v to have status %v got %+v", i, want[i], result)
+		}
+	}
+	if _, err := data.GetUserByUsername(th.DB, "testapibulkc"); err == nil {
+		t.Error("expected the valid user not to be created")
+	}
+}
+
+func TestCreateUsersBulkRejectsBatchSize(t *testing.T) {
+	h := &UserHandler{}
+	user := `{"username": "testbulksize", "email": "testbulksize@localhost", "firstname": "Test", "lastname": "Size"}`
+	for name, body := range map[string]string{
+		"Empty":    `[]`,
+		"TooLarge": "[" + strings.Repeat(user+",", maxBulkUsers) + user + "]",
+		"Null":     `[null]`,
+		"NotArray": user,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/users/bulk", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.CreateUsersBulk(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCreateUserValidation(t *testing.T) {
+	h := &UserHandler{}
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"MissingFields", `{"username": "testvalidation"}`, []string{"email", "firstname", "lastname"}},
+		{"UsernameWithSpace", `{"username": "test validation", "email": "testvalidation@localhost", "firstname": "Test", "lastname": "Validation"}`, []string{"username"}},
+		{"UsernameWithDot", `{"username": "test.validation", "email": "testvalidation@localhost", "firstname": "Test", "lastname": "Validation"}`, []string{"username"}},
+		{"UsernameTooLong", `{"username": "` + strings.Repeat("a", 33) + `", "email": "testvalidation@localhost", "firstname": "Test", "lastname": "Validation"}`, []string{"username"}},
+		{"EmailWithoutAt", `{"username": "testvalidation", "email": "testvalidation", "firstname": "Test", "lastname": "Validation"}`, []string{"email"}},
+		{"EmailWithName", `{"username": "testvalidation", "email": "Test <testvalidation@localhost>", "firstname": "Test", "lastname": "Validation"}`, []string{"email"}},
+		{"EmailWithSpace", `{"username": "testvalidation", "email": "test validation@localhost", "firstname": "Test", "lastname": "Validation"}`, []string{"email"}},
+		{"BlankLastname", `{"username": "testvalidation", "email": "testvalidation@localhost", "firstname": "Test", "lastname": " "}`, []string{"lastname"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.CreateUser(w, req)
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusUnprocessableEntity)
+			}
+			var errResp ErrResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+				t.Fatal(err)
+			}
+			fields := []string{}
+			for _, f := range errResp.Fields {
+				if f.Message == "" {
+					t.Errorf("expected a message for field %v", f.Field)
+				}
+				fields = append(fields, f.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("expected errors for %v got %v", tt.fields, fields)
+			}
+		})
+	}
+}
+
+func TestGetUserByUsername(t *testing.T) {
+	store := data.NewMemoryStore()
+	ctx := context.WithValue(context.Background(), keys.ConfigKey, &config.ServerConfig{})
+	ctx = context.WithValue(ctx, keys.StoreKey, store)
+	users := UsersRouter(ctx)
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		reqCtx := context.WithValue(req.Context(), keys.RoleKey, "admin")
+		w := httptest.NewRecorder()
+		users.ServeHTTP(w, req.WithContext(reqCtx))
+		return w
+	}
+
+	// the username is lowercased when it's written
+	w := do("POST", "/", `{"username": "Jdoe", "email": "jdoe@localhost", "firstname": "J", "lastname": "Doe"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var created UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Username != "jdoe" {
+		t.Errorf("expected username jdoe got %v", created.Username)
+	}
+
+	// and when it's looked up
+	for _, username := range []string{"Jdoe", "jdoe", "JDOE"} {
+		w := do("GET", "/by-username/"+username, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code for %s: got %v want %v", username, w.Code, http.StatusOK)
+		}
+		var got UserResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Id != created.Id || got.Email != created.Email || got.CreatedAt.IsZero() {
+			t.Errorf("expected %s to be the full record of user %d got %+v", username, created.Id, got)
+		}
+	}
+	if w := do("GET", "/by-username/nobody", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %v for a missing username got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestAPIGetUsersByCursor(t *testing.T) {
+	th := NewTestDataHandler()
+	seeded := map[int]bool{}
+	for i := 0; i < 5; i++ {
+		user := newTestPirgOwner(t, th, fmt.Sprintf("testapiusersbycursor%d", i))
+		seeded[user.Id] = true
+	}
+
+	seen := map[int]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10000 {
+			t.Fatal("paging didn't reach the last page")
+		}
+		req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/users?limit=2&cursor="+url.QueryEscape(cursor), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page struct {
+			Items      []UserResponse `json:"items"`
+			Limit      int            `json:"limit"`
+			NextCursor string         `json:"next_cursor"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+		if len(page.Items) > 2 {
+			t.Fatalf("expected at most 2 users got %v", len(page.Items))
+		}
+		for _, u := range page.Items {
+			if seen[u.Id] {
+				t.Fatalf("user %v returned twice", u.Id)
+			}
+			seen[u.Id] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	for id := range seeded {
+		if !seen[id] {
+			t.Errorf("expected seeded user %v to be returned", id)
+		}
+	}
+}
+
+func TestAPIGetUserByUid(t *testing.T) {
+	th := NewTestDataHandler()
+	user := newTestPirgOwner(t, th, "testapigetuserbyuid")
+	uid, err := data.AllocatePosixId(th.DB, data.PosixIdKindUid, user.Id, data.PosixIdRange{Min: 73000, Max: 73999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(uid string, wantStatus int) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://localhost:3333/api/v1/users/by-uid/"+uid, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != wantStatus {
+			resp.Body.Close()
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, wantStatus)
+		}
+		return resp
+	}
+
+	resp := get(fmt.Sprint(uid), http.StatusOK)
+	defer resp.Body.Close()
+	var userResponse UserResponse
+	if err = json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
+		t.Fatal(err)
+	}
+	if userResponse.Id != user.Id {
+		t.Errorf("expected user %v got %v", user.Id, userResponse.Id)
+	}
+
+	// nobody holds this uid
+	get("73999999", http.StatusNotFound).Body.Close()
+	get("notauid", http.StatusNotFound).Body.Close()
+}
+
+func TestRecomputeDerived(t *testing.T) {
+	th := NewTestDataHandler()
+	var userId int
+	err := th.DB.QueryRow("INSERT INTO users (username, email, firstname, lastname) VALUES ('testrecomputederived', 'TestRecomputeDerived+Tag@Localhost ', 'Test', 'Recompute') RETURNING id").Scan(&userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &UserHandler{dbConn: th.DB, store: data.NewPostgresStore(th.DB), stripEmailPlusTags: true}
+	recompute := func(t *testing.T, query string) *RecomputeDerivedResponse {
+		w := httptest.NewRecorder()
+		h.RecomputeDerived(w, httptest.NewRequest("POST", "/maintenance/recompute-derived"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var resp RecomputeDerivedResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return &resp
+	}
+	findChange := func(resp *RecomputeDerivedResponse) *UserEmailChangeResponse {
+		for _, c := range resp.Emails {
+			if c.UserId == userId {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		resp := recompute(t, "")
+		if !resp.DryRun || resp.Changed != len(resp.Emails) {
+			t.Errorf("expected a dry run counting every change got %+v", resp)
+		}
+		if c := findChange(resp); c == nil || c.To != "testrecomputederived@localhost" {
+			t.Errorf("expected testrecomputederived to be normalized got %+v", c)
+		}
+		user, err := data.GetUserById(th.DB, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "TestRecomputeDerived+Tag@Localhost " {
+			t.Errorf("expected a dry run to change nothing got %q", user.Email)
+		}
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		resp := recompute(t, "?apply=true")
+		if resp.DryRun || findChange(resp) == nil {
+			t.Errorf("expected testrecomputederived to be normalized got %+v", resp)
+		}
+		user, err := data.GetUserById(th.DB, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "testrecomputederived@localhost" {
+			t.Errorf("expected the normalized email to be stored got %q", user.Email)
+		}
+		if resp := recompute(t, ""); findChange(resp) != nil {
+			t.Errorf("expected nothing left to change for testrecomputederived")
+		}
+	})
+}
+
+func TestGetPendingUsersRejectsInvalidReason(t *testing.T) {
+	for _, reason := range []string{"", "unverified"} {
+		req := httptest.NewRequest("GET", "/admin/users/pending?reason="+reason, nil)
+		w := httptest.NewRecorder()
+		(&UserHandler{}).GetPendingUsers(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no_primary_pirg") {
+			t.Errorf("expected reason %q to be rejected listing the reasons got %v %s", reason, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAPIGetPendingUsers(t *testing.T) {
+	th := NewTestDataHandler()
+	user, err := data.CreateUser(th.DB, &data.UserRequest{Username: "testapipending", Email: "testapipending@localhost", FirstName: "Test", LastName: "Pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingIds := func() []int {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://localhost:3333/admin/users/pending?reason=unverified_email&envelope=true&limit=1000", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "testkey1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+		var page struct {
+			Items []UserResponse `json:"items"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, u := range page.Items {
+			ids = append(ids, u.Id)
+		}
+		return ids
+	}
+	if !slices.Contains(pendingIds(), user.Id) {
+		t.Fatal("expected the new user to have an unverified email")
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:3333/api/v1/users/%d/verify-email", user.Id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "testkey1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusNoContent)
+	}
+	if slices.Contains(pendingIds(), user.Id) {
+		t.Error("expected the verified user not to be pending")
+	}
 }
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// expectedVersion returns the version of the record an update is based on,
+// from the If-Match header or else the body's version, or 0 if neither has
+// one and the update shouldn't check. If-Match has the version as its entity
+// tag, such as "3" or W/"3". If-Match: * is any version.
+func expectedVersion(r *http.Request, bodyVersion int) (int, error) {
+	if bodyVersion < 0 {
+		return 0, fmt.Errorf("version must be a positive integer")
+	}
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return bodyVersion, nil
+	}
+	tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("If-Match must be the version of the record, not %s", ifMatch)
+	}
+	if bodyVersion != 0 && bodyVersion != version {
+		return 0, fmt.Errorf("If-Match version %d doesn't match the body's version %d", version, bodyVersion)
+	}
+	return version, nil
+}
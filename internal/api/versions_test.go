@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpectedVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifMatch     string
+		bodyVersion int
+		want        int
+		wantErr     bool
+	}{
+		{"Neither", "", 0, 0, false},
+		{"Body", "", 3, 3, false},
+		{"IfMatch", `"3"`, 0, 3, false},
+		{"WeakIfMatch", `W/"3"`, 0, 3, false},
+		{"BareIfMatch", "3", 0, 3, false},
+		{"AnyVersion", "*", 0, 0, false},
+		{"Both", `"3"`, 3, 3, false},
+		{"Mismatch", `"3"`, 2, 0, true},
+		{"NotAVersion", `W/"6f1ed002ab5595859014ebf0951522d9"`, 0, 0, true},
+		{"Zero", `"0"`, 0, 0, true},
+		{"NegativeBody", "", -1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/", nil)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			got, err := expectedVersion(req, tt.bodyVersion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected version %d got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package auditsink forwards audit events to systems outside the database,
+// such as a syslog server or a SIEM webhook
+package auditsink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net/http"
+	"sync"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// defaultSyslogTag is the tag syslog messages are sent with if syslog.tag isn't set
+const defaultSyslogTag = "hpcadmin-server"
+
+// Sink receives audit events, each one as a single JSON document
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, event []byte) error
+}
+
+// New returns a sink for each one that's configured, in a fixed order.
+// The database isn't one of them, it records every event regardless.
+func New(cfg config.AuditSinkConfig, client *http.Client) ([]Sink, error) {
+	sinks := []Sink{}
+	if cfg.Syslog.Enabled {
+		s, err := NewSyslogSink(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.HTTP.URL != "" {
+		sinks = append(sinks, NewHTTPSink(cfg.HTTP, client))
+	}
+	return sinks, nil
+}
+
+// SyslogSink sends each event as an info message to syslog.
+// The writer reconnects on its own if the connection drops.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+func NewSyslogSink(cfg config.SyslogSinkConfig) (*SyslogSink, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	slog.Debug("connecting to syslog", "network", cfg.Network, "address", cfg.Address, "tag", tag, "package", "auditsink", "method", "NewSyslogSink")
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Write(ctx context.Context, event []byte) error {
+	return s.w.Info(string(event))
+}
+
+// HTTPSink POSTs each event to a webhook. Any response other than a 2xx is
+// an error, so the event is sent again on the next attempt.
+type HTTPSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewHTTPSink(cfg config.HTTPSinkConfig, client *http.Client) *HTTPSink {
+	return &HTTPSink{url: cfg.URL, headers: cfg.Headers, client: client}
+}
+
+func (s *HTTPSink) Name() string {
+	return "http"
+}
+
+func (s *HTTPSink) Write(ctx context.Context, event []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(event))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Recorder keeps the events written to it, for tests.
+// Writes return Err instead if it's set.
+type Recorder struct {
+	mu     sync.Mutex
+	events [][]byte
+	Err    error
+}
+
+func (r *Recorder) Name() string {
+	return "recorder"
+}
+
+func (r *Recorder) Write(ctx context.Context, event []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return r.Err
+	}
+	r.events = append(r.events, bytes.Clone(event))
+	return nil
+}
+
+// Events returns the events written so far, oldest first
+func (r *Recorder) Events() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]byte{}, r.events...)
+}
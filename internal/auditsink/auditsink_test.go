@@ -0,0 +1,91 @@
+package auditsink
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	sinks, err := New(config.AuditSinkConfig{}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 0 {
+		t.Fatalf("expected no sinks without config got %v", len(sinks))
+	}
+	sinks, err = New(config.AuditSinkConfig{HTTP: config.HTTPSinkConfig{URL: "http://localhost"}}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 1 || sinks[0].Name() != "http" {
+		t.Fatalf("expected the http sink got %v", sinks)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var gotBody, gotType, gotAuth string
+	status := http.StatusAccepted
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotType = r.Header.Get("Content-Type")
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := NewHTTPSink(config.HTTPSinkConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer testtoken"}}, srv.Client())
+	event := `{"id":1,"action":"member_added"}`
+	if err := s.Write(context.Background(), []byte(event)); err != nil {
+		t.Fatal(err)
+	}
+	if gotBody != event {
+		t.Errorf("expected body %s got %s", event, gotBody)
+	}
+	if gotType != "application/json" {
+		t.Errorf("expected application/json got %v", gotType)
+	}
+	if gotAuth != "Bearer testtoken" {
+		t.Errorf("expected the configured header got %v", gotAuth)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := s.Write(context.Background(), []byte(event)); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected a 503 to be an error got %v", err)
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewSyslogSink(config.SyslogSinkConfig{Enabled: true, Network: "udp", Address: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := `{"id":1,"action":"member_added"}`
+	if err := s.Write(context.Background(), []byte(event)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.Contains(msg, defaultSyslogTag) || !strings.HasSuffix(strings.TrimSpace(msg), event) {
+		t.Errorf("unexpected syslog message: %s", msg)
+	}
+}
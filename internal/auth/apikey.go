@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/api"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
 )
@@ -37,9 +40,22 @@ func (m *Middleware) APIKeyLoader(next http.Handler) http.Handler {
 		// that means it's a valid role
 		if cachedRole != "unknown" {
 			slog.Debug("api key and valid role found in cache", "package", "auth", "method", "APIKeyLoader")
+			userId := ac.LookupCachedAPIKeyUserId(apiKey)
+			// the key's user may have been deleted since it was cached,
+			// their keys stop working with them
+			if userId != 0 {
+				if _, err := data.GetUserById(m.db, userId); err != nil {
+					slog.Debug("cached api key's user not found", "user_id", userId, "error", err, "package", "auth", "method", "APIKeyLoader")
+					ac.EvictAPIKey(apiKey)
+					render.Render(w, r, api.ErrUnauthorized)
+					return
+				}
+			}
 			// api key and valid role was found in cache,
-			// so we'll set the role, cache it, and continue
+			// so we'll set the role and continue
 			ctx = context.WithValue(ctx, keys.RoleKey, cachedRole)
+			ctx = context.WithValue(ctx, keys.AuthUserIdKey, userId)
+			ctx = context.WithValue(ctx, keys.ActorKey, apiKeyActor(userId))
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
@@ -49,14 +65,14 @@ func (m *Middleware) APIKeyLoader(next http.Handler) http.Handler {
 		apiKeyEntry, err := data.GetAPIKeyEntry(m.db, apiKey)
 		if err != nil {
 			// error getting api key entry from database
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			render.Render(w, r, api.ErrUnauthorized)
 			return
 		}
 		if apiKeyEntry == nil {
 			slog.Debug("api key not found in database", "package", "auth", "method", "APIKeyLoader")
 			// api key wasnt found in the database
 			// cache the unknown key and continue
-			ac.CacheAPIKey(apiKey, "unknown")
+			ac.CacheAPIKey(apiKey, "unknown", 0)
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -64,8 +80,15 @@ func (m *Middleware) APIKeyLoader(next http.Handler) http.Handler {
 		// api key found in database, cache it and continue
 		slog.Debug("api key found in database", "package", "auth", "method", "APIKeyLoader")
 		slog.Debug("caching api key", "package", "auth", "method", "APIKeyLoader")
-		ac.CacheAPIKey(apiKey, apiKeyEntry.Role)
+		ac.CacheAPIKey(apiKey, apiKeyEntry.Role, apiKeyEntry.UserId)
 		ctx = context.WithValue(ctx, keys.RoleKey, apiKeyEntry.Role)
+		ctx = context.WithValue(ctx, keys.AuthUserIdKey, apiKeyEntry.UserId)
+		ctx = context.WithValue(ctx, keys.ActorKey, apiKeyActor(apiKeyEntry.UserId))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// apiKeyActor identifies requests made with an api key by the key's user
+func apiKeyActor(userId int) string {
+	return fmt.Sprintf("user:%d", userId)
+}
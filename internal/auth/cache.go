@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"context"
 	"log/slog"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/lcrownover/hpcadmin-lib/pkg/oauth"
+	"github.com/lcrownover/hpcadmin-server/internal/jwks"
 )
 
 // AuthCache is the cache for the auth service
@@ -23,8 +25,9 @@ type TokenCache struct {
 }
 
 type APIKeyCache struct {
-	Key  string
-	Role string
+	Key    string
+	Role   string
+	UserId int
 }
 
 func NewAuthCache() *AuthCache {
@@ -34,8 +37,9 @@ func NewAuthCache() *AuthCache {
 	}
 }
 
-// TokenIsValid checks if the token is valid and returns it if it is
-func (a *AuthCache) TokenIsValid(token string) (*jwt.Token, bool, error) {
+// TokenIsValid checks if the token is valid and returns it if it is.
+// Tokens that aren't cached are verified with the keys from source.
+func (a *AuthCache) TokenIsValid(ctx context.Context, token string, source *jwks.Source) (*jwt.Token, bool, error) {
 	// if the token is in our cache, it's valid and it hasn't expired, return it
 	jwtToken, ok, err := a.LookupCachedToken(token)
 	if err != nil {
@@ -47,7 +51,7 @@ func (a *AuthCache) TokenIsValid(token string) (*jwt.Token, bool, error) {
 
 	// otherwise, check if the token is valid and return it
 	slog.Debug("token is not in cache, parsing token", "package", "auth", "method", "TokenIsValid")
-	jwtToken, err = oauth.GetJWTFromTokenString(token)
+	jwtToken, err = source.Parse(ctx, token)
 	if err != nil {
 		return nil, false, err
 	}
@@ -101,12 +105,24 @@ func (a *AuthCache) LookupCachedAPIKey(key string) string {
 	return "unknown"
 }
 
+// LookupCachedAPIKeyUserId returns the user id the cached api key belongs to,
+// or 0 if the key isn't cached
+func (a *AuthCache) LookupCachedAPIKeyUserId(key string) int {
+	return a.APITokenCache[key].UserId
+}
+
 // CacheAPIKey adds the api key to the cache
-func (a *AuthCache) CacheAPIKey(key string, role string) {
+func (a *AuthCache) CacheAPIKey(key string, role string, userId int) {
 	slog.Debug("adding api key to cache", "role", role, "package", "auth", "method", "CacheAPIKey")
 	a.APITokenCache[key] = APIKeyCache{
-		Key:  key,
-		Role: role,
+		Key:    key,
+		Role:   role,
+		UserId: userId,
 	}
 	slog.Debug("cached api key", "package", "auth", "method", "CacheAPIKey")
 }
+
+// EvictAPIKey removes the api key from the cache
+func (a *AuthCache) EvictAPIKey(key string) {
+	delete(a.APITokenCache, key)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// NewServerTLSConfig builds the tls.Config for the http server.
+// If a client CA file is configured, client certificates signed by it are verified,
+// and required if RequireClientCert is set.
+func NewServerTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+	slog.Debug("loading client ca file", "package", "auth", "method", "NewServerTLSConfig", "path", cfg.ClientCAFile)
+	caData, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client ca file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("no certificates found in client ca file: %s", cfg.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// ClientCertLoader middleware maps a verified client certificate to a role.
+// The subject CN is checked first, then the DNS, email, and URI SANs.
+// Requests without a verified certificate, or with an identity that isn't
+// mapped, continue on without a role so other auth methods can set one.
+func (m *Middleware) ClientCertLoader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cert := r.TLS.VerifiedChains[0][0]
+		for _, identity := range clientCertIdentities(cert) {
+			role, ok := m.cfg.TLS.ClientCertRoles[identity]
+			if !ok {
+				continue
+			}
+			slog.Debug("client certificate identity mapped to role", "package", "auth", "method", "ClientCertLoader", "identity", identity, "role", role)
+			ctx := context.WithValue(r.Context(), keys.ClientCertKey, identity)
+			ctx = context.WithValue(ctx, keys.RoleKey, role)
+			ctx = context.WithValue(ctx, keys.ActorKey, "cert:"+identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		slog.Debug("client certificate identity not mapped to a role", "package", "auth", "method", "ClientCertLoader", "cn", cert.Subject.CommonName)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientCertIdentities returns the names a client certificate can be mapped by
+func clientCertIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (ca *testCA) issueClientCert(t *testing.T, cn string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newClientCertTestServer starts a TLS server that trusts clientCA and returns
+// the role that ClientCertLoader set, or 401 if none was set
+func newClientCertTestServer(t *testing.T, clientCA *testCA, require bool) *httptest.Server {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, clientCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ServerConfig{
+		TLS: config.TLSConfig{
+			ClientCAFile:      caFile,
+			RequireClientCert: require,
+			ClientCertRoles:   map[string]string{"sync-service": "admin"},
+		},
+	}
+	tlsConfig, err := NewServerTLSConfig(cfg.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := NewMiddleware(nil, cfg, nil)
+	srv := httptest.NewUnstartedServer(mw.ClientCertLoader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, ok := r.Context().Value(keys.RoleKey).(string)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(role))
+	})))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func clientWithCert(srv *httptest.Server, cert *tls.Certificate) *http.Client {
+	client := srv.Client()
+	transport := client.Transport.(*http.Transport)
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	return client
+}
+
+func TestClientCertLoader(t *testing.T) {
+	trustedCA := newTestCA(t, "trusted-ca")
+	untrustedCA := newTestCA(t, "untrusted-ca")
+
+	t.Run("TrustedCertMapsToRole", func(t *testing.T) {
+		srv := newClientCertTestServer(t, trustedCA, true)
+		cert := trustedCA.issueClientCert(t, "sync-service")
+		resp, err := clientWithCert(srv, &cert).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %v got %v", http.StatusOK, resp.StatusCode)
+		}
+	})
+	t.Run("UntrustedCertRejected", func(t *testing.T) {
+		srv := newClientCertTestServer(t, trustedCA, true)
+		cert := untrustedCA.issueClientCert(t, "sync-service")
+		resp, err := clientWithCert(srv, &cert).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+			t.Fatal("expected tls handshake to fail for an untrusted client certificate")
+		}
+	})
+	t.Run("UnmappedCertHasNoRole", func(t *testing.T) {
+		srv := newClientCertTestServer(t, trustedCA, true)
+		cert := trustedCA.issueClientCert(t, "someone-else")
+		resp, err := clientWithCert(srv, &cert).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status %v got %v", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+	t.Run("OptionalCertAllowsOtherAuth", func(t *testing.T) {
+		srv := newClientCertTestServer(t, trustedCA, false)
+		resp, err := clientWithCert(srv, nil).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status %v got %v", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// GrantLoader middleware applies an active role grant for the requesting user.
+// It only ever raises the role, so it has no effect on admins. Once a grant
+// expires it is no longer found, and the user is back to their normal role.
+func (m *Middleware) GrantLoader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value(keys.RoleKey).(string)
+		if role == "admin" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userId := m.requestUserId(r.Context())
+		if userId == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		grant, err := data.GetActiveRoleGrant(m.db, userId)
+		if err != nil {
+			slog.Error("failed to look up role grant", "package", "auth", "method", "GrantLoader", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if grant == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.Debug("applying role grant", "package", "auth", "method", "GrantLoader", "user_id", userId, "role", grant.Role, "expires_at", grant.ExpiresAt)
+		ctx := context.WithValue(r.Context(), keys.RoleKey, grant.Role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestUserId returns the id of the user making the request, or 0 if unknown
+func (m *Middleware) requestUserId(ctx context.Context) int {
+	if userId, ok := ctx.Value(keys.AuthUserIdKey).(int); ok && userId != 0 {
+		return userId
+	}
+	if username, ok := ctx.Value(keys.AuthUsernameKey).(string); ok && username != "" {
+		user, err := data.GetUserByUsername(m.db, username)
+		if err == nil {
+			return user.Id
+		}
+	}
+	return 0
+}
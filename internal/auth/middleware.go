@@ -1,18 +1,39 @@
 package auth
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
 
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/api"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/identity"
+	"github.com/lcrownover/hpcadmin-server/internal/jwks"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
 )
 
 type Middleware struct {
-	db *sql.DB
+	db       *sql.DB
+	cfg      *config.ServerConfig
+	identity *identity.Resolver
+	jwks     *jwks.Source
 }
 
-func NewMiddleware(db *sql.DB) *Middleware {
-	return &Middleware{db: db}
+// NewMiddleware creates the auth middleware. httpClient is used for outbound
+// identity and jwks lookups, and can be nil to use http.DefaultClient.
+func NewMiddleware(db *sql.DB, cfg *config.ServerConfig, httpClient *http.Client) *Middleware {
+	m := &Middleware{db: db, cfg: cfg, jwks: jwks.NewSource(cfg.Oauth.JWKS, httpClient)}
+	if cfg.Identity.UserinfoURL != "" {
+		m.identity = identity.NewResolver(cfg.Identity, httpClient)
+	}
+	return m
+}
+
+// RefreshJWKS keeps the token signing keys fresh in the background until ctx
+// is done, so no request waits on fetching them
+func (m *Middleware) RefreshJWKS(ctx context.Context) {
+	m.jwks.Run(ctx)
 }
 
 // AdminOnly middleware restricts access to just administrators.
@@ -20,7 +41,7 @@ func (m *Middleware) AdminOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		role, ok := r.Context().Value(keys.RoleKey).(string)
 		if !ok || !(role == "admin") {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			render.Render(w, r, api.ErrForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -3,13 +3,16 @@ package auth
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt"
 	"github.com/lcrownover/hpcadmin-lib/pkg/oauth"
 	"github.com/lcrownover/hpcadmin-server/internal/api"
 	"github.com/lcrownover/hpcadmin-server/internal/config"
@@ -110,28 +113,126 @@ func (m *Middleware) OauthLoader(next http.Handler) http.Handler {
 		// authorization header is set, validate header value
 		if len(bearerString) < len("Bearer ") {
 			// bearer string doesn't contain "Bearer "
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			render.Render(w, r, api.ErrUnauthorized)
 			return
 		}
 		tokenString := bearerString[len("Bearer "):]
 		slog.Debug("validating token", "package", "auth", "method", "OauthLoader")
-		jwtToken, isValid, err := ac.TokenIsValid(tokenString)
+		jwtToken, isValid, err := ac.TokenIsValid(r.Context(), tokenString, m.jwks)
+		if tokenExpired(err) {
+			slog.Debug("token is expired", "package", "auth", "method", "OauthLoader")
+			render.Render(w, r, api.ErrTokenExpired(jwt.TimeFunc()))
+			return
+		}
 		if err != nil || !isValid {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			render.Render(w, r, api.ErrUnauthorized)
+			return
+		}
+		if !m.audienceIsValid(jwtToken) {
+			slog.Debug("token was issued for another audience", "package", "auth", "method", "OauthLoader")
+			render.Render(w, r, api.ErrUnauthorized)
+			return
+		}
+		if !m.issuerIsValid(jwtToken) {
+			slog.Debug("token was issued by another issuer", "package", "auth", "method", "OauthLoader")
+			render.Render(w, r, api.ErrUnauthorized)
 			return
 		}
 		ctx := context.WithValue(r.Context(), keys.JWTTokenKey, jwtToken)
 		slog.Debug("getting role from token", "package", "auth", "method", "OauthLoader")
 		role := oauth.GetJWTRoleFromToken(jwtToken)
 		ctx = context.WithValue(ctx, keys.RoleKey, role)
+		// azure ad puts the id of the issuing tenant in the tid claim
+		if claims, ok := jwtToken.Claims.(jwt.MapClaims); ok {
+			if tenant, ok := claims["tid"].(string); ok {
+				ctx = context.WithValue(ctx, keys.TenantKey, tenant)
+			}
+			subject, username := m.tokenIdentity(r.Context(), claims, tokenString)
+			if username != "" {
+				ctx = context.WithValue(ctx, keys.AuthUsernameKey, username)
+				ctx = context.WithValue(ctx, keys.ActorKey, "oauth:"+username)
+			} else if subject != "" {
+				ctx = context.WithValue(ctx, keys.ActorKey, "oauth:"+subject)
+			}
+		}
 		if role != "admin" {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			render.Render(w, r, api.ErrForbidden)
 			return
 		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// tokenExpired reports whether parsing failed because the token expired
+func tokenExpired(err error) bool {
+	var ve *jwt.ValidationError
+	return errors.As(err, &ve) && ve.Errors&jwt.ValidationErrorExpired != 0
+}
+
+// audienceIsValid checks that the token was issued for this application.
+// Azure AD v2 tokens carry the client id as their audience, and v1 tokens
+// the default application id uri, api://{client id}.
+func (m *Middleware) audienceIsValid(token *jwt.Token) bool {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || m.cfg.Oauth.ClientID == "" {
+		return false
+	}
+	return claims.VerifyAudience(m.cfg.Oauth.ClientID, true) || claims.VerifyAudience("api://"+m.cfg.Oauth.ClientID, true)
+}
+
+// issuerIsValid checks that the token was issued by an accepted issuer, see
+// config.OauthConfig. Azure AD v1 tokens are issued by sts.windows.net and
+// v2 tokens by login.microsoftonline.com.
+func (m *Middleware) issuerIsValid(token *jwt.Token) bool {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	issuer, _ := claims["iss"].(string)
+	if issuer == "" {
+		return false
+	}
+	tenant, _ := claims["tid"].(string)
+	for _, allowed := range m.cfg.Oauth.AllowedIssuers {
+		if strings.Contains(allowed, "{tenantid}") {
+			if tenant == "" {
+				continue
+			}
+			allowed = strings.ReplaceAll(allowed, "{tenantid}", tenant)
+		}
+		if issuer == allowed {
+			return true
+		}
+	}
+	if m.cfg.Oauth.IssuerValidation == config.IssuerValidationAllowList || m.cfg.Oauth.TenantID == "" {
+		return false
+	}
+	return issuer == "https://sts.windows.net/"+m.cfg.Oauth.TenantID+"/" ||
+		issuer == "https://login.microsoftonline.com/"+m.cfg.Oauth.TenantID+"/v2.0"
+}
+
+// tokenIdentity returns the subject and username of a token. If the token
+// doesn't carry a username, it is resolved from the configured identity endpoint.
+func (m *Middleware) tokenIdentity(ctx context.Context, claims jwt.MapClaims, tokenString string) (string, string) {
+	// azure ad's oid is stable across applications, unlike sub
+	subject, _ := claims["oid"].(string)
+	if subject == "" {
+		subject, _ = claims["sub"].(string)
+	}
+	if username, ok := claims["preferred_username"].(string); ok && username != "" {
+		return subject, username
+	}
+	if m.identity == nil || subject == "" {
+		return subject, ""
+	}
+	username, err := m.identity.Resolve(ctx, subject, tokenString)
+	if err != nil {
+		slog.Warn("failed to resolve username for token subject", "package", "auth", "method", "tokenIdentity", "error", err)
+		return subject, ""
+	}
+	return subject, username
+}
+
 type InfoResponse struct {
 	TenantID string `json:"tenant_id"`
 	ClientID string `json:"client_id"`
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/jwks"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// newTestJWKSServer serves a key set holding key under kid
+func newTestJWKSServer(t *testing.T, key *rsa.PrivateKey, kid string) *httptest.Server {
+	jwkKey, err := jwk.New(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jwkKey.Set(jwk.KeyIDKey, kid); err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	set.Add(jwkKey)
+	body, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOauthLoaderAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	cfg := &config.ServerConfig{Oauth: config.OauthConfig{TenantID: "testtenant", ClientID: "testclient"}}
+	m := &Middleware{cfg: cfg, jwks: jwks.NewSource(config.JWKSConfig{URL: srv.URL}, srv.Client())}
+	h := m.OauthLoader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name string
+		aud  any
+		exp  time.Duration
+		want int
+	}{
+		{name: "ClientId", aud: "testclient", exp: time.Hour, want: http.StatusOK},
+		{name: "AppIdURI", aud: "api://testclient", exp: time.Hour, want: http.StatusOK},
+		{name: "AudienceList", aud: []string{"other", "testclient"}, exp: time.Hour, want: http.StatusOK},
+		{name: "WrongAudience", aud: "otherclient", exp: time.Hour, want: http.StatusUnauthorized},
+		{name: "MissingAudience", exp: time.Hour, want: http.StatusUnauthorized},
+		{name: "Expired", aud: "testclient", exp: -time.Hour, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{
+				"iss":                "https://login.microsoftonline.com/testtenant/v2.0",
+				"sub":                "test" + tt.name,
+				"preferred_username": "test",
+				"roles":              []string{"Role.Admin"},
+				"exp":                time.Now().Add(tt.exp).Unix(),
+			}
+			if tt.aud != nil {
+				claims["aud"] = tt.aud
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+			token.Header["kid"] = "key1"
+			signed, err := token.SignedString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			req.Header.Set("Authorization", "Bearer "+signed)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected %v got %v", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestOauthLoaderExpiredTokenServerTime(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	cfg := &config.ServerConfig{Oauth: config.OauthConfig{TenantID: "testtenant", ClientID: "testclient"}}
+	m := &Middleware{cfg: cfg, jwks: jwks.NewSource(config.JWKSConfig{URL: srv.URL}, srv.Client())}
+	h := m.OauthLoader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   "https://login.microsoftonline.com/testtenant/v2.0",
+		"sub":   "testexpired",
+		"aud":   "testclient",
+		"roles": []string{"Role.Admin"},
+		"exp":   time.Now().Add(-time.Hour).Unix(),
+	})
+	token.Header["kid"] = "key1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	before := time.Now().Add(-time.Second)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %v got %v", http.StatusUnauthorized, rec.Code)
+	}
+	var resp struct {
+		Error      string    `json:"error"`
+		Message    string    `json:"message"`
+		ServerTime time.Time `json:"server_time"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "token_expired" || resp.Message != "token is expired" || resp.ServerTime.Before(before) || resp.ServerTime.After(time.Now()) {
+		t.Errorf("expected an expired token error with the server time got %+v", resp)
+	}
+}
+
+func TestOauthLoaderIssuer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	tenantCfg := config.OauthConfig{TenantID: "testtenant", ClientID: "testclient"}
+	allowListCfg := config.OauthConfig{
+		TenantID:         "testtenant",
+		ClientID:         "testclient",
+		IssuerValidation: config.IssuerValidationAllowList,
+		AllowedIssuers:   []string{"https://login.microsoftonline.com/{tenantid}/v2.0"},
+	}
+
+	tests := []struct {
+		name  string
+		oauth config.OauthConfig
+		iss   string
+		tid   string
+		want  int
+	}{
+		{name: "TenantV2", oauth: tenantCfg, iss: "https://login.microsoftonline.com/testtenant/v2.0", want: http.StatusOK},
+		{name: "TenantV1", oauth: tenantCfg, iss: "https://sts.windows.net/testtenant/", want: http.StatusOK},
+		{name: "WrongTenant", oauth: tenantCfg, iss: "https://login.microsoftonline.com/othertenant/v2.0", tid: "othertenant", want: http.StatusUnauthorized},
+		{name: "MissingIssuer", oauth: tenantCfg, want: http.StatusUnauthorized},
+		{name: "AllowListTenant", oauth: allowListCfg, iss: "https://login.microsoftonline.com/othertenant/v2.0", tid: "othertenant", want: http.StatusOK},
+		{name: "AllowListTenantMismatch", oauth: allowListCfg, iss: "https://login.microsoftonline.com/othertenant/v2.0", tid: "thirdtenant", want: http.StatusUnauthorized},
+		{name: "AllowListV1", oauth: allowListCfg, iss: "https://sts.windows.net/testtenant/", tid: "testtenant", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ServerConfig{Oauth: tt.oauth}
+			m := &Middleware{cfg: cfg, jwks: jwks.NewSource(config.JWKSConfig{URL: srv.URL}, srv.Client())}
+			h := m.OauthLoader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			claims := jwt.MapClaims{
+				"aud":                "testclient",
+				"sub":                "test" + tt.name,
+				"preferred_username": "test",
+				"roles":              []string{"Role.Admin"},
+				"exp":                time.Now().Add(time.Hour).Unix(),
+			}
+			if tt.iss != "" {
+				claims["iss"] = tt.iss
+			}
+			if tt.tid != "" {
+				claims["tid"] = tt.tid
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+			token.Header["kid"] = "key1"
+			signed, err := token.SignedString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			req.Header.Set("Authorization", "Bearer "+signed)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected %v got %v", tt.want, rec.Code)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/api"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
 )
 
@@ -21,17 +23,17 @@ func (m *Middleware) RoleVerifier(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		role, ok := r.Context().Value(keys.RoleKey).(string)
 		if !ok {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusUnauthorized)
+			render.Render(w, r, api.ErrUnauthorized)
 			return
 		}
 		if role == "" {
 			slog.Debug("role is empty", "package", "auth", "method", "RoleVerifier")
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusUnauthorized)
+			render.Render(w, r, api.ErrUnauthorized)
 			return
 		}
 		if role == "unknown" {
 			slog.Debug("role is unknown", "package", "auth", "method", "RoleVerifier")
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusUnauthorized)
+			render.Render(w, r, api.ErrUnauthorized)
 			return
 		}
 		slog.Debug("role is valid", "package", "auth", "method", "RoleVerifier")
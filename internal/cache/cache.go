@@ -0,0 +1,188 @@
+// Package cache is a fail-open cache in front of a pluggable backend.
+// A backend that errors is treated as a miss so callers fall through to the
+// database, and after repeated failures it's left alone for a cooldown.
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCooldown         = 30 * time.Second
+)
+
+// Backend stores cached values, for example in memory or in redis.
+// Get reports whether the key was found.
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Options configure the circuit breaker. After FailureThreshold consecutive
+// backend errors, by default 5, the backend isn't called for Cooldown, by
+// default 30s. Then a single call is let through to see if it's back.
+type Options struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// Cache wraps a backend so its errors never reach callers
+type Cache struct {
+	backend Backend
+	breaker *breaker
+}
+
+func New(backend Backend, opts Options) *Cache {
+	if opts.FailureThreshold == 0 {
+		opts.FailureThreshold = defaultFailureThreshold
+	}
+	if opts.Cooldown == 0 {
+		opts.Cooldown = defaultCooldown
+	}
+	return &Cache{backend: backend, breaker: newBreaker(opts.FailureThreshold, opts.Cooldown, time.Now)}
+}
+
+// Get returns the cached value. A backend error, or an open breaker, is a miss.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool) {
+	if !c.breaker.allow() {
+		return nil, false
+	}
+	value, ok, err := c.backend.Get(ctx, key)
+	c.breaker.record(err)
+	if err != nil {
+		slog.Warn("cache get failed, treating as a miss", "key", key, "package", "cache", "method", "Get", "error", err)
+		return nil, false
+	}
+	return value, ok
+}
+
+// Set caches the value. Backend errors are logged and otherwise ignored.
+func (c *Cache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if !c.breaker.allow() {
+		return
+	}
+	err := c.backend.Set(ctx, key, value, ttl)
+	c.breaker.record(err)
+	if err != nil {
+		slog.Warn("cache set failed", "key", key, "package", "cache", "method", "Set", "error", err)
+	}
+}
+
+// GetOrLoad returns the cached value, or calls load and caches what it returns.
+// Only errors from load are returned.
+func (c *Cache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load func(context.Context) ([]byte, error)) ([]byte, error) {
+	if value, ok := c.Get(ctx, key); ok {
+		return value, nil
+	}
+	value, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(ctx, key, value, ttl)
+	return value, nil
+}
+
+// Open reports whether the breaker is currently skipping the backend
+func (c *Cache) Open() bool {
+	return c.breaker.isOpen()
+}
+
+// breaker counts consecutive failures and opens once there are threshold of
+// them. While open nothing is allowed until the cooldown passes, then one
+// trial call is: success closes the breaker, failure opens it again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration, now func() time.Time) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: now}
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			slog.Error("cache backend failing, skipping it", "cooldown", b.cooldown, "package", "cache", "method", "record")
+		}
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold
+}
+
+// Memory is an in-process Backend, values expire after their ttl
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]memoryEntry{}, now: time.Now}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !e.expiresAt.IsZero() && !m.now().Before(e.expiresAt) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Set stores the value, a ttl of 0 keeps it until it's replaced
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expiresAt = m.now().Add(ttl)
+	}
+	m.entries[key] = e
+	return nil
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyBackend wraps a Memory backend, failing every call while err is set
+type flakyBackend struct {
+	*Memory
+	err   error
+	calls int
+}
+
+func (f *flakyBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	return f.Memory.Get(ctx, key)
+}
+
+func (f *flakyBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	return f.Memory.Set(ctx, key, value, ttl)
+}
+
+func TestGetOrLoadFallsThroughOnBackendError(t *testing.T) {
+	backend := &flakyBackend{Memory: NewMemory(), err: errors.New("connection refused")}
+	c := New(backend, Options{})
+	loads := 0
+	load := func(context.Context) ([]byte, error) {
+		loads++
+		return []byte("from db"), nil
+	}
+
+	value, err := c.GetOrLoad(context.Background(), "user:1", time.Minute, load)
+	if err != nil {
+		t.Fatalf("expected the backend error to be hidden got %v", err)
+	}
+	if string(value) != "from db" || loads != 1 {
+		t.Fatalf("expected the value to be loaded from the db got %q after %v loads", value, loads)
+	}
+
+	// once the backend is back the loaded value is cached
+	backend.err = nil
+	if _, err = c.GetOrLoad(context.Background(), "user:1", time.Minute, load); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetOrLoad(context.Background(), "user:1", time.Minute, load); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 2 {
+		t.Fatalf("expected the second get to be served from the cache got %v loads", loads)
+	}
+
+	// load errors are still returned
+	loadErr := errors.New("db is down")
+	if _, err = c.GetOrLoad(context.Background(), "user:2", time.Minute, func(context.Context) ([]byte, error) { return nil, loadErr }); !errors.Is(err, loadErr) {
+		t.Fatalf("expected the load error got %v", err)
+	}
+}
+
+func TestBreakerOpensAfterRepeatedFailures(t *testing.T) {
+	backend := &flakyBackend{Memory: NewMemory(), err: errors.New("timeout")}
+	c := New(backend, Options{FailureThreshold: 3, Cooldown: time.Minute})
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, ok := c.Get(ctx, "key"); ok {
+			t.Fatal("expected a miss from a failing backend")
+		}
+	}
+	if !c.Open() {
+		t.Fatal("expected the breaker to open after 3 failures")
+	}
+	// while open the backend isn't called at all
+	c.Get(ctx, "key")
+	c.Set(ctx, "key", []byte("value"), 0)
+	if backend.calls != 3 {
+		t.Fatalf("expected no backend calls while open got %v", backend.calls)
+	}
+
+	// after the cooldown one trial call is let through, and failing reopens it
+	now = now.Add(time.Minute)
+	c.Get(ctx, "key")
+	if backend.calls != 4 || !c.Open() {
+		t.Fatalf("expected one failed trial call got %v calls, open %v", backend.calls, c.Open())
+	}
+	c.Get(ctx, "key")
+	if backend.calls != 4 {
+		t.Fatalf("expected the failed trial to restart the cooldown got %v calls", backend.calls)
+	}
+
+	// a successful trial closes it
+	backend.err = nil
+	now = now.Add(time.Minute)
+	c.Set(ctx, "key", []byte("value"), 0)
+	if c.Open() {
+		t.Fatal("expected a successful trial to close the breaker")
+	}
+	if value, ok := c.Get(ctx, "key"); !ok || string(value) != "value" {
+		t.Fatalf("expected the cached value got %q %v", value, ok)
+	}
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+	m.Set(ctx, "short", []byte("a"), time.Second)
+	m.Set(ctx, "forever", []byte("b"), 0)
+	now = now.Add(time.Second)
+	if _, ok, _ := m.Get(ctx, "short"); ok {
+		t.Error("expected the entry to expire after its ttl")
+	}
+	if _, ok, _ := m.Get(ctx, "forever"); !ok {
+		t.Error("expected an entry without a ttl to be kept")
+	}
+}
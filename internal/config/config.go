@@ -1,10 +1,18 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/mail"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,12 +22,243 @@ type ServerConfig struct {
 	Port  int            `yaml:"port"`
 	Oauth OauthConfig    `yaml:"oauth"`
 	DB    DatabaseConfig `yaml:"database"`
+	TLS   TLSConfig      `yaml:"tls"`
+
+	Partitions []PartitionConfig `yaml:"partitions"`
+
+	// MaxHeaderBytes caps the size of request headers, 0 uses the net/http default of 1MB
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+
+	// MaxRequestBodyBytes caps the size of /api/v1 request bodies, larger ones
+	// get a 413. 0 uses the default of 1MB.
+	MaxRequestBodyBytes int64 `yaml:"max_request_body_bytes"`
+
+	Timeouts TimeoutConfig `yaml:"timeouts"`
+
+	// AllowedHosts are the only Host header values requests are served for,
+	// with or without a port. Health checks are exempt. Empty allows any host.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+
+	// DefaultPirg is the name of a pirg that every new user is added to
+	DefaultPirg string `yaml:"default_pirg"`
+
+	// OrphanedPirgOwner is the username of a user that takes over the pirgs of
+	// deleted users. If it's empty, users who own pirgs can't be deleted.
+	OrphanedPirgOwner string `yaml:"orphaned_pirg_owner"`
+
+	// ReconcileBatchSize is how many membership changes each transaction of a
+	// reconcile applies, so large pirgs aren't locked for the whole reconcile.
+	// 0 applies every change in one transaction.
+	ReconcileBatchSize int `yaml:"reconcile_batch_size"`
+
+	// DBWarmupConnections is the number of database connections opened
+	// before the server starts listening, capped at the database max_open_conns
+	DBWarmupConnections int `yaml:"db_warmup_connections"`
+
+	// DBQueryTimeoutSeconds cancels database queries that run longer, and the
+	// request gets a 504. 0 uses the default of 10 seconds.
+	DBQueryTimeoutSeconds int `yaml:"db_query_timeout_seconds"`
+
+	// InputSanitization is what's done with control characters and null bytes
+	// in the strings of user and pirg request bodies, InputSanitizationReject
+	// (the default) answers 422, InputSanitizationStrip removes them
+	InputSanitization string `yaml:"input_sanitization"`
+
+	// StripEmailPlusTags removes +tags from user emails when they're normalized,
+	// so foo+hpc@example.com and foo@example.com are the same user.
+	// Emails are always trimmed and lowercased.
+	StripEmailPlusTags bool `yaml:"strip_email_plus_tags"`
+
+	// MaskedUserFields are the fields of user responses, any of
+	// MaskableUserFields, that are blanked for callers who aren't admins
+	// unless it's their own user. Unset masks email, an empty list nothing.
+	MaskedUserFields []string `yaml:"masked_user_fields"`
+
+	FeatureFlags map[string]FeatureFlagConfig `yaml:"feature_flags"`
+
+	HTTPClient HTTPClientConfig `yaml:"http_client"`
+
+	Pagination PaginationConfig `yaml:"pagination"`
+
+	// ListEnvelope controls whether paginated list endpoints wrap their items
+	// in the {items,total,limit,offset} envelope or return the bare array.
+	// Defaults to the envelope, and requests can override it with ?envelope=.
+	ListEnvelope *bool `yaml:"list_envelope"`
+
+	// StreamListThreshold is how many items an unpaginated list such as
+	// GET /users can have before it's streamed instead of encoded in memory,
+	// default 1000. Only lists encoded in memory carry an ETag.
+	StreamListThreshold int `yaml:"stream_list_threshold"`
+
+	Identity IdentityConfig `yaml:"identity"`
+
+	LDAP LDAPConfig `yaml:"ldap"`
+
+	PosixIds PosixIdConfig `yaml:"posix_ids"`
+
+	Provisioning ProvisioningConfig `yaml:"provisioning"`
+
+	Fairshare FairshareConfig `yaml:"fairshare"`
+
+	Slurm SlurmConfig `yaml:"slurm"`
+
+	Webhooks WebhookConfig `yaml:"webhooks"`
+
+	// MembershipSweepInterval is how often expired pirg memberships are deleted.
+	// They stop counting as members as soon as they expire regardless.
+	MembershipSweepInterval time.Duration `yaml:"membership_sweep_interval"`
+
+	// MaxConcurrentJobs is how many background jobs, such as the sweeps and
+	// audit retention, can run at once, default 2. The rest wait their turn.
+	MaxConcurrentJobs int `yaml:"max_concurrent_jobs"`
+
+	// AuditRetentionDays is how many days audit events are kept, 0 keeps them forever.
+	// The retention job runs every AuditRetentionInterval, by default daily, and
+	// if AuditArchiveDir is set it writes the deleted events there first.
+	AuditRetentionDays     int           `yaml:"audit_retention_days"`
+	AuditRetentionInterval time.Duration `yaml:"audit_retention_interval"`
+	AuditArchiveDir        string        `yaml:"audit_archive_dir"`
+
+	// AuditSinks are where audit events are forwarded to besides the database
+	AuditSinks AuditSinkConfig `yaml:"audit_sinks"`
+
+	Notifications NotificationConfig `yaml:"notifications"`
+
+	// RecentErrors is how many 5xx responses /admin/errors/recent keeps, default 100
+	RecentErrors int `yaml:"recent_errors"`
+
+	// ExposeInternalErrors includes the underlying error in 500 responses,
+	// which is useful in development. Otherwise responses only carry the
+	// request id to find the error in the server log.
+	ExposeInternalErrors bool `yaml:"expose_internal_errors"`
+
+	// IncludeCharset adds "; charset=utf-8" to the Content-Type of text
+	// responses, such as JSON, for clients that won't assume utf-8
+	IncludeCharset bool `yaml:"include_charset"`
+
+	CORS CORSConfig `yaml:"cors"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// RequestIdFormat is how request ids are generated when a request doesn't
+	// send X-Request-Id, RequestIdFormatChi (the default) or RequestIdFormatUUID
+	RequestIdFormat string `yaml:"request_id_format"`
+
+	// LogLevel is the lowest level logged, LogLevelDebug, LogLevelInfo (the
+	// default), LogLevelWarn or LogLevelError. The -debug flag overrides it.
+	LogLevel string `yaml:"log_level"`
+
+	// TrailingSlash is how paths ending in a slash are routed,
+	// TrailingSlashStrict (the default), TrailingSlashStrip or TrailingSlashRedirect
+	TrailingSlash string `yaml:"trailing_slash"`
+
+	// LogQueries logs every database query at debug level.
+	// Argument values are redacted to their type and length.
+	LogQueries bool `yaml:"log_queries"`
+
+	// ReadAuditRoutes are the chi route patterns, such as /api/v1/users/{userID},
+	// whose successful GETs are recorded in the audit log with who made them.
+	// Nothing is audited by default.
+	ReadAuditRoutes []string `yaml:"read_audit_routes"`
+
+	// MigrationsPath is the directory of database migrations the -migrate
+	// flag applies, by default /etc/hpcadmin-server/migrations
+	MigrationsPath string `yaml:"migrations_path"`
+
+	Metrics MetricsConfig `yaml:"metrics"`
+
+	Debug DebugConfig `yaml:"debug"`
 }
 
+// Database sslmodes, as libpq accepts them
+const (
+	DBSSLModeDisable    = "disable"
+	DBSSLModeRequire    = "require"
+	DBSSLModeVerifyCA   = "verify-ca"
+	DBSSLModeVerifyFull = "verify-full"
+)
+
+// Database drivers. pq is lib/pq, pgx is pgx over a pgxpool.
+const (
+	DBDriverPq  = "pq"
+	DBDriverPgx = "pgx"
+)
+
+// Log levels, as slog names them
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// Request id formats. chi-default is the hostname, a random prefix and a counter,
+// and uuid is a random UUIDv4.
+const (
+	RequestIdFormatChi  = "chi-default"
+	RequestIdFormatUUID = "uuid"
+)
+
+// MaskableUserFields are the json names of the user response fields that
+// masked_user_fields can hide
+var MaskableUserFields = []string{"email", "firstname", "lastname", "last_login_at"}
+
+// Trailing slash handling. strict routes /users/ and /users as different paths,
+// strip routes /users/ as /users, and redirect sends a redirect to /users.
+const (
+	TrailingSlashStrict   = "strict"
+	TrailingSlashStrip    = "strip"
+	TrailingSlashRedirect = "redirect"
+)
+
+// Input sanitization modes. reject refuses request bodies with control
+// characters or null bytes in their strings, and strip removes them.
+const (
+	InputSanitizationReject = "reject"
+	InputSanitizationStrip  = "strip"
+)
+
+// Issuer validation modes. tenant accepts tokens issued by the configured
+// tenant, and allow_list only the allowed issuers, for multi-tenant apps.
+const (
+	IssuerValidationTenant    = "tenant"
+	IssuerValidationAllowList = "allow_list"
+)
+
+// Metrics backends. prometheus serves /metrics for scraping, and statsd sends
+// the same metrics to a StatsD server.
+const (
+	MetricsBackendPrometheus = "prometheus"
+	MetricsBackendStatsD     = "statsd"
+)
+
+// OauthConfig is the Azure AD application tokens are issued for.
+// IssuerValidation is how a token's iss claim is checked. In
+// IssuerValidationTenant mode, the default, it must be the v1 or v2 issuer of
+// TenantID or one of AllowedIssuers, and in IssuerValidationAllowList mode
+// one of AllowedIssuers. An allowed issuer can hold {tenantid}, which matches
+// the tenant the token names in its tid claim.
 type OauthConfig struct {
-	TenantID     string `yaml:"tenant_id"`
-	ClientID     string `yaml:"client_id"`
-	ClientSecret string `yaml:"client_secret"`
+	TenantID         string     `yaml:"tenant_id"`
+	ClientID         string     `yaml:"client_id"`
+	ClientSecret     string     `yaml:"client_secret"`
+	JWKS             JWKSConfig `yaml:"jwks"`
+	IssuerValidation string     `yaml:"issuer_validation"`
+	AllowedIssuers   []string   `yaml:"allowed_issuers"`
+	// VerifyTimeout is how long -check-oauth waits for the tenant's
+	// discovery document, see VerifyOauth, by default DefaultOauthVerifyTimeout
+	VerifyTimeout time.Duration `yaml:"verify_timeout"`
+}
+
+// JWKSConfig is where token signing keys are fetched from, by default Azure AD.
+// Keys are refetched in the background every RefreshInterval, by default
+// hourly, less up to a fifth of it so replicas don't fetch together. If a
+// refetch fails the last keys are used for up to MaxStaleness longer, 0
+// rejects every token until a refetch succeeds.
+type JWKSConfig struct {
+	URL             string        `yaml:"url"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	MaxStaleness    time.Duration `yaml:"max_staleness"`
 }
 
 type DatabaseConfig struct {
@@ -28,6 +267,327 @@ type DatabaseConfig struct {
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	DBName   string `yaml:"dbname"`
+
+	// Driver is the postgres driver, pq (the default) or pgx. With pgx the
+	// connections are pooled by a pgxpool, sized by MaxOpenConns.
+	Driver string `yaml:"driver"`
+
+	// SSLMode is the libpq sslmode, by default disable. verify-ca and
+	// verify-full need SSLRootCert, the CA the server's cert is checked
+	// against. SSLCert and SSLKey are a client cert, if the server wants one.
+	SSLMode     string `yaml:"sslmode"`
+	SSLRootCert string `yaml:"sslrootcert"`
+	SSLCert     string `yaml:"sslcert"`
+	SSLKey      string `yaml:"sslkey"`
+
+	// MaxOpenConns limits the connection pool size, by default 25. 0 is
+	// unlimited, or with pgx pgxpool's default of 4 or the number of CPUs if
+	// that's more. MaxIdleConns is how many of them are kept open while idle,
+	// at most MaxOpenConns, and 0 is 5 or MaxOpenConns if that's less. With
+	// pgx the pgxpool keeps its own. Connections are closed after
+	// ConnMaxLifetimeSeconds, by default 30 minutes, and 0 keeps them forever.
+	MaxOpenConns           int `yaml:"max_open_conns"`
+	MaxIdleConns           int `yaml:"max_idle_conns"`
+	ConnMaxLifetimeSeconds int `yaml:"conn_max_lifetime_seconds"`
+
+	// ConnectAttempts is how many times connecting at startup is tried while
+	// the database refuses connections, by default 10. 1 fails fast.
+	// ConnectBackoff is the first wait between attempts, by default 500ms,
+	// and doubles after each one.
+	ConnectAttempts int           `yaml:"connect_attempts"`
+	ConnectBackoff  time.Duration `yaml:"connect_backoff"`
+}
+
+// TLSConfig enables serving over TLS, and optionally verifying client certificates.
+// ClientCertRoles maps a client certificate identity (subject CN or a SAN)
+// to the role it is granted.
+//
+// EnforceHTTPS redirects cleartext requests, other than health checks, to
+// https and sets Strict-Transport-Security on TLS responses, for HSTSMaxAge,
+// by default a year. HTTPPort is a port also served over plain http, so
+// those requests can be redirected and health checks don't need TLS.
+type TLSConfig struct {
+	CertFile          string            `yaml:"cert_file"`
+	KeyFile           string            `yaml:"key_file"`
+	ClientCAFile      string            `yaml:"client_ca_file"`
+	RequireClientCert bool              `yaml:"require_client_cert"`
+	ClientCertRoles   map[string]string `yaml:"client_cert_roles"`
+	EnforceHTTPS      bool              `yaml:"enforce_https"`
+	HSTSMaxAge        time.Duration     `yaml:"hsts_max_age"`
+	HTTPPort          int               `yaml:"http_port"`
+}
+
+// PartitionConfig describes a scheduler partition that pirgs can be assigned to
+type PartitionConfig struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	MaxNodes    int               `yaml:"max_nodes"`
+	MaxCPUs     int               `yaml:"max_cpus"`
+	MaxWallTime string            `yaml:"max_walltime"`
+	Metadata    map[string]string `yaml:"metadata"`
+}
+
+// TimeoutConfig bounds how long the http server waits on a connection.
+// Zero values use the defaults in newServer. WriteTimeout also limits
+// streamed responses such as the audit export. ShutdownTimeout is how long
+// in-flight requests get to finish after SIGINT or SIGTERM, and
+// JobsShutdownTimeout how long background jobs get after that.
+type TimeoutConfig struct {
+	ReadTimeout         time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout   time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout        time.Duration `yaml:"write_timeout"`
+	IdleTimeout         time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout"`
+	JobsShutdownTimeout time.Duration `yaml:"jobs_shutdown_timeout"`
+}
+
+// HTTPClientConfig tunes the http client shared by outbound integrations.
+// Zero values fall back to the defaults in the httpclient package.
+type HTTPClientConfig struct {
+	DialTimeout     time.Duration `yaml:"dial_timeout"`
+	ResponseTimeout time.Duration `yaml:"response_timeout"`
+	Timeout         time.Duration `yaml:"timeout"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	// Proxy is the url of an http proxy. If empty, the standard
+	// HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables apply.
+	Proxy string `yaml:"proxy"`
+}
+
+// IdentityConfig resolves usernames for tokens that only carry an opaque subject.
+// UserinfoURL is an OIDC userinfo endpoint or https://graph.microsoft.com/v1.0/me,
+// and UsernameField is the response field holding the username
+// (userPrincipalName for Graph). Resolution is disabled if UserinfoURL is empty.
+type IdentityConfig struct {
+	UserinfoURL   string        `yaml:"userinfo_url"`
+	UsernameField string        `yaml:"username_field"`
+	CacheTTL      time.Duration `yaml:"cache_ttl"`
+}
+
+// LDAPConfig is the directory, such as Active Directory, POST /admin/sync/ldap
+// reconciles the users table against. URL is ldap:// or ldaps://, and syncing
+// is disabled while it's unset. Users are read from under BaseDN matching
+// Filter, by default (&(objectClass=user)(objectCategory=person)).
+type LDAPConfig struct {
+	URL          string              `yaml:"url"`
+	BindDN       string              `yaml:"bind_dn"`
+	BindPassword string              `yaml:"bind_password"`
+	BaseDN       string              `yaml:"base_dn"`
+	Filter       string              `yaml:"filter"`
+	Attributes   LDAPAttributeConfig `yaml:"attributes"`
+}
+
+// LDAPAttributeConfig names the directory attributes user fields are read
+// from. They default to the Active Directory ones: sAMAccountName, mail,
+// givenName and sn.
+type LDAPAttributeConfig struct {
+	Username  string `yaml:"username"`
+	Email     string `yaml:"email"`
+	FirstName string `yaml:"firstname"`
+	LastName  string `yaml:"lastname"`
+}
+
+// PaginationConfig sets the page size of paginated list endpoints.
+// DefaultLimit applies when a request doesn't pass limit, and larger
+// requested limits are reduced to MaxLimit. Zero values use the api defaults.
+// Roles overrides the limits for requests authenticated with the role,
+// such as a service account role that syncs with large pages.
+type PaginationConfig struct {
+	DefaultLimit int                             `yaml:"default_limit"`
+	MaxLimit     int                             `yaml:"max_limit"`
+	Roles        map[string]RolePaginationConfig `yaml:"roles"`
+}
+
+// RolePaginationConfig overrides the page size for a role.
+// Zero values fall back to the global pagination limits.
+type RolePaginationConfig struct {
+	DefaultLimit int `yaml:"default_limit"`
+	MaxLimit     int `yaml:"max_limit"`
+}
+
+// PosixIdConfig sets the inclusive ranges uids and gids are allocated from.
+// Allocation of a kind is disabled while its range is unset.
+//
+// Uids are otherwise allocated when a pirg is provisioned. With
+// AllocateUidOnCreate new users get theirs as they're created, and a
+// user.provision webhook event carries it along with DefaultGid, for the
+// automation creating home directories.
+type PosixIdConfig struct {
+	UidMin int `yaml:"uid_min"`
+	UidMax int `yaml:"uid_max"`
+	GidMin int `yaml:"gid_min"`
+	GidMax int `yaml:"gid_max"`
+
+	AllocateUidOnCreate bool `yaml:"allocate_uid_on_create"`
+	DefaultGid          int  `yaml:"default_gid"`
+}
+
+// ProvisioningConfig renders the script that creates a pirg's directories.
+// ScriptTemplate is the path of a text/template file, empty uses the built in
+// template. Directories are created under BasePath, by default /projects, and
+// Quota is passed to the template as is for every pirg.
+type ProvisioningConfig struct {
+	ScriptTemplate string `yaml:"script_template"`
+	BasePath       string `yaml:"base_path"`
+	Quota          string `yaml:"quota"`
+}
+
+// FairshareConfig sets the Slurm fair-share weights /admin/export/fairshare
+// exports. Each pirg's share of Total, default 10000, is proportional to its
+// allocation in Allocations by pirg name, or to DefaultAllocation, default 1,
+// if it isn't listed there.
+type FairshareConfig struct {
+	Total             int            `yaml:"total"`
+	DefaultAllocation *int           `yaml:"default_allocation"`
+	Allocations       map[string]int `yaml:"allocations"`
+}
+
+// SlurmConfig is how pirg changes are provisioned in Slurm, where each pirg
+// is an account and its members are users associated with it. It's off
+// unless Enabled is set. Changes are made by running Command, by default
+// sacctmgr, or if URL is set through the slurmrestd there, authenticating as
+// User with Token. Cluster limits the changes to one cluster.
+type SlurmConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Command string `yaml:"command"`
+	URL     string `yaml:"url"`
+	User    string `yaml:"user"`
+	Token   string `yaml:"token"`
+	Cluster string `yaml:"cluster"`
+}
+
+// WebhookConfig is where user and pirg lifecycle events are POSTed, to every
+// one of URLs, signed with Secret. Webhooks are off while URLs is empty.
+// Deliveries that time out or get a 5xx are retried, up to MaxAttempts
+// in all, default 3. Up to QueueSize events, default 1000, wait to be
+// delivered, and more are dropped.
+type WebhookConfig struct {
+	URLs        []string `yaml:"urls"`
+	Secret      string   `yaml:"secret"`
+	MaxAttempts int      `yaml:"max_attempts"`
+	QueueSize   int      `yaml:"queue_size"`
+}
+
+// NotificationConfig is how notifications are delivered.
+// They're sent through the SMTP server, and disabled while its host is unset.
+type NotificationConfig struct {
+	SMTP  SMTPConfig             `yaml:"smtp"`
+	Pirgs PirgNotificationConfig `yaml:"pirgs"`
+}
+
+// PirgNotificationConfig notifies people of changes to the members of pirgs.
+// While Enabled, each change notifies the pirg's owner, unless SkipOwner is
+// set, and the addresses Recipients has under the pirg's name.
+type PirgNotificationConfig struct {
+	Enabled    bool                `yaml:"enabled"`
+	SkipOwner  bool                `yaml:"skip_owner"`
+	Recipients map[string][]string `yaml:"recipients"`
+}
+
+// SMTPConfig is the server notifications are sent through. Port defaults to
+// 587, and Username and Password are only needed if the server requires auth.
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// AuditSinkConfig is where audit events are forwarded to. The database always
+// records them, and every sink that's configured gets a copy, syslog while
+// Syslog.Enabled is set and the webhook while HTTP.URL is set. New events are
+// forwarded every Interval, by default every second.
+type AuditSinkConfig struct {
+	Interval time.Duration    `yaml:"interval"`
+	Syslog   SyslogSinkConfig `yaml:"syslog"`
+	HTTP     HTTPSinkConfig   `yaml:"http"`
+}
+
+// SyslogSinkConfig is the syslog server audit events are sent to. Network and
+// Address are passed to syslog.Dial, so leaving them unset uses the local
+// syslog. Tag defaults to hpcadmin-server.
+type SyslogSinkConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	Tag     string `yaml:"tag"`
+}
+
+// HTTPSinkConfig is the webhook audit events are POSTed to, one JSON event
+// per request. Headers are added to every request, for example for auth.
+type HTTPSinkConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// CORSConfig lets browsers on other origins call /api/v1. It's off while
+// AllowedOrigins is empty, and "*" allows every origin. AllowedMethods
+// defaults to GET, POST, PUT, PATCH and DELETE. AllowCredentials lets the
+// browser send cookies and auth headers, and can't be used with "*".
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+}
+
+// RateLimitConfig limits how many requests each client, the authenticated
+// actor or otherwise the remote address, can make to /api/v1. It's off while
+// RequestsPerMinute is 0. Burst is how many can be made at once, by default
+// RequestsPerMinute. The limits are kept by each instance.
+type RateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	Burst             int `yaml:"burst"`
+}
+
+// DebugConfig is for debugging the running server. PprofEnabled serves the
+// net/http/pprof profiles at /admin/debug/pprof and runtime stats at
+// /admin/debug/vars, for admins only. They're off by default, and 404 unless enabled.
+type DebugConfig struct {
+	PprofEnabled bool `yaml:"pprof_enabled"`
+}
+
+// MetricsConfig is where request and database pool metrics are exported.
+// Backends can hold both MetricsBackendPrometheus and MetricsBackendStatsD,
+// and if it's empty only prometheus is enabled.
+type MetricsConfig struct {
+	Backends []string     `yaml:"backends"`
+	StatsD   StatsDConfig `yaml:"statsd"`
+}
+
+// StatsDConfig is the StatsD server metrics are sent to over udp. Metric names
+// start with Prefix, by default hpcadmin, and the database pool stats are sent
+// every Interval, by default every 10s.
+type StatsDConfig struct {
+	Address  string        `yaml:"address"`
+	Prefix   string        `yaml:"prefix"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// FeatureFlagConfig controls who can reach the routes behind a feature flag.
+// The flag is on for everyone if Enabled is set, otherwise only for
+// requests whose role or tenant is listed.
+type FeatureFlagConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Roles   []string `yaml:"roles"`
+	Tenants []string `yaml:"tenants"`
+}
+
+// Defaults is the config the file and then the environment are applied on top
+// of, so the precedence is defaults < file < environment. LoadFile and Parse
+// start from it, settings missing from the file or left empty keep their
+// default, and LoadEnvironment then overrides whatever is set in the environment.
+func Defaults() *ServerConfig {
+	return &ServerConfig{
+		Host: "0.0.0.0",
+		Port: 3333,
+		DB: DatabaseConfig{
+			Host:                   "localhost",
+			Port:                   5432,
+			SSLMode:                DBSSLModeDisable,
+			MaxOpenConns:           25,
+			ConnMaxLifetimeSeconds: 1800,
+		},
+	}
 }
 
 // Load loads the configuration from the given path
@@ -35,8 +595,8 @@ type DatabaseConfig struct {
 // file from /etc/hpcadmin-server/config.yaml
 func LoadFile(configPath string) (*ServerConfig, error) {
 	var err error
-	cfg := &ServerConfig{}
-	// If configPath wasn't provided, and the file doesn't exist, just return an empty config
+	cfg := Defaults()
+	// If configPath wasn't provided, and the file doesn't exist, just return the defaults
 	if configPath == "" {
 		configPath = "/etc/hpcadmin-server/config.yaml"
 	}
@@ -54,15 +614,34 @@ func LoadFile(configPath string) (*ServerConfig, error) {
 	}
 
 	slog.Debug("parsing YAML", "package", "config", "method", "Load", "path", configPath)
-	err = yaml.Unmarshal(configData, cfg)
-	if err != nil {
+	return Parse(configData, false)
+}
+
+// Parse parses a YAML (or JSON) configuration on top of Defaults. With
+// knownFields set, fields that don't exist in ServerConfig are an error.
+func Parse(configData []byte, knownFields bool) (*ServerConfig, error) {
+	cfg := Defaults()
+	dec := yaml.NewDecoder(bytes.NewReader(configData))
+	dec.KnownFields(knownFields)
+	// an empty document decodes as io.EOF, which is the same as an empty config
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
 		return nil, fmt.Errorf("failed to load configuration: %v", err)
 	}
-
 	return cfg, nil
 }
 
-func LoadEnvironment(cfg *ServerConfig) *ServerConfig {
+// LoadEnvironment overrides cfg with the HPCADMIN_SERVER_* variables that are
+// set, which take precedence over both the file and the defaults.
+//
+// Secrets can also be read from files, the *_FILE convention of docker and
+// kubernetes secret mounts. HPCADMIN_SERVER_DATABASE_PASSWORD_FILE and
+// HPCADMIN_SERVER_OAUTH_CLIENT_SECRET_FILE,
+// HPCADMIN_SERVER_LDAP_BIND_PASSWORD_FILE,
+// HPCADMIN_SERVER_SLURM_TOKEN_FILE and
+// HPCADMIN_SERVER_WEBHOOKS_SECRET_FILE take precedence over the inline
+// variables, and a file that can't be read is an error rather than falling
+// back to another source.
+func LoadEnvironment(cfg *ServerConfig) (*ServerConfig, error) {
 	// HPCADMIN_SERVER_HOST
 	if host, found := os.LookupEnv("HPCADMIN_SERVER_HOST"); found {
 		slog.Debug("found host override", "package", "config", "method", "LoadEnvironment", "host", host)
@@ -99,13 +678,22 @@ func LoadEnvironment(cfg *ServerConfig) *ServerConfig {
 		cfg.DB.User = dbuser
 	}
 	// HPCADMIN_SERVER_DATABASE_PASSWORD
-	if dbpassword, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_USER"); found {
-		slog.Debug("found database user override", "package", "config", "method", "LoadEnvironment", "password", "REDACTED")
+	if dbpassword, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_PASSWORD"); found {
+		slog.Debug("found database password override", "package", "config", "method", "LoadEnvironment", "password", "REDACTED")
+		cfg.DB.Password = dbpassword
+	}
+	// HPCADMIN_SERVER_DATABASE_PASSWORD_FILE
+	if path, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_PASSWORD_FILE"); found {
+		slog.Debug("found database password file override", "package", "config", "method", "LoadEnvironment", "path", path)
+		dbpassword, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HPCADMIN_SERVER_DATABASE_PASSWORD_FILE: %v", err)
+		}
 		cfg.DB.Password = dbpassword
 	}
 	// HPCADMIN_SERVER_DATABASE_DBNAME
 	if dbname, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_DBNAME"); found {
-		slog.Debug("found database user override", "package", "config", "method", "LoadEnvironment", "dbname", dbname)
+		slog.Debug("found database name override", "package", "config", "method", "LoadEnvironment", "dbname", dbname)
 		cfg.DB.DBName = dbname
 	}
 	// HPCADMIN_SERVER_OAUTH_TENANT_ID
@@ -123,39 +711,396 @@ func LoadEnvironment(cfg *ServerConfig) *ServerConfig {
 		slog.Debug("found oauth clientSecret override", "package", "config", "method", "LoadEnvironment", "clientSecret", "REDACTED")
 		cfg.Oauth.ClientSecret = clientSecret
 	}
-	return cfg
+	// HPCADMIN_SERVER_OAUTH_CLIENT_SECRET_FILE
+	if path, found := os.LookupEnv("HPCADMIN_SERVER_OAUTH_CLIENT_SECRET_FILE"); found {
+		slog.Debug("found oauth clientSecret file override", "package", "config", "method", "LoadEnvironment", "path", path)
+		clientSecret, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HPCADMIN_SERVER_OAUTH_CLIENT_SECRET_FILE: %v", err)
+		}
+		cfg.Oauth.ClientSecret = clientSecret
+	}
+	// HPCADMIN_SERVER_LDAP_BIND_PASSWORD
+	if bindPassword, found := os.LookupEnv("HPCADMIN_SERVER_LDAP_BIND_PASSWORD"); found {
+		slog.Debug("found ldap bind password override", "package", "config", "method", "LoadEnvironment", "bindPassword", "REDACTED")
+		cfg.LDAP.BindPassword = bindPassword
+	}
+	// HPCADMIN_SERVER_LDAP_BIND_PASSWORD_FILE
+	if path, found := os.LookupEnv("HPCADMIN_SERVER_LDAP_BIND_PASSWORD_FILE"); found {
+		slog.Debug("found ldap bind password file override", "package", "config", "method", "LoadEnvironment", "path", path)
+		bindPassword, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HPCADMIN_SERVER_LDAP_BIND_PASSWORD_FILE: %v", err)
+		}
+		cfg.LDAP.BindPassword = bindPassword
+	}
+	// HPCADMIN_SERVER_SLURM_TOKEN
+	if token, found := os.LookupEnv("HPCADMIN_SERVER_SLURM_TOKEN"); found {
+		slog.Debug("found slurm token override", "package", "config", "method", "LoadEnvironment", "token", "REDACTED")
+		cfg.Slurm.Token = token
+	}
+	// HPCADMIN_SERVER_SLURM_TOKEN_FILE
+	if path, found := os.LookupEnv("HPCADMIN_SERVER_SLURM_TOKEN_FILE"); found {
+		slog.Debug("found slurm token file override", "package", "config", "method", "LoadEnvironment", "path", path)
+		token, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HPCADMIN_SERVER_SLURM_TOKEN_FILE: %v", err)
+		}
+		cfg.Slurm.Token = token
+	}
+	// HPCADMIN_SERVER_WEBHOOKS_SECRET
+	if secret, found := os.LookupEnv("HPCADMIN_SERVER_WEBHOOKS_SECRET"); found {
+		slog.Debug("found webhooks secret override", "package", "config", "method", "LoadEnvironment", "secret", "REDACTED")
+		cfg.Webhooks.Secret = secret
+	}
+	// HPCADMIN_SERVER_WEBHOOKS_SECRET_FILE
+	if path, found := os.LookupEnv("HPCADMIN_SERVER_WEBHOOKS_SECRET_FILE"); found {
+		slog.Debug("found webhooks secret file override", "package", "config", "method", "LoadEnvironment", "path", path)
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HPCADMIN_SERVER_WEBHOOKS_SECRET_FILE: %v", err)
+		}
+		cfg.Webhooks.Secret = secret
+	}
+	return cfg, nil
+}
+
+// readSecretFile returns the contents of a secret file without the trailing
+// newline most editors and `echo` add
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
 }
 
+// Validate checks cfg and returns every problem found, joined into one error
 func Validate(cfg *ServerConfig) error {
+	return errors.Join(ValidationErrors(cfg)...)
+}
+
+// ValidationErrors checks cfg and returns each problem found
+func ValidationErrors(cfg *ServerConfig) []error {
+	var errs []error
 	if cfg.Host == "" {
-		return fmt.Errorf("missing host")
+		errs = append(errs, fmt.Errorf("missing host"))
 	}
 	if cfg.Port == 0 {
-		return fmt.Errorf("missing port")
+		errs = append(errs, fmt.Errorf("missing port"))
 	}
 	if cfg.DB.Host == "" {
-		return fmt.Errorf("missing database host")
+		errs = append(errs, fmt.Errorf("missing database host"))
 	}
 	if cfg.DB.Port == 0 {
-		return fmt.Errorf("missing database port")
+		errs = append(errs, fmt.Errorf("missing database port"))
 	}
 	if cfg.DB.User == "" {
-		return fmt.Errorf("missing database user")
+		errs = append(errs, fmt.Errorf("missing database user"))
 	}
 	if cfg.DB.Password == "" {
-		return fmt.Errorf("missing database password")
+		errs = append(errs, fmt.Errorf("missing database password"))
 	}
 	if cfg.DB.DBName == "" {
-		return fmt.Errorf("missing database name")
+		errs = append(errs, fmt.Errorf("missing database name"))
 	}
 	if cfg.Oauth.TenantID == "" {
-		return fmt.Errorf("missing oauth tenant ID")
+		errs = append(errs, fmt.Errorf("missing oauth tenant ID"))
 	}
 	if cfg.Oauth.ClientID == "" {
-		return fmt.Errorf("missing oauth client ID")
+		errs = append(errs, fmt.Errorf("missing oauth client ID"))
 	}
 	if cfg.Oauth.ClientSecret == "" {
-		return fmt.Errorf("missing oauth client secret")
+		errs = append(errs, fmt.Errorf("missing oauth client secret"))
+	}
+	switch cfg.DB.Driver {
+	case "", DBDriverPq, DBDriverPgx:
+	default:
+		errs = append(errs, fmt.Errorf("database driver must be %s or %s: %s", DBDriverPq, DBDriverPgx, cfg.DB.Driver))
+	}
+	switch cfg.DB.SSLMode {
+	case "", DBSSLModeDisable, DBSSLModeRequire:
+	case DBSSLModeVerifyCA, DBSSLModeVerifyFull:
+		if cfg.DB.SSLRootCert == "" {
+			errs = append(errs, fmt.Errorf("database sslrootcert is required for sslmode %s", cfg.DB.SSLMode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database sslmode must be %s, %s, %s or %s: %s", DBSSLModeDisable, DBSSLModeRequire, DBSSLModeVerifyCA, DBSSLModeVerifyFull, cfg.DB.SSLMode))
+	}
+	if (cfg.DB.SSLCert == "") != (cfg.DB.SSLKey == "") {
+		errs = append(errs, fmt.Errorf("database sslcert and sslkey must be set together"))
+	}
+	if cfg.DB.MaxOpenConns < 0 {
+		errs = append(errs, fmt.Errorf("database max_open_conns must not be negative"))
+	}
+	if cfg.DB.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("database max_idle_conns must not be negative"))
+	}
+	if cfg.DB.MaxOpenConns > 0 && cfg.DB.MaxIdleConns > cfg.DB.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("database max_idle_conns must not be more than max_open_conns"))
+	}
+	if cfg.DB.ConnMaxLifetimeSeconds < 0 {
+		errs = append(errs, fmt.Errorf("database conn_max_lifetime_seconds must not be negative"))
+	}
+	if cfg.DB.ConnectAttempts < 0 {
+		errs = append(errs, fmt.Errorf("database connect_attempts must not be negative"))
+	}
+	if cfg.DB.ConnectBackoff < 0 {
+		errs = append(errs, fmt.Errorf("database connect_backoff must not be negative"))
+	}
+	if cfg.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_header_bytes must not be negative"))
+	}
+	if cfg.MaxRequestBodyBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_request_body_bytes must not be negative"))
+	}
+	if cfg.ReconcileBatchSize < 0 {
+		errs = append(errs, fmt.Errorf("reconcile_batch_size must not be negative"))
+	}
+	if cfg.Timeouts.ReadTimeout < 0 || cfg.Timeouts.ReadHeaderTimeout < 0 || cfg.Timeouts.WriteTimeout < 0 || cfg.Timeouts.IdleTimeout < 0 || cfg.Timeouts.ShutdownTimeout < 0 || cfg.Timeouts.JobsShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("server timeouts must not be negative"))
+	}
+	if cfg.AuditRetentionDays < 0 || cfg.AuditRetentionInterval < 0 {
+		errs = append(errs, fmt.Errorf("audit retention days and interval must not be negative"))
+	}
+	if cfg.AuditSinks.Interval < 0 {
+		errs = append(errs, fmt.Errorf("audit_sinks interval must not be negative"))
+	}
+	switch cfg.AuditSinks.Syslog.Network {
+	case "", "udp", "tcp", "unix", "unixgram":
+	default:
+		errs = append(errs, fmt.Errorf("audit_sinks syslog network must be udp, tcp, unix or unixgram: %s", cfg.AuditSinks.Syslog.Network))
+	}
+	if (cfg.AuditSinks.Syslog.Network == "") != (cfg.AuditSinks.Syslog.Address == "") {
+		errs = append(errs, fmt.Errorf("audit_sinks syslog network and address must be set together"))
+	}
+	if cfg.AuditSinks.HTTP.URL != "" {
+		u, err := url.Parse(cfg.AuditSinks.HTTP.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("audit_sinks http url must be an http or https url: %s", cfg.AuditSinks.HTTP.URL))
+		}
+	}
+	for _, origin := range cfg.CORS.AllowedOrigins {
+		if origin == "*" {
+			if cfg.CORS.AllowCredentials {
+				errs = append(errs, fmt.Errorf("cors allow_credentials can't be used with the * origin"))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("cors allowed_origins must be * or a scheme and host like https://admin.example.com: %s", origin))
+		}
+	}
+	for _, backend := range cfg.Metrics.Backends {
+		switch backend {
+		case MetricsBackendPrometheus:
+		case MetricsBackendStatsD:
+			if cfg.Metrics.StatsD.Address == "" {
+				errs = append(errs, fmt.Errorf("metrics statsd address is required for the statsd backend"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("metrics backends must be %s or %s: %s", MetricsBackendPrometheus, MetricsBackendStatsD, backend))
+		}
+	}
+	if cfg.Metrics.StatsD.Interval < 0 {
+		errs = append(errs, fmt.Errorf("metrics statsd interval must not be negative"))
+	}
+	if cfg.Oauth.JWKS.RefreshInterval < 0 || cfg.Oauth.JWKS.MaxStaleness < 0 {
+		errs = append(errs, fmt.Errorf("oauth jwks refresh_interval and max_staleness must not be negative"))
+	}
+	if cfg.Oauth.VerifyTimeout < 0 {
+		errs = append(errs, fmt.Errorf("oauth verify_timeout must not be negative"))
+	}
+	if cfg.MembershipSweepInterval < 0 {
+		errs = append(errs, fmt.Errorf("membership_sweep_interval must not be negative"))
+	}
+	if cfg.MaxConcurrentJobs < 0 {
+		errs = append(errs, fmt.Errorf("max_concurrent_jobs must not be negative"))
+	}
+	if cfg.Notifications.SMTP.Host != "" && cfg.Notifications.SMTP.From == "" {
+		errs = append(errs, fmt.Errorf("notifications smtp from is required when host is set"))
+	}
+	if cfg.Notifications.SMTP.Port < 0 {
+		errs = append(errs, fmt.Errorf("notifications smtp port must not be negative"))
+	}
+	if cfg.Notifications.Pirgs.Enabled && cfg.Notifications.SMTP.Host == "" {
+		errs = append(errs, fmt.Errorf("notifications smtp host is required when pirgs is enabled"))
+	}
+	for pirgName, recipients := range cfg.Notifications.Pirgs.Recipients {
+		for _, recipient := range recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				errs = append(errs, fmt.Errorf("notifications pirgs recipients of %s must be email addresses: %s", pirgName, recipient))
+			}
+		}
+	}
+	for _, field := range cfg.MaskedUserFields {
+		if !slices.Contains(MaskableUserFields, field) {
+			errs = append(errs, fmt.Errorf("masked_user_fields must be any of %s: %s", strings.Join(MaskableUserFields, ", "), field))
+		}
+	}
+	if cfg.RateLimit.RequestsPerMinute < 0 || cfg.RateLimit.Burst < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit requests_per_minute and burst must not be negative"))
+	}
+	if cfg.StreamListThreshold < 0 {
+		errs = append(errs, fmt.Errorf("stream_list_threshold must not be negative"))
+	}
+	if cfg.RecentErrors < 0 {
+		errs = append(errs, fmt.Errorf("recent_errors must not be negative"))
+	}
+	switch cfg.RequestIdFormat {
+	case "", RequestIdFormatChi, RequestIdFormatUUID:
+	default:
+		errs = append(errs, fmt.Errorf("request_id_format must be %s or %s: %s", RequestIdFormatChi, RequestIdFormatUUID, cfg.RequestIdFormat))
+	}
+	switch cfg.Oauth.IssuerValidation {
+	case "", IssuerValidationTenant:
+	case IssuerValidationAllowList:
+		if len(cfg.Oauth.AllowedIssuers) == 0 {
+			errs = append(errs, fmt.Errorf("oauth issuer_validation %s requires allowed_issuers", IssuerValidationAllowList))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("oauth issuer_validation must be %s or %s: %s", IssuerValidationTenant, IssuerValidationAllowList, cfg.Oauth.IssuerValidation))
+	}
+	switch cfg.InputSanitization {
+	case "", InputSanitizationReject, InputSanitizationStrip:
+	default:
+		errs = append(errs, fmt.Errorf("input_sanitization must be %s or %s: %s", InputSanitizationReject, InputSanitizationStrip, cfg.InputSanitization))
+	}
+	switch cfg.LogLevel {
+	case "", LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		errs = append(errs, fmt.Errorf("log_level must be %s, %s, %s or %s: %s", LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError, cfg.LogLevel))
+	}
+	for _, route := range cfg.ReadAuditRoutes {
+		if !strings.HasPrefix(route, "/") {
+			errs = append(errs, fmt.Errorf("read_audit_routes must be route patterns starting with /: %s", route))
+		}
+	}
+	switch cfg.TrailingSlash {
+	case "", TrailingSlashStrict, TrailingSlashStrip, TrailingSlashRedirect:
+	default:
+		errs = append(errs, fmt.Errorf("trailing_slash must be %s, %s or %s: %s", TrailingSlashStrict, TrailingSlashStrip, TrailingSlashRedirect, cfg.TrailingSlash))
+	}
+	if cfg.DBWarmupConnections < 0 {
+		errs = append(errs, fmt.Errorf("db_warmup_connections must not be negative"))
+	}
+	if cfg.DBQueryTimeoutSeconds < 0 {
+		errs = append(errs, fmt.Errorf("db_query_timeout_seconds must not be negative"))
+	}
+	if cfg.Pagination.DefaultLimit < 0 || cfg.Pagination.MaxLimit < 0 {
+		errs = append(errs, fmt.Errorf("pagination limits must not be negative"))
+	}
+	if cfg.Pagination.MaxLimit != 0 && cfg.Pagination.DefaultLimit > cfg.Pagination.MaxLimit {
+		errs = append(errs, fmt.Errorf("pagination default_limit must not exceed max_limit"))
+	}
+	for role, rp := range cfg.Pagination.Roles {
+		if rp.DefaultLimit < 0 || rp.MaxLimit < 0 {
+			errs = append(errs, fmt.Errorf("pagination limits for role %s must not be negative", role))
+		}
+		if rp.MaxLimit != 0 && rp.DefaultLimit > rp.MaxLimit {
+			errs = append(errs, fmt.Errorf("pagination default_limit for role %s must not exceed max_limit", role))
+		}
+	}
+	if cfg.LDAP.URL != "" {
+		if u, err := url.Parse(cfg.LDAP.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("ldap url must be an ldap:// or ldaps:// url: %s", cfg.LDAP.URL))
+		}
+		if cfg.LDAP.BaseDN == "" {
+			errs = append(errs, fmt.Errorf("ldap url requires base_dn"))
+		}
+		if cfg.LDAP.BindDN != "" && cfg.LDAP.BindPassword == "" {
+			errs = append(errs, fmt.Errorf("ldap bind_dn requires bind_password"))
+		}
+	}
+	if cfg.Slurm.Enabled && cfg.Slurm.URL != "" {
+		if u, err := url.Parse(cfg.Slurm.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("slurm url must be an http:// or https:// url: %s", cfg.Slurm.URL))
+		}
+		if cfg.Slurm.User == "" || cfg.Slurm.Token == "" {
+			errs = append(errs, fmt.Errorf("slurm url requires user and token"))
+		}
+	}
+	for _, webhookURL := range cfg.Webhooks.URLs {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhooks url must be an http:// or https:// url: %s", webhookURL))
+		}
+	}
+	if len(cfg.Webhooks.URLs) > 0 && cfg.Webhooks.Secret == "" {
+		errs = append(errs, fmt.Errorf("webhooks urls require a secret"))
+	}
+	if cfg.Webhooks.MaxAttempts < 0 || cfg.Webhooks.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("webhooks max_attempts and queue_size must not be negative"))
+	}
+	if cfg.Fairshare.Total < 0 {
+		errs = append(errs, fmt.Errorf("fairshare total must not be negative"))
+	}
+	if cfg.Fairshare.DefaultAllocation != nil && *cfg.Fairshare.DefaultAllocation < 0 {
+		errs = append(errs, fmt.Errorf("fairshare default_allocation must not be negative"))
+	}
+	for name, allocation := range cfg.Fairshare.Allocations {
+		if allocation < 0 {
+			errs = append(errs, fmt.Errorf("fairshare allocation for pirg %s must not be negative", name))
+		}
+	}
+	if err := validatePosixIdRange("uid", cfg.PosixIds.UidMin, cfg.PosixIds.UidMax); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validatePosixIdRange("gid", cfg.PosixIds.GidMin, cfg.PosixIds.GidMax); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.PosixIds.AllocateUidOnCreate && cfg.PosixIds.UidMin == 0 {
+		errs = append(errs, fmt.Errorf("posix_ids allocate_uid_on_create requires uid_min and uid_max"))
+	}
+	if cfg.PosixIds.DefaultGid < 0 {
+		errs = append(errs, fmt.Errorf("posix_ids default_gid must not be negative"))
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls cert_file and key_file must be set together"))
+	}
+	if cfg.TLS.ClientCAFile != "" && cfg.TLS.CertFile == "" {
+		errs = append(errs, fmt.Errorf("tls client_ca_file requires cert_file and key_file"))
+	}
+	if cfg.TLS.RequireClientCert && cfg.TLS.ClientCAFile == "" {
+		errs = append(errs, fmt.Errorf("tls require_client_cert requires client_ca_file"))
+	}
+	if cfg.TLS.EnforceHTTPS && cfg.TLS.CertFile == "" {
+		errs = append(errs, fmt.Errorf("tls enforce_https requires cert_file and key_file"))
+	}
+	if cfg.TLS.HSTSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("tls hsts_max_age must not be negative"))
+	}
+	if cfg.TLS.HTTPPort != 0 {
+		if cfg.TLS.CertFile == "" {
+			errs = append(errs, fmt.Errorf("tls http_port requires cert_file and key_file"))
+		}
+		if cfg.TLS.HTTPPort < 0 || cfg.TLS.HTTPPort > 65535 {
+			errs = append(errs, fmt.Errorf("tls http_port must be between 1 and 65535"))
+		}
+		if cfg.TLS.HTTPPort == cfg.Port {
+			errs = append(errs, fmt.Errorf("tls http_port must not be the same as port"))
+		}
+	}
+	partitionNames := map[string]bool{}
+	for _, p := range cfg.Partitions {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("missing partition name"))
+			continue
+		}
+		if partitionNames[p.Name] {
+			errs = append(errs, fmt.Errorf("duplicate partition name: %s", p.Name))
+		}
+		partitionNames[p.Name] = true
+	}
+	return errs
+}
+
+// validatePosixIdRange checks a range is either unset or positive and ordered
+func validatePosixIdRange(kind string, min int, max int) error {
+	if min == 0 && max == 0 {
+		return nil
+	}
+	if min <= 0 || max < min {
+		return fmt.Errorf("posix_ids %s_min must be positive and not exceed %s_max", kind, kind)
 	}
 	return nil
 }
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // copied from tests/data/testconfig.yaml
@@ -35,6 +38,10 @@ func TestLoadFile(t *testing.T) {
 				User:     "hpcadmin",
 				Password: "superfancytestpasswordthatnobodyknows&",
 				DBName:   "hpcadmin_test",
+				SSLMode:  DBSSLModeDisable,
+				// the pool settings the test config leaves out keep their defaults
+				MaxOpenConns:           25,
+				ConnMaxLifetimeSeconds: 1800,
 			},
 			Oauth: OauthConfig{
 				TenantID:     "mock",
@@ -56,7 +63,7 @@ func TestLoadFile(t *testing.T) {
 	// Test case 2: Test with no config path
 	t.Run("NoConfigPath", func(t *testing.T) {
 		configPath := ""
-		want := &ServerConfig{}
+		want := Defaults()
 
 		got, err := LoadFile(configPath)
 		if err != nil {
@@ -69,3 +76,501 @@ func TestLoadFile(t *testing.T) {
 		}
 	})
 }
+
+func TestDefaults(t *testing.T) {
+	t.Run("MinimalFileValidates", func(t *testing.T) {
+		cfg, err := Parse([]byte(`
+database:
+  user: hpcadmin
+  password: secret
+  dbname: hpcadmin
+oauth:
+  tenant_id: mock
+  client_id: mock
+  client_secret: mock
+`), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Validate(cfg); err != nil {
+			t.Errorf("expected a minimal config to validate: %v", err)
+		}
+		if cfg.Host != "0.0.0.0" || cfg.Port != 3333 || cfg.DB.Host != "localhost" || cfg.DB.Port != 5432 || cfg.DB.SSLMode != DBSSLModeDisable ||
+			cfg.DB.MaxOpenConns != 25 || cfg.DB.ConnMaxLifetimeSeconds != 1800 {
+			t.Errorf("expected the defaults for settings left out got %+v", cfg)
+		}
+	})
+	t.Run("FileOverridesDefaults", func(t *testing.T) {
+		cfg, err := Parse([]byte("port: 8080\ndatabase:\n  host: db.example.com\n  port:\n"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an empty value is the same as leaving the setting out
+		if cfg.Port != 8080 || cfg.DB.Host != "db.example.com" || cfg.Host != "0.0.0.0" || cfg.DB.Port != 5432 {
+			t.Errorf("expected file values over the defaults got %+v", cfg)
+		}
+	})
+	t.Run("EnvironmentOverridesFile", func(t *testing.T) {
+		t.Setenv("HPCADMIN_SERVER_PORT", "9090")
+		cfg, err := Parse([]byte("port: 8080\n"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err = LoadEnvironment(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Port != 9090 {
+			t.Errorf("expected the environment over the file got port %v", cfg.Port)
+		}
+	})
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	base := func() *ServerConfig {
+		return &ServerConfig{
+			Host: "localhost",
+			Port: 3333,
+			DB: DatabaseConfig{
+				Host:     "dbhost",
+				Port:     5432,
+				User:     "hpcadmin",
+				Password: "filepassword",
+				DBName:   "hpcadmin",
+			},
+			Oauth: OauthConfig{
+				TenantID:     "tenant",
+				ClientID:     "client",
+				ClientSecret: "secret",
+			},
+		}
+	}
+	tests := []struct {
+		env   string
+		value string
+		want  func(cfg *ServerConfig)
+	}{
+		{"HPCADMIN_SERVER_HOST", "example.com", func(cfg *ServerConfig) { cfg.Host = "example.com" }},
+		{"HPCADMIN_SERVER_PORT", "8080", func(cfg *ServerConfig) { cfg.Port = 8080 }},
+		{"HPCADMIN_SERVER_PORT", "notaport", func(cfg *ServerConfig) {}},
+		{"HPCADMIN_SERVER_DATABASE_HOST", "db.example.com", func(cfg *ServerConfig) { cfg.DB.Host = "db.example.com" }},
+		{"HPCADMIN_SERVER_DATABASE_PORT", "5433", func(cfg *ServerConfig) { cfg.DB.Port = 5433 }},
+		{"HPCADMIN_SERVER_DATABASE_USER", "envuser", func(cfg *ServerConfig) { cfg.DB.User = "envuser" }},
+		{"HPCADMIN_SERVER_DATABASE_PASSWORD", "envpassword", func(cfg *ServerConfig) { cfg.DB.Password = "envpassword" }},
+		{"HPCADMIN_SERVER_DATABASE_DBNAME", "envdb", func(cfg *ServerConfig) { cfg.DB.DBName = "envdb" }},
+		{"HPCADMIN_SERVER_OAUTH_TENANT_ID", "envtenant", func(cfg *ServerConfig) { cfg.Oauth.TenantID = "envtenant" }},
+		{"HPCADMIN_SERVER_OAUTH_CLIENT_ID", "envclient", func(cfg *ServerConfig) { cfg.Oauth.ClientID = "envclient" }},
+		{"HPCADMIN_SERVER_OAUTH_CLIENT_SECRET", "envsecret", func(cfg *ServerConfig) { cfg.Oauth.ClientSecret = "envsecret" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			want := base()
+			tt.want(want)
+			got, err := LoadEnvironment(base())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected only %s to change got %+v want %+v", tt.env, got, want)
+			}
+		})
+	}
+}
+
+func TestLoadEnvironmentSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeSecret := func(name string, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("FileOverridesInline", func(t *testing.T) {
+		t.Setenv("HPCADMIN_SERVER_DATABASE_PASSWORD", "inlinepassword")
+		t.Setenv("HPCADMIN_SERVER_DATABASE_PASSWORD_FILE", writeSecret("dbpassword", "filepassword\n"))
+		t.Setenv("HPCADMIN_SERVER_OAUTH_CLIENT_SECRET", "inlinesecret")
+		t.Setenv("HPCADMIN_SERVER_OAUTH_CLIENT_SECRET_FILE", writeSecret("clientsecret", "filesecret\r\n"))
+		cfg, err := LoadEnvironment(&ServerConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.DB.Password != "filepassword" || cfg.Oauth.ClientSecret != "filesecret" {
+			t.Errorf("expected the secrets from the files without newlines got %q and %q", cfg.DB.Password, cfg.Oauth.ClientSecret)
+		}
+	})
+	t.Run("OnlyTrailingNewlineTrimmed", func(t *testing.T) {
+		t.Setenv("HPCADMIN_SERVER_DATABASE_PASSWORD_FILE", writeSecret("spaces", " pass word \n"))
+		cfg, err := LoadEnvironment(&ServerConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.DB.Password != " pass word " {
+			t.Errorf("expected only the trailing newline to be removed got %q", cfg.DB.Password)
+		}
+	})
+	for _, env := range []string{"HPCADMIN_SERVER_DATABASE_PASSWORD_FILE", "HPCADMIN_SERVER_OAUTH_CLIENT_SECRET_FILE"} {
+		t.Run("Unreadable"+env, func(t *testing.T) {
+			t.Setenv(env, filepath.Join(dir, "missing"))
+			if _, err := LoadEnvironment(&ServerConfig{}); err == nil || !strings.Contains(err.Error(), env) {
+				t.Errorf("expected an error naming %s got %v", env, err)
+			}
+		})
+	}
+}
+
+func TestValidateDBSSLMode(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name    string
+		db      DatabaseConfig
+		wantErr bool
+	}{
+		{name: "Unset", db: DatabaseConfig{}},
+		{name: "Require", db: DatabaseConfig{SSLMode: DBSSLModeRequire}},
+		{name: "VerifyFullWithRootCert", db: DatabaseConfig{SSLMode: DBSSLModeVerifyFull, SSLRootCert: "/etc/ca.pem"}},
+		{name: "VerifyFullWithoutRootCert", db: DatabaseConfig{SSLMode: DBSSLModeVerifyFull}, wantErr: true},
+		{name: "VerifyCAWithoutRootCert", db: DatabaseConfig{SSLMode: DBSSLModeVerifyCA}, wantErr: true},
+		{name: "Unknown", db: DatabaseConfig{SSLMode: "sometimes"}, wantErr: true},
+		{name: "CertWithoutKey", db: DatabaseConfig{SSLMode: DBSSLModeRequire, SSLCert: "/etc/client.pem"}, wantErr: true},
+		{name: "DriverPgx", db: DatabaseConfig{Driver: DBDriverPgx}},
+		{name: "UnknownDriver", db: DatabaseConfig{Driver: "mysql"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.DB.Driver = tt.db.Driver
+			cfg.DB.SSLMode = tt.db.SSLMode
+			cfg.DB.SSLRootCert = tt.db.SSLRootCert
+			cfg.DB.SSLCert = tt.db.SSLCert
+			cfg.DB.SSLKey = tt.db.SSLKey
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateDBPool(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name    string
+		db      DatabaseConfig
+		wantErr bool
+	}{
+		{name: "Unset", db: DatabaseConfig{}},
+		{name: "IdleUnderOpen", db: DatabaseConfig{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetimeSeconds: 60}},
+		{name: "IdleEqualsOpen", db: DatabaseConfig{MaxOpenConns: 5, MaxIdleConns: 5}},
+		{name: "IdleWithUnlimitedOpen", db: DatabaseConfig{MaxIdleConns: 50}},
+		{name: "IdleOverOpen", db: DatabaseConfig{MaxOpenConns: 5, MaxIdleConns: 10}, wantErr: true},
+		{name: "NegativeIdle", db: DatabaseConfig{MaxIdleConns: -1}, wantErr: true},
+		{name: "NegativeLifetime", db: DatabaseConfig{ConnMaxLifetimeSeconds: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.DB.MaxOpenConns = tt.db.MaxOpenConns
+			cfg.DB.MaxIdleConns = tt.db.MaxIdleConns
+			cfg.DB.ConnMaxLifetimeSeconds = tt.db.ConnMaxLifetimeSeconds
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name    string
+		cors    CORSConfig
+		wantErr bool
+	}{
+		{name: "Unset", cors: CORSConfig{}},
+		{name: "Origin", cors: CORSConfig{AllowedOrigins: []string{"https://admin.example.com"}, AllowCredentials: true}},
+		{name: "OriginWithPort", cors: CORSConfig{AllowedOrigins: []string{"http://localhost:5173"}}},
+		{name: "AnyOrigin", cors: CORSConfig{AllowedOrigins: []string{"*"}}},
+		{name: "AnyOriginWithCredentials", cors: CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, wantErr: true},
+		{name: "OriginWithPath", cors: CORSConfig{AllowedOrigins: []string{"https://admin.example.com/"}}, wantErr: true},
+		{name: "OriginWithoutScheme", cors: CORSConfig{AllowedOrigins: []string{"admin.example.com"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.CORS = tt.cors
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateEnforceHTTPS(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name    string
+		tls     TLSConfig
+		wantErr bool
+	}{
+		{name: "Unset", tls: TLSConfig{}},
+		{name: "Enforced", tls: TLSConfig{CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem", EnforceHTTPS: true, HTTPPort: 8080}},
+		{name: "EnforcedWithoutTLS", tls: TLSConfig{EnforceHTTPS: true}, wantErr: true},
+		{name: "HTTPPortWithoutTLS", tls: TLSConfig{HTTPPort: 8080}, wantErr: true},
+		{name: "HTTPPortSameAsPort", tls: TLSConfig{CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem", HTTPPort: 3333}, wantErr: true},
+		{name: "NegativeMaxAge", tls: TLSConfig{CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem", EnforceHTTPS: true, HSTSMaxAge: -time.Second}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Port = 3333
+			cfg.TLS = tt.tls
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateInputSanitization(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	for mode, wantErr := range map[string]bool{
+		"":                      false,
+		InputSanitizationReject: false,
+		InputSanitizationStrip:  false,
+		"escape":                true,
+	} {
+		cfg, err := LoadFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.InputSanitization = mode
+		err = Validate(cfg)
+		if wantErr && err == nil {
+			t.Errorf("expected input_sanitization %q to be a validation error", mode)
+		}
+		if !wantErr && err != nil {
+			t.Errorf("unexpected validation error for input_sanitization %q: %v", mode, err)
+		}
+	}
+}
+
+func TestValidateLDAP(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name    string
+		ldap    LDAPConfig
+		wantErr bool
+	}{
+		{name: "Unset", ldap: LDAPConfig{}},
+		{name: "Anonymous", ldap: LDAPConfig{URL: "ldap://dc.example.com", BaseDN: "dc=example,dc=com"}},
+		{name: "Bind", ldap: LDAPConfig{URL: "ldaps://dc.example.com:636", BaseDN: "dc=example,dc=com", BindDN: "cn=hpcadmin,dc=example,dc=com", BindPassword: "secret"}},
+		{name: "BindWithoutPassword", ldap: LDAPConfig{URL: "ldaps://dc.example.com", BaseDN: "dc=example,dc=com", BindDN: "cn=hpcadmin,dc=example,dc=com"}, wantErr: true},
+		{name: "WithoutBaseDN", ldap: LDAPConfig{URL: "ldaps://dc.example.com"}, wantErr: true},
+		{name: "WrongScheme", ldap: LDAPConfig{URL: "https://dc.example.com", BaseDN: "dc=example,dc=com"}, wantErr: true},
+		{name: "WithoutHost", ldap: LDAPConfig{URL: "ldaps://", BaseDN: "dc=example,dc=com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.LDAP = tt.ldap
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateSlurm(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name    string
+		slurm   SlurmConfig
+		wantErr bool
+	}{
+		{name: "Unset", slurm: SlurmConfig{}},
+		{name: "Command", slurm: SlurmConfig{Enabled: true, Command: "/usr/bin/sacctmgr"}},
+		{name: "REST", slurm: SlurmConfig{Enabled: true, URL: "http://slurmrestd:6820", User: "slurm", Token: "secret"}},
+		{name: "RESTWithoutToken", slurm: SlurmConfig{Enabled: true, URL: "http://slurmrestd:6820", User: "slurm"}, wantErr: true},
+		{name: "WrongScheme", slurm: SlurmConfig{Enabled: true, URL: "slurmrestd:6820", User: "slurm", Token: "secret"}, wantErr: true},
+		{name: "Disabled", slurm: SlurmConfig{URL: "slurmrestd:6820"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Slurm = tt.slurm
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateIssuerValidation(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name    string
+		mode    string
+		issuers []string
+		wantErr bool
+	}{
+		{name: "Default"},
+		{name: "Tenant", mode: IssuerValidationTenant},
+		{name: "AllowList", mode: IssuerValidationAllowList, issuers: []string{"https://login.microsoftonline.com/{tenantid}/v2.0"}},
+		{name: "AllowListWithoutIssuers", mode: IssuerValidationAllowList, wantErr: true},
+		{name: "Unknown", mode: "none", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Oauth.IssuerValidation = tt.mode
+			cfg.Oauth.AllowedIssuers = tt.issuers
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWebhooks(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name     string
+		webhooks WebhookConfig
+		wantErr  bool
+	}{
+		{name: "Unset", webhooks: WebhookConfig{}},
+		{name: "URLs", webhooks: WebhookConfig{URLs: []string{"https://tickets.example.edu/hooks", "http://localhost:8080"}, Secret: "secret"}},
+		{name: "WithoutSecret", webhooks: WebhookConfig{URLs: []string{"https://tickets.example.edu/hooks"}}, wantErr: true},
+		{name: "WrongScheme", webhooks: WebhookConfig{URLs: []string{"tickets.example.edu/hooks"}, Secret: "secret"}, wantErr: true},
+		{name: "NegativeMaxAttempts", webhooks: WebhookConfig{URLs: []string{"https://tickets.example.edu/hooks"}, Secret: "secret", MaxAttempts: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Webhooks = tt.webhooks
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidatePirgNotifications(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	smtp := SMTPConfig{Host: "smtp.example.edu", From: "hpcadmin@example.edu"}
+	tests := []struct {
+		name          string
+		notifications NotificationConfig
+		wantErr       bool
+	}{
+		{name: "Unset", notifications: NotificationConfig{}},
+		{name: "Enabled", notifications: NotificationConfig{SMTP: smtp, Pirgs: PirgNotificationConfig{Enabled: true, Recipients: map[string][]string{"lab": {"manager@example.edu"}}}}},
+		{name: "EnabledWithoutSMTP", notifications: NotificationConfig{Pirgs: PirgNotificationConfig{Enabled: true}}, wantErr: true},
+		{name: "InvalidRecipient", notifications: NotificationConfig{SMTP: smtp, Pirgs: PirgNotificationConfig{Enabled: true, Recipients: map[string][]string{"lab": {"manager"}}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Notifications = tt.notifications
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidatePosixIds(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	tests := []struct {
+		name     string
+		posixIds PosixIdConfig
+		wantErr  bool
+	}{
+		{name: "Unset", posixIds: PosixIdConfig{}},
+		{name: "AllocateUidOnCreate", posixIds: PosixIdConfig{UidMin: 50000, UidMax: 59999, AllocateUidOnCreate: true, DefaultGid: 100}},
+		{name: "AllocateUidOnCreateWithoutRange", posixIds: PosixIdConfig{AllocateUidOnCreate: true}, wantErr: true},
+		{name: "NegativeDefaultGid", posixIds: PosixIdConfig{DefaultGid: -1}, wantErr: true},
+		{name: "InvertedRange", posixIds: PosixIdConfig{UidMin: 59999, UidMax: 50000}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.PosixIds = tt.posixIds
+			err = Validate(cfg)
+			if tt.wantErr && err == nil {
+				t.Error("expected a validation error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the values of secret fields in a Diff
+const redacted = "<redacted>"
+
+// secretFields are the yaml paths of fields holding secrets, and a path
+// ending in .* is every key of that map
+var secretFields = []string{
+	"oauth.client_secret",
+	"database.password",
+	"ldap.bind_password",
+	"slurm.token",
+	"webhooks.secret",
+	"notifications.smtp.password",
+	"audit_sinks.http.headers.*",
+}
+
+// FieldDiff is a field that differs between two configurations, by its yaml
+// path such as database.host. A and B are its values as JSON, null where
+// it's missing, or redacted if it's a secret.
+type FieldDiff struct {
+	Field string
+	A     string
+	B     string
+}
+
+// Diff returns the fields that differ between a and b, ordered by path.
+// Fields are compared down to the scalar values, with lists compared whole.
+func Diff(a *ServerConfig, b *ServerConfig) ([]FieldDiff, error) {
+	fieldsA, err := flattenConfig(a)
+	if err != nil {
+		return nil, err
+	}
+	fieldsB, err := flattenConfig(b)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for path := range fieldsA {
+		paths = append(paths, path)
+	}
+	for path := range fieldsB {
+		if _, ok := fieldsA[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	var diffs []FieldDiff
+	for _, path := range paths {
+		if fieldsA[path] != fieldsB[path] {
+			diffs = append(diffs, FieldDiff{Field: path, A: diffValue(path, fieldsA), B: diffValue(path, fieldsB)})
+		}
+	}
+	return diffs, nil
+}
+
+// flattenConfig maps the yaml path of every scalar and list in cfg to its
+// value as JSON
+func flattenConfig(cfg *ServerConfig) (map[string]string, error) {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %v", err)
+	}
+	var tree map[string]any
+	if err := yaml.Unmarshal(b, &tree); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %v", err)
+	}
+	fields := map[string]string{}
+	var flatten func(prefix string, v any) error
+	flatten = func(prefix string, v any) error {
+		if m, ok := v.(map[string]any); ok && len(m) > 0 {
+			for key, child := range m {
+				if err := flatten(strings.TrimPrefix(prefix+"."+key, "."), child); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", prefix, err)
+		}
+		fields[prefix] = string(value)
+		return nil
+	}
+	return fields, flatten("", tree)
+}
+
+// isSecretField reports whether path is one of secretFields
+func isSecretField(path string) bool {
+	for _, secret := range secretFields {
+		if prefix, ok := strings.CutSuffix(secret, "*"); ok && strings.HasPrefix(path, prefix) {
+			return true
+		}
+		if path == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// diffValue is the value of path in fields, redacted if it's a secret
+// that's set
+func diffValue(path string, fields map[string]string) string {
+	value, ok := fields[path]
+	if !ok {
+		return "null"
+	}
+	if isSecretField(path) && value != `""` && value != "null" {
+		return redacted
+	}
+	return value
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	base, err := os.ReadFile("../../test/data/testconfig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	load := func(name string, extra string) *ServerConfig {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, append(base, []byte("\n"+extra)...), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	// an empty list is the same as leaving it out
+	staging := load("staging.yaml", "log_level: debug\ncors:\n  allowed_origins: []\n")
+	prod := load("prod.yaml", "log_level: warn\nallowed_hosts: [hpcadmin.example.edu]\nslurm:\n  token: prodtoken\n")
+	diffs, err := Diff(staging, prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldDiff{
+		{Field: "allowed_hosts", A: "[]", B: `["hpcadmin.example.edu"]`},
+		{Field: "log_level", A: `"debug"`, B: `"warn"`},
+		{Field: "slurm.token", A: `""`, B: redacted},
+	}
+	if !slices.Equal(diffs, want) {
+		t.Errorf("expected %+v got %+v", want, diffs)
+	}
+
+	if diffs, err := Diff(staging, staging); err != nil || len(diffs) != 0 {
+		t.Errorf("expected no differences with itself got %+v %v", diffs, err)
+	}
+}
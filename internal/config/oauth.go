@@ -0,0 +1,64 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultOauthVerifyTimeout is how long VerifyOauth is given at startup
+// if oauth verify_timeout isn't set
+const DefaultOauthVerifyTimeout = 5 * time.Second
+
+// oauthDiscoveryURL is the tenant's OpenID configuration, with %s the tenant id
+var oauthDiscoveryURL = "https://login.microsoftonline.com/%s/v2.0/.well-known/openid-configuration"
+
+// oauthDiscovery is the part of the discovery document VerifyOauth checks
+type oauthDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+	// Azure AD answers an unknown tenant with these instead
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// VerifyOauth fetches the OpenID discovery document of the configured tenant
+// with client, so a wrong tenant id fails at startup instead of on the first
+// login. It fails if the tenant doesn't exist, the document can't be parsed,
+// or the request doesn't finish before ctx is done.
+func VerifyOauth(ctx context.Context, cfg *ServerConfig, client *http.Client) error {
+	tenant := cfg.Oauth.TenantID
+	discoveryURL := fmt.Sprintf(oauthDiscoveryURL, url.PathEscape(tenant))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to verify oauth tenant %s: %v", tenant, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to verify oauth tenant %s, couldn't fetch %s: %v", tenant, discoveryURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to verify oauth tenant %s, couldn't read %s: %v", tenant, discoveryURL, err)
+	}
+	var doc oauthDiscovery
+	parseErr := json.Unmarshal(body, &doc)
+	if resp.StatusCode != http.StatusOK {
+		if parseErr == nil && doc.ErrorDescription != "" {
+			return fmt.Errorf("oauth tenant %s is invalid: %s", tenant, doc.ErrorDescription)
+		}
+		return fmt.Errorf("oauth tenant %s is invalid, %s answered %s", tenant, discoveryURL, resp.Status)
+	}
+	if parseErr != nil {
+		return fmt.Errorf("failed to verify oauth tenant %s, couldn't parse %s: %v", tenant, discoveryURL, parseErr)
+	}
+	if doc.Issuer == "" || doc.JWKSURI == "" {
+		return fmt.Errorf("failed to verify oauth tenant %s, %s has no issuer or jwks_uri", tenant, discoveryURL)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyOauth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good/v2.0/.well-known/openid-configuration":
+			w.Write([]byte(`{"issuer": "https://login.microsoftonline.com/good/v2.0", "jwks_uri": "https://login.microsoftonline.com/good/discovery/v2.0/keys"}`))
+		case "/typo/v2.0/.well-known/openid-configuration":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_tenant", "error_description": "AADSTS90002: Tenant 'typo' not found."}`))
+		case "/garbled/v2.0/.well-known/openid-configuration":
+			w.Write([]byte(`<html>`))
+		case "/slow/v2.0/.well-known/openid-configuration":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defaultURL := oauthDiscoveryURL
+	oauthDiscoveryURL = server.URL + "/%s/v2.0/.well-known/openid-configuration"
+	defer func() { oauthDiscoveryURL = defaultURL }()
+
+	tests := []struct {
+		tenant  string
+		message string
+	}{
+		{"good", ""},
+		{"typo", "Tenant 'typo' not found"},
+		{"missing", "404"},
+		{"garbled", "couldn't parse"},
+		{"slow", "deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			cfg := &ServerConfig{Oauth: OauthConfig{TenantID: tt.tenant}}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := VerifyOauth(ctx, cfg, server.Client())
+			if tt.message == "" {
+				if err != nil {
+					t.Errorf("expected the tenant to verify got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.message) || !strings.Contains(err.Error(), tt.tenant) {
+				t.Errorf("expected an error about the tenant containing %q got %v", tt.message, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ReloadableFields are the top level fields a running server changes to when
+// it reloads the configuration, changes to the others need a restart
+var ReloadableFields = []string{"log_level", "rate_limit"}
+
+// Reload loads and validates the configuration the same way as at startup,
+// from the file at configPath and then the environment
+func Reload(configPath string) (*ServerConfig, error) {
+	cfg, err := LoadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err = LoadEnvironment(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// RestartRequired returns the yaml names of the top level fields that differ
+// between old and new, leaving out ReloadableFields
+func RestartRequired(old *ServerConfig, new *ServerConfig) []string {
+	var changed []string
+	oldValue, newValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		name, _, _ := strings.Cut(oldValue.Type().Field(i).Tag.Get("yaml"), ",")
+		if slices.Contains(ReloadableFields, name) {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRestartRequired(t *testing.T) {
+	configPath, _ := filepath.Abs("../../test/data/testconfig.yaml")
+	old, err := Reload(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := Reload(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed := RestartRequired(old, new); len(changed) != 0 {
+		t.Errorf("expected no changes reloading the same file got %v", changed)
+	}
+
+	new.LogLevel = LogLevelDebug
+	new.RateLimit.RequestsPerMinute = 60
+	if changed := RestartRequired(old, new); len(changed) != 0 {
+		t.Errorf("expected reloadable fields to be left out got %v", changed)
+	}
+
+	new.DB.Host = "elsewhere"
+	new.Port = old.Port + 1
+	if changed := RestartRequired(old, new); !slices.Equal(changed, []string{"port", "database"}) {
+		t.Errorf("expected port and database to need a restart got %v", changed)
+	}
+}
@@ -10,6 +10,7 @@ import (
 type APIKeyEntry struct {
 	Key       string
 	Role      string
+	UserId    int
 	CreatedAt time.Time
 	ModifiedAt time.Time
 }
@@ -19,7 +20,9 @@ type APIKeyEntry struct {
 func GetAPIKeyEntry(db *sql.DB, key string) (*APIKeyEntry, error) {
 	slog.Debug("querying database for api key", "package", "data", "method", "GetAPIKeyEntry")
 	var k APIKeyEntry
-	err := db.QueryRow("SELECT key, role, created_at, modified_at FROM api_keys WHERE key = $1", key).Scan(&k.Key, &k.Role, &k.CreatedAt, &k.ModifiedAt)
+	err := db.QueryRow(`
+		SELECT key, role, user_id, created_at, modified_at FROM api_keys
+		WHERE key = $1 AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id = api_keys.user_id AND u.deleted_at IS NOT NULL)`, key).Scan(&k.Key, &k.Role, &k.UserId, &k.CreatedAt, &k.ModifiedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			slog.Debug("api key not found in database", "package", "data", "method", "GetAPIKeyEntry")
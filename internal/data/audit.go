@@ -0,0 +1,388 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	AuditActionMemberAdded   = "member_added"
+	AuditActionMemberRemoved = "member_removed"
+	AuditActionOwnerChanged  = "owner_changed"
+	AuditActionAdminAdded    = "admin_added"
+	AuditActionAdminRemoved  = "admin_removed"
+	AuditActionRead          = "read"
+	// users and pirgs themselves being changed
+	AuditActionCreated  = "created"
+	AuditActionUpdated  = "updated"
+	AuditActionDeleted  = "deleted"
+	AuditActionRestored = "restored"
+	// bulk changes to users that don't record each user
+	AuditActionAttributesSet = "attributes_set"
+)
+
+type AuditEvent struct {
+	Id           int             `json:"id"`
+	OccurredAt   time.Time       `json:"occurred_at"`
+	Actor        string          `json:"actor"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceId   string          `json:"resource_id"`
+	Details      json.RawMessage `json:"details"`
+}
+
+type AuditEventRequest struct {
+	Actor        string          `json:"actor"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceId   string          `json:"resource_id"`
+	Details      json.RawMessage `json:"details"`
+}
+
+// CreateAuditEvent records an audit event
+func CreateAuditEvent(db *sql.DB, ar *AuditEventRequest) (*AuditEvent, error) {
+	slog.Debug("creating audit event in database", "action", ar.Action, "package", "data", "method", "CreateAuditEvent")
+	return insertAuditEvent(db, ar)
+}
+
+// RecordAudit records that actor took action on a resource, with details
+// marshaled to json if they aren't nil
+func RecordAudit(db *sql.DB, actor string, action string, resourceType string, resourceId string, details any) error {
+	ar := &AuditEventRequest{Actor: actor, Action: action, ResourceType: resourceType, ResourceId: resourceId}
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit details: %v", err)
+		}
+		ar.Details = b
+	}
+	_, err := CreateAuditEvent(db, ar)
+	return err
+}
+
+// insertAuditEvent records an audit event, as part of a transaction if q is one
+func insertAuditEvent(q querier, ar *AuditEventRequest) (*AuditEvent, error) {
+	// a nil RawMessage would be sent as an empty string, which isn't valid jsonb
+	var details any
+	if len(ar.Details) > 0 {
+		details = string(ar.Details)
+	}
+	e := AuditEvent{
+		Actor:        ar.Actor,
+		Action:       ar.Action,
+		ResourceType: ar.ResourceType,
+		ResourceId:   ar.ResourceId,
+		Details:      ar.Details,
+	}
+	err := q.QueryRow(`
+		INSERT INTO audit_log (actor, action, resource_type, resource_id, details)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, occurred_at`,
+		ar.Actor, ar.Action, ar.ResourceType, ar.ResourceId, details).Scan(&e.Id, &e.OccurredAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// StreamAuditEvents calls fn for each audit event that occurred in [from, to),
+// oldest first. Rows are read one at a time so the full range is never held in
+// memory. Iteration stops at the first error returned by fn.
+func StreamAuditEvents(db *sql.DB, from time.Time, to time.Time, fn func(*AuditEvent) error) error {
+	slog.Debug("streaming audit events from database", "from", from, "to", to, "package", "data", "method", "StreamAuditEvents")
+	rows, err := db.Query(`
+		SELECT id, occurred_at, actor, action, resource_type, resource_id, details
+		FROM audit_log
+		WHERE occurred_at >= $1 AND occurred_at < $2
+		ORDER BY occurred_at, id`, from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEvent
+		var details []byte
+		err := rows.Scan(&e.Id, &e.OccurredAt, &e.Actor, &e.Action, &e.ResourceType, &e.ResourceId, &details)
+		if err != nil {
+			return err
+		}
+		if details != nil {
+			e.Details = json.RawMessage(details)
+		}
+		if err = fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetChangesAfter returns up to limit audit events of changes, every event
+// but reads, that occurred after afterTime, or at afterTime with an id
+// greater than afterId. They're ordered by time, then id.
+func GetChangesAfter(db *sql.DB, afterTime time.Time, afterId int, limit int) ([]*AuditEvent, error) {
+	slog.Debug("getting changes after time from database", "after_time", afterTime, "after_id", afterId, "limit", limit, "package", "data", "method", "GetChangesAfter")
+	rows, err := db.Query(`
+		SELECT id, occurred_at, actor, action, resource_type, resource_id, details
+		FROM audit_log
+		WHERE action <> $1 AND (occurred_at, id) > ($2, $3)
+		ORDER BY occurred_at, id
+		LIMIT $4`, AuditActionRead, afterTime.UTC(), afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var details []byte
+		err := rows.Scan(&e.Id, &e.OccurredAt, &e.Actor, &e.Action, &e.ResourceType, &e.ResourceId, &details)
+		if err != nil {
+			return nil, err
+		}
+		if details != nil {
+			e.Details = json.RawMessage(details)
+		}
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetAuditEventsAfter returns up to limit audit events with an id greater
+// than afterId, oldest first
+func GetAuditEventsAfter(db *sql.DB, afterId int, limit int) ([]*AuditEvent, error) {
+	slog.Debug("getting audit events after id from database", "after_id", afterId, "limit", limit, "package", "data", "method", "GetAuditEventsAfter")
+	rows, err := db.Query(`
+		SELECT id, occurred_at, actor, action, resource_type, resource_id, details
+		FROM audit_log
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var details []byte
+		err := rows.Scan(&e.Id, &e.OccurredAt, &e.Actor, &e.Action, &e.ResourceType, &e.ResourceId, &details)
+		if err != nil {
+			return nil, err
+		}
+		if details != nil {
+			e.Details = json.RawMessage(details)
+		}
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetAuditEventsByActor returns a page of the audit events recorded for the
+// actor that occurred in [from, to), oldest first, and how many there are in total
+func GetAuditEventsByActor(db *sql.DB, actor string, from time.Time, to time.Time, limit int, offset int) ([]*AuditEvent, int, error) {
+	slog.Debug("getting audit events by actor from database", "actor", actor, "from", from, "to", to, "package", "data", "method", "GetAuditEventsByActor")
+	var total int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM audit_log
+		WHERE actor = $1 AND occurred_at >= $2 AND occurred_at < $3`,
+		actor, from.UTC(), to.UTC()).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(`
+		SELECT id, occurred_at, actor, action, resource_type, resource_id, details
+		FROM audit_log
+		WHERE actor = $1 AND occurred_at >= $2 AND occurred_at < $3
+		ORDER BY occurred_at, id
+		LIMIT $4 OFFSET $5`, actor, from.UTC(), to.UTC(), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var details []byte
+		err := rows.Scan(&e.Id, &e.OccurredAt, &e.Actor, &e.Action, &e.ResourceType, &e.ResourceId, &details)
+		if err != nil {
+			return nil, 0, err
+		}
+		if details != nil {
+			e.Details = json.RawMessage(details)
+		}
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// GetLastAuditEventId returns the id of the newest audit event, or 0 if there are none
+func GetLastAuditEventId(db *sql.DB) (int, error) {
+	slog.Debug("getting last audit event id from database", "package", "data", "method", "GetLastAuditEventId")
+	var id int
+	err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM audit_log").Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// PurgeAuditEvents deletes the audit events that occurred before the cutoff
+// and returns how many were deleted. If maxId isn't 0 only events up to and
+// including it are deleted, so a caller can archive the events with
+// StreamAuditEvents first and delete exactly the ones it archived.
+func PurgeAuditEvents(db *sql.DB, before time.Time, maxId int) (int, error) {
+	slog.Debug("purging audit events from database", "before", before, "max_id", maxId, "package", "data", "method", "PurgeAuditEvents")
+	res, err := db.Exec("DELETE FROM audit_log WHERE occurred_at < $1 AND ($2 = 0 OR id <= $2)", before.UTC(), maxId)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// PirgMembershipEvent is a single user joining or leaving a pirg
+type PirgMembershipEvent struct {
+	Actor      string
+	Action     string
+	UserId     int
+	Username   string
+	OccurredAt time.Time
+}
+
+// RecordPirgMembershipChanges records an audit event for every user present
+// in only one of before and after
+func RecordPirgMembershipChanges(db *sql.DB, actor string, pirgId int, before []int, after []int) error {
+	slog.Debug("recording pirg membership changes", "pirg_id", pirgId, "package", "data", "method", "RecordPirgMembershipChanges")
+	record := func(action string, userId int) error {
+		_, err := CreateAuditEvent(db, &AuditEventRequest{
+			Actor:        actor,
+			Action:       action,
+			ResourceType: "pirg",
+			ResourceId:   strconv.Itoa(pirgId),
+			Details:      json.RawMessage(fmt.Sprintf(`{"user_id": %d}`, userId)),
+		})
+		return err
+	}
+	for _, userId := range after {
+		if !slices.Contains(before, userId) {
+			if err := record(AuditActionMemberAdded, userId); err != nil {
+				return err
+			}
+		}
+	}
+	for _, userId := range before {
+		if !slices.Contains(after, userId) {
+			if err := record(AuditActionMemberRemoved, userId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetPirgMembershipHistory returns a page of membership events for the pirg,
+// oldest first, along with the total number of events
+func GetPirgMembershipHistory(db *sql.DB, pirgId int, limit int, offset int) ([]*PirgMembershipEvent, int, error) {
+	slog.Debug("getting pirg membership history from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgMembershipHistory")
+	resourceId := strconv.Itoa(pirgId)
+	actions := []string{AuditActionMemberAdded, AuditActionMemberRemoved}
+	var total int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM audit_log
+		WHERE resource_type = 'pirg' AND resource_id = $1 AND action IN ($2, $3)`,
+		resourceId, actions[0], actions[1]).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	// the user may have been deleted since, so the username is optional
+	rows, err := db.Query(`
+		SELECT a.actor, a.action, (a.details->>'user_id')::int, COALESCE(u.username, ''), a.occurred_at
+		FROM audit_log a
+		LEFT JOIN users u ON u.id = (a.details->>'user_id')::int
+		WHERE a.resource_type = 'pirg' AND a.resource_id = $1 AND a.action IN ($2, $3)
+		ORDER BY a.occurred_at, a.id
+		LIMIT $4 OFFSET $5`,
+		resourceId, actions[0], actions[1], limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	events := []*PirgMembershipEvent{}
+	for rows.Next() {
+		var e PirgMembershipEvent
+		if err := rows.Scan(&e.Actor, &e.Action, &e.UserId, &e.Username, &e.OccurredAt); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// pirgMembersAsOfQuery selects the members of pirg $1 at $4, the users whose
+// last membership event, $2 for added or $3 for removed, up to then added them
+const pirgMembersAsOfQuery = `
+	WITH latest AS (
+		SELECT DISTINCT ON ((details->>'user_id')::int)
+			(details->>'user_id')::int AS user_id, action, occurred_at
+		FROM audit_log
+		WHERE resource_type = 'pirg' AND resource_id = $1 AND action IN ($2, $3) AND occurred_at <= $4
+		ORDER BY (details->>'user_id')::int, occurred_at DESC, id DESC
+	)
+	SELECT user_id, occurred_at FROM latest WHERE action = $2`
+
+// GetPirgMembersAsOf returns a page of the members the pirg had at asOf ordered
+// by username, along with the total number, replayed from its membership
+// history in the audit log. JoinedAt is when they were last added. Changes
+// that weren't recorded, such as members from before the history was kept,
+// aren't known. Users deleted since have only their id.
+func GetPirgMembersAsOf(db *sql.DB, pirgId int, asOf time.Time, limit int, offset int) ([]*PirgMember, int, error) {
+	slog.Debug("getting pirg members as of a time from database", "pirg_id", pirgId, "as_of", asOf, "package", "data", "method", "GetPirgMembersAsOf")
+	args := []any{strconv.Itoa(pirgId), AuditActionMemberAdded, AuditActionMemberRemoved, asOf.UTC()}
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM ("+pirgMembersAsOfQuery+") m", args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(`
+		SELECT m.user_id, COALESCE(u.username, ''), COALESCE(u.email, ''), COALESCE(u.firstname, ''), COALESCE(u.lastname, ''), m.occurred_at
+		FROM (`+pirgMembersAsOfQuery+`) m
+		LEFT JOIN users u ON u.id = m.user_id
+		ORDER BY u.username, m.user_id
+		LIMIT $5 OFFSET $6`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	members := []*PirgMember{}
+	for rows.Next() {
+		var m PirgMember
+		if err := rows.Scan(&m.UserId, &m.Username, &m.Email, &m.FirstName, &m.LastName, &m.JoinedAt); err != nil {
+			return nil, 0, err
+		}
+		members = append(members, &m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return members, total, nil
+}
@@ -0,0 +1,405 @@
+package data
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestStreamAuditEvents(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	// place events on known days so the range filter can be checked exactly
+	days := []time.Time{
+		time.Date(2001, 1, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2001, 1, 2, 12, 0, 0, 0, time.UTC),
+		time.Date(2001, 1, 3, 12, 0, 0, 0, time.UTC),
+	}
+	var ids []int
+	for _, day := range days {
+		e, err := CreateAuditEvent(db, &AuditEventRequest{
+			Actor:        "teststreamauditevents",
+			Action:       "create",
+			ResourceType: "user",
+			ResourceId:   "1",
+			Details:      []byte(`{"username":"teststreamauditevents"}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id = $2", day, e.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.Id)
+	}
+
+	var got []*AuditEvent
+	err := StreamAuditEvents(db, days[0], days[2], func(e *AuditEvent) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// from is inclusive and to is exclusive
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events got %v", len(got))
+	}
+	if got[0].Id != ids[0] || got[1].Id != ids[1] {
+		t.Fatalf("expected ids %v got %v and %v", ids[:2], got[0].Id, got[1].Id)
+	}
+	if string(got[0].Details) != `{"username": "teststreamauditevents"}` {
+		t.Fatalf("unexpected details: %s", got[0].Details)
+	}
+}
+
+func TestGetAuditEventsAfter(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var ids []int
+	for i := 0; i < 3; i++ {
+		e, err := CreateAuditEvent(db, &AuditEventRequest{
+			Actor:        "testgetauditeventsafter",
+			Action:       "create",
+			ResourceType: "user",
+			ResourceId:   "1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.Id)
+	}
+	lastId, err := GetLastAuditEventId(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastId != ids[2] {
+		t.Fatalf("expected last id %v got %v", ids[2], lastId)
+	}
+	got, err := GetAuditEventsAfter(db, ids[0], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Id != ids[1] {
+		t.Fatalf("expected only event %v got %v", ids[1], got)
+	}
+	if got, err = GetAuditEventsAfter(db, ids[2], 10); err != nil || len(got) != 0 {
+		t.Fatalf("expected no events after the last got %v %v", got, err)
+	}
+}
+
+func TestRecordAudit(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	last, err := GetLastAuditEventId(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordAudit(db, "testrecordaudit", AuditActionAttributesSet, "user", "", map[string]int{"affected": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordAudit(db, "testrecordaudit", AuditActionDeleted, "pirg", "7", nil); err != nil {
+		t.Fatal(err)
+	}
+	events, err := GetAuditEventsAfter(db, last, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []*AuditEvent
+	for _, e := range events {
+		if e.Actor == "testrecordaudit" {
+			recorded = append(recorded, e)
+		}
+	}
+	if len(recorded) != 2 {
+		t.Fatalf("expected 2 events got %v", len(recorded))
+	}
+	if recorded[0].Action != AuditActionAttributesSet || string(recorded[0].Details) != `{"affected": 2}` {
+		t.Errorf("unexpected first event %+v details %s", recorded[0], recorded[0].Details)
+	}
+	if recorded[1].ResourceType != "pirg" || recorded[1].ResourceId != "7" || recorded[1].Details != nil {
+		t.Errorf("unexpected second event %+v details %s", recorded[1], recorded[1].Details)
+	}
+}
+
+func TestGetChangesAfter(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	if _, err := db.Exec("DELETE FROM audit_log WHERE actor = 'testgetchangesafter'"); err != nil {
+		t.Fatal(err)
+	}
+	// created newest first so time and id order disagree, two share a time
+	at := time.Date(2005, 3, 1, 10, 0, 0, 0, time.UTC)
+	times := []time.Time{at.Add(2 * time.Hour), at.Add(time.Hour), at, at}
+	actions := []string{AuditActionCreated, AuditActionMemberAdded, AuditActionRead, AuditActionUpdated}
+	var ids []int
+	for i, ts := range times {
+		e, err := CreateAuditEvent(db, &AuditEventRequest{
+			Actor:        "testgetchangesafter",
+			Action:       actions[i],
+			ResourceType: "pirg",
+			ResourceId:   "1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id = $2", ts, e.Id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.Id)
+	}
+	// the read is left out
+	want := []int{ids[3], ids[1], ids[0]}
+	got, err := GetChangesAfter(db, at, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v changes got %v", len(want), len(got))
+	}
+	for i, e := range got {
+		if e.Id != want[i] {
+			t.Errorf("expected change %v at %v got %v", want[i], i, e.Id)
+		}
+	}
+	// resuming after an event skips the ones before it at the same time
+	got, err = GetChangesAfter(db, at, ids[3], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Id != ids[1] {
+		t.Fatalf("expected only change %v got %v", ids[1], got)
+	}
+}
+
+func TestPurgeAuditEvents(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	// well before any other test's events so the purge only touches these
+	cutoff := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ids []int
+	for _, occurredAt := range []time.Time{cutoff.AddDate(-1, 0, 0), cutoff.AddDate(0, 0, 1)} {
+		e, err := CreateAuditEvent(db, &AuditEventRequest{
+			Actor:        "testpurgeauditevents",
+			Action:       "create",
+			ResourceType: "user",
+			ResourceId:   "1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id = $2", occurredAt, e.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.Id)
+	}
+	old, recent := ids[0], ids[1]
+
+	// bounded by an id below the old event, nothing is deleted
+	count, err := PurgeAuditEvents(db, cutoff, old-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no events purged below id %v got %v", old, count)
+	}
+	count, err = PurgeAuditEvents(db, cutoff, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 event purged got %v", count)
+	}
+	var remaining []int
+	rows, err := db.Query("SELECT id FROM audit_log WHERE id = ANY($1) ORDER BY id", pq.Array(ids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		remaining = append(remaining, id)
+	}
+	if len(remaining) != 1 || remaining[0] != recent {
+		t.Fatalf("expected only the recent event %v to remain got %v", recent, remaining)
+	}
+}
+
+func TestPirgMembershipHistory(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	owner, err := CreateUser(db, &UserRequest{
+		Username:  "testmembershiphistoryowner",
+		Email:     "testmembershiphistoryowner@localhost",
+		FirstName: "Test",
+		LastName:  "Owner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := CreateUser(db, &UserRequest{
+		Username:  "testmembershiphistorymember",
+		Email:     "testmembershiphistorymember@localhost",
+		FirstName: "Test",
+		LastName:  "Member",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testmembershiphistory",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// add then remove the member
+	err = RecordPirgMembershipChanges(db, "user:1", pirg.Id, []int{owner.Id}, []int{owner.Id, member.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RecordPirgMembershipChanges(db, "user:1", pirg.Id, []int{owner.Id, member.Id}, []int{owner.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, total, err := GetPirgMembershipHistory(db, pirg.Id, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(events) != 2 {
+		t.Fatalf("expected 2 events got total %v len %v", total, len(events))
+	}
+	if events[0].Action != AuditActionMemberAdded || events[1].Action != AuditActionMemberRemoved {
+		t.Fatalf("expected add then remove got %v then %v", events[0].Action, events[1].Action)
+	}
+	for _, e := range events {
+		if e.UserId != member.Id || e.Username != member.Username {
+			t.Fatalf("expected member %v got %v (%v)", member.Id, e.UserId, e.Username)
+		}
+		if e.Actor != "user:1" {
+			t.Fatalf("expected actor user:1 got %v", e.Actor)
+		}
+	}
+	// pagination
+	events, total, err = GetPirgMembershipHistory(db, pirg.Id, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(events) != 1 || events[0].Action != AuditActionMemberRemoved {
+		t.Fatalf("expected the second event on its own page, got total %v events %+v", total, events)
+	}
+}
+
+func TestGetPirgMembersAsOf(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"testmembersasofa", "testmembersasofb"} {
+		u, err := CreateUser(db, &UserRequest{
+			Username:  name,
+			Email:     name + "@localhost",
+			FirstName: "Test",
+			LastName:  "Member",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	a, b := users[0], users[1]
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testmembersasof",
+		OwnerId:  a.Id,
+		AdminIds: []int{a.Id},
+		UserIds:  []int{a.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a joins on the 1st, b on the 2nd, a leaves on the 3rd and b rejoins
+	// after leaving on the 4th
+	day := func(d int) time.Time { return time.Date(2001, 1, d, 12, 0, 0, 0, time.UTC) }
+	changes := []struct {
+		at     time.Time
+		before []int
+		after  []int
+	}{
+		{day(1), []int{}, []int{a.Id}},
+		{day(2), []int{a.Id}, []int{a.Id, b.Id}},
+		{day(3), []int{a.Id, b.Id}, []int{b.Id}},
+		{day(4), []int{b.Id}, []int{}},
+		{day(4).Add(time.Hour), []int{}, []int{b.Id}},
+	}
+	for _, c := range changes {
+		lastId, err := GetLastAuditEventId(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = RecordPirgMembershipChanges(db, "user:1", pirg.Id, c.before, c.after); err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("UPDATE audit_log SET occurred_at = $1 WHERE id > $2 AND resource_type = 'pirg' AND resource_id = $3",
+			c.at, lastId, strconv.Itoa(pirg.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		asOf time.Time
+		want []int
+	}{
+		{day(1).Add(-time.Hour), []int{}},
+		{day(1), []int{a.Id}},
+		{day(2).Add(time.Minute), []int{a.Id, b.Id}},
+		{day(3).Add(time.Minute), []int{b.Id}},
+		{day(4).Add(time.Minute), []int{}},
+		{day(5), []int{b.Id}},
+	}
+	for _, tt := range tests {
+		members, total, err := GetPirgMembersAsOf(db, pirg.Id, tt.asOf, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []int{}
+		for _, m := range members {
+			got = append(got, m.UserId)
+		}
+		if total != len(tt.want) || !slices.Equal(got, tt.want) {
+			t.Fatalf("as of %v expected %v got %v (total %v)", tt.asOf, tt.want, got, total)
+		}
+	}
+	// joined_at is when b was last added
+	members, _, err := GetPirgMembersAsOf(db, pirg.Id, day(5), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !members[0].JoinedAt.Equal(day(4).Add(time.Hour)) || members[0].Username != b.Username {
+		t.Fatalf("expected %v joined at %v got %+v", b.Username, day(4).Add(time.Hour), members[0])
+	}
+	// pagination
+	members, total, err := GetPirgMembersAsOf(db, pirg.Id, day(2).Add(time.Minute), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(members) != 1 || members[0].UserId != b.Id {
+		t.Fatalf("expected %v on its own page got total %v members %+v", b.Id, total, members)
+	}
+}
@@ -1,12 +1,85 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
 )
 
+// querier is satisfied by both *sql.DB and *sql.Tx so helpers
+// can run either on their own or as part of a transaction
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// dryRunKey marks a context WithTx rolls its transaction back in
+type dryRunKey struct{}
+
+// WithDryRun returns ctx marked so WithTx rolls back the transaction instead
+// of committing it once fn returns nil. fn runs every statement it would
+// otherwise, so a dry run finds the same changes and errors a real one does.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether ctx was marked by WithDryRun
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// txContext is the context for the transaction of a data function that can
+// be dry run
+func txContext(dryRun bool) context.Context {
+	if dryRun {
+		return WithDryRun(context.Background())
+	}
+	return context.Background()
+}
+
+// WithTx runs fn in a transaction that's committed if fn returns nil and
+// rolled back if it returns an error or panics. The panic is carried on
+// once the transaction is rolled back. With a ctx from WithDryRun it's
+// rolled back when fn returns nil too.
+func WithTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			slog.Warn("failed to roll back transaction", "package", "data", "method", "WithTx", "error", rbErr)
+		}
+		return err
+	}
+	if IsDryRun(ctx) {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
 type DBRequest struct {
 	Host       string
 	Port       int
@@ -14,8 +87,52 @@ type DBRequest struct {
 	Password   string
 	DBName     string
 	DisableSSL bool
+	// Driver is DriverPq, the default, or DriverPgx. pgx connections are
+	// kept in a pgxpool and database/sql borrows them from it.
+	Driver string
+	// SSLMode is a libpq sslmode, disable, require, verify-ca or verify-full.
+	// It takes precedence over DisableSSL, which is the same as disable.
+	// The cert paths are passed as sslrootcert, sslcert and sslkey if set.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// MaxOpenConns limits the connection pool size, 0 is unlimited.
+	// With pgx it's the pgxpool's size, and 0 is pgxpool's default.
+	MaxOpenConns int
+	// MaxIdleConns is how many connections are kept while idle, 0 is
+	// defaultMaxIdleConns capped at MaxOpenConns. It's ignored with pgx,
+	// whose pool keeps them.
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections that have been open longer,
+	// 0 keeps them forever
+	ConnMaxLifetime time.Duration
+	// QueryTimeout cancels queries and execs that run longer, and their
+	// errors wrap ErrQueryTimeout. 0 doesn't time them out.
+	QueryTimeout time.Duration
+	// LogQueries logs every query with redacted arguments at debug level
+	LogQueries bool
+	// ConnectAttempts is how many times the first ping is tried while the
+	// database refuses connections, by default 10. 1 fails fast.
+	ConnectAttempts int
+	// ConnectBackoff is the wait after the first refused ping, by default
+	// 500ms. It doubles on each attempt, up to maxConnectBackoff.
+	ConnectBackoff time.Duration
 }
 
+// Postgres drivers NewDBConn can connect with
+const (
+	DriverPq  = "pq"
+	DriverPgx = "pgx"
+)
+
+const (
+	defaultConnectAttempts = 10
+	defaultConnectBackoff  = 500 * time.Millisecond
+	maxConnectBackoff      = 10 * time.Second
+	defaultMaxIdleConns    = 5
+)
+
 func NewDBRequest(host string, port int, user, password, dbname string, disableSSL bool) (DBRequest, error) {
 	return DBRequest{
 		Host:       host,
@@ -27,23 +144,211 @@ func NewDBRequest(host string, port int, user, password, dbname string, disableS
 	}, nil
 }
 
-func NewDBConn(dbr DBRequest) (*sql.DB, error) {
-	connStr := fmt.Sprintf("postgresql://%s:%s@%s/%s", dbr.User, dbr.Password, dbr.Host, dbr.DBName)
-	if dbr.DisableSSL {
-		connStr = connStr + "?sslmode=disable"
+// connString is the connection url for the request. The credentials are escaped
+// and ssl params that aren't set are left out so libpq's defaults apply.
+func (dbr DBRequest) connString() string {
+	host := dbr.Host
+	if dbr.Port != 0 {
+		host = net.JoinHostPort(dbr.Host, strconv.Itoa(dbr.Port))
+	}
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(dbr.User, dbr.Password),
+		Host:   host,
+		Path:   "/" + dbr.DBName,
 	}
-	dbConn, err := sql.Open("postgres", connStr)
+	sslMode := dbr.SSLMode
+	if sslMode == "" && dbr.DisableSSL {
+		sslMode = "disable"
+	}
+	q := url.Values{}
+	for k, v := range map[string]string{
+		"sslmode":     sslMode,
+		"sslrootcert": dbr.SSLRootCert,
+		"sslcert":     dbr.SSLCert,
+		"sslkey":      dbr.SSLKey,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func NewDBConn(dbr DBRequest) (*sql.DB, error) {
+	connector, err := dbr.connector()
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err.Error())
 	}
-	if err = dbConn.Ping(); err != nil {
+	if dbr.QueryTimeout > 0 {
+		connector = &timeoutConnector{Connector: connector, timeout: dbr.QueryTimeout}
+	}
+	if dbr.LogQueries {
+		connector = &loggingConnector{Connector: connector}
+	}
+	dbConn := sql.OpenDB(connector)
+	dbConn.SetMaxOpenConns(dbr.MaxOpenConns)
+	dbConn.SetConnMaxLifetime(dbr.ConnMaxLifetime)
+	if dbr.Driver == DriverPgx {
+		// the pgxpool keeps the idle connections, database/sql holding on
+		// to them too would leave the pool with none to hand out
+		dbConn.SetMaxIdleConns(0)
+	} else {
+		dbConn.SetMaxIdleConns(dbr.maxIdleConns())
+	}
+	attempts := dbr.ConnectAttempts
+	if attempts == 0 {
+		attempts = defaultConnectAttempts
+	}
+	backoff := dbr.ConnectBackoff
+	if backoff == 0 {
+		backoff = defaultConnectBackoff
+	}
+	if err = pingWithRetry(dbConn.Ping, attempts, backoff, time.Sleep); err != nil {
+		dbConn.Close()
 		return nil, fmt.Errorf("failed to connect to database: %v", err.Error())
 	}
 	return dbConn, nil
 }
 
+// maxIdleConns is the idle pool size of the request, see MaxIdleConns
+func (dbr DBRequest) maxIdleConns() int {
+	if dbr.MaxIdleConns > 0 {
+		return dbr.MaxIdleConns
+	}
+	if dbr.MaxOpenConns > 0 {
+		return min(defaultMaxIdleConns, dbr.MaxOpenConns)
+	}
+	return defaultMaxIdleConns
+}
+
+// warmupIdleConns is the idle pool size while n connections are warmed up.
+// With pq the idle pool has to be able to hold them, otherwise they're closed
+// as soon as they're released, but it never shrinks below maxIdleConns. With
+// pgx it stays 0, the pgxpool keeps the warmed connections.
+func (dbr DBRequest) warmupIdleConns(n int) int {
+	if dbr.Driver == DriverPgx {
+		return 0
+	}
+	return max(dbr.maxIdleConns(), n)
+}
+
+// connector returns the driver's connector for the request
+func (dbr DBRequest) connector() (driver.Connector, error) {
+	connStr := dbr.connString()
+	switch dbr.Driver {
+	case "", DriverPq:
+		return pq.NewConnector(connStr)
+	case DriverPgx:
+		poolConfig, err := pgxpool.ParseConfig(connStr)
+		if err != nil {
+			return nil, err
+		}
+		if dbr.MaxOpenConns > 0 {
+			poolConfig.MaxConns = int32(dbr.MaxOpenConns)
+		}
+		if dbr.ConnMaxLifetime > 0 {
+			poolConfig.MaxConnLifetime = dbr.ConnMaxLifetime
+		}
+		// the pool connects lazily, so the ping below is still the first connection
+		pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &pgxPoolConnector{Connector: stdlib.GetPoolConnector(pool), pool: pool}, nil
+	}
+	return nil, fmt.Errorf("unknown database driver %s", dbr.Driver)
+}
+
+// pgxPoolConnector borrows connections from a pgxpool. Closing the *sql.DB
+// closes it, and so the pool.
+type pgxPoolConnector struct {
+	driver.Connector
+	pool *pgxpool.Pool
+}
+
+func (c *pgxPoolConnector) Close() error {
+	c.pool.Close()
+	return nil
+}
+
+// pingWithRetry pings until it succeeds, fails with an error other than the
+// database not accepting connections yet, or has tried attempts times.
+// The wait between attempts starts at backoff and doubles up to maxConnectBackoff.
+func pingWithRetry(ping func() error, attempts int, backoff time.Duration, sleep func(time.Duration)) error {
+	for attempt := 1; ; attempt++ {
+		err := ping()
+		if err == nil || attempt >= attempts || !isConnectionRefused(err) {
+			return err
+		}
+		slog.Warn("database not accepting connections, retrying", "attempt", attempt, "attempts", attempts, "backoff", backoff, "package", "data", "method", "NewDBConn", "error", err)
+		sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+}
+
+// isConnectionRefused reports whether the database isn't accepting connections
+// yet, either because nothing is listening or because it's still starting up
+func isConnectionRefused(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "57P03" // cannot_connect_now
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "57P03"
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// WarmupDBConn opens n connections concurrently and returns them to the pool
+// db was opened with for dbr, so the first requests don't pay for connection
+// setup. n is capped at the pool's max open connections.
+func WarmupDBConn(db *sql.DB, dbr DBRequest, n int) error {
+	maxOpen := db.Stats().MaxOpenConnections
+	if maxOpen > 0 && n > maxOpen {
+		n = maxOpen
+	}
+	if n <= 0 {
+		return nil
+	}
+	slog.Debug("warming up database connections", "count", n, "package", "data", "method", "WarmupDBConn")
+	db.SetMaxIdleConns(dbr.warmupIdleConns(n))
+
+	// every connection is held until all are open, so the pings can't share one
+	conns := make([]*sql.Conn, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := db.Conn(context.Background())
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			conns[i] = conn
+			errs[i] = conn.PingContext(context.Background())
+		}(i)
+	}
+	wg.Wait()
+	for _, conn := range conns {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to warm up database connections: %v", err)
+		}
+	}
+	return nil
+}
+
 func WipeDB(db *sql.DB) error {
-	tables := []string{"pirgs_users", "pirgs_groups", "pirgs_admins", "groups_users", "pirgs", "users"}
+	tables := []string{"audit_log", "posix_ids", "user_attributes", "role_grants", "pirgs_users", "pirgs_groups", "pirgs_admins", "groups_users", "pirgs", "users"}
 	for _, table := range tables {
 		q := fmt.Sprintf("DELETE FROM %s", table)
 		_, err := db.Exec(q)
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// RoleGrant temporarily gives a user a role until ExpiresAt
+type RoleGrant struct {
+	Id        int
+	UserId    int
+	Role      string
+	GrantedBy string
+	Reason    string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type RoleGrantRequest struct {
+	UserId    int
+	Role      string
+	GrantedBy string
+	Reason    string
+	ExpiresAt time.Time
+}
+
+// CreateRoleGrant stores a time-limited role grant
+func CreateRoleGrant(db *sql.DB, gr *RoleGrantRequest) (*RoleGrant, error) {
+	slog.Debug("creating role grant in database", "user_id", gr.UserId, "role", gr.Role, "package", "data", "method", "CreateRoleGrant")
+	if err := validateUserId(db, gr.UserId); err != nil {
+		return nil, err
+	}
+	g := RoleGrant{
+		UserId:    gr.UserId,
+		Role:      gr.Role,
+		GrantedBy: gr.GrantedBy,
+		Reason:    gr.Reason,
+		ExpiresAt: gr.ExpiresAt.UTC(),
+	}
+	err := db.QueryRow(`
+		INSERT INTO role_grants (user_id, role, granted_by, reason, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		g.UserId, g.Role, g.GrantedBy, g.Reason, g.ExpiresAt).Scan(&g.Id, &g.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// GetActiveRoleGrant returns the user's unexpired grant, preferring admin
+// grants over others, or nil if the user has no active grant
+func GetActiveRoleGrant(db *sql.DB, userId int) (*RoleGrant, error) {
+	var g RoleGrant
+	err := db.QueryRow(`
+		SELECT id, user_id, role, granted_by, reason, expires_at, created_at
+		FROM role_grants
+		WHERE user_id = $1 AND expires_at > $2
+		ORDER BY role = 'admin' DESC, expires_at DESC
+		LIMIT 1`, userId, time.Now().UTC()).Scan(
+		&g.Id, &g.UserId, &g.Role, &g.GrantedBy, &g.Reason, &g.ExpiresAt, &g.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
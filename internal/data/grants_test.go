@@ -0,0 +1,51 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetActiveRoleGrant(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	user, err := CreateUser(db, &UserRequest{
+		Username:  "testactiverolegrant",
+		Email:     "testactiverolegrant@localhost",
+		FirstName: "Test",
+		LastName:  "Grant",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, err := CreateRoleGrant(db, &RoleGrantRequest{
+		UserId:    user.Id,
+		Role:      "admin",
+		GrantedBy: "user:1",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := GetActiveRoleGrant(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active == nil || active.Id != grant.Id {
+		t.Fatalf("expected active grant %v got %+v", grant.Id, active)
+	}
+
+	// once expired the grant is ignored
+	_, err = db.Exec("UPDATE role_grants SET expires_at = $1 WHERE id = $2", time.Now().Add(-time.Minute).UTC(), grant.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err = GetActiveRoleGrant(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != nil {
+		t.Fatalf("expected no active grant got %+v", active)
+	}
+}
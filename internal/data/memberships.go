@@ -0,0 +1,410 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MembershipExpiryActor is the audit actor for memberships removed because
+// they expired
+const MembershipExpiryActor = "system:membership-expiry"
+
+// AddPirgMember adds the user to the pirg, or sets the expiry of their existing
+// membership. A nil expiresAt makes the membership permanent.
+// The returned bool is true if the user wasn't already a member.
+func AddPirgMember(db *sql.DB, pirgId int, userId int, expiresAt *time.Time) (*PirgMember, bool, error) {
+	slog.Debug("adding pirg member to database", "pirg_id", pirgId, "user_id", userId, "package", "data", "method", "AddPirgMember")
+	if err := validateUserId(db, userId); err != nil {
+		return nil, false, err
+	}
+	var expires any
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var ownerId int
+	err = tx.QueryRow("SELECT owner_id FROM pirgs WHERE id = $1 FOR UPDATE", pirgId).Scan(&ownerId)
+	if err != nil {
+		return nil, false, err
+	}
+	if userId == ownerId && expiresAt != nil {
+		return nil, false, fmt.Errorf("the pirg owner's membership can't expire")
+	}
+	if _, err = expirePirgMembers(tx, pirgId); err != nil {
+		return nil, false, err
+	}
+	res, err := tx.Exec("UPDATE pirgs_users SET expires_at = $3 WHERE pirg_id = $1 AND user_id = $2", pirgId, userId, expires)
+	if err != nil {
+		return nil, false, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if updated == 0 {
+		_, err = tx.Exec("INSERT INTO pirgs_users (pirg_id, user_id, expires_at) VALUES ($1, $2, $3)", pirgId, userId, expires)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	var m PirgMember
+	err = db.QueryRow(`
+		SELECT u.id, u.username, u.email, u.firstname, u.lastname,
+			EXISTS (SELECT 1 FROM pirgs_admins pa WHERE pa.pirg_id = pu.pirg_id AND pa.user_id = u.id),
+			pu.created_at, pu.expires_at
+		FROM pirgs_users pu
+		JOIN users u ON u.id = pu.user_id
+		WHERE pu.pirg_id = $1 AND pu.user_id = $2`, pirgId, userId).Scan(
+		&m.UserId, &m.Username, &m.Email, &m.FirstName, &m.LastName, &m.IsAdmin, &m.JoinedAt, &m.ExpiresAt)
+	if err != nil {
+		return nil, false, err
+	}
+	return &m, updated == 0, nil
+}
+
+// ErrRemovePirgOwner is returned when removing the owner of a pirg from its members
+var ErrRemovePirgOwner = errors.New("the pirg owner can't be removed from the pirg")
+
+// RemovePirgMember removes the user from the pirg's members, along with their
+// admin rights. The returned bool is false if the user wasn't a member.
+func RemovePirgMember(db *sql.DB, pirgId int, userId int) (bool, error) {
+	slog.Debug("removing pirg member from database", "pirg_id", pirgId, "user_id", userId, "package", "data", "method", "RemovePirgMember")
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var ownerId int
+	err = tx.QueryRow("SELECT owner_id FROM pirgs WHERE id = $1 FOR UPDATE", pirgId).Scan(&ownerId)
+	if err != nil {
+		return false, err
+	}
+	if userId == ownerId {
+		return false, ErrRemovePirgOwner
+	}
+	if _, err = expirePirgMembers(tx, pirgId); err != nil {
+		return false, err
+	}
+	if err = checkLastPirgAdmin(tx, pirgId, userId); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("DELETE FROM pirgs_users WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
+	if err != nil {
+		return false, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if err = deletePirgAdmin(tx, pirgId, userId); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return removed > 0, nil
+}
+
+// ErrNotPirgMember is returned when making a user who isn't a member of a pirg one of its admins
+var ErrNotPirgMember = errors.New("the user isn't a member of the pirg")
+
+// ErrRemoveLastPirgAdmin is returned when removing the only admin of a pirg,
+// either from its admins or from its members
+var ErrRemoveLastPirgAdmin = errors.New("the last admin of the pirg can't be removed")
+
+// IsPirgAdmin reports whether the user is an admin of the pirg.
+// Admin rights end with the user's membership.
+func IsPirgAdmin(db *sql.DB, pirgId int, userId int) (bool, error) {
+	slog.Debug("checking pirg admin in database", "pirg_id", pirgId, "user_id", userId, "package", "data", "method", "IsPirgAdmin")
+	var isAdmin bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM active_pirgs_admins WHERE pirg_id = $1 AND user_id = $2)", pirgId, userId).Scan(&isAdmin)
+	return isAdmin, err
+}
+
+// SetPirgAdmin makes the member an admin of the pirg. The returned bool is
+// false if they already were one.
+func SetPirgAdmin(db *sql.DB, pirgId int, userId int) (bool, error) {
+	slog.Debug("adding pirg admin to database", "pirg_id", pirgId, "user_id", userId, "package", "data", "method", "SetPirgAdmin")
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// pirgs_admins has no unique constraint, locking the pirg keeps
+	// concurrent requests from adding the admin twice
+	var id int
+	if err = tx.QueryRow("SELECT id FROM pirgs WHERE id = $1 FOR UPDATE", pirgId).Scan(&id); err != nil {
+		return false, err
+	}
+	if _, err = expirePirgMembers(tx, pirgId); err != nil {
+		return false, err
+	}
+	var isMember, isAdmin bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM active_pirgs_users WHERE pirg_id = $1 AND user_id = $2),
+			EXISTS (SELECT 1 FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2)`, pirgId, userId).Scan(&isMember, &isAdmin)
+	if err != nil {
+		return false, err
+	}
+	if !isMember {
+		return false, ErrNotPirgMember
+	}
+	if isAdmin {
+		return false, nil
+	}
+	if err = insertPirgMemberRows(tx, "pirgs_admins", pirgId, []int{userId}); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemovePirgAdmin takes the user's admin rights on the pirg, they stay a member.
+// The returned bool is false if they weren't an admin.
+func RemovePirgAdmin(db *sql.DB, pirgId int, userId int) (bool, error) {
+	slog.Debug("removing pirg admin from database", "pirg_id", pirgId, "user_id", userId, "package", "data", "method", "RemovePirgAdmin")
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err = tx.QueryRow("SELECT id FROM pirgs WHERE id = $1 FOR UPDATE", pirgId).Scan(&id); err != nil {
+		return false, err
+	}
+	if _, err = expirePirgMembers(tx, pirgId); err != nil {
+		return false, err
+	}
+	if err = checkLastPirgAdmin(tx, pirgId, userId); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("DELETE FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
+	if err != nil {
+		return false, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return removed > 0, nil
+}
+
+// checkLastPirgAdmin returns ErrRemoveLastPirgAdmin if the user is the pirg's
+// only admin. The pirg row should be locked so the count can't change.
+func checkLastPirgAdmin(q querier, pirgId int, userId int) error {
+	var isAdmin bool
+	var admins int
+	err := q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM active_pirgs_admins WHERE pirg_id = $1 AND user_id = $2),
+			(SELECT COUNT(DISTINCT user_id) FROM active_pirgs_admins WHERE pirg_id = $1)`, pirgId, userId).Scan(&isAdmin, &admins)
+	if err != nil {
+		return err
+	}
+	if isAdmin && admins == 1 {
+		return ErrRemoveLastPirgAdmin
+	}
+	return nil
+}
+
+// SweepExpiredPirgMembers deletes every expired membership, along with the
+// member's admin rights, and returns how many were removed
+func SweepExpiredPirgMembers(db *sql.DB) (int, error) {
+	slog.Debug("sweeping expired pirg members from database", "package", "data", "method", "SweepExpiredPirgMembers")
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	count, err := expirePirgMembers(tx, 0)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// expirePirgMembers deletes the expired memberships of the pirg, or of every
+// pirg if pirgId is 0, and records a member_removed audit event for each
+func expirePirgMembers(q querier, pirgId int) (int, error) {
+	rows, err := q.Query(`
+		DELETE FROM pirgs_users
+		WHERE ($1 = 0 OR pirg_id = $1) AND expires_at <= (NOW() AT TIME ZONE 'UTC')
+		RETURNING pirg_id, user_id, expires_at`, pirgId)
+	if err != nil {
+		return 0, err
+	}
+	type expired struct {
+		pirgId    int
+		userId    int
+		expiredAt time.Time
+	}
+	var removed []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.pirgId, &e.userId, &e.expiredAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		removed = append(removed, e)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, e := range removed {
+		if err = deletePirgAdmin(q, e.pirgId, e.userId); err != nil {
+			return 0, err
+		}
+		details, err := json.Marshal(map[string]any{"user_id": e.userId, "expired_at": e.expiredAt})
+		if err != nil {
+			return 0, err
+		}
+		_, err = insertAuditEvent(q, &AuditEventRequest{
+			Actor:        MembershipExpiryActor,
+			Action:       AuditActionMemberRemoved,
+			ResourceType: "pirg",
+			ResourceId:   strconv.Itoa(e.pirgId),
+			Details:      details,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(removed) > 0 {
+		slog.Debug("removed expired pirg members", "count", len(removed), "package", "data", "method", "expirePirgMembers")
+	}
+	return len(removed), nil
+}
+
+// MembershipFilter selects pirg memberships for bulk changes. A membership
+// has to match every condition that's set.
+type MembershipFilter struct {
+	PirgIds []int
+	// UserAttributes matches members with all of these user attributes,
+	// such as the grant that sponsors them
+	UserAttributes map[string]string
+	// ExpiresBefore matches memberships that expire before it, memberships
+	// that don't expire never match
+	ExpiresBefore *time.Time
+}
+
+// IsEmpty reports whether the filter has no conditions, and so matches every membership
+func (f MembershipFilter) IsEmpty() bool {
+	return len(f.PirgIds) == 0 && len(f.UserAttributes) == 0 && f.ExpiresBefore == nil
+}
+
+// ErrEmptyMembershipFilter is returned by bulk changes that would otherwise apply to every membership
+var ErrEmptyMembershipFilter = errors.New("filter must have at least one condition")
+
+// DeactivatePirgMembersBulk removes every membership matching the filter, along
+// with the members' admin rights, in one transaction, and records a
+// member_removed audit event for each. Pirg owners always stay members of their
+// pirgs, so their memberships are skipped. Returns how many were removed.
+func DeactivatePirgMembersBulk(db *sql.DB, filter MembershipFilter, actor string) (int, error) {
+	slog.Debug("deactivating pirg members in bulk in database", "package", "data", "method", "DeactivatePirgMembersBulk")
+	if filter.IsEmpty() {
+		return 0, ErrEmptyMembershipFilter
+	}
+	where, args := membershipFilterClause(filter)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`
+		DELETE FROM pirgs_users pu
+		USING pirgs p
+		WHERE p.id = pu.pirg_id AND pu.user_id <> p.owner_id AND `+where+`
+		RETURNING pu.pirg_id, pu.user_id`, args...)
+	if err != nil {
+		return 0, err
+	}
+	type removed struct {
+		pirgId int
+		userId int
+	}
+	var members []removed
+	for rows.Next() {
+		var m removed
+		if err := rows.Scan(&m.pirgId, &m.userId); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, m := range members {
+		if err = deletePirgAdmin(tx, m.pirgId, m.userId); err != nil {
+			return 0, err
+		}
+		_, err = insertAuditEvent(tx, &AuditEventRequest{
+			Actor:        actor,
+			Action:       AuditActionMemberRemoved,
+			ResourceType: "pirg",
+			ResourceId:   strconv.Itoa(m.pirgId),
+			Details:      json.RawMessage(fmt.Sprintf(`{"user_id": %d}`, m.userId)),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(members), tx.Commit()
+}
+
+// membershipFilterClause returns the conditions selecting the memberships pu
+// matching the filter, and their parameters
+func membershipFilterClause(filter MembershipFilter) (string, []any) {
+	var where []string
+	var args []any
+	if len(filter.PirgIds) > 0 {
+		args = append(args, pq.Array(filter.PirgIds))
+		where = append(where, fmt.Sprintf("pu.pirg_id = ANY($%d)", len(args)))
+	}
+	if filter.ExpiresBefore != nil {
+		args = append(args, filter.ExpiresBefore.UTC())
+		where = append(where, fmt.Sprintf("pu.expires_at < $%d", len(args)))
+	}
+	// sorted so the generated query is stable for the same filter
+	attrKeys := make([]string, 0, len(filter.UserAttributes))
+	for k := range filter.UserAttributes {
+		attrKeys = append(attrKeys, k)
+	}
+	slices.Sort(attrKeys)
+	for _, k := range attrKeys {
+		args = append(args, k, filter.UserAttributes[k])
+		where = append(where, fmt.Sprintf("EXISTS (SELECT 1 FROM user_attributes ua WHERE ua.user_id = pu.user_id AND ua.key = $%d AND ua.value = $%d)",
+			len(args)-1, len(args)))
+	}
+	return strings.Join(where, " AND "), args
+}
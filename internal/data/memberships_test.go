@@ -0,0 +1,306 @@
+package data
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPirgMembershipExpiry(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"owner", "guest"} {
+		username := "testmembershipexpiry" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Expiry",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, guest := users[0], users[1]
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testmembershipexpiry",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	member, created, err := AddPirgMember(db, pirg.Id, guest.Id, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || member.ExpiresAt == nil || !member.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected a new member expiring at %v got %+v", expiresAt, member)
+	}
+	if _, err = db.Exec("INSERT INTO pirgs_admins (pirg_id, user_id) VALUES ($1, $2)", pirg.Id, guest.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = AddPirgMember(db, pirg.Id, owner.Id, &expiresAt); err == nil {
+		t.Fatal("expected an error setting an expiry on the owner")
+	}
+
+	// once expired the membership is hidden straight away
+	_, err = db.Exec("UPDATE pirgs_users SET expires_at = $1 WHERE pirg_id = $2 AND user_id = $3",
+		time.Now().Add(-time.Minute).UTC(), pirg.Id, guest.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(expired.UserIds, guest.Id) || slices.Contains(expired.AdminIds, guest.Id) {
+		t.Fatalf("expected expired member to be excluded got users %v admins %v", expired.UserIds, expired.AdminIds)
+	}
+	members, total, err := GetPirgMembers(db, pirg.Id, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(members) != 1 || members[0].UserId != owner.Id {
+		t.Fatalf("expected only the owner to be listed got total %v members %+v", total, members)
+	}
+
+	// and the sweep deletes it and audits the removal
+	swept, err := SweepExpiredPirgMembers(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swept < 1 {
+		t.Fatalf("expected at least 1 swept membership got %v", swept)
+	}
+	var rows int
+	err = db.QueryRow("SELECT COUNT(*) FROM pirgs_users WHERE pirg_id = $1 AND user_id = $2", pirg.Id, guest.Id).Scan(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) + $3 FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2", pirg.Id, guest.Id, rows).Scan(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Fatalf("expected the swept membership and admin rows to be deleted")
+	}
+	events, _, err := GetPirgMembershipHistory(db, pirg.Id, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := events[len(events)-1]
+	if last.Action != AuditActionMemberRemoved || last.UserId != guest.Id || last.Actor != MembershipExpiryActor {
+		t.Fatalf("expected the sweep to record the removal got %+v", last)
+	}
+}
+
+func TestRemovePirgMember(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"owner", "member"} {
+		username := "testremovepirgmember" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "RemoveMember",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, member := users[0], users[1]
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testremovepirgmember",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id, member.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := RemovePirgMember(db, pirg.Id, member.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !removed {
+		t.Fatal("expected the member to be removed")
+	}
+	got, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(got.UserIds, member.Id) || slices.Contains(got.AdminIds, member.Id) {
+		t.Fatalf("expected %v to no longer be a member or admin got users %v admins %v", member.Username, got.UserIds, got.AdminIds)
+	}
+
+	// removing a non member changes nothing
+	if removed, err = RemovePirgMember(db, pirg.Id, member.Id); err != nil || removed {
+		t.Fatalf("expected nothing to remove got %v %v", removed, err)
+	}
+	if _, err = RemovePirgMember(db, pirg.Id, owner.Id); !errors.Is(err, ErrRemovePirgOwner) {
+		t.Fatalf("expected ErrRemovePirgOwner got %v", err)
+	}
+}
+
+func TestPirgAdmins(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"owner", "member", "outsider"} {
+		username := "testpirgadmins" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "PirgAdmins",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, member, outsider := users[0], users[1], users[2]
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testpirgadmins",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isAdmin := func(userId int) bool {
+		t.Helper()
+		ok, err := IsPirgAdmin(db, pirg.Id, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if !isAdmin(owner.Id) || isAdmin(member.Id) {
+		t.Fatalf("expected only the owner to be an admin")
+	}
+	if _, err = SetPirgAdmin(db, pirg.Id, outsider.Id); !errors.Is(err, ErrNotPirgMember) {
+		t.Fatalf("expected ErrNotPirgMember got %v", err)
+	}
+	if created, err := SetPirgAdmin(db, pirg.Id, member.Id); err != nil || !created {
+		t.Fatalf("expected the member to be made an admin got %v %v", created, err)
+	}
+	if created, err := SetPirgAdmin(db, pirg.Id, member.Id); err != nil || created {
+		t.Fatalf("expected setting an admin again to change nothing got %v %v", created, err)
+	}
+	if !isAdmin(member.Id) {
+		t.Fatalf("expected the member to be an admin")
+	}
+
+	if removed, err := RemovePirgAdmin(db, pirg.Id, owner.Id); err != nil || !removed {
+		t.Fatalf("expected the owner's admin rights to be removed got %v %v", removed, err)
+	}
+	if removed, err := RemovePirgAdmin(db, pirg.Id, owner.Id); err != nil || removed {
+		t.Fatalf("expected nothing to remove got %v %v", removed, err)
+	}
+	// the member is the only admin left, so can't lose their rights or membership
+	if _, err = RemovePirgAdmin(db, pirg.Id, member.Id); !errors.Is(err, ErrRemoveLastPirgAdmin) {
+		t.Fatalf("expected ErrRemoveLastPirgAdmin got %v", err)
+	}
+	if _, err = RemovePirgMember(db, pirg.Id, member.Id); !errors.Is(err, ErrRemoveLastPirgAdmin) {
+		t.Fatalf("expected ErrRemoveLastPirgAdmin got %v", err)
+	}
+	if isAdmin(owner.Id) || !isAdmin(member.Id) {
+		t.Fatalf("expected only the member to be an admin")
+	}
+}
+
+func TestDeactivatePirgMembersBulk(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"owner", "granta", "grantb", "other"} {
+		username := "testbulkdeactivate" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "BulkDeactivate",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, grantA, grantB, other := users[0], users[1], users[2], users[3]
+	// the owner is sponsored by the grant too, but keeps their membership
+	for _, user := range []*User{owner, grantA, grantB} {
+		if err := SetUserAttribute(db, user.Id, "testbulkdeactivategrant", "GrantZ"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testbulkdeactivate",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id, grantA.Id},
+		UserIds:  []int{owner.Id, grantA.Id, grantB.Id, other.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = DeactivatePirgMembersBulk(db, MembershipFilter{}, "test"); !errors.Is(err, ErrEmptyMembershipFilter) {
+		t.Fatalf("expected ErrEmptyMembershipFilter got %v", err)
+	}
+	count, err := DeactivatePirgMembersBulk(db, MembershipFilter{
+		PirgIds:        []int{pirg.Id},
+		UserAttributes: map[string]string{"testbulkdeactivategrant": "GrantZ"},
+	}, "testbulkdeactivate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 memberships deactivated got %v", count)
+	}
+	got, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got.UserIds)
+	if want := []int{owner.Id, other.Id}; !slices.Equal(got.UserIds, want) {
+		t.Errorf("expected members %v got %v", want, got.UserIds)
+	}
+	if !slices.Equal(got.AdminIds, []int{owner.Id}) {
+		t.Errorf("expected only the owner to stay an admin got %v", got.AdminIds)
+	}
+	events, _, err := GetPirgMembershipHistory(db, pirg.Id, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := 0
+	for _, e := range events {
+		if e.Action == AuditActionMemberRemoved && e.Actor == "testbulkdeactivate" {
+			removed++
+		}
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 removals audited got %v", removed)
+	}
+
+	// the filter is evaluated again, so a second run changes nothing
+	if count, err = DeactivatePirgMembersBulk(db, MembershipFilter{PirgIds: []int{pirg.Id}, UserAttributes: map[string]string{"testbulkdeactivategrant": "GrantZ"}}, "test"); err != nil || count != 0 {
+		t.Errorf("expected nothing left to deactivate got %v %v", count, err)
+	}
+}
@@ -0,0 +1,714 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps users, pirgs and audit events in maps,
+// for handler tests that shouldn't need a database. Lookups of missing
+// records return sql.ErrNoRows like the Postgres ones, and creates, updates
+// and membership changes refuse the same conflicts and bump the same
+// versions. Everything returned is a copy.
+type MemoryStore struct {
+	mu     sync.Mutex
+	users  map[int]*User
+	pirgs  map[int]*Pirg
+	events []*AuditEvent
+	// memberships are when members joined and expire, members without one
+	// joined when the pirg was created and don't expire
+	memberships map[pirgUser]membership
+	// the last ids handed out, like the tables' sequences
+	lastUserId int
+	lastPirgId int
+}
+
+// pirgUser is a member of a pirg
+type pirgUser struct {
+	pirgId int
+	userId int
+}
+
+type membership struct {
+	joinedAt  time.Time
+	expiresAt *time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{users: map[int]*User{}, pirgs: map[int]*Pirg{}, memberships: map[pirgUser]membership{}}
+}
+
+func (s *MemoryStore) GetUserById(id int) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[id]; ok && u.DeletedAt == nil {
+		return copyUser(u), nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetUserByIdIncludingDeleted(id int) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[id]; ok {
+		return copyUser(u), nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetUserByUsername(username string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == NormalizeUsername(username) && u.DeletedAt == nil {
+			return copyUser(u), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetUserByEmail looks up a user by their normalized email, see NormalizeEmail
+func (s *MemoryStore) GetUserByEmail(email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if strings.ToLower(u.Email) == email && u.DeletedAt == nil {
+			return copyUser(u), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetAllUsers returns the users ordered by id
+func (s *MemoryStore) GetAllUsers(includeDeleted bool) ([]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []*User
+	for _, u := range s.users {
+		if includeDeleted || u.DeletedAt == nil {
+			users = append(users, copyUser(u))
+		}
+	}
+	slices.SortFunc(users, func(a, b *User) int { return a.Id - b.Id })
+	return users, nil
+}
+
+func (s *MemoryStore) CreateUser(user *UserRequest) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createUser(user)
+}
+
+func (s *MemoryStore) CreateUserInPirg(user *UserRequest, pirgName string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pirg := s.pirgByName(pirgName)
+	if pirg == nil {
+		return nil, fmt.Errorf("failed to look up default pirg %s: %v", pirgName, sql.ErrNoRows)
+	}
+	newUser, err := s.createUser(user)
+	if err != nil {
+		return nil, err
+	}
+	pirg.UserIds = append(pirg.UserIds, newUser.Id)
+	return newUser, nil
+}
+
+func (s *MemoryStore) createUser(user *UserRequest) (*User, error) {
+	for _, u := range s.users {
+		field, value := "", ""
+		if u.Username == user.Username {
+			field, value = "username", user.Username
+		} else if u.Email == user.Email {
+			field, value = "email", user.Email
+		} else {
+			continue
+		}
+		if u.DeletedAt != nil {
+			return nil, fmt.Errorf("%w: deleted user %d has %s %s, restore them instead", ErrDeletedUserExists, u.Id, field, value)
+		}
+		return nil, fmt.Errorf("user with %s %s already exists", field, value)
+	}
+	now := time.Now().UTC()
+	s.lastUserId++
+	u := &User{
+		Id:         s.lastUserId,
+		Username:   user.Username,
+		Email:      user.Email,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		CreatedAt:  now,
+		ModifiedAt: now,
+		Version:    1,
+	}
+	s.users[u.Id] = u
+	return copyUser(u), nil
+}
+
+func (s *MemoryStore) UpdateUser(userId int, user *UserRequest, expectedVersion int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateUser(userId, expectedVersion, func(u *User) {
+		u.Username = user.Username
+		u.Email = user.Email
+		u.FirstName = user.FirstName
+		u.LastName = user.LastName
+	})
+}
+
+func (s *MemoryStore) UpdateUserFields(userId int, fields map[string]any, expectedVersion int) error {
+	if unknown := UnknownUserFields(fields); len(unknown) > 0 {
+		return fmt.Errorf("unknown User fields: %s", strings.Join(unknown, ", "))
+	}
+	if _, ok := fields["id"]; len(fields) == 0 || (ok && len(fields) == 1) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateUser(userId, expectedVersion, func(u *User) {
+		for name, value := range fields {
+			v, _ := value.(string)
+			switch name {
+			case "username":
+				u.Username = v
+			case "email":
+				u.Email = v
+			case "firstname":
+				u.FirstName = v
+			case "lastname":
+				u.LastName = v
+			}
+		}
+	})
+}
+
+// updateUser applies update to the user, refusing it if the user isn't at
+// expectedVersion or it would give them another user's username or email.
+// The version only goes up if a field changed, like the users trigger.
+func (s *MemoryStore) updateUser(userId int, expectedVersion int, update func(u *User)) error {
+	u, ok := s.users[userId]
+	if !ok {
+		return fmt.Errorf("expected to update 1 row, updated 0 rows")
+	}
+	if expectedVersion != 0 && u.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	updated := copyUser(u)
+	update(updated)
+	for _, other := range s.users {
+		if other.Id == userId {
+			continue
+		}
+		if other.Username == updated.Username {
+			return fmt.Errorf("user with username %s already exists", updated.Username)
+		}
+		if other.Email == updated.Email {
+			return fmt.Errorf("user with email %s already exists", updated.Email)
+		}
+	}
+	if updated.Username != u.Username || updated.Email != u.Email || updated.FirstName != u.FirstName || updated.LastName != u.LastName {
+		updated.Version++
+	}
+	updated.ModifiedAt = time.Now().UTC()
+	s.users[userId] = updated
+	return nil
+}
+
+// DeleteUser soft deletes the user, see data.DeleteUser
+func (s *MemoryStore) DeleteUser(id int, dryRun bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.pirgs {
+		if p.OwnerId == id {
+			return ErrUserOwnsPirgs
+		}
+	}
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	if !dryRun {
+		now := time.Now().UTC()
+		u.DeletedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStore) RestoreUser(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	u.DeletedAt = nil
+	return nil
+}
+
+func (s *MemoryStore) TouchUserLogin(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now().UTC()
+	u.LastLoginAt = &now
+	return nil
+}
+
+// VerifyUserEmail only checks the user exists, users don't carry when
+// their email was verified
+func (s *MemoryStore) VerifyUserEmail(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[id]; !ok || u.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetPirgById(id int) (*Pirg, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pirgs[id]; ok {
+		return s.activePirg(p), nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetPirgByName(name string) (*Pirg, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p := s.pirgByName(name); p != nil {
+		return s.activePirg(p), nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) pirgByName(name string) *Pirg {
+	for _, p := range s.pirgs {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// GetAllPirgs returns the pirgs ordered by id
+func (s *MemoryStore) GetAllPirgs() ([]*Pirg, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pirgs []*Pirg
+	for _, p := range s.pirgs {
+		pirgs = append(pirgs, s.activePirg(p))
+	}
+	slices.SortFunc(pirgs, func(a, b *Pirg) int { return a.Id - b.Id })
+	return pirgs, nil
+}
+
+func (s *MemoryStore) CreatePirg(pirg *PirgRequest) (*Pirg, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.validatePirgRequest(pirg); err != nil {
+		return nil, err
+	}
+	if s.pirgByName(pirg.Name) != nil {
+		return nil, fmt.Errorf("pirg with name %s already exists", pirg.Name)
+	}
+	return s.createPirg(pirg), nil
+}
+
+func (s *MemoryStore) createPirg(pirg *PirgRequest) *Pirg {
+	now := time.Now().UTC()
+	s.lastPirgId++
+	p := &Pirg{
+		Id:         s.lastPirgId,
+		Name:       pirg.Name,
+		OwnerId:    pirg.OwnerId,
+		AdminIds:   slices.Clone(pirg.AdminIds),
+		UserIds:    slices.Clone(pirg.UserIds),
+		CreatedAt:  now,
+		ModifiedAt: now,
+		Version:    1,
+	}
+	s.pirgs[p.Id] = p
+	return s.activePirg(p)
+}
+
+// UpsertPirg creates the pirg if there's none with its name, otherwise it
+// updates the existing one like UpdatePirg. The returned bool is true if the
+// pirg was created.
+func (s *MemoryStore) UpsertPirg(pr *PirgRequest) (*Pirg, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.validatePirgRequest(pr); err != nil {
+		return nil, false, err
+	}
+	p := s.pirgByName(pr.Name)
+	if p == nil {
+		return s.createPirg(pr), true, nil
+	}
+	return s.updatePirg(p, pr), false, nil
+}
+
+// validatePirgRequest checks the owner, admins and users of the pirg exist
+func (s *MemoryStore) validatePirgRequest(pirg *PirgRequest) error {
+	validate := func(field string, userId int) error {
+		if u, ok := s.users[userId]; !ok || u.DeletedAt != nil {
+			return fmt.Errorf("validating %s failed: user does not exist with id: %d", field, userId)
+		}
+		return nil
+	}
+	if err := validate("owner_id", pirg.OwnerId); err != nil {
+		return err
+	}
+	for _, adminId := range pirg.AdminIds {
+		if err := validate("admin_id", adminId); err != nil {
+			return err
+		}
+	}
+	for _, userId := range pirg.UserIds {
+		if err := validate("user_id", userId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdatePirg sets the pirg's name, owner and membership, keeping the order
+// of existing members and adding new ones after them
+func (s *MemoryStore) UpdatePirg(id int, pr *PirgRequest, expectedVersion int) (*Pirg, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pirgs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if expectedVersion != 0 && p.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+	if err := s.validatePirgRequest(pr); err != nil {
+		return nil, err
+	}
+	if other := s.pirgByName(pr.Name); other != nil && other.Id != id {
+		return nil, fmt.Errorf("pirg with name %s already exists", pr.Name)
+	}
+	return s.updatePirg(p, pr), nil
+}
+
+// updatePirg sets the pirg's name, owner and membership, see UpdatePirg
+func (s *MemoryStore) updatePirg(p *Pirg, pr *PirgRequest) *Pirg {
+	id := p.Id
+	s.expirePirgMembers(p)
+	// deleted users aren't listed as members, so their rows are left alone
+	sync := func(existing []int, desired []int) []int {
+		var synced []int
+		for _, userId := range existing {
+			if slices.Contains(desired, userId) || s.isDeletedUser(userId) {
+				synced = append(synced, userId)
+			}
+		}
+		for _, userId := range desired {
+			if !slices.Contains(synced, userId) {
+				synced = append(synced, userId)
+			}
+		}
+		return synced
+	}
+	adminIds, userIds := sync(p.AdminIds, pr.AdminIds), sync(p.UserIds, pr.UserIds)
+	now := time.Now().UTC()
+	for _, userId := range p.UserIds {
+		if !slices.Contains(userIds, userId) {
+			delete(s.memberships, pirgUser{id, userId})
+		}
+	}
+	for _, userId := range userIds {
+		if !slices.Contains(p.UserIds, userId) {
+			s.memberships[pirgUser{id, userId}] = membership{joinedAt: now}
+		}
+	}
+	if pr.Name != p.Name || pr.OwnerId != p.OwnerId || !sameIds(adminIds, p.AdminIds) || !sameIds(userIds, p.UserIds) {
+		p.Version++
+	}
+	p.Name, p.OwnerId, p.AdminIds, p.UserIds = pr.Name, pr.OwnerId, adminIds, userIds
+	p.ModifiedAt = now
+	return s.activePirg(p)
+}
+
+// sameIds reports whether a and b hold the same ids, in any order
+func sameIds(a []int, b []int) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+func (s *MemoryStore) DeletePirg(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pirgs[id]; ok {
+		for _, userId := range p.UserIds {
+			delete(s.memberships, pirgUser{id, userId})
+		}
+		delete(s.pirgs, id)
+	}
+	return nil
+}
+
+// IsPirgAdmin reports whether the user is an admin of the pirg, and still a
+// member of it
+func (s *MemoryStore) IsPirgAdmin(pirgId int, userId int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pirgs[pirgId]
+	if !ok {
+		return false, nil
+	}
+	return slices.Contains(p.AdminIds, userId) && s.isActiveMember(p, userId), nil
+}
+
+// AddPirgMember adds the user to the pirg or sets the expiry of their
+// membership, see data.AddPirgMember
+func (s *MemoryStore) AddPirgMember(pirgId int, userId int, expiresAt *time.Time) (*PirgMember, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userId]
+	if !ok || u.DeletedAt != nil {
+		return nil, false, fmt.Errorf("user does not exist with id: %d", userId)
+	}
+	p, ok := s.pirgs[pirgId]
+	if !ok {
+		return nil, false, sql.ErrNoRows
+	}
+	if userId == p.OwnerId && expiresAt != nil {
+		return nil, false, fmt.Errorf("the pirg owner's membership can't expire")
+	}
+	if expiresAt != nil {
+		expires := expiresAt.UTC()
+		expiresAt = &expires
+	}
+	s.expirePirgMembers(p)
+	key := pirgUser{pirgId, userId}
+	created := !slices.Contains(p.UserIds, userId)
+	m := s.membership(p, userId)
+	if created {
+		p.UserIds = append(p.UserIds, userId)
+		m.joinedAt = time.Now().UTC()
+	}
+	m.expiresAt = expiresAt
+	s.memberships[key] = m
+	p.Version++
+	member := &PirgMember{
+		UserId:    u.Id,
+		Username:  u.Username,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		IsAdmin:   slices.Contains(p.AdminIds, userId),
+		JoinedAt:  m.joinedAt,
+		ExpiresAt: m.expiresAt,
+	}
+	return member, created, nil
+}
+
+// RemovePirgMember removes the user from the pirg's members and admins, see
+// data.RemovePirgMember
+func (s *MemoryStore) RemovePirgMember(pirgId int, userId int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pirgs[pirgId]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	if userId == p.OwnerId {
+		return false, ErrRemovePirgOwner
+	}
+	s.expirePirgMembers(p)
+	if err := s.checkLastPirgAdmin(p, userId); err != nil {
+		return false, err
+	}
+	removed := slices.Contains(p.UserIds, userId)
+	s.removePirgMember(p, userId)
+	return removed, nil
+}
+
+// SetPirgAdmin makes the member an admin of the pirg, see data.SetPirgAdmin
+func (s *MemoryStore) SetPirgAdmin(pirgId int, userId int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pirgs[pirgId]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	s.expirePirgMembers(p)
+	if !slices.Contains(p.UserIds, userId) {
+		return false, ErrNotPirgMember
+	}
+	if slices.Contains(p.AdminIds, userId) {
+		return false, nil
+	}
+	p.AdminIds = append(p.AdminIds, userId)
+	p.Version++
+	return true, nil
+}
+
+// RemovePirgAdmin takes the user's admin rights on the pirg, see data.RemovePirgAdmin
+func (s *MemoryStore) RemovePirgAdmin(pirgId int, userId int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pirgs[pirgId]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	s.expirePirgMembers(p)
+	if err := s.checkLastPirgAdmin(p, userId); err != nil {
+		return false, err
+	}
+	i := slices.Index(p.AdminIds, userId)
+	if i < 0 {
+		return false, nil
+	}
+	p.AdminIds = slices.Delete(p.AdminIds, i, i+1)
+	p.Version++
+	return true, nil
+}
+
+// membership returns when the member joined the pirg and expires
+func (s *MemoryStore) membership(p *Pirg, userId int) membership {
+	if m, ok := s.memberships[pirgUser{p.Id, userId}]; ok {
+		return m
+	}
+	return membership{joinedAt: p.CreatedAt}
+}
+
+// isActiveMember reports whether the user is a member of the pirg whose
+// membership hasn't expired and who isn't deleted, like active_pirgs_users
+func (s *MemoryStore) isActiveMember(p *Pirg, userId int) bool {
+	return slices.Contains(p.UserIds, userId) && !s.isExpiredMember(p, userId) && !s.isDeletedUser(userId)
+}
+
+func (s *MemoryStore) isExpiredMember(p *Pirg, userId int) bool {
+	expiresAt := s.membership(p, userId).expiresAt
+	return expiresAt != nil && !expiresAt.After(time.Now().UTC())
+}
+
+func (s *MemoryStore) isDeletedUser(userId int) bool {
+	u, ok := s.users[userId]
+	return ok && u.DeletedAt != nil
+}
+
+// expirePirgMembers removes the pirg's expired members and records a
+// member_removed event for each, like the Postgres expiry does
+func (s *MemoryStore) expirePirgMembers(p *Pirg) {
+	for _, userId := range slices.Clone(p.UserIds) {
+		if !s.isExpiredMember(p, userId) {
+			continue
+		}
+		expiredAt := *s.membership(p, userId).expiresAt
+		s.removePirgMember(p, userId)
+		s.recordAudit(MembershipExpiryActor, AuditActionMemberRemoved, "pirg", strconv.Itoa(p.Id),
+			json.RawMessage(fmt.Sprintf(`{"user_id": %d, "expired_at": %q}`, userId, expiredAt.Format(time.RFC3339Nano))))
+	}
+}
+
+func (s *MemoryStore) removePirgMember(p *Pirg, userId int) {
+	if i := slices.Index(p.UserIds, userId); i >= 0 {
+		p.UserIds = slices.Delete(p.UserIds, i, i+1)
+		p.Version++
+	}
+	if i := slices.Index(p.AdminIds, userId); i >= 0 {
+		p.AdminIds = slices.Delete(p.AdminIds, i, i+1)
+		p.Version++
+	}
+	delete(s.memberships, pirgUser{p.Id, userId})
+}
+
+// checkLastPirgAdmin returns ErrRemoveLastPirgAdmin if the user is the pirg's
+// only admin
+func (s *MemoryStore) checkLastPirgAdmin(p *Pirg, userId int) error {
+	admins := 0
+	for _, adminId := range p.AdminIds {
+		if s.isActiveMember(p, adminId) {
+			admins++
+		}
+	}
+	if slices.Contains(p.AdminIds, userId) && s.isActiveMember(p, userId) && admins == 1 {
+		return ErrRemoveLastPirgAdmin
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordAudit(actor string, action string, resourceType string, resourceId string, details any) error {
+	var raw json.RawMessage
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit details: %v", err)
+		}
+		raw = b
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordAudit(actor, action, resourceType, resourceId, raw)
+	return nil
+}
+
+// recordAudit appends the event, s.mu must be held
+func (s *MemoryStore) recordAudit(actor string, action string, resourceType string, resourceId string, raw json.RawMessage) {
+	s.events = append(s.events, &AuditEvent{
+		Id:           len(s.events) + 1,
+		OccurredAt:   time.Now().UTC(),
+		Actor:        actor,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceId:   resourceId,
+		Details:      raw,
+	})
+}
+
+func (s *MemoryStore) RecordPirgMembershipChanges(actor string, pirgId int, before []int, after []int) error {
+	for _, userId := range after {
+		if !slices.Contains(before, userId) {
+			if err := s.RecordAudit(actor, AuditActionMemberAdded, "pirg", strconv.Itoa(pirgId), map[string]int{"user_id": userId}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, userId := range before {
+		if !slices.Contains(after, userId) {
+			if err := s.RecordAudit(actor, AuditActionMemberRemoved, "pirg", strconv.Itoa(pirgId), map[string]int{"user_id": userId}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AuditEvents returns the recorded audit events, oldest first
+func (s *MemoryStore) AuditEvents() []*AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.events)
+}
+
+func copyUser(u *User) *User {
+	c := *u
+	return &c
+}
+
+// activePirg returns a copy of the pirg listing only its active members and
+// admins, see isActiveMember
+func (s *MemoryStore) activePirg(p *Pirg) *Pirg {
+	c := *p
+	inactive := func(userId int) bool { return !s.isActiveMember(p, userId) }
+	c.AdminIds = slices.DeleteFunc(slices.Clone(p.AdminIds), inactive)
+	c.UserIds = slices.DeleteFunc(slices.Clone(p.UserIds), inactive)
+	return &c
+}
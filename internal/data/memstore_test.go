@@ -0,0 +1,147 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	if _, err := s.GetUserById(1); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows for a missing user got %v", err)
+	}
+	owner, err := s.CreateUser(&UserRequest{Username: "testmemowner", Email: "testmemowner@localhost", FirstName: "Test", LastName: "Memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(&UserRequest{Username: "testmemowner", Email: "other@localhost"}); err == nil {
+		t.Fatal("expected error creating a user with a taken username")
+	}
+	deleted, err := s.CreateUser(&UserRequest{Username: "testmemdeleted", Email: "testmemdeleted@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(deleted.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(&UserRequest{Username: "testmemdeleted", Email: "other@localhost"}); !errors.Is(err, ErrDeletedUserExists) {
+		t.Fatalf("expected ErrDeletedUserExists creating a deleted user's username got %v", err)
+	}
+	if _, err := s.CreatePirg(&PirgRequest{Name: "testmem", OwnerId: owner.Id + 100}); err == nil {
+		t.Fatal("expected error creating a pirg owned by a missing user")
+	}
+	pirg, err := s.CreatePirg(&PirgRequest{Name: "testmem", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := s.CreateUserInPirg(&UserRequest{Username: "testmemmember", Email: "testmemmember@localhost", FirstName: "Test", LastName: "Memory"}, "testmem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUserInPirg(&UserRequest{Username: "testmemnopirg", Email: "testmemnopirg@localhost"}, "missing"); err == nil {
+		t.Fatal("expected error creating a user in a missing pirg")
+	}
+
+	got, err := s.GetPirgByName("testmem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != pirg.Id || len(got.UserIds) != 2 || got.UserIds[1] != member.Id {
+		t.Fatalf("expected the member to be added to the pirg got %+v", got)
+	}
+	// what's returned is a copy
+	got.UserIds[0] = 0
+	if again, _ := s.GetPirgById(pirg.Id); again.UserIds[0] != owner.Id {
+		t.Fatalf("expected changing a returned pirg to leave the store alone got %+v", again)
+	}
+	users, err := s.GetAllUsers(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Id != owner.Id || users[1].Id != member.Id {
+		t.Fatalf("expected both users ordered by id got %+v", users)
+	}
+
+	if err := s.RecordPirgMembershipChanges("user:1", pirg.Id, []int{owner.Id}, []int{owner.Id, member.Id}); err != nil {
+		t.Fatal(err)
+	}
+	events := s.AuditEvents()
+	if len(events) != 1 || events[0].Action != AuditActionMemberAdded || string(events[0].Details) != fmt.Sprintf(`{"user_id":%d}`, member.Id) {
+		t.Fatalf("expected a member_added event got %+v", events)
+	}
+}
+
+func TestMemoryStoreMembershipExpiry(t *testing.T) {
+	s := NewMemoryStore()
+	owner, err := s.CreateUser(&UserRequest{Username: "testmemowner", Email: "testmemowner@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := s.CreateUser(&UserRequest{Username: "testmemmember", Email: "testmemmember@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := s.CreatePirg(&PirgRequest{Name: "testmem", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.AddPirgMember(pirg.Id, owner.Id, &time.Time{}); err == nil {
+		t.Fatal("expected error expiring the owner's membership")
+	}
+	expired := time.Now().Add(-time.Minute)
+	if _, created, err := s.AddPirgMember(pirg.Id, member.Id, &expired); err != nil || !created {
+		t.Fatalf("expected the member to be added got %v %v", created, err)
+	}
+	if _, err := s.SetPirgAdmin(pirg.Id, member.Id); err != ErrNotPirgMember {
+		t.Fatalf("expected ErrNotPirgMember for an expired member got %v", err)
+	}
+	got, _ := s.GetPirgById(pirg.Id)
+	if len(got.UserIds) != 1 {
+		t.Fatalf("expected the expired member to be removed got %+v", got)
+	}
+	events := s.AuditEvents()
+	if len(events) != 1 || events[0].Actor != MembershipExpiryActor || events[0].Action != AuditActionMemberRemoved {
+		t.Fatalf("expected a member_removed event for the expiry got %+v", events)
+	}
+	if _, err := s.RemovePirgAdmin(pirg.Id, owner.Id); err != ErrRemoveLastPirgAdmin {
+		t.Fatalf("expected ErrRemoveLastPirgAdmin got %v", err)
+	}
+	if _, err := s.RemovePirgMember(pirg.Id, owner.Id); err != ErrRemovePirgOwner {
+		t.Fatalf("expected ErrRemovePirgOwner got %v", err)
+	}
+}
+
+func TestMemoryStoreDeletedMember(t *testing.T) {
+	s := NewMemoryStore()
+	owner, err := s.CreateUser(&UserRequest{Username: "testmemowner", Email: "testmemowner@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := s.CreateUser(&UserRequest{Username: "testmemmember", Email: "testmemmember@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := s.CreatePirg(&PirgRequest{Name: "testmem", OwnerId: owner.Id, AdminIds: []int{owner.Id, member.Id}, UserIds: []int{owner.Id, member.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(member.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.GetPirgById(pirg.Id)
+	if len(got.UserIds) != 1 || len(got.AdminIds) != 1 {
+		t.Fatalf("expected the deleted user to be left out of the pirg got %+v", got)
+	}
+	if isAdmin, _ := s.IsPirgAdmin(pirg.Id, member.Id); isAdmin {
+		t.Fatal("expected the deleted user not to be a pirg admin")
+	}
+	if err := s.RestoreUser(member.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetPirgById(pirg.Id); len(got.UserIds) != 2 || len(got.AdminIds) != 2 {
+		t.Fatalf("expected the restored user back in the pirg got %+v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// ErrDirtyMigration is returned by RunMigrations when a previous migration
+// failed partway. The schema has to be fixed by hand and the version forced
+// with the migrate cli before the server will migrate again.
+var ErrDirtyMigration = errors.New("database has a dirty migration")
+
+// RunMigrations applies every migration in migrationsDir that the database
+// hasn't had yet, and logs the version it went from and to. A database that's
+// already up to date is left alone. Only one server migrates at a time, the
+// others wait up to migrate's lock timeout and then fail.
+func RunMigrations(db *sql.DB, migrationsDir string) error {
+	slog.Debug("running database migrations", "path", migrationsDir, "package", "data", "method", "RunMigrations")
+	dir, err := filepath.Abs(migrationsDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve migrations path: %v", err)
+	}
+	// a dedicated connection so closing the migrator doesn't close the pool
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to prepare migrations: %v", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://"+dir, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return fmt.Errorf("failed to load migrations from %s: %v", dir, err)
+	}
+	defer m.Close()
+
+	from, err := migrationVersion(m)
+	if err != nil {
+		return err
+	}
+	err = m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		slog.Info("database schema is up to date", "version", from, "package", "data", "method", "RunMigrations")
+		return nil
+	}
+	var dirty migrate.ErrDirty
+	if errors.As(err, &dirty) {
+		return fmt.Errorf("%w at version %d", ErrDirtyMigration, dirty.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply migrations: %v", err)
+	}
+	to, err := migrationVersion(m)
+	if err != nil {
+		return err
+	}
+	slog.Info("applied database migrations", "from", from, "to", to, "package", "data", "method", "RunMigrations")
+	return nil
+}
+
+// migrationVersion is the version the database is at, 0 if it's never been
+// migrated. It fails with ErrDirtyMigration if the last migration failed.
+func migrationVersion(m *migrate.Migrate) (uint, error) {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read migration version: %v", err)
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w at version %d", ErrDirtyMigration, version)
+	}
+	return version, nil
+}
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunMigrations(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	migrationsDir := "../../database/migration"
+
+	// the test database is already migrated, so both runs change nothing
+	for i := 0; i < 2; i++ {
+		if err := RunMigrations(db, migrationsDir); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+	var version int
+	var dirty bool
+	if err := db.QueryRow("SELECT version, dirty FROM schema_migrations").Scan(&version, &dirty); err != nil {
+		t.Fatal(err)
+	}
+	if version == 0 || dirty {
+		t.Fatalf("expected a clean migrated version got %v dirty %v", version, dirty)
+	}
+	// the pool is still usable after the migrator closes its connection
+	if err := db.Ping(); err != nil {
+		t.Fatalf("expected the pool to stay open got %v", err)
+	}
+
+	if err := RunMigrations(db, t.TempDir()+"/missing"); err == nil {
+		t.Error("expected a missing migrations directory to fail")
+	}
+
+	if _, err := db.Exec("UPDATE schema_migrations SET dirty = true"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("UPDATE schema_migrations SET dirty = false")
+	if err := RunMigrations(db, migrationsDir); !errors.Is(err, ErrDirtyMigration) {
+		t.Fatalf("expected ErrDirtyMigration got %v", err)
+	}
+}
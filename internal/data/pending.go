@@ -0,0 +1,69 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// Reasons a user can be pending, waiting on an onboarding step
+const (
+	// PendingReasonUnverifiedEmail users haven't verified their email
+	PendingReasonUnverifiedEmail = "unverified_email"
+	// PendingReasonNoUid users haven't been allocated a uid
+	PendingReasonNoUid = "no_uid"
+	// PendingReasonNoPrimaryPirg users aren't an active member of any pirg
+	PendingReasonNoPrimaryPirg = "no_primary_pirg"
+)
+
+// PendingUserReasons are the reasons GetPendingUsers accepts
+var PendingUserReasons = []string{PendingReasonUnverifiedEmail, PendingReasonNoUid, PendingReasonNoPrimaryPirg}
+
+// pendingUserConditions select the users u pending for each reason
+var pendingUserConditions = map[string]string{
+	PendingReasonUnverifiedEmail: "u.email_verified_at IS NULL",
+	PendingReasonNoUid:           "NOT EXISTS (SELECT 1 FROM posix_ids p WHERE p.kind = 'uid' AND p.resource_id = u.id)",
+	PendingReasonNoPrimaryPirg:   "NOT EXISTS (SELECT 1 FROM active_pirgs_users pu WHERE pu.user_id = u.id)",
+}
+
+// GetPendingUsers returns a page of the users that aren't deleted and are
+// pending for reason, one of PendingUserReasons, ordered by id, along with
+// the total number pending
+func GetPendingUsers(db *sql.DB, reason string, limit int, offset int) ([]*User, int, error) {
+	slog.Debug("getting pending users from database", "reason", reason, "package", "data", "method", "GetPendingUsers")
+	condition, ok := pendingUserConditions[reason]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown pending reason: %s", reason)
+	}
+	from := " FROM users u WHERE u.deleted_at IS NULL AND " + condition
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*)" + from).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query("SELECT u.id, u.username, u.email, u.firstname, u.lastname, u.created_at, u.modified_at, u.deleted_at, u.last_login_at, u.version"+from+" ORDER BY u.id LIMIT $1 OFFSET $2", limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// VerifyUserEmail records that the user verified their email. Verifying it
+// again keeps the first time, and changing the email clears it.
+// sql.ErrNoRows is returned if they don't exist or are deleted.
+func VerifyUserEmail(db *sql.DB, id int) error {
+	slog.Debug("verifying user email in database", "user_id", id, "package", "data", "method", "VerifyUserEmail")
+	return checkUserUpdated(db.Exec("UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW() AT TIME ZONE 'UTC') WHERE id = $1 AND deleted_at IS NULL", id))
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetPendingUsers(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+
+	users := map[string]*User{}
+	for _, username := range []string{"testpendingunverified", "testpendingnouid", "testpendingnopirg", "testpendingnone"} {
+		user, err := CreateUser(db, &UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Pending"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[username] = user
+	}
+	rng := PosixIdRange{Min: 74000, Max: 74099}
+	for _, username := range []string{"testpendingunverified", "testpendingnopirg", "testpendingnone"} {
+		if _, err := AllocatePosixId(db, PosixIdKindUid, users[username].Id, rng); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, username := range []string{"testpendingnouid", "testpendingnopirg", "testpendingnone"} {
+		if err := VerifyUserEmail(db, users[username].Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	memberIds := []int{users["testpendingunverified"].Id, users["testpendingnouid"].Id, users["testpendingnone"].Id}
+	_, err := CreatePirg(db, &PirgRequest{Name: "testpending", OwnerId: memberIds[0], AdminIds: memberIds[:1], UserIds: memberIds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := func(reason string) []string {
+		t.Helper()
+		found, total, err := GetPendingUsers(db, reason, 100000, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != len(found) {
+			t.Errorf("expected total %v to count every pending user got %v", len(found), total)
+		}
+		usernames := []string{}
+		for _, u := range found {
+			if users[u.Username] != nil {
+				usernames = append(usernames, u.Username)
+			}
+		}
+		return usernames
+	}
+	for reason, want := range map[string]string{
+		PendingReasonUnverifiedEmail: "testpendingunverified",
+		PendingReasonNoUid:           "testpendingnouid",
+		PendingReasonNoPrimaryPirg:   "testpendingnopirg",
+	} {
+		if got := pending(reason); !slices.Equal(got, []string{want}) {
+			t.Errorf("expected only %v pending for %v got %v", want, reason, got)
+		}
+	}
+	if _, _, err := GetPendingUsers(db, "unknown", 10, 0); err == nil {
+		t.Error("expected an unknown reason to be an error")
+	}
+
+	// changing the email unverifies it
+	if err := UpdateUserFields(db, users["testpendingnone"].Id, map[string]any{"email": "testpendingnone2@localhost"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := pending(PendingReasonUnverifiedEmail); !slices.Contains(got, "testpendingnone") {
+		t.Errorf("expected a changed email to be unverified got %v", got)
+	}
+}
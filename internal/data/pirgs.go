@@ -2,10 +2,14 @@ package data
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/exp/slices"
 )
 
@@ -17,6 +21,8 @@ type Pirg struct {
 	UserIds    []int     `json:"user_ids"`
 	CreatedAt  time.Time `json:"created_at"`
 	ModifiedAt time.Time `json:"modified_at"`
+	// Version counts the pirg's updates, membership changes included
+	Version int `json:"version"`
 }
 
 type PirgRequest struct {
@@ -51,7 +57,7 @@ func GetAllPirgs(db *sql.DB) ([]*Pirg, error) {
 func GetPirgById(db *sql.DB, id int) (*Pirg, error) {
 	slog.Debug("querying database for pirg", "id", id, "package", "data", "method", "GetPirgById")
 	var pirg Pirg
-	err := db.QueryRow("SELECT id, name, owner_id, created_at, modified_at FROM pirgs WHERE id = $1", id).Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &pirg.CreatedAt, &pirg.ModifiedAt)
+	err := db.QueryRow("SELECT id, name, owner_id, created_at, modified_at, version FROM pirgs WHERE id = $1", id).Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &pirg.CreatedAt, &pirg.ModifiedAt, &pirg.Version)
 	if err != nil {
 		slog.Error("failed to look up pirg from database", "package", "data", "method", "GetPirgById", "error", err)
 		return nil, err
@@ -72,7 +78,7 @@ func GetPirgById(db *sql.DB, id int) (*Pirg, error) {
 func GetPirgByName(db *sql.DB, name string) (*Pirg, error) {
 	slog.Debug("querying database for pirg", "name", name, "package", "data", "method", "GetPirgByName")
 	var pirg Pirg
-	err := db.QueryRow("SELECT id, name, owner_id, created_at, modified_at FROM pirgs WHERE name = $1", name).Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &pirg.CreatedAt, &pirg.ModifiedAt)
+	err := db.QueryRow("SELECT id, name, owner_id, created_at, modified_at, version FROM pirgs WHERE name = $1", name).Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &pirg.CreatedAt, &pirg.ModifiedAt, &pirg.Version)
 	if err != nil {
 		slog.Error("failed to look up pirg from database", "package", "data", "method", "GetPirgByName", "error", err)
 		return nil, err
@@ -90,10 +96,57 @@ func GetPirgByName(db *sql.DB, name string) (*Pirg, error) {
 	return &pirg, err
 }
 
-func getPirgAdminIds(db *sql.DB, id int) ([]int, error) {
+// GetPirgsByOwner returns a page of the pirgs the user owns ordered by name,
+// along with the total number they own
+func GetPirgsByOwner(db *sql.DB, ownerId int, limit int, offset int) ([]*Pirg, int, error) {
+	slog.Debug("querying database for pirgs by owner", "owner_id", ownerId, "package", "data", "method", "GetPirgsByOwner")
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM pirgs WHERE owner_id = $1", ownerId).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query("SELECT id FROM pirgs WHERE owner_id = $1 ORDER BY name LIMIT $2 OFFSET $3", ownerId, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	pirgs := []*Pirg{}
+	for _, id := range ids {
+		pirg, err := GetPirgById(db, id)
+		if err != nil {
+			return nil, 0, err
+		}
+		pirgs = append(pirgs, pirg)
+	}
+	return pirgs, total, nil
+}
+
+// ValidateDefaultPirg verifies that the pirg configured as the default for new users exists
+func ValidateDefaultPirg(db *sql.DB, name string) error {
+	slog.Debug("validating default pirg", "name", name, "package", "data", "method", "ValidateDefaultPirg")
+	var id int
+	err := db.QueryRow("SELECT id FROM pirgs WHERE name = $1", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("default pirg does not exist: %s", name)
+	}
+	return err
+}
+
+func getPirgAdminIds(q querier, id int) ([]int, error) {
 	slog.Debug("getting pirg admin ids from database", "package", "data", "method", "getPirgAdminIds")
 	var adminIds []int
-	rows, err := db.Query("SELECT user_id FROM pirgs_admins WHERE pirg_id = $1", id)
+	rows, err := q.Query("SELECT user_id FROM active_pirgs_admins WHERE pirg_id = $1", id)
 	if err != nil {
 		slog.Error("failed to look up pirg admins from database", "package", "data", "method", "getPirgAdminIds", "error", err)
 		return nil, err
@@ -110,10 +163,10 @@ func getPirgAdminIds(db *sql.DB, id int) ([]int, error) {
 	return adminIds, err
 }
 
-func getPirgUserIds(db *sql.DB, id int) ([]int, error) {
+func getPirgUserIds(q querier, id int) ([]int, error) {
 	slog.Debug("getting pirg user ids from database", "package", "data", "method", "getPirgUserIds")
 	var userIds []int
-	rows, err := db.Query("SELECT user_id FROM pirgs_users WHERE pirg_id = $1", id)
+	rows, err := q.Query("SELECT user_id FROM active_pirgs_users WHERE pirg_id = $1", id)
 	if err != nil {
 		slog.Error("failed to look up pirg users from database", "package", "data", "method", "getPirgUserIds", "error", err)
 		return nil, err
@@ -153,15 +206,23 @@ func CreatePirg(db *sql.DB, pirg *PirgRequest) (*Pirg, error) {
 			return nil, fmt.Errorf("validating user_id failed: %v", err)
 		}
 	}
-	err = db.QueryRow("INSERT INTO pirgs (name, owner_id) VALUES ($1, $2) RETURNING id", pirg.Name, pirg.OwnerId).Scan(&newId)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("INSERT INTO pirgs (name, owner_id) VALUES ($1, $2) RETURNING id", pirg.Name, pirg.OwnerId).Scan(&newId)
 	if err != nil {
 		return nil, err
 	}
-	for adminId := range pirg.AdminIds {
-		db.QueryRow("INSERT INTO pirgs_admins (pirg_id, user_id) VALUES ($1, $2)", newId, adminId)
+	if err = insertPirgMemberRows(tx, "pirgs_admins", newId, pirg.AdminIds); err != nil {
+		return nil, err
+	}
+	if err = insertPirgMemberRows(tx, "pirgs_users", newId, pirg.UserIds); err != nil {
+		return nil, err
 	}
-	for userId := range pirg.UserIds {
-		db.QueryRow("INSERT INTO pirgs_users (pirg_id, user_id) VALUES ($1, $2)", newId, userId)
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
 	newPirg, err := GetPirgById(db, newId)
 	if err != nil {
@@ -170,70 +231,780 @@ func CreatePirg(db *sql.DB, pirg *PirgRequest) (*Pirg, error) {
 	return newPirg, err
 }
 
-func UpdatePirg(db *sql.DB, id int, pr *PirgRequest) (*Pirg, error) {
+// UpdatePirg sets the pirg's name, owner and membership. With a nonzero
+// expectedVersion the update only happens if the pirg is still at that
+// version, otherwise ErrVersionConflict is returned.
+func UpdatePirg(db *sql.DB, id int, pr *PirgRequest, expectedVersion int) (*Pirg, error) {
 	slog.Debug("updating pirg in database", "package", "data", "method", "UpdatePirg")
 	existingPirg, err := GetPirgById(db, id)
 	if err != nil {
 		return nil, err
 	}
-	// Updates name and owner_id if changed
-	if pr.Name != existingPirg.Name || pr.OwnerId != existingPirg.OwnerId {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// Updates name and owner_id if changed. With an expected version the
+	// update always runs, it checks the version and holds the row until
+	// the membership is synced.
+	if pr.Name != existingPirg.Name || pr.OwnerId != existingPirg.OwnerId || expectedVersion != 0 {
 		slog.Debug("updating pirg name and owner_id", "name", pr.Name, "owner_id", pr.OwnerId, "package", "data", "method", "UpdatePirg")
-		res, err := db.Exec("UPDATE pirgs SET name = $1, owner_id = $2 WHERE id = $3", pr.Name, pr.OwnerId, id)
-		if err = checkAffectedRows(res, err); err != nil {
+		res, err := tx.Exec("UPDATE pirgs SET name = $1, owner_id = $2 WHERE id = $3 AND "+versionCondition(4), pr.Name, pr.OwnerId, id, expectedVersion)
+		if err = checkVersionedUpdate(tx, "pirgs", id, expectedVersion, res, err); err != nil {
 			return nil, err
 		}
 	}
-	existingAdminIds, err := getPirgAdminIds(db, id)
+	if err = syncPirgMembers(tx, id, pr.AdminIds, pr.UserIds); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	newPirg, err := GetPirgById(db, id)
 	if err != nil {
 		return nil, err
 	}
-	// Adds new admin ids
+	return newPirg, err
+}
+
+// UpsertPirg creates the pirg if no pirg with the requested name exists,
+// otherwise it updates the owner and membership of the existing pirg.
+// The returned bool is true if the pirg was created.
+func UpsertPirg(db *sql.DB, pr *PirgRequest) (*Pirg, bool, error) {
+	slog.Debug("upserting pirg in database", "name", pr.Name, "package", "data", "method", "UpsertPirg")
+	err := validateUserId(db, pr.OwnerId)
+	if err != nil {
+		return nil, false, fmt.Errorf("validating owner_id failed: %v", err)
+	}
 	for _, adminId := range pr.AdminIds {
+		if err = validateUserId(db, adminId); err != nil {
+			return nil, false, fmt.Errorf("validating admin_id failed: %v", err)
+		}
+	}
+	for _, userId := range pr.UserIds {
+		if err = validateUserId(db, userId); err != nil {
+			return nil, false, fmt.Errorf("validating user_id failed: %v", err)
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+	// The upsert holds the pirg row lock until commit, so concurrent upserts
+	// for the same name wait here instead of racing on the membership sync.
+	// xmax is only 0 for a freshly inserted row.
+	var id int
+	var created bool
+	err = tx.QueryRow(`
+		INSERT INTO pirgs (name, owner_id) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET owner_id = EXCLUDED.owner_id
+		RETURNING id, (xmax = 0)`, pr.Name, pr.OwnerId).Scan(&id, &created)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = syncPirgMembers(tx, id, pr.AdminIds, pr.UserIds); err != nil {
+		return nil, false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	pirg, err := GetPirgById(db, id)
+	if err != nil {
+		return nil, false, err
+	}
+	return pirg, created, nil
+}
+
+// syncPirgMembers adds and removes admins and users so the pirg
+// membership matches the provided ids
+func syncPirgMembers(q querier, id int, adminIds []int, userIds []int) error {
+	// expired members would otherwise be added a second time
+	if _, err := expirePirgMembers(q, id); err != nil {
+		return err
+	}
+	existingAdminIds, err := getPirgAdminIds(q, id)
+	if err != nil {
+		return err
+	}
+	// Adds new admin ids
+	var newAdminIds []int
+	for _, adminId := range adminIds {
 		if !slices.Contains(existingAdminIds, adminId) {
-			if err = addPirgAdmin(db, id, adminId); err != nil {
-				return nil, err
-			}
+			newAdminIds = append(newAdminIds, adminId)
 		}
 	}
+	if err = insertPirgMemberRows(q, "pirgs_admins", id, newAdminIds); err != nil {
+		return err
+	}
 	// Removes admin ids not present in request
 	for _, existingAdminId := range existingAdminIds {
-		if !slices.Contains(pr.AdminIds, existingAdminId) {
-			if err = deletePirgAdmin(db, id, existingAdminId); err != nil {
-				return nil, err
+		if !slices.Contains(adminIds, existingAdminId) {
+			if err = deletePirgAdmin(q, id, existingAdminId); err != nil {
+				return err
 			}
 		}
 	}
-	existingUserIds, err := getPirgUserIds(db, id)
+	existingUserIds, err := getPirgUserIds(q, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// Adds new User ids
-	for _, UserId := range pr.UserIds {
+	var newUserIds []int
+	for _, UserId := range userIds {
 		if !slices.Contains(existingUserIds, UserId) {
-			if err = addPirgUser(db, id, UserId); err != nil {
-				return nil, err
-			}
+			newUserIds = append(newUserIds, UserId)
 		}
 	}
+	if err = insertPirgMemberRows(q, "pirgs_users", id, newUserIds); err != nil {
+		return err
+	}
 	// Removes User ids not present in request
 	for _, existingUserId := range existingUserIds {
-		if !slices.Contains(pr.UserIds, existingUserId) {
-			if err = deletePirgUser(db, id, existingUserId); err != nil {
+		if !slices.Contains(userIds, existingUserId) {
+			if err = deletePirgUser(q, id, existingUserId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PirgMember is a user belonging to a pirg
+type PirgMember struct {
+	UserId    int
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	IsAdmin   bool
+	JoinedAt  time.Time
+	// ExpiresAt is when the membership ends, or nil if it doesn't
+	ExpiresAt *time.Time
+	// User is the member's whole user record, only set by GetPirgMembersWithUsers
+	User *User
+}
+
+// GetPirgMembers returns a page of the pirg's members ordered by username,
+// along with the total number of members
+func GetPirgMembers(db *sql.DB, pirgId int, limit int, offset int) ([]*PirgMember, int, error) {
+	slog.Debug("getting pirg members from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgMembers")
+	return getPirgMembers(db, pirgId, limit, offset, false)
+}
+
+// GetPirgMembersWithUsers is GetPirgMembers with each member's User set, read
+// in the same query
+func GetPirgMembersWithUsers(db *sql.DB, pirgId int, limit int, offset int) ([]*PirgMember, int, error) {
+	slog.Debug("getting pirg members with users from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgMembersWithUsers")
+	return getPirgMembers(db, pirgId, limit, offset, true)
+}
+
+func getPirgMembers(db *sql.DB, pirgId int, limit int, offset int, withUsers bool) ([]*PirgMember, int, error) {
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM active_pirgs_users WHERE pirg_id = $1", pirgId).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(`
+		SELECT u.id, u.username, u.email, u.firstname, u.lastname,
+			u.created_at, u.modified_at, u.deleted_at, u.last_login_at, u.version,
+			EXISTS (SELECT 1 FROM pirgs_admins pa WHERE pa.pirg_id = pu.pirg_id AND pa.user_id = u.id),
+			pu.created_at, pu.expires_at
+		FROM active_pirgs_users pu
+		JOIN users u ON u.id = pu.user_id
+		WHERE pu.pirg_id = $1
+		ORDER BY u.username
+		LIMIT $2 OFFSET $3`, pirgId, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	members := []*PirgMember{}
+	for rows.Next() {
+		var m PirgMember
+		var u User
+		err := rows.Scan(&u.Id, &u.Username, &u.Email, &u.FirstName, &u.LastName,
+			&u.CreatedAt, &u.ModifiedAt, &u.DeletedAt, &u.LastLoginAt, &u.Version,
+			&m.IsAdmin, &m.JoinedAt, &m.ExpiresAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		m.UserId, m.Username, m.Email, m.FirstName, m.LastName = u.Id, u.Username, u.Email, u.FirstName, u.LastName
+		if withUsers {
+			m.User = &u
+		}
+		members = append(members, &m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return members, total, nil
+}
+
+// PirgWithMembers is a pirg along with its members. MembersTruncated is set
+// if the member limit cut the list short, MemberCount is the full count.
+type PirgWithMembers struct {
+	*Pirg
+	Members          []*PirgMember
+	MemberCount      int
+	MembersTruncated bool
+}
+
+// GetAllPirgsWithMembers returns every pirg ordered by id with its members
+// ordered by username, in two queries rather than a pair per pirg. At most
+// memberLimit members are returned across all the pirgs, the pirgs past the
+// limit have theirs truncated.
+func GetAllPirgsWithMembers(db *sql.DB, memberLimit int) ([]*PirgWithMembers, error) {
+	slog.Debug("getting all pirgs with members from database", "member_limit", memberLimit, "package", "data", "method", "GetAllPirgsWithMembers")
+	rows, err := db.Query(`
+		SELECT p.id, p.name, p.owner_id, p.created_at, p.modified_at, p.version,
+			ARRAY(SELECT user_id FROM active_pirgs_admins WHERE pirg_id = p.id ORDER BY user_id),
+			ARRAY(SELECT user_id FROM active_pirgs_users WHERE pirg_id = p.id ORDER BY user_id)
+		FROM pirgs p
+		ORDER BY p.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pirgs := []*PirgWithMembers{}
+	byId := map[int]*PirgWithMembers{}
+	for rows.Next() {
+		var pirg Pirg
+		var adminIds, userIds []int64
+		err := rows.Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &pirg.CreatedAt, &pirg.ModifiedAt, &pirg.Version, pq.Array(&adminIds), pq.Array(&userIds))
+		if err != nil {
+			return nil, err
+		}
+		pirg.AdminIds = toInts(adminIds)
+		pirg.UserIds = toInts(userIds)
+		p := &PirgWithMembers{Pirg: &pirg, Members: []*PirgMember{}, MemberCount: len(userIds)}
+		pirgs = append(pirgs, p)
+		byId[pirg.Id] = p
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT pu.pirg_id, u.id, u.username, u.email, u.firstname, u.lastname,
+			EXISTS (SELECT 1 FROM active_pirgs_admins pa WHERE pa.pirg_id = pu.pirg_id AND pa.user_id = u.id),
+			pu.created_at, pu.expires_at
+		FROM active_pirgs_users pu
+		JOIN users u ON u.id = pu.user_id
+		ORDER BY pu.pirg_id, u.username
+		LIMIT $1`, memberLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pirgId int
+		var m PirgMember
+		err := rows.Scan(&pirgId, &m.UserId, &m.Username, &m.Email, &m.FirstName, &m.LastName, &m.IsAdmin, &m.JoinedAt, &m.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		// a pirg created between the two queries isn't in the list
+		if p, ok := byId[pirgId]; ok {
+			p.Members = append(p.Members, &m)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range pirgs {
+		p.MembersTruncated = len(p.Members) < p.MemberCount
+	}
+	return pirgs, nil
+}
+
+func toInts(ids []int64) []int {
+	ints := make([]int, len(ids))
+	for i, id := range ids {
+		ints[i] = int(id)
+	}
+	return ints
+}
+
+// PirgReconcileResult is the membership diff applied, or that would be
+// applied on a dry run, by ReconcilePirgMembers
+type PirgReconcileResult struct {
+	PirgId  int
+	Added   []*PirgMember
+	Removed []*PirgMember
+	DryRun  bool
+}
+
+// ReconcilePirgMembers makes the pirg's members exactly the users named in
+// usernames. Removed members also lose admin. The owner can't be removed, and
+// every username must belong to an existing user. On a dry run the diff is
+// computed the same way and then rolled back.
+//
+// If batchSize is 0 the changes are applied in one transaction. Otherwise
+// each transaction applies at most batchSize of them, additions first, so
+// the pirg is only locked for a batch at a time. If a batch fails the
+// earlier ones stay applied, and the result of those is returned with the
+// error, reconciling again applies the rest.
+func ReconcilePirgMembers(db *sql.DB, pirgId int, usernames []string, dryRun bool, batchSize int) (*PirgReconcileResult, error) {
+	slog.Debug("reconciling pirg members in database", "pirg_id", pirgId, "dry_run", dryRun, "batch_size", batchSize, "package", "data", "method", "ReconcilePirgMembers")
+	if batchSize > 0 && !dryRun {
+		desired, err := resolvePirgMembers(db, usernames)
+		if err != nil {
+			return nil, err
+		}
+		return reconcilePirgMembersInBatches(db, pirgId, desired, batchSize)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	desired, err := resolvePirgMembers(tx, usernames)
+	if err != nil {
+		return nil, err
+	}
+	result, err := reconcilePirgMembers(tx, pirgId, desired, 0)
+	if err != nil {
+		return nil, err
+	}
+	result.DryRun = dryRun
+	if dryRun {
+		return result, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReconcileAllPirgMembers reconciles the members of every pirg named in desired,
+// a map of pirg name to usernames, the same as ReconcilePirgMembers. Every pirg
+// must exist. On a dry run the diffs are computed and then rolled back.
+//
+// If batchSize is 0 every pirg is reconciled in one transaction, so either
+// every pirg is reconciled or none are. Otherwise the pirgs, usernames and
+// owners are all checked first, and then each pirg is reconciled in batches
+// in name order. If a batch fails the results of the pirgs reconciled so far
+// are returned with the error.
+func ReconcileAllPirgMembers(db *sql.DB, desired map[string][]string, dryRun bool, batchSize int) (map[string]*PirgReconcileResult, error) {
+	slog.Debug("reconciling members of pirgs in database", "count", len(desired), "dry_run", dryRun, "batch_size", batchSize, "package", "data", "method", "ReconcileAllPirgMembers")
+	if batchSize > 0 && !dryRun {
+		return reconcileAllPirgMembersInBatches(db, desired, batchSize)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	names, pirgs, err := lookupReconcilePirgs(tx, desired)
+	if err != nil {
+		return nil, err
+	}
+	results := map[string]*PirgReconcileResult{}
+	for _, name := range names {
+		members, err := resolvePirgMembers(tx, desired[name])
+		if err != nil {
+			return nil, fmt.Errorf("pirg %s: %v", name, err)
+		}
+		result, err := reconcilePirgMembers(tx, pirgs[name].id, members, 0)
+		if err != nil {
+			return nil, fmt.Errorf("pirg %s: %v", name, err)
+		}
+		result.DryRun = dryRun
+		results[name] = result
+	}
+	if dryRun {
+		return results, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func reconcileAllPirgMembersInBatches(db *sql.DB, desired map[string][]string, batchSize int) (map[string]*PirgReconcileResult, error) {
+	names, pirgs, err := lookupReconcilePirgs(db, desired)
+	if err != nil {
+		return nil, err
+	}
+	// everything is checked before any pirg changes, so a bad request changes nothing
+	members := map[string][]*PirgMember{}
+	for _, name := range names {
+		m, err := resolvePirgMembers(db, desired[name])
+		if err != nil {
+			return nil, fmt.Errorf("pirg %s: %v", name, err)
+		}
+		if !slices.ContainsFunc(m, func(m *PirgMember) bool { return m.UserId == pirgs[name].ownerId }) {
+			return nil, fmt.Errorf("pirg %s: member list must include the pirg owner: %d", name, pirgs[name].ownerId)
+		}
+		members[name] = m
+	}
+
+	results := map[string]*PirgReconcileResult{}
+	for _, name := range names {
+		result, err := reconcilePirgMembersInBatches(db, pirgs[name].id, members[name], batchSize)
+		if result != nil {
+			results[name] = result
+		}
+		if err != nil {
+			return results, fmt.Errorf("pirg %s: %v", name, err)
+		}
+	}
+	return results, nil
+}
+
+type reconcilePirg struct {
+	id      int
+	ownerId int
+}
+
+// lookupReconcilePirgs returns the names in desired in order and their pirgs,
+// or an error naming every pirg that doesn't exist
+func lookupReconcilePirgs(q querier, desired map[string][]string) ([]string, map[string]reconcilePirg, error) {
+	// pirgs are reconciled, and so locked, in name order so concurrent
+	// reconciles can't deadlock
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	pirgs := map[string]reconcilePirg{}
+	var missing []string
+	for _, name := range names {
+		var p reconcilePirg
+		err := q.QueryRow("SELECT id, owner_id FROM pirgs WHERE name = $1", name).Scan(&p.id, &p.ownerId)
+		if err == sql.ErrNoRows {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		pirgs[name] = p
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("pirgs do not exist: %v", missing)
+	}
+	return names, pirgs, nil
+}
+
+// resolvePirgMembers looks up the users named in usernames, in any case, in
+// their order and without duplicates, or returns an error naming every one
+// that doesn't exist or is deleted
+func resolvePirgMembers(q querier, usernames []string) ([]*PirgMember, error) {
+	seen := map[int]bool{}
+	var members []*PirgMember
+	var missing []string
+	for _, username := range usernames {
+		var m PirgMember
+		err := q.QueryRow("SELECT id, username, email, firstname, lastname FROM users WHERE lower(username) = $1 AND deleted_at IS NULL", NormalizeUsername(username)).Scan(
+			&m.UserId, &m.Username, &m.Email, &m.FirstName, &m.LastName)
+		if err == sql.ErrNoRows {
+			missing = append(missing, username)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !seen[m.UserId] {
+			seen[m.UserId] = true
+			members = append(members, &m)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("users do not exist: %v", missing)
+	}
+	return members, nil
+}
+
+// reconcilePirgMembersInBatches reconciles the pirg's members to desired in
+// transactions of at most batchSize changes, until one has fewer left to
+// apply. Each batch diffs against the members as they are then, so changes
+// made between batches are reconciled too.
+func reconcilePirgMembersInBatches(db *sql.DB, pirgId int, desired []*PirgMember, batchSize int) (*PirgReconcileResult, error) {
+	result := &PirgReconcileResult{PirgId: pirgId, Added: []*PirgMember{}, Removed: []*PirgMember{}}
+	for batch := 1; ; batch++ {
+		applied, err := reconcilePirgMembersBatch(db, pirgId, desired, batchSize)
+		if err != nil {
+			if batch == 1 {
 				return nil, err
 			}
+			return result, fmt.Errorf("batch %d failed, the %d before it were applied: %v", batch, batch-1, err)
+		}
+		result.Added = append(result.Added, applied.Added...)
+		result.Removed = append(result.Removed, applied.Removed...)
+		slog.Debug("applied pirg reconcile batch", "pirg_id", pirgId, "batch", batch, "added", len(applied.Added), "removed", len(applied.Removed), "package", "data", "method", "reconcilePirgMembersInBatches")
+		if len(applied.Added)+len(applied.Removed) < batchSize {
+			return result, nil
 		}
 	}
-	newPirg, err := GetPirgById(db, id)
+}
+
+func reconcilePirgMembersBatch(db *sql.DB, pirgId int, desired []*PirgMember, batchSize int) (*PirgReconcileResult, error) {
+	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
-	return newPirg, err
+	defer tx.Rollback()
+	result, err := reconcilePirgMembers(tx, pirgId, desired, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reconcilePirgMembers applies the membership diff between the pirg's members
+// and desired in tx and returns it. If limit isn't 0 at most limit changes
+// are applied and returned, additions first. The caller decides whether to commit.
+func reconcilePirgMembers(tx *sql.Tx, pirgId int, desiredOrder []*PirgMember, limit int) (*PirgReconcileResult, error) {
+	// lock the pirg so concurrent reconciles apply one after the other
+	var ownerId int
+	err := tx.QueryRow("SELECT owner_id FROM pirgs WHERE id = $1 FOR UPDATE", pirgId).Scan(&ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	// desiredOrder keeps the request order so additions are reported in it
+	desired := map[int]bool{}
+	for _, m := range desiredOrder {
+		desired[m.UserId] = true
+	}
+	if !desired[ownerId] {
+		return nil, fmt.Errorf("member list must include the pirg owner: %d", ownerId)
+	}
+	if _, err = expirePirgMembers(tx, pirgId); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT u.id, u.username, u.email, u.firstname, u.lastname
+		FROM active_pirgs_users pu JOIN users u ON u.id = pu.user_id
+		WHERE pu.pirg_id = $1 ORDER BY u.username`, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	current := map[int]bool{}
+	result := &PirgReconcileResult{PirgId: pirgId, Added: []*PirgMember{}, Removed: []*PirgMember{}}
+	for rows.Next() {
+		var m PirgMember
+		if err := rows.Scan(&m.UserId, &m.Username, &m.Email, &m.FirstName, &m.LastName); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current[m.UserId] = true
+		if !desired[m.UserId] {
+			result.Removed = append(result.Removed, &m)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, m := range desiredOrder {
+		if !current[m.UserId] {
+			result.Added = append(result.Added, m)
+		}
+	}
+	if limit > 0 {
+		result.Added = result.Added[:min(len(result.Added), limit)]
+		result.Removed = result.Removed[:min(len(result.Removed), limit-len(result.Added))]
+	}
+
+	var addedIds []int
+	for _, m := range result.Added {
+		addedIds = append(addedIds, m.UserId)
+	}
+	if err = insertPirgMemberRows(tx, "pirgs_users", pirgId, addedIds); err != nil {
+		return nil, err
+	}
+	for _, m := range result.Removed {
+		if err = deletePirgAdmin(tx, pirgId, m.UserId); err != nil {
+			return nil, err
+		}
+		if err = deletePirgUser(tx, pirgId, m.UserId); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// PirgComparison splits the members of two pirgs into those only in A,
+// only in B, and in both
+type PirgComparison struct {
+	A     int
+	B     int
+	OnlyA []*PirgMember
+	OnlyB []*PirgMember
+	Both  []*PirgMember
+}
+
+// ComparePirgMembers compares the active members of pirgs a and b.
+// Each list is ordered by username.
+func ComparePirgMembers(db *sql.DB, a int, b int) (*PirgComparison, error) {
+	slog.Debug("comparing pirg members in database", "a", a, "b", b, "package", "data", "method", "ComparePirgMembers")
+	members := func(op string, left int, right int) ([]*PirgMember, error) {
+		rows, err := db.Query(fmt.Sprintf(`
+			SELECT u.id, u.username, u.email, u.firstname, u.lastname
+			FROM users u
+			WHERE u.id IN (
+				SELECT user_id FROM active_pirgs_users WHERE pirg_id = $1
+				%s
+				SELECT user_id FROM active_pirgs_users WHERE pirg_id = $2
+			)
+			ORDER BY u.username`, op), left, right)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		list := []*PirgMember{}
+		for rows.Next() {
+			var m PirgMember
+			if err := rows.Scan(&m.UserId, &m.Username, &m.Email, &m.FirstName, &m.LastName); err != nil {
+				return nil, err
+			}
+			list = append(list, &m)
+		}
+		return list, rows.Err()
+	}
+	c := &PirgComparison{A: a, B: b}
+	var err error
+	if c.OnlyA, err = members("EXCEPT", a, b); err != nil {
+		return nil, err
+	}
+	if c.OnlyB, err = members("EXCEPT", b, a); err != nil {
+		return nil, err
+	}
+	if c.Both, err = members("INTERSECT", a, b); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TransferAllPirgs makes toId the owner of every pirg fromId owns, and adds
+// them as a permanent member and an admin of each. The transfers, their audit
+// events and the new memberships happen in one transaction, which is rolled
+// back with dryRun. Returns the transferred pirg ids, ordered.
+func TransferAllPirgs(db *sql.DB, fromId int, toId int, actor string, dryRun bool) ([]int, error) {
+	slog.Debug("transferring all pirgs in database", "from_user_id", fromId, "to_user_id", toId, "dry_run", dryRun, "package", "data", "method", "TransferAllPirgs")
+	if fromId == toId {
+		return nil, fmt.Errorf("can't transfer pirgs to the user that owns them")
+	}
+	if err := validateUserId(db, toId); err != nil {
+		return nil, err
+	}
+	var pirgIds []int
+	err := WithTx(txContext(dryRun), db, func(tx *sql.Tx) error {
+		var err error
+		pirgIds, err = transferOwnedPirgs(tx, fromId, toId, actor)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pirgIds, nil
+}
+
+// transferOwnedPirgs makes toId the owner of every pirg fromId owns and records
+// an owner_changed audit event for each. The new owner is made a permanent
+// member and an admin of each pirg they aren't already, with member_added and
+// admin_added events, so the pirg keeps its owner among its admins. Returns
+// the pirg ids, ordered.
+func transferOwnedPirgs(q querier, fromId int, toId int, actor string) ([]int, error) {
+	rows, err := q.Query("UPDATE pirgs SET owner_id = $1 WHERE owner_id = $2 RETURNING id", toId, fromId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pirgIds := []int{}
+	for rows.Next() {
+		var pirgId int
+		if err := rows.Scan(&pirgId); err != nil {
+			return nil, err
+		}
+		pirgIds = append(pirgIds, pirgId)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	slices.Sort(pirgIds)
+	record := func(action string, pirgId int, details string) error {
+		_, err := insertAuditEvent(q, &AuditEventRequest{
+			Actor:        actor,
+			Action:       action,
+			ResourceType: "pirg",
+			ResourceId:   strconv.Itoa(pirgId),
+			Details:      json.RawMessage(details),
+		})
+		return err
+	}
+	for _, pirgId := range pirgIds {
+		if err = record(AuditActionOwnerChanged, pirgId, fmt.Sprintf(`{"from_user_id": %d, "to_user_id": %d}`, fromId, toId)); err != nil {
+			return nil, err
+		}
+		if _, err = expirePirgMembers(q, pirgId); err != nil {
+			return nil, err
+		}
+		// an owner's membership can't expire
+		res, err := q.Exec("UPDATE pirgs_users SET expires_at = NULL WHERE pirg_id = $1 AND user_id = $2", pirgId, toId)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if updated == 0 {
+			if err = addPirgUser(q, pirgId, toId); err != nil {
+				return nil, err
+			}
+			if err = record(AuditActionMemberAdded, pirgId, fmt.Sprintf(`{"user_id": %d}`, toId)); err != nil {
+				return nil, err
+			}
+		}
+		// pirgs_admins has no unique constraint, the pirg's row is locked by
+		// the owner update so the admin can't be added twice
+		var isAdmin bool
+		err = q.QueryRow("SELECT EXISTS (SELECT 1 FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2)", pirgId, toId).Scan(&isAdmin)
+		if err != nil {
+			return nil, err
+		}
+		if !isAdmin {
+			if err = insertPirgMemberRows(q, "pirgs_admins", pirgId, []int{toId}); err != nil {
+				return nil, err
+			}
+			if err = record(AuditActionAdminAdded, pirgId, fmt.Sprintf(`{"user_id": %d}`, toId)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pirgIds, nil
 }
 
 func DeletePirg(db *sql.DB, id int) error {
 	slog.Debug("deleting pirg from database", "package", "data", "method", "DeletePirg")
-	res, err := db.Exec("DELETE FROM pirgs WHERE id = $1", id)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// membership rows reference the pirg, so they have to go first
+	for _, table := range []string{"pirgs_admins", "pirgs_users"} {
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE pirg_id = $1", table), id)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s for pirg: %v", table, err)
+		}
+	}
+	res, err := tx.Exec("DELETE FROM pirgs WHERE id = $1", id)
 	if err != nil {
 		return err
 	}
@@ -241,7 +1012,67 @@ func DeletePirg(db *sql.DB, id int) error {
 	if err != nil || count != 1 {
 		return err
 	}
-	return nil
+	return tx.Commit()
+}
+
+type PirgSummary struct {
+	PirgId        int
+	Name          string
+	OwnerId       int
+	MemberCount   int
+	AdminCount    int
+	RecentMembers []*PirgMemberChange
+	LastActivity  time.Time
+}
+
+type PirgMemberChange struct {
+	UserId    int
+	Username  string
+	Action    string
+	Timestamp time.Time
+}
+
+// GetPirgSummary assembles the counts, recent membership changes, and last
+// activity for a pirg. The summary is built from two queries regardless of
+// how many members the pirg has.
+func GetPirgSummary(db *sql.DB, id int, recentLimit int) (*PirgSummary, error) {
+	slog.Debug("querying database for pirg summary", "id", id, "package", "data", "method", "GetPirgSummary")
+	var summary PirgSummary
+	err := db.QueryRow(`
+		SELECT p.id, p.name, p.owner_id,
+			(SELECT COUNT(*) FROM active_pirgs_users WHERE pirg_id = p.id),
+			(SELECT COUNT(*) FROM active_pirgs_admins WHERE pirg_id = p.id),
+			GREATEST(
+				p.modified_at,
+				(SELECT MAX(modified_at) FROM pirgs_users WHERE pirg_id = p.id),
+				(SELECT MAX(modified_at) FROM pirgs_admins WHERE pirg_id = p.id)
+			)
+		FROM pirgs p WHERE p.id = $1`, id).Scan(&summary.PirgId, &summary.Name, &summary.OwnerId, &summary.MemberCount, &summary.AdminCount, &summary.LastActivity)
+	if err != nil {
+		slog.Error("failed to look up pirg summary from database", "package", "data", "method", "GetPirgSummary", "error", err)
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT pu.user_id, u.username, pu.created_at
+		FROM active_pirgs_users pu JOIN users u ON u.id = pu.user_id
+		WHERE pu.pirg_id = $1
+		ORDER BY pu.created_at DESC, pu.id DESC
+		LIMIT $2`, id, recentLimit)
+	if err != nil {
+		slog.Error("failed to look up recent pirg members from database", "package", "data", "method", "GetPirgSummary", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	summary.RecentMembers = []*PirgMemberChange{}
+	for rows.Next() {
+		change := PirgMemberChange{Action: "added"}
+		err := rows.Scan(&change.UserId, &change.Username, &change.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		summary.RecentMembers = append(summary.RecentMembers, &change)
+	}
+	return &summary, rows.Err()
 }
 
 func checkAffectedRows(res sql.Result, err error) error {
@@ -258,27 +1089,51 @@ func checkAffectedRows(res sql.Result, err error) error {
 	return nil
 }
 
-func addPirgAdmin(db *sql.DB, pirgId int, userId int) error {
-	slog.Debug("adding pirg admin to database", "package", "data", "method", "addPirgAdmin")
-	_, err := db.Exec("INSERT INTO pirgs_admins (pirg_id, user_id) VALUES ($1, $2)", pirgId, userId)
-	return err
+// maxStatementParams is the most bind parameters Postgres accepts in one statement
+var maxStatementParams = 65535
+
+// insertPirgMemberRows inserts a (pirg_id, user_id) row into pirgs_users or
+// pirgs_admins for each user. Rows are batched into as few statements as the
+// parameter limit allows, so run it in a transaction to keep it atomic.
+func insertPirgMemberRows(q querier, table string, pirgId int, userIds []int) error {
+	slog.Debug("adding pirg member rows to database", "table", table, "count", len(userIds), "package", "data", "method", "insertPirgMemberRows")
+	// pirg_id is shared by every row so it's bound once as $1
+	perStatement := maxStatementParams - 1
+	for start := 0; start < len(userIds); start += perStatement {
+		batch := userIds[start:min(start+perStatement, len(userIds))]
+		var query strings.Builder
+		fmt.Fprintf(&query, "INSERT INTO %s (pirg_id, user_id) VALUES ", table)
+		args := make([]any, 0, len(batch)+1)
+		args = append(args, pirgId)
+		for i, userId := range batch {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "($1, $%d)", i+2)
+			args = append(args, userId)
+		}
+		if _, err := q.Exec(query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func deletePirgAdmin(db *sql.DB, pirgId int, userId int) error {
+func deletePirgAdmin(q querier, pirgId int, userId int) error {
 	slog.Debug("deleting pirg admin from database", "package", "data", "method", "deletePirgAdmin")
-	_, err := db.Exec("DELETE FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
+	_, err := q.Exec("DELETE FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
 	return err
 }
 
-func addPirgUser(db *sql.DB, pirgId int, userId int) error {
+func addPirgUser(q querier, pirgId int, userId int) error {
 	slog.Debug("adding pirg user to database", "package", "data", "method", "addPirgUser")
-	_, err := db.Exec("INSERT INTO pirgs_users (pirg_id, user_id) VALUES ($1, $2)", pirgId, userId)
+	_, err := q.Exec("INSERT INTO pirgs_users (pirg_id, user_id) VALUES ($1, $2)", pirgId, userId)
 	return err
 }
 
-func deletePirgUser(db *sql.DB, pirgId int, userId int) error {
+func deletePirgUser(q querier, pirgId int, userId int) error {
 	slog.Debug("deleting pirg user from database", "package", "data", "method", "deletePirgUser")
-	_, err := db.Exec("DELETE FROM pirgs_users WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
+	_, err := q.Exec("DELETE FROM pirgs_users WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
 	return err
 }
 
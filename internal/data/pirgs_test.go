@@ -1,6 +1,11 @@
 package data
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -69,7 +74,947 @@ func TestGetAllPirgs(t *testing.T) {
 	}
 }
 
-// TODO(lcrown): 
+func TestGetAllPirgsWithMembers(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var userIds []int
+	for i := 0; i < 3; i++ {
+		username := fmt.Sprintf("testpirgswithmembers%d", i)
+		user, err := CreateUser(db, &UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Member"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, user.Id)
+	}
+	a, err := CreatePirg(db, &PirgRequest{Name: "testpirgswithmembersa", OwnerId: userIds[0], AdminIds: []int{userIds[0]}, UserIds: userIds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CreatePirg(db, &PirgRequest{Name: "testpirgswithmembersb", OwnerId: userIds[1], AdminIds: []int{userIds[1]}, UserIds: userIds[1:2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(pirgs []*PirgWithMembers, id int) *PirgWithMembers {
+		for _, p := range pirgs {
+			if p.Id == id {
+				return p
+			}
+		}
+		t.Fatalf("expected pirg %v in the list", id)
+		return nil
+	}
+	pirgs, err := GetAllPirgsWithMembers(db, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pa := find(pirgs, a.Id)
+	if pa.MemberCount != 3 || len(pa.Members) != 3 || pa.MembersTruncated {
+		t.Errorf("expected all 3 members of pirg a got %v of %v", len(pa.Members), pa.MemberCount)
+	}
+	for _, m := range pa.Members {
+		if m.IsAdmin != (m.UserId == userIds[0]) {
+			t.Errorf("unexpected is_admin %v for user %v", m.IsAdmin, m.UserId)
+		}
+	}
+	if !slices.Equal(pa.AdminIds, []int{userIds[0]}) || len(pa.UserIds) != 3 {
+		t.Errorf("unexpected admin_ids %v and user_ids %v", pa.AdminIds, pa.UserIds)
+	}
+	pb := find(pirgs, b.Id)
+	if pb.MemberCount != 1 || len(pb.Members) != 1 || pb.Members[0].UserId != userIds[1] {
+		t.Errorf("expected pirg b to have only user %v got %v", userIds[1], pb.Members)
+	}
+
+	// pirgs are ordered by id, so b is past a limit that a fills
+	total := 0
+	for _, p := range pirgs {
+		if p.Id < b.Id {
+			total += p.MemberCount
+		}
+	}
+	pirgs, err = GetAllPirgsWithMembers(db, total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb = find(pirgs, b.Id)
+	if !pb.MembersTruncated || len(pb.Members) != 0 || pb.MemberCount != 1 {
+		t.Errorf("expected pirg b to be truncated got %v of %v members", len(pb.Members), pb.MemberCount)
+	}
+}
+
+func TestGetPirgSummary(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	owner, err := CreateUser(db, &UserRequest{
+		Username:  "testpirgsummaryowner",
+		Email:     "testpirgsummaryowner@localhost",
+		FirstName: "Test",
+		LastName:  "Owner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := CreateUser(db, &UserRequest{
+		Username:  "testpirgsummarymember",
+		Email:     "testpirgsummarymember@localhost",
+		FirstName: "Test",
+		LastName:  "Member",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testpirgsummary",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := GetPirgSummary(db, pirg.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Name != pirg.Name {
+		t.Fatalf("expected name %v got %v", pirg.Name, summary.Name)
+	}
+	if summary.MemberCount != 2 {
+		t.Fatalf("expected 2 members got %v", summary.MemberCount)
+	}
+	if summary.AdminCount != 1 {
+		t.Fatalf("expected 1 admin got %v", summary.AdminCount)
+	}
+	// the recent limit is respected and the latest addition comes first
+	if len(summary.RecentMembers) != 1 {
+		t.Fatalf("expected 1 recent member got %v", len(summary.RecentMembers))
+	}
+	if summary.RecentMembers[0].UserId != member.Id {
+		t.Fatalf("expected most recent member %v got %v", member.Id, summary.RecentMembers[0].UserId)
+	}
+	if summary.LastActivity.IsZero() {
+		t.Fatal("expected last activity to be set")
+	}
+}
+
+func TestGetPirgsByOwner(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"owner", "none"} {
+		username := "testpirgsbyowner" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Owner",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, none := users[0], users[1]
+	for _, name := range []string{"testpirgsbyownerc", "testpirgsbyownera", "testpirgsbyownerb"} {
+		_, err := CreatePirg(db, &PirgRequest{
+			Name:     name,
+			OwnerId:  owner.Id,
+			AdminIds: []int{owner.Id},
+			UserIds:  []int{owner.Id, none.Id},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pirgs, total, err := GetPirgsByOwner(db, owner.Id, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Fatalf("expected 3 owned pirgs got %v", total)
+	}
+	if len(pirgs) != 2 || pirgs[0].Name != "testpirgsbyownera" || pirgs[1].Name != "testpirgsbyownerb" {
+		t.Fatalf("expected the first page ordered by name got %+v", pirgs)
+	}
+
+	// membership alone isn't ownership
+	pirgs, total, err = GetPirgsByOwner(db, none.Id, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 || pirgs == nil || len(pirgs) != 0 {
+		t.Fatalf("expected an empty list got total %v pirgs %+v", total, pirgs)
+	}
+}
+
+func TestComparePirgMembers(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	users := map[string]*User{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		username := "testcomparepirgs" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Compare",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user
+	}
+	newPirg := func(name string, members ...string) *Pirg {
+		t.Helper()
+		var ids []int
+		for _, m := range members {
+			ids = append(ids, users[m].Id)
+		}
+		pirg, err := CreatePirg(db, &PirgRequest{
+			Name:     name,
+			OwnerId:  ids[0],
+			AdminIds: ids[:1],
+			UserIds:  ids,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pirg
+	}
+	usernames := func(members []*PirgMember) string {
+		var names []string
+		for _, m := range members {
+			names = append(names, strings.TrimPrefix(m.Username, "testcomparepirgs"))
+		}
+		return strings.Join(names, ",")
+	}
+
+	overlapA := newPirg("testcompareoverlapa", "a", "b", "c")
+	overlapB := newPirg("testcompareoverlapb", "c", "b", "d")
+	c, err := ComparePirgMembers(db, overlapA.Id, overlapB.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usernames(c.OnlyA); got != "a" {
+		t.Errorf("expected only a in A got %q", got)
+	}
+	if got := usernames(c.OnlyB); got != "d" {
+		t.Errorf("expected only d in B got %q", got)
+	}
+	if got := usernames(c.Both); got != "b,c" {
+		t.Errorf("expected b,c in both got %q", got)
+	}
+
+	disjoint := newPirg("testcomparedisjoint", "e")
+	c, err = ComparePirgMembers(db, overlapA.Id, disjoint.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usernames(c.OnlyA) != "a,b,c" || usernames(c.OnlyB) != "e" || c.Both == nil || len(c.Both) != 0 {
+		t.Errorf("expected disjoint sets got only a %q only b %q both %q",
+			usernames(c.OnlyA), usernames(c.OnlyB), usernames(c.Both))
+	}
+}
+
+func TestPirgMembersBatchedInserts(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	// lower the limit so a handful of members needs several statements
+	defaultMaxParams := maxStatementParams
+	maxStatementParams = 3
+	defer func() { maxStatementParams = defaultMaxParams }()
+
+	var userIds []int
+	for i := 0; i < 7; i++ {
+		username := fmt.Sprintf("testbatchedinserts%d", i)
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Batch",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, user.Id)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testbatchedinserts",
+		OwnerId:  userIds[0],
+		AdminIds: userIds[:5],
+		UserIds:  userIds[:5],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortedIds := func(ids []int) []int {
+		ids = slices.Clone(ids)
+		slices.Sort(ids)
+		return ids
+	}
+	if !slices.Equal(sortedIds(pirg.UserIds), userIds[:5]) || !slices.Equal(sortedIds(pirg.AdminIds), userIds[:5]) {
+		t.Fatalf("expected all 5 users and admins got users %v admins %v", pirg.UserIds, pirg.AdminIds)
+	}
+
+	// a bad id in a later batch rolls back the batches before it
+	_, err = UpdatePirg(db, pirg.Id, &PirgRequest{
+		Name:     pirg.Name,
+		OwnerId:  userIds[0],
+		AdminIds: userIds[:1],
+		UserIds:  append(slices.Clone(userIds), 999999999),
+	}, 0)
+	if err == nil {
+		t.Fatal("expected an error adding a user that doesn't exist")
+	}
+	unchanged, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sortedIds(unchanged.UserIds), userIds[:5]) || len(unchanged.AdminIds) != 5 {
+		t.Fatalf("expected the failed update to change nothing got users %v admins %v", unchanged.UserIds, unchanged.AdminIds)
+	}
+
+	updated, err := UpdatePirg(db, pirg.Id, &PirgRequest{
+		Name:     pirg.Name,
+		OwnerId:  userIds[0],
+		AdminIds: userIds[:1],
+		UserIds:  userIds,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sortedIds(updated.UserIds), userIds) || !slices.Equal(updated.AdminIds, userIds[:1]) {
+		t.Fatalf("expected all 7 users and 1 admin got users %v admins %v", updated.UserIds, updated.AdminIds)
+	}
+}
+
+func TestUpsertPirg(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	owner, err := CreateUser(db, &UserRequest{
+		Username:  "testupsertpirgowner",
+		Email:     "testupsertpirgowner@localhost",
+		FirstName: "Test",
+		LastName:  "Owner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := CreateUser(db, &UserRequest{
+		Username:  "testupsertpirgmember",
+		Email:     "testupsertpirgmember@localhost",
+		FirstName: "Test",
+		LastName:  "Member",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := PirgRequest{
+		Name:     "testupsertpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	}
+	pirg, created, err := UpsertPirg(db, &pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Fatal("expected pirg to be created")
+	}
+	if pirg.Name != pr.Name {
+		t.Fatalf("expected name %v got %v", pr.Name, pirg.Name)
+	}
+
+	pr.OwnerId = member.Id
+	pr.UserIds = []int{owner.Id, member.Id}
+	updated, created, err := UpsertPirg(db, &pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Fatal("expected pirg to be updated")
+	}
+	if updated.Id != pirg.Id {
+		t.Fatalf("expected id %v got %v", pirg.Id, updated.Id)
+	}
+	if updated.OwnerId != member.Id {
+		t.Fatalf("expected owner id %v got %v", member.Id, updated.OwnerId)
+	}
+	if len(updated.UserIds) != 2 {
+		t.Fatalf("expected 2 users got %v", len(updated.UserIds))
+	}
+}
+
+func TestUpsertPirgConcurrent(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	owner, err := CreateUser(db, &UserRequest{
+		Username:  "testupsertpirgconcurrent",
+		Email:     "testupsertpirgconcurrent@localhost",
+		FirstName: "Test",
+		LastName:  "Owner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := PirgRequest{
+		Name:     "testupsertpirgconcurrent",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	}
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	createdCount := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, created, err := UpsertPirg(db, &pr)
+			errs <- err
+			createdCount <- created
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	close(createdCount)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	creates := 0
+	for created := range createdCount {
+		if created {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Fatalf("expected exactly 1 create got %v", creates)
+	}
+	pirg, err := GetPirgByName(db, pr.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pirg.UserIds) != 1 || len(pirg.AdminIds) != 1 {
+		t.Fatalf("expected 1 user and 1 admin got %v and %v", len(pirg.UserIds), len(pirg.AdminIds))
+	}
+}
+
+func TestGetPirgMembersPaging(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	const memberCount = 35
+	var userIds []int
+	for i := 0; i < memberCount; i++ {
+		username := fmt.Sprintf("testpirgmemberspaging%02d", i)
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Member",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, user.Id)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testpirgmemberspaging",
+		OwnerId:  userIds[0],
+		AdminIds: []int{userIds[0]},
+		UserIds:  userIds,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[int]bool{}
+	pages := 0
+	for offset := 0; ; offset += 10 {
+		members, total, err := GetPirgMembers(db, pirg.Id, 10, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != memberCount {
+			t.Fatalf("expected total %v got %v", memberCount, total)
+		}
+		if len(members) == 0 {
+			break
+		}
+		pages++
+		for _, m := range members {
+			if seen[m.UserId] {
+				t.Fatalf("user %v returned on more than one page", m.UserId)
+			}
+			seen[m.UserId] = true
+			if m.IsAdmin != (m.UserId == userIds[0]) {
+				t.Fatalf("unexpected is_admin %v for user %v", m.IsAdmin, m.UserId)
+			}
+		}
+	}
+	if pages != 4 {
+		t.Fatalf("expected 4 pages got %v", pages)
+	}
+	if len(seen) != memberCount {
+		t.Fatalf("expected to see %v members got %v", memberCount, len(seen))
+	}
+}
+
+func TestReconcilePirgMembers(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"owner", "keep", "drop", "join"} {
+		username := "testreconcile" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Reconcile",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, keep, drop, join := users[0], users[1], users[2], users[3]
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testreconcilemembers",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id, drop.Id},
+		UserIds:  []int{owner.Id, keep.Id, drop.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := []string{owner.Username, keep.Username, join.Username}
+
+	checkDiff := func(result *PirgReconcileResult) {
+		t.Helper()
+		if len(result.Added) != 1 || result.Added[0].UserId != join.Id {
+			t.Fatalf("expected %v to be added got %+v", join.Username, result.Added)
+		}
+		if len(result.Removed) != 1 || result.Removed[0].UserId != drop.Id {
+			t.Fatalf("expected %v to be removed got %+v", drop.Username, result.Removed)
+		}
+	}
+
+	// dry run reports the diff without applying it
+	result, err := ReconcilePirgMembers(db, pirg.Id, desired, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDiff(result)
+	unchanged, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(unchanged.UserIds, pirg.UserIds) || !slices.Equal(unchanged.AdminIds, pirg.AdminIds) {
+		t.Fatalf("expected dry run to change nothing, got users %v admins %v", unchanged.UserIds, unchanged.AdminIds)
+	}
+
+	result, err = ReconcilePirgMembers(db, pirg.Id, desired, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDiff(result)
+	reconciled, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := slices.Clone(reconciled.UserIds)
+	slices.Sort(got)
+	want := []int{owner.Id, keep.Id, join.Id}
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected members %v got %v", want, got)
+	}
+	// the removed member was an admin and loses that too
+	if slices.Contains(reconciled.AdminIds, drop.Id) {
+		t.Fatalf("expected %v to no longer be an admin", drop.Username)
+	}
+
+	// reconciling again is a no-op
+	result, err = ReconcilePirgMembers(db, pirg.Id, desired, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 0 || len(result.Removed) != 0 {
+		t.Fatalf("expected no changes got %+v", result)
+	}
+
+	// the owner can't be reconciled away, and unknown users are rejected
+	if _, err = ReconcilePirgMembers(db, pirg.Id, []string{keep.Username}, false, 0); err == nil {
+		t.Fatal("expected an error when the owner is left out")
+	}
+	if _, err = ReconcilePirgMembers(db, pirg.Id, []string{owner.Username, "testreconcilenobody"}, false, 0); err == nil {
+		t.Fatal("expected an error for an unknown username")
+	}
+
+	// usernames match in any case
+	result, err = ReconcilePirgMembers(db, pirg.Id, []string{"TestReconcileOwner", " TESTRECONCILEKEEP", "testReconcileJoin"}, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 0 || len(result.Removed) != 0 {
+		t.Fatalf("expected usernames in another case to be the same members got %+v", result)
+	}
+	// and deleted users can't be reconciled back in
+	if err = DeleteUser(db, drop.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReconcilePirgMembers(db, pirg.Id, append(desired, drop.Username), false, 0); err == nil {
+		t.Fatal("expected an error for a deleted user")
+	}
+}
+
+func TestReconcileAllPirgMembers(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"owner", "a", "b", "c"} {
+		username := "testreconcileall" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "ReconcileAll",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, a, b, c := users[0], users[1], users[2], users[3]
+	first, err := CreatePirg(db, &PirgRequest{Name: "testreconcileallfirst", OwnerId: owner.Id, UserIds: []int{owner.Id, a.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := CreatePirg(db, &PirgRequest{Name: "testreconcileallsecond", OwnerId: owner.Id, UserIds: []int{owner.Id, b.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := map[string][]string{
+		first.Name:  {owner.Username, b.Username},
+		second.Name: {owner.Username, b.Username, c.Username},
+	}
+	checkDiff := func(results map[string]*PirgReconcileResult) {
+		t.Helper()
+		if len(results) != 2 {
+			t.Fatalf("expected results for 2 pirgs got %v", len(results))
+		}
+		r := results[first.Name]
+		if r.PirgId != first.Id || len(r.Added) != 1 || r.Added[0].UserId != b.Id || len(r.Removed) != 1 || r.Removed[0].UserId != a.Id {
+			t.Fatalf("unexpected diff for %v: %+v", first.Name, r)
+		}
+		r = results[second.Name]
+		if r.PirgId != second.Id || len(r.Added) != 1 || r.Added[0].UserId != c.Id || len(r.Removed) != 0 {
+			t.Fatalf("unexpected diff for %v: %+v", second.Name, r)
+		}
+	}
+	members := func(id int) []int {
+		t.Helper()
+		p, err := GetPirgById(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := slices.Clone(p.UserIds)
+		slices.Sort(ids)
+		return ids
+	}
+	sorted := func(ids ...int) []int {
+		slices.Sort(ids)
+		return ids
+	}
+
+	results, err := ReconcileAllPirgMembers(db, desired, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDiff(results)
+	if got := members(first.Id); !slices.Equal(got, sorted(owner.Id, a.Id)) {
+		t.Fatalf("expected dry run to change nothing got %v", got)
+	}
+
+	// a failure in one pirg leaves every pirg unchanged
+	broken := map[string][]string{first.Name: desired[first.Name], second.Name: {b.Username}}
+	if _, err = ReconcileAllPirgMembers(db, broken, false, 0); err == nil {
+		t.Fatal("expected an error when a pirg's owner is left out")
+	}
+	if got := members(first.Id); !slices.Equal(got, sorted(owner.Id, a.Id)) {
+		t.Fatalf("expected the failed reconcile to change nothing got %v", got)
+	}
+	if _, err = ReconcileAllPirgMembers(db, map[string][]string{"testreconcileallnothing": {owner.Username}}, true, 0); err == nil {
+		t.Fatal("expected an error for an unknown pirg")
+	}
+
+	results, err = ReconcileAllPirgMembers(db, desired, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDiff(results)
+	if got := members(first.Id); !slices.Equal(got, sorted(owner.Id, b.Id)) {
+		t.Fatalf("unexpected members of %v: %v", first.Name, got)
+	}
+	if got := members(second.Id); !slices.Equal(got, sorted(owner.Id, b.Id, c.Id)) {
+		t.Fatalf("unexpected members of %v: %v", second.Name, got)
+	}
+}
+
+func TestReconcilePirgMembersInBatches(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for i := 0; i < 61; i++ {
+		username := fmt.Sprintf("testreconcilebatch%02d", i)
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "ReconcileBatch",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	// the owner stays, the 25 current members are replaced by 35 new ones
+	owner, current, joining := users[0], users[1:26], users[26:]
+	userIds := []int{owner.Id}
+	for _, u := range current {
+		userIds = append(userIds, u.Id)
+	}
+	first, err := CreatePirg(db, &PirgRequest{Name: "testreconcilebatchfirst", OwnerId: owner.Id, AdminIds: []int{owner.Id, current[0].Id}, UserIds: userIds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := CreatePirg(db, &PirgRequest{Name: "testreconcilebatchsecond", OwnerId: owner.Id, UserIds: userIds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := []string{owner.Username}
+	want := []int{owner.Id}
+	for _, u := range joining {
+		desired = append(desired, u.Username)
+		want = append(want, u.Id)
+	}
+	slices.Sort(want)
+	members := func(id int) []int {
+		t.Helper()
+		p, err := GetPirgById(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := slices.Clone(p.UserIds)
+		slices.Sort(ids)
+		return ids
+	}
+
+	// 60 changes in batches of 7 takes 9 transactions
+	result, err := ReconcilePirgMembers(db, first.Id, desired, false, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != len(joining) || len(result.Removed) != len(current) {
+		t.Fatalf("expected %d added and %d removed got %d and %d", len(joining), len(current), len(result.Added), len(result.Removed))
+	}
+	for i, m := range result.Added {
+		if m.UserId != joining[i].Id {
+			t.Fatalf("expected additions in request order, %d is %v want %v", i, m.Username, joining[i].Username)
+		}
+	}
+	if got := members(first.Id); !slices.Equal(got, want) {
+		t.Fatalf("expected members %v got %v", want, got)
+	}
+	reconciled, err := GetPirgById(db, first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(reconciled.AdminIds, []int{owner.Id}) {
+		t.Fatalf("expected the removed admin to lose admin got %v", reconciled.AdminIds)
+	}
+	result, err = ReconcilePirgMembers(db, first.Id, desired, false, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 0 || len(result.Removed) != 0 {
+		t.Fatalf("expected no changes got %+v", result)
+	}
+
+	// a bad pirg fails before any pirg is changed
+	broken := map[string][]string{first.Name: {owner.Username}, second.Name: desired[1:]}
+	if _, err = ReconcileAllPirgMembers(db, broken, false, 7); err == nil {
+		t.Fatal("expected an error when a pirg's owner is left out")
+	}
+	if got := members(first.Id); !slices.Equal(got, want) {
+		t.Fatalf("expected the failed reconcile to change nothing got %v", got)
+	}
+
+	results, err := ReconcileAllPirgMembers(db, map[string][]string{first.Name: desired, second.Name: desired}, false, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[first.Name]; len(r.Added) != 0 || len(r.Removed) != 0 {
+		t.Fatalf("expected no changes to %v got %+v", first.Name, r)
+	}
+	if r := results[second.Name]; len(r.Added) != len(joining) || len(r.Removed) != len(current) {
+		t.Fatalf("unexpected diff for %v: %d added %d removed", second.Name, len(r.Added), len(r.Removed))
+	}
+	if got := members(second.Id); !slices.Equal(got, want) {
+		t.Fatalf("expected members of %v %v got %v", second.Name, want, got)
+	}
+}
+
+// TODO(lcrown):
 // GetOne
 // Update?
 // Delete
+
+func TestTransferAllPirgs(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, name := range []string{"from", "to", "other"} {
+		username := "testtransferall" + name
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "TransferAll",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	from, to, other := users[0], users[1], users[2]
+	var owned []int
+	for i, userIds := range [][]int{{from.Id}, {from.Id, to.Id}} {
+		p, err := CreatePirg(db, &PirgRequest{Name: fmt.Sprintf("testtransferall%d", i), OwnerId: from.Id, AdminIds: []int{from.Id}, UserIds: userIds})
+		if err != nil {
+			t.Fatal(err)
+		}
+		owned = append(owned, p.Id)
+	}
+	untouched, err := CreatePirg(db, &PirgRequest{Name: "testtransferallother", OwnerId: other.Id, UserIds: []int{other.Id, from.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = TransferAllPirgs(db, from.Id, from.Id, "test", false); err == nil {
+		t.Fatal("expected transferring to the same user to fail")
+	}
+	pirgIds, err := TransferAllPirgs(db, from.Id, to.Id, "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(pirgIds, owned) {
+		t.Fatalf("expected pirgs %v to be transferred got %v", owned, pirgIds)
+	}
+	for _, id := range owned {
+		p, err := GetPirgById(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.OwnerId != to.Id || !slices.Contains(p.UserIds, to.Id) {
+			t.Errorf("expected %v to be owned by and have member %v got owner %v members %v", p.Name, to.Id, p.OwnerId, p.UserIds)
+		}
+		count := 0
+		for _, userId := range p.UserIds {
+			if userId == to.Id {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("expected %v to be a member of %v once got %v", to.Id, p.Name, p.UserIds)
+		}
+		// the new owner is an admin, so the pirg can still be updated as it reads back
+		count = 0
+		for _, adminId := range p.AdminIds {
+			if adminId == to.Id {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("expected %v to be an admin of %v once got %v", to.Id, p.Name, p.AdminIds)
+		}
+	}
+	p, err := GetPirgById(db, untouched.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.OwnerId != other.Id {
+		t.Errorf("expected pirgs owned by others to be untouched got owner %v", p.OwnerId)
+	}
+
+	// nothing left to transfer
+	if pirgIds, err = TransferAllPirgs(db, from.Id, to.Id, "test", false); err != nil || len(pirgIds) != 0 {
+		t.Fatalf("expected nothing to transfer got %v %v", pirgIds, err)
+	}
+}
+
+func TestDataUpdatePirgVersion(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var userIds []int
+	for i := 0; i < 2; i++ {
+		username := fmt.Sprintf("testdatapirgversion%d", i)
+		user, err := CreateUser(db, &UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Version"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, user.Id)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{Name: "testdatapirgversion", OwnerId: userIds[0], AdminIds: userIds[:1], UserIds: userIds[:1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// adding a member moves the version on, so an update based on the
+	// version before it can't drop them
+	if _, _, err := AddPirgMember(db, pirg.Id, userIds[1], nil); err != nil {
+		t.Fatal(err)
+	}
+	added, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.Version <= pirg.Version {
+		t.Fatalf("expected adding a member to bump version %d got %d", pirg.Version, added.Version)
+	}
+	_, err = UpdatePirg(db, pirg.Id, &PirgRequest{Name: pirg.Name, OwnerId: userIds[0], AdminIds: userIds[:1], UserIds: userIds[:1]}, pirg.Version)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict updating a stale version got %v", err)
+	}
+	if got, err := GetPirgById(db, pirg.Id); err != nil || len(got.UserIds) != 2 {
+		t.Fatalf("expected the stale update to change nothing got %+v %v", got, err)
+	}
+
+	updated, err := UpdatePirg(db, pirg.Id, &PirgRequest{Name: pirg.Name, OwnerId: userIds[0], AdminIds: userIds[:1], UserIds: userIds[:1]}, added.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.UserIds) != 1 || updated.Version <= added.Version {
+		t.Errorf("expected the current version's update to remove the member and bump the version got %+v", updated)
+	}
+	if _, err := UpdatePirg(db, 999999999, &PirgRequest{Name: pirg.Name, OwnerId: userIds[0]}, 1); errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected a missing pirg not to be a version conflict")
+	}
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	PosixIdKindUid = "uid"
+	PosixIdKindGid = "gid"
+)
+
+// ErrPosixIdRangeExhausted is returned when every id in the range is allocated
+var ErrPosixIdRangeExhausted = errors.New("posix id range exhausted")
+
+// ErrPosixIdRangeNotConfigured is returned when an id is needed from an unset range
+var ErrPosixIdRangeNotConfigured = errors.New("posix id range not configured")
+
+// PosixIdRange is the inclusive range ids of a kind are allocated from
+type PosixIdRange struct {
+	Min int
+	Max int
+}
+
+// Configured reports whether the range has been set
+func (r PosixIdRange) Configured() bool {
+	return r.Min > 0 && r.Max >= r.Min
+}
+
+// NextPosixId returns the id that AllocatePosixId would assign next from the range,
+// without allocating it. Another allocation can take the id at any time,
+// so the result is only advisory.
+func NextPosixId(db *sql.DB, kind string, rng PosixIdRange) (int, error) {
+	slog.Debug("getting next posix id from database", "kind", kind, "package", "data", "method", "NextPosixId")
+	return nextPosixId(db, kind, rng)
+}
+
+func nextPosixId(q querier, kind string, rng PosixIdRange) (int, error) {
+	if !rng.Configured() {
+		return 0, fmt.Errorf("%w: %s", ErrPosixIdRangeNotConfigured, kind)
+	}
+	var next int
+	err := q.QueryRow(`
+		SELECT COALESCE(MAX(value) + 1, $2)
+		FROM posix_ids
+		WHERE kind = $1 AND value BETWEEN $2 AND $3`,
+		kind, rng.Min, rng.Max).Scan(&next)
+	if err != nil {
+		return 0, err
+	}
+	if next > rng.Max {
+		return 0, ErrPosixIdRangeExhausted
+	}
+	return next, nil
+}
+
+// AllocatePosixId assigns the next id of the kind from the range to the resource,
+// a user id for uids or a pirg id for gids. If the resource already has an id
+// of that kind, it's returned instead.
+func AllocatePosixId(db *sql.DB, kind string, resourceId int, rng PosixIdRange) (int, error) {
+	slog.Debug("allocating posix id in database", "kind", kind, "resource_id", resourceId, "package", "data", "method", "AllocatePosixId")
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// serialize allocations of the same kind so two can't pick the same id
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext('posix_ids_' || $1))", kind)
+	if err != nil {
+		return 0, err
+	}
+	var existing int
+	err = tx.QueryRow("SELECT value FROM posix_ids WHERE kind = $1 AND resource_id = $2", kind, resourceId).Scan(&existing)
+	if err == nil {
+		return existing, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+	next, err := nextPosixId(tx, kind, rng)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("INSERT INTO posix_ids (kind, value, resource_id) VALUES ($1, $2, $3)", kind, next, resourceId)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// PosixIdUtilization is how much of a range is allocated. Ids are handed
+// out above the highest allocated one, so Remaining can be less than Free
+// when ids in the range were allocated out of order.
+type PosixIdUtilization struct {
+	Kind      string
+	Range     PosixIdRange
+	Size      int
+	Allocated int
+	Free      int
+	Remaining int
+	// Next is the id the allocator would assign next, or 0 if the range is exhausted
+	Next int
+}
+
+// GetPosixIdUtilization returns the utilization of the range of the kind
+func GetPosixIdUtilization(db *sql.DB, kind string, rng PosixIdRange) (*PosixIdUtilization, error) {
+	slog.Debug("getting posix id utilization from database", "kind", kind, "package", "data", "method", "GetPosixIdUtilization")
+	if !rng.Configured() {
+		return nil, fmt.Errorf("%w: %s", ErrPosixIdRangeNotConfigured, kind)
+	}
+	u := PosixIdUtilization{Kind: kind, Range: rng, Size: rng.Max - rng.Min + 1}
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM posix_ids
+		WHERE kind = $1 AND value BETWEEN $2 AND $3`,
+		kind, rng.Min, rng.Max).Scan(&u.Allocated)
+	if err != nil {
+		return nil, err
+	}
+	u.Free = u.Size - u.Allocated
+	u.Next, err = nextPosixId(db, kind, rng)
+	if errors.Is(err, ErrPosixIdRangeExhausted) {
+		return &u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Remaining = rng.Max - u.Next + 1
+	return &u, nil
+}
+
+// PosixIdBackfillResult counts the ids BackfillPosixIds allocated of each kind
+type PosixIdBackfillResult struct {
+	Uids   int
+	Gids   int
+	DryRun bool
+}
+
+// BackfillPosixIds allocates a uid to every user and a gid to every pirg
+// that doesn't have one, for records created before allocation was turned
+// on. Kinds whose range isn't configured are skipped, and it's an
+// ErrPosixIdRangeNotConfigured error if neither is. Ids are handed out in
+// record id order, all in one transaction, so if a range doesn't have room
+// for everyone it's ErrPosixIdRangeExhausted and nothing is allocated. With
+// dryRun the transaction is rolled back.
+func BackfillPosixIds(db *sql.DB, uids PosixIdRange, gids PosixIdRange, dryRun bool) (*PosixIdBackfillResult, error) {
+	slog.Debug("backfilling posix ids in database", "dry_run", dryRun, "package", "data", "method", "BackfillPosixIds")
+	if !uids.Configured() && !gids.Configured() {
+		return nil, fmt.Errorf("%w: uid or gid", ErrPosixIdRangeNotConfigured)
+	}
+	result := &PosixIdBackfillResult{DryRun: dryRun}
+	err := WithTx(txContext(dryRun), db, func(tx *sql.Tx) error {
+		var err error
+		if uids.Configured() {
+			result.Uids, err = backfillPosixIds(tx, PosixIdKindUid, "SELECT id FROM users WHERE deleted_at IS NULL", uids)
+			if err != nil {
+				return err
+			}
+		}
+		if gids.Configured() {
+			result.Gids, err = backfillPosixIds(tx, PosixIdKindGid, "SELECT id FROM pirgs", gids)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// backfillPosixIds allocates an id of the kind from rng to each resource the
+// query selects that doesn't have one, and returns how many were allocated
+func backfillPosixIds(tx *sql.Tx, kind string, resources string, rng PosixIdRange) (int, error) {
+	// the same lock AllocatePosixId takes, so neither picks an id the other does
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('posix_ids_' || $1))", kind); err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query(`
+		SELECT r.id FROM (`+resources+`) r
+		WHERE NOT EXISTS (SELECT 1 FROM posix_ids p WHERE p.kind = $1 AND p.resource_id = r.id)
+		ORDER BY r.id`, kind)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var missing []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		missing = append(missing, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	next, err := nextPosixId(tx, kind, rng)
+	if errors.Is(err, ErrPosixIdRangeExhausted) {
+		return 0, fmt.Errorf("%w: %d %ss are missing and none are left", err, len(missing), kind)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if remaining := rng.Max - next + 1; len(missing) > remaining {
+		return 0, fmt.Errorf("%w: %d %ss are missing and only %d are left", ErrPosixIdRangeExhausted, len(missing), kind, remaining)
+	}
+	for i, resourceId := range missing {
+		_, err = tx.Exec("INSERT INTO posix_ids (kind, value, resource_id) VALUES ($1, $2, $3)", kind, next+i, resourceId)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(missing), nil
+}
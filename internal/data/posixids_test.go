@@ -0,0 +1,196 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAllocatePosixId(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	rng := PosixIdRange{Min: 70000, Max: 70001}
+
+	next, err := NextPosixId(db, PosixIdKindUid, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != rng.Min {
+		t.Fatalf("expected next uid %v got %v", rng.Min, next)
+	}
+	// previewing doesn't consume the id
+	again, err := NextPosixId(db, PosixIdKindUid, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != next {
+		t.Fatalf("expected preview to be stable, got %v then %v", next, again)
+	}
+	allocated, err := AllocatePosixId(db, PosixIdKindUid, 1, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated != next {
+		t.Fatalf("expected allocated uid %v to match preview %v", allocated, next)
+	}
+
+	// the same resource keeps its id
+	existing, err := AllocatePosixId(db, PosixIdKindUid, 1, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing != allocated {
+		t.Fatalf("expected existing uid %v got %v", allocated, existing)
+	}
+
+	// kinds are allocated independently
+	gid, err := NextPosixId(db, PosixIdKindGid, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gid != rng.Min {
+		t.Fatalf("expected next gid %v got %v", rng.Min, gid)
+	}
+
+	if _, err = AllocatePosixId(db, PosixIdKindUid, 2, rng); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NextPosixId(db, PosixIdKindUid, rng); !errors.Is(err, ErrPosixIdRangeExhausted) {
+		t.Fatalf("expected range exhausted got %v", err)
+	}
+	if _, err = AllocatePosixId(db, PosixIdKindUid, 3, rng); !errors.Is(err, ErrPosixIdRangeExhausted) {
+		t.Fatalf("expected range exhausted got %v", err)
+	}
+}
+
+func TestGetPosixIdUtilization(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	rng := PosixIdRange{Min: 74000, Max: 74009}
+
+	u, err := GetPosixIdUtilization(db, PosixIdKindGid, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Size != 10 || u.Allocated != 0 || u.Free != 10 || u.Remaining != 10 || u.Next != rng.Min {
+		t.Fatalf("unexpected utilization of an empty range %+v", u)
+	}
+
+	for _, resourceId := range []int{1, 2} {
+		if _, err := AllocatePosixId(db, PosixIdKindGid, resourceId, rng); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an id assigned out of order leaves a gap the allocator won't use
+	_, err = db.Exec("INSERT INTO posix_ids (kind, value, resource_id) VALUES ($1, $2, $3)", PosixIdKindGid, 74005, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ids outside the range don't count
+	if _, err := AllocatePosixId(db, PosixIdKindGid, 4, PosixIdRange{Min: 75000, Max: 75009}); err != nil {
+		t.Fatal(err)
+	}
+	u, err = GetPosixIdUtilization(db, PosixIdKindGid, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Allocated != 3 || u.Free != 7 || u.Remaining != 4 || u.Next != 74006 {
+		t.Fatalf("unexpected utilization %+v", u)
+	}
+
+	if _, err = GetPosixIdUtilization(db, PosixIdKindUid, PosixIdRange{}); !errors.Is(err, ErrPosixIdRangeNotConfigured) {
+		t.Fatalf("expected range not configured got %v", err)
+	}
+}
+
+func TestBackfillPosixIds(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	uids := PosixIdRange{Min: 76000, Max: 79999}
+	gids := PosixIdRange{Min: 76000, Max: 79999}
+
+	var users []*User
+	for _, username := range []string{"testbackfilla", "testbackfillb", "testbackfillc"} {
+		user, err := CreateUser(db, &UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Backfill"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{Name: "testbackfill", OwnerId: users[0].Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a user that already has a uid keeps it
+	kept, err := AllocatePosixId(db, PosixIdKindUid, users[0].Id, uids)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = BackfillPosixIds(db, PosixIdRange{}, PosixIdRange{}, false); !errors.Is(err, ErrPosixIdRangeNotConfigured) {
+		t.Fatalf("expected range not configured got %v", err)
+	}
+	// a range too small for every missing id allocates none of them
+	if _, err = BackfillPosixIds(db, PosixIdRange{Min: kept, Max: kept + 1}, PosixIdRange{}, false); !errors.Is(err, ErrPosixIdRangeExhausted) {
+		t.Fatalf("expected range exhausted got %v", err)
+	}
+	if _, err := GetUserByUid(db, kept+1); err == nil {
+		t.Fatal("expected an exhausted backfill to allocate nothing")
+	}
+
+	dryRun, err := BackfillPosixIds(db, uids, gids, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dryRun.Uids < 2 || dryRun.Gids < 1 || !dryRun.DryRun {
+		t.Fatalf("expected the dry run to count the missing ids got %+v", dryRun)
+	}
+	if _, err := GetUserByUid(db, kept+1); err == nil {
+		t.Fatal("expected a dry run to allocate nothing")
+	}
+
+	result, err := BackfillPosixIds(db, uids, gids, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Uids != dryRun.Uids || result.Gids != dryRun.Gids {
+		t.Fatalf("expected the backfill to allocate what the dry run counted, %+v got %+v", dryRun, result)
+	}
+	seen := map[int]bool{}
+	for i, user := range users {
+		var uid int
+		if err := db.QueryRow("SELECT value FROM posix_ids WHERE kind = $1 AND resource_id = $2", PosixIdKindUid, user.Id).Scan(&uid); err != nil {
+			t.Fatalf("expected %s to have a uid: %v", user.Username, err)
+		}
+		if i == 0 && uid != kept {
+			t.Errorf("expected %s to keep uid %v got %v", user.Username, kept, uid)
+		}
+		if seen[uid] {
+			t.Errorf("uid %v allocated twice", uid)
+		}
+		seen[uid] = true
+	}
+	var gid int
+	if err := db.QueryRow("SELECT value FROM posix_ids WHERE kind = $1 AND resource_id = $2", PosixIdKindGid, pirg.Id).Scan(&gid); err != nil {
+		t.Fatalf("expected the pirg to have a gid: %v", err)
+	}
+	var duplicates int
+	err = db.QueryRow("SELECT COUNT(*) FROM (SELECT kind, value FROM posix_ids GROUP BY kind, value HAVING COUNT(*) > 1) d").Scan(&duplicates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicates != 0 {
+		t.Errorf("expected every id to be unique got %v duplicates", duplicates)
+	}
+
+	// everything has an id now, so there's nothing left to backfill
+	result, err = BackfillPosixIds(db, uids, gids, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Uids != 0 || result.Gids != 0 {
+		t.Fatalf("expected nothing to backfill got %+v", result)
+	}
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"database/sql"
+	"log/slog"
+)
+
+// ProvisionedMember is a pirg member and the uid their files are owned by
+type ProvisionedMember struct {
+	UserId   int
+	Username string
+	Uid      int
+}
+
+// PirgProvisioning is what's needed to create a pirg on a filesystem,
+// its gid and the uid of each member, ordered by username
+type PirgProvisioning struct {
+	PirgId  int
+	Name    string
+	OwnerId int
+	Gid     int
+	Members []*ProvisionedMember
+}
+
+// GetPirgProvisioning returns the provisioning attributes of the pirg.
+// The pirg's gid and the members' uids are allocated from the ranges if they
+// don't have one yet, so a range only needs to be configured until then.
+func GetPirgProvisioning(db *sql.DB, pirgId int, uids PosixIdRange, gids PosixIdRange) (*PirgProvisioning, error) {
+	slog.Debug("getting pirg provisioning from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgProvisioning")
+	p := PirgProvisioning{PirgId: pirgId}
+	err := db.QueryRow("SELECT name, owner_id FROM pirgs WHERE id = $1", pirgId).Scan(&p.Name, &p.OwnerId)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT u.id, u.username
+		FROM active_pirgs_users pu
+		JOIN users u ON u.id = pu.user_id
+		WHERE pu.pirg_id = $1
+		ORDER BY u.username`, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	p.Members = []*ProvisionedMember{}
+	for rows.Next() {
+		var m ProvisionedMember
+		if err := rows.Scan(&m.UserId, &m.Username); err != nil {
+			return nil, err
+		}
+		p.Members = append(p.Members, &m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// allocation takes its own transaction, so the rows are read first
+	rows.Close()
+
+	p.Gid, err = AllocatePosixId(db, PosixIdKindGid, pirgId, gids)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range p.Members {
+		m.Uid, err = AllocatePosixId(db, PosixIdKindUid, m.UserId, uids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// GetAllPirgProvisioning returns the provisioning attributes of every pirg,
+// ordered by name, allocating ids the same as GetPirgProvisioning
+func GetAllPirgProvisioning(db *sql.DB, uids PosixIdRange, gids PosixIdRange) ([]*PirgProvisioning, error) {
+	slog.Debug("getting all pirg provisioning from database", "package", "data", "method", "GetAllPirgProvisioning")
+	rows, err := db.Query("SELECT id FROM pirgs ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pirgIds []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		pirgIds = append(pirgIds, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	pirgs := []*PirgProvisioning{}
+	for _, id := range pirgIds {
+		p, err := GetPirgProvisioning(db, id, uids, gids)
+		if err != nil {
+			return nil, err
+		}
+		pirgs = append(pirgs, p)
+	}
+	return pirgs, nil
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetPirgProvisioning(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	uids := PosixIdRange{Min: 72000, Max: 72099}
+	gids := PosixIdRange{Min: 73000, Max: 73099}
+
+	var userIds []int
+	for _, username := range []string{"testprovisioningb", "testprovisioninga"} {
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Provisioning",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, user.Id)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testprovisioning",
+		OwnerId:  userIds[0],
+		AdminIds: userIds[:1],
+		UserIds:  userIds,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = GetPirgProvisioning(db, pirg.Id, PosixIdRange{}, gids); !errors.Is(err, ErrPosixIdRangeNotConfigured) {
+		t.Fatalf("expected range not configured got %v", err)
+	}
+	p, err := GetPirgProvisioning(db, pirg.Id, uids, gids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != pirg.Name || p.OwnerId != userIds[0] {
+		t.Errorf("expected pirg %v owned by %v got %v owned by %v", pirg.Name, userIds[0], p.Name, p.OwnerId)
+	}
+	if p.Gid < gids.Min || p.Gid > gids.Max {
+		t.Errorf("expected gid in %v got %v", gids, p.Gid)
+	}
+	if len(p.Members) != 2 || p.Members[0].Username != "testprovisioninga" || p.Members[1].Username != "testprovisioningb" {
+		t.Fatalf("expected both members ordered by username got %+v", p.Members)
+	}
+	if p.Members[0].Uid == p.Members[1].Uid {
+		t.Errorf("expected members to have distinct uids got %v", p.Members[0].Uid)
+	}
+
+	// ids are kept once allocated, even without a range
+	again, err := GetPirgProvisioning(db, pirg.Id, PosixIdRange{}, PosixIdRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Gid != p.Gid || again.Members[0].Uid != p.Members[0].Uid || again.Members[1].Uid != p.Members[1].Uid {
+		t.Errorf("expected the allocated ids to be stable got %+v then %+v", p, again)
+	}
+}
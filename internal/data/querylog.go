@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// loggingConnector wraps a driver.Connector so every query and exec run on
+// its connections is logged at debug level. Argument values are never
+// logged, only their types and lengths.
+type loggingConnector struct {
+	driver.Connector
+}
+
+func (c *loggingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &loggingConn{Conn: conn}, nil
+}
+
+// Close closes the wrapped connector if it has to be, like the pgx one
+func (c *loggingConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// loggingConn passes through to the wrapped connection, forwarding the
+// optional driver interfaces database/sql looks for
+type loggingConn struct {
+	driver.Conn
+}
+
+func (c *loggingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	logQuery("QueryContext", query, args)
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *loggingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	logQuery("ExecContext", query, args)
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *loggingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *loggingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *loggingConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *loggingConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *loggingConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func logQuery(method string, query string, args []driver.NamedValue) {
+	slog.Debug("database query", "package", "data", "method", method, "query", strings.Join(strings.Fields(query), " "), "args", redactArgs(args))
+}
+
+// redactArgs describes query arguments without their values,
+// e.g. [$1=string(len=5) $2=int64 $3=NULL]
+func redactArgs(args []driver.NamedValue) []string {
+	summary := make([]string, 0, len(args))
+	for _, arg := range args {
+		var desc string
+		switch v := arg.Value.(type) {
+		case nil:
+			desc = "NULL"
+		case string:
+			desc = fmt.Sprintf("string(len=%d)", len(v))
+		case []byte:
+			desc = fmt.Sprintf("[]byte(len=%d)", len(v))
+		default:
+			desc = fmt.Sprintf("%T", v)
+		}
+		summary = append(summary, fmt.Sprintf("$%d=%s", arg.Ordinal, desc))
+	}
+	return summary
+}
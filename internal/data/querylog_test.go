@@ -0,0 +1,64 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not implemented") }
+func (fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return []string{"id"} }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func TestLoggingConnector(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(defaultLogger)
+
+	db := sql.OpenDB(&loggingConnector{Connector: fakeConnector{}})
+	defer db.Close()
+	rows, err := db.Query("SELECT id FROM users WHERE username = $1 AND email = $2 AND id = $3",
+		"secretname", "secret@example.edu", 987654321)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	logged := buf.String()
+	if !strings.Contains(logged, "SELECT id FROM users WHERE username = $1") {
+		t.Fatalf("expected the query to be logged, got: %s", logged)
+	}
+	for _, want := range []string{"$1=string(len=10)", "$2=string(len=18)", "$3=int64"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("expected %q in the log, got: %s", want, logged)
+		}
+	}
+	for _, secret := range []string{"secretname", "secret@example.edu", "987654321"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("expected %q to be redacted, got: %s", secret, logged)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrQueryTimeout is returned when a query is canceled for running longer
+// than the query timeout
+var ErrQueryTimeout = errors.New("database query timed out")
+
+// timeoutConnector wraps a driver.Connector so every query and exec run on
+// its connections is canceled after timeout. Only running a query counts,
+// once its rows are coming back reading them can take as long as it has to,
+// so streaming a large result to a slow client isn't cut off.
+type timeoutConnector struct {
+	driver.Connector
+	timeout time.Duration
+}
+
+func (c *timeoutConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutConn{Conn: conn, timeout: c.timeout}, nil
+}
+
+// Close closes the wrapped connector if it has to be, like the pgx one
+func (c *timeoutConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// timeoutConn passes through to the wrapped connection, forwarding the
+// optional driver interfaces database/sql looks for
+type timeoutConn struct {
+	driver.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, stop, cancel := c.withTimeout(ctx)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	stop()
+	if err != nil {
+		cancel(nil)
+		return nil, timeoutError(ctx, err)
+	}
+	// the driver may still be watching ctx, it's canceled once the rows are closed
+	return &timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (c *timeoutConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, stop, cancel := c.withTimeout(ctx)
+	defer cancel(nil)
+	defer stop()
+	res, err := execer.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, timeoutError(ctx, err)
+	}
+	return res, nil
+}
+
+// withTimeout returns ctx canceled with ErrQueryTimeout after the timeout,
+// unless stop is called first
+func (c *timeoutConn) withTimeout(ctx context.Context) (context.Context, func() bool, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(c.timeout, func() { cancel(ErrQueryTimeout) })
+	return ctx, timer.Stop, cancel
+}
+
+func (c *timeoutConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+// BeginTx isn't timed out, only each query in the transaction is
+func (c *timeoutConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *timeoutConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timeoutConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timeoutConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue lets the driver convert its own argument types, pgx has some
+func (c *timeoutConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// timeoutRows cancels the query's context once the rows are closed
+type timeoutRows struct {
+	driver.Rows
+	cancel context.CancelCauseFunc
+}
+
+func (r *timeoutRows) Close() error {
+	defer r.cancel(nil)
+	return r.Rows.Close()
+}
+
+// timeoutError marks err as a timeout if the query was canceled for running
+// too long, whichever error the driver canceled it with
+func timeoutError(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrQueryTimeout) {
+		return fmt.Errorf("%w: %v", ErrQueryTimeout, err)
+	}
+	return err
+}
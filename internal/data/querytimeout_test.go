@@ -0,0 +1,79 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+type blockingConnector struct{}
+
+func (blockingConnector) Connect(context.Context) (driver.Conn, error) { return blockingConn{}, nil }
+func (blockingConnector) Driver() driver.Driver                        { return nil }
+
+// blockingConn runs every query until it's canceled, like the driver does
+type blockingConn struct {
+	fakeConn
+}
+
+func (blockingConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, errors.New("canceling statement due to user request")
+}
+
+func (blockingConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, errors.New("canceling statement due to user request")
+}
+
+func TestTimeoutConnector(t *testing.T) {
+	t.Run("Blocking", func(t *testing.T) {
+		db := sql.OpenDB(&timeoutConnector{Connector: blockingConnector{}, timeout: 10 * time.Millisecond})
+		defer db.Close()
+		if _, err := db.Query("SELECT 1"); !errors.Is(err, ErrQueryTimeout) {
+			t.Errorf("expected the query to time out got %v", err)
+		}
+		if _, err := db.Exec("SELECT 1"); !errors.Is(err, ErrQueryTimeout) {
+			t.Errorf("expected the exec to time out got %v", err)
+		}
+	})
+	t.Run("Fast", func(t *testing.T) {
+		db := sql.OpenDB(&timeoutConnector{Connector: fakeConnector{}, timeout: time.Second})
+		defer db.Close()
+		rows, err := db.Query("SELECT id FROM users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		if err := rows.Err(); err != nil {
+			t.Errorf("expected no error got %v", err)
+		}
+		rows.Close()
+	})
+}
+
+func TestQueryTimeoutPgSleep(t *testing.T) {
+	dbr := testDBRequest(DriverPq)
+	dbr.QueryTimeout = 100 * time.Millisecond
+	db, err := NewDBConn(dbr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	start := time.Now()
+	_, err = db.Exec("SELECT pg_sleep(5)")
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Fatalf("expected ErrQueryTimeout got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the query to be canceled after 100ms, it took %v", elapsed)
+	}
+	// the canceled connection is dropped, the pool still works
+	if _, err := db.Exec("SELECT 1"); err != nil {
+		t.Errorf("expected the next query to succeed got %v", err)
+	}
+}
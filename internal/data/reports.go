@@ -0,0 +1,53 @@
+package data
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"github.com/lib/pq"
+)
+
+// UserReportRow is a user's line in the user report. Uid is 0 if the user
+// hasn't been allocated one, and Pirgs are the names of the pirgs they're an
+// active member of, ordered by name.
+type UserReportRow struct {
+	UserId   int
+	Username string
+	Uid      int
+	Pirgs    []string
+}
+
+// StreamUserReport calls fn for the report row of every user who isn't
+// deleted, ordered by username. Rows are read one at a time so the report is
+// never held in memory. Iteration stops at the first error returned by fn.
+func StreamUserReport(db *sql.DB, fn func(*UserReportRow) error) error {
+	slog.Debug("streaming user report from database", "package", "data", "method", "StreamUserReport")
+	rows, err := db.Query(`
+		SELECT u.id, u.username, p.value,
+			COALESCE(array_agg(g.name ORDER BY g.name) FILTER (WHERE g.name IS NOT NULL), '{}')
+		FROM users u
+		LEFT JOIN posix_ids p ON p.kind = $1 AND p.resource_id = u.id
+		LEFT JOIN active_pirgs_users pu ON pu.user_id = u.id
+		LEFT JOIN pirgs g ON g.id = pu.pirg_id
+		WHERE u.deleted_at IS NULL
+		GROUP BY u.id, u.username, p.value
+		ORDER BY u.username, u.id`, PosixIdKindUid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row UserReportRow
+		var uid sql.NullInt64
+		var pirgs pq.StringArray
+		if err := rows.Scan(&row.UserId, &row.Username, &uid, &pirgs); err != nil {
+			return err
+		}
+		row.Uid = int(uid.Int64)
+		row.Pirgs = pirgs
+		if err = fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStreamUserReport(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+
+	var userIds []int
+	for _, username := range []string{"testreportb", "testreporta"} {
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Report",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, user.Id)
+	}
+	for _, name := range []string{"testreportz", "testreporty"} {
+		_, err := CreatePirg(db, &PirgRequest{
+			Name:     name,
+			OwnerId:  userIds[0],
+			AdminIds: userIds[:1],
+			UserIds:  userIds[:1],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	uid, err := AllocatePosixId(db, PosixIdKindUid, userIds[0], PosixIdRange{Min: 74000, Max: 74099})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var usernames []string
+	found := map[string]*UserReportRow{}
+	err = StreamUserReport(db, func(row *UserReportRow) error {
+		usernames = append(usernames, row.Username)
+		found[row.Username] = row
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.IsSorted(usernames) {
+		t.Errorf("expected rows ordered by username got %v", usernames)
+	}
+	b, a := found["testreportb"], found["testreporta"]
+	if b == nil || a == nil {
+		t.Fatalf("expected both seeded users in the report got %v", usernames)
+	}
+	if b.UserId != userIds[0] || b.Uid != uid || !slices.Equal(b.Pirgs, []string{"testreporty", "testreportz"}) {
+		t.Errorf("expected %v with uid %v in both pirgs got %+v", userIds[0], uid, b)
+	}
+	if a.UserId != userIds[1] || a.Uid != 0 || len(a.Pirgs) != 0 {
+		t.Errorf("expected %v without a uid or pirgs got %+v", userIds[1], a)
+	}
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"database/sql"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PirgMembershipSnapshot is the admins and users of a pirg when it was taken
+type PirgMembershipSnapshot struct {
+	Id        int
+	PirgId    int
+	AdminIds  []int
+	UserIds   []int
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// PirgSnapshotRestore is the outcome of restoring a snapshot.
+// MissingUserIds were in the snapshot but have since been deleted,
+// so they couldn't be restored.
+type PirgSnapshotRestore struct {
+	Snapshot       *PirgMembershipSnapshot
+	BeforeUserIds  []int
+	AfterUserIds   []int
+	MissingUserIds []int
+}
+
+// CreatePirgMembershipSnapshot stores the current admins and users of the pirg
+func CreatePirgMembershipSnapshot(db *sql.DB, pirgId int, actor string) (*PirgMembershipSnapshot, error) {
+	slog.Debug("creating pirg membership snapshot in database", "pirg_id", pirgId, "package", "data", "method", "CreatePirgMembershipSnapshot")
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// lock the pirg so the admins and users are read from the same membership
+	if err = tx.QueryRow("SELECT id FROM pirgs WHERE id = $1 FOR UPDATE", pirgId).Scan(&pirgId); err != nil {
+		return nil, err
+	}
+	s := PirgMembershipSnapshot{PirgId: pirgId, CreatedBy: actor}
+	if s.AdminIds, err = getPirgAdminIds(tx, pirgId); err != nil {
+		return nil, err
+	}
+	if s.UserIds, err = getPirgUserIds(tx, pirgId); err != nil {
+		return nil, err
+	}
+	s.AdminIds = sortedIds(s.AdminIds)
+	s.UserIds = sortedIds(s.UserIds)
+	err = tx.QueryRow(`
+		INSERT INTO pirg_membership_snapshots (pirg_id, admin_ids, user_ids, created_by)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		pirgId, pq.Array(s.AdminIds), pq.Array(s.UserIds), actor).Scan(&s.Id, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, tx.Commit()
+}
+
+// GetPirgMembershipSnapshot returns a snapshot of the pirg,
+// or sql.ErrNoRows if the snapshot is of another pirg
+func GetPirgMembershipSnapshot(db *sql.DB, pirgId int, snapshotId int) (*PirgMembershipSnapshot, error) {
+	slog.Debug("getting pirg membership snapshot from database", "pirg_id", pirgId, "snapshot_id", snapshotId, "package", "data", "method", "GetPirgMembershipSnapshot")
+	return getPirgMembershipSnapshot(db, pirgId, snapshotId)
+}
+
+func getPirgMembershipSnapshot(q querier, pirgId int, snapshotId int) (*PirgMembershipSnapshot, error) {
+	s := PirgMembershipSnapshot{}
+	var adminIds, userIds pq.Int64Array
+	err := q.QueryRow(`
+		SELECT id, pirg_id, admin_ids, user_ids, created_by, created_at
+		FROM pirg_membership_snapshots WHERE id = $1 AND pirg_id = $2`, snapshotId, pirgId).Scan(
+		&s.Id, &s.PirgId, &adminIds, &userIds, &s.CreatedBy, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.AdminIds = intIds(adminIds)
+	s.UserIds = intIds(userIds)
+	return &s, nil
+}
+
+// RestorePirgMembershipSnapshot adds and removes admins and users so the pirg
+// membership matches the snapshot again. Users deleted since the snapshot was
+// taken are left out.
+func RestorePirgMembershipSnapshot(db *sql.DB, pirgId int, snapshotId int) (*PirgSnapshotRestore, error) {
+	slog.Debug("restoring pirg membership snapshot in database", "pirg_id", pirgId, "snapshot_id", snapshotId, "package", "data", "method", "RestorePirgMembershipSnapshot")
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err = tx.QueryRow("SELECT id FROM pirgs WHERE id = $1 FOR UPDATE", pirgId).Scan(&pirgId); err != nil {
+		return nil, err
+	}
+	s, err := getPirgMembershipSnapshot(tx, pirgId, snapshotId)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := getExistingUserIds(tx, append(slices.Clone(s.AdminIds), s.UserIds...))
+	if err != nil {
+		return nil, err
+	}
+	restore := &PirgSnapshotRestore{Snapshot: s, MissingUserIds: []int{}}
+	var adminIds, userIds []int
+	for _, id := range s.AdminIds {
+		if slices.Contains(existing, id) {
+			adminIds = append(adminIds, id)
+		}
+	}
+	for _, id := range s.UserIds {
+		if slices.Contains(existing, id) {
+			userIds = append(userIds, id)
+		} else {
+			restore.MissingUserIds = append(restore.MissingUserIds, id)
+		}
+	}
+	if restore.BeforeUserIds, err = getPirgUserIds(tx, pirgId); err != nil {
+		return nil, err
+	}
+	if err = syncPirgMembers(tx, pirgId, adminIds, userIds); err != nil {
+		return nil, err
+	}
+	if restore.AfterUserIds, err = getPirgUserIds(tx, pirgId); err != nil {
+		return nil, err
+	}
+	return restore, tx.Commit()
+}
+
+// getExistingUserIds returns which of the ids are users
+func getExistingUserIds(q querier, ids []int) ([]int, error) {
+	var existing pq.Int64Array
+	err := q.QueryRow("SELECT COALESCE(array_agg(id), '{}') FROM users WHERE id = ANY($1)", pq.Array(ids)).Scan(&existing)
+	if err != nil {
+		return nil, err
+	}
+	return intIds(existing), nil
+}
+
+func sortedIds(ids []int) []int {
+	if ids == nil {
+		return []int{}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func intIds(ids pq.Int64Array) []int {
+	out := make([]int, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, int(id))
+	}
+	return out
+}
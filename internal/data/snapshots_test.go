@@ -0,0 +1,76 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPirgMembershipSnapshotRestore(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, username := range []string{"testsnapshotowner", "testsnapshota", "testsnapshotb"} {
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "Snapshot",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, a, b := users[0], users[1], users[2]
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testsnapshot",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, a.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := CreatePirgMembershipSnapshot(db, pirg.Id, "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantUsers := sortedIds([]int{owner.Id, a.Id})
+	if !slices.Equal(snapshot.UserIds, wantUsers) || !slices.Equal(snapshot.AdminIds, []int{owner.Id}) {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	// swap a for b and drop the admin, then restore
+	_, err = UpdatePirg(db, pirg.Id, &PirgRequest{Name: pirg.Name, OwnerId: owner.Id, UserIds: []int{owner.Id, b.Id}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore, err := RestorePirgMembershipSnapshot(db, pirg.Id, snapshot.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restore.MissingUserIds) != 0 {
+		t.Errorf("expected no missing users got %v", restore.MissingUserIds)
+	}
+	adminIds, err := getPirgAdminIds(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userIds, err := getPirgUserIds(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sortedIds(userIds), wantUsers) || !slices.Equal(adminIds, []int{owner.Id}) {
+		t.Fatalf("expected users %v and admins %v got %v and %v", wantUsers, []int{owner.Id}, userIds, adminIds)
+	}
+
+	// snapshots are scoped to their pirg
+	other, err := CreatePirg(db, &PirgRequest{Name: "testsnapshotother", OwnerId: owner.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestorePirgMembershipSnapshot(db, other.Id, snapshot.Id); err == nil {
+		t.Fatal("expected error restoring another pirg's snapshot")
+	}
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Store is the single user and pirg lookups, writes, membership changes and
+// audit records the users and pirgs handlers make, so they can be run
+// against something other than Postgres. PostgresStore is the one the
+// server uses, and MemoryStore keeps everything in memory for tests that
+// shouldn't need a database.
+//
+// Bulk creates and updates, searches and pages, reconciles, snapshots,
+// history, transfers and the reporting endpoints aren't part of it, those
+// handlers still use the Postgres connection directly.
+type Store interface {
+	GetUserById(id int) (*User, error)
+	GetUserByIdIncludingDeleted(id int) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	GetAllUsers(includeDeleted bool) ([]*User, error)
+	CreateUser(user *UserRequest) (*User, error)
+	CreateUserInPirg(user *UserRequest, pirgName string) (*User, error)
+	UpdateUser(userId int, user *UserRequest, expectedVersion int) error
+	UpdateUserFields(userId int, fields map[string]any, expectedVersion int) error
+	DeleteUser(id int, dryRun bool) error
+	RestoreUser(id int) error
+	TouchUserLogin(id int) error
+	VerifyUserEmail(id int) error
+
+	GetPirgById(id int) (*Pirg, error)
+	GetPirgByName(name string) (*Pirg, error)
+	GetAllPirgs() ([]*Pirg, error)
+	CreatePirg(pirg *PirgRequest) (*Pirg, error)
+	UpdatePirg(id int, pirg *PirgRequest, expectedVersion int) (*Pirg, error)
+	UpsertPirg(pirg *PirgRequest) (*Pirg, bool, error)
+	DeletePirg(id int) error
+	IsPirgAdmin(pirgId int, userId int) (bool, error)
+	AddPirgMember(pirgId int, userId int, expiresAt *time.Time) (*PirgMember, bool, error)
+	RemovePirgMember(pirgId int, userId int) (bool, error)
+	SetPirgAdmin(pirgId int, userId int) (bool, error)
+	RemovePirgAdmin(pirgId int, userId int) (bool, error)
+
+	RecordAudit(actor string, action string, resourceType string, resourceId string, details any) error
+	RecordPirgMembershipChanges(actor string, pirgId int, before []int, after []int) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// PostgresStore is the Store of a Postgres database, each method calls the
+// package function of the same name with the connection
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) GetUserById(id int) (*User, error) {
+	return GetUserById(s.db, id)
+}
+
+func (s *PostgresStore) GetUserByIdIncludingDeleted(id int) (*User, error) {
+	return GetUserByIdIncludingDeleted(s.db, id)
+}
+
+func (s *PostgresStore) GetUserByUsername(username string) (*User, error) {
+	return GetUserByUsername(s.db, username)
+}
+
+func (s *PostgresStore) GetUserByEmail(email string) (*User, error) {
+	return GetUserByEmail(s.db, email)
+}
+
+func (s *PostgresStore) GetAllUsers(includeDeleted bool) ([]*User, error) {
+	return GetAllUsers(s.db, includeDeleted)
+}
+
+func (s *PostgresStore) CreateUser(user *UserRequest) (*User, error) {
+	return CreateUser(s.db, user)
+}
+
+func (s *PostgresStore) CreateUserInPirg(user *UserRequest, pirgName string) (*User, error) {
+	return CreateUserInPirg(s.db, user, pirgName)
+}
+
+func (s *PostgresStore) UpdateUser(userId int, user *UserRequest, expectedVersion int) error {
+	return UpdateUser(s.db, userId, user, expectedVersion)
+}
+
+func (s *PostgresStore) UpdateUserFields(userId int, fields map[string]any, expectedVersion int) error {
+	return UpdateUserFields(s.db, userId, fields, expectedVersion)
+}
+
+func (s *PostgresStore) DeleteUser(id int, dryRun bool) error {
+	return DeleteUser(s.db, id, dryRun)
+}
+
+func (s *PostgresStore) RestoreUser(id int) error {
+	return RestoreUser(s.db, id)
+}
+
+func (s *PostgresStore) TouchUserLogin(id int) error {
+	return TouchUserLogin(s.db, id)
+}
+
+func (s *PostgresStore) VerifyUserEmail(id int) error {
+	return VerifyUserEmail(s.db, id)
+}
+
+func (s *PostgresStore) GetPirgById(id int) (*Pirg, error) {
+	return GetPirgById(s.db, id)
+}
+
+func (s *PostgresStore) GetPirgByName(name string) (*Pirg, error) {
+	return GetPirgByName(s.db, name)
+}
+
+func (s *PostgresStore) GetAllPirgs() ([]*Pirg, error) {
+	return GetAllPirgs(s.db)
+}
+
+func (s *PostgresStore) CreatePirg(pirg *PirgRequest) (*Pirg, error) {
+	return CreatePirg(s.db, pirg)
+}
+
+func (s *PostgresStore) UpdatePirg(id int, pirg *PirgRequest, expectedVersion int) (*Pirg, error) {
+	return UpdatePirg(s.db, id, pirg, expectedVersion)
+}
+
+func (s *PostgresStore) UpsertPirg(pirg *PirgRequest) (*Pirg, bool, error) {
+	return UpsertPirg(s.db, pirg)
+}
+
+func (s *PostgresStore) DeletePirg(id int) error {
+	return DeletePirg(s.db, id)
+}
+
+func (s *PostgresStore) IsPirgAdmin(pirgId int, userId int) (bool, error) {
+	return IsPirgAdmin(s.db, pirgId, userId)
+}
+
+func (s *PostgresStore) AddPirgMember(pirgId int, userId int, expiresAt *time.Time) (*PirgMember, bool, error) {
+	return AddPirgMember(s.db, pirgId, userId, expiresAt)
+}
+
+func (s *PostgresStore) RemovePirgMember(pirgId int, userId int) (bool, error) {
+	return RemovePirgMember(s.db, pirgId, userId)
+}
+
+func (s *PostgresStore) SetPirgAdmin(pirgId int, userId int) (bool, error) {
+	return SetPirgAdmin(s.db, pirgId, userId)
+}
+
+func (s *PostgresStore) RemovePirgAdmin(pirgId int, userId int) (bool, error) {
+	return RemovePirgAdmin(s.db, pirgId, userId)
+}
+
+func (s *PostgresStore) RecordAudit(actor string, action string, resourceType string, resourceId string, details any) error {
+	return RecordAudit(s.db, actor, action, resourceType, resourceId, details)
+}
+
+func (s *PostgresStore) RecordPirgMembershipChanges(actor string, pirgId int, before []int, after []int) error {
+	return RecordPirgMembershipChanges(s.db, actor, pirgId, before, after)
+}
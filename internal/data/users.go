@@ -1,12 +1,18 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type User struct {
@@ -17,6 +23,13 @@ type User struct {
 	LastName   string
 	CreatedAt  time.Time
 	ModifiedAt time.Time
+	// DeletedAt is set once the user is deleted. Deleted users are kept so
+	// what references them stays intact, but are left out of lookups.
+	DeletedAt *time.Time
+	// LastLoginAt is when the user last logged in, if they ever have
+	LastLoginAt *time.Time
+	// Version counts the updates of the user's fields
+	Version int
 }
 
 type UserRequest struct {
@@ -26,17 +39,18 @@ type UserRequest struct {
 	LastName  string
 }
 
-func GetAllUsers(db *sql.DB) ([]*User, error) {
+// GetAllUsers returns every user, and with includeDeleted the deleted ones too
+func GetAllUsers(db *sql.DB, includeDeleted bool) ([]*User, error) {
 	slog.Debug("getting all users from database", "package", "data", "method", "GetAllUsers")
 	var users []*User
-	rows, err := db.Query("SELECT id, username, email, firstname, lastname, created_at, modified_at FROM users")
+	rows, err := db.Query("SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version FROM users WHERE $1 OR deleted_at IS NULL", includeDeleted)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var user User
-		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt)
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -45,34 +59,778 @@ func GetAllUsers(db *sql.DB) ([]*User, error) {
 	return users, nil
 }
 
+// StreamUsers calls fn for each user, ordered by id. Rows are read one at a
+// time so every user is never held in memory. Deleted users are only included
+// with includeDeleted. Iteration stops at the first error returned by fn.
+func StreamUsers(db *sql.DB, includeDeleted bool, fn func(*User) error) error {
+	slog.Debug("streaming users from database", "include_deleted", includeDeleted, "package", "data", "method", "StreamUsers")
+	rows, err := db.Query(`
+		SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version
+		FROM users
+		WHERE $1 OR deleted_at IS NULL
+		ORDER BY id`, includeDeleted)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+		if err != nil {
+			return err
+		}
+		if err = fn(&user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetUsersAfter returns up to limit users with an id greater than afterId,
+// ordered by id, for paging through users with a cursor. Deleted users are
+// only included with includeDeleted.
+func GetUsersAfter(db *sql.DB, afterId int, limit int, includeDeleted bool) ([]*User, error) {
+	slog.Debug("getting users after id from database", "after_id", afterId, "limit", limit, "package", "data", "method", "GetUsersAfter")
+	rows, err := db.Query(`
+		SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version
+		FROM users
+		WHERE id > $1 AND ($3 OR deleted_at IS NULL)
+		ORDER BY id
+		LIMIT $2`, afterId, limit, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// UserFilter narrows a user listing. All set fields must match.
+type UserFilter struct {
+	Username string
+	// Usernames matches a user with any of the usernames
+	Usernames []string
+	// Attributes maps user_attributes keys to the value they must have
+	Attributes map[string]string
+	// AttributesIn maps user_attributes keys to values, one of which they must have
+	AttributesIn map[string][]string
+	// Query matches users whose username or email contains it, ignoring case
+	Query string
+	// HasPirg matches users that are, or if false aren't, a member of any pirg
+	HasPirg *bool
+	// InactiveSince matches users who haven't logged in since then, or ever
+	InactiveSince *time.Time
+	// IncludeDeleted matches deleted users too, it isn't a condition
+	IncludeDeleted bool
+}
+
+// IsEmpty reports whether the filter has no conditions, and so matches every user
+func (f UserFilter) IsEmpty() bool {
+	return f.Username == "" && len(f.Usernames) == 0 && len(f.Attributes) == 0 && len(f.AttributesIn) == 0 &&
+		f.Query == "" && f.HasPirg == nil && f.InactiveSince == nil
+}
+
+// UserSortFields are the fields FindUsersPage can sort by
+var UserSortFields = []string{"username", "created_at", "last_login_at"}
+
+// userSortColumns maps the UserSortFields to their columns
+var userSortColumns = map[string]string{
+	"username":      "u.username",
+	"created_at":    "u.created_at",
+	"last_login_at": "u.last_login_at",
+}
+
+// FindUsersPage returns a page of the users matching the filter sorted by
+// sortBy, one of UserSortFields, along with the total number matching.
+// Users that sort the same are ordered by id.
+func FindUsersPage(db *sql.DB, filter UserFilter, sortBy string, desc bool, limit int, offset int) ([]*User, int, error) {
+	slog.Debug("finding page of users in database", "sort", sortBy, "desc", desc, "package", "data", "method", "FindUsersPage")
+	column, ok := userSortColumns[sortBy]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown user sort field: %s", sortBy)
+	}
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+	from, args := userFilterClause(filter)
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, limit, offset)
+	query := fmt.Sprintf("SELECT u.id, u.username, u.email, u.firstname, u.lastname, u.created_at, u.modified_at, u.deleted_at, u.last_login_at, u.version%s ORDER BY %s %s, u.id %s LIMIT $%d OFFSET $%d",
+		from, column, order, order, len(args)-1, len(args))
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// likeEscaper escapes the ILIKE wildcards so a query matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ErrEmptyUserFilter is returned by bulk changes that would otherwise apply to every user
+var ErrEmptyUserFilter = errors.New("filter must have at least one condition")
+
+// FindUsers returns the users matching every condition in the filter
+func FindUsers(db *sql.DB, filter UserFilter) ([]*User, error) {
+	slog.Debug("finding users in database", "package", "data", "method", "FindUsers")
+	from, args := userFilterClause(filter)
+	query := "SELECT u.id, u.username, u.email, u.firstname, u.lastname, u.created_at, u.modified_at, u.deleted_at, u.last_login_at, u.version" + from + " ORDER BY u.id"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// userFilterClause returns the FROM and WHERE clauses selecting the users u
+// matching the filter, and their parameters
+func userFilterClause(filter UserFilter) (string, []any) {
+	clause := " FROM users u"
+	var args []any
+	// sorted so the generated query is stable for the same filter
+	attrKeys := make([]string, 0, len(filter.Attributes))
+	for k := range filter.Attributes {
+		attrKeys = append(attrKeys, k)
+	}
+	slices.Sort(attrKeys)
+	for i, k := range attrKeys {
+		args = append(args, k, filter.Attributes[k])
+		clause += fmt.Sprintf(" JOIN user_attributes a%d ON a%d.user_id = u.id AND a%d.key = $%d AND a%d.value = $%d",
+			i, i, i, len(args)-1, i, len(args))
+	}
+	inKeys := make([]string, 0, len(filter.AttributesIn))
+	for k := range filter.AttributesIn {
+		inKeys = append(inKeys, k)
+	}
+	slices.Sort(inKeys)
+	for i, k := range inKeys {
+		args = append(args, k, pq.Array(filter.AttributesIn[k]))
+		clause += fmt.Sprintf(" JOIN user_attributes ai%d ON ai%d.user_id = u.id AND ai%d.key = $%d AND ai%d.value = ANY($%d)",
+			i, i, i, len(args)-1, i, len(args))
+	}
+	var where []string
+	if !filter.IncludeDeleted {
+		where = append(where, "u.deleted_at IS NULL")
+	}
+	// usernames match case insensitively, like GetUserByUsername
+	if filter.Username != "" {
+		args = append(args, NormalizeUsername(filter.Username))
+		where = append(where, fmt.Sprintf("lower(u.username) = $%d", len(args)))
+	}
+	if len(filter.Usernames) > 0 {
+		usernames := make([]string, len(filter.Usernames))
+		for i, username := range filter.Usernames {
+			usernames[i] = NormalizeUsername(username)
+		}
+		args = append(args, pq.Array(usernames))
+		where = append(where, fmt.Sprintf("lower(u.username) = ANY($%d)", len(args)))
+	}
+	if filter.Query != "" {
+		args = append(args, "%"+likeEscaper.Replace(filter.Query)+"%")
+		where = append(where, fmt.Sprintf("(u.username ILIKE $%d OR u.email ILIKE $%d)", len(args), len(args)))
+	}
+	if filter.HasPirg != nil {
+		exists := "EXISTS (SELECT 1 FROM active_pirgs_users pu WHERE pu.user_id = u.id)"
+		if !*filter.HasPirg {
+			exists = "NOT " + exists
+		}
+		where = append(where, exists)
+	}
+	if filter.InactiveSince != nil {
+		args = append(args, filter.InactiveSince.UTC())
+		where = append(where, fmt.Sprintf("(u.last_login_at IS NULL OR u.last_login_at < $%d)", len(args)))
+	}
+	if len(where) > 0 {
+		clause += " WHERE " + strings.Join(where, " AND ")
+	}
+	return clause, args
+}
+
+// SetUserAttributesBulk sets the attributes on every user matching the filter,
+// replacing any existing values, in one transaction. The filter is evaluated
+// once, before any attribute is set. Returns the ids of the users matched.
+// With dryRun the transaction is rolled back.
+func SetUserAttributesBulk(db *sql.DB, filter UserFilter, attributes map[string]string, dryRun bool) ([]int, error) {
+	slog.Debug("setting user attributes in bulk in database", "dry_run", dryRun, "package", "data", "method", "SetUserAttributesBulk")
+	if filter.IsEmpty() {
+		return nil, ErrEmptyUserFilter
+	}
+	var userIds pq.Int64Array
+	err := WithTx(txContext(dryRun), db, func(tx *sql.Tx) error {
+		from, args := userFilterClause(filter)
+		err := tx.QueryRow("SELECT COALESCE(array_agg(u.id ORDER BY u.id), '{}')"+from, args...).Scan(&userIds)
+		if err != nil {
+			return err
+		}
+		if len(userIds) == 0 {
+			return nil
+		}
+		for k, v := range attributes {
+			_, err = tx.Exec(`
+				INSERT INTO user_attributes (user_id, key, value) SELECT unnest($1::int[]), $2, $3
+				ON CONFLICT (user_id, key) DO UPDATE SET value = EXCLUDED.value`, userIds, k, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(userIds))
+	for _, id := range userIds {
+		ids = append(ids, int(id))
+	}
+	return ids, nil
+}
+
+// SetUserAttribute sets the value of an attribute on a user, replacing any existing value
+func SetUserAttribute(db *sql.DB, userId int, key string, value string) error {
+	slog.Debug("setting user attribute in database", "key", key, "package", "data", "method", "SetUserAttribute")
+	_, err := db.Exec(`
+		INSERT INTO user_attributes (user_id, key, value) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO UPDATE SET value = EXCLUDED.value`, userId, key, value)
+	return err
+}
+
+// GetUserById looks up a user by id. sql.ErrNoRows is returned if they
+// don't exist or are deleted.
 func GetUserById(db *sql.DB, id int) (*User, error) {
 	slog.Debug("querying database for user by id", "package", "data", "method", "GetUserById")
 	var user User
-	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at FROM users WHERE id = $1", id).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt)
+	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version FROM users WHERE id = $1 AND deleted_at IS NULL", id).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+	return &user, err
+}
+
+// GetUserByIdIncludingDeleted looks up a user by id whether or not they're deleted
+func GetUserByIdIncludingDeleted(db *sql.DB, id int) (*User, error) {
+	slog.Debug("querying database for user by id including deleted", "package", "data", "method", "GetUserByIdIncludingDeleted")
+	var user User
+	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version FROM users WHERE id = $1", id).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
 	return &user, err
 }
 
+// GetUserByUsername looks up the user by username, ignoring case like the
+// users username index does
 func GetUserByUsername(db *sql.DB, username string) (*User, error) {
 	slog.Debug("querying database for user by username", "package", "data", "method", "GetUserByUsername")
 	var user User
-	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at FROM users WHERE username = $1", username).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt)
+	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version FROM users WHERE lower(username) = $1 AND deleted_at IS NULL", NormalizeUsername(username)).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+	return &user, err
+}
+
+// GetUserByUid looks up the user the posix uid is allocated to.
+// sql.ErrNoRows is returned if the uid isn't allocated.
+func GetUserByUid(db *sql.DB, uid int) (*User, error) {
+	slog.Debug("querying database for user by uid", "uid", uid, "package", "data", "method", "GetUserByUid")
+	var user User
+	err := db.QueryRow(`
+		SELECT u.id, u.username, u.email, u.firstname, u.lastname, u.created_at, u.modified_at, u.deleted_at, u.last_login_at, u.version
+		FROM posix_ids p JOIN users u ON u.id = p.resource_id
+		WHERE p.kind = $1 AND p.value = $2 AND u.deleted_at IS NULL`, PosixIdKindUid, uid).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
 	return &user, err
 }
 
+// GetUserByEmail looks up a user by the normalized form of their email,
+// see NormalizeEmail
+func GetUserByEmail(db *sql.DB, email string) (*User, error) {
+	slog.Debug("querying database for user by email", "package", "data", "method", "GetUserByEmail")
+	var user User
+	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version FROM users WHERE lower(email) = $1 AND deleted_at IS NULL", email).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt, &user.LastLoginAt, &user.Version)
+	return &user, err
+}
+
+// NormalizeEmail returns the canonical form of an email, trimmed and lowercased
+// the same as the users email index. With stripPlusTags a +tag in the local
+// part is removed too, so Foo+tag@Example.com becomes foo@example.com.
+func NormalizeEmail(email string, stripPlusTags bool) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !stripPlusTags {
+		return email
+	}
+	local, domain, found := strings.Cut(email, "@")
+	if !found {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return local + "@" + domain
+}
+
+// UserEmailChange is a user whose stored email isn't in the normalized form
+type UserEmailChange struct {
+	UserId   int
+	Username string
+	From     string
+	To       string
+}
+
+// UserEmailNormalizeResult lists the users whose emails were normalized.
+// Conflicts would have the same email as another user once normalized, so
+// they're left as they are for an admin to sort out.
+type UserEmailNormalizeResult struct {
+	Changed   []*UserEmailChange
+	Conflicts []*UserEmailChange
+	DryRun    bool
+}
+
+// NormalizeUserEmails rewrites every stored email in the form NormalizeEmail
+// gives, for after the normalization policy changes. It's one transaction with
+// the users locked, and on a dry run the changes are rolled back.
+// Users are ordered by id.
+func NormalizeUserEmails(db *sql.DB, stripPlusTags bool, dryRun bool) (*UserEmailNormalizeResult, error) {
+	slog.Debug("normalizing user emails in database", "strip_plus_tags", stripPlusTags, "dry_run", dryRun, "package", "data", "method", "NormalizeUserEmails")
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id, username, email FROM users ORDER BY id FOR UPDATE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []*UserEmailChange
+	// every user's email once normalized, changed or not, to find collisions
+	owners := map[string]int{}
+	for rows.Next() {
+		var c UserEmailChange
+		if err := rows.Scan(&c.UserId, &c.Username, &c.From); err != nil {
+			return nil, err
+		}
+		c.To = NormalizeEmail(c.From, stripPlusTags)
+		owners[c.To]++
+		all = append(all, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	result := &UserEmailNormalizeResult{Changed: []*UserEmailChange{}, Conflicts: []*UserEmailChange{}, DryRun: dryRun}
+	for _, c := range all {
+		if c.From == c.To {
+			continue
+		}
+		if owners[c.To] > 1 {
+			result.Conflicts = append(result.Conflicts, c)
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET email = $1 WHERE id = $2", c.To, c.UserId); err != nil {
+			return nil, err
+		}
+		result.Changed = append(result.Changed, c)
+	}
+	if dryRun {
+		return result, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func CreateUser(db *sql.DB, user *UserRequest) (*User, error) {
 	slog.Debug("creating new user in database", "package", "data", "method", "CreateUser")
+	if err := checkUserExists(db, user); err != nil {
+		return nil, err
+	}
+	return insertUser(db, user)
+}
+
+// ErrDeletedUserExists is returned when creating a user whose username or
+// email belongs to a deleted user, who should be restored instead
+var ErrDeletedUserExists = errors.New("deleted user exists, restore it")
+
+// checkUserExists returns an error if the username or email is already taken.
+// Deleted users still hold theirs, the error for one wraps ErrDeletedUserExists.
+func checkUserExists(db *sql.DB, user *UserRequest) error {
+	checks := []struct {
+		field string
+		value string
+		query string
+	}{
+		{"username", NormalizeUsername(user.Username), "SELECT id, deleted_at IS NOT NULL FROM users WHERE lower(username) = $1"},
+		{"email", strings.ToLower(user.Email), "SELECT id, deleted_at IS NOT NULL FROM users WHERE lower(email) = $1"},
+	}
+	for _, c := range checks {
+		var id int
+		var deleted bool
+		err := db.QueryRow(c.query, c.value).Scan(&id, &deleted)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if deleted {
+			return fmt.Errorf("%w: deleted user %d has %s %s, restore them instead", ErrDeletedUserExists, id, c.field, c.value)
+		}
+		return fmt.Errorf("user with %s %s already exists", c.field, c.value)
+	}
+	return nil
+}
+
+// CreateUserInPirg creates a new user and adds them as a member of the named pirg
+// in the same transaction, so the user is never created without the membership
+func CreateUserInPirg(db *sql.DB, user *UserRequest, pirgName string) (*User, error) {
+	slog.Debug("creating new user in database with default pirg", "pirg", pirgName, "package", "data", "method", "CreateUserInPirg")
+	if err := checkUserExists(db, user); err != nil {
+		return nil, err
+	}
+	var newUser *User
+	err := WithTx(context.Background(), db, func(tx *sql.Tx) error {
+		var err error
+		newUser, err = insertUser(tx, user)
+		if err != nil {
+			return err
+		}
+		var pirgId int
+		err = tx.QueryRow("SELECT id FROM pirgs WHERE name = $1", pirgName).Scan(&pirgId)
+		if err != nil {
+			return fmt.Errorf("failed to look up default pirg %s: %v", pirgName, err)
+		}
+		return addPirgUser(tx, pirgId, newUser.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newUser, nil
+}
+
+// ErrUserExists is the error for a user whose username or email is taken
+var ErrUserExists = errors.New("user already exists")
+
+// BulkUserError is why users in a batch couldn't be created, by their index
+// in the batch. An invalid user's error is a *ValidationError, and one whose
+// username or email is taken, by an existing user or an earlier one in the
+// batch, wraps ErrUserExists.
+type BulkUserError struct {
+	Errors map[int]error
+}
+
+func (e *BulkUserError) Error() string {
+	return fmt.Sprintf("%d of the users can't be created", len(e.Errors))
+}
+
+// CreateUsers creates the users in one transaction, and adds each as a member
+// of the named pirg if pirgName isn't empty. Either every user is created or
+// none are. Users that can't be created are returned in a *BulkUserError.
+// With dryRun the transaction is rolled back, the returned users' ids were
+// only taken from the sequence for the dry run.
+func CreateUsers(db *sql.DB, users []*UserRequest, pirgName string, dryRun bool) ([]*User, error) {
+	slog.Debug("creating users in database in bulk", "count", len(users), "pirg", pirgName, "dry_run", dryRun, "package", "data", "method", "CreateUsers")
+	errs := map[int]error{}
+	usernames := map[string]int{}
+	emails := map[string]int{}
+	for i, user := range users {
+		if err := ValidateUser(user); err != nil {
+			errs[i] = err
+			continue
+		}
+		email := strings.ToLower(user.Email)
+		if j, ok := usernames[user.Username]; ok {
+			errs[i] = fmt.Errorf("%w: username %s is also user %d of the batch", ErrUserExists, user.Username, j)
+			continue
+		}
+		if j, ok := emails[email]; ok {
+			errs[i] = fmt.Errorf("%w: email %s is also user %d of the batch", ErrUserExists, user.Email, j)
+			continue
+		}
+		usernames[user.Username] = i
+		emails[email] = i
+	}
+	// deleted users still hold their username and email
+	usernameList := make([]string, 0, len(usernames))
+	for username := range usernames {
+		usernameList = append(usernameList, username)
+	}
+	emailList := make([]string, 0, len(emails))
+	for email := range emails {
+		emailList = append(emailList, email)
+	}
+	rows, err := db.Query("SELECT username, lower(email) FROM users WHERE username = ANY($1) OR lower(email) = ANY($2)", pq.Array(usernameList), pq.Array(emailList))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username, email string
+		if err := rows.Scan(&username, &email); err != nil {
+			return nil, err
+		}
+		if i, ok := usernames[username]; ok {
+			errs[i] = fmt.Errorf("%w: user with username %s already exists", ErrUserExists, username)
+		}
+		if i, ok := emails[email]; ok && errs[i] == nil {
+			errs[i] = fmt.Errorf("%w: user with email %s already exists", ErrUserExists, email)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, &BulkUserError{Errors: errs}
+	}
+
+	created := make([]*User, 0, len(users))
+	err = WithTx(txContext(dryRun), db, func(tx *sql.Tx) error {
+		var pirgId int
+		if pirgName != "" {
+			err := tx.QueryRow("SELECT id FROM pirgs WHERE name = $1", pirgName).Scan(&pirgId)
+			if err != nil {
+				return fmt.Errorf("failed to look up default pirg %s: %v", pirgName, err)
+			}
+		}
+		for _, user := range users {
+			newUser, err := insertUser(tx, user)
+			if err != nil {
+				return fmt.Errorf("failed to create user %s: %w", user.Username, err)
+			}
+			if pirgId != 0 {
+				if err = addPirgUser(tx, pirgId, newUser.Id); err != nil {
+					return err
+				}
+			}
+			created = append(created, newUser)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func insertUser(q querier, user *UserRequest) (*User, error) {
 	var newUser User
-	_, err := GetUserByUsername(db, user.Username)
-	if err == nil {
-		return nil, fmt.Errorf("user with username %s already exists", user.Username)
+	err := q.QueryRow("INSERT INTO users (username, email, firstname, lastname) VALUES ($1, $2, $3, $4) RETURNING id, username, email, firstname, lastname, created_at, modified_at, version", user.Username, user.Email, user.FirstName, user.LastName).Scan(&newUser.Id, &newUser.Username, &newUser.Email, &newUser.FirstName, &newUser.LastName, &newUser.CreatedAt, &newUser.ModifiedAt, &newUser.Version)
+	if err != nil {
+		return nil, err
 	}
-	err = db.QueryRow("INSERT INTO users (username, email, firstname, lastname) VALUES ($1, $2, $3, $4) RETURNING id, username, email, firstname, lastname, created_at, modified_at", user.Username, user.Email, user.FirstName, user.LastName).Scan(&newUser.Id, &newUser.Username, &newUser.Email, &newUser.FirstName, &newUser.LastName, &newUser.CreatedAt, &newUser.ModifiedAt)
-	return &newUser, err
+	return &newUser, nil
 }
 
-func UpdateUser(db *sql.DB, userId int, user *UserRequest) error {
+// UpdateUser sets the user's fields. With a nonzero expectedVersion the
+// update only happens if the user is still at that version, otherwise
+// ErrVersionConflict is returned.
+func UpdateUser(db *sql.DB, userId int, user *UserRequest, expectedVersion int) error {
 	slog.Debug("updating user in database", "package", "data", "method", "UpdateUser")
-	res, err := db.Exec("UPDATE users SET username = $1, email = $2, firstname = $3, lastname = $4 WHERE id = $5 RETURNING id, username, email, firstname, lastname, created_at, modified_at", user.Username, user.Email, user.FirstName, user.LastName, userId)
+	res, err := db.Exec("UPDATE users SET username = $1, email = $2, firstname = $3, lastname = $4 WHERE id = $5 AND "+versionCondition(6), user.Username, user.Email, user.FirstName, user.LastName, userId, expectedVersion)
+	return checkVersionedUpdate(db, "users", userId, expectedVersion, res, err)
+}
+
+// userFieldColumns are the columns UpdateUserFields sets, by field name
+var userFieldColumns = map[string]string{
+	"username":  "username",
+	"email":     "email",
+	"firstname": "firstname",
+	"lastname":  "lastname",
+}
+
+// UnknownUserFields returns the names in fields that aren't user fields
+// UpdateUserFields can set, sorted. id is ignored.
+func UnknownUserFields(fields map[string]any) []string {
+	unknown := []string{}
+	for name := range fields {
+		if _, ok := userFieldColumns[name]; !ok && name != "id" {
+			unknown = append(unknown, name)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// usernameRegexp matches the usernames users can have, which are also their
+// login names on the cluster
+var usernameRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// NormalizeUsername returns the canonical form of a username, trimmed and
+// lowercased the same as the users username index. Usernames are case
+// insensitive, so Jdoe and jdoe are the same user.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// FieldError is what's wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request, in field order
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := []string{}
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return "invalid fields: " + strings.Join(msgs, "; ")
+}
+
+// ValidateUser checks that every field of the user is set, the username is
+// 1 to 32 lowercase letters, digits, hyphens or underscores, and the email is
+// a bare address. It returns a *ValidationError listing every problem.
+func ValidateUser(user *UserRequest) error {
+	return validateUserFields([]string{"username", "email", "firstname", "lastname"}, map[string]any{
+		"username":  user.Username,
+		"email":     user.Email,
+		"firstname": user.FirstName,
+		"lastname":  user.LastName,
+	})
+}
+
+// ValidateUserFields checks the fields UpdateUserFields would set the same
+// way as ValidateUser, and that each one is a string. Unknown fields aren't
+// checked, see UnknownUserFields.
+func ValidateUserFields(fields map[string]any) error {
+	names := []string{}
+	for name := range fields {
+		if _, ok := userFieldColumns[name]; ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return validateUserFields(names, fields)
+}
+
+func validateUserFields(names []string, fields map[string]any) error {
+	errs := []FieldError{}
+	for _, name := range names {
+		s, ok := fields[name].(string)
+		if !ok {
+			errs = append(errs, FieldError{Field: name, Message: "must be a string"})
+			continue
+		}
+		if msg := validateUserField(name, s); msg != "" {
+			errs = append(errs, FieldError{Field: name, Message: msg})
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+func validateUserField(name string, value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "is required"
+	}
+	switch name {
+	case "username":
+		if !usernameRegexp.MatchString(value) {
+			return "must be 1 to 32 lowercase letters, digits, hyphens or underscores"
+		}
+	case "email":
+		// emails are trimmed when they're normalized, but a display name or
+		// angle brackets aren't part of the address
+		email := strings.TrimSpace(value)
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Name != "" || addr.Address != email {
+			return "must be a valid email address"
+		}
+	}
+	return ""
+}
+
+// UpdateUserFields sets only the given fields of the user, by name, and
+// leaves the other columns as they are. The id can't be changed, so an id
+// field is ignored. expectedVersion is checked like UpdateUser does.
+func UpdateUserFields(db *sql.DB, userId int, fields map[string]any, expectedVersion int) error {
+	slog.Debug("updating user fields in database", "id", userId, "package", "data", "method", "UpdateUserFields")
+	if unknown := UnknownUserFields(fields); len(unknown) > 0 {
+		return fmt.Errorf("unknown User fields: %s", strings.Join(unknown, ", "))
+	}
+	names := []string{}
+	for name := range fields {
+		if name != "id" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	// sorted so the same fields always build the same statement
+	slices.Sort(names)
+	sets := make([]string, 0, len(names))
+	args := make([]any, 0, len(names)+1)
+	for i, name := range names {
+		sets = append(sets, fmt.Sprintf("%s = $%d", userFieldColumns[name], i+1))
+		args = append(args, fields[name])
+	}
+	args = append(args, userId, expectedVersion)
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d AND %s", strings.Join(sets, ", "), len(args)-1, versionCondition(len(args)))
+	res, err := db.Exec(query, args...)
+	return checkVersionedUpdate(db, "users", userId, expectedVersion, res, err)
+}
+
+// ErrUserOwnsPirgs is returned when deleting a user who still owns pirgs
+var ErrUserOwnsPirgs = errors.New("user owns pirgs, transfer their ownership first")
+
+// DeleteUser soft deletes the user by setting their deleted_at, so their pirg
+// membership history stays intact. sql.ErrNoRows is returned if they don't
+// exist or are already deleted. With dryRun the delete is rolled back.
+func DeleteUser(db *sql.DB, id int, dryRun bool) error {
+	slog.Debug("deleting user from database", "dry_run", dryRun, "package", "data", "method", "DeleteUser")
+	return WithTx(txContext(dryRun), db, func(tx *sql.Tx) error {
+		var owned int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM pirgs WHERE owner_id = $1", id).Scan(&owned); err != nil {
+			return err
+		}
+		if owned > 0 {
+			return ErrUserOwnsPirgs
+		}
+		return softDeleteUser(tx, id)
+	})
+}
+
+func softDeleteUser(q querier, id int) error {
+	return checkUserUpdated(q.Exec("UPDATE users SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1 AND deleted_at IS NULL", id))
+}
+
+// checkUserUpdated returns sql.ErrNoRows if no user was updated
+func checkUserUpdated(res sql.Result, err error) error {
 	if err != nil {
 		return err
 	}
@@ -80,22 +838,161 @@ func UpdateUser(db *sql.DB, userId int, user *UserRequest) error {
 	if err != nil {
 		return err
 	}
-	if count != 1 {
-		return fmt.Errorf("expected to update 1 row, updated %d rows", count)
+	if count == 0 {
+		return sql.ErrNoRows
 	}
+	return nil
+}
 
-	return err
+// ErrVersionConflict is returned by updates given an expected version when
+// the record has been updated since, so it's no longer at that version
+var ErrVersionConflict = errors.New("the record was updated since the expected version")
+
+// versionCondition is the condition on the version column of an update
+// whose expected version is parameter n. An expected version of 0 matches
+// any version, for updates that don't check.
+func versionCondition(n int) string {
+	return fmt.Sprintf("($%d = 0 OR version = $%d)", n, n)
 }
 
-func DeleteUser(db *sql.DB, id int) error {
-	slog.Debug("deleting user from database", "package", "data", "method", "DeleteUser")
-	res, err := db.Exec("DELETE FROM users WHERE id = $1", id)
+// checkVersionedUpdate checks an update of the row id of table conditional
+// on versionCondition updated the one row. If none were, it's
+// ErrVersionConflict when the row still exists.
+func checkVersionedUpdate(q querier, table string, id int, expectedVersion int, res sql.Result, err error) error {
 	if err != nil {
 		return err
 	}
 	count, err := res.RowsAffected()
-	if err != nil || count != 1 {
+	if err != nil {
 		return err
 	}
+	if count == 0 && expectedVersion != 0 {
+		var exists bool
+		if err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = $1)", id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrVersionConflict
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("expected to update 1 row, updated %d rows", count)
+	}
 	return nil
 }
+
+// TouchUserLogin sets the user's last_login_at to now. It's a single update
+// by primary key, since the login integration calls it on every login.
+// sql.ErrNoRows is returned if they don't exist or are deleted.
+func TouchUserLogin(db *sql.DB, id int) error {
+	slog.Debug("touching user login in database", "user_id", id, "package", "data", "method", "TouchUserLogin")
+	return checkUserUpdated(db.Exec("UPDATE users SET last_login_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1 AND deleted_at IS NULL", id))
+}
+
+// RestoreUser clears the deleted_at of a deleted user. Restoring a user
+// who isn't deleted does nothing. sql.ErrNoRows is returned if they don't exist.
+func RestoreUser(db *sql.DB, id int) error {
+	slog.Debug("restoring user in database", "package", "data", "method", "RestoreUser")
+	return checkUserUpdated(db.Exec("UPDATE users SET deleted_at = NULL WHERE id = $1", id))
+}
+
+// ValidateOrphanedPirgOwner verifies that the user configured to take over
+// the pirgs of deleted users exists
+func ValidateOrphanedPirgOwner(db *sql.DB, username string) error {
+	slog.Debug("validating orphaned pirg owner", "username", username, "package", "data", "method", "ValidateOrphanedPirgOwner")
+	_, err := GetUserByUsername(db, username)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("orphaned pirg owner does not exist: %s", username)
+	}
+	return err
+}
+
+// DeleteUserReassigningPirgs soft deletes the user after transferring the pirgs
+// they own to newOwnerId, who becomes a member and an admin of each. The
+// transfers, their audit events, and the delete happen in one transaction,
+// which is rolled back with dryRun. Returns the transferred pirg ids.
+func DeleteUserReassigningPirgs(db *sql.DB, id int, newOwnerId int, actor string, dryRun bool) ([]int, error) {
+	slog.Debug("deleting user from database reassigning pirgs", "new_owner_id", newOwnerId, "dry_run", dryRun, "package", "data", "method", "DeleteUserReassigningPirgs")
+	if id == newOwnerId {
+		return nil, fmt.Errorf("the orphaned pirg owner can't be deleted")
+	}
+	var pirgIds []int
+	err := WithTx(txContext(dryRun), db, func(tx *sql.Tx) error {
+		var err error
+		if pirgIds, err = transferOwnedPirgs(tx, id, newOwnerId, actor); err != nil {
+			return err
+		}
+		return softDeleteUser(tx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pirgIds, nil
+}
+
+// PirgRef identifies a pirg without its membership
+type PirgRef struct {
+	Id   int
+	Name string
+}
+
+// UserDeleteImpact lists what depends on a user and would be affected by deleting them
+type UserDeleteImpact struct {
+	UserId      int
+	OwnedPirgs  []*PirgRef
+	AdminPirgs  []*PirgRef
+	MemberPirgs []*PirgRef
+	APIKeyCount int
+	// RequiresOwnershipTransfer is set when the user owns any pirg.
+	// Every pirg has exactly one owner, so each owned pirg needs a new
+	// owner before the user can be deleted.
+	RequiresOwnershipTransfer bool
+}
+
+// GetUserDeleteImpact looks up everything that references the user
+func GetUserDeleteImpact(db *sql.DB, id int) (*UserDeleteImpact, error) {
+	slog.Debug("getting user delete impact from database", "package", "data", "method", "GetUserDeleteImpact")
+	impact := &UserDeleteImpact{UserId: id}
+	var err error
+	impact.OwnedPirgs, err = getPirgRefs(db, "SELECT id, name FROM pirgs WHERE owner_id = $1 ORDER BY name", id)
+	if err != nil {
+		return nil, err
+	}
+	impact.AdminPirgs, err = getPirgRefs(db, `
+		SELECT p.id, p.name FROM pirgs p
+		JOIN pirgs_admins pa ON pa.pirg_id = p.id
+		WHERE pa.user_id = $1 ORDER BY p.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	impact.MemberPirgs, err = getPirgRefs(db, `
+		SELECT p.id, p.name FROM pirgs p
+		JOIN pirgs_users pu ON pu.pirg_id = p.id
+		WHERE pu.user_id = $1 ORDER BY p.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE user_id = $1", id).Scan(&impact.APIKeyCount)
+	if err != nil {
+		return nil, err
+	}
+	impact.RequiresOwnershipTransfer = len(impact.OwnedPirgs) > 0
+	return impact, nil
+}
+
+func getPirgRefs(db *sql.DB, query string, args ...any) ([]*PirgRef, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	refs := []*PirgRef{}
+	for rows.Next() {
+		var ref PirgRef
+		if err := rows.Scan(&ref.Id, &ref.Name); err != nil {
+			return nil, err
+		}
+		refs = append(refs, &ref)
+	}
+	return refs, rows.Err()
+}
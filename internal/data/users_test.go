@@ -2,7 +2,13 @@
 package data
 
 import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDataGetUserById(t *testing.T) {
@@ -85,7 +91,7 @@ func TestDataUpdateUser(t *testing.T) {
 		FirstName: "TestData2",
 		LastName:  "UpdateUser2",
 	}
-	err = UpdateUser(db, user.Id, &updatedUr)
+	err = UpdateUser(db, user.Id, &updatedUr, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -107,6 +113,230 @@ func TestDataUpdateUser(t *testing.T) {
 	}
 }
 
+func TestDataUpdateUserFields(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	user, err := CreateUser(db, &UserRequest{
+		Username:  "testdataupdateuserfields",
+		Email:     "testdataupdateuserfields@localhost",
+		FirstName: "TestData",
+		LastName:  "UpdateUserFields",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UpdateUserFields(db, user.Id, map[string]any{"id": user.Id + 1, "firstname": "Patched"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetUserById(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FirstName != "Patched" {
+		t.Errorf("expected first name Patched got %v", got.FirstName)
+	}
+	if got.Username != user.Username || got.Email != user.Email || got.LastName != user.LastName {
+		t.Errorf("expected untouched fields to keep their values got %+v", got)
+	}
+
+	err = UpdateUserFields(db, user.Id, map[string]any{"lastname": "Nope", "shell": "/bin/zsh", "admin": true}, 0)
+	if err == nil || !strings.Contains(err.Error(), "admin, shell") {
+		t.Fatalf("expected unknown fields admin, shell got %v", err)
+	}
+	if got, err = GetUserById(db, user.Id); err != nil || got.LastName != user.LastName {
+		t.Fatalf("expected nothing to change on unknown fields got %+v %v", got, err)
+	}
+	if err = UpdateUserFields(db, user.Id, map[string]any{}, 0); err != nil {
+		t.Errorf("expected updating no fields to succeed got %v", err)
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email         string
+		stripPlusTags bool
+		want          string
+	}{
+		{"Foo+tag@Example.com ", true, "foo@example.com"},
+		{"Foo+tag@Example.com ", false, "foo+tag@example.com"},
+		{"  foo@example.com", true, "foo@example.com"},
+		{"foo+a+b@example.com", true, "foo@example.com"},
+		{"not-an-email+tag", true, "not-an-email+tag"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.email, tt.stripPlusTags); got != tt.want {
+			t.Errorf("NormalizeEmail(%q, %v) = %q, want %q", tt.email, tt.stripPlusTags, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeUserEmails(t *testing.T) {
+	th := NewTestDataHandler()
+	// inserted directly, the api would normalize them
+	ids := map[string]int{}
+	for username, email := range map[string]string{
+		"testnormalizeemailsa": " TestNormalizeEmailsA@Localhost",
+		"testnormalizeemailsb": "testnormalizeemailsb+tag@localhost",
+		"testnormalizeemailsc": "testnormalizeemailsb@localhost",
+	} {
+		var id int
+		err := th.DB.QueryRow("INSERT INTO users (username, email, firstname, lastname) VALUES ($1, $2, 'Test', 'Normalize') RETURNING id", username, email).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[username] = id
+	}
+	find := func(changes []*UserEmailChange, userId int) *UserEmailChange {
+		for _, c := range changes {
+			if c.UserId == userId {
+				return c
+			}
+		}
+		return nil
+	}
+	emailOf := func(userId int) string {
+		user, err := GetUserById(th.DB, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Email
+	}
+
+	res, err := NormalizeUserEmails(th.DB, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.DryRun {
+		t.Errorf("expected a dry run")
+	}
+	if c := find(res.Changed, ids["testnormalizeemailsa"]); c == nil || c.To != "testnormalizeemailsa@localhost" {
+		t.Errorf("expected testnormalizeemailsa to be normalized got %+v", c)
+	}
+	if c := find(res.Conflicts, ids["testnormalizeemailsb"]); c == nil || find(res.Changed, ids["testnormalizeemailsb"]) != nil {
+		t.Errorf("expected testnormalizeemailsb to conflict with testnormalizeemailsc")
+	}
+	if find(res.Changed, ids["testnormalizeemailsc"]) != nil || find(res.Conflicts, ids["testnormalizeemailsc"]) != nil {
+		t.Errorf("expected the already normalized testnormalizeemailsc to be left out")
+	}
+	if email := emailOf(ids["testnormalizeemailsa"]); email != " TestNormalizeEmailsA@Localhost" {
+		t.Errorf("expected a dry run to change nothing got %q", email)
+	}
+
+	res, err = NormalizeUserEmails(th.DB, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DryRun || find(res.Changed, ids["testnormalizeemailsa"]) == nil {
+		t.Errorf("expected testnormalizeemailsa to be normalized got %+v", res)
+	}
+	if email := emailOf(ids["testnormalizeemailsa"]); email != "testnormalizeemailsa@localhost" {
+		t.Errorf("expected the normalized email to be stored got %q", email)
+	}
+	if email := emailOf(ids["testnormalizeemailsb"]); email != "testnormalizeemailsb+tag@localhost" {
+		t.Errorf("expected the +tag to be kept without strip_email_plus_tags got %q", email)
+	}
+}
+
+func TestDataGetUserByEmail(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	ur := UserRequest{
+		Username:  "testdatagetuserbyemail",
+		Email:     "testdatagetuserbyemail@example.com",
+		FirstName: "TestData",
+		LastName:  "GetUserByEmail",
+	}
+	user, err := CreateUser(db, &ur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := GetUserByEmail(db, NormalizeEmail("TestDataGetUserByEmail+tag@Example.com ", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Id != user.Id {
+		t.Fatalf("expected user %v got %v", user.Id, found.Id)
+	}
+
+	// the same email under another username is a duplicate
+	dup := ur
+	dup.Username = "testdatagetuserbyemail2"
+	if _, err := CreateUser(db, &dup); err == nil {
+		t.Fatal("expected error creating user with a duplicate email")
+	}
+}
+
+func TestSetUserAttributesBulk(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, username := range []string{"testbulkattra", "testbulkattrb", "testbulkattrc"} {
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "Test",
+			LastName:  "BulkAttributes",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	for _, user := range users[:2] {
+		if err := SetUserAttribute(db, user.Id, "testbulkdept", "physics"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// c already has a sponsor, which shouldn't change
+	if err := SetUserAttribute(db, users[2].Id, "testbulksponsor", "GrantY"); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := UserFilter{Attributes: map[string]string{"testbulkdept": "physics"}}
+	// a dry run finds the same users and sets nothing
+	userIds, err := SetUserAttributesBulk(db, filter, map[string]string{"testbulksponsor": "GrantX"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userIds) != 2 || userIds[0] != users[0].Id || userIds[1] != users[1].Id {
+		t.Fatalf("expected users a and b affected by the dry run got %v", userIds)
+	}
+	if sponsored, err := FindUsers(db, UserFilter{Attributes: map[string]string{"testbulksponsor": "GrantX"}}); err != nil || len(sponsored) != 0 {
+		t.Fatalf("expected the dry run to set no attributes got %+v, %v", sponsored, err)
+	}
+
+	userIds, err = SetUserAttributesBulk(db, filter, map[string]string{"testbulksponsor": "GrantX"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userIds) != 2 {
+		t.Fatalf("expected 2 users affected got %v", userIds)
+	}
+	sponsored, err := FindUsers(db, UserFilter{Attributes: map[string]string{"testbulksponsor": "GrantX"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sponsored) != 2 || sponsored[0].Id != users[0].Id || sponsored[1].Id != users[1].Id {
+		t.Fatalf("expected users a and b to be sponsored got %+v", sponsored)
+	}
+	unchanged, err := FindUsers(db, UserFilter{Attributes: map[string]string{"testbulksponsor": "GrantY"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unchanged) != 1 || unchanged[0].Id != users[2].Id {
+		t.Fatalf("expected user c to keep their sponsor got %+v", unchanged)
+	}
+
+	if _, err := SetUserAttributesBulk(db, UserFilter{}, map[string]string{"testbulksponsor": "GrantX"}, false); !errors.Is(err, ErrEmptyUserFilter) {
+		t.Fatalf("expected ErrEmptyUserFilter got %v", err)
+	}
+}
+
 func TestDataDeleteUser(t *testing.T) {
 	dh := NewTestDataHandler()
 	db := dh.DB
@@ -121,7 +351,13 @@ func TestDataDeleteUser(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = DeleteUser(db, user.Id)
+	if err = DeleteUser(db, user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetUserById(db, user.Id); err != nil {
+		t.Fatalf("expected a dry run to leave the user got %v", err)
+	}
+	err = DeleteUser(db, user.Id, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -129,6 +365,271 @@ func TestDataDeleteUser(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error getting deleted user")
 	}
+	if err = DeleteUser(db, user.Id, false); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows deleting a deleted user got %v", err)
+	}
+
+	// the row is kept, and only shows up when deleted users are asked for
+	deleted, err := GetUserByIdIncludingDeleted(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.DeletedAt == nil {
+		t.Errorf("expected deleted_at to be set")
+	}
+	listed := func(includeDeleted bool) bool {
+		users, err := GetAllUsers(db, includeDeleted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return slices.ContainsFunc(users, func(u *User) bool { return u.Id == user.Id })
+	}
+	if listed(false) || !listed(true) {
+		t.Errorf("expected the deleted user to only be listed with includeDeleted")
+	}
+	found, err := FindUsers(db, UserFilter{Username: ur.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("expected FindUsers to leave out the deleted user got %v", found)
+	}
+
+	// the deleted user still holds their username and email
+	for _, recreate := range []UserRequest{ur, {Username: "testdatadeleteuser2", Email: ur.Email}} {
+		if _, err := CreateUser(db, &recreate); !errors.Is(err, ErrDeletedUserExists) {
+			t.Errorf("expected ErrDeletedUserExists creating %+v got %v", recreate, err)
+		}
+	}
+
+	if err = RestoreUser(db, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := GetUserById(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.DeletedAt != nil {
+		t.Errorf("expected deleted_at to be cleared got %v", restored.DeletedAt)
+	}
+	if err = RestoreUser(db, 0); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows restoring a missing user got %v", err)
+	}
+}
+
+func TestDataDeleteUserLeavesPirgs(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	owner, err := CreateUser(db, &UserRequest{Username: "testdatadeletedmemberowner", Email: "testdatadeletedmemberowner@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := CreateUser(db, &UserRequest{Username: "testdatadeletedmember", Email: "testdatadeletedmember@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testdatadeletedmember",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id, member.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = DeleteUser(db, member.Id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.UserIds, []int{owner.Id}) || !slices.Equal(got.AdminIds, []int{owner.Id}) {
+		t.Errorf("expected the deleted user to be left out of the pirg got %+v", got)
+	}
+	members, total, err := GetPirgMembers(db, pirg.Id, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(members) != 1 || members[0].UserId != owner.Id {
+		t.Errorf("expected only the owner listed as a member got %d %+v", total, members)
+	}
+	if isAdmin, err := IsPirgAdmin(db, pirg.Id, member.Id); err != nil || isAdmin {
+		t.Errorf("expected the deleted user not to be a pirg admin got %v %v", isAdmin, err)
+	}
+	provisioning, err := GetPirgProvisioning(db, pirg.Id, PosixIdRange{Min: 74000, Max: 74099}, PosixIdRange{Min: 75000, Max: 75099})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provisioning.Members) != 1 {
+		t.Errorf("expected the deleted user to be left out of provisioning got %+v", provisioning.Members)
+	}
+
+	// the rows are kept, so restoring the user brings their membership back
+	if err = RestoreUser(db, member.Id); err != nil {
+		t.Fatal(err)
+	}
+	got, err = GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(got.UserIds, member.Id) || !slices.Contains(got.AdminIds, member.Id) {
+		t.Errorf("expected the restored user back in the pirg got %+v", got)
+	}
+}
+
+func TestDataDeleteUserOwningPirgs(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	newUser := func(username string) *User {
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "TestData",
+			LastName:  "DeleteUserOwningPirgs",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	owner := newUser("testdatadeleteowner")
+	fallback := newUser("testdatadeleteownerfallback")
+	pirg, err := CreatePirg(db, &PirgRequest{Name: "testdatadeleteowner", OwnerId: owner.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Unset", func(t *testing.T) {
+		if err := DeleteUser(db, owner.Id, false); !errors.Is(err, ErrUserOwnsPirgs) {
+			t.Fatalf("expected ErrUserOwnsPirgs got %v", err)
+		}
+		if _, err := GetUserById(db, owner.Id); err != nil {
+			t.Fatalf("expected the owner to still exist got %v", err)
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		if _, err := DeleteUserReassigningPirgs(db, fallback.Id, fallback.Id, "user:1", false); err == nil {
+			t.Fatal("expected error deleting the fallback owner")
+		}
+		pirgIds, err := DeleteUserReassigningPirgs(db, owner.Id, fallback.Id, "user:1", true)
+		if err != nil || len(pirgIds) != 1 || pirgIds[0] != pirg.Id {
+			t.Fatalf("expected the dry run to transfer the pirg got %v, %v", pirgIds, err)
+		}
+		if p, err := GetPirgById(db, pirg.Id); err != nil || p.OwnerId != owner.Id {
+			t.Fatalf("expected the dry run to keep the owner got %+v, %v", p, err)
+		}
+		pirgIds, err = DeleteUserReassigningPirgs(db, owner.Id, fallback.Id, "user:1", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(pirgIds, []int{pirg.Id}) {
+			t.Fatalf("expected pirg %v to be reassigned got %v", pirg.Id, pirgIds)
+		}
+		if _, err := GetUserById(db, owner.Id); err == nil {
+			t.Fatal("expected the owner to be deleted")
+		}
+		p, err := GetPirgById(db, pirg.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.OwnerId != fallback.Id {
+			t.Fatalf("expected owner %v got %v", fallback.Id, p.OwnerId)
+		}
+		if !slices.Equal(p.UserIds, []int{fallback.Id}) || !slices.Equal(p.AdminIds, []int{fallback.Id}) {
+			t.Fatalf("expected %v to be the only member and admin got members %v admins %v", fallback.Id, p.UserIds, p.AdminIds)
+		}
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE action = $1 AND resource_type = 'pirg' AND resource_id = $2 AND actor = 'user:1'",
+			AuditActionOwnerChanged, strconv.Itoa(pirg.Id)).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("expected 1 owner_changed event got %v", count)
+		}
+	})
+}
+
+func TestDataGetUserByUid(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	user, err := CreateUser(db, &UserRequest{
+		Username:  "testdatagetuserbyuid",
+		Email:     "testdatagetuserbyuid@localhost",
+		FirstName: "TestData",
+		LastName:  "GetUserByUid",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := AllocatePosixId(db, PosixIdKindUid, user.Id, PosixIdRange{Min: 72000, Max: 72999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := GetUserByUid(db, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Id != user.Id {
+		t.Fatalf("expected user %v got %v", user.Id, found.Id)
+	}
+	if _, err = GetUserByUid(db, 72999999); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows for an unallocated uid got %v", err)
+	}
+}
+
+func TestGetUsersAfter(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	seeded := map[int]bool{}
+	for i := 0; i < 7; i++ {
+		username := "testgetusersafter" + strconv.Itoa(i)
+		user, err := CreateUser(db, &UserRequest{
+			Username:  username,
+			Email:     username + "@localhost",
+			FirstName: "TestData",
+			LastName:  "GetUsersAfter",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeded[user.Id] = true
+	}
+
+	// page through every user, each one must come after the last
+	seen := map[int]bool{}
+	afterId := 0
+	for {
+		users, err := GetUsersAfter(db, afterId, 3, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) > 3 {
+			t.Fatalf("expected at most 3 users got %v", len(users))
+		}
+		if len(users) == 0 {
+			break
+		}
+		for _, u := range users {
+			if u.Id <= afterId || seen[u.Id] {
+				t.Fatalf("user %v returned out of order or twice", u.Id)
+			}
+			seen[u.Id] = true
+			afterId = u.Id
+		}
+	}
+	for id := range seeded {
+		if !seen[id] {
+			t.Errorf("expected seeded user %v to be returned", id)
+		}
+	}
 }
 
 func TestDataGetAllUsers(t *testing.T) {
@@ -145,7 +646,7 @@ func TestDataGetAllUsers(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	users, err := GetAllUsers(db)
+	users, err := GetAllUsers(db, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -153,3 +654,442 @@ func TestDataGetAllUsers(t *testing.T) {
 		t.Fatal("expected at least one user")
 	}
 }
+
+func TestDataCreateUserInPirg(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	owner, err := CreateUser(db, &UserRequest{
+		Username:  "testdatadefaultpirgowner",
+		Email:     "testdatadefaultpirgowner@localhost",
+		FirstName: "TestData",
+		LastName:  "DefaultPirgOwner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testdatadefaultpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := CreateUserInPirg(db, &UserRequest{
+		Username:  "testdatacreateuserinpirg",
+		Email:     "testdatacreateuserinpirg@localhost",
+		FirstName: "TestData",
+		LastName:  "CreateUserInPirg",
+	}, pirg.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err = GetPirgById(db, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(pirg.UserIds, user.Id) {
+		t.Fatalf("expected user %v to be a member of %v, got %v", user.Id, pirg.Name, pirg.UserIds)
+	}
+}
+
+func TestDataCreateUserInMissingPirg(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	ur := UserRequest{
+		Username:  "testdatacreateuserinmissingpirg",
+		Email:     "testdatacreateuserinmissingpirg@localhost",
+		FirstName: "TestData",
+		LastName:  "CreateUserInMissingPirg",
+	}
+	_, err := CreateUserInPirg(db, &ur, "testdatamissingpirg")
+	if err == nil {
+		t.Fatal("expected error creating user in a missing pirg")
+	}
+	// the transaction should have rolled back the user insert
+	_, err = GetUserByUsername(db, ur.Username)
+	if err == nil {
+		t.Fatal("expected user to not exist after failed default pirg enrollment")
+	}
+	if err = ValidateDefaultPirg(db, "testdatamissingpirg"); err == nil {
+		t.Fatal("expected validation error for a missing default pirg")
+	}
+}
+
+func TestDataGetUserByUsernameIgnoresCase(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	user, err := CreateUser(db, &UserRequest{
+		Username:  "testdatausernamecase",
+		Email:     "testdatausernamecase@localhost",
+		FirstName: "TestData",
+		LastName:  "UsernameCase",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"testdatausernamecase", "TestDataUsernameCase", " TESTDATAUSERNAMECASE "} {
+		got, err := GetUserByUsername(db, username)
+		if err != nil {
+			t.Fatalf("expected to find the user by %q got %v", username, err)
+		}
+		if got.Id != user.Id {
+			t.Errorf("expected %q to be user %d got %d", username, user.Id, got.Id)
+		}
+	}
+	// so do the filters of FindUsers and SetUserAttributesBulk
+	for _, filter := range []UserFilter{{Username: "TestDataUsernameCase"}, {Usernames: []string{"nobody", " TESTDATAUSERNAMECASE"}}} {
+		found, err := FindUsers(db, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].Id != user.Id {
+			t.Errorf("expected %+v to find user %d got %v", filter, user.Id, found)
+		}
+	}
+	// the index refuses usernames that only differ by case
+	_, err = db.Exec("INSERT INTO users (username, email, firstname, lastname) VALUES ('TestDataUsernameCase', 'testdatausernamecase2@localhost', 'TestData', 'UsernameCase')")
+	if err == nil {
+		t.Error("expected a username only differing by case to be refused")
+	}
+}
+
+func TestDataGetUserDeleteImpact(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	owner, err := CreateUser(db, &UserRequest{
+		Username:  "testdatadeleteimpactowner",
+		Email:     "testdatadeleteimpactowner@localhost",
+		FirstName: "TestData",
+		LastName:  "DeleteImpactOwner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := CreateUser(db, &UserRequest{
+		Username:  "testdatadeleteimpactmember",
+		Email:     "testdatadeleteimpactmember@localhost",
+		FirstName: "TestData",
+		LastName:  "DeleteImpactMember",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := CreatePirg(db, &PirgRequest{
+		Name:     "testdatadeleteimpact",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impact, err := GetUserDeleteImpact(db, owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !impact.RequiresOwnershipTransfer {
+		t.Error("expected owner to require ownership transfer")
+	}
+	if len(impact.OwnedPirgs) != 1 || impact.OwnedPirgs[0].Id != pirg.Id {
+		t.Errorf("expected owned pirg %v got %+v", pirg.Id, impact.OwnedPirgs)
+	}
+	if len(impact.AdminPirgs) != 1 || len(impact.MemberPirgs) != 1 {
+		t.Errorf("expected 1 admin and 1 member pirg got %v and %v", len(impact.AdminPirgs), len(impact.MemberPirgs))
+	}
+
+	impact, err = GetUserDeleteImpact(db, member.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if impact.RequiresOwnershipTransfer {
+		t.Error("expected plain member not to require ownership transfer")
+	}
+	if len(impact.OwnedPirgs) != 0 || len(impact.AdminPirgs) != 0 {
+		t.Errorf("expected no owned or admin pirgs got %+v and %+v", impact.OwnedPirgs, impact.AdminPirgs)
+	}
+	if len(impact.MemberPirgs) != 1 || impact.MemberPirgs[0].Name != pirg.Name {
+		t.Errorf("expected member of %v got %+v", pirg.Name, impact.MemberPirgs)
+	}
+}
+
+func TestDataFindUsersByAttribute(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	physicist, err := CreateUser(db, &UserRequest{
+		Username:  "testdatafindusersphysics",
+		Email:     "testdatafindusersphysics@localhost",
+		FirstName: "TestData",
+		LastName:  "Physics",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chemist, err := CreateUser(db, &UserRequest{
+		Username:  "testdatafinduserschemistry",
+		Email:     "testdatafinduserschemistry@localhost",
+		FirstName: "TestData",
+		LastName:  "Chemistry",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SetUserAttribute(db, physicist.Id, "testdept", "physics"); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetUserAttribute(db, physicist.Id, "testcampus", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetUserAttribute(db, chemist.Id, "testdept", "chemistry"); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := FindUsers(db, UserFilter{Attributes: map[string]string{"testdept": "physics"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != physicist.Id {
+		t.Fatalf("expected only user %v got %+v", physicist.Id, users)
+	}
+	// attributes combine with each other and with username
+	users, err = FindUsers(db, UserFilter{
+		Username:   physicist.Username,
+		Attributes: map[string]string{"testdept": "physics", "testcampus": "main"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("expected 1 user got %v", len(users))
+	}
+	users, err = FindUsers(db, UserFilter{Attributes: map[string]string{"testdept": "biology"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatalf("expected no users got %v", len(users))
+	}
+	// the In filters match any of their values
+	users, err = FindUsers(db, UserFilter{AttributesIn: map[string][]string{"testdept": {"physics", "chemistry", "biology"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Id != physicist.Id || users[1].Id != chemist.Id {
+		t.Fatalf("expected users %v and %v got %+v", physicist.Id, chemist.Id, users)
+	}
+	users, err = FindUsers(db, UserFilter{
+		Usernames:    []string{physicist.Username, chemist.Username},
+		AttributesIn: map[string][]string{"testcampus": {"main"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != physicist.Id {
+		t.Fatalf("expected only user %v got %+v", physicist.Id, users)
+	}
+}
+
+func TestValidateUser(t *testing.T) {
+	valid := &UserRequest{Username: "test-validate_user1", Email: " Test.Validate+hpc@Example.com ", FirstName: "Test", LastName: "Validate"}
+	if err := ValidateUser(valid); err != nil {
+		t.Fatalf("expected a valid user got %v", err)
+	}
+	err := ValidateUser(&UserRequest{Username: "Test User", Email: "test@", FirstName: "Test"})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error got %v", err)
+	}
+	want := []FieldError{
+		{Field: "username", Message: "must be 1 to 32 lowercase letters, digits, hyphens or underscores"},
+		{Field: "email", Message: "must be a valid email address"},
+		{Field: "lastname", Message: "is required"},
+	}
+	if !slices.Equal(invalid.Fields, want) {
+		t.Errorf("expected %+v got %+v", want, invalid.Fields)
+	}
+
+	// patches only check the fields they set
+	if err := ValidateUserFields(map[string]any{"lastname": "Patched", "shell": ""}); err != nil {
+		t.Errorf("expected a valid patch got %v", err)
+	}
+	err = ValidateUserFields(map[string]any{"username": "testvalidateuser", "firstname": 1, "email": ""})
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error got %v", err)
+	}
+	want = []FieldError{
+		{Field: "email", Message: "is required"},
+		{Field: "firstname", Message: "must be a string"},
+	}
+	if !slices.Equal(invalid.Fields, want) {
+		t.Errorf("expected %+v got %+v", want, invalid.Fields)
+	}
+}
+
+func TestCreateUsers(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	users := []*UserRequest{
+		{Username: "testcreateusersa", Email: "testcreateusersa@localhost", FirstName: "Test", LastName: "UsersA"},
+		{Username: "testcreateusersb", Email: "testcreateusersb@localhost", FirstName: "Test", LastName: "UsersB"},
+	}
+	created, err := CreateUsers(db, users, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || created[0].Username != users[0].Username || created[1].Username != users[1].Username {
+		t.Fatalf("expected both users created got %v", created)
+	}
+
+	batch := []*UserRequest{
+		{Username: "testcreatee", Email: "testcreatee@localhost", FirstName: "Test", LastName: "UsersC"},
+		{Username: "testcreatef", Email: "TestCreateUsersA@localhost", FirstName: "Test", LastName: "UsersD"},
+		{Username: "testcreatee", Email: "testcreateg@localhost", FirstName: "Test", LastName: "UsersE"},
+		{Username: "testcreateh", Email: "", FirstName: "Test", LastName: "UsersF"},
+	}
+	_, err = CreateUsers(db, batch, "", false)
+	var bulkErr *BulkUserError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("expected a BulkUserError got %v", err)
+	}
+	if bulkErr.Errors[0] != nil {
+		t.Errorf("expected the first user to be fine got %v", bulkErr.Errors[0])
+	}
+	// an existing email, a duplicate in the batch, and an invalid user
+	if !errors.Is(bulkErr.Errors[1], ErrUserExists) || !errors.Is(bulkErr.Errors[2], ErrUserExists) {
+		t.Errorf("expected users 1 and 2 to exist got %v", bulkErr.Errors)
+	}
+	var invalid *ValidationError
+	if !errors.As(bulkErr.Errors[3], &invalid) {
+		t.Errorf("expected user 3 to be invalid got %v", bulkErr.Errors[3])
+	}
+	if _, err := GetUserByUsername(db, "testcreatee"); err == nil {
+		t.Error("expected no user of the failed batch to be created")
+	}
+}
+
+func TestFindUsersPage(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, username := range []string{"testsearchb", "testsearcha", "testsearch_c"} {
+		user, err := CreateUser(db, &UserRequest{Username: username, Email: username + "@search.localhost", FirstName: "Test", LastName: "Search"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	_, err := CreatePirg(db, &PirgRequest{Name: "testsearchusers", OwnerId: users[0].Id, AdminIds: []int{users[0].Id}, UserIds: []int{users[0].Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	usernames := func(users []*User) []string {
+		names := []string{}
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+	yes, no := true, false
+
+	tests := []struct {
+		name   string
+		filter UserFilter
+		sortBy string
+		desc   bool
+		want   []string
+	}{
+		{"QueryUsername", UserFilter{Query: "TESTSEARCH"}, "username", false, []string{"testsearch_c", "testsearcha", "testsearchb"}},
+		{"QueryEmail", UserFilter{Query: "searcha@SEARCH"}, "username", false, []string{"testsearcha"}},
+		// _ is matched literally, not as any character
+		{"QueryWildcard", UserFilter{Query: "search_"}, "username", false, []string{"testsearch_c"}},
+		{"HasPirg", UserFilter{Query: "testsearch", HasPirg: &yes}, "username", false, []string{"testsearchb"}},
+		{"HasNoPirg", UserFilter{Query: "testsearch", HasPirg: &no}, "username", false, []string{"testsearch_c", "testsearcha"}},
+		{"SortDesc", UserFilter{Query: "testsearch"}, "username", true, []string{"testsearchb", "testsearcha", "testsearch_c"}},
+		{"SortCreatedAt", UserFilter{Query: "testsearch"}, "created_at", false, []string{"testsearchb", "testsearcha", "testsearch_c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, total, err := FindUsersPage(db, tt.filter, tt.sortBy, tt.desc, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := usernames(found); !slices.Equal(got, tt.want) || total != len(tt.want) {
+				t.Errorf("expected %v got %v, total %v", tt.want, got, total)
+			}
+		})
+	}
+
+	page, total, err := FindUsersPage(db, UserFilter{Query: "testsearch"}, "username", false, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || !slices.Equal(usernames(page), []string{"testsearcha"}) {
+		t.Errorf("expected the second user of 3 got %v of %v", usernames(page), total)
+	}
+	if _, _, err := FindUsersPage(db, UserFilter{}, "email; DROP TABLE users", false, 10, 0); err == nil {
+		t.Error("expected an unknown sort field to be an error")
+	}
+}
+
+func TestTouchUserLogin(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+	var users []*User
+	for _, username := range []string{"testtouchloginactive", "testtouchloginstale", "testtouchloginnever"} {
+		user, err := CreateUser(db, &UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Login"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	active, stale := users[0], users[1]
+	if users[2].LastLoginAt != nil {
+		t.Fatalf("expected a new user to have never logged in got %v", users[2].LastLoginAt)
+	}
+	before := time.Now().Add(-time.Minute)
+	for _, u := range []*User{active, stale} {
+		if err := TouchUserLogin(db, u.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := GetUserById(db, active.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastLoginAt == nil || got.LastLoginAt.Before(before) {
+		t.Fatalf("expected last_login_at after %v got %v", before, got.LastLoginAt)
+	}
+	_, err = db.Exec("UPDATE users SET last_login_at = '2001-01-01' WHERE id = $1", stale.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, total, err := FindUsersPage(db, UserFilter{Query: "testtouchlogin", InactiveSince: &before}, "username", false, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, u := range found {
+		names = append(names, u.Username)
+	}
+	if want := []string{"testtouchloginnever", "testtouchloginstale"}; total != 2 || !slices.Equal(names, want) {
+		t.Fatalf("expected %v got %v, total %v", want, names, total)
+	}
+
+	if err = DeleteUser(db, stale.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = TouchUserLogin(db, stale.Id); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected touching a deleted user to be sql.ErrNoRows got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrEmptyDirectory is returned by SyncUsers when the directory has no users,
+// which is far more likely a wrong base dn or filter than everyone leaving
+var ErrEmptyDirectory = errors.New("directory has no users, refusing to remove every synced user")
+
+// UserSyncResult is what SyncUsers changed. Unchanged counts the directory
+// users that already matched, Invalid has the usernames of the ones skipped
+// for failing ValidateUser, and KeptOwners the synced users missing from the
+// directory who weren't removed because they still own pirgs.
+type UserSyncResult struct {
+	Created    []*User
+	Updated    []*User
+	Restored   []*User
+	Removed    []*User
+	Unchanged  int
+	Invalid    []string
+	KeptOwners []string
+}
+
+// UserSyncProgress counts what a running SyncUsers has done so far, so it can
+// be read while the sync runs. Scanned is the directory users compared.
+// The counts are of a transaction that can still be rolled back.
+type UserSyncProgress struct {
+	Scanned  atomic.Int64
+	Created  atomic.Int64
+	Updated  atomic.Int64
+	Restored atomic.Int64
+	Removed  atomic.Int64
+}
+
+// syncedUser is a row of the users table as SyncUsers compares it
+type syncedUser struct {
+	User
+	synced bool
+}
+
+// SyncUsers reconciles the users table against users, every user read from
+// a directory, in one transaction. Users are matched by username: new ones
+// are created, ones whose email or name changed are updated, deleted ones are
+// restored, and users an earlier sync created or matched that are no longer
+// in the directory are soft deleted. Running it again with the same users
+// changes nothing. With dryRun the transaction is rolled back, and created
+// users' ids were only taken from the sequence for the dry run. progress, if
+// not nil, is counted up as the sync goes. Syncs wait for each other, so only
+// one runs at a time across instances.
+func SyncUsers(db *sql.DB, users []*UserRequest, dryRun bool, progress *UserSyncProgress) (*UserSyncResult, error) {
+	slog.Debug("syncing users in database", "count", len(users), "dry_run", dryRun, "package", "data", "method", "SyncUsers")
+	if len(users) == 0 {
+		return nil, ErrEmptyDirectory
+	}
+	result := &UserSyncResult{
+		Created:    []*User{},
+		Updated:    []*User{},
+		Restored:   []*User{},
+		Removed:    []*User{},
+		Invalid:    []string{},
+		KeptOwners: []string{},
+	}
+	if progress == nil {
+		progress = &UserSyncProgress{}
+	}
+	err := WithTx(txContext(dryRun), db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('ldap_sync'))"); err != nil {
+			return err
+		}
+		existing, err := getSyncedUsers(tx)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, u := range users {
+			normalized := *u
+			normalized.Username = NormalizeUsername(u.Username)
+			u = &normalized
+			if seen[u.Username] {
+				continue
+			}
+			seen[u.Username] = true
+			progress.Scanned.Add(1)
+			if err := ValidateUser(u); err != nil {
+				result.Invalid = append(result.Invalid, u.Username)
+				continue
+			}
+			current, ok := existing[u.Username]
+			if !ok {
+				created, err := insertUser(tx, u)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.Exec("UPDATE users SET ldap_synced = TRUE WHERE id = $1", created.Id); err != nil {
+					return err
+				}
+				result.Created = append(result.Created, created)
+				progress.Created.Add(1)
+				continue
+			}
+			changed := current.Email != u.Email || current.FirstName != u.FirstName || current.LastName != u.LastName
+			if !changed && current.DeletedAt == nil {
+				if !current.synced {
+					if _, err := tx.Exec("UPDATE users SET ldap_synced = TRUE WHERE id = $1", current.Id); err != nil {
+						return err
+					}
+				}
+				result.Unchanged++
+				continue
+			}
+			_, err := tx.Exec("UPDATE users SET email = $1, firstname = $2, lastname = $3, deleted_at = NULL, ldap_synced = TRUE WHERE id = $4",
+				u.Email, u.FirstName, u.LastName, current.Id)
+			if err != nil {
+				return err
+			}
+			user := current.User
+			user.Email, user.FirstName, user.LastName = u.Email, u.FirstName, u.LastName
+			if user.DeletedAt != nil {
+				user.DeletedAt = nil
+				result.Restored = append(result.Restored, &user)
+				progress.Restored.Add(1)
+			} else {
+				result.Updated = append(result.Updated, &user)
+				progress.Updated.Add(1)
+			}
+		}
+
+		missing := []*syncedUser{}
+		for username, u := range existing {
+			if u.synced && u.DeletedAt == nil && !seen[username] {
+				missing = append(missing, u)
+			}
+		}
+		slices.SortFunc(missing, func(a, b *syncedUser) int { return strings.Compare(a.Username, b.Username) })
+		for _, u := range missing {
+			var owned int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM pirgs WHERE owner_id = $1", u.Id).Scan(&owned); err != nil {
+				return err
+			}
+			if owned > 0 {
+				result.KeptOwners = append(result.KeptOwners, u.Username)
+				continue
+			}
+			if err := softDeleteUser(tx, u.Id); err != nil {
+				return err
+			}
+			user := u.User
+			now := time.Now().UTC()
+			user.DeletedAt = &now
+			result.Removed = append(result.Removed, &user)
+			progress.Removed.Add(1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// getSyncedUsers returns every user, deleted ones included, by username
+func getSyncedUsers(q querier) (map[string]*syncedUser, error) {
+	rows, err := q.Query("SELECT id, username, email, firstname, lastname, created_at, modified_at, deleted_at, last_login_at, version, ldap_synced FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := map[string]*syncedUser{}
+	for rows.Next() {
+		var u syncedUser
+		err := rows.Scan(&u.Id, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.CreatedAt, &u.ModifiedAt, &u.DeletedAt, &u.LastLoginAt, &u.Version, &u.synced)
+		if err != nil {
+			return nil, err
+		}
+		users[u.Username] = &u
+	}
+	return users, rows.Err()
+}
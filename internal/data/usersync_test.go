@@ -0,0 +1,99 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncUsers(t *testing.T) {
+	dh := NewTestDataHandler()
+	db := dh.DB
+	defer db.Close()
+
+	if _, err := SyncUsers(db, nil, false, nil); !errors.Is(err, ErrEmptyDirectory) {
+		t.Fatalf("expected empty directory got %v", err)
+	}
+
+	directory := func(lastName string) []*UserRequest {
+		return []*UserRequest{
+			{Username: "testsynca", Email: "testsynca@localhost", FirstName: "Test", LastName: lastName},
+			{Username: "testsyncb", Email: "testsyncb@localhost", FirstName: "Test", LastName: "Sync"},
+			{Username: "testsyncowner", Email: "testsyncowner@localhost", FirstName: "Test", LastName: "Sync"},
+			{Username: "testsyncinvalid", Email: "not an email", FirstName: "Test", LastName: "Sync"},
+		}
+	}
+	result, err := SyncUsers(db, directory("Sync"), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 3 || len(result.Invalid) != 1 || result.Invalid[0] != "testsyncinvalid" {
+		t.Fatalf("expected three users created and one invalid got %+v", result)
+	}
+	owner := result.Created[2]
+	if _, err := CreatePirg(db, &PirgRequest{Name: "testsync", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// syncing the same users again changes nothing
+	result, err = SyncUsers(db, directory("Sync"), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created)+len(result.Updated)+len(result.Restored)+len(result.Removed) != 0 || result.Unchanged != 3 {
+		t.Fatalf("expected nothing to change got %+v", result)
+	}
+
+	// a dry run reports the changes and makes none of them
+	result, err = SyncUsers(db, directory("Synced")[:1], true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 1 || len(result.Removed) != 1 {
+		t.Fatalf("expected the dry run to find an update and a removal got %+v", result)
+	}
+	if u, err := GetUserByUsername(db, "testsynca"); err != nil || u.LastName != "Sync" {
+		t.Fatalf("expected the dry run to leave testsynca got %+v, %v", u, err)
+	}
+	if _, err := GetUserByUsername(db, "testsyncb"); err != nil {
+		t.Fatalf("expected the dry run to leave testsyncb got %v", err)
+	}
+
+	progress := &UserSyncProgress{}
+	result, err = SyncUsers(db, directory("Synced")[:1], false, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Scanned.Load() != 1 || progress.Updated.Load() != 1 || progress.Removed.Load() != 1 || progress.Created.Load() != 0 {
+		t.Errorf("expected the progress to count the scan, update and removal got scanned %d updated %d removed %d",
+			progress.Scanned.Load(), progress.Updated.Load(), progress.Removed.Load())
+	}
+	if len(result.Updated) != 1 || result.Updated[0].LastName != "Synced" {
+		t.Errorf("expected testsynca to be updated got %+v", result.Updated)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].Username != "testsyncb" {
+		t.Errorf("expected testsyncb to be removed got %+v", result.Removed)
+	}
+	if len(result.KeptOwners) != 1 || result.KeptOwners[0] != "testsyncowner" {
+		t.Errorf("expected the pirg owner to be kept got %+v", result.KeptOwners)
+	}
+
+	result, err = SyncUsers(db, directory("Synced"), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Restored) != 1 || result.Restored[0].Username != "testsyncb" || result.Restored[0].DeletedAt != nil {
+		t.Errorf("expected testsyncb to be restored got %+v", result.Restored)
+	}
+
+	// users that weren't synced are left alone
+	local, err := CreateUser(db, &UserRequest{Username: "testsynclocal", Email: "testsynclocal@localhost", FirstName: "Test", LastName: "Local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = SyncUsers(db, directory("Synced"), false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetUserById(db, local.Id); err != nil {
+		t.Errorf("expected the local user to be kept got %v", err)
+	}
+}
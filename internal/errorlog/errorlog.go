@@ -0,0 +1,122 @@
+// Package errorlog keeps the most recent 5xx responses in memory for diagnostics
+package errorlog
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxErrorLength is how much of an error's text is kept
+const maxErrorLength = 256
+
+// redactPattern matches quoted strings and key=(value) details, which is
+// where database errors put the values that caused them
+var redactPattern = regexp.MustCompile(`'[^']*'|"[^"]*"|=\([^)]*\)`)
+
+type ctxKey struct{}
+
+// Entry is a single 5xx response. Route is the matched route pattern,
+// so it doesn't include ids from the url.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	Status    int       `json:"status"`
+	RequestId string    `json:"request_id"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Buffer holds the last size entries, overwriting the oldest.
+// It's in memory, so it's per instance and cleared by a restart.
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+func NewBuffer(size int) *Buffer {
+	return &Buffer{entries: make([]Entry, size)}
+}
+
+// Add records an entry, dropping the oldest if the buffer is full
+func (b *Buffer) Add(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) == 0 {
+		return
+	}
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Recent returns the entries, newest first
+func (b *Buffer) Recent() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := b.next
+	if b.full {
+		count = len(b.entries)
+	}
+	recent := make([]Entry, 0, count)
+	for i := 1; i <= count; i++ {
+		recent = append(recent, b.entries[(b.next-i+len(b.entries))%len(b.entries)])
+	}
+	return recent
+}
+
+// Middleware records every response with a 5xx status. It should run outside
+// middleware.Recoverer so panics are recorded too.
+func (b *Buffer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqErr string
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), ctxKey{}, &reqErr)))
+		if ww.Status() < 500 {
+			return
+		}
+		route := r.URL.Path
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		b.Add(Entry{
+			Time:      time.Now().UTC(),
+			Method:    r.Method,
+			Route:     route,
+			Status:    ww.Status(),
+			RequestId: middleware.GetReqID(r.Context()),
+			Error:     reqErr,
+		})
+	})
+}
+
+// SetError attaches the error behind a 5xx response to the request,
+// if it's being recorded. The error is redacted first.
+func SetError(ctx context.Context, err error) {
+	reqErr, ok := ctx.Value(ctxKey{}).(*string)
+	if !ok || err == nil {
+		return
+	}
+	*reqErr = Redact(err.Error())
+}
+
+// Redact masks the values in an error message and keeps only
+// the first line, up to maxErrorLength bytes
+func Redact(s string) string {
+	s, _, _ = strings.Cut(s, "\n")
+	s = redactPattern.ReplaceAllString(s, "<redacted>")
+	if len(s) > maxErrorLength {
+		s = s[:maxErrorLength] + "..."
+	}
+	return s
+}
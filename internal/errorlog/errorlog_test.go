@@ -0,0 +1,67 @@
+package errorlog
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestBufferRollsOff(t *testing.T) {
+	b := NewBuffer(3)
+	if recent := b.Recent(); len(recent) != 0 {
+		t.Fatalf("expected an empty buffer got %v", recent)
+	}
+	for i := 1; i <= 5; i++ {
+		b.Add(Entry{Status: 500, RequestId: fmt.Sprint(i)})
+	}
+	recent := b.Recent()
+	if len(recent) != 3 {
+		t.Fatalf("expected 3 entries got %v", recent)
+	}
+	for i, want := range []string{"5", "4", "3"} {
+		if recent[i].RequestId != want {
+			t.Errorf("expected entry %v to be request %v got %v", i, want, recent[i].RequestId)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := Redact(`pq: duplicate key value violates unique constraint "users_email_key" Key (email)=(someone@example.com)` + "\nDETAIL: more")
+	want := `pq: duplicate key value violates unique constraint <redacted> Key (email)<redacted>`
+	if got != want {
+		t.Errorf("expected %q got %q", want, got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	b := NewBuffer(10)
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(b.Middleware)
+	r.Use(middleware.Recoverer)
+	r.Get("/pirgs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetError(r.Context(), fmt.Errorf("failed to read pirg 'secret'"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	for _, path := range []string{"/pirgs/12", "/ok", "/panic"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	recent := b.Recent()
+	if len(recent) != 2 {
+		t.Fatalf("expected the two 500s got %+v", recent)
+	}
+	if recent[0].Route != "/panic" || recent[0].Status != http.StatusInternalServerError {
+		t.Errorf("expected the panic to be recorded got %+v", recent[0])
+	}
+	e := recent[1]
+	if e.Route != "/pirgs/{id}" || e.Method != "GET" || e.RequestId == "" || e.Error != "failed to read pirg <redacted>" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,66 @@
+package flags
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// Evaluator decides whether a feature flag is on for a request
+type Evaluator struct {
+	flags map[string]config.FeatureFlagConfig
+}
+
+func NewEvaluator(flags map[string]config.FeatureFlagConfig) *Evaluator {
+	return &Evaluator{flags: flags}
+}
+
+// Enabled returns true if the named flag is on for the role and tenant in ctx.
+// Flags that aren't configured are off.
+func (e *Evaluator) Enabled(ctx context.Context, name string) bool {
+	flag, ok := e.flags[name]
+	if !ok {
+		return false
+	}
+	if flag.Enabled {
+		return true
+	}
+	if role, ok := ctx.Value(keys.RoleKey).(string); ok && slices.Contains(flag.Roles, role) {
+		return true
+	}
+	if tenant, ok := ctx.Value(keys.TenantKey).(string); ok && slices.Contains(flag.Tenants, tenant) {
+		return true
+	}
+	return false
+}
+
+// EnabledFlags returns the names of the flags that are on for ctx, sorted
+func (e *Evaluator) EnabledFlags(ctx context.Context) []string {
+	names := []string{}
+	for name := range e.flags {
+		if e.Enabled(ctx, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Require returns middleware that responds 404 when the named flag is off,
+// so a dark-launched route looks like it doesn't exist
+func (e *Evaluator) Require(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !e.Enabled(r.Context(), name) {
+				slog.Debug("feature flag is off for request", "package", "flags", "method", "Require", "flag", name)
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
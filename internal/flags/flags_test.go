@@ -0,0 +1,66 @@
+package flags
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestRequire(t *testing.T) {
+	e := NewEvaluator(map[string]config.FeatureFlagConfig{
+		"by-role":   {Roles: []string{"admin"}},
+		"by-tenant": {Tenants: []string{"tenant-a"}},
+		"everyone":  {Enabled: true},
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		flag   string
+		role   string
+		tenant string
+		want   int
+	}{
+		{"RoleOn", "by-role", "admin", "", http.StatusOK},
+		{"RoleOff", "by-role", "user", "", http.StatusNotFound},
+		{"TenantOn", "by-tenant", "user", "tenant-a", http.StatusOK},
+		{"TenantOff", "by-tenant", "user", "tenant-b", http.StatusNotFound},
+		{"Enabled", "everyone", "user", "", http.StatusOK},
+		{"UnknownFlag", "missing", "admin", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), keys.RoleKey, tt.role)
+			if tt.tenant != "" {
+				ctx = context.WithValue(ctx, keys.TenantKey, tt.tenant)
+			}
+			req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			e.Require(tt.flag)(ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %v got %v", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestEnabledFlags(t *testing.T) {
+	e := NewEvaluator(map[string]config.FeatureFlagConfig{
+		"by-role":  {Roles: []string{"admin"}},
+		"off":      {},
+		"everyone": {Enabled: true},
+	})
+	admin := context.WithValue(context.Background(), keys.RoleKey, "admin")
+	if got := strings.Join(e.EnabledFlags(admin), ","); got != "by-role,everyone" {
+		t.Errorf("expected by-role,everyone for admin got %q", got)
+	}
+	user := context.WithValue(context.Background(), keys.RoleKey, "user")
+	if got := strings.Join(e.EnabledFlags(user), ","); got != "everyone" {
+		t.Errorf("expected everyone for user got %q", got)
+	}
+}
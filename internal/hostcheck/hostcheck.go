@@ -0,0 +1,47 @@
+// Package hostcheck rejects requests whose Host header isn't expected,
+// to guard against host header attacks
+package hostcheck
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Middleware returns 400 for requests whose Host doesn't match one of allowed.
+// Entries match the host with or without a port, ignoring case.
+// Requests for the exempt paths, such as health checks, are always served.
+// If allowed is empty every host is served.
+func Middleware(allowed []string, exempt []string) func(http.Handler) http.Handler {
+	hosts := make([]string, 0, len(allowed))
+	for _, h := range allowed {
+		hosts = append(hosts, strings.ToLower(h))
+	}
+	return func(next http.Handler) http.Handler {
+		if len(hosts) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(exempt, r.URL.Path) || hostAllowed(hosts, r.Host) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			slog.Debug("rejecting unexpected host", "host", r.Host, "package", "hostcheck", "method", "Middleware")
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		})
+	}
+}
+
+func hostAllowed(hosts []string, host string) bool {
+	host = strings.ToLower(host)
+	if slices.Contains(hosts, host) {
+		return true
+	}
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(hosts, name)
+}
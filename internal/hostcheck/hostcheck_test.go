@@ -0,0 +1,38 @@
+package hostcheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name    string
+		allowed []string
+		host    string
+		path    string
+		want    int
+	}{
+		{"AllowedHost", []string{"hpcadmin.example.com"}, "hpcadmin.example.com", "/api/v1/users", http.StatusOK},
+		{"AllowedHostWithPort", []string{"hpcadmin.example.com"}, "hpcadmin.example.com:3333", "/api/v1/users", http.StatusOK},
+		{"AllowedHostCase", []string{"HPCAdmin.example.com"}, "hpcadmin.EXAMPLE.com", "/api/v1/users", http.StatusOK},
+		{"AllowedHostAndPort", []string{"localhost:3333"}, "localhost:3333", "/api/v1/users", http.StatusOK},
+		{"WrongPort", []string{"localhost:3333"}, "localhost:4444", "/api/v1/users", http.StatusBadRequest},
+		{"SpoofedHost", []string{"hpcadmin.example.com"}, "evil.example.com", "/api/v1/users", http.StatusBadRequest},
+		{"SpoofedHealthCheck", []string{"hpcadmin.example.com"}, "evil.example.com", "/", http.StatusOK},
+		{"Disabled", nil, "evil.example.com", "/api/v1/users", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			Middleware(tt.allowed, []string{"/"})(ok).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("expected status %v got %v", tt.want, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package httpclient
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+const (
+	defaultDialTimeout     = 5 * time.Second
+	defaultResponseTimeout = 10 * time.Second
+	defaultTimeout         = 30 * time.Second
+	defaultMaxIdleConns    = 100
+)
+
+// New returns the http client that outbound integrations should use,
+// so their timeouts and proxy are configured in one place
+func New(cfg config.HTTPClientConfig) (*http.Client, error) {
+	dialTimeout := cfg.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	responseTimeout := cfg.ResponseTimeout
+	if responseTimeout == 0 {
+		responseTimeout = defaultResponseTimeout
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	maxIdleConns := cfg.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http client proxy: %v", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	slog.Debug("creating outbound http client", "package", "httpclient", "method", "New", "timeout", timeout, "proxy", cfg.Proxy)
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		TLSHandshakeTimeout:   dialTimeout,
+		ResponseHeaderTimeout: responseTimeout,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
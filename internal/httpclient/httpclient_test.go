@@ -0,0 +1,70 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func newSlowServer(t *testing.T, delay time.Duration) *httptest.Server {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-done:
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestNew(t *testing.T) {
+	t.Run("ResponseTimeout", func(t *testing.T) {
+		srv := newSlowServer(t, time.Second)
+		client, err := New(config.HTTPClientConfig{ResponseTimeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		_, err = client.Get(srv.URL)
+		if err == nil {
+			t.Fatal("expected the request to time out")
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("expected timeout near 50ms, took %v", elapsed)
+		}
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		srv := newSlowServer(t, time.Second)
+		client, err := New(config.HTTPClientConfig{Timeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Get(srv.URL); err == nil {
+			t.Fatal("expected the request to time out")
+		}
+	})
+	t.Run("FastServer", func(t *testing.T) {
+		srv := newSlowServer(t, 0)
+		client, err := New(config.HTTPClientConfig{Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+	t.Run("InvalidProxy", func(t *testing.T) {
+		if _, err := New(config.HTTPClientConfig{Proxy: "://bad"}); err == nil {
+			t.Fatal("expected an error for an invalid proxy url")
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+const (
+	defaultUsernameField = "preferred_username"
+	defaultCacheTTL      = 15 * time.Minute
+)
+
+type cachedUsername struct {
+	username   string
+	validUntil time.Time
+}
+
+// Resolver looks up the username for a token subject from an OIDC userinfo
+// endpoint or Microsoft Graph /me, and caches the answer per subject
+type Resolver struct {
+	client        *http.Client
+	url           string
+	usernameField string
+	ttl           time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedUsername
+}
+
+func NewResolver(cfg config.IdentityConfig, client *http.Client) *Resolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	usernameField := cfg.UsernameField
+	if usernameField == "" {
+		usernameField = defaultUsernameField
+	}
+	ttl := cfg.CacheTTL
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	return &Resolver{
+		client:        client,
+		url:           cfg.UserinfoURL,
+		usernameField: usernameField,
+		ttl:           ttl,
+		cache:         make(map[string]cachedUsername),
+	}
+}
+
+// Resolve returns the username for subject. The endpoint describes whoever
+// the token belongs to, so token must be the subject's own access token.
+func (r *Resolver) Resolve(ctx context.Context, subject string, token string) (string, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[subject]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.validUntil) {
+		slog.Debug("username found in identity cache", "package", "identity", "method", "Resolve")
+		return cached.username, nil
+	}
+
+	slog.Debug("resolving username from identity endpoint", "package", "identity", "method", "Resolve", "url", r.url)
+	req, err := http.NewRequestWithContext(ctx, "GET", r.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("identity request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("identity endpoint returned status %d", resp.StatusCode)
+	}
+	var info map[string]any
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("failed to decode identity response: %v", err)
+	}
+	username, ok := info[r.usernameField].(string)
+	if !ok || username == "" {
+		return "", fmt.Errorf("identity response has no %s field", r.usernameField)
+	}
+
+	r.mu.Lock()
+	r.cache[subject] = cachedUsername{username: username, validUntil: time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+	return username, nil
+}
@@ -0,0 +1,71 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// newUserinfoServer answers for a single token, like a real userinfo endpoint
+func newUserinfoServer(t *testing.T, token string, info map[string]any) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestResolve(t *testing.T) {
+	t.Run("ResolvesAndCaches", func(t *testing.T) {
+		srv, hits := newUserinfoServer(t, "token1", map[string]any{"sub": "opaque-subject", "preferred_username": "jdoe"})
+		r := NewResolver(config.IdentityConfig{UserinfoURL: srv.URL}, srv.Client())
+		for i := 0; i < 2; i++ {
+			username, err := r.Resolve(context.Background(), "opaque-subject", "token1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if username != "jdoe" {
+				t.Fatalf("expected jdoe got %v", username)
+			}
+		}
+		if hits.Load() != 1 {
+			t.Fatalf("expected 1 request to the userinfo endpoint got %v", hits.Load())
+		}
+	})
+	t.Run("GraphUsernameField", func(t *testing.T) {
+		srv, _ := newUserinfoServer(t, "token1", map[string]any{"id": "opaque-subject", "userPrincipalName": "jdoe@example.edu"})
+		r := NewResolver(config.IdentityConfig{UserinfoURL: srv.URL, UsernameField: "userPrincipalName"}, srv.Client())
+		username, err := r.Resolve(context.Background(), "opaque-subject", "token1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "jdoe@example.edu" {
+			t.Fatalf("expected jdoe@example.edu got %v", username)
+		}
+	})
+	t.Run("RejectedToken", func(t *testing.T) {
+		srv, _ := newUserinfoServer(t, "token1", map[string]any{"preferred_username": "jdoe"})
+		r := NewResolver(config.IdentityConfig{UserinfoURL: srv.URL}, srv.Client())
+		if _, err := r.Resolve(context.Background(), "opaque-subject", "wrong"); err == nil {
+			t.Fatal("expected an error for a rejected token")
+		}
+	})
+	t.Run("MissingField", func(t *testing.T) {
+		srv, _ := newUserinfoServer(t, "token1", map[string]any{"sub": "opaque-subject"})
+		r := NewResolver(config.IdentityConfig{UserinfoURL: srv.URL}, srv.Client())
+		if _, err := r.Resolve(context.Background(), "opaque-subject", "token1"); err == nil {
+			t.Fatal("expected an error when the username field is missing")
+		}
+	})
+}
@@ -0,0 +1,262 @@
+// Package jobs runs background maintenance tasks on an interval
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every calls fn once per interval until ctx is done. Errors are logged and
+// don't stop later runs. The first run happens after one interval.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	slog.Debug("starting background job", "job", name, "interval", interval, "package", "jobs", "method", "Every")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Debug("stopping background job", "job", name, "package", "jobs", "method", "Every")
+			return
+		case <-ticker.C:
+			if err := fn(ctx); err != nil {
+				slog.Error("background job failed", "job", name, "package", "jobs", "method", "Every", "error", err)
+			}
+		}
+	}
+}
+
+// Pool runs jobs with at most limit of them running at once. The rest wait
+// their turn in the order they were submitted.
+type Pool struct {
+	slots chan struct{}
+}
+
+// NewPool returns a pool running up to limit jobs at once, or any number
+// of them if limit is 0
+func NewPool(limit int) *Pool {
+	p := &Pool{}
+	if limit > 0 {
+		p.slots = make(chan struct{}, limit)
+	}
+	return p
+}
+
+// Run waits for a free slot and then calls fn. If ctx is done first, fn
+// isn't called and the context's error is returned.
+func (p *Pool) Run(ctx context.Context, fn func(context.Context) error) error {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+			defer func() { <-p.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fn(ctx)
+}
+
+// Statuses of a job's last run
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Status is the state of a registered job. Queued is set while a run waits
+// for the pool to have a free slot. LastRun is nil until the first run
+// starts, and LastStatus and LastError describe the last finished run.
+type Status struct {
+	Name       string
+	Interval   time.Duration
+	Queued     bool
+	Running    bool
+	LastRun    *time.Time
+	LastStatus string
+	LastError  string
+	NextRun    time.Time
+}
+
+// Registry starts jobs and keeps their status for reporting. Every job's
+// runs share one pool, so no more than its limit run at once.
+type Registry struct {
+	mu   sync.Mutex
+	jobs []*Status
+	pool *Pool
+	// stopped is done once Shutdown is called, and no more runs start
+	stopped  context.Context
+	stop     context.CancelFunc
+	shutdown bool
+	// running counts the runs in progress by job name, and inflight waits
+	// for them
+	running  map[string]int
+	inflight sync.WaitGroup
+}
+
+// NewRegistry returns a registry running up to maxConcurrent jobs at once,
+// or any number of them if maxConcurrent is 0
+func NewRegistry(maxConcurrent int) *Registry {
+	stopped, stop := context.WithCancel(context.Background())
+	return &Registry{pool: NewPool(maxConcurrent), stopped: stopped, stop: stop, running: map[string]int{}}
+}
+
+// Start registers the job and runs it with Every in a new goroutine until
+// ctx is done or the registry shuts down. Each run waits for a free slot in
+// the registry's pool first. A run in progress then is left to finish, see
+// Shutdown.
+func (r *Registry) Start(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	s := &Status{Name: name, Interval: interval, NextRun: time.Now().Add(interval)}
+	r.mu.Lock()
+	r.jobs = append(r.jobs, s)
+	r.mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	context.AfterFunc(r.stopped, cancel)
+	go Every(ctx, name, interval, func(ctx context.Context) error {
+		r.mu.Lock()
+		s.Queued = true
+		r.mu.Unlock()
+		err := r.pool.Run(ctx, func(ctx context.Context) error {
+			return r.run(context.WithoutCancel(ctx), s, fn)
+		})
+		if err != nil && errors.Is(err, ctx.Err()) {
+			// stopped while waiting for a slot, so it didn't run
+			r.mu.Lock()
+			s.Queued = false
+			r.mu.Unlock()
+			return nil
+		}
+		return err
+	})
+}
+
+// Submit runs fn once in a new goroutine, after waiting for a free slot in
+// the registry's pool like the registered jobs' runs. An error is logged.
+// Once the registry shuts down no more jobs start, including ones submitted
+// before that are still waiting for a slot.
+func (r *Registry) Submit(ctx context.Context, name string, fn func(context.Context) error) {
+	slog.Debug("submitting job", "job", name, "package", "jobs", "method", "Submit")
+	waitCtx, cancel := context.WithCancel(ctx)
+	stopWaiting := context.AfterFunc(r.stopped, cancel)
+	go func() {
+		defer cancel()
+		defer stopWaiting()
+		err := r.pool.Run(waitCtx, func(context.Context) error {
+			if !r.begin(name) {
+				return errShutdown
+			}
+			defer r.end(name)
+			return fn(ctx)
+		})
+		if errors.Is(err, errShutdown) || (err != nil && r.stopped.Err() != nil && errors.Is(err, waitCtx.Err())) {
+			slog.Warn("not running job, shutting down", "job", name, "package", "jobs", "method", "Submit")
+			return
+		}
+		if err != nil {
+			slog.Error("job failed", "job", name, "package", "jobs", "method", "Submit", "error", err)
+		}
+	}()
+}
+
+// errShutdown is the error of a run that didn't start because the registry
+// is shutting down
+var errShutdown = errors.New("shutting down")
+
+// begin counts a run of the job name as in progress. It returns false, and
+// the run mustn't start, if the registry is shutting down.
+func (r *Registry) begin(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown {
+		return false
+	}
+	r.running[name]++
+	r.inflight.Add(1)
+	return true
+}
+
+// end counts the run begin started as finished
+func (r *Registry) end(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[name]--; r.running[name] == 0 {
+		delete(r.running, name)
+	}
+	r.inflight.Done()
+}
+
+// Shutdown stops the jobs' schedules and any more runs from starting, then
+// waits for the runs in progress to finish, or for ctx to be done. It
+// returns the names of the jobs still running then, sorted, which are
+// abandoned.
+func (r *Registry) Shutdown(ctx context.Context) []string {
+	r.mu.Lock()
+	r.shutdown = true
+	r.mu.Unlock()
+	r.stop()
+	done := make(chan struct{})
+	go func() {
+		r.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	abandoned := []string{}
+	for name := range r.running {
+		abandoned = append(abandoned, name)
+	}
+	slices.Sort(abandoned)
+	return abandoned
+}
+
+// run calls fn, recording it in s. It doesn't if the registry is shutting down.
+func (r *Registry) run(ctx context.Context, s *Status, fn func(context.Context) error) error {
+	if !r.begin(s.Name) {
+		r.mu.Lock()
+		s.Queued = false
+		r.mu.Unlock()
+		return nil
+	}
+	defer r.end(s.Name)
+	start := time.Now()
+	r.mu.Lock()
+	s.Queued = false
+	s.Running = true
+	s.LastRun = &start
+	s.NextRun = start.Add(s.Interval)
+	r.mu.Unlock()
+
+	err := fn(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.Running = false
+	s.LastStatus = StatusSucceeded
+	s.LastError = ""
+	if err != nil {
+		s.LastStatus = StatusFailed
+		s.LastError = err.Error()
+	}
+	return err
+}
+
+// Jobs returns the status of every registered job, ordered by name
+func (r *Registry) Jobs() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]Status, 0, len(r.jobs))
+	for _, s := range r.jobs {
+		jobs = append(jobs, *s)
+	}
+	slices.SortFunc(jobs, func(a, b Status) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return jobs
+}
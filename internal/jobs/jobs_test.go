@@ -0,0 +1,258 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		Every(ctx, "test", time.Millisecond, func(context.Context) error {
+			// a failing run doesn't stop the next one
+			if runs.Add(1) == 1 {
+				return errors.New("first run fails")
+			}
+			return nil
+		})
+		close(done)
+	}()
+	deadline := time.After(5 * time.Second)
+	for runs.Load() < 3 {
+		select {
+		case <-deadline:
+			t.Fatalf("expected at least 3 runs got %v", runs.Load())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Every to return once the context is done")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewRegistry(0)
+	before := time.Now()
+	r.Start(ctx, "hourly", time.Hour, func(context.Context) error { return nil })
+	var runs atomic.Int32
+	r.Start(ctx, "failing", time.Millisecond, func(context.Context) error {
+		runs.Add(1)
+		return errors.New("sweep failed")
+	})
+
+	statuses := r.Jobs()
+	if len(statuses) != 2 || statuses[0].Name != "failing" || statuses[1].Name != "hourly" {
+		t.Fatalf("expected both jobs ordered by name got %+v", statuses)
+	}
+	hourly := statuses[1]
+	if hourly.Interval != time.Hour || hourly.LastRun != nil || hourly.LastStatus != "" {
+		t.Errorf("expected an hourly job that hasn't run got %+v", hourly)
+	}
+	if hourly.NextRun.Before(before.Add(time.Hour)) {
+		t.Errorf("expected the next run in an hour got %v", hourly.NextRun)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		failing := r.Jobs()[0]
+		if failing.LastStatus == StatusFailed {
+			if failing.LastRun == nil || failing.LastError != "sweep failed" {
+				t.Errorf("expected the failed run to be recorded got %+v", failing)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("expected the failing job to run got %+v", failing)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// concurrencyTracker records the most calls of run in progress at once
+type concurrencyTracker struct {
+	running atomic.Int32
+	max     atomic.Int32
+	calls   atomic.Int32
+}
+
+func (c *concurrencyTracker) run(context.Context) error {
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		m := c.max.Load()
+		if n <= m || c.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	c.calls.Add(1)
+	time.Sleep(5 * time.Millisecond)
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	p := NewPool(3)
+	var c concurrencyTracker
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Run(context.Background(), c.run); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.calls.Load() != 20 {
+		t.Errorf("expected every job to run got %v", c.calls.Load())
+	}
+	if got := c.max.Load(); got != 3 {
+		t.Errorf("expected at most and up to 3 jobs at once got %v", got)
+	}
+
+	// a job waiting for a slot gives up when its context is done
+	p = NewPool(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.Run(context.Background(), func(context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ran := false
+	err := p.Run(ctx, func(context.Context) error {
+		ran = true
+		return nil
+	})
+	close(release)
+	if !errors.Is(err, context.DeadlineExceeded) || ran {
+		t.Errorf("expected the queued job not to run got %v, ran %v", err, ran)
+	}
+}
+
+func TestRegistryMaxConcurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewRegistry(2)
+	var c concurrencyTracker
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		r.Start(ctx, name, time.Millisecond, c.run)
+	}
+	deadline := time.After(5 * time.Second)
+	sawQueued := false
+	for c.calls.Load() < 50 {
+		for _, s := range r.Jobs() {
+			sawQueued = sawQueued || s.Queued
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("expected at least 50 runs got %v", c.calls.Load())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if got := c.max.Load(); got > 2 {
+		t.Errorf("expected at most 2 jobs at once got %v", got)
+	}
+	if !sawQueued {
+		t.Error("expected jobs waiting for a slot to be queued")
+	}
+}
+
+func TestRegistryShutdown(t *testing.T) {
+	r := NewRegistry(0)
+	var scheduled atomic.Int32
+	r.Start(context.Background(), "sweep", time.Millisecond, func(context.Context) error {
+		scheduled.Add(1)
+		return nil
+	})
+	started := make(chan struct{})
+	var finished atomic.Bool
+	r.Submit(context.Background(), "export", func(ctx context.Context) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		// the run's context isn't canceled by the shutdown
+		if ctx.Err() == nil {
+			finished.Store(true)
+		}
+		return nil
+	})
+	<-started
+
+	// the running job gets to finish
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if abandoned := r.Shutdown(ctx); len(abandoned) != 0 {
+		t.Errorf("expected no jobs to be abandoned got %v", abandoned)
+	}
+	if !finished.Load() {
+		t.Error("expected the running job to finish before Shutdown returned")
+	}
+
+	// and nothing more runs after
+	runs := scheduled.Load()
+	ran := make(chan struct{})
+	r.Submit(context.Background(), "late", func(context.Context) error {
+		close(ran)
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+	if got := scheduled.Load(); got != runs {
+		t.Errorf("expected the schedule to stop at %d runs got %d", runs, got)
+	}
+	select {
+	case <-ran:
+		t.Error("expected a job submitted after Shutdown not to run")
+	default:
+	}
+}
+
+func TestRegistryShutdownAbandonsHungJobs(t *testing.T) {
+	r := NewRegistry(1)
+	hung := make(chan struct{})
+	defer close(hung)
+	started := make(chan struct{})
+	r.Submit(context.Background(), "hung export", func(context.Context) error {
+		close(started)
+		<-hung
+		return nil
+	})
+	<-started
+	// queued behind the hung job, it never gets a slot
+	queued := make(chan struct{})
+	r.Submit(context.Background(), "queued", func(context.Context) error {
+		close(queued)
+		return nil
+	})
+
+	const timeout = 20 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	abandoned := r.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > 5*time.Second {
+		t.Errorf("expected Shutdown to wait out the %v timeout, took %v", timeout, elapsed)
+	}
+	if len(abandoned) != 1 || abandoned[0] != "hung export" {
+		t.Errorf("expected the hung job to be abandoned got %v", abandoned)
+	}
+	select {
+	case <-queued:
+		t.Error("expected the queued job not to run")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,181 @@
+// Package jwks fetches and caches the signing keys oauth tokens are verified with
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const (
+	defaultURL             = "https://login.microsoftonline.com/common/discovery/v2.0/keys"
+	defaultRefreshInterval = time.Hour
+	// minRefreshInterval limits the refreshes caused by tokens signed with an
+	// unknown key, which is how a key rotation shows up
+	minRefreshInterval = time.Minute
+	// refreshJitter is the most of the refresh interval that background
+	// refreshes happen early by, so replicas started together don't all
+	// fetch at once
+	refreshJitter = 0.2
+)
+
+// Source verifies tokens against a JWKS endpoint. Keys are refetched every
+// refresh interval, and if a refetch fails the last keys fetched keep being
+// used for up to the max staleness, so an outage of the endpoint doesn't
+// fail every login.
+type Source struct {
+	client          *http.Client
+	url             string
+	refreshInterval time.Duration
+	maxStaleness    time.Duration
+	now             func() time.Time
+	// jitter returns a random number in [0, 1) that scales refreshJitter
+	jitter func() float64
+
+	mu          sync.Mutex
+	keys        jwk.Set
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func NewSource(cfg config.JWKSConfig, client *http.Client) *Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	url := cfg.URL
+	if url == "" {
+		url = defaultURL
+	}
+	refreshInterval := cfg.RefreshInterval
+	if refreshInterval == 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+	return &Source{
+		client:          client,
+		url:             url,
+		refreshInterval: refreshInterval,
+		maxStaleness:    cfg.MaxStaleness,
+		now:             time.Now,
+		jitter:          rand.Float64,
+	}
+}
+
+// Parse parses and verifies a RS256 signed token with the key named by its kid header
+func (s *Source) Parse(ctx context.Context, tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		if token.Method.Alg() != jwa.RS256.String() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("kid header not found")
+		}
+		key, err := s.lookupKey(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := &rsa.PublicKey{}
+		if err := key.Raw(publicKey); err != nil {
+			return nil, fmt.Errorf("could not parse public key %v: %v", kid, err)
+		}
+		return publicKey, nil
+	})
+}
+
+func (s *Source) lookupKey(ctx context.Context, kid string) (jwk.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.keys == nil || now.Sub(s.fetchedAt) >= s.refreshInterval {
+		if err := s.refresh(ctx, now); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := s.keys.LookupKeyID(kid); ok {
+		return key, nil
+	}
+	// the keys may have rotated since they were fetched
+	if now.Sub(s.attemptedAt) >= minRefreshInterval {
+		if err := s.refresh(ctx, now); err != nil {
+			return nil, err
+		}
+		if key, ok := s.keys.LookupKeyID(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("key %v not found", kid)
+}
+
+// Run refreshes the keys in the background until ctx is done, so requests
+// don't wait on a fetch. The keys are fetched right away and then again a
+// little before each refresh interval is up. A failed refresh is retried
+// every minRefreshInterval, and requests still fall back to fetching the
+// keys themselves if they've expired.
+func (s *Source) Run(ctx context.Context) {
+	slog.Debug("starting background jwks refresh", "interval", s.refreshInterval, "package", "jwks", "method", "Run")
+	for {
+		timer := time.NewTimer(s.refreshAhead(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Debug("stopping background jwks refresh", "package", "jwks", "method", "Run")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refreshAhead refreshes the keys and returns how long to wait before the
+// next background refresh. The fetch happens without s.mu held so requests
+// keep using the current keys meanwhile.
+func (s *Source) refreshAhead(ctx context.Context) time.Duration {
+	slog.Debug("fetching jwks in the background", "url", s.url, "package", "jwks", "method", "refreshAhead")
+	keys, err := jwk.Fetch(ctx, s.url, jwk.WithHTTPClient(s.client))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.attemptedAt = now
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("failed to refresh jwks in the background", "package", "jwks", "method", "refreshAhead", "error", err)
+		}
+		return min(minRefreshInterval, s.refreshInterval)
+	}
+	s.keys = keys
+	s.fetchedAt = now
+	return s.nextRefresh()
+}
+
+// nextRefresh is the refresh interval less up to refreshJitter of it
+func (s *Source) nextRefresh() time.Duration {
+	return s.refreshInterval - time.Duration(float64(s.refreshInterval)*refreshJitter*s.jitter())
+}
+
+// refresh fetches the keys. If that fails, the keys already held are kept
+// as long as they're within the max staleness. s.mu must be held.
+func (s *Source) refresh(ctx context.Context, now time.Time) error {
+	slog.Debug("fetching jwks", "url", s.url, "package", "jwks", "method", "refresh")
+	s.attemptedAt = now
+	keys, err := jwk.Fetch(ctx, s.url, jwk.WithHTTPClient(s.client))
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = now
+		return nil
+	}
+	age := now.Sub(s.fetchedAt)
+	if s.keys != nil && age < s.refreshInterval+s.maxStaleness {
+		slog.Warn("failed to refresh jwks, using the last keys fetched", "age", age.Round(time.Second), "package", "jwks", "method", "refresh", "error", err)
+		return nil
+	}
+	return fmt.Errorf("failed to fetch jwks: %v", err)
+}
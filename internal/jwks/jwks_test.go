@@ -0,0 +1,187 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// newTestJWKS serves a key set holding key under kid, or a 503 while failing is set.
+// Each request is counted in fetches unless it's nil.
+func newTestJWKS(t *testing.T, key *rsa.PrivateKey, kid string, failing *atomic.Bool, fetches *atomic.Int32) *httptest.Server {
+	jwkKey, err := jwk.New(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jwkKey.Set(jwk.KeyIDKey, kid); err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	set.Add(jwkKey)
+	body, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches != nil {
+			fetches.Add(1)
+		}
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "test",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSourceServesStaleKeysWhenRefreshFails(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failing atomic.Bool
+	srv := newTestJWKS(t, key, "key1", &failing, nil)
+	s := NewSource(config.JWKSConfig{URL: srv.URL, RefreshInterval: time.Hour, MaxStaleness: 6 * time.Hour}, srv.Client())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	token := signTestToken(t, key, "key1")
+	ctx := context.Background()
+
+	if _, err := s.Parse(ctx, token); err != nil {
+		t.Fatalf("expected the token to validate got %v", err)
+	}
+
+	failing.Store(true)
+	// the refresh is due and fails, but the keys are within the staleness window
+	now = now.Add(3 * time.Hour)
+	if _, err := s.Parse(ctx, token); err != nil {
+		t.Fatalf("expected the cached keys to validate the token got %v", err)
+	}
+	// and past it every token is rejected
+	now = now.Add(5 * time.Hour)
+	if _, err := s.Parse(ctx, token); err == nil {
+		t.Fatal("expected the token to be rejected once the keys are too stale")
+	}
+
+	// a successful refresh recovers
+	failing.Store(false)
+	if _, err := s.Parse(ctx, token); err != nil {
+		t.Fatalf("expected the token to validate after recovering got %v", err)
+	}
+}
+
+func TestSourceWithoutStaleness(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failing atomic.Bool
+	srv := newTestJWKS(t, key, "key1", &failing, nil)
+	s := NewSource(config.JWKSConfig{URL: srv.URL, RefreshInterval: time.Hour}, srv.Client())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	token := signTestToken(t, key, "key1")
+	if _, err := s.Parse(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	now = now.Add(time.Hour)
+	if _, err := s.Parse(context.Background(), token); err == nil {
+		t.Fatal("expected the token to be rejected when the refresh fails")
+	}
+}
+
+func TestSourceUnknownKeyRefreshes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failing atomic.Bool
+	srv := newTestJWKS(t, key, "key1", &failing, nil)
+	s := NewSource(config.JWKSConfig{URL: srv.URL}, srv.Client())
+	if _, err := s.Parse(context.Background(), signTestToken(t, key, "key1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Parse(context.Background(), signTestToken(t, key, "rotated")); err == nil {
+		t.Fatal("expected a token signed with an unknown key to be rejected")
+	}
+}
+
+func TestSourceRunRefreshesInBackground(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failing atomic.Bool
+	var fetches atomic.Int32
+	srv := newTestJWKS(t, key, "key1", &failing, &fetches)
+	s := NewSource(config.JWKSConfig{URL: srv.URL, RefreshInterval: 50 * time.Millisecond}, srv.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	// no token is parsed, so every fetch is the refresher's
+	deadline := time.Now().Add(5 * time.Second)
+	for fetches.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the keys to be refreshed in the background, got %d fetches", fetches.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	s.mu.Lock()
+	fetchedAt := s.fetchedAt
+	s.mu.Unlock()
+	// the keys are fresh, so parsing doesn't fetch them again
+	s.now = func() time.Time { return fetchedAt }
+	before := fetches.Load()
+	if _, err := s.Parse(context.Background(), signTestToken(t, key, "key1")); err != nil {
+		t.Fatal(err)
+	}
+	if fetches.Load() != before {
+		t.Errorf("expected the token to be verified with the prefetched keys")
+	}
+}
+
+func TestSourceNextRefreshJitter(t *testing.T) {
+	s := NewSource(config.JWKSConfig{RefreshInterval: time.Hour}, nil)
+	s.jitter = func() float64 { return 0 }
+	if d := s.nextRefresh(); d != time.Hour {
+		t.Errorf("expected no jitter to refresh after the interval, got %v", d)
+	}
+	s.jitter = func() float64 { return 0.5 }
+	if d := s.nextRefresh(); d != 54*time.Minute {
+		t.Errorf("expected half the jitter to refresh 6m early, got %v", d)
+	}
+}
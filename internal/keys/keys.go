@@ -11,3 +11,19 @@ const ConfigKey key = "config"
 const RoleKey key = "role"
 const JWTTokenKey key = "token"
 const APIKey key = "APIKey"
+const ClientCertKey key = "clientCert"
+const TenantKey key = "tenant"
+const FlagsKey key = "flags"
+const ActorKey key = "actor"
+const HTTPClientKey key = "httpClient"
+const AuthUsernameKey key = "authUsername"
+const AuthUserIdKey key = "authUserId"
+const MaintenanceKey key = "maintenance"
+const ExposeErrorsKey key = "exposeErrors"
+const ErrorLogKey key = "errorLog"
+const NotifierKey key = "notifier"
+const JobsKey key = "jobs"
+const SlurmKey key = "slurm"
+const WebhooksKey key = "webhooks"
+const StoreKey key = "store"
+const RateLimitKey key = "rateLimit"
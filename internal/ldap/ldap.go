@@ -0,0 +1,135 @@
+// Package ldap reads users from an LDAP directory such as Active Directory
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+const (
+	defaultFilter             = "(&(objectClass=user)(objectCategory=person))"
+	defaultUsernameAttribute  = "sAMAccountName"
+	defaultEmailAttribute     = "mail"
+	defaultFirstNameAttribute = "givenName"
+	defaultLastNameAttribute  = "sn"
+	// timeout applies to connecting and to each request
+	timeout = 30 * time.Second
+	// pageSize is how many entries are read per page, below the
+	// Active Directory limit of 1000
+	pageSize = 500
+)
+
+// ErrNotConfigured is returned by Users when no ldap url is configured
+var ErrNotConfigured = errors.New("ldap sync is not configured")
+
+// User is a user read from the directory
+type User struct {
+	DN        string
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// Directory reads every user under the configured base dn that matches the
+// configured filter
+type Directory struct {
+	cfg config.LDAPConfig
+}
+
+func NewDirectory(cfg config.LDAPConfig) *Directory {
+	if cfg.Filter == "" {
+		cfg.Filter = defaultFilter
+	}
+	attrs := &cfg.Attributes
+	for _, a := range []struct {
+		name *string
+		def  string
+	}{
+		{&attrs.Username, defaultUsernameAttribute},
+		{&attrs.Email, defaultEmailAttribute},
+		{&attrs.FirstName, defaultFirstNameAttribute},
+		{&attrs.LastName, defaultLastNameAttribute},
+	} {
+		if *a.name == "" {
+			*a.name = a.def
+		}
+	}
+	return &Directory{cfg: cfg}
+}
+
+// Users binds with the configured dn and password and returns every user in
+// the directory. Entries without a username are skipped. The connection is
+// closed if ctx is done before the search finishes.
+func (d *Directory) Users(ctx context.Context) ([]User, error) {
+	if d.cfg.URL == "" {
+		return nil, ErrNotConfigured
+	}
+	slog.Debug("reading users from ldap", "url", d.cfg.URL, "base_dn", d.cfg.BaseDN, "package", "ldap", "method", "Users")
+	conn, err := goldap.DialURL(d.cfg.URL, goldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ldap: %v", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(timeout)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if d.cfg.BindDN != "" {
+		err = conn.Bind(d.cfg.BindDN, d.cfg.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind to ldap: %v", err)
+	}
+	attrs := d.cfg.Attributes
+	req := goldap.NewSearchRequest(d.cfg.BaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false,
+		d.cfg.Filter, []string{attrs.Username, attrs.Email, attrs.FirstName, attrs.LastName}, nil)
+	res, err := conn.SearchWithPaging(req, pageSize)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to search ldap: %v", err)
+	}
+	users := []User{}
+	for _, e := range res.Entries {
+		u := d.newUser(e)
+		if u.Username == "" {
+			slog.Warn("skipping ldap entry without a username", "dn", e.DN, "attribute", attrs.Username, "package", "ldap", "method", "Users")
+			continue
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// newUser reads the user's fields from the mapped attributes. Usernames are
+// lowercased, since directories such as Active Directory don't match them
+// by case.
+func (d *Directory) newUser(e *goldap.Entry) User {
+	attrs := d.cfg.Attributes
+	return User{
+		DN:        e.DN,
+		Username:  strings.ToLower(strings.TrimSpace(e.GetEqualFoldAttributeValue(attrs.Username))),
+		Email:     strings.TrimSpace(e.GetEqualFoldAttributeValue(attrs.Email)),
+		FirstName: strings.TrimSpace(e.GetEqualFoldAttributeValue(attrs.FirstName)),
+		LastName:  strings.TrimSpace(e.GetEqualFoldAttributeValue(attrs.LastName)),
+	}
+}
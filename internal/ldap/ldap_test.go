@@ -0,0 +1,42 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestNewDirectoryDefaults(t *testing.T) {
+	d := NewDirectory(config.LDAPConfig{Attributes: config.LDAPAttributeConfig{Username: "uid"}})
+	if d.cfg.Filter != defaultFilter {
+		t.Errorf("expected the default filter got %q", d.cfg.Filter)
+	}
+	attrs := d.cfg.Attributes
+	if attrs.Username != "uid" || attrs.Email != defaultEmailAttribute || attrs.FirstName != defaultFirstNameAttribute || attrs.LastName != defaultLastNameAttribute {
+		t.Errorf("expected the configured username attribute and default others got %+v", attrs)
+	}
+}
+
+func TestNewUser(t *testing.T) {
+	d := NewDirectory(config.LDAPConfig{})
+	e := goldap.NewEntry("cn=Jane Doe,ou=people,dc=example,dc=com", map[string][]string{
+		"samaccountname": {" JDoe "},
+		"mail":           {"jdoe@example.com"},
+		"givenName":      {"Jane"},
+		"sn":             {"Doe"},
+	})
+	u := d.newUser(e)
+	want := User{DN: e.DN, Username: "jdoe", Email: "jdoe@example.com", FirstName: "Jane", LastName: "Doe"}
+	if u != want {
+		t.Errorf("expected %+v got %+v", want, u)
+	}
+}
+
+func TestUsersNotConfigured(t *testing.T) {
+	if _, err := NewDirectory(config.LDAPConfig{}).Users(context.Background()); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("expected not configured got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package maintenance holds the banner shown to api clients during planned work
+package maintenance
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// HeaderName is the response header the banner is sent in while it's set
+const HeaderName = "X-Maintenance-Notice"
+
+// MaxMessageLength is the longest banner message accepted, so it fits in a header
+const MaxMessageLength = 512
+
+// Severities are the accepted banner severities, least severe first
+var Severities = []string{"info", "warning", "critical"}
+
+// Banner is a notice about planned work
+type Banner struct {
+	Message  string    `json:"message"`
+	Severity string    `json:"severity"`
+	SetAt    time.Time `json:"set_at"`
+}
+
+// Validate checks the banner can be sent as a header
+func (b *Banner) Validate() error {
+	if b.Message == "" {
+		return fmt.Errorf("missing required message")
+	}
+	if len(b.Message) > MaxMessageLength {
+		return fmt.Errorf("message must be at most %d bytes", MaxMessageLength)
+	}
+	for _, c := range b.Message {
+		if unicode.IsControl(c) {
+			return fmt.Errorf("message must not contain control characters")
+		}
+	}
+	if !slices.Contains(Severities, b.Severity) {
+		return fmt.Errorf("severity must be one of %v", Severities)
+	}
+	return nil
+}
+
+// Notice holds the current banner. It's kept in memory, so it's per instance
+// and cleared by a restart.
+type Notice struct {
+	mu     sync.RWMutex
+	banner *Banner
+}
+
+func NewNotice() *Notice {
+	return &Notice{}
+}
+
+// Set replaces the banner
+func (n *Notice) Set(b Banner) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.banner = &b
+}
+
+// Clear removes the banner
+func (n *Notice) Clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.banner = nil
+}
+
+// Get returns a copy of the banner, or nil if it isn't set
+func (n *Notice) Get() *Banner {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.banner == nil {
+		return nil
+	}
+	b := *n.banner
+	return &b
+}
+
+// Middleware adds the banner to every response while it's set,
+// as "<severity>: <message>"
+func (n *Notice) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b := n.Get(); b != nil {
+			w.Header().Set(HeaderName, b.Severity+": "+b.Message)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package maintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBannerValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		banner Banner
+		valid  bool
+	}{
+		{"Valid", Banner{Message: "database upgrade at 18:00", Severity: "warning"}, true},
+		{"MissingMessage", Banner{Severity: "info"}, false},
+		{"UnknownSeverity", Banner{Message: "upgrade", Severity: "urgent"}, false},
+		{"Newline", Banner{Message: "upgrade\r\nSet-Cookie: x", Severity: "info"}, false},
+		{"TooLong", Banner{Message: strings.Repeat("a", MaxMessageLength+1), Severity: "info"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.banner.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("expected valid banner got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	n := NewNotice()
+	handler := n.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	header := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Header().Get(HeaderName)
+	}
+	if h := header(); h != "" {
+		t.Fatalf("expected no header before a banner is set got %q", h)
+	}
+	n.Set(Banner{Message: "database upgrade at 18:00", Severity: "warning"})
+	if h := header(); h != "warning: database upgrade at 18:00" {
+		t.Fatalf("unexpected header %q", h)
+	}
+	n.Clear()
+	if h := header(); h != "" {
+		t.Fatalf("expected no header after clearing got %q", h)
+	}
+}
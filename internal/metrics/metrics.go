@@ -0,0 +1,217 @@
+// Package metrics exports metrics for the http server and the database
+// connection pool, to Prometheus, StatsD or both
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute is the route label of requests that didn't match a route,
+// so unknown paths can't add labels
+const unmatchedRoute = "unmatched"
+
+// defaultStatsDPrefix starts every StatsD metric name if statsd.prefix isn't set
+const defaultStatsDPrefix = "hpcadmin"
+
+// Metrics records the server's metrics to each configured backend. Requests
+// are labeled by the chi route pattern they matched rather than their path,
+// so ids in the path don't each get their own series.
+type Metrics struct {
+	db *sql.DB
+
+	// registry is nil without the prometheus backend
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+
+	// statsd is nil without the statsd backend
+	statsd         *statsdClient
+	statsdInFlight atomic.Int64
+}
+
+// New sets up the configured backends. For prometheus that's the http
+// metrics, the stats of the db pool as go_sql_* metrics, and the go runtime
+// and process metrics. StatsD gets the same http and db pool metrics.
+func New(db *sql.DB, cfg config.MetricsConfig) (*Metrics, error) {
+	backends := cfg.Backends
+	if len(backends) == 0 {
+		backends = []string{config.MetricsBackendPrometheus}
+	}
+	m := &Metrics{db: db}
+	if slices.Contains(backends, config.MetricsBackendPrometheus) {
+		m.newPrometheus()
+	}
+	if slices.Contains(backends, config.MetricsBackendStatsD) {
+		prefix := cfg.StatsD.Prefix
+		if prefix == "" {
+			prefix = defaultStatsDPrefix
+		}
+		slog.Debug("connecting to statsd", "address", cfg.StatsD.Address, "prefix", prefix, "package", "metrics", "method", "New")
+		conn, err := net.Dial("udp", cfg.StatsD.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to statsd: %v", err)
+		}
+		m.statsd = &statsdClient{conn: conn, prefix: prefix}
+	}
+	return m, nil
+}
+
+func (m *Metrics) newPrometheus() {
+	m.registry = prometheus.NewRegistry()
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hpcadmin",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+	m.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "hpcadmin",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	m.inFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "hpcadmin",
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		collectors.NewDBStatsCollector(m.db, "hpcadmin"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Prometheus reports whether the prometheus backend is enabled, and so
+// whether Handler should be served
+func (m *Metrics) Prometheus() bool {
+	return m.registry != nil
+}
+
+// StatsD reports whether the statsd backend is enabled, and so whether
+// SendDBStats should be run
+func (m *Metrics) StatsD() bool {
+	return m.statsd != nil
+}
+
+// Middleware records every request. It has to be used on the root router,
+// the route pattern is read once the request has been routed.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.registry != nil {
+			m.inFlight.Inc()
+			defer m.inFlight.Dec()
+		}
+		if m.statsd != nil {
+			m.statsd.send(m.statsd.gauge("http.requests_in_flight", m.statsdInFlight.Add(1)))
+			defer m.statsdInFlight.Add(-1)
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		elapsed := time.Since(start)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code := strconv.Itoa(status)
+		if m.registry != nil {
+			m.requests.WithLabelValues(route, r.Method, code).Inc()
+			m.duration.WithLabelValues(route, r.Method, code).Observe(elapsed.Seconds())
+		}
+		if m.statsd != nil {
+			name := statsdName(route) + "." + r.Method + "." + code
+			m.statsd.send(
+				m.statsd.counter("http.requests."+name, 1),
+				m.statsd.timing("http.request_duration."+name, elapsed),
+			)
+		}
+	})
+}
+
+// Handler serves the prometheus metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// SendDBStats is a job for jobs.Registry.Start that sends the db pool stats
+// to StatsD as gauges. Prometheus reads them itself when it's scraped.
+func (m *Metrics) SendDBStats(ctx context.Context) error {
+	stats := m.db.Stats()
+	return m.statsd.send(
+		m.statsd.gauge("db.max_open_connections", int64(stats.MaxOpenConnections)),
+		m.statsd.gauge("db.open_connections", int64(stats.OpenConnections)),
+		m.statsd.gauge("db.in_use", int64(stats.InUse)),
+		m.statsd.gauge("db.idle", int64(stats.Idle)),
+		m.statsd.gauge("db.wait_count", stats.WaitCount),
+		m.statsd.gauge("db.wait_duration_ms", stats.WaitDuration.Milliseconds()),
+	)
+}
+
+// statsdClient writes metrics in the StatsD line format, each call to send
+// being one udp packet
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+}
+
+func (s *statsdClient) counter(name string, value int64) string {
+	return fmt.Sprintf("%s.%s:%d|c", s.prefix, name, value)
+}
+
+func (s *statsdClient) gauge(name string, value int64) string {
+	return fmt.Sprintf("%s.%s:%d|g", s.prefix, name, value)
+}
+
+func (s *statsdClient) timing(name string, d time.Duration) string {
+	return fmt.Sprintf("%s.%s:%s|ms", s.prefix, name, strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64))
+}
+
+// send writes the lines as one packet. It's udp, so an error means the packet
+// couldn't be sent at all, not that it wasn't received.
+func (s *statsdClient) send(lines ...string) error {
+	_, err := s.conn.Write([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		slog.Debug("failed to send statsd metrics", "package", "metrics", "method", "send", "error", err)
+	}
+	return err
+}
+
+// statsdUnsafe matches what can't be in a StatsD name segment
+var statsdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// statsdName turns a route pattern into one segment of a metric name,
+// /api/v1/users/{userID} becomes api_v1_users_userID and / becomes root
+func statsdName(route string) string {
+	name := strings.Trim(statsdUnsafe.ReplaceAllString(route, "_"), "_")
+	if name == "" {
+		return "root"
+	}
+	return name
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	_ "github.com/lib/pq"
+)
+
+func TestMetrics(t *testing.T) {
+	// the pool only has to exist for its stats, it never connects
+	db, err := sql.Open("postgres", "postgresql://localhost/hpcadmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := New(db, config.MetricsConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.StatsD() {
+		t.Errorf("expected statsd to be disabled by default")
+	}
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Route("/users", func(r chi.Router) {
+		r.Get("/{userID}", func(w http.ResponseWriter, r *http.Request) {})
+		r.Delete("/{userID}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/users/1"},
+		{"GET", "/users/2"},
+		{"DELETE", "/users/1"},
+		{"GET", "/nope/123"},
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`hpcadmin_http_requests_total{code="200",method="GET",route="/users/{userID}"} 2`,
+		`hpcadmin_http_requests_total{code="204",method="DELETE",route="/users/{userID}"} 1`,
+		`hpcadmin_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`hpcadmin_http_request_duration_seconds_count{code="200",method="GET",route="/users/{userID}"} 2`,
+		`hpcadmin_http_requests_in_flight 0`,
+		`go_sql_open_connections{db_name="hpcadmin"} 0`,
+		`go_sql_wait_count_total{db_name="hpcadmin"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %s", want)
+		}
+	}
+	if strings.Contains(body, "/users/1") || strings.Contains(body, "/nope") {
+		t.Errorf("expected raw paths to not be used as labels")
+	}
+}
+
+// readPackets reads count packets from the mock statsd listener
+func readPackets(t *testing.T, conn net.PacketConn, count int) []string {
+	t.Helper()
+	packets := []string{}
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(packets) < count {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected %d statsd packets, got %d: %v", count, len(packets), err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+	return packets
+}
+
+func TestMetricsStatsD(t *testing.T) {
+	db, err := sql.Open("postgres", "postgresql://localhost/hpcadmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name       string
+		backends   []string
+		prometheus bool
+	}{
+		{"statsd only", []string{config.MetricsBackendStatsD}, false},
+		{"both", []string{config.MetricsBackendPrometheus, config.MetricsBackendStatsD}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			m, err := New(db, config.MetricsConfig{
+				Backends: tt.backends,
+				StatsD:   config.StatsDConfig{Address: listener.LocalAddr().String(), Prefix: "test"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !m.StatsD() {
+				t.Fatalf("expected statsd to be enabled")
+			}
+			if m.Prometheus() != tt.prometheus {
+				t.Errorf("expected prometheus enabled to be %v", tt.prometheus)
+			}
+
+			r := chi.NewRouter()
+			r.Use(m.Middleware)
+			r.Get("/users/{userID}", func(w http.ResponseWriter, r *http.Request) {})
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope/123", nil))
+
+			// each request sends the in flight gauge, then its counter and timing
+			packets := strings.Join(readPackets(t, listener, 4), "\n")
+			for _, want := range []string{
+				"test.http.requests_in_flight:1|g",
+				"test.http.requests.users_userID.GET.200:1|c",
+				"test.http.request_duration.users_userID.GET.200:",
+				"test.http.requests.unmatched.GET.404:1|c",
+			} {
+				if !strings.Contains(packets, want) {
+					t.Errorf("expected statsd packets to contain %s, got %s", want, packets)
+				}
+			}
+			if strings.Contains(packets, "users_1") || strings.Contains(packets, "nope") {
+				t.Errorf("expected raw paths to not be used in metric names")
+			}
+
+			if err := m.SendDBStats(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			packets = readPackets(t, listener, 1)[0]
+			for _, want := range []string{
+				"test.db.open_connections:0|g",
+				"test.db.in_use:0|g",
+				"test.db.wait_count:0|g",
+			} {
+				if !strings.Contains(packets, want) {
+					t.Errorf("expected statsd packets to contain %s, got %s", want, packets)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsDName(t *testing.T) {
+	tests := []struct {
+		route string
+		want  string
+	}{
+		{"/", "root"},
+		{"/api/v1/users/{userID}", "api_v1_users_userID"},
+		{"/api/v1/pirgs/{pirgID}/*", "api_v1_pirgs_pirgID"},
+		{"unmatched", "unmatched"},
+	}
+	for _, tt := range tests {
+		if got := statsdName(tt.route); got != tt.want {
+			t.Errorf("statsdName(%q) = %q, want %q", tt.route, got, tt.want)
+		}
+	}
+}
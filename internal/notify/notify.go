@@ -0,0 +1,167 @@
+// Package notify delivers notifications to users and admins
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// ErrNotConfigured is returned when sending while no delivery method is configured
+var ErrNotConfigured = errors.New("notifications are not configured")
+
+// defaultSMTPPort is the submission port, used if smtp.port isn't set
+const defaultSMTPPort = 587
+
+// sendTimeout bounds a send if the context has no deadline of its own
+const sendTimeout = 30 * time.Second
+
+// Message is a plain text notification
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Notifier sends messages
+type Notifier interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// New returns the notifier for the config, which returns ErrNotConfigured
+// from every send while the smtp host is unset
+func New(cfg config.NotificationConfig) Notifier {
+	if cfg.SMTP.Host == "" {
+		return disabled{}
+	}
+	return NewSMTPNotifier(cfg.SMTP)
+}
+
+type disabled struct{}
+
+func (disabled) Send(ctx context.Context, m Message) error {
+	return ErrNotConfigured
+}
+
+// SMTPNotifier sends messages through an SMTP server, using STARTTLS if the
+// server offers it. Credentials are only sent over TLS.
+type SMTPNotifier struct {
+	cfg    config.SMTPConfig
+	dialer net.Dialer
+}
+
+func NewSMTPNotifier(cfg config.SMTPConfig) *SMTPNotifier {
+	if cfg.Port == 0 {
+		cfg.Port = defaultSMTPPort
+	}
+	return &SMTPNotifier{cfg: cfg}
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, m Message) error {
+	msg, err := formatMessage(n.cfg.From, m, time.Now())
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sendTimeout)
+		defer cancel()
+	}
+	addr := net.JoinHostPort(n.cfg.Host, strconv.Itoa(n.cfg.Port))
+	conn, err := n.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server %s: %v", addr, err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	c, err := smtp.NewClient(conn, n.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start smtp session: %v", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.cfg.Host}); err != nil {
+			return fmt.Errorf("failed to start tls: %v", err)
+		}
+	}
+	if n.cfg.Username != "" {
+		// PlainAuth refuses to send credentials without tls, except to localhost
+		if err := c.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %v", err)
+		}
+	}
+	if err := c.Mail(n.cfg.From); err != nil {
+		return fmt.Errorf("sender rejected: %v", err)
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", to, err)
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("message rejected: %v", err)
+	}
+	return c.Quit()
+}
+
+// formatMessage builds the message headers and body. Header values can't
+// contain line breaks, so they can't be used to add headers.
+func formatMessage(from string, m Message, now time.Time) ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, fmt.Errorf("message has no recipients")
+	}
+	for _, v := range append([]string{from, m.Subject}, m.To...) {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("message headers must not contain line breaks")
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// Recorder keeps the messages sent through it instead of delivering them,
+// for tests. Sends return Err if it's set.
+type Recorder struct {
+	mu   sync.Mutex
+	sent []Message
+	Err  error
+}
+
+func (n *Recorder) Send(ctx context.Context, m Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, m)
+	return n.Err
+}
+
+// Sent returns the messages sent so far, including failed sends
+func (n *Recorder) Sent() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Message{}, n.sent...)
+}
@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatMessage(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg, err := formatMessage("hpcadmin@example.com", Message{
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "Test",
+		Body:    "line one\nline two\n",
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "From: hpcadmin@example.com\r\n" +
+		"To: a@example.com, b@example.com\r\n" +
+		"Subject: Test\r\n" +
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		"line one\r\nline two\r\n"
+	if string(msg) != want {
+		t.Errorf("expected %q got %q", want, msg)
+	}
+
+	if _, err := formatMessage("hpcadmin@example.com", Message{To: []string{"a@example.com"}, Subject: "Test\r\nBcc: c@example.com"}, now); err == nil || !strings.Contains(err.Error(), "line breaks") {
+		t.Errorf("expected a header injection to be rejected got %v", err)
+	}
+	if _, err := formatMessage("hpcadmin@example.com", Message{Subject: "Test"}, now); err == nil {
+		t.Error("expected a message without recipients to be rejected")
+	}
+}
@@ -0,0 +1,131 @@
+// Package ratelimit limits how often each client can make requests, with a
+// token bucket per client
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Limiter takes tokens from the clients' buckets. MemoryLimiter keeps them in
+// the process, so each instance limits on its own. A store shared by every
+// instance, such as Redis, can implement it to limit across them.
+type Limiter interface {
+	// Allow takes a token from key's bucket. If it's empty it returns false
+	// and how long until the next token is added.
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+	// Status returns the state of key's bucket without taking a token
+	Status(ctx context.Context, key string) (Status, error)
+}
+
+// Status is the state of a client's bucket
+type Status struct {
+	// Limit is the most tokens the bucket holds, or 0 if there's no limit
+	Limit int
+	// Remaining is how many whole tokens are left in it
+	Remaining int
+	// Reset is how long until it's full again
+	Reset time.Duration
+}
+
+// sweepInterval is how often a MemoryLimiter forgets the buckets that have refilled
+const sweepInterval = time.Minute
+
+// MemoryLimiter is a Limiter keeping the buckets in memory. Each holds up to
+// burst tokens and gets requestsPerMinute of them back every minute. With a
+// requestsPerMinute of 0 it allows everything.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemoryLimiter returns a limiter allowing requestsPerMinute requests, and
+// up to burst at once. A burst of 0 is requestsPerMinute.
+func NewMemoryLimiter(requestsPerMinute int, burst int) *MemoryLimiter {
+	l := &MemoryLimiter{buckets: map[string]*bucket{}, now: time.Now}
+	l.setRate(requestsPerMinute, burst)
+	return l
+}
+
+// SetRate changes the limits to those of NewMemoryLimiter. Buckets keep their
+// tokens, up to the new burst.
+func (l *MemoryLimiter) SetRate(requestsPerMinute int, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setRate(requestsPerMinute, burst)
+}
+
+func (l *MemoryLimiter) setRate(requestsPerMinute int, burst int) {
+	if burst == 0 {
+		burst = requestsPerMinute
+	}
+	l.rate = float64(requestsPerMinute) / 60
+	l.burst = float64(burst)
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return true, 0, nil
+	}
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refilled(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait, nil
+}
+
+func (l *MemoryLimiter) Status(ctx context.Context, key string) (Status, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return Status{}, nil
+	}
+	status := Status{Limit: int(l.burst), Remaining: int(l.burst)}
+	if b, ok := l.buckets[key]; ok {
+		tokens := l.refilled(b, l.now())
+		status.Remaining = int(tokens)
+		status.Reset = time.Duration((l.burst - tokens) / l.rate * float64(time.Second))
+	}
+	return status, nil
+}
+
+// refilled is how many tokens b has at now
+func (l *MemoryLimiter) refilled(b *bucket, now time.Time) float64 {
+	return min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+}
+
+// sweep forgets the buckets that are full again, they're the same as new ones
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refilled(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+	slog.Debug("swept rate limit buckets", "remaining", len(l.buckets), "package", "ratelimit", "method", "sweep")
+}
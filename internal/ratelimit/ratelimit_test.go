@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter(60, 3)
+	l.now = func() time.Time { return now }
+	allow := func(key string) (bool, time.Duration) {
+		t.Helper()
+		ok, wait, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, wait
+	}
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := allow("a"); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	if ok, wait := allow("a"); ok || wait != time.Second {
+		t.Errorf("expected the request past the burst to wait a second got %v %v", ok, wait)
+	}
+	// clients have their own buckets
+	if ok, _ := allow("b"); !ok {
+		t.Error("expected another client to be allowed")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := allow("a"); !ok {
+		t.Error("expected a token back after a second")
+	}
+	if ok, _ := allow("a"); ok {
+		t.Error("expected only one token back after a second")
+	}
+
+	// buckets that refilled are forgotten
+	now = now.Add(sweepInterval)
+	allow("c")
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+		t.Errorf("expected the refilled buckets to be swept got %v", l.buckets)
+	}
+}
+
+func TestMemoryLimiterSetRate(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter(60, 1)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	if ok, _, _ := l.Allow(ctx, "a"); !ok {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if ok, _, _ := l.Allow(ctx, "a"); ok {
+		t.Fatal("expected the request past the burst to be refused")
+	}
+
+	// a rate of 0 turns the limit off
+	l.SetRate(0, 0)
+	for i := 0; i < 5; i++ {
+		if ok, _, _ := l.Allow(ctx, "a"); !ok {
+			t.Fatalf("expected request %d to be allowed without a limit", i+1)
+		}
+	}
+
+	// and a new rate applies to the buckets already there
+	l.SetRate(120, 2)
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.Allow(ctx, "a"); !ok {
+			t.Fatalf("expected request %d at the new rate to be allowed", i+1)
+		}
+	}
+	if ok, wait, _ := l.Allow(ctx, "a"); ok || wait != 500*time.Millisecond {
+		t.Errorf("expected the request past the new burst to wait half a second got %v %v", ok, wait)
+	}
+}
+
+func TestMemoryLimiterStatus(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter(60, 3)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	status := func() Status {
+		t.Helper()
+		s, err := l.Status(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if s := status(); s != (Status{Limit: 3, Remaining: 3}) {
+		t.Errorf("expected a new client to have a full bucket got %+v", s)
+	}
+	for i := 0; i < 2; i++ {
+		l.Allow(ctx, "a")
+	}
+	if s := status(); s != (Status{Limit: 3, Remaining: 1, Reset: 2 * time.Second}) {
+		t.Errorf("expected 1 token left, full in 2 seconds got %+v", s)
+	}
+	// checking doesn't take a token
+	if s := status(); s.Remaining != 1 {
+		t.Errorf("expected Status not to take a token got %+v", s)
+	}
+	now = now.Add(time.Second)
+	if s := status(); s != (Status{Limit: 3, Remaining: 2, Reset: time.Second}) {
+		t.Errorf("expected a token back after a second got %+v", s)
+	}
+
+	l.SetRate(0, 0)
+	if s := status(); s != (Status{}) {
+		t.Errorf("expected no limit got %+v", s)
+	}
+}
@@ -0,0 +1,261 @@
+// Package slurm provisions pirgs in Slurm, where each pirg is an account and
+// its members are users associated with it
+package slurm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+const (
+	defaultCommand = "sacctmgr"
+	// commandTimeout bounds a change if the context has no deadline of its own
+	commandTimeout = 30 * time.Second
+	// restAPIVersion is the slurmrestd data parser the requests are written for
+	restAPIVersion = "v0.0.40"
+)
+
+// SlurmProvisioner makes the Slurm changes for pirg changes. Every change is
+// idempotent, creating an account that exists or removing a user that isn't
+// associated isn't an error.
+type SlurmProvisioner interface {
+	CreateAccount(ctx context.Context, account string) error
+	DeleteAccount(ctx context.Context, account string) error
+	AddUser(ctx context.Context, account string, username string) error
+	RemoveUser(ctx context.Context, account string, username string) error
+}
+
+// New returns the provisioner for the config, or nil while it isn't enabled
+func New(cfg config.SlurmConfig, client *http.Client) SlurmProvisioner {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.URL != "" {
+		return NewRESTProvisioner(cfg, client)
+	}
+	return NewCommandProvisioner(cfg)
+}
+
+// CommandProvisioner makes changes by running sacctmgr
+type CommandProvisioner struct {
+	command string
+	cluster string
+}
+
+func NewCommandProvisioner(cfg config.SlurmConfig) *CommandProvisioner {
+	command := cfg.Command
+	if command == "" {
+		command = defaultCommand
+	}
+	return &CommandProvisioner{command: command, cluster: cfg.Cluster}
+}
+
+func (p *CommandProvisioner) CreateAccount(ctx context.Context, account string) error {
+	return p.run(ctx, "add", "account", "name="+account)
+}
+
+func (p *CommandProvisioner) DeleteAccount(ctx context.Context, account string) error {
+	return p.run(ctx, "delete", "account", "name="+account)
+}
+
+func (p *CommandProvisioner) AddUser(ctx context.Context, account string, username string) error {
+	return p.run(ctx, "add", "user", "name="+username, "account="+account)
+}
+
+func (p *CommandProvisioner) RemoveUser(ctx context.Context, account string, username string) error {
+	return p.run(ctx, "delete", "user", "name="+username, "account="+account)
+}
+
+// run runs sacctmgr without prompting. It exits nonzero when there's nothing
+// to add or delete, which is only reported in its output.
+func (p *CommandProvisioner) run(ctx context.Context, args ...string) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, commandTimeout)
+		defer cancel()
+	}
+	args = append([]string{"--immediate"}, args...)
+	if p.cluster != "" {
+		args = append(args, "cluster="+p.cluster)
+	}
+	slog.Debug("running sacctmgr", "command", p.command, "args", args, "package", "slurm", "method", "run")
+	out, err := exec.CommandContext(ctx, p.command, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	output := strings.TrimSpace(string(out))
+	if strings.Contains(output, "Nothing new added") || strings.Contains(output, "Nothing deleted") {
+		return nil
+	}
+	if output == "" {
+		return fmt.Errorf("%s %s failed: %v", p.command, strings.Join(args[1:3], " "), err)
+	}
+	return fmt.Errorf("%s %s failed: %v: %s", p.command, strings.Join(args[1:3], " "), err, output)
+}
+
+// RESTProvisioner makes changes through slurmrestd
+type RESTProvisioner struct {
+	url     string
+	user    string
+	token   string
+	cluster string
+	client  *http.Client
+}
+
+func NewRESTProvisioner(cfg config.SlurmConfig, client *http.Client) *RESTProvisioner {
+	return &RESTProvisioner{
+		url:     strings.TrimSuffix(cfg.URL, "/") + "/slurmdb/" + restAPIVersion,
+		user:    cfg.User,
+		token:   cfg.Token,
+		cluster: cfg.Cluster,
+		client:  client,
+	}
+}
+
+type restAccount struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Organization string `json:"organization"`
+}
+
+type restAssociation struct {
+	Account string `json:"account"`
+	User    string `json:"user"`
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// restErrors is the body slurmrestd answers with, only the errors are read
+type restErrors struct {
+	Errors []struct {
+		Description string `json:"description"`
+		Error       string `json:"error"`
+	} `json:"errors"`
+}
+
+func (p *RESTProvisioner) CreateAccount(ctx context.Context, account string) error {
+	body := map[string][]restAccount{"accounts": {{Name: account, Description: account, Organization: account}}}
+	return p.do(ctx, http.MethodPost, "/accounts", nil, body)
+}
+
+func (p *RESTProvisioner) DeleteAccount(ctx context.Context, account string) error {
+	return p.do(ctx, http.MethodDelete, "/account/"+url.PathEscape(account), nil, nil)
+}
+
+func (p *RESTProvisioner) AddUser(ctx context.Context, account string, username string) error {
+	body := map[string][]restAssociation{"associations": {{Account: account, User: username, Cluster: p.cluster}}}
+	return p.do(ctx, http.MethodPost, "/associations", nil, body)
+}
+
+func (p *RESTProvisioner) RemoveUser(ctx context.Context, account string, username string) error {
+	query := url.Values{"account": {account}, "user": {username}}
+	if p.cluster != "" {
+		query.Set("cluster", p.cluster)
+	}
+	return p.do(ctx, http.MethodDelete, "/association", query, nil)
+}
+
+// do sends a request to slurmrestd. Any response other than a 2xx is an
+// error, carrying the errors slurmrestd reported.
+func (p *RESTProvisioner) do(ctx context.Context, method string, path string, query url.Values, body any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	u := p.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-SLURM-USER-NAME", p.user)
+	req.Header.Set("X-SLURM-USER-TOKEN", p.token)
+	slog.Debug("sending slurmrestd request", "http_method", method, "url", u, "package", "slurm", "method", "do")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	var errs restErrors
+	if json.Unmarshal(respBody, &errs) == nil && len(errs.Errors) > 0 {
+		messages := []string{}
+		for _, e := range errs.Errors {
+			if e.Description != "" {
+				messages = append(messages, e.Description)
+			} else {
+				messages = append(messages, e.Error)
+			}
+		}
+		return fmt.Errorf("slurmrestd %s %s returned %s: %s", method, path, resp.Status, strings.Join(messages, ", "))
+	}
+	return fmt.Errorf("slurmrestd %s %s returned %s", method, path, resp.Status)
+}
+
+// Call is a change made through a Recorder
+type Call struct {
+	Method   string
+	Account  string
+	Username string
+}
+
+// Recorder keeps the changes made through it, for tests.
+// Changes return Err instead if it's set.
+type Recorder struct {
+	mu    sync.Mutex
+	calls []Call
+	Err   error
+}
+
+func (r *Recorder) CreateAccount(ctx context.Context, account string) error {
+	return r.record(Call{Method: "CreateAccount", Account: account})
+}
+
+func (r *Recorder) DeleteAccount(ctx context.Context, account string) error {
+	return r.record(Call{Method: "DeleteAccount", Account: account})
+}
+
+func (r *Recorder) AddUser(ctx context.Context, account string, username string) error {
+	return r.record(Call{Method: "AddUser", Account: account, Username: username})
+}
+
+func (r *Recorder) RemoveUser(ctx context.Context, account string, username string) error {
+	return r.record(Call{Method: "RemoveUser", Account: account, Username: username})
+}
+
+func (r *Recorder) record(c Call) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, c)
+	return r.Err
+}
+
+// Calls returns the changes made so far, oldest first
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call{}, r.calls...)
+}
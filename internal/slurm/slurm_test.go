@@ -0,0 +1,162 @@
+package slurm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	if p := New(config.SlurmConfig{Command: "/usr/bin/sacctmgr"}, http.DefaultClient); p != nil {
+		t.Errorf("expected no provisioner while disabled got %T", p)
+	}
+	if _, ok := New(config.SlurmConfig{Enabled: true}, http.DefaultClient).(*CommandProvisioner); !ok {
+		t.Error("expected the command provisioner by default")
+	}
+	if _, ok := New(config.SlurmConfig{Enabled: true, URL: "http://localhost:6820"}, http.DefaultClient).(*RESTProvisioner); !ok {
+		t.Error("expected the rest provisioner with a url")
+	}
+}
+
+// fakeSacctmgr writes a script that appends its args to a log and then runs
+// body, and returns its path and the log's
+func fakeSacctmgr(t *testing.T, body string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args.log")
+	script := filepath.Join(dir, "sacctmgr")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+log+"\n"+body+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script, log
+}
+
+func TestCommandProvisioner(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Args", func(t *testing.T) {
+		script, log := fakeSacctmgr(t, "exit 0")
+		p := NewCommandProvisioner(config.SlurmConfig{Command: script, Cluster: "talapas"})
+		for _, err := range []error{
+			p.CreateAccount(ctx, "testpirg"),
+			p.AddUser(ctx, "testpirg", "jdoe"),
+			p.RemoveUser(ctx, "testpirg", "jdoe"),
+			p.DeleteAccount(ctx, "testpirg"),
+		} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := os.ReadFile(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Join([]string{
+			"--immediate add account name=testpirg cluster=talapas",
+			"--immediate add user name=jdoe account=testpirg cluster=talapas",
+			"--immediate delete user name=jdoe account=testpirg cluster=talapas",
+			"--immediate delete account name=testpirg cluster=talapas",
+		}, "\n") + "\n"
+		if string(got) != want {
+			t.Errorf("expected args\n%s\ngot\n%s", want, got)
+		}
+	})
+
+	t.Run("NothingToDo", func(t *testing.T) {
+		script, _ := fakeSacctmgr(t, "echo ' Nothing new added.'\nexit 1")
+		p := NewCommandProvisioner(config.SlurmConfig{Command: script})
+		if err := p.CreateAccount(ctx, "testpirg"); err != nil {
+			t.Errorf("expected an existing account not to be an error got %v", err)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		script, _ := fakeSacctmgr(t, "echo 'This user does not exist' >&2\nexit 1")
+		p := NewCommandProvisioner(config.SlurmConfig{Command: script})
+		err := p.AddUser(ctx, "testpirg", "jdoe")
+		if err == nil || !strings.Contains(err.Error(), "add user") || !strings.Contains(err.Error(), "This user does not exist") {
+			t.Errorf("expected the failure with its output got %v", err)
+		}
+	})
+}
+
+func TestRESTProvisioner(t *testing.T) {
+	type request struct {
+		method string
+		path   string
+		query  string
+		body   map[string]any
+	}
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-SLURM-USER-NAME") != "slurm" || r.Header.Get("X-SLURM-USER-TOKEN") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req := request{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery}
+		json.NewDecoder(r.Body).Decode(&req.body)
+		requests = append(requests, req)
+		if req.path == "/slurmdb/v0.0.40/account/missing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"errors": [{"description": "Nothing found with query", "error": "Unspecified error"}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors": []}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	p := NewRESTProvisioner(config.SlurmConfig{URL: srv.URL + "/", User: "slurm", Token: "secret", Cluster: "talapas"}, srv.Client())
+	for _, err := range []error{
+		p.CreateAccount(ctx, "testpirg"),
+		p.AddUser(ctx, "testpirg", "jdoe"),
+		p.RemoveUser(ctx, "testpirg", "jdoe"),
+		p.DeleteAccount(ctx, "testpirg"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 requests got %+v", requests)
+	}
+	if r := requests[0]; r.method != "POST" || r.path != "/slurmdb/v0.0.40/accounts" || !strings.Contains(mustJSON(t, r.body), `"name":"testpirg"`) {
+		t.Errorf("unexpected create account request %+v", r)
+	}
+	if r := requests[1]; r.method != "POST" || r.path != "/slurmdb/v0.0.40/associations" ||
+		mustJSON(t, r.body) != `{"associations":[{"account":"testpirg","cluster":"talapas","user":"jdoe"}]}` {
+		t.Errorf("unexpected add user request %+v", r)
+	}
+	if r := requests[2]; r.method != "DELETE" || r.path != "/slurmdb/v0.0.40/association" || r.query != "account=testpirg&cluster=talapas&user=jdoe" {
+		t.Errorf("unexpected remove user request %+v", r)
+	}
+	if r := requests[3]; r.method != "DELETE" || r.path != "/slurmdb/v0.0.40/account/testpirg" {
+		t.Errorf("unexpected delete account request %+v", r)
+	}
+
+	err := p.DeleteAccount(ctx, "missing")
+	if err == nil || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "Nothing found with query") {
+		t.Errorf("expected the slurmrestd error got %v", err)
+	}
+	p.token = "wrong"
+	if err := p.DeleteAccount(ctx, "testpirg"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized got %v", err)
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -5,14 +5,33 @@ import (
 	"os"
 )
 
-func ConfigureLogging(debug bool) {
+// logLevel is the level of the logger ConfigureLogging sets, so SetLogLevel
+// can change it while the server runs
+var logLevel = new(slog.LevelVar)
+
+// ConfigureLogging sets the default logger to write text to stdout at the
+// level, debug, info, warn or error. An empty or unknown level is info, and
+// debug logs at debug regardless of level.
+func ConfigureLogging(debug bool, level string) {
 	// Set up logging
+	SetLogLevel(debug, level)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+	}))
+	slog.SetDefault(logger)
+}
+
+// SetLogLevel changes the level of the logger ConfigureLogging set, the same
+// as ConfigureLogging would, without replacing the logger
+func SetLogLevel(debug bool, level string) {
 	lvl := slog.LevelInfo
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			lvl = slog.LevelInfo
+		}
+	}
 	if debug {
 		lvl = slog.LevelDebug
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: lvl,
-	}))
-	slog.SetDefault(logger)
+	logLevel.Set(lvl)
 }
@@ -0,0 +1,193 @@
+// Package webhook POSTs user and pirg lifecycle events to downstream systems
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// Event types
+const (
+	EventUserCreated  = "user.created"
+	EventUserUpdated  = "user.updated"
+	EventUserDeleted  = "user.deleted"
+	EventUserRestored = "user.restored"
+	// EventUserProvision follows user.created once the new user has a uid,
+	// see config.PosixIdConfig
+	EventUserProvision = "user.provision"
+	EventPirgCreated   = "pirg.created"
+	EventPirgUpdated   = "pirg.updated"
+	EventPirgDeleted   = "pirg.deleted"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the body, see Sign
+	SignatureHeader = "X-HPCAdmin-Signature"
+	// EventHeader carries the event type, so receivers can route it unread
+	EventHeader = "X-HPCAdmin-Event"
+
+	defaultQueueSize   = 1000
+	defaultMaxAttempts = 3
+	// defaultRetryBackoff is the wait before the first retry, doubled for each one after
+	defaultRetryBackoff = time.Second
+)
+
+// Event is the body POSTed to every webhook url
+type Event struct {
+	Type      string    `json:"type"`
+	Data      any       `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Sign returns the signature of body, "sha256=" and the hex HMAC-SHA256 of
+// it keyed with secret. Receivers compute the same to check it came from us.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers events to the webhook urls in the background, so
+// sending them never holds up a response. Events are queued in memory and
+// delivered in order by Run. A delivery that times out or is answered with a
+// 5xx is retried, up to MaxAttempts in all.
+type Dispatcher struct {
+	urls        []string
+	secret      string
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	queue       chan *queued
+}
+
+type queued struct {
+	eventType string
+	body      []byte
+}
+
+// New returns the dispatcher for the config, or nil while no urls are set.
+// Enqueue on a nil dispatcher does nothing.
+func New(cfg config.WebhookConfig, client *http.Client) *Dispatcher {
+	if len(cfg.URLs) == 0 {
+		return nil
+	}
+	queueSize := cfg.QueueSize
+	if queueSize == 0 {
+		queueSize = defaultQueueSize
+	}
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	return &Dispatcher{
+		urls:        cfg.URLs,
+		secret:      cfg.Secret,
+		client:      client,
+		maxAttempts: maxAttempts,
+		backoff:     defaultRetryBackoff,
+		queue:       make(chan *queued, queueSize),
+	}
+}
+
+// Enqueue queues an event of eventType with data, which is encoded right
+// away so later changes to it aren't sent. If the queue is full the event
+// is dropped and logged rather than blocking the caller.
+func (d *Dispatcher) Enqueue(eventType string, data any) {
+	if d == nil {
+		return
+	}
+	body, err := json.Marshal(&Event{Type: eventType, Data: data, Timestamp: time.Now().UTC()})
+	if err != nil {
+		slog.Error("failed to encode webhook event", "type", eventType, "error", err, "package", "webhook", "method", "Enqueue")
+		return
+	}
+	select {
+	case d.queue <- &queued{eventType: eventType, body: body}:
+	default:
+		slog.Warn("webhook queue is full, dropping event", "type", eventType, "package", "webhook", "method", "Enqueue")
+	}
+}
+
+// Run delivers queued events to every url until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-d.queue:
+			for _, url := range d.urls {
+				if err := d.deliver(ctx, url, q); err != nil {
+					slog.Error("failed to deliver webhook event", "type", q.eventType, "url", url, "error", err, "package", "webhook", "method", "Run")
+				}
+			}
+		}
+	}
+}
+
+// retryableError is a failed delivery that's worth another attempt
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, url string, q *queued) error {
+	backoff := d.backoff
+	var err error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		var retryable *retryableError
+		if err = d.post(ctx, url, q); err == nil || !errors.As(err, &retryable) {
+			return err
+		}
+		if attempt == d.maxAttempts {
+			break
+		}
+		slog.Debug("retrying webhook delivery", "type", q.eventType, "url", url, "attempt", attempt, "error", err, "package", "webhook", "method", "deliver")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("gave up after %d attempts: %v", d.maxAttempts, err)
+}
+
+func (d *Dispatcher) post(ctx context.Context, url string, q *queued) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(q.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, q.eventType)
+	req.Header.Set(SignatureHeader, Sign(d.secret, q.body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		// timeouts and refused connections alike
+		return &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 500 {
+		return &retryableError{err: fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("testsecret", []byte(`{"type":"user.created"}`))
+	want := "sha256=4c9d8be94044e0ae0664850a5191ac6587f0e7728b3d996afcd075b7a89bc36a"
+	if got != want {
+		t.Errorf("expected %v got %v", want, got)
+	}
+}
+
+// webhookServer answers each request with the next of statuses, and the
+// last one once they run out, and sends every request it gets on requests
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, chan *http.Request, chan []byte) {
+	t.Helper()
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		mu.Unlock()
+		requests <- r
+		bodies <- body
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests, bodies
+}
+
+func TestDispatcher(t *testing.T) {
+	if New(config.WebhookConfig{Secret: "testsecret"}, http.DefaultClient) != nil {
+		t.Error("expected no dispatcher without urls")
+	}
+	// a nil dispatcher drops events
+	var disabled *Dispatcher
+	disabled.Enqueue(EventUserCreated, nil)
+
+	srv, requests, bodies := webhookServer(t, http.StatusNoContent)
+	d := New(config.WebhookConfig{URLs: []string{srv.URL}, Secret: "testsecret"}, srv.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Enqueue(EventUserCreated, map[string]any{"id": 1, "username": "testwebhook"})
+	var r *http.Request
+	var body []byte
+	select {
+	case r = <-requests:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the event to be delivered")
+	}
+	if r.Header.Get(SignatureHeader) != Sign("testsecret", body) {
+		t.Errorf("expected signature %v got %v", Sign("testsecret", body), r.Header.Get(SignatureHeader))
+	}
+	if r.Header.Get(EventHeader) != EventUserCreated || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+	var event struct {
+		Type      string         `json:"type"`
+		Data      map[string]any `json:"data"`
+		Timestamp time.Time      `json:"timestamp"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventUserCreated || event.Data["username"] != "testwebhook" || event.Timestamp.IsZero() {
+		t.Errorf("unexpected event %s", body)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		wantErr  bool
+	}{
+		{name: "RetriesServerErrors", statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, attempts: 3},
+		{name: "GivesUp", statuses: []int{http.StatusInternalServerError}, attempts: 3, wantErr: true},
+		{name: "DoesNotRetryClientErrors", statuses: []int{http.StatusBadRequest}, attempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests, _ := webhookServer(t, tt.statuses...)
+			d := New(config.WebhookConfig{URLs: []string{srv.URL}, Secret: "testsecret"}, srv.Client())
+			d.backoff = time.Millisecond
+			err := d.deliver(context.Background(), srv.URL, &queued{eventType: EventPirgDeleted, body: []byte(`{}`)})
+			if tt.wantErr != (err != nil) {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+			if len(requests) != tt.attempts {
+				t.Errorf("expected %v attempts got %v", tt.attempts, len(requests))
+			}
+		})
+	}
+}
+
+func TestDispatcherTimeoutRetried(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+	client := srv.Client()
+	client.Timeout = 50 * time.Millisecond
+	d := New(config.WebhookConfig{URLs: []string{srv.URL}, Secret: "testsecret"}, client)
+	d.backoff = time.Millisecond
+	if err := d.deliver(context.Background(), srv.URL, &queued{eventType: EventPirgCreated, body: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("expected the timed out delivery to be retried got %v attempts", got)
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	d := New(config.WebhookConfig{URLs: []string{"http://localhost"}, Secret: "testsecret", QueueSize: 1}, http.DefaultClient)
+	d.Enqueue(EventUserCreated, nil)
+	d.Enqueue(EventUserUpdated, nil)
+	if len(d.queue) != 1 {
+		t.Errorf("expected the second event to be dropped got %v queued", len(d.queue))
+	}
+}